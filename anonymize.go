@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// anonymizeChunkTokens bounds each excerpt the model lists names from, so
+// that names deep into a long episode aren't lost to a truncated prompt.
+const anonymizeChunkTokens = 6000
+
+// anonymizeResponseFormat is the JSON schema of the name listing reply.
+var anonymizeResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "people",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"people": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"names": map[string]any{
+								"type":        "array",
+								"items":       map[string]any{"type": "string"},
+								"description": "Every form the person is named by, exactly as written: full name, first name, surname, nickname",
+							},
+						},
+						"required":             []string{"names"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"people"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// anonymizePrompt asks the model for the people named in an excerpt.
+const anonymizePrompt = `List every real person named in the following podcast transcript excerpt, whether they speak or are only mentioned: the speakers, their guests, and anyone they talk about, including public figures.
+
+Give one entry per person with every form they are named by, exactly as written in the excerpt, e.g. ["Jane Doe", "Jane", "Doe", "JD"]. Don't list organizations, places, products, fictional characters, or speaker labels such as "Speaker 1".
+%s
+Excerpt:
+%s`
+
+// findPeople asks the chat model for the people named in t, in excerpts of
+// anonymizeChunkTokens. Each entry lists the forms one person is named by.
+func (p *Pipeline) findPeople(ctx context.Context, apiKey string, t *Transcript) ([][]string, TokenUsage, error) {
+	var known []string
+	known = append(known, p.config.SpeakerNames...)
+	known = append(known, p.config.Guests...)
+	for _, s := range t.Speakers {
+		if s.Name != "" {
+			known = append(known, s.Name)
+		}
+	}
+	hint := ""
+	if len(known) > 0 {
+		hint = fmt.Sprintf("\nThe people on the show include: %s.\n", strings.Join(known, ", "))
+	}
+
+	var total TokenUsage
+	var people [][]string
+	for _, chunk := range anonymizeChunks(t.Segments) {
+		payload := map[string]any{
+			"model":           p.config.DiarizationModel,
+			"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(anonymizePrompt, hint, formatTimedTurns(chunk))}},
+			"temperature":     p.config.Temperature,
+			"response_format": anonymizeResponseFormat,
+		}
+		content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+		total.Add(usage)
+		if err != nil {
+			return nil, total, fmt.Errorf("failed to find names: %v", err)
+		}
+		var res struct {
+			People []struct {
+				Names []string `json:"names"`
+			} `json:"people"`
+		}
+		if err := json.Unmarshal([]byte(content), &res); err != nil {
+			return nil, total, fmt.Errorf("failed to decode names: %v", err)
+		}
+		for _, person := range res.People {
+			people = append(people, person.Names)
+		}
+	}
+	return people, total, nil
+}
+
+// anonymizeChunks splits turns into excerpts of about anonymizeChunkTokens.
+func anonymizeChunks(turns []Segment) [][]Segment {
+	var chunks [][]Segment
+	start, tokens := 0, 0
+	for i, s := range turns {
+		n := estimateTokens(s.Text)
+		if tokens+n > anonymizeChunkTokens && i > start {
+			chunks = append(chunks, turns[start:i])
+			start, tokens = i, 0
+		}
+		tokens += n
+	}
+	if start < len(turns) {
+		chunks = append(chunks, turns[start:])
+	}
+	return chunks
+}
+
+// pseudonymizer replaces the names of people with consistent pseudonyms.
+type pseudonymizer struct {
+	// forms maps every name form to its person's pseudonym.
+	forms   map[string]string
+	pattern *regexp.Regexp
+	// labels maps speaker labels to their pseudonyms.
+	labels map[string]string
+}
+
+// newPseudonymizer gives the speakers of t "Speaker A", "Speaker B", ... in
+// order of appearance, and everyone else in people "PERSON_1", "PERSON_2", ...
+// A person named as a speaker, or by a form a speaker is named by, is that
+// speaker, so mentions of them match their turns.
+func newPseudonymizer(t *Transcript, people [][]string) *pseudonymizer {
+	z := &pseudonymizer{forms: map[string]string{}, labels: map[string]string{}}
+
+	// Merge the entries of each person across excerpts by the forms they share
+	var groups [][]string
+	byForm := map[string]int{}
+	add := func(names []string) int {
+		g := -1
+		for _, n := range names {
+			if i, ok := byForm[strings.ToLower(n)]; ok {
+				g = i
+				break
+			}
+		}
+		if g < 0 {
+			groups = append(groups, nil)
+			g = len(groups) - 1
+		}
+		for _, n := range names {
+			n = strings.TrimSpace(n)
+			if utf8.RuneCountInString(n) < 2 {
+				continue
+			}
+			if _, ok := byForm[strings.ToLower(n)]; !ok {
+				byForm[strings.ToLower(n)] = g
+				groups[g] = append(groups[g], n)
+			}
+		}
+		return g
+	}
+
+	// Speakers come first, so they keep the speaker pseudonyms
+	named := map[string][]string{}
+	for _, s := range t.Speakers {
+		if s.Name != "" {
+			named[s.Label] = append(named[s.Label], s.Name)
+			named[s.Name] = append(named[s.Name], s.Name)
+		}
+	}
+	pseudonyms := map[int]string{}
+	for i, label := range t.speakers() {
+		pseudonym := "Speaker " + speakerLetters(i)
+		z.labels[label] = pseudonym
+		names := named[label]
+		if !genericSpeaker.MatchString(label) {
+			names = append(names, label)
+		}
+		if len(names) > 0 {
+			if g := add(names); pseudonyms[g] == "" {
+				pseudonyms[g] = pseudonym
+			}
+		}
+	}
+	for _, names := range people {
+		add(names)
+	}
+
+	n := 0
+	for g, names := range groups {
+		pseudonym, ok := pseudonyms[g]
+		if !ok {
+			n++
+			pseudonym = fmt.Sprintf("PERSON_%d", n)
+		}
+		for _, name := range names {
+			z.forms[name] = pseudonym
+			// The parts of a full name refer to the person on their own too
+			for _, part := range strings.Fields(name) {
+				if _, ok := z.forms[part]; !ok && utf8.RuneCountInString(part) > 2 && startsUpper(part) {
+					z.forms[part] = pseudonym
+				}
+			}
+		}
+	}
+	if len(z.forms) == 0 {
+		return z
+	}
+	forms := make([]string, 0, len(z.forms))
+	for f := range z.forms {
+		forms = append(forms, regexp.QuoteMeta(f))
+	}
+	// Longest first, so a full name wins over its parts
+	sort.Slice(forms, func(i, j int) bool {
+		if len(forms[i]) != len(forms[j]) {
+			return len(forms[i]) > len(forms[j])
+		}
+		return forms[i] < forms[j]
+	})
+	z.pattern = regexp.MustCompile(strings.Join(forms, "|"))
+	return z
+}
+
+// speakerLetters numbers speakers A to Z, then AA, AB, ...
+func speakerLetters(i int) string {
+	s := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('A'+(i-1)%26)) + s
+	}
+	return s
+}
+
+// startsUpper reports whether s starts with an upper-case letter.
+func startsUpper(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsUpper(r)
+}
+
+// replace writes the pseudonyms in place of whole-word names in s, and
+// returns the result and the number of names replaced.
+func (z *pseudonymizer) replace(s string) (string, int) {
+	if z.pattern == nil || s == "" {
+		return s, 0
+	}
+	var b strings.Builder
+	n, last, prev := 0, 0, ""
+	for _, m := range z.pattern.FindAllStringIndex(s, -1) {
+		before, _ := utf8.DecodeLastRuneInString(s[:m[0]])
+		after, _ := utf8.DecodeRuneInString(s[m[1]:])
+		if m[0] > 0 && isWordRune(before) || m[1] < len(s) && isWordRune(after) {
+			continue
+		}
+		pseudonym := z.forms[s[m[0]:m[1]]]
+		gap := s[last:m[0]]
+		if prev == pseudonym && strings.TrimSpace(gap) == "" {
+			// "Jane Doe" as two matched parts is still one person
+			last = m[1]
+			continue
+		}
+		b.WriteString(gap)
+		b.WriteString(pseudonym)
+		n++
+		last, prev = m[1], pseudonym
+	}
+	if n == 0 {
+		return s, 0
+	}
+	b.WriteString(s[last:])
+	return b.String(), n
+}
+
+// isWordRune reports whether r can be part of a name.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// anonymize replaces speakers and the names of people in t, its turns, words,
+// translations, speaker info and metadata, and returns how many names were
+// replaced in the text.
+func (z *pseudonymizer) anonymize(t *Transcript) int {
+	count := 0
+	text := func(s *string) {
+		var n int
+		*s, n = z.replace(*s)
+		count += n
+	}
+	for i := range t.Segments {
+		s := &t.Segments[i]
+		if pseudonym, ok := z.labels[s.Speaker]; ok {
+			s.Speaker = pseudonym
+		}
+		text(&s.Text)
+		for lang, tr := range s.Translations {
+			s.Translations[lang], _ = z.replace(tr)
+		}
+		s.Words = z.anonymizeWords(s.Words)
+	}
+	for i, info := range t.Speakers {
+		label := z.labels[info.Label]
+		if label == "" {
+			label = z.labels[info.Name]
+		}
+		if label == "" {
+			label, _ = z.replace(info.Label)
+		}
+		t.Speakers[i] = SpeakerInfo{Label: label, Role: info.Role}
+	}
+	for _, s := range []*string{&t.Title, &t.Description, &t.Summary, &t.Audio} {
+		*s, _ = z.replace(*s)
+	}
+	t.Text, _ = z.replace(t.Text)
+	if t.Podcast != nil {
+		t.Podcast.Episode, _ = z.replace(t.Podcast.Episode)
+	}
+	for i := range t.Chapters {
+		c := &t.Chapters[i]
+		c.Headline, _ = z.replace(c.Headline)
+		c.Gist, _ = z.replace(c.Gist)
+		c.Summary, _ = z.replace(c.Summary)
+	}
+	for i, e := range t.Entities {
+		replaced, n := z.replace(e.Text)
+		if n == 0 && strings.Contains(strings.ToLower(e.Type), "person") {
+			// A person the model missed
+			replaced = "PERSON"
+		}
+		t.Entities[i].Text = replaced
+	}
+	return count
+}
+
+// anonymizeWords replaces names in word timings. The words of a name made of
+// several become one, spanning them all.
+func (z *pseudonymizer) anonymizeWords(words []Word) []Word {
+	out := words[:0]
+	prev := ""
+	for _, w := range words {
+		core := strings.TrimFunc(w.Text, func(r rune) bool { return !isWordRune(r) })
+		pseudonym, ok := z.forms[core]
+		if !ok {
+			// A possessive or a name with punctuation attached
+			if replaced, n := z.replace(w.Text); n > 0 {
+				w.Text = replaced
+			}
+			out = append(out, w)
+			prev = ""
+			continue
+		}
+		if pseudonym == prev && len(out) > 0 {
+			// Keep the punctuation after the last word of the name
+			last := &out[len(out)-1]
+			last.End = w.End
+			last.Text = last.Text[:strings.LastIndex(last.Text, pseudonym)+len(pseudonym)] + w.Text[strings.Index(w.Text, core)+len(core):]
+			continue
+		}
+		w.Text = strings.Replace(w.Text, core, pseudonym, 1)
+		out = append(out, w)
+		prev = pseudonym
+	}
+	return out
+}
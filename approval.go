@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// approvalVariant names the files of the approval copy, diarized.approval.<ext>,
+// written beside the full outputs.
+const approvalVariant = "approval"
+
+// withholding is what the approval copy leaves out: the turns of some
+// speakers, and off-record stretches from a cue file.
+type withholding struct {
+	// speakers are lower-case names or labels.
+	speakers map[string]bool
+	ranges   []timeRange
+	// collapse replaces each run of withheld turns with a single marker
+	// instead of masking every turn's text.
+	collapse bool
+}
+
+// parseWithholding reads the -withhold-speakers list, the -off-record cue
+// file and the -withhold-style, returning nil if nothing is withheld.
+func parseWithholding(speakers, offRecord, style string) (*withholding, error) {
+	if style != "mask" && style != "collapse" {
+		return nil, fmt.Errorf("unknown -withhold-style %q (available: mask, collapse)", style)
+	}
+	w := &withholding{speakers: map[string]bool{}, collapse: style == "collapse"}
+	for _, s := range strings.Split(speakers, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			w.speakers[strings.ToLower(s)] = true
+		}
+	}
+	if offRecord != "" {
+		ranges, err := loadCueFile(offRecord)
+		if err != nil {
+			return nil, fmt.Errorf("-off-record: %v", err)
+		}
+		w.ranges = ranges
+	}
+	if len(w.speakers) == 0 && len(w.ranges) == 0 {
+		return nil, nil
+	}
+	return w, nil
+}
+
+// marker is the text that stands in for a withheld turn: the label of the
+// off-record range it falls in, or "withheld".
+func (w *withholding) marker(s Segment) (string, bool) {
+	for _, r := range w.ranges {
+		if r.overlaps(s.Start, s.End) {
+			return "[" + firstNonEmpty(r.Label, "off the record") + "]", true
+		}
+	}
+	if w.speakers[strings.ToLower(s.Speaker)] || w.speakers[strings.ToLower(s.speakerLabel())] {
+		return "[withheld]", true
+	}
+	return "", false
+}
+
+// approvalCopy returns a copy of t for guests to approve or for publishing
+// under embargo, with the withheld turns masked or collapsed, and the number
+// of turns withheld. A turn that only partly overlaps an off-record range is
+// withheld whole. The episode summary and the summaries of chapters reaching
+// into withheld turns are dropped, as are entities mentioned in them, since
+// they may give away what was said.
+func (t *Transcript) approvalCopy(w *withholding) (*Transcript, int) {
+	c := *t
+	c.Segments = make([]Segment, 0, len(t.Segments))
+	var withheld []timeRange
+	n := 0
+	// previous is whether the turn before was withheld
+	previous := false
+	for _, s := range t.Segments {
+		text, ok := w.marker(s)
+		if !ok {
+			c.Segments = append(c.Segments, s)
+			previous = false
+			continue
+		}
+		n++
+		withheld = append(withheld, timeRange{Start: s.Start, End: s.End})
+		if last := len(c.Segments) - 1; w.collapse && previous && c.Segments[last].Text == text {
+			// One marker for the whole run, attributed only if one speaker
+			// had it all
+			if c.Segments[last].Speaker != s.Speaker {
+				c.Segments[last].Speaker = ""
+			}
+			c.Segments[last].End = s.End
+			continue
+		}
+		masked := Segment{Start: s.Start, End: s.End, Speaker: s.Speaker, Text: text, Paragraph: s.Paragraph}
+		if w.collapse {
+			masked.Kind = eventKind
+		}
+		c.Segments = append(c.Segments, masked)
+		previous = true
+	}
+	for i := range c.Segments {
+		c.Segments[i].ID = i
+	}
+	c.Text = joinSegmentText(c.Segments)
+	c.Summary = ""
+	c.Chapters = nil
+	for _, ch := range t.Chapters {
+		for _, r := range withheld {
+			if r.overlaps(ch.Start, ch.End) {
+				ch.Gist, ch.Summary = "", ""
+				break
+			}
+		}
+		c.Chapters = append(c.Chapters, ch)
+	}
+	c.Entities = nil
+	for _, e := range t.Entities {
+		hidden := false
+		for _, r := range withheld {
+			if r.overlaps(e.Start, max(e.End, e.Start+0.001)) {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			c.Entities = append(c.Entities, e)
+		}
+	}
+	return &c, n
+}
+
+// exportApproval writes the approval copy of t in every format and returns
+// the paths written.
+func (p *Pipeline) exportApproval(t *Transcript, w *withholding, formats []string) ([]string, error) {
+	approval, n := t.approvalCopy(w)
+	paths, err := p.exportAll(approval, formats, approvalVariant)
+	if err != nil {
+		return nil, err
+	}
+	p.console.progressf("Wrote an approval copy withholding %d turn(s)\n", n)
+	return paths, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveVersion is the layout version of archive bundles.
+const archiveVersion = 1
+
+// Names of the index entries every bundle starts with.
+const (
+	archiveIndexName = "archive.json"
+	archiveSumsName  = "SHA256SUMS"
+)
+
+// signatureExts are detached signatures archived alongside an episode's
+// outputs when they're in its directory.
+var signatureExts = []string{".sig", ".minisig", ".asc"}
+
+// archiveIndex is archive.json: what the bundle holds and the digest of every
+// file in it.
+type archiveIndex struct {
+	Version         int           `json:"version"`
+	Title           string        `json:"title,omitempty"`
+	Date            string        `json:"date,omitempty"`
+	ProcessedAt     time.Time     `json:"processed_at"`
+	SoftwareVersion string        `json:"software_version"`
+	Audio           archiveAudio  `json:"audio"`
+	Files           []archiveFile `json:"files"`
+}
+
+// archiveAudio identifies the audio the episode was transcribed from. The
+// audio itself is only in the bundle when -audio is given.
+type archiveAudio struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	File   string `json:"file,omitempty"`
+}
+
+// archiveFile is one file of the bundle.
+type archiveFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// path is where the file is read from.
+	path string
+}
+
+// runArchive implements the archive command.
+func runArchive(args []string) error {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	out := flags.String("out", "archive", "Directory the bundles are written to")
+	audio := flags.String("audio", "", "Include this audio file, which must be the one the episode was transcribed from (one episode only)")
+	verify := flags.Bool("verify", false, "Check the given bundles against their checksums instead of creating any")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription archive [-out dir] [-audio file] episode-dir...\n       podcast-transcription archive -verify bundle.tar...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no episode directories or bundles given")
+	}
+	if *verify {
+		failed := 0
+		for _, path := range flags.Args() {
+			index, err := verifyArchive(path)
+			if err != nil {
+				failed++
+				fmt.Printf("%s: FAILED: %v\n", path, err)
+				continue
+			}
+			fmt.Printf("%s: OK, %d file(s), audio sha256 %s\n", path, len(index.Files), index.Audio.SHA256)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d bundle(s) failed verification", failed, flags.NArg())
+		}
+		return nil
+	}
+	if *audio != "" && flags.NArg() > 1 {
+		return errors.New("-audio can only be given with a single episode directory")
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %v", err)
+	}
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	for _, dir := range flags.Args() {
+		path, existed, err := archiveEpisode(key, dir, *audio, *out)
+		if err != nil {
+			return fmt.Errorf("%s: %v", dir, err)
+		}
+		if existed {
+			fmt.Printf("%s: already archived as %s\n", dir, path)
+			continue
+		}
+		fmt.Printf("%s: archived as %s\n", dir, path)
+	}
+	return nil
+}
+
+// archiveEpisode bundles the outputs of the episode processed into dir, with
+// the audio at audioPath if it's given, and writes the bundle to out named by
+// its SHA-256. The bundle is made read-only; an identical bundle already there
+// is left as it is.
+func archiveEpisode(key []byte, dir, audioPath, out string) (string, bool, error) {
+	defaults := defaultConfig()
+	manifestPath := filepath.Join(dir, filepath.Base(defaults.ManifestFile))
+	data, err := readStored(key, manifestPath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", false, fmt.Errorf("failed to parse %s: %v", manifestPath, err)
+	}
+	if m.Input.SHA256 == "" {
+		return "", false, fmt.Errorf("%s records no input audio", manifestPath)
+	}
+	index := &archiveIndex{
+		Version:         archiveVersion,
+		ProcessedAt:     m.FinishedAt,
+		SoftwareVersion: m.SoftwareVersion,
+		Audio:           archiveAudio{Name: filepath.Base(m.Input.Path), Size: m.Input.Size, SHA256: m.Input.SHA256},
+	}
+	if t, err := loadTranscript(key, filepath.Join(dir, filepath.Base(defaults.DiarizedJSONFile))); err == nil {
+		index.Title, index.Date = episodeTitle(t, filepath.Base(absDir(dir))), t.Date
+	}
+
+	files, err := episodeFiles(dir, m.Outputs)
+	if err != nil {
+		return "", false, err
+	}
+	if audioPath != "" {
+		size, sum, err := hashFile(audioPath)
+		if err != nil {
+			return "", false, err
+		}
+		if sum != m.Input.SHA256 {
+			return "", false, fmt.Errorf("%s is not the audio the episode was transcribed from (sha256 %s, want %s)", audioPath, sum, m.Input.SHA256)
+		}
+		index.Audio.File = "audio/" + index.Audio.Name
+		files = append(files, archiveFile{Name: index.Audio.File, Size: size, SHA256: sum, path: audioPath})
+	}
+	for i := range files {
+		if files[i].SHA256 != "" {
+			continue
+		}
+		if files[i].Size, files[i].SHA256, err = hashFile(files[i].path); err != nil {
+			return "", false, err
+		}
+	}
+	index.Files = files
+	return writeArchive(index, out)
+}
+
+// episodeFiles returns the files of an episode to archive: its outputs as
+// listed in the manifest, and the detached signatures next to them. Outputs
+// are found relative to the manifest, so a moved directory still archives.
+func episodeFiles(dir string, outputs []string) ([]archiveFile, error) {
+	if len(outputs) == 0 {
+		return nil, errors.New("the manifest lists no outputs")
+	}
+	// The manifest is the last output, so its directory is the run's output directory
+	base := filepath.Dir(outputs[len(outputs)-1])
+	seen := map[string]bool{}
+	var files []archiveFile
+	add := func(name, path string) {
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, archiveFile{Name: name, path: path})
+		}
+	}
+	for _, output := range outputs {
+		rel, err := filepath.Rel(base, output)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("output %s is outside the episode directory", output)
+		}
+		path := filepath.Join(dir, rel)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("output missing: %v", err)
+		}
+		add(filepath.ToSlash(rel), path)
+		for _, ext := range signatureExts {
+			if _, err := os.Stat(path + ext); err == nil {
+				add(filepath.ToSlash(rel)+ext, path+ext)
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// writeArchive writes the bundle of index to a temporary file in out, then
+// names it after its SHA-256. Entries are in a fixed order with the time the
+// episode was processed, so the same files always make the same bundle.
+func writeArchive(index *archiveIndex, out string) (string, bool, error) {
+	indexData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", false, err
+	}
+	var sums strings.Builder
+	for _, f := range index.Files {
+		fmt.Fprintf(&sums, "%s  %s\n", f.SHA256, f.Name)
+	}
+
+	tmp, err := os.CreateTemp(out, ".archive-*.tar")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create bundle: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(tmp, h))
+	entry := func(name string, size int64, body io.Reader) error {
+		hdr := &tar.Header{Name: name, Mode: 0444, Size: size, ModTime: index.ProcessedAt, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if n, err := io.Copy(tw, body); err != nil {
+			return err
+		} else if n != size {
+			return fmt.Errorf("%s changed while being archived", name)
+		}
+		return nil
+	}
+	if err := entry(archiveIndexName, int64(len(indexData)+1), bytes.NewReader(append(indexData, '\n'))); err != nil {
+		return "", false, fmt.Errorf("failed to write bundle: %v", err)
+	}
+	if err := entry(archiveSumsName, int64(sums.Len()), strings.NewReader(sums.String())); err != nil {
+		return "", false, fmt.Errorf("failed to write bundle: %v", err)
+	}
+	for _, f := range index.Files {
+		r, err := os.Open(f.path)
+		if err != nil {
+			return "", false, err
+		}
+		err = entry(f.Name, f.Size, r)
+		r.Close()
+		if err != nil {
+			return "", false, fmt.Errorf("failed to write bundle: %v", err)
+		}
+	}
+	err = tw.Close()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to write bundle: %v", err)
+	}
+
+	path := filepath.Join(out, "sha256-"+hex.EncodeToString(h.Sum(nil))+".tar")
+	if _, err := os.Stat(path); err == nil {
+		return path, true, nil
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return "", false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", false, fmt.Errorf("failed to write bundle: %v", err)
+	}
+	return path, false, nil
+}
+
+// verifyArchive checks that the bundle at path still has the digest it's named
+// after and that every file in it matches archive.json and SHA256SUMS.
+func verifyArchive(path string) (*archiveIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	tr := tar.NewReader(io.TeeReader(f, h))
+	var index *archiveIndex
+	var sums string
+	digests := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a readable bundle: %v", err)
+		}
+		switch hdr.Name {
+		case archiveIndexName:
+			index = &archiveIndex{}
+			if err := json.NewDecoder(tr).Decode(index); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", archiveIndexName, err)
+			}
+		case archiveSumsName:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			sums = string(data)
+		default:
+			fh := sha256.New()
+			if _, err := io.Copy(fh, tr); err != nil {
+				return nil, err
+			}
+			digests[hdr.Name] = hex.EncodeToString(fh.Sum(nil))
+		}
+	}
+	// Read the tar's end-of-archive padding into the digest as well
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		return nil, err
+	}
+	if index == nil {
+		return nil, fmt.Errorf("no %s in the bundle", archiveIndexName)
+	}
+	if name := filepath.Base(path); strings.HasPrefix(name, "sha256-") {
+		if want := strings.TrimSuffix(strings.TrimPrefix(name, "sha256-"), ".tar"); want != hex.EncodeToString(h.Sum(nil)) {
+			return nil, errors.New("the bundle's digest doesn't match its name")
+		}
+	}
+	var want strings.Builder
+	for _, file := range index.Files {
+		got, ok := digests[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing", file.Name)
+		}
+		if got != file.SHA256 {
+			return nil, fmt.Errorf("%s has been modified", file.Name)
+		}
+		delete(digests, file.Name)
+		fmt.Fprintf(&want, "%s  %s\n", file.SHA256, file.Name)
+	}
+	for name := range digests {
+		return nil, fmt.Errorf("%s is not listed in %s", name, archiveIndexName)
+	}
+	if sums != want.String() {
+		return nil, fmt.Errorf("%s doesn't match %s", archiveSumsName, archiveIndexName)
+	}
+	return index, nil
+}
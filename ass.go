@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// assPalette colors the speakers of Advanced SubStation subtitles in order of
+// appearance, chosen to stay readable with a black outline on video.
+var assPalette = []string{"#ffffff", "#ffd400", "#4fd1ff", "#8cff66", "#ff8ad8", "#ffa64d", "#b9a3ff", "#66ffd9"}
+
+// assAlignments are the SubStation numpad alignments of the -ass-style
+// positions.
+var assAlignments = map[string]int{
+	"left":      1,
+	"center":    2,
+	"right":     3,
+	"top-left":  7,
+	"top":       8,
+	"top-right": 9,
+}
+
+// assStyle is the look of one speaker's lines.
+type assStyle struct {
+	color     string
+	alignment int
+}
+
+// parseASSStyles reads -ass-style: comma-separated Speaker=#RRGGBB@position
+// entries, where either the color or the position may be left out.
+func parseASSStyles(s string) (map[string]assStyle, error) {
+	styles := map[string]assStyle{}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid -ass-style entry %q (want Speaker=#RRGGBB@position)", entry)
+		}
+		color, position, _ := strings.Cut(strings.TrimSpace(spec), "@")
+		var style assStyle
+		if color != "" {
+			if _, err := assColor(color); err != nil {
+				return nil, fmt.Errorf("invalid color %q for %s in -ass-style (want #RRGGBB)", color, name)
+			}
+			style.color = color
+		}
+		if position != "" {
+			a, ok := assAlignments[strings.ToLower(position)]
+			if !ok {
+				return nil, fmt.Errorf("unknown position %q for %s in -ass-style (available: left, center, right, top-left, top, top-right)", position, name)
+			}
+			style.alignment = a
+		}
+		styles[name] = style
+	}
+	return styles, nil
+}
+
+// assColor converts #RRGGBB into SubStation's &H00BBGGRR.
+func assColor(hex string) (string, error) {
+	rgb, ok := strings.CutPrefix(hex, "#")
+	if !ok || len(rgb) != 6 {
+		return "", fmt.Errorf("invalid color %q", hex)
+	}
+	if _, err := strconv.ParseUint(rgb, 16, 32); err != nil {
+		return "", fmt.Errorf("invalid color %q", hex)
+	}
+	rgb = strings.ToUpper(rgb)
+	return "&H00" + rgb[4:6] + rgb[2:4] + rgb[0:2], nil
+}
+
+// assName makes a speaker label usable as a style or actor name, which
+// SubStation separates with commas.
+func assName(s string) string {
+	return strings.ReplaceAll(s, ",", ";")
+}
+
+// assEscaper keeps transcript text from being read as override tags, which
+// SubStation has no way to escape.
+var assEscaper = strings.NewReplacer("{", "(", "}", ")", `\`, "/", "\n", `\N`)
+
+// renderASS writes Advanced SubStation Alpha subtitles with a style per
+// speaker. Speakers get the colors of assPalette in order of appearance; the
+// first two stand bottom left and bottom right and any more bottom center,
+// unless -ass-style places them. Sound events use the Default style.
+func renderASS(t *Transcript, opts renderOptions) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("[Script Info]\n; Written by podcast-transcription\n")
+	if t.Title != "" {
+		fmt.Fprintf(&b, "Title: %s\n", strings.ReplaceAll(t.Title, "\n", " "))
+	}
+	b.WriteString("ScriptType: v4.00+\nPlayResX: 1920\nPlayResY: 1080\nWrapStyle: 0\nScaledBorderAndShadow: yes\n\n")
+
+	b.WriteString("[V4+ Styles]\n")
+	b.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	writeStyle := func(name, color string, alignment int) {
+		primary, _ := assColor(color)
+		fmt.Fprintf(&b, "Style: %s,Arial,64,%s,&H000000FF,&H00000000,&H80000000,-1,0,0,0,100,100,0,0,1,3,1,%d,80,80,60,1\n", name, primary, alignment)
+	}
+	writeStyle("Default", assPalette[0], 2)
+	speakers := t.speakers()
+	for i, name := range speakers {
+		style := assStyle{color: assPalette[i%len(assPalette)], alignment: 2}
+		if len(speakers) > 1 && i < 2 {
+			style.alignment = 1 + 2*i
+		}
+		if custom, ok := opts.assStyles[name]; ok {
+			if custom.color != "" {
+				style.color = custom.color
+			}
+			if custom.alignment != 0 {
+				style.alignment = custom.alignment
+			}
+		}
+		writeStyle(assName(name), style.color, style.alignment)
+	}
+
+	b.WriteString("\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	for _, s := range t.Segments {
+		style := "Default"
+		if s.Speaker != "" {
+			style = assName(s.Speaker)
+		}
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,%s,%s,0,0,0,,%s\n", assTimestamp(s.Start), assTimestamp(max(s.End, s.Start)), style, assName(s.speakerLabel()), assEscaper.Replace(s.displayText()))
+	}
+	return []byte(b.String()), nil
+}
+
+// assTimestamp formats seconds as SubStation's H:MM:SS.cc.
+func assTimestamp(seconds float64) string {
+	cs := int64(max(seconds, 0)*100 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
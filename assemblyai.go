@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// assemblyTranscript is the subset of AssemblyAI's transcript resource that is used.
+// All times are in milliseconds.
+type assemblyTranscript struct {
+	ID            string  `json:"id"`
+	Status        string  `json:"status"`
+	Error         string  `json:"error"`
+	Text          string  `json:"text"`
+	LanguageCode  string  `json:"language_code"`
+	AudioDuration float64 `json:"audio_duration"`
+	Utterances    []struct {
+		Speaker string `json:"speaker"`
+		Start   int64  `json:"start"`
+		End     int64  `json:"end"`
+		Text    string `json:"text"`
+		Words   []struct {
+			Text       string  `json:"text"`
+			Start      int64   `json:"start"`
+			End        int64   `json:"end"`
+			Confidence float64 `json:"confidence"`
+		} `json:"words"`
+	} `json:"utterances"`
+	Chapters []struct {
+		Gist     string `json:"gist"`
+		Headline string `json:"headline"`
+		Summary  string `json:"summary"`
+		Start    int64  `json:"start"`
+		End      int64  `json:"end"`
+	} `json:"chapters"`
+	Entities []struct {
+		EntityType string `json:"entity_type"`
+		Text       string `json:"text"`
+		Start      int64  `json:"start"`
+		End        int64  `json:"end"`
+	} `json:"entities"`
+}
+
+// transcribeAssemblyAI uploads the audio, submits a transcript job with speaker
+// labels, chapters and entity detection, and polls until it finishes.
+func (p *Pipeline) transcribeAssemblyAI(ctx context.Context, apiKey, audioPath string) (*Transcript, error) {
+	uploadURL, err := p.assemblyUpload(ctx, apiKey, audioPath)
+	if err != nil {
+		return nil, err
+	}
+
+	job := map[string]interface{}{
+		"audio_url":          uploadURL,
+		"speech_model":       p.config.TranscriptionModel,
+		"speaker_labels":     true,
+		"auto_chapters":      true,
+		"entity_detection":   true,
+		"language_detection": true,
+	}
+	if p.config.Speakers > 0 {
+		job["speakers_expected"] = p.config.Speakers
+	}
+	if p.config.Verbatim {
+		job["disfluencies"] = true
+	}
+	if len(p.config.Vocabulary) > 0 {
+		job["word_boost"] = p.config.Vocabulary
+	}
+	var res assemblyTranscript
+	if err := p.assemblyRequest(ctx, apiKey, "POST", "/transcript", job, &res); err != nil {
+		return nil, err
+	}
+
+	for res.Status != "completed" {
+		if res.Status == "error" {
+			return nil, fmt.Errorf("AssemblyAI transcript %s failed: %s", res.ID, res.Error)
+		}
+		if err := waitPoll(ctx, "AssemblyAI transcript "+res.ID); err != nil {
+			return nil, err
+		}
+		if err := p.assemblyRequest(ctx, apiKey, "GET", "/transcript/"+res.ID, nil, &res); err != nil {
+			return nil, err
+		}
+	}
+	return res.transcript(filepath.Base(audioPath)), nil
+}
+
+// assemblyUpload streams the audio file to AssemblyAI's upload endpoint and
+// returns the private URL it is stored under. An upload whose connection
+// drops is sent again.
+func (p *Pipeline) assemblyUpload(ctx context.Context, apiKey, audioPath string) (string, error) {
+	fileInfo, err := os.Stat(audioPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info: %v", err)
+	}
+	var res struct {
+		UploadURL string `json:"upload_url"`
+	}
+	err = p.retryCorrupt(ctx, func() error {
+		file, err := os.Open(audioPath)
+		if err != nil {
+			return fmt.Errorf("failed to open audio file: %v", err)
+		}
+		defer file.Close()
+		req, err := http.NewRequestWithContext(ctx, "POST", p.config.AssemblyAIURL+"/upload", file)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.ContentLength = fileInfo.Size()
+		req.Header.Set("Authorization", apiKey)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return p.assemblyDo(req, &res)
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.UploadURL, nil
+}
+
+// assemblyRequest sends a JSON API request and decodes the reply into out.
+func (p *Pipeline) assemblyRequest(ctx context.Context, apiKey, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.config.AssemblyAIURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", apiKey)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return p.assemblyDo(req, out)
+}
+
+func (p *Pipeline) assemblyDo(req *http.Request, out interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if isDropped(err) {
+			return &droppedTransfer{"request to AssemblyAI", err}
+		}
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return fmt.Errorf("non-200 response from AssemblyAI: %d, body: %s%s", resp.StatusCode, string(body), requestRef(resp))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode AssemblyAI response: %v", err)
+	}
+	return nil
+}
+
+// transcript converts a completed AssemblyAI transcript into the canonical model.
+func (res *assemblyTranscript) transcript(audio string) *Transcript {
+	t := &Transcript{
+		Audio:    audio,
+		Language: res.LanguageCode,
+		Duration: res.AudioDuration,
+		Text:     res.Text,
+	}
+	var segments []Segment
+	for _, u := range res.Utterances {
+		s := Segment{
+			Start:   msToSeconds(u.Start),
+			End:     msToSeconds(u.End),
+			Speaker: "Speaker " + u.Speaker,
+			Text:    u.Text,
+		}
+		for _, w := range u.Words {
+			s.Words = append(s.Words, Word{Text: w.Text, Start: msToSeconds(w.Start), End: msToSeconds(w.End), Confidence: w.Confidence})
+		}
+		segments = append(segments, s)
+	}
+	t.Segments = mergeSpeakerTurns(segments)
+	for _, c := range res.Chapters {
+		t.Chapters = append(t.Chapters, Chapter{
+			Start:    msToSeconds(c.Start),
+			End:      msToSeconds(c.End),
+			Headline: c.Headline,
+			Gist:     c.Gist,
+			Summary:  c.Summary,
+		})
+	}
+	for _, e := range res.Entities {
+		t.Entities = append(t.Entities, Entity{
+			Type:  e.EntityType,
+			Text:  e.Text,
+			Start: msToSeconds(e.Start),
+			End:   msToSeconds(e.End),
+		})
+	}
+	return t
+}
+
+func msToSeconds(ms int64) float64 {
+	return float64(ms) / 1000
+}
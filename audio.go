@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// probeDuration returns the duration of the audio file in seconds using
+// ffprobe, or where it isn't installed, by reading the file itself.
+func probeDuration(path string) (float64, error) {
+	if !haveTool("ffprobe") {
+		return nativeDuration(path)
+	}
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	d, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe duration: %v", err)
+	}
+	return d, nil
+}
+
+// cutAudio copies the audio from start seconds to end seconds (0 meaning the end
+// of the file) into a temporary file of the same format using ffmpeg, without
+// re-encoding, or where it isn't installed, with nativeCut. The caller removes
+// the file with the returned cleanup function.
+func (p *Pipeline) cutAudio(ctx context.Context, path string, start, end float64) (string, func(), error) {
+	dir, err := p.makeTempDir("cut")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out := filepath.Join(dir, "cut"+filepath.Ext(path))
+
+	args := []string{"-v", "error", "-y", "-ss", strconv.FormatFloat(start, 'f', 3, 64)}
+	if end > 0 {
+		args = append(args, "-to", strconv.FormatFloat(end, 'f', 3, 64))
+	}
+	args = append(args, "-i", path, "-c", "copy", out)
+	native := !haveTool("ffmpeg")
+	err = p.retryCorrupt(ctx, func() error {
+		if native {
+			if err := nativeCut(path, out, start, end); err != nil {
+				return err
+			}
+			return checkCut(path, out, start, end)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		// A full disk or a dying ffmpeg can leave a short file behind
+		return checkCut(path, out, start, end)
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return out, cleanup, nil
+}
+
+// silenceThreshold and minSilence tune ffmpeg's silencedetect filter: quieter
+// than -45 dB for at least half a second counts as silence.
+const (
+	silenceThreshold = "-45dB"
+	minSilence       = 0.5
+)
+
+// silenceLine matches the silence_start and silence_end lines silencedetect
+// logs.
+var silenceLine = regexp.MustCompile(`silence_(start|end): (-?[0-9.]+)`)
+
+// speechBounds finds where speech starts and ends in the audio between start
+// and end seconds, relative to start, with ffmpeg's silencedetect. silent is set
+// when there is no speech at all.
+func speechBounds(ctx context.Context, path string, start, end float64) (from, to float64, silent bool, err error) {
+	args := []string{"-hide_banner", "-nostats", "-ss", strconv.FormatFloat(start, 'f', 3, 64), "-to", strconv.FormatFloat(end, 'f', 3, 64),
+		"-i", path, "-af", fmt.Sprintf("silencedetect=noise=%s:d=%g", silenceThreshold, minSilence), "-f", "null", "-"}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, 0, false, fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	length := end - start
+	from, to = 0, length
+	open := -1.0 // start of a silence that hasn't ended
+	for _, m := range silenceLine.FindAllStringSubmatch(stderr.String(), -1) {
+		v, _ := strconv.ParseFloat(m[2], 64)
+		if m[1] == "start" {
+			open = max(v, 0)
+			continue
+		}
+		switch {
+		case open >= 0 && open < 0.05:
+			// Leading silence
+			from = v
+		case open >= 0 && v >= length-0.05:
+			// Trailing silence, which newer ffmpeg versions close at the end
+			to = open
+		}
+		open = -1
+	}
+	if open >= 0 {
+		// Trailing silence runs to the end
+		if open < 0.05 {
+			return 0, 0, true, nil
+		}
+		to = open
+	}
+	if to-from < minSilence {
+		return 0, 0, true, nil
+	}
+	return from, min(to, length), false, nil
+}
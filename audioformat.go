@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// whisperFormats are the file types the Whisper API accepts, by extension.
+var whisperFormats = map[string]bool{
+	"flac": true, "m4a": true, "mp3": true, "mp4": true, "mpeg": true,
+	"mpga": true, "oga": true, "ogg": true, "wav": true, "webm": true,
+}
+
+// sniffAudioFormat identifies the container of the audio file from its first
+// bytes. It returns the extension Whisper knows the format by, or a descriptive
+// name such as "aiff" or "wma" for formats it doesn't accept, or "" if unknown.
+func sniffAudioFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer f.Close()
+	head := make([]byte, 64)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read audio file: %v", err)
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "mp3", nil
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync; ADTS AAC uses the same sync with layer bits 00
+		if head[1]&0x06 == 0 {
+			return "aac", nil
+		}
+		return "mp3", nil
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "flac", nil
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return "ogg", nil
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if bytes.Contains(head, []byte("webm")) {
+			return "webm", nil
+		}
+		return "matroska", nil
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		if wavCodec(head) != 1 && wavCodec(head) != 3 {
+			// Only PCM and float WAV are safe; ADPCM, GSM etc. are not
+			return "compressed wav", nil
+		}
+		return "wav", nil
+	case len(head) >= 12 && string(head[:4]) == "FORM" && (string(head[8:12]) == "AIFF" || string(head[8:12]) == "AIFC"):
+		return "aiff", nil
+	case bytes.HasPrefix(head, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}):
+		return "wma", nil
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		brand := string(head[8:12])
+		if strings.HasPrefix(brand, "3g") {
+			return "3gp", nil
+		}
+		if strings.HasPrefix(brand, "M4A") || strings.HasPrefix(brand, "M4B") {
+			return "m4a", nil
+		}
+		return "mp4", nil
+	}
+	return "", nil
+}
+
+// wavCodec returns the format tag of the WAV "fmt " chunk when it is the first
+// chunk, or 0.
+func wavCodec(head []byte) uint16 {
+	if len(head) < 22 || string(head[12:16]) != "fmt " {
+		return 0
+	}
+	tag := binary.LittleEndian.Uint16(head[20:22])
+	if tag == 0xFFFE && len(head) >= 46 {
+		// WAVE_FORMAT_EXTENSIBLE: the real codec is the first two bytes of the sub-format GUID
+		return binary.LittleEndian.Uint16(head[44:46])
+	}
+	return tag
+}
+
+// prepareForWhisper returns the file to upload to Whisper and the file name to
+// upload it under. Audio in a format Whisper doesn't accept is transcoded to
+// mono 16 kHz MP3 with ffmpeg; accepted audio with a misleading extension is
+// uploaded under the right one. The caller runs cleanup when done.
+func (p *Pipeline) prepareForWhisper(ctx context.Context, audioPath string) (path, name string, cleanup func(), err error) {
+	noop := func() {}
+	format, err := sniffAudioFormat(audioPath)
+	if err != nil {
+		return "", "", nil, err
+	}
+	base := strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath))
+	if whisperFormats[format] {
+		return audioPath, base + "." + format, noop, nil
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(audioPath)), ".")
+	if format == "" && whisperFormats[ext] {
+		// Unrecognised content with an accepted extension; let the API decide
+		return audioPath, filepath.Base(audioPath), noop, nil
+	}
+
+	if format == "" {
+		format = "unrecognized"
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return "", "", nil, fmt.Errorf("%s audio is not accepted by Whisper and ffmpeg, needed to convert it, is not installed", format)
+	}
+	p.console.progressf("Converting %s audio to MP3 for Whisper\n", format)
+	converted, cleanup, err := p.transcodeAudio(ctx, audioPath)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to convert %s audio: %v", format, err)
+	}
+	return converted, base + ".mp3", cleanup, nil
+}
+
+// transcodeAudio converts the audio to mono 16 kHz MP3, the sample rate Whisper
+// resamples to anyway, in a temporary file.
+func (p *Pipeline) transcodeAudio(ctx context.Context, path string) (string, func(), error) {
+	dir, err := p.makeTempDir("convert")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out := filepath.Join(dir, "audio.mp3")
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y", "-i", path, "-vn", "-ac", "1", "-ar", "16000", "-b:a", "64k", out)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, cleanup, nil
+}
+
+// fitHeadroom leaves room under the size limit for container overhead and
+// encoder overshoot.
+const fitHeadroom = 0.93
+
+// shrinkToFit re-encodes audio that exceeds limit bytes at the bitrate that fits,
+// as Opus (which holds up well for speech at low bitrates) or, if ffmpeg lacks
+// an Opus encoder, MP3. It refuses when that bitrate would be below
+// config.MinBitrate kbps.
+func (p *Pipeline) shrinkToFit(ctx context.Context, path string, size, limit int64) (string, func(), error) {
+	tooLarge := fmt.Errorf("audio file too large: %d bytes (max: %d bytes)", size, limit)
+	if p.config.MinBitrate <= 0 {
+		return "", nil, tooLarge
+	}
+	seconds := audioDuration(path)
+	if seconds <= 0 {
+		return "", nil, tooLarge
+	}
+	kbps := int(float64(limit) * 8 * fitHeadroom / seconds / 1000)
+	if kbps < p.config.MinBitrate {
+		return "", nil, fmt.Errorf("%v; fitting it would need %d kbps, below -min-bitrate %d (use -chunk to split it instead)", tooLarge, kbps, p.config.MinBitrate)
+	}
+	kbps = min(kbps, 64)
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return "", nil, fmt.Errorf("%v; ffmpeg, needed to re-encode it, is not installed", tooLarge)
+	}
+
+	dir, err := p.makeTempDir("reencode")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	bitrate := fmt.Sprintf("%dk", kbps)
+	encodings := []struct{ file, codec string }{
+		{"audio.ogg", "libopus"},
+		{"audio.mp3", "libmp3lame"},
+	}
+	var lastErr error
+	for _, enc := range encodings {
+		out := filepath.Join(dir, enc.file)
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y", "-i", path, "-vn", "-ac", "1", "-c:a", enc.codec, "-b:a", bitrate, out)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			lastErr = fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+			continue
+		}
+		info, err := os.Stat(out)
+		if err != nil || info.Size() > limit {
+			lastErr = fmt.Errorf("re-encoded audio is still over the limit")
+			continue
+		}
+		p.console.progressf("Re-encoded audio at %s to fit under the %d byte limit\n", bitrate, limit)
+		return out, cleanup, nil
+	}
+	cleanup()
+	return "", nil, fmt.Errorf("%v; re-encoding failed: %v", tooLarge, lastErr)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// OpenAI's GPT-4o transcription models take different parameters from
+// whisper-1: they can't return verbose_json, so there are no segments or
+// timestamps, but they can stream the text as it is recognized. The
+// -diarize variant instead returns speaker-labeled segments and ignores the
+// prompt.
+
+// isGPT4oTranscribe reports whether model is one of the GPT-4o transcription
+// models rather than Whisper.
+func isGPT4oTranscribe(model string) bool {
+	return strings.HasPrefix(model, "gpt-4o") && strings.Contains(model, "transcribe")
+}
+
+// diarizingModel reports whether model returns speaker-labeled segments, so
+// that the LLM diarization stage can be skipped as for acoustic providers.
+func diarizingModel(model string) bool {
+	return isGPT4oTranscribe(model) && strings.HasSuffix(model, "-diarize")
+}
+
+// transcriptionFields returns the form fields of a transcription request for
+// the configured model, adjusted by opts.
+func (p *Pipeline) transcriptionFields(opts whisperOptions) []formField {
+	model := p.config.TranscriptionModel
+	fields := []formField{{"model", model}}
+	switch {
+	case diarizingModel(model):
+		// Audio over 30 seconds must be split server-side, which "auto" does
+		// at silences
+		fields = append(fields, formField{"response_format", "diarized_json"}, formField{"chunking_strategy", "auto"})
+	case isGPT4oTranscribe(model):
+		fields = append(fields, formField{"response_format", "json"}, formField{"stream", "true"})
+	default:
+		fields = append(fields, formField{"response_format", "verbose_json"})
+		if p.config.WordTimestamps {
+			// Asking for words alone would drop the segments
+			fields = append(fields, formField{"timestamp_granularities[]", "word"}, formField{"timestamp_granularities[]", "segment"})
+		}
+	}
+	if opts.Temperature > 0 {
+		fields = append(fields, formField{"temperature", strconv.FormatFloat(opts.Temperature, 'f', -1, 64)})
+	}
+	if !opts.NoPrompt && !diarizingModel(model) {
+		// The prompt works as a spelling hint for names and jargon, and as an
+		// example of the style to transcribe in
+		var prompt []string
+		if p.config.Verbatim {
+			prompt = append(prompt, verbatimWhisperPrompt)
+		}
+		if len(p.config.Vocabulary) > 0 {
+			prompt = append(prompt, whisperPrompt(p.config.Vocabulary))
+		}
+		if len(prompt) > 0 {
+			fields = append(fields, formField{"prompt", strings.Join(prompt, " ")})
+		}
+	}
+	return fields
+}
+
+// transcriptionEvent is one server-sent event of a streamed transcription.
+type transcriptionEvent struct {
+	Type  string `json:"type"`
+	Delta string `json:"delta"`
+	Text  string `json:"text"`
+}
+
+// decodeTranscriptionStream reads a streamed transcription, returning the text
+// of its transcript.text.done event, or the deltas received when the stream
+// ends without one. An error event fails the transcription.
+func decodeTranscriptionStream(r io.Reader) (*Transcript, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var text strings.Builder
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		var ev transcriptionEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return nil, fmt.Errorf("failed to decode stream event: %v", err)
+		}
+		switch ev.Type {
+		case "transcript.text.delta":
+			text.WriteString(ev.Delta)
+		case "transcript.text.done":
+			return &Transcript{Text: ev.Text}, nil
+		case "error":
+			return nil, fmt.Errorf("transcription stream failed: %s", data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %v", err)
+	}
+	if text.Len() == 0 {
+		return nil, fmt.Errorf("transcription stream ended without text")
+	}
+	return &Transcript{Text: text.String()}, nil
+}
+
+// diarizedResponse is the diarized_json reply of a diarizing model.
+type diarizedResponse struct {
+	Duration float64 `json:"duration"`
+	Text     string  `json:"text"`
+	Segments []struct {
+		Start   float64 `json:"start"`
+		End     float64 `json:"end"`
+		Speaker string  `json:"speaker"`
+		Text    string  `json:"text"`
+	} `json:"segments"`
+}
+
+// transcript converts the reply into the canonical model, naming the
+// provider's speaker letters "Speaker A", "Speaker B" and so on.
+func (d *diarizedResponse) transcript() *Transcript {
+	t := &Transcript{Duration: d.Duration, Text: d.Text}
+	for i, s := range d.Segments {
+		t.Segments = append(t.Segments, Segment{
+			ID:      i,
+			Start:   s.Start,
+			End:     s.End,
+			Speaker: "Speaker " + s.Speaker,
+			Text:    strings.TrimSpace(s.Text),
+		})
+	}
+	if t.Duration == 0 && len(t.Segments) > 0 {
+		t.Duration = t.Segments[len(t.Segments)-1].End
+	}
+	return t
+}
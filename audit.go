@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEntry is one line of the API audit log.
+type auditEntry struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	Endpoint        string      `json:"endpoint"`
+	RequestBytes    int64       `json:"request_bytes"`
+	ResponseBytes   int64       `json:"response_bytes"`
+	DurationSeconds float64     `json:"duration_seconds"`
+	Status          int         `json:"status,omitempty"`
+	RequestID       string      `json:"request_id,omitempty"`
+	ProviderID      string      `json:"provider_request_id,omitempty"`
+	Error           string      `json:"error,omitempty"`
+	Model           string      `json:"model,omitempty"`
+	Usage           *TokenUsage `json:"usage,omitempty"`
+	AudioSeconds    float64     `json:"audio_seconds,omitempty"`
+	CostEstimateUSD float64     `json:"cost_estimate_usd"`
+}
+
+// auditCaptureLimit bounds how much of a response body is kept to extract usage.
+const auditCaptureLimit = 1 << 20
+
+// auditLog appends one JSON line per external API call to a file.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openAuditLog opens path for appending, creating it if needed.
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return &auditLog{f: f}, nil
+}
+
+func (l *auditLog) write(e auditEntry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(stderr, "Error writing audit log: %v\n", err)
+	}
+}
+
+// Close closes the underlying file.
+func (l *auditLog) Close() error {
+	return l.f.Close()
+}
+
+// auditTransport is an http.RoundTripper that meters every request it carries: the
+// estimated cost is added to the state store's monthly spend and, when a log is
+// configured, an entry is written to it. Entries are recorded once the response
+// body has been closed, so the duration covers the full transfer.
+type auditTransport struct {
+	next  http.RoundTripper
+	log   *auditLog
+	state *stateStore
+	// config is the run's configuration, whose transcription model prices
+	// transcriptions since they don't report their model.
+	config *Config
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := auditEntry{
+		Time:         time.Now().UTC(),
+		Method:       req.Method,
+		Endpoint:     req.URL.Scheme + "://" + req.URL.Host + req.URL.Path,
+		RequestBytes: req.ContentLength,
+		RequestID:    req.Header.Get(clientRequestIDHeader),
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		entry.DurationSeconds = time.Since(entry.Time).Seconds()
+		entry.Error = redactSecrets(err.Error())
+		t.record(entry)
+		return nil, err
+	}
+	entry.Status = resp.StatusCode
+	entry.ProviderID = providerRequestID(resp)
+	resp.Body = &auditBody{ReadCloser: resp.Body, entry: entry, transport: t}
+	return resp, nil
+}
+
+// record persists a finished entry to the spend tracker and the audit log.
+func (t *auditTransport) record(e auditEntry) {
+	if t.state != nil {
+		if err := t.state.addSpend(e.CostEstimateUSD); err != nil {
+			fmt.Fprintf(stderr, "Error recording spend: %v\n", err)
+		}
+	}
+	if t.log != nil {
+		t.log.write(e)
+	}
+}
+
+// auditBody counts and partially captures a response body, writing the audit entry
+// when it is closed.
+type auditBody struct {
+	io.ReadCloser
+	entry     auditEntry
+	transport *auditTransport
+	capture   bytes.Buffer
+	once      sync.Once
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.entry.ResponseBytes += int64(n)
+	if room := auditCaptureLimit - b.capture.Len(); room > 0 {
+		b.capture.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+func (b *auditBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.entry.DurationSeconds = time.Since(b.entry.Time).Seconds()
+		estimateEntryCost(&b.entry, b.capture.Bytes(), b.transport.config.TranscriptionModel)
+		b.transport.record(b.entry)
+	})
+	return err
+}
+
+// estimateEntryCost fills in usage and a cost estimate from a captured response.
+// Chat completions report token usage and their model; transcriptions report the
+// audio duration and are priced with the run's transcription model.
+func estimateEntryCost(e *auditEntry, body []byte, model string) {
+	if e.Status != http.StatusOK {
+		return
+	}
+	if strings.HasSuffix(e.Endpoint, "/content") {
+		// A -batch result, a response per line
+		estimateBatchCost(e, body)
+		return
+	}
+	var res struct {
+		Model    string      `json:"model"`
+		Usage    *TokenUsage `json:"usage"`
+		Duration float64     `json:"duration"`
+		// AudioDuration is reported by AssemblyAI once a transcript completes.
+		AudioDuration float64 `json:"audio_duration"`
+		Metadata      struct {
+			Duration float64 `json:"duration"`
+		} `json:"metadata"`
+	}
+	if json.Unmarshal(body, &res) != nil {
+		return
+	}
+	if res.Duration == 0 {
+		res.Duration = max(res.Metadata.Duration, res.AudioDuration)
+	}
+	switch {
+	case res.Usage != nil && res.Usage.TotalTokens > 0 && res.Model != "":
+		// GPT-4o transcription replies report tokens but no model, and aren't
+		// priced by them
+		e.Model = res.Model
+		e.Usage = res.Usage
+		e.CostEstimateUSD = chatCost(res.Model, *res.Usage)
+	case res.Duration > 0:
+		e.Model = model
+		e.AudioSeconds = res.Duration
+		e.CostEstimateUSD = audioCost(model, res.Duration)
+	}
+}
+
+// estimateBatchCost fills in the usage and cost estimate of a downloaded
+// batch output file from its responses, at the batch price.
+func estimateBatchCost(e *auditEntry, body []byte) {
+	var usage TokenUsage
+	for _, line := range batchLines(body) {
+		var res struct {
+			Model string     `json:"model"`
+			Usage TokenUsage `json:"usage"`
+		}
+		if line.Response.StatusCode != http.StatusOK || json.Unmarshal(line.Response.Body, &res) != nil || res.Usage.TotalTokens == 0 {
+			continue
+		}
+		e.Model = res.Model
+		usage.Add(res.Usage)
+		e.CostEstimateUSD += chatCost(res.Model, res.Usage) * batchDiscount
+	}
+	if usage.TotalTokens > 0 {
+		e.Usage = &usage
+	}
+}
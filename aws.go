@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// awsCredentials are the static or session credentials read from the standard
+// AWS environment variables.
+type awsCredentials struct {
+	accessKey, secretKey, sessionToken string
+}
+
+// awsTranscriptionJob is the subset of Amazon Transcribe's TranscriptionJob that
+// is used.
+type awsTranscriptionJob struct {
+	TranscriptionJobName   string `json:"TranscriptionJobName"`
+	TranscriptionJobStatus string `json:"TranscriptionJobStatus"`
+	FailureReason          string `json:"FailureReason"`
+	LanguageCode           string `json:"LanguageCode"`
+	Transcript             struct {
+		TranscriptFileURI string `json:"TranscriptFileUri"`
+	} `json:"Transcript"`
+}
+
+// awsTranscript is the transcript document Amazon Transcribe writes when a job
+// completes. Times are decimal seconds encoded as strings.
+type awsTranscript struct {
+	Results struct {
+		Transcripts []struct {
+			Transcript string `json:"transcript"`
+		} `json:"transcripts"`
+		Items []struct {
+			Type         string `json:"type"`
+			StartTime    string `json:"start_time"`
+			EndTime      string `json:"end_time"`
+			SpeakerLabel string `json:"speaker_label"`
+			Alternatives []struct {
+				Content    string `json:"content"`
+				Confidence string `json:"confidence"`
+			} `json:"alternatives"`
+		} `json:"items"`
+	} `json:"results"`
+}
+
+// transcribeAWS stages the audio in S3, runs an Amazon Transcribe job with speaker
+// labels, and polls until it finishes.
+func (p *Pipeline) transcribeAWS(ctx context.Context, accessKey, audioPath string) (*Transcript, error) {
+	creds := awsCredentials{accessKey, os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}
+	if creds.secretKey == "" {
+		return nil, fmt.Errorf("Please set the AWS_SECRET_ACCESS_KEY environment variable")
+	}
+	if p.config.CloudBucket == "" {
+		return nil, fmt.Errorf("the aws backend needs -bucket to stage the audio in S3")
+	}
+	region := p.config.CloudRegion
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return nil, fmt.Errorf("the aws backend needs -region or the AWS_REGION environment variable")
+	}
+
+	jobName := fmt.Sprintf("podcast-transcription-%d", time.Now().UnixNano())
+	key := "podcast-transcription/" + jobName + filepath.Ext(audioPath)
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", p.config.CloudBucket, region, key)
+	if err := p.awsUpload(ctx, creds, region, objectURL, audioPath); err != nil {
+		return nil, err
+	}
+	defer p.awsDelete(creds, region, objectURL)
+
+	settings := map[string]interface{}{}
+	if p.config.Speakers > 1 {
+		settings["ShowSpeakerLabels"] = true
+		settings["MaxSpeakerLabels"] = min(p.config.Speakers, 30)
+	}
+	payload := map[string]interface{}{
+		"TranscriptionJobName": jobName,
+		"Media":                map[string]string{"MediaFileUri": "s3://" + p.config.CloudBucket + "/" + key},
+		"Settings":             settings,
+	}
+	if p.config.Language != "" {
+		payload["LanguageCode"] = p.config.Language
+	} else {
+		payload["IdentifyLanguage"] = true
+	}
+	var res struct {
+		TranscriptionJob awsTranscriptionJob `json:"TranscriptionJob"`
+	}
+	if err := p.awsTranscribeCall(ctx, creds, region, "StartTranscriptionJob", payload, &res); err != nil {
+		return nil, err
+	}
+	job := res.TranscriptionJob
+	for job.TranscriptionJobStatus != "COMPLETED" {
+		if job.TranscriptionJobStatus == "FAILED" {
+			return nil, fmt.Errorf("Amazon Transcribe job %s failed: %s", jobName, job.FailureReason)
+		}
+		if err := waitPoll(ctx, "Amazon Transcribe job "+jobName); err != nil {
+			return nil, err
+		}
+		if err := p.awsTranscribeCall(ctx, creds, region, "GetTranscriptionJob", map[string]string{"TranscriptionJobName": jobName}, &res); err != nil {
+			return nil, err
+		}
+		job = res.TranscriptionJob
+	}
+
+	// The transcript URI is a presigned S3 URL and needs no further signing
+	req, err := http.NewRequestWithContext(ctx, "GET", job.Transcript.TranscriptFileURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	var doc awsTranscript
+	if err := p.awsDo(req, &doc); err != nil {
+		return nil, fmt.Errorf("failed to fetch transcript: %v", err)
+	}
+	t := doc.transcript(filepath.Base(audioPath))
+	t.Language = job.LanguageCode
+	return t, nil
+}
+
+// awsUpload PUTs the audio file to objectURL. S3 checks the upload against its
+// Content-MD5 and rejects a corrupted one with BadDigest, in which case it is
+// uploaded again.
+func (p *Pipeline) awsUpload(ctx context.Context, creds awsCredentials, region, objectURL, audioPath string) error {
+	fileInfo, err := os.Stat(audioPath)
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+	sum, err := fileMD5(audioPath)
+	if err != nil {
+		return err
+	}
+
+	return p.retryCorrupt(ctx, func() error {
+		file, err := os.Open(audioPath)
+		if err != nil {
+			return fmt.Errorf("failed to open audio file: %v", err)
+		}
+		defer file.Close()
+		req, err := http.NewRequestWithContext(ctx, "PUT", objectURL, file)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.ContentLength = fileInfo.Size()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+		signAWS(req, "UNSIGNED-PAYLOAD", creds, region, "s3", time.Now())
+		if err := p.awsDo(req, nil); err != nil {
+			var dropped *droppedTransfer
+			switch {
+			case strings.Contains(err.Error(), "BadDigest"):
+				return &corruptTransfer{"upload", "S3 rejected the MD5 checksum"}
+			case errors.As(err, &dropped):
+				return &droppedTransfer{"upload to S3", dropped.err}
+			}
+			return fmt.Errorf("failed to upload audio to S3: %v", err)
+		}
+		return nil
+	})
+}
+
+// awsDelete removes the staged audio. Failures are only reported, since the
+// transcript has already been produced.
+func (p *Pipeline) awsDelete(creds awsCredentials, region, objectURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.HTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", objectURL, nil)
+	if err == nil {
+		signAWS(req, sha256Hex(nil), creds, region, "s3", time.Now())
+		err = p.awsDo(req, nil)
+	}
+	if err != nil {
+		p.console.warnf("failed to delete %s: %v\n", objectURL, err)
+	}
+}
+
+// awsTranscribeCall invokes an Amazon Transcribe JSON API action.
+func (p *Pipeline) awsTranscribeCall(ctx context.Context, creds awsCredentials, region, action string, payload, out interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	endpoint := fmt.Sprintf("https://transcribe.%s.amazonaws.com/", region)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Transcribe."+action)
+	signAWS(req, sha256Hex(data), creds, region, "transcribe", time.Now())
+	if err := p.awsDo(req, out); err != nil {
+		return fmt.Errorf("%s failed: %v", action, err)
+	}
+	return nil
+}
+
+// awsDo sends req and decodes a JSON reply into out, if out is not nil.
+func (p *Pipeline) awsDo(req *http.Request, out interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if isDropped(err) {
+			return &droppedTransfer{"request to AWS", err}
+		}
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return fmt.Errorf("non-2xx response from AWS: %d, body: %s", resp.StatusCode, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode AWS response: %v", err)
+	}
+	return nil
+}
+
+// signAWS adds AWS Signature Version 4 headers to req. payloadHash is the hex
+// SHA-256 of the body, or "UNSIGNED-PAYLOAD" for streamed S3 uploads.
+func signAWS(req *http.Request, payloadHash string, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := []byte("AWS4" + creds.secretKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// transcript converts the Amazon Transcribe document into the canonical model.
+// Punctuation items are attached to the preceding word.
+func (doc *awsTranscript) transcript(audio string) *Transcript {
+	t := &Transcript{Audio: audio}
+	if len(doc.Results.Transcripts) > 0 {
+		t.Text = doc.Results.Transcripts[0].Transcript
+	}
+	var words []labeledWord
+	for _, item := range doc.Results.Items {
+		if len(item.Alternatives) == 0 {
+			continue
+		}
+		alt := item.Alternatives[0]
+		if item.Type == "punctuation" {
+			if n := len(words); n > 0 {
+				words[n-1].Text += alt.Content
+			}
+			continue
+		}
+		start, _ := strconv.ParseFloat(item.StartTime, 64)
+		end, _ := strconv.ParseFloat(item.EndTime, 64)
+		confidence, _ := strconv.ParseFloat(alt.Confidence, 64)
+		words = append(words, labeledWord{
+			Word:    Word{Text: alt.Content, Start: start, End: end, Confidence: confidence},
+			speaker: item.SpeakerLabel,
+		})
+		t.Duration = max(t.Duration, end)
+	}
+	t.Segments = wordTurns(words)
+	return t
+}
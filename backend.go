@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backend is a speech-to-text provider selectable with -backend.
+type backend struct {
+	// defaultModel is used unless -transcription-model is given.
+	defaultModel string
+	// keyEnv names the environment variable holding the provider's API key.
+	keyEnv string
+	// endpoint is recorded in the run manifest.
+	endpoint string
+	// diarizes reports whether the provider returns speaker-attributed turns, in
+	// which case the LLM diarization stage is skipped.
+	diarizes bool
+	// credentials, if set, obtains the key instead of reading keyEnv.
+	credentials func() (string, error)
+	// transcribe converts the audio file into the canonical model.
+	transcribe func(p *Pipeline, ctx context.Context, apiKey, audioPath string) (*Transcript, error)
+}
+
+// backends is the registry of transcription providers.
+var backends = map[string]backend{
+	"openai": {
+		defaultModel: "whisper-1",
+		keyEnv:       "OPENAI_API_KEY",
+		endpoint:     defaultWhisperURL,
+		transcribe:   (*Pipeline).transcribeAudio,
+	},
+	"deepgram": {
+		defaultModel: "nova-3",
+		keyEnv:       "DEEPGRAM_API_KEY",
+		endpoint:     defaultDeepgramURL,
+		diarizes:     true,
+		transcribe:   (*Pipeline).transcribeDeepgram,
+	},
+	"assemblyai": {
+		defaultModel: "best",
+		keyEnv:       "ASSEMBLYAI_API_KEY",
+		endpoint:     defaultAssemblyAIURL,
+		diarizes:     true,
+		transcribe:   (*Pipeline).transcribeAssemblyAI,
+	},
+	"google": {
+		defaultModel: "long",
+		keyEnv:       "GOOGLE_OAUTH_ACCESS_TOKEN",
+		endpoint:     "https://speech.googleapis.com/v2",
+		diarizes:     true,
+		credentials:  googleAccessToken,
+		transcribe:   (*Pipeline).transcribeGoogle,
+	},
+	"aws": {
+		defaultModel: "transcribe",
+		keyEnv:       "AWS_ACCESS_KEY_ID",
+		endpoint:     "https://transcribe.amazonaws.com",
+		diarizes:     true,
+		transcribe:   (*Pipeline).transcribeAWS,
+	},
+	"local": {
+		defaultModel: "large-v3",
+		diarizes:     true,
+		credentials:  func() (string, error) { return "", nil },
+		transcribe:   (*Pipeline).transcribeLocal,
+	},
+}
+
+// backendNames returns the registered backend names in sorted order.
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for n := range backends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupBackend returns the named built-in backend or provider plugin, or an error
+// listing the available ones.
+func lookupBackend(name string) (backend, error) {
+	if b, ok := backends[name]; ok {
+		return b, nil
+	}
+	if _, ok := findPlugins()[name]; ok {
+		return pluginBackend(name)
+	}
+	available := append(backendNames(), pluginNames()...)
+	return backend{}, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(available, ", "))
+}
+
+// apiKey returns the credential passed to the backend's transcribe function.
+func (b backend) apiKey() (string, error) {
+	if b.credentials != nil {
+		key, err := b.credentials()
+		registerSecret(key)
+		return key, err
+	}
+	key := os.Getenv(b.keyEnv)
+	if key == "" {
+		return "", fmt.Errorf("Please set the %s environment variable", b.keyEnv)
+	}
+	return key, nil
+}
+
+// pollInterval is how often a provider's long-running transcription job is polled.
+const pollInterval = 3 * time.Second
+
+// waitPoll sleeps for one poll interval, returning early if ctx is done.
+func waitPoll(ctx context.Context, job string) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for %s: %v", job, ctx.Err())
+	case <-time.After(pollInterval):
+		return nil
+	}
+}
+
+// labeledWord is a timed word with the provider's raw speaker label.
+type labeledWord struct {
+	Word
+	speaker string
+}
+
+// wordTurns groups words into speaker turns. Raw labels are renamed "Speaker 1",
+// "Speaker 2", … in order of first appearance; words without a label stay
+// unattributed.
+func wordTurns(words []labeledWord) []Segment {
+	name := speakerNamer()
+	var segments []Segment
+	for _, w := range words {
+		segments = append(segments, Segment{Start: w.Start, End: w.End, Speaker: name(w.speaker), Text: w.Text, Words: []Word{w.Word}})
+	}
+	return mergeSpeakerTurns(segments)
+}
+
+// speakerNamer returns a function that renames raw provider labels such as
+// "spk_0" or "SPEAKER_01" to "Speaker 1", "Speaker 2", … in order of first
+// appearance. The empty label stays empty.
+func speakerNamer() func(label string) string {
+	names := map[string]string{}
+	return func(label string) string {
+		if label == "" {
+			return ""
+		}
+		if _, ok := names[label]; !ok {
+			names[label] = fmt.Sprintf("Speaker %d", len(names)+1)
+		}
+		return names[label]
+	}
+}
+
+// mergedConfidence is the speaker confidence of two joined turns: their mean
+// weighted by duration, or whichever is known.
+func mergedConfidence(a, b Segment) float64 {
+	if a.SpeakerConfidence == 0 || b.SpeakerConfidence == 0 {
+		return max(a.SpeakerConfidence, b.SpeakerConfidence)
+	}
+	da, db := max(a.End-a.Start, 0.001), max(b.End-b.Start, 0.001)
+	return (a.SpeakerConfidence*da + b.SpeakerConfidence*db) / (da + db)
+}
+
+// mergeSpeakerTurns joins consecutive segments from the same speaker into one
+// turn, which is how providers that diarize per utterance are presented.
+func mergeSpeakerTurns(segments []Segment) []Segment {
+	var turns []Segment
+	for _, s := range segments {
+		if n := len(turns); n > 0 && turns[n-1].Speaker == s.Speaker {
+			last := &turns[n-1]
+			last.SpeakerConfidence = mergedConfidence(*last, s)
+			last.End = s.End
+			last.Text = strings.TrimSpace(last.Text + " " + s.Text)
+			last.Words = append(last.Words, s.Words...)
+			continue
+		}
+		turns = append(turns, s)
+	}
+	for i := range turns {
+		turns[i].ID = i
+	}
+	return turns
+}
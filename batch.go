@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// batchWindow is the completion window batches are submitted with. The
+	// provider may take this long to answer, in return for about half the
+	// price.
+	batchWindow = 24 * time.Hour
+	// batchPollInterval is how often a submitted batch's status is checked.
+	batchPollInterval = 30 * time.Second
+	// batchDiscount is the share of the list price batched requests cost.
+	batchDiscount = 0.5
+)
+
+// batchRecord is a chat request submitted as a batch with -batch whose
+// result hasn't been collected, so that a run stopped while it waits picks
+// the batch up again instead of paying for it twice.
+type batchRecord struct {
+	ID          string    `json:"id"`
+	Model       string    `json:"model,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// pendingBatch returns the batch submitted for the request with key, if any.
+func (s *stateStore) pendingBatch(key string) (batchRecord, bool) {
+	st, err := s.load()
+	if err != nil {
+		return batchRecord{}, false
+	}
+	b, ok := st.Batches[key]
+	return b, ok
+}
+
+// setBatch records the batch submitted for the request with key, or forgets
+// it once collected if b is nil.
+func (s *stateStore) setBatch(key string, b *batchRecord) error {
+	return s.update(func(st *State) {
+		if b == nil {
+			delete(st.Batches, key)
+		} else {
+			st.Batches[key] = *b
+		}
+	})
+}
+
+// batchAPI returns the base URL of the OpenAI-compatible API the chat
+// completions endpoint belongs to, and the endpoint's path under it, which
+// batches name their requests' endpoint by.
+func (p *Pipeline) batchAPI() (base, endpoint string, err error) {
+	u, err := url.Parse(p.config.ChatCompletionsURL)
+	if err != nil {
+		return "", "", err
+	}
+	base = strings.TrimSuffix(p.config.ChatCompletionsURL, "/chat/completions")
+	if base == p.config.ChatCompletionsURL {
+		return "", "", fmt.Errorf("-batch needs a chat completions URL ending in /chat/completions, not %s", p.config.ChatCompletionsURL)
+	}
+	return base, u.Path, nil
+}
+
+// batchCompletion answers a chat request through the provider's Batch API:
+// it uploads the request as a one-line batch, waits for the batch to
+// complete, and returns the content of the first choice of its result with
+// the token usage. Each step is an ordinary request, retried by the
+// transport like any other, and a batch the run is stopped waiting for is
+// collected by the next run sending the same request.
+func (p *Pipeline) batchCompletion(ctx context.Context, apiKey string, payload map[string]interface{}) (string, TokenUsage, error) {
+	base, endpoint, err := p.batchAPI()
+	if err != nil {
+		return "", TokenUsage{}, err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", TokenUsage{}, fmt.Errorf("failed to marshal payload: %v", err)
+	}
+	sum := sha256.Sum256(append([]byte(base+"\n"), body...))
+	key := hex.EncodeToString(sum[:])
+	model, _ := payload["model"].(string)
+
+	var batch batchRecord
+	resumed := false
+	if p.batches != nil {
+		batch, resumed = p.batches.pendingBatch(key)
+	}
+	if resumed {
+		p.console.progressf("Collecting batch %s submitted %s\n", batch.ID, batch.SubmittedAt.Local().Format("2006-01-02 15:04"))
+	} else {
+		line, err := json.Marshal(map[string]interface{}{
+			"custom_id": key[:16],
+			"method":    "POST",
+			"url":       endpoint,
+			"body":      json.RawMessage(body),
+		})
+		if err != nil {
+			return "", TokenUsage{}, fmt.Errorf("failed to marshal batch request: %v", err)
+		}
+		if batch.ID, err = p.submitBatch(ctx, apiKey, base, endpoint, append(line, '\n')); err != nil {
+			return "", TokenUsage{}, err
+		}
+		batch.Model, batch.SubmittedAt = model, time.Now().UTC()
+		if p.batches != nil {
+			if err := p.batches.setBatch(key, &batch); err != nil {
+				p.console.warnf("failed to record batch %s: %v\n", batch.ID, err)
+			}
+		}
+		p.console.progressf("Submitted batch %s; waiting for it to complete (up to %.0fh)\n", batch.ID, batchWindow.Hours())
+	}
+
+	content, usage, final, err := p.awaitBatch(ctx, apiKey, base, batch.ID)
+	if final && p.batches != nil {
+		// Once it's done, a batch that failed is submitted anew by the next
+		// attempt; one still running is waited for again
+		if err := p.batches.setBatch(key, nil); err != nil {
+			p.console.warnf("failed to record batch %s as collected: %v\n", batch.ID, err)
+		}
+	}
+	return content, usage, err
+}
+
+// submitBatch uploads the JSONL requests and starts a batch of them,
+// returning its ID.
+func (p *Pipeline) submitBatch(ctx context.Context, apiKey, base, endpoint string, requests []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("purpose", "batch")
+	part, _ := mw.CreateFormFile("file", "requests.jsonl")
+	part.Write(requests)
+	mw.Close()
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err := p.openAIRequest(ctx, apiKey, "POST", base+"/files", mw.FormDataContentType(), &body, &file); err != nil {
+		return "", fmt.Errorf("failed to upload batch requests: %v", err)
+	}
+
+	create, err := json.Marshal(map[string]interface{}{
+		"input_file_id":     file.ID,
+		"endpoint":          endpoint,
+		"completion_window": "24h",
+		"metadata":          map[string]string{"submitted_by": "podcast-transcription"},
+	})
+	if err != nil {
+		return "", err
+	}
+	var batch struct {
+		ID string `json:"id"`
+	}
+	if err := p.openAIRequest(ctx, apiKey, "POST", base+"/batches", "application/json", bytes.NewReader(create), &batch); err != nil {
+		return "", fmt.Errorf("failed to create batch: %v", err)
+	}
+	return batch.ID, nil
+}
+
+// awaitBatch polls the batch every batchPollInterval until it's done, and
+// returns the result of its one request. The bool reports whether the batch
+// is done with, even if it failed, rather than still to be collected.
+func (p *Pipeline) awaitBatch(ctx context.Context, apiKey, base, id string) (string, TokenUsage, bool, error) {
+	for {
+		var status struct {
+			Status       string `json:"status"`
+			OutputFileID string `json:"output_file_id"`
+			ErrorFileID  string `json:"error_file_id"`
+			Errors       *struct {
+				Data []struct {
+					Message string `json:"message"`
+				} `json:"data"`
+			} `json:"errors"`
+		}
+		if err := p.openAIRequest(ctx, apiKey, "GET", base+"/batches/"+url.PathEscape(id), "", nil, &status); err != nil {
+			return "", TokenUsage{}, false, fmt.Errorf("failed to check batch %s: %v", id, err)
+		}
+		switch status.Status {
+		case "completed":
+			file := firstNonEmpty(status.OutputFileID, status.ErrorFileID)
+			if file == "" {
+				return "", TokenUsage{}, true, fmt.Errorf("batch %s completed without a result", id)
+			}
+			return p.batchResult(ctx, apiKey, base, file)
+		case "failed", "expired", "cancelled", "cancelling":
+			reason := ""
+			if status.Errors != nil && len(status.Errors.Data) > 0 {
+				reason = ": " + status.Errors.Data[0].Message
+			}
+			return "", TokenUsage{}, true, fmt.Errorf("batch %s %s%s", id, status.Status, reason)
+		}
+		select {
+		case <-ctx.Done():
+			return "", TokenUsage{}, false, fmt.Errorf("stopped waiting for batch %s, which the next run collects: %v", id, ctx.Err())
+		case <-time.After(batchPollInterval):
+		}
+	}
+}
+
+// batchResult downloads the output file of a batch and returns the content
+// of the first choice of its one response. The bool reports whether the file
+// was downloaded, the result then being final even if it's an error.
+func (p *Pipeline) batchResult(ctx context.Context, apiKey, base, file string) (string, TokenUsage, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/files/"+url.PathEscape(file)+"/content", nil)
+	if err != nil {
+		return "", TokenUsage{}, false, err
+	}
+	p.setOpenAIHeaders(req, apiKey)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", TokenUsage{}, false, fmt.Errorf("failed to download batch result: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+	if err != nil {
+		return "", TokenUsage{}, false, fmt.Errorf("failed to download batch result: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", TokenUsage{}, false, fmt.Errorf("non-200 response downloading batch result: %d, body: %s%s", resp.StatusCode, string(data), requestRef(resp))
+	}
+	lines := batchLines(data)
+	if len(lines) == 0 {
+		return "", TokenUsage{}, true, fmt.Errorf("empty batch result")
+	}
+	line := lines[0]
+	if line.Error != nil {
+		return "", TokenUsage{}, true, fmt.Errorf("batch request failed: %s", line.Error.Message)
+	}
+	if line.Response.StatusCode != http.StatusOK {
+		return "", TokenUsage{}, true, fmt.Errorf("non-200 response from batched chat completion: %d, body: %s", line.Response.StatusCode, string(line.Response.Body))
+	}
+	var res struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage TokenUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(line.Response.Body, &res); err != nil {
+		return "", TokenUsage{}, true, fmt.Errorf("failed to decode batched chat completion: %v", err)
+	}
+	if len(res.Choices) == 0 {
+		return "", res.Usage, true, fmt.Errorf("no choices returned from batched chat completion")
+	}
+	return res.Choices[0].Message.Content, res.Usage, true, nil
+}
+
+// batchLine is one line of a batch's output or error file.
+type batchLine struct {
+	CustomID string `json:"custom_id"`
+	Response struct {
+		StatusCode int             `json:"status_code"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// batchLines parses a batch's output or error file, skipping lines that
+// aren't JSON.
+func batchLines(data []byte) []batchLine {
+	var lines []batchLine
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		var line batchLine
+		if json.Unmarshal(sc.Bytes(), &line) == nil {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// openAIRequest sends a request to the OpenAI-compatible API and decodes
+// its JSON reply into out.
+func (p *Pipeline) openAIRequest(ctx context.Context, apiKey, method, endpoint, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	p.setOpenAIHeaders(req, apiKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return fmt.Errorf("non-200 response: %d, body: %s%s", resp.StatusCode, string(data), requestRef(resp))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out)
+}
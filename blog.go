@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// draftTokens is about the length of a written draft such as a blog post, for
+// sizing the request timeout.
+const draftTokens = 2000
+
+// blogPrompt asks for a blog post draft built from a diarized transcript.
+const blogPrompt = `Turn the following podcast transcript into a blog post draft in Markdown with:
+- a title as a level-1 heading
+- an introduction of one or two paragraphs
+- sections under level-2 headings, one for each main topic of the conversation
+- two or three pull quotes, as blockquotes attributed to the speaker, copied word for word from the transcript
+- a short conclusion
+Write readable prose rather than retelling the conversation turn by turn. Respond with the Markdown only.
+%s%s
+Transcript:
+%s`
+
+// blogDraft asks the summary model for a blog post draft of the diarized
+// transcript, following the optional style instructions.
+func (p *Pipeline) blogDraft(ctx context.Context, apiKey string, t *Transcript, style string) (string, TokenUsage, error) {
+	var styleText, episode string
+	if style = strings.TrimSpace(style); style != "" {
+		styleText = "\nFollow this style guide:\n" + style + "\n"
+	}
+	if t.Title != "" {
+		episode += "\nEpisode title: " + t.Title + "\n"
+	}
+	if t.Description != "" {
+		episode += "Episode description: " + t.Description + "\n"
+	}
+	text := fitContext(formatTurns(t.Segments), p.config.SummaryModel)
+	payload := map[string]interface{}{
+		"model":       p.config.SummaryModel,
+		"messages":    []map[string]string{{"role": "user", "content": fmt.Sprintf(blogPrompt, styleText, episode, text)}},
+		"temperature": p.config.Temperature,
+	}
+	draft, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return "", usage, fmt.Errorf("failed to draft blog post: %v", err)
+	}
+	return stripCodeFence(draft) + "\n", usage, nil
+}
+
+// stripCodeFence removes the ``` fence models sometimes wrap a whole answer in.
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") {
+		return s
+	}
+	s = strings.TrimSuffix(s, "```")
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	} else {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
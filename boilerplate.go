@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// boilerplateTolerance is how far, in seconds, an intro or outro may move
+	// between episodes while still counting as at a fixed place.
+	boilerplateTolerance = 3.0
+	// boilerplatePhrases caps the phrases kept of an intro or outro.
+	boilerplatePhrases = 500
+)
+
+// boilerplate is what a show says in every episode: its intro and outro, as
+// learned by the boilerplate command from episodes already transcribed.
+type boilerplate struct {
+	Episodes int              `json:"episodes"`
+	Window   float64          `json:"window_seconds"`
+	Intro    *boilerplatePart `json:"intro,omitempty"`
+	Outro    *boilerplatePart `json:"outro,omitempty"`
+}
+
+// boilerplatePart is an intro or an outro.
+type boilerplatePart struct {
+	// Offset is where the intro starts after the start of the episode, or
+	// where the outro ends before its end, in seconds; as are Duration and
+	// the rest, this is the median of the episodes it was found in.
+	Offset   float64 `json:"offset"`
+	Duration float64 `json:"duration"`
+	// Fixed is whether it was at the same place in every episode, give or
+	// take boilerplateTolerance, so that it can be cut without transcribing.
+	Fixed bool `json:"fixed"`
+	Seen  int  `json:"seen"`
+	// Text is the part as transcribed in the first episode it was found in.
+	Text string `json:"text"`
+	// Phrases are its runs of recurringShingle words, which turns are
+	// matched against.
+	Phrases []string `json:"phrases"`
+}
+
+// runBoilerplate implements the boilerplate command.
+func runBoilerplate(args []string) error {
+	flags := flag.NewFlagSet("boilerplate", flag.ExitOnError)
+	in := flags.String("in", ".", "Directory searched recursively for the show's diarized transcripts")
+	out := flags.String("out", "boilerplate.json", "File the learned intro and outro are written to, for -boilerplate")
+	window := flags.Duration("window", 3*time.Minute, "How far from the start and the end of each episode to look")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription boilerplate [-in dir] [-out file] [-window duration]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	episodes, err := loadCatalog(key, *in)
+	if err != nil {
+		return err
+	}
+	if len(episodes) < 2 {
+		return fmt.Errorf("found %d %s file(s) under %s; an intro is learned from at least two episodes", len(episodes), filepath.Base(defaultConfig().DiarizedJSONFile), *in)
+	}
+	b := learnBoilerplate(episodes, window.Seconds())
+	if b.Intro == nil && b.Outro == nil {
+		return fmt.Errorf("no intro or outro recurs in the first or last %s of %d episodes", formatTimestamp(b.Window, ".")[:8], len(episodes))
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write boilerplate: %v", err)
+	}
+	for _, part := range []struct {
+		name string
+		p    *boilerplatePart
+	}{{"intro", b.Intro}, {"outro", b.Outro}} {
+		if part.p == nil {
+			fmt.Printf("No %s recurs\n", part.name)
+			continue
+		}
+		place := "moves between episodes, so it can only be left out of transcripts"
+		if part.p.Fixed {
+			place = "is at a fixed place, so -skip-boilerplate can cut it before transcribing"
+		}
+		fmt.Printf("The %s (%s, in %d of %d episodes) %s: %q\n",
+			part.name, formatTimestamp(part.p.Duration, ".")[:8], part.p.Seen, b.Episodes, place, truncateWords(part.p.Text, 20))
+	}
+	fmt.Printf("Wrote %s\n", *out)
+	return nil
+}
+
+// learnBoilerplate finds the intro and outro of a show: the turns in the
+// first and last window seconds of episodes whose phrases are heard there in
+// at least half of them. Turns are matched by phrase rather than whole, since
+// hosts seldom read an intro the same way twice, or are transcribed the same
+// way when they do.
+func learnBoilerplate(episodes []catalogEpisode, window float64) *boilerplate {
+	b := &boilerplate{Episodes: len(episodes), Window: window}
+	b.Intro = learnPart(episodes, window, false)
+	b.Outro = learnPart(episodes, window, true)
+	return b
+}
+
+// learnPart learns the intro, or the outro if outro is set.
+func learnPart(episodes []catalogEpisode, window float64, outro bool) *boilerplatePart {
+	minSeen := max(2, (len(episodes)+1)/2)
+	regions := make([][]Segment, len(episodes))
+	counts := map[string]int{}
+	for i, ep := range episodes {
+		regions[i] = boilerplateRegion(ep.transcript.Segments, window, outro)
+		seen := map[string]bool{}
+		for _, s := range regions[i] {
+			for _, key := range phraseKeys(s.Text) {
+				if !seen[key] {
+					seen[key] = true
+					counts[key]++
+				}
+			}
+		}
+	}
+	phrases := map[string]bool{}
+	for key, n := range counts {
+		if n >= minSeen {
+			phrases[key] = true
+		}
+	}
+	if len(phrases) == 0 {
+		return nil
+	}
+
+	var offsets, durations []float64
+	part := &boilerplatePart{}
+	for i, ep := range episodes {
+		matched := boilerplateRun(regions[i], phrases, outro)
+		if len(matched) == 0 {
+			continue
+		}
+		start, end := matched[0].Start, matched[len(matched)-1].End
+		offset := start
+		if outro {
+			offset = episodeEnd(ep.transcript.Segments) - end
+		}
+		offsets = append(offsets, offset)
+		durations = append(durations, end-start)
+		if part.Text == "" {
+			part.Text = joinSegmentText(matched)
+		}
+	}
+	if len(offsets) < minSeen {
+		return nil
+	}
+	part.Seen = len(offsets)
+	part.Offset, part.Duration = median(offsets), median(durations)
+	part.Fixed = part.Seen == len(episodes)
+	for i := range offsets {
+		if abs(offsets[i]-part.Offset) > boilerplateTolerance || abs(offsets[i]+durations[i]-part.Offset-part.Duration) > boilerplateTolerance {
+			part.Fixed = false
+		}
+	}
+	for key := range phrases {
+		part.Phrases = append(part.Phrases, key)
+	}
+	sort.Strings(part.Phrases)
+	part.Phrases = part.Phrases[:min(len(part.Phrases), boilerplatePhrases)]
+	return part
+}
+
+// boilerplateRun returns the run of consecutive turns of a region making up
+// its intro: from the first turn matching phrases until one doesn't, or for
+// an outro, back from the last. Stopping at the first turn that isn't
+// boilerplate keeps a phrase the hosts happen to repeat later on from
+// stretching the intro over the content before it.
+func boilerplateRun(region []Segment, phrases map[string]bool, outro bool) []Segment {
+	if outro {
+		end := len(region)
+		for end > 0 && !matchesBoilerplate(region[end-1].Text, phrases) {
+			end--
+		}
+		start := end
+		for start > 0 && matchesBoilerplate(region[start-1].Text, phrases) {
+			start--
+		}
+		return region[start:end]
+	}
+	start := 0
+	for start < len(region) && !matchesBoilerplate(region[start].Text, phrases) {
+		start++
+	}
+	end := start
+	for end < len(region) && matchesBoilerplate(region[end].Text, phrases) {
+		end++
+	}
+	return region[start:end]
+}
+
+// boilerplateRegion returns the turns, events aside, starting in the first
+// window seconds of an episode, or ending in its last if outro is set.
+func boilerplateRegion(segments []Segment, window float64, outro bool) []Segment {
+	end := episodeEnd(segments)
+	var region []Segment
+	for _, s := range segments {
+		if s.Kind == eventKind {
+			continue
+		}
+		if (!outro && s.Start < window) || (outro && s.End > end-window) {
+			region = append(region, s)
+		}
+	}
+	return region
+}
+
+// episodeEnd is where the last turn of an episode ends.
+func episodeEnd(segments []Segment) float64 {
+	if len(segments) == 0 {
+		return 0
+	}
+	return segments[len(segments)-1].End
+}
+
+// phraseKeys returns the distinct runs of recurringShingle words of text.
+func phraseKeys(text string) []string {
+	words := shingleWords(text)
+	var keys []string
+	seen := map[string]bool{}
+	for j := 0; j+recurringShingle <= len(words); j++ {
+		key := strings.Join(words[j:j+recurringShingle], " ")
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// matchesBoilerplate reports whether at least half the phrases of a turn are
+// boilerplate. Turns shorter than a phrase never match.
+func matchesBoilerplate(text string, phrases map[string]bool) bool {
+	keys := phraseKeys(text)
+	n := 0
+	for _, key := range keys {
+		if phrases[key] {
+			n++
+		}
+	}
+	return n > 0 && 2*n >= len(keys)
+}
+
+// median returns the median of values, which it sorts.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// abs returns the absolute value of x.
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// loadBoilerplate reads a file written by the boilerplate command.
+func loadBoilerplate(path string) (*boilerplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b boilerplate
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &b, nil
+}
+
+// skipRange returns the -from and -to positions that cut the intro and outro
+// off audio of the given length before it's transcribed, where they're at a
+// fixed place at its very start or end; with nothing to skip they're empty.
+// Where one isn't, say after a cold open, cutting it out would shift every
+// time after it, so it's only left out of the transcript.
+func (b *boilerplate) skipRange(duration float64) (from, to string) {
+	if p := b.Intro; p != nil && p.Fixed && p.Offset <= boilerplateTolerance {
+		from = strconv.FormatFloat(p.Offset+p.Duration, 'f', 3, 64)
+	}
+	if p := b.Outro; p != nil && p.Fixed && p.Offset <= boilerplateTolerance && duration > 0 {
+		if end := duration - p.Offset - p.Duration; end > 0 {
+			to = strconv.FormatFloat(end, 'f', 3, 64)
+		}
+	}
+	return from, to
+}
+
+// drop removes the turns of the intro and outro from segments, returning the
+// segments kept and how many were dropped.
+func (b *boilerplate) drop(segments []Segment) ([]Segment, int) {
+	intro, outro := map[string]bool{}, map[string]bool{}
+	if b.Intro != nil {
+		for _, key := range b.Intro.Phrases {
+			intro[key] = true
+		}
+	}
+	if b.Outro != nil {
+		for _, key := range b.Outro.Phrases {
+			outro[key] = true
+		}
+	}
+	end := episodeEnd(segments)
+	kept := segments[:0:0]
+	dropped := 0
+	for _, s := range segments {
+		if s.Kind != eventKind && ((s.Start < b.Window && matchesBoilerplate(s.Text, intro)) ||
+			(s.End > end-b.Window && matchesBoilerplate(s.Text, outro))) {
+			dropped++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept, dropped
+}
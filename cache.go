@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// artifactPrefix starts the name of every scratch directory the tool creates in
+// the cache directory, so cleanup never touches anything else.
+const artifactPrefix = "podcast-transcription-"
+
+// staleArtifactAge is how old a scratch directory must be before it is taken to
+// be left over from a crashed run and removed at startup.
+const staleArtifactAge = 24 * time.Hour
+
+// diskHeadroom is the free space kept in reserve beyond what a stage needs.
+const diskHeadroom = 100 << 20
+
+// defaultCacheDir returns the per-user cache directory, falling back to the
+// system temp directory.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return os.TempDir()
+	}
+	return filepath.Join(dir, "podcast-transcription")
+}
+
+// makeTempDir creates a scratch directory for one stage in the cache directory.
+func (p *Pipeline) makeTempDir(stage string) (string, error) {
+	if err := os.MkdirAll(p.config.CacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	dir, err := os.MkdirTemp(p.config.CacheDir, artifactPrefix+stage+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	return dir, nil
+}
+
+// cacheEntry is one artifact in the cache directory.
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// listArtifacts returns the tool's artifacts in dir, oldest first.
+func listArtifacts(dir string) ([]cacheEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %v", err)
+	}
+	var artifacts []cacheEntry
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), artifactPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		artifacts = append(artifacts, cacheEntry{path: path, size: diskUsage(path), modTime: info.ModTime()})
+	}
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].modTime.Before(artifacts[j].modTime) })
+	return artifacts, nil
+}
+
+// diskUsage returns the total size of the files under path.
+func diskUsage(path string) int64 {
+	var total int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// cleanCache removes artifacts older than maxAge (0 keeps any age) and then the
+// oldest ones until the rest fit in maxSize bytes (0 means no limit). It returns
+// what it removed, or would remove with dryRun.
+func cleanCache(dir string, maxAge time.Duration, maxSize int64, dryRun bool) ([]cacheEntry, error) {
+	artifacts, err := listArtifacts(dir)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, a := range artifacts {
+		total += a.size
+	}
+	var removed []cacheEntry
+	for _, a := range artifacts {
+		tooOld := maxAge > 0 && time.Since(a.modTime) > maxAge
+		tooBig := maxSize > 0 && total > maxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(a.path); err != nil {
+				return removed, fmt.Errorf("failed to remove %s: %v", a.path, err)
+			}
+		}
+		total -= a.size
+		removed = append(removed, a)
+	}
+	return removed, nil
+}
+
+// cleanStaleArtifacts removes scratch directories left behind by runs that didn't
+// get to clean up after themselves.
+func (p *Pipeline) cleanStaleArtifacts() {
+	removed, err := cleanCache(p.config.CacheDir, staleArtifactAge, 0, false)
+	if err != nil {
+		p.console.warnf("failed to clean stale artifacts: %v\n", err)
+	}
+	if len(removed) > 0 {
+		p.console.progressf("Removed %d stale artifact(s) from %s\n", len(removed), p.config.CacheDir)
+	}
+}
+
+// checkDiskSpace fails if dir has less than need bytes free, plus some headroom.
+// Where free space can't be determined, the check passes.
+func checkDiskSpace(dir string, need int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	free, ok := freeDiskSpace(dir)
+	if !ok || free >= need+diskHeadroom {
+		return nil
+	}
+	return fmt.Errorf("not enough disk space in %s: about %s needed, %s free (set -cache-dir to use another disk)",
+		dir, formatBytes(need+diskHeadroom), formatBytes(free))
+}
+
+// formatBytes renders n in binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// runCache implements the cache subcommand.
+func runCache(args []string) error {
+	if len(args) == 0 || args[0] != "clean" {
+		return fmt.Errorf("usage: podcast-transcription cache clean [-cache-dir dir] [-older-than age] [-max-size size] [-dry-run]")
+	}
+	fs := flag.NewFlagSet("cache clean", flag.ExitOnError)
+	dir := fs.String("cache-dir", defaultCacheDir(), "Cache directory to clean")
+	olderThan := fs.Duration("older-than", 0, "Remove artifacts older than this, e.g. 168h (0 removes all unless -max-size is set)")
+	maxSize := fs.String("max-size", "", "Remove the oldest artifacts until the cache fits in this size, e.g. 5GiB; GB is a power of 1000, G and GiB of 1024")
+	dryRun := fs.Bool("dry-run", false, "List what would be removed without removing it")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var limit int64
+	if *maxSize != "" {
+		n, err := parseByteSize(*maxSize)
+		if err != nil {
+			return fmt.Errorf("-max-size: %v", err)
+		}
+		limit = n
+	}
+	age := *olderThan
+	if age == 0 && limit == 0 {
+		// No policy given: clear everything
+		age = time.Nanosecond
+	}
+
+	removed, err := cleanCache(*dir, age, limit, *dryRun)
+	var freed int64
+	for _, a := range removed {
+		freed += a.size
+		if *dryRun {
+			fmt.Printf("Would remove %s (%s)\n", a.path, formatBytes(a.size))
+		}
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d artifact(s), %s, from %s\n", verb, len(removed), formatBytes(freed), *dir)
+	return err
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// chapterLimits are the constraints -chapters enforces on the chapter list.
+// Lengths are in seconds; a max or count of zero is unlimited.
+type chapterLimits struct {
+	min, max float64
+	count    int
+}
+
+// chapterCut is the start of a chapter: the index of its first segment, and
+// its title when one is known.
+type chapterCut struct {
+	pos   int
+	title string
+}
+
+// chaptersPrompt asks for chapters of a podcast episode for podcast apps.
+const chaptersPrompt = `Divide the following podcast episode into chapters for podcast apps. Each chapter starts at the timestamp of a turn in the transcript, copied exactly, and the first at 00:00:00. Chapters should follow the topics of the conversation%s. Give each a title of a few words that says what it is about.
+%s
+Transcript:
+%s`
+
+// chaptersResponseFormat is the JSON schema of the chapter suggestions.
+var chaptersResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "chapters",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"chapters": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":  map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn the chapter starts at, copied from the transcript"},
+							"title": map[string]any{"type": "string", "description": "Chapter title of a few words"},
+						},
+						"required":             []string{"time", "title"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"chapters"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// chapterTitlesPrompt asks for titles of chapters the limits created.
+const chapterTitlesPrompt = `Give each numbered podcast chapter below a title of a few words that says what it is about.
+
+%s
+
+Respond with a JSON object whose "chapters" array has one entry per numbered chapter.`
+
+// chapterTitlesFormat is the JSON schema of the chapter titles reply.
+var chapterTitlesFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "chapter_titles",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"chapters": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"index": map[string]any{"type": "integer"},
+							"title": map[string]any{"type": "string"},
+						},
+						"required":             []string{"index", "title"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"chapters"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// buildChapters sets the chapters of t for -chapters. The provider's chapters,
+// or else the summary model's, are the suggestions; the limits are then
+// enforced on them, cutting and joining chapters where the vocabulary of the
+// conversation changes most and least, and chapters left without a title are
+// named by the model.
+func (p *Pipeline) buildChapters(ctx context.Context, apiKey string, t *Transcript, lim chapterLimits) (TokenUsage, error) {
+	var usage TokenUsage
+	if len(t.Segments) == 0 {
+		return usage, nil
+	}
+	var cuts []chapterCut
+	if len(t.Chapters) > 0 {
+		for _, c := range t.Chapters {
+			cuts = append(cuts, chapterCut{segmentAt(t.Segments, c.Start), strings.TrimSpace(c.Headline)})
+		}
+	} else {
+		suggested, u, err := p.suggestChapters(ctx, apiKey, t, lim)
+		usage.Add(u)
+		if err != nil {
+			return usage, err
+		}
+		cuts = suggested
+	}
+
+	cuts = enforceChapterLimits(t.Segments, cuts, boundaryDepths(t.Segments), lim)
+	u, err := p.titleChapters(ctx, apiKey, t, cuts)
+	usage.Add(u)
+	if err != nil {
+		return usage, err
+	}
+
+	end := t.Segments[len(t.Segments)-1].End
+	t.Chapters = make([]Chapter, len(cuts))
+	for i, c := range cuts {
+		start := t.Segments[c.pos].Start
+		if i == 0 {
+			start = 0
+		}
+		stop := end
+		if i+1 < len(cuts) {
+			stop = t.Segments[cuts[i+1].pos].Start
+		}
+		t.Chapters[i] = Chapter{Start: start, End: stop, Headline: c.title}
+	}
+	return usage, nil
+}
+
+// suggestChapters asks the summary model for chapters of t, as cuts at the
+// turns they start at.
+func (p *Pipeline) suggestChapters(ctx context.Context, apiKey string, t *Transcript, lim chapterLimits) ([]chapterCut, TokenUsage, error) {
+	var aim []string
+	if lim.min > 0 {
+		aim = append(aim, fmt.Sprintf("at least %s long", describeSeconds(lim.min)))
+	}
+	if lim.max > 0 {
+		aim = append(aim, fmt.Sprintf("at most %s long", describeSeconds(lim.max)))
+	}
+	length := ""
+	if len(aim) > 0 {
+		length = ", each " + strings.Join(aim, " and ")
+	}
+	if lim.count > 0 {
+		length += fmt.Sprintf(", with no more than %d chapters", lim.count)
+	}
+	var episode string
+	if t.Title != "" {
+		episode += "\nEpisode title: " + t.Title + "\n"
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(chaptersPrompt, length, episode, fitContext(formatTimedTurns(t.Segments), p.config.SummaryModel))}},
+		"temperature":     p.config.Temperature,
+		"response_format": chaptersResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to suggest chapters: %v", err)
+	}
+	var res struct {
+		Chapters []struct {
+			Time  string `json:"time"`
+			Title string `json:"title"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal([]byte(content), &res); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode chapters: %v", err)
+	}
+	var cuts []chapterCut
+	for _, c := range res.Chapters {
+		at, err := parseClock(c.Time)
+		if err != nil {
+			continue
+		}
+		cuts = append(cuts, chapterCut{segmentAt(t.Segments, at), strings.TrimSpace(c.Title)})
+	}
+	return cuts, usage, nil
+}
+
+// titleChapters asks the summary model for the titles of the chapters that
+// have none.
+func (p *Pipeline) titleChapters(ctx context.Context, apiKey string, t *Transcript, cuts []chapterCut) (TokenUsage, error) {
+	var lines []string
+	for i, c := range cuts {
+		if c.title != "" {
+			continue
+		}
+		next := len(t.Segments)
+		if i+1 < len(cuts) {
+			next = cuts[i+1].pos
+		}
+		excerpt := truncateWords(joinSegmentText(t.Segments[c.pos:next]), 300)
+		lines = append(lines, fmt.Sprintf("%d: %s", i, excerpt))
+	}
+	if len(lines) == 0 {
+		return TokenUsage{}, nil
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(chapterTitlesPrompt, strings.Join(lines, "\n\n"))}},
+		"temperature":     p.config.Temperature,
+		"response_format": chapterTitlesFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return usage, fmt.Errorf("failed to title chapters: %v", err)
+	}
+	var res struct {
+		Chapters []struct {
+			Index int    `json:"index"`
+			Title string `json:"title"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal([]byte(content), &res); err != nil {
+		return usage, fmt.Errorf("failed to decode chapter titles: %v", err)
+	}
+	for _, r := range res.Chapters {
+		if r.Index >= 0 && r.Index < len(cuts) && cuts[r.Index].title == "" {
+			cuts[r.Index].title = strings.TrimSpace(r.Title)
+		}
+	}
+	for i := range cuts {
+		if cuts[i].title == "" {
+			cuts[i].title = fmt.Sprintf("Part %d", i+1)
+		}
+	}
+	return usage, nil
+}
+
+// enforceChapterLimits turns suggested cuts into chapters that keep to lim.
+// The first chapter starts with the first segment. Chapters shorter than the
+// minimum are joined to the neighbor across the weaker topic boundary, those
+// longer than the maximum are cut at the strongest boundary that leaves both
+// parts long enough, and extra chapters are joined across the weakest
+// boundaries. depths are the boundary strengths of boundaryDepths.
+func enforceChapterLimits(segments []Segment, cuts []chapterCut, depths []float64, lim chapterLimits) []chapterCut {
+	sort.SliceStable(cuts, func(i, j int) bool { return cuts[i].pos < cuts[j].pos })
+	var kept []chapterCut
+	for _, c := range cuts {
+		if c.pos < 0 || c.pos >= len(segments) {
+			continue
+		}
+		if n := len(kept); n > 0 && kept[n-1].pos == c.pos {
+			if kept[n-1].title == "" {
+				kept[n-1].title = c.title
+			}
+			continue
+		}
+		kept = append(kept, c)
+	}
+	if len(kept) == 0 || kept[0].pos != 0 {
+		kept = append([]chapterCut{{pos: 0}}, kept...)
+	}
+	cuts = kept
+
+	end := segments[len(segments)-1].End
+	start := func(i int) float64 {
+		if i == 0 {
+			return 0
+		}
+		return segments[cuts[i].pos].Start
+	}
+	length := func(i int) float64 {
+		if i+1 < len(cuts) {
+			return start(i+1) - start(i)
+		}
+		return end - start(i)
+	}
+	// join removes the boundary at the start of chapter i, keeping the title
+	// of the chapter before it when it has one.
+	join := func(i int) {
+		if cuts[i-1].title == "" {
+			cuts[i-1].title = cuts[i].title
+		}
+		cuts = append(cuts[:i], cuts[i+1:]...)
+	}
+
+	for len(cuts) > 1 {
+		shortest := -1
+		for i := range cuts {
+			if length(i) < lim.min && (shortest < 0 || length(i) < length(shortest)) {
+				shortest = i
+			}
+		}
+		if shortest < 0 {
+			break
+		}
+		switch {
+		case shortest == 0:
+			join(1)
+		case shortest == len(cuts)-1 || depths[cuts[shortest].pos] <= depths[cuts[shortest+1].pos]:
+			join(shortest)
+		default:
+			join(shortest + 1)
+		}
+	}
+
+	if lim.max > 0 {
+		for i := 0; i < len(cuts); i++ {
+			if length(i) <= lim.max {
+				continue
+			}
+			from, to := start(i), start(i)+length(i)
+			next := len(segments)
+			if i+1 < len(cuts) {
+				next = cuts[i+1].pos
+			}
+			best := -1
+			for j := cuts[i].pos + 1; j < next; j++ {
+				at := segments[j].Start
+				if at-from < lim.min || to-at < lim.min {
+					continue
+				}
+				if best < 0 || depths[j] > depths[best] {
+					best = j
+				}
+			}
+			if best < 0 {
+				continue
+			}
+			cuts = append(cuts[:i+1], append([]chapterCut{{pos: best}}, cuts[i+1:]...)...)
+			// Check the first part again
+			i--
+		}
+	}
+
+	for lim.count > 0 && len(cuts) > lim.count {
+		weakest, fits := -1, false
+		for i := 1; i < len(cuts); i++ {
+			ok := lim.max == 0 || length(i-1)+length(i) <= lim.max
+			if weakest < 0 || (ok && !fits) || (ok == fits && depths[cuts[i].pos] < depths[cuts[weakest].pos]) {
+				weakest, fits = i, ok
+			}
+		}
+		join(weakest)
+	}
+	return cuts
+}
+
+// segmentAt returns the index of the segment at the given time: the last one
+// starting at or before it.
+func segmentAt(segments []Segment, at float64) int {
+	pos := 0
+	for i, s := range segments {
+		if s.Start > at {
+			break
+		}
+		pos = i
+	}
+	return pos
+}
+
+// describeSeconds writes a chapter length for a prompt, e.g. "5 minutes".
+func describeSeconds(s float64) string {
+	if s >= 60 {
+		return fmt.Sprintf("%g minutes", math.Round(s/6)/10)
+	}
+	return fmt.Sprintf("%g seconds", math.Round(s))
+}
+
+// renderPodcastChapters writes the chapters of t in the JSON chapters format
+// of the Podcasting 2.0 namespace, read by podcast apps from a
+// <podcast:chapters> link in the feed.
+func renderPodcastChapters(t *Transcript) ([]byte, error) {
+	type chapter struct {
+		StartTime float64 `json:"startTime"`
+		EndTime   float64 `json:"endTime,omitempty"`
+		Title     string  `json:"title"`
+	}
+	doc := struct {
+		Version  string    `json:"version"`
+		Chapters []chapter `json:"chapters"`
+	}{Version: "1.2.0", Chapters: []chapter{}}
+	for _, c := range t.Chapters {
+		doc.Chapters = append(doc.Chapters, chapter{StartTime: math.Round(c.Start*1000) / 1000, EndTime: math.Round(c.End*1000) / 1000, Title: c.Headline})
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
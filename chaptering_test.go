@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnforceChapterLimits(t *testing.T) {
+	// Ten minute-long segments, with the strongest topic changes at 3:00 and 7:00
+	var segments []Segment
+	for i := 0; i < 10; i++ {
+		segments = append(segments, Segment{Start: float64(i * 60), End: float64(i*60 + 60)})
+	}
+	depths := []float64{0, 0.1, 0.2, 0.9, 0.1, 0.2, 0.3, 0.8, 0.1, 0.2}
+	tests := []struct {
+		name string
+		cuts []chapterCut
+		lim  chapterLimits
+		want []chapterCut
+	}{
+		{"first chapter added", []chapterCut{{7, "C"}, {3, "B"}, {20, "lost"}, {3, ""}}, chapterLimits{}, []chapterCut{{0, ""}, {3, "B"}, {7, "C"}}},
+		{"short first joined", []chapterCut{{1, "A"}, {3, "B"}, {7, "C"}}, chapterLimits{min: 120}, []chapterCut{{0, "A"}, {3, "B"}, {7, "C"}}},
+		{"short joined across the weaker boundary", []chapterCut{{0, "Intro"}, {4, "B"}, {5, "C"}}, chapterLimits{min: 120}, []chapterCut{{0, "Intro"}, {5, "C"}}},
+		{"long cut at the strongest boundary", nil, chapterLimits{min: 60, max: 240}, []chapterCut{{0, ""}, {3, ""}, {7, ""}}},
+		{"long kept without room to cut", nil, chapterLimits{min: 310, max: 240}, []chapterCut{{0, ""}}},
+		{"extra joined across the weakest", []chapterCut{{0, ""}, {3, ""}, {7, ""}}, chapterLimits{count: 2}, []chapterCut{{0, ""}, {3, ""}}},
+		{"extra joined where it fits the maximum", []chapterCut{{0, ""}, {3, ""}, {5, ""}}, chapterLimits{max: 400, count: 2}, []chapterCut{{0, ""}, {5, ""}}},
+	}
+	for _, tt := range tests {
+		got := enforceChapterLimits(segments, append([]chapterCut(nil), tt.cuts...), depths, tt.lim)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: enforceChapterLimits = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSegmentAt(t *testing.T) {
+	segments := []Segment{{Start: 0, End: 60}, {Start: 60, End: 120}, {Start: 120, End: 180}}
+	tests := []struct {
+		at   float64
+		want int
+	}{
+		{-5, 0},
+		{0, 0},
+		{59.9, 0},
+		{60, 1},
+		{1000, 2},
+	}
+	for _, tt := range tests {
+		if got := segmentAt(segments, tt.at); got != tt.want {
+			t.Errorf("segmentAt(%g) = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeSeconds(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{300, "5 minutes"},
+		{90, "1.5 minutes"},
+		{60, "1 minutes"},
+		{45.4, "45 seconds"},
+	}
+	for _, tt := range tests {
+		if got := describeSeconds(tt.in); got != tt.want {
+			t.Errorf("describeSeconds(%g) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
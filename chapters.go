@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Topic segmentation parameters: the vocabulary of topicWindow seconds on each
+// side of a segment boundary is compared, TextTiling-style, and boundaries are
+// kept at least minChapterLength seconds apart.
+const (
+	topicWindow      = 120.0
+	minChapterLength = 300.0
+)
+
+// topicStopwords are common words ignored when comparing vocabulary, since
+// they say nothing about the topic.
+var topicStopwords = map[string]bool{
+	"about": true, "after": true, "again": true, "also": true, "because": true, "been": true,
+	"being": true, "could": true, "didn't": true, "does": true, "doing": true, "don't": true,
+	"even": true, "from": true, "going": true, "good": true, "have": true, "here": true,
+	"into": true, "it's": true, "just": true, "know": true, "like": true, "lot": true,
+	"make": true, "mean": true, "more": true, "much": true, "okay": true, "only": true,
+	"really": true, "right": true, "said": true, "same": true, "some": true, "something": true,
+	"that": true, "that's": true, "their": true, "them": true, "then": true, "there": true,
+	"these": true, "they": true, "thing": true, "things": true, "think": true, "this": true,
+	"those": true, "very": true, "want": true, "well": true, "were": true, "what": true,
+	"when": true, "where": true, "which": true, "while": true, "will": true, "with": true,
+	"would": true, "yeah": true, "your": true, "you're": true,
+}
+
+// topicWords returns the content words of text, lowercased.
+func topicWords(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		w = strings.Trim(w, "'")
+		if len(w) > 3 && !topicStopwords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// detectChapters splits segments at the boundaries where the vocabulary
+// changes most, returning the chapters' segments in order. A transcript
+// shorter than two chapters is returned whole.
+func detectChapters(segments []Segment) [][]Segment {
+	if len(segments) < 2 || segments[len(segments)-1].End-segments[0].Start < 2*minChapterLength {
+		return [][]Segment{segments}
+	}
+	depths := boundaryDepths(segments)
+	type candidate struct {
+		pos   int
+		depth float64
+	}
+	var candidates []candidate
+	var sum, sumSq float64
+	for i := 1; i < len(segments); i++ {
+		candidates = append(candidates, candidate{i, depths[i]})
+		sum += depths[i]
+		sumSq += depths[i] * depths[i]
+	}
+	mean := sum / float64(len(candidates))
+	cutoff := mean + math.Sqrt(max(0, sumSq/float64(len(candidates))-mean*mean))/2
+
+	// Keep the deepest boundaries that leave every chapter long enough
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].depth > candidates[j].depth })
+	start, end := segments[0].Start, segments[len(segments)-1].End
+	var cuts []int
+	for _, c := range candidates {
+		if c.depth <= cutoff {
+			break
+		}
+		at := segments[c.pos].Start
+		if at-start < minChapterLength || end-at < minChapterLength {
+			continue
+		}
+		ok := true
+		for _, cut := range cuts {
+			if math.Abs(segments[cut].Start-at) < minChapterLength {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			cuts = append(cuts, c.pos)
+		}
+	}
+	sort.Ints(cuts)
+	var chapters [][]Segment
+	prev := 0
+	for _, cut := range cuts {
+		chapters = append(chapters, segments[prev:cut])
+		prev = cut
+	}
+	return append(chapters, segments[prev:])
+}
+
+// boundaryDepths scores the boundary before each segment by how much the
+// vocabulary changes there: how far the similarity of the topicWindow on
+// either side dips below the peaks around it. The first segment scores 0.
+func boundaryDepths(segments []Segment) []float64 {
+	words := make([][]string, len(segments))
+	for i, s := range segments {
+		words[i] = topicWords(s.Text)
+	}
+
+	// Similarity of the windows either side of the boundary before segment i
+	sim := make([]float64, len(segments))
+	for i := 1; i < len(segments); i++ {
+		left, right := map[string]int{}, map[string]int{}
+		for j := i - 1; j >= 0 && segments[i].Start-segments[j].Start <= topicWindow; j-- {
+			for _, w := range words[j] {
+				left[w]++
+			}
+		}
+		for j := i; j < len(segments) && segments[j].End-segments[i].Start <= topicWindow; j++ {
+			for _, w := range words[j] {
+				right[w]++
+			}
+		}
+		sim[i] = cosine(left, right)
+	}
+
+	depths := make([]float64, len(segments))
+	for i := 1; i < len(segments); i++ {
+		leftPeak, rightPeak := sim[i], sim[i]
+		for j := i - 1; j >= 1 && sim[j] >= leftPeak; j-- {
+			leftPeak = sim[j]
+		}
+		for j := i + 1; j < len(segments) && sim[j] >= rightPeak; j++ {
+			rightPeak = sim[j]
+		}
+		depths[i] = leftPeak + rightPeak - 2*sim[i]
+	}
+	return depths
+}
+
+// cosine returns the cosine similarity of two word-count vectors.
+func cosine(a, b map[string]int) float64 {
+	var dot, na, nb float64
+	for w, n := range a {
+		dot += float64(n * b[w])
+		na += float64(n * n)
+	}
+	for _, n := range b {
+		nb += float64(n * n)
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// chaptersOf groups segments by the provider's chapters, when it detected
+// any, or by detectChapters.
+func chaptersOf(t *Transcript) [][]Segment {
+	if len(t.Chapters) < 2 {
+		return detectChapters(t.Segments)
+	}
+	var chapters [][]Segment
+	var cur []Segment
+	next := 1
+	for _, s := range t.Segments {
+		if next < len(t.Chapters) && s.Start >= t.Chapters[next].Start && len(cur) > 0 {
+			chapters = append(chapters, cur)
+			cur = nil
+			for next < len(t.Chapters) && s.Start >= t.Chapters[next].Start {
+				next++
+			}
+		}
+		cur = append(cur, s)
+	}
+	if len(cur) > 0 {
+		chapters = append(chapters, cur)
+	}
+	return chapters
+}
+
+// speakerRecap summarizes who has said what in turns, for the next chapter's
+// prompt: each speaker's number of turns, first words and latest words,
+// followed by the last few turns.
+func speakerRecap(turns []Segment) string {
+	var order []string
+	count := map[string]int{}
+	first, latest := map[string]string{}, map[string]string{}
+	for _, t := range turns {
+		if t.Speaker == "" || t.Kind == eventKind {
+			continue
+		}
+		if count[t.Speaker] == 0 {
+			order = append(order, t.Speaker)
+			first[t.Speaker] = truncateWords(t.Text, 25)
+		}
+		count[t.Speaker]++
+		latest[t.Speaker] = truncateWords(t.Text, 25)
+	}
+	var b strings.Builder
+	b.WriteString("Speakers so far, with what each said first and most recently:\n")
+	for _, s := range order {
+		fmt.Fprintf(&b, "- %s (%d turn(s)): first %q; latest %q\n", s, count[s], first[s], latest[s])
+	}
+	b.WriteString("\nThe previous chapter ended:\n")
+	b.WriteString(formatTurns(turns[max(0, len(turns)-contextLines):]))
+	return b.String()
+}
+
+// diarizeByChapter diarizes transcript one chapter at a time, splitting a
+// chapter further only when it is too long for one reply. Each request gets a
+// recap of the speakers so far, which keeps labels consistent over long
+// episodes better than the tail of the previous part alone.
+func (p *Pipeline) diarizeByChapter(ctx context.Context, apiKey string, transcript *Transcript, numSpeakers int) ([]Segment, TokenUsage, error) {
+	budget := p.diarizationBudget(p.config.DiarizationModel)
+	chapters := chaptersOf(transcript)
+	var (
+		turns []Segment
+		usage TokenUsage
+	)
+	for i, chapter := range chapters {
+		for _, part := range splitSegments(chapter, budget) {
+			var previous string
+			if len(turns) > 0 {
+				previous = speakerRecap(turns)
+			}
+			partTurns, u, err := p.diarizeVerified(ctx, apiKey, paragraphText(part), previous, pauseHints(part, p.config.SpeakerGap), numSpeakers)
+			usage.Add(u)
+			if err != nil {
+				return nil, usage, fmt.Errorf("chapter %d/%d: %v", i+1, len(chapters), err)
+			}
+			turns = append(turns, partTurns...)
+		}
+		if len(chapters) > 1 {
+			p.console.progressf("Diarized chapter %d/%d\n", i+1, len(chapters))
+		}
+	}
+	return turns, usage, nil
+}
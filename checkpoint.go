@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// checkpointFile holds the chunks a chunked run has finished, so that a run
+// stopped part way, by a SIGTERM on a preempted VM or a crash, resumes after
+// the last finished chunk instead of transcribing everything again. It is
+// removed once the whole transcription is saved.
+const checkpointFile = "checkpoint.json"
+
+// chunkCheckpoint is the progress of a chunked run.
+type chunkCheckpoint struct {
+	// Source, Model and ChunkSeconds are what the chunks were made from; a
+	// checkpoint of other audio or other settings is started over.
+	Source       string  `json:"source_sha256"`
+	Model        string  `json:"transcription_model"`
+	ChunkSeconds float64 `json:"chunk_seconds"`
+	// Diarization identifies the settings the chunks were diarized with;
+	// with others, their transcripts are kept but they're diarized again.
+	Diarization string            `json:"diarization"`
+	Chunks      []checkpointChunk `json:"chunks"`
+
+	path string
+	// key encrypts the checkpoint at rest, as -encryption-key does outputs.
+	key []byte
+	mu  sync.Mutex
+}
+
+// checkpointChunk is one finished chunk.
+type checkpointChunk struct {
+	Transcript *Transcript `json:"transcript,omitempty"`
+	// Turns are the chunk's diarization, and Context the end of it passed to
+	// the next chunk's, once the chunk is diarized.
+	Turns   []Segment `json:"turns,omitempty"`
+	Context string    `json:"context,omitempty"`
+}
+
+// loadCheckpoint reads the checkpoint at path if it belongs to this run,
+// and otherwise returns an empty one that will be saved there. diarization
+// is what diarizationSettings returns for the run.
+func loadCheckpoint(path string, key []byte, source *AudioFingerprint, model string, chunkSeconds float64, diarization string) (*chunkCheckpoint, error) {
+	c := &chunkCheckpoint{Model: model, ChunkSeconds: chunkSeconds, Diarization: diarization, path: path, key: key}
+	if source != nil {
+		c.Source = source.SHA256
+	}
+	data, err := readStored(key, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	var saved chunkCheckpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return c, fmt.Errorf("failed to parse checkpoint %s: %v", path, err)
+	}
+	if saved.Source == c.Source && saved.Model == model && saved.ChunkSeconds == chunkSeconds {
+		c.Chunks = saved.Chunks
+		if saved.Diarization != diarization {
+			for i := range c.Chunks {
+				c.Chunks[i].Turns, c.Chunks[i].Context = nil, ""
+			}
+		}
+	}
+	return c, nil
+}
+
+// diarizationSettings returns a hash of the settings the chunks' turns
+// depend on: the model and its sampling, the number of speakers, what goes
+// into the prompt besides the transcript, the cleanup it's diarized after,
+// how it's split into requests and how the replies are verified.
+func (p *Pipeline) diarizationSettings(numSpeakers int, cleanupMode string) string {
+	cfg := p.config
+	data, _ := json.Marshal([]any{cfg.DiarizationModel, numSpeakers, cfg.PromptTemplate, cfg.Examples, cfg.StructuredOutput,
+		cfg.Temperature, cfg.TopP, cfg.Seed, cfg.SpeakerNames, cfg.Guests, cfg.Vocabulary, cfg.Title, cfg.Description,
+		cleanupMode, cfg.SpeakerGap, cfg.MaxPromptTokens, cfg.MaxOutputTokens, cfg.ChapterDiarization,
+		cfg.VerifyWords, cfg.VerifyRetries, cfg.MaxWordDrift, cfg.Verbatim})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chunk returns the checkpointed chunk i, or nil if it isn't done.
+func (c *chunkCheckpoint) chunk(i int) *checkpointChunk {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i >= len(c.Chunks) || c.Chunks[i].Transcript == nil {
+		return nil
+	}
+	ch := c.Chunks[i]
+	return &ch
+}
+
+// transcribed records the transcription of chunk i.
+func (c *chunkCheckpoint) transcribed(i int, t *Transcript) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.Chunks) <= i {
+		c.Chunks = append(c.Chunks, checkpointChunk{})
+	}
+	c.Chunks[i].Transcript = t
+	return c.save()
+}
+
+// diarized records the diarization of chunk i.
+func (c *chunkCheckpoint) diarized(i int, turns []Segment, context string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i >= len(c.Chunks) {
+		return nil
+	}
+	c.Chunks[i].Turns, c.Chunks[i].Context = turns, context
+	return c.save()
+}
+
+// save writes the checkpoint by renaming a temporary file into place, so that
+// a run killed while saving leaves the previous one.
+func (c *chunkCheckpoint) save() error {
+	data, err := json.Marshal(c)
+	if err == nil {
+		data, err = sealStored(c.key, data)
+	}
+	if err == nil {
+		err = writeFileAtomic(c.path, data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint once it's no longer needed.
+func (c *chunkCheckpoint) remove() {
+	if c != nil {
+		os.Remove(c.path)
+	}
+}
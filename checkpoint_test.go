@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDiarizationSettings(t *testing.T) {
+	seed := int64(7)
+	base := func() *Config {
+		return &Config{DiarizationModel: "gpt-4o", Temperature: 0.2, SpeakerGap: 1.5, VerifyWords: true, VerifyRetries: 2, MaxWordDrift: 0.02}
+	}
+	want := (&Pipeline{config: base()}).diarizationSettings(2, "")
+	if got := (&Pipeline{config: base()}).diarizationSettings(2, ""); got != want {
+		t.Errorf("the same settings hashed %s and %s", want, got)
+	}
+	tests := []struct {
+		name        string
+		change      func(*Config)
+		numSpeakers int
+		cleanupMode string
+	}{
+		{"speakers", func(*Config) {}, 3, ""},
+		{"cleanup", func(*Config) {}, 2, "llm"},
+		{"model", func(c *Config) { c.DiarizationModel = "gpt-4.1" }, 2, ""},
+		{"top_p", func(c *Config) { c.TopP = 0.9 }, 2, ""},
+		{"seed", func(c *Config) { c.Seed = &seed }, 2, ""},
+		{"speaker gap", func(c *Config) { c.SpeakerGap = 3 }, 2, ""},
+		{"prompt tokens", func(c *Config) { c.MaxPromptTokens = 4000 }, 2, ""},
+		{"output tokens", func(c *Config) { c.MaxOutputTokens = 2000 }, 2, ""},
+		{"by chapter", func(c *Config) { c.ChapterDiarization = true }, 2, ""},
+		{"verify", func(c *Config) { c.VerifyWords = false }, 2, ""},
+		{"verify retries", func(c *Config) { c.VerifyRetries = 5 }, 2, ""},
+		{"word drift", func(c *Config) { c.MaxWordDrift = 0.1 }, 2, ""},
+		{"vocabulary", func(c *Config) { c.Vocabulary = []string{"Kubernetes"} }, 2, ""},
+	}
+	for _, tt := range tests {
+		cfg := base()
+		tt.change(cfg)
+		if got := (&Pipeline{config: cfg}).diarizationSettings(tt.numSpeakers, tt.cleanupMode); got == want {
+			t.Errorf("changing the %s kept the hash", tt.name)
+		}
+	}
+}
+
+func TestLoadCheckpointSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), checkpointFile)
+	source := &AudioFingerprint{SHA256: "abc"}
+	c, err := loadCheckpoint(path, nil, source, "whisper-1", 600, "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.transcribed(0, &Transcript{Text: "hello"})
+	c.diarized(0, []Segment{{Speaker: "Speaker 1", Text: "hello"}}, "Speaker 1: hello")
+
+	tests := []struct {
+		name                 string
+		model                string
+		chunkSeconds         float64
+		diarization          string
+		transcript, diarized bool
+	}{
+		{"same run", "whisper-1", 600, "settings", true, true},
+		{"other diarization", "whisper-1", 600, "other", true, false},
+		{"other model", "gpt-4o-transcribe", 600, "settings", false, false},
+		{"other chunk length", "whisper-1", 300, "settings", false, false},
+	}
+	for _, tt := range tests {
+		c, err := loadCheckpoint(path, nil, source, tt.model, tt.chunkSeconds, tt.diarization)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		ch := c.chunk(0)
+		if got := ch != nil; got != tt.transcript {
+			t.Errorf("%s: chunk kept = %v, want %v", tt.name, got, tt.transcript)
+			continue
+		}
+		if ch != nil && (len(ch.Turns) > 0) != tt.diarized {
+			t.Errorf("%s: turns kept = %v, want %v", tt.name, len(ch.Turns) > 0, tt.diarized)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// seamMargin is how close to a chunk cut speech can run before a word may
+// have been split by it.
+const seamMargin = 0.2
+
+// chunkReport records how a -chunk run cut the audio and stitched the chunks
+// back together, for auditing that nothing was lost at the seams.
+type chunkReport struct {
+	ChunkSeconds float64       `json:"chunk_seconds"`
+	Duration     float64       `json:"duration"`
+	Chunks       []chunkRecord `json:"chunks"`
+	Seams        []chunkSeam   `json:"seams"`
+}
+
+// chunkRecord is one transcribed chunk.
+type chunkRecord struct {
+	Index    int     `json:"index"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Segments int     `json:"segments"`
+	Words    int     `json:"words"`
+	// FirstSpeech and LastSpeech bound the transcribed speech in the chunk.
+	FirstSpeech float64 `json:"first_speech,omitempty"`
+	LastSpeech  float64 `json:"last_speech,omitempty"`
+}
+
+// chunkSeam is the cut between two chunks. Chunks are cut back to back, so
+// Overlap holds words transcribed on both sides of the cut: a phrase that was
+// duplicated.
+type chunkSeam struct {
+	At float64 `json:"at"`
+	// GapBefore and GapAfter are the silence between the cut and the speech
+	// on either side of it.
+	GapBefore     float64 `json:"gap_before"`
+	GapAfter      float64 `json:"gap_after"`
+	TextBefore    string  `json:"text_before,omitempty"`
+	TextAfter     string  `json:"text_after,omitempty"`
+	SpeakerBefore string  `json:"speaker_before,omitempty"`
+	SpeakerAfter  string  `json:"speaker_after,omitempty"`
+	Overlap       string  `json:"overlap,omitempty"`
+	// Status is "clean", "speech at cut" when a word may have been split, or
+	// "possible duplicate" when the same words end one chunk and start the
+	// next.
+	Status string `json:"status"`
+	// Stitch is how the chunks were joined.
+	Stitch string `json:"stitch"`
+}
+
+// buildChunkReport describes the chunks of chunkSeconds that segments were
+// transcribed in, and the seams between them. turns, the diarized turns, give
+// the speakers on either side of each seam.
+func buildChunkReport(segments, turns []Segment, duration, chunkSeconds float64) *chunkReport {
+	r := &chunkReport{ChunkSeconds: chunkSeconds, Duration: duration}
+	for i := 0; i < chunkCount(duration, chunkSeconds); i++ {
+		start := float64(i) * chunkSeconds
+		c := chunkRecord{Index: i + 1, Start: start, End: math.Min(start+chunkSeconds, duration)}
+		for _, s := range chunkSegments(segments, c.Start, c.End) {
+			if c.Segments == 0 {
+				c.FirstSpeech = s.Start
+			}
+			c.Segments++
+			c.Words += len(strings.Fields(s.Text))
+			c.LastSpeech = s.End
+		}
+		r.Chunks = append(r.Chunks, c)
+	}
+	for i := 1; i < len(r.Chunks); i++ {
+		before := chunkSegments(segments, r.Chunks[i-1].Start, r.Chunks[i-1].End)
+		after := chunkSegments(segments, r.Chunks[i].Start, r.Chunks[i].End)
+		seam := chunkSeam{At: r.Chunks[i].Start, Status: "clean", Stitch: "concatenated"}
+		if len(turns) > 0 {
+			seam.Stitch = "concatenated; speakers carried over from the end of the previous chunk's diarization"
+		}
+		if len(before) == 0 || len(after) == 0 {
+			seam.Stitch += "; no speech on one side"
+			r.Seams = append(r.Seams, seam)
+			continue
+		}
+		last, first := before[len(before)-1], after[0]
+		seam.GapBefore = math.Round(math.Max(0, seam.At-last.End)*1000) / 1000
+		seam.GapAfter = math.Round(math.Max(0, first.Start-seam.At)*1000) / 1000
+		seam.TextBefore = lastWords(last.Text, 8)
+		seam.TextAfter = firstWords(first.Text, 8)
+		seam.SpeakerBefore = speakerAt(turns, last.End-0.01)
+		seam.SpeakerAfter = speakerAt(turns, first.Start+0.01)
+		if overlap := repeatedAcross(last.Text, first.Text); overlap != "" {
+			seam.Overlap = overlap
+			seam.Status = "possible duplicate"
+		} else if seam.GapBefore < seamMargin && seam.GapAfter < seamMargin {
+			seam.Status = "speech at cut"
+		}
+		r.Seams = append(r.Seams, seam)
+	}
+	return r
+}
+
+// chunkSegments returns the segments that start in the chunk from start to end.
+func chunkSegments(segments []Segment, start, end float64) []Segment {
+	var in []Segment
+	for _, s := range segments {
+		if s.Start >= start && s.Start < end && s.Kind == "" {
+			in = append(in, s)
+		}
+	}
+	return in
+}
+
+func firstWords(text string, n int) string {
+	words := strings.Fields(text)
+	if len(words) <= n {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:n], " ") + " …"
+}
+
+// speakerAt returns the speaker of the turn at the given time.
+func speakerAt(turns []Segment, at float64) string {
+	for _, t := range turns {
+		if t.Start <= at && at < t.End {
+			return t.Speaker
+		}
+	}
+	return ""
+}
+
+// repeatedAcross returns the longest run of at least two words that ends
+// before and starts after, which Whisper produces when both chunks transcribe
+// the same phrase at a cut.
+func repeatedAcross(before, after string) string {
+	tail := strings.Fields(normalizeWords(before))
+	head := strings.Fields(normalizeWords(after))
+	for n := min(len(tail), len(head), 12); n >= 2; n-- {
+		if strings.Join(tail[len(tail)-n:], " ") == strings.Join(head[:n], " ") {
+			return strings.Join(head[:n], " ")
+		}
+	}
+	return ""
+}
+
+// normalizeWords lowercases text and strips punctuation, for comparing words.
+func normalizeWords(text string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(".,!?;:\"()…", r) {
+			return -1
+		}
+		return r
+	}, strings.ToLower(text))
+}
+
+// warnings returns a line for each seam that needs checking.
+func (r *chunkReport) warnings() []string {
+	var lines []string
+	for _, s := range r.Seams {
+		if s.Status != "clean" {
+			lines = append(lines, fmt.Sprintf("chunk seam at %s: %s", formatTimestamp(s.At, ".")[:8], s.Status))
+		}
+	}
+	return lines
+}
+
+// render returns the report as written to the report file, with its times
+// shifted by offset seconds into the episode.
+func (r *chunkReport) render(offset float64) ([]byte, error) {
+	for i := range r.Chunks {
+		c := &r.Chunks[i]
+		c.Start += offset
+		c.End += offset
+		if c.Segments > 0 {
+			c.FirstSpeech += offset
+			c.LastSpeech += offset
+		}
+	}
+	for i := range r.Seams {
+		r.Seams[i].At += offset
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
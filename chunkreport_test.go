@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestChunkReportSeams(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after Segment
+		status        string
+		overlap       string
+	}{
+		{"clean", Segment{Start: 50, End: 58, Text: "and that's the news."}, Segment{Start: 61, End: 65, Text: "Next up, sports."}, "clean", ""},
+		{"speech at cut", Segment{Start: 50, End: 59.95, Text: "we were talking about"}, Segment{Start: 60.05, End: 64, Text: "the weather today"}, "speech at cut", ""},
+		{"possible duplicate", Segment{Start: 52, End: 60, Text: "and then we went to the beach."}, Segment{Start: 60, End: 63, Text: "To the beach, and swam."}, "possible duplicate", "to the beach"},
+		{"single word repeated", Segment{Start: 52, End: 58, Text: "it was fine"}, Segment{Start: 61, End: 63, Text: "fine weather"}, "clean", ""},
+	}
+	turns := []Segment{{Start: 0, End: 60, Speaker: "Alice"}, {Start: 60, End: 120, Speaker: "Bob"}}
+	for _, tt := range tests {
+		r := buildChunkReport([]Segment{tt.before, tt.after}, turns, 120, 60)
+		if len(r.Chunks) != 2 || len(r.Seams) != 1 {
+			t.Errorf("%s: %d chunks and %d seams, want 2 and 1", tt.name, len(r.Chunks), len(r.Seams))
+			continue
+		}
+		s := r.Seams[0]
+		if s.Status != tt.status || s.Overlap != tt.overlap {
+			t.Errorf("%s: seam is %q with overlap %q, want %q with %q", tt.name, s.Status, s.Overlap, tt.status, tt.overlap)
+		}
+		if s.SpeakerBefore != "Alice" || s.SpeakerAfter != "Bob" {
+			t.Errorf("%s: speakers %q and %q at the seam, want Alice and Bob", tt.name, s.SpeakerBefore, s.SpeakerAfter)
+		}
+		if warned := len(r.warnings()) > 0; warned != (tt.status != "clean") {
+			t.Errorf("%s: warned %v for a %s seam", tt.name, warned, tt.status)
+		}
+	}
+}
+
+func TestChunkReportRecords(t *testing.T) {
+	segments := []Segment{
+		{Start: 1, End: 4, Text: "one two three"},
+		{Start: 5, End: 8, Text: "four five"},
+		{Start: 5, End: 6, Text: "[laughter]", Kind: eventKind},
+		{Start: 12, End: 14, Text: "six"},
+	}
+	r := buildChunkReport(segments, nil, 25, 10)
+	want := []chunkRecord{
+		{Index: 1, Start: 0, End: 10, Segments: 2, Words: 5, FirstSpeech: 1, LastSpeech: 8},
+		{Index: 2, Start: 10, End: 20, Segments: 1, Words: 1, FirstSpeech: 12, LastSpeech: 14},
+		{Index: 3, Start: 20, End: 25},
+	}
+	if len(r.Chunks) != len(want) {
+		t.Fatalf("%d chunks, want %d", len(r.Chunks), len(want))
+	}
+	for i, c := range r.Chunks {
+		if c != want[i] {
+			t.Errorf("chunk %d = %+v, want %+v", i+1, c, want[i])
+		}
+	}
+	if s := r.Seams[1]; s.Status != "clean" || s.Stitch != "concatenated; no speech on one side" {
+		t.Errorf("seam into the silent chunk = %+v", s)
+	}
+}
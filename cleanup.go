@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Paragraphing thresholds of the rule-based cleanup: a pause of paragraphGap
+// seconds, or paragraphSentences sentences, starts a new paragraph.
+const (
+	paragraphGap       = 1.5
+	paragraphSentences = 5
+)
+
+// cleanupChunkTokens is the size of the transcript chunks sent to the model by the
+// LLM cleanup.
+const cleanupChunkTokens = 1500
+
+// cleanupPrompt asks the model to fix formatting without touching the words.
+const cleanupPrompt = `Below is a raw speech-to-text transcript excerpt. Restore sentence punctuation and capitalization, split run-on sentences, and break the text into readable paragraphs separated by blank lines.
+
+Do not add, remove, reorder, or replace any words. Only change punctuation, capitalization, and line breaks. Respond with the formatted text only.
+
+Transcript:
+%s`
+
+var (
+	spaceBeforePunct = regexp.MustCompile(`\s+([,.;:!?])`)
+	loneI            = regexp.MustCompile(`\bi\b('(m|ve|ll|d))?`)
+	multiSpace       = regexp.MustCompile(`\s+`)
+	paragraphBreak   = regexp.MustCompile(`\n\s*\n`)
+)
+
+// cleanupTranscript runs the formatting pass selected with -cleanup ("rules" or
+// "llm") over the transcription segments and rebuilds the transcript text with
+// paragraph breaks. LLM output for a chunk that changed the words is discarded
+// in favour of the rule-based result.
+func (p *Pipeline) cleanupTranscript(ctx context.Context, apiKey string, t *Transcript, mode string) (TokenUsage, error) {
+	var usage TokenUsage
+	switch mode {
+	case "rules":
+		cleanupRules(t.Segments)
+	case "llm":
+		cleanupRules(t.Segments)
+		// Cut long segments up front, so each chunk is a window on t.Segments
+		t.Segments = splitLongSegments(t.Segments, cleanupChunkTokens)
+		for _, chunk := range splitSegments(t.Segments, cleanupChunkTokens) {
+			u, err := p.cleanupLLM(ctx, apiKey, chunk)
+			usage.Add(u)
+			if err != nil {
+				return usage, err
+			}
+		}
+	default:
+		return usage, fmt.Errorf("unknown cleanup mode %q (available: rules, llm)", mode)
+	}
+	t.Text = paragraphText(t.Segments)
+	return usage, nil
+}
+
+// cleanupRules normalises spacing, capitalises sentence starts and the pronoun
+// "I", and marks paragraph starts at long pauses or every few sentences.
+func cleanupRules(segments []Segment) {
+	sentenceStart := true
+	sentences := 0
+	for i := range segments {
+		s := &segments[i]
+		if s.Kind == eventKind {
+			continue
+		}
+		if i > 0 && !s.Paragraph {
+			prev := segments[i-1]
+			if s.Start-prev.End >= paragraphGap || (sentences >= paragraphSentences && endsSentence(prev.Text)) {
+				s.Paragraph = true
+			}
+		}
+		if s.Paragraph {
+			sentences = 0
+			sentenceStart = true
+		}
+		text := multiSpace.ReplaceAllString(strings.TrimSpace(s.Text), " ")
+		text = spaceBeforePunct.ReplaceAllString(text, "$1")
+		text = loneI.ReplaceAllStringFunc(text, func(m string) string { return "I" + m[1:] })
+
+		words := strings.Fields(text)
+		for j, w := range words {
+			if sentenceStart {
+				words[j] = capitalize(w)
+			}
+			sentenceStart = endsSentence(w)
+			if sentenceStart {
+				sentences++
+			}
+		}
+		s.Text = strings.Join(words, " ")
+	}
+}
+
+// cleanupLLM reformats one chunk of segments with the chat model and writes the
+// formatted words back onto the segments.
+func (p *Pipeline) cleanupLLM(ctx context.Context, apiKey string, chunk []Segment) (TokenUsage, error) {
+	source := joinSegmentText(chunk)
+	payload := map[string]interface{}{
+		"model":       p.config.DiarizationModel,
+		"messages":    []map[string]string{{"role": "user", "content": fmt.Sprintf(cleanupPrompt, source)}},
+		"temperature": 0,
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.chatTimeout(estimateTokens(source)))
+	defer cancel()
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return usage, fmt.Errorf("failed to clean up transcript: %v", err)
+	}
+
+	// Split the reply into words, remembering which ones start a paragraph.
+	// Stray punctuation tokens are attached to the preceding word.
+	var words []string
+	var paragraphStarts []bool
+	for _, para := range paragraphBreak.Split(strings.TrimSpace(content), -1) {
+		first := true
+		for _, w := range strings.Fields(para) {
+			if normalizeWord(w) == "" && len(words) > 0 {
+				words[len(words)-1] += w
+				continue
+			}
+			words = append(words, w)
+			paragraphStarts = append(paragraphStarts, first)
+			first = false
+		}
+	}
+	if !sameWords(normalizedWords(source), words) {
+		p.console.warnf("LLM cleanup changed the words of a chunk; keeping the rule-based formatting\n")
+		return usage, nil
+	}
+
+	// Hand the formatted words back out, segment by segment
+	pos := 0
+	pendingBreak := false
+	for i := range chunk {
+		s := &chunk[i]
+		n := len(normalizedWords(s.Text))
+		if s.Kind == eventKind || n == 0 {
+			continue
+		}
+		if pos > 0 {
+			// Breaks the model put inside a segment move to the next segment start
+			s.Paragraph = paragraphStarts[pos] || pendingBreak
+			pendingBreak = false
+		}
+		for _, start := range paragraphStarts[pos+1 : pos+n] {
+			pendingBreak = pendingBreak || start
+		}
+		s.Text = strings.Join(words[pos:pos+n], " ")
+		pos += n
+	}
+	return usage, nil
+}
+
+// sameWords reports whether the formatted words normalise to exactly source.
+func sameWords(source, formatted []string) bool {
+	if len(formatted) != len(source) {
+		return false
+	}
+	for i, w := range formatted {
+		if normalizeWord(w) != source[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// paragraphText joins segment texts, separating paragraphs with a blank line.
+func paragraphText(segments []Segment) string {
+	var b strings.Builder
+	for _, s := range segments {
+		text := strings.TrimSpace(s.Text)
+		if text == "" || s.Kind == eventKind {
+			continue
+		}
+		if b.Len() > 0 {
+			if s.Paragraph {
+				b.WriteString("\n\n")
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+func capitalize(w string) string {
+	r, size := utf8.DecodeRuneInString(w)
+	if r == utf8.RuneError || !unicode.IsLower(r) {
+		return w
+	}
+	return string(unicode.ToUpper(r)) + w[size:]
+}
+
+func endsSentence(w string) bool {
+	w = strings.TrimRight(strings.TrimSpace(w), `"')”’`)
+	return strings.HasSuffix(w, ".") || strings.HasSuffix(w, "?") || strings.HasSuffix(w, "!")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Audiogram clips last between minClip and maxClip seconds.
+const (
+	minClip = 30.0
+	maxClip = 60.0
+)
+
+// clipsResponseFormat is the JSON schema of the clip suggestion reply.
+var clipsResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "clips",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"clips": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"start":   map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the clip's first turn, copied from the transcript"},
+							"end":     map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the clip's last turn, copied from the transcript"},
+							"title":   map[string]any{"type": "string", "description": "A few words naming the clip"},
+							"caption": map[string]any{"type": "string", "description": "A one-sentence social caption to post with the clip"},
+							"reason":  map[string]any{"type": "string", "description": "Why the clip works on its own"},
+						},
+						"required":             []string{"start", "end", "title", "caption", "reason"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"clips"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// clipsPrompt asks for the best moments to share as audiograms.
+const clipsPrompt = `Pick the %d best moments of the following podcast transcript to share as 30 to 60 second audio clips on social media, best first. A good clip opens with a strong hook in its first sentence, makes sense to someone who hasn't heard the episode, and ends on a complete thought. Clips must not overlap. Give each clip's first and last turn by their timestamps, copied exactly.
+%s
+Transcript:
+%s`
+
+// clip is a suggested audiogram clip. Start and End are seconds into the
+// episode; caption cues are relative to the start of the clip.
+type clip struct {
+	Start     float64   `json:"start"`
+	End       float64   `json:"end"`
+	StartTime string    `json:"start_time"`
+	EndTime   string    `json:"end_time"`
+	Title     string    `json:"title"`
+	Caption   string    `json:"caption"`
+	Reason    string    `json:"reason,omitempty"`
+	Audio     string    `json:"audio,omitempty"`
+	Subtitles string    `json:"subtitles,omitempty"`
+	Cues      []clipCue `json:"cues"`
+}
+
+// clipCue is a caption line of a clip.
+type clipCue struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker,omitempty"`
+	Text    string  `json:"text"`
+}
+
+// suggestClips asks the summary model for up to n clips of t. The suggested
+// bounds are snapped to turn and word boundaries within the clip length
+// limits, and clips that don't fit or overlap a better one are dropped.
+func (p *Pipeline) suggestClips(ctx context.Context, apiKey string, t *Transcript, n int) ([]clip, TokenUsage, error) {
+	var episode string
+	if t.Title != "" {
+		episode += "\nEpisode title: " + t.Title + "\n"
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(clipsPrompt, n, episode, fitContext(formatTimedTurns(t.Segments), p.config.SummaryModel))}},
+		"temperature":     p.config.Temperature,
+		"response_format": clipsResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to suggest clips: %v", err)
+	}
+	var reply struct {
+		Clips []struct {
+			Start   string `json:"start"`
+			End     string `json:"end"`
+			Title   string `json:"title"`
+			Caption string `json:"caption"`
+			Reason  string `json:"reason"`
+		} `json:"clips"`
+	}
+	if err := json.Unmarshal([]byte(content), &reply); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode clips: %v", err)
+	}
+	var clips []clip
+	for _, c := range reply.Clips {
+		first, err := parseClock(c.Start)
+		if err != nil {
+			continue
+		}
+		last, err := parseClock(c.End)
+		if err != nil {
+			continue
+		}
+		start, end, ok := clipBounds(t, first, last)
+		if !ok || overlapsClip(clips, start, end) {
+			continue
+		}
+		clips = append(clips, clip{
+			Start:   start,
+			End:     end,
+			Title:   strings.TrimSpace(c.Title),
+			Caption: strings.TrimSpace(c.Caption),
+			Reason:  strings.TrimSpace(c.Reason),
+			Cues:    clipCues(t.Segments, start, end),
+		})
+		if len(clips) == n {
+			break
+		}
+	}
+	return clips, usage, nil
+}
+
+// clipBounds returns the exact bounds of a clip from the turn at first to the
+// turn at last: from the start of the first turn to the end of the turn or word
+// nearest the end of the last that keeps the clip between minClip and maxClip.
+func clipBounds(t *Transcript, first, last float64) (float64, float64, bool) {
+	start := -1.0
+	target := 0.0
+	for _, s := range t.Segments {
+		// Timestamps in the prompt are truncated to the second
+		if s.Start < first+1 && s.End > first {
+			start = s.Start
+		}
+		if s.Start < last+1 {
+			target = s.End
+		}
+	}
+	if start < 0 {
+		return 0, 0, false
+	}
+	lo, hi := start+minClip, start+maxClip
+	if t.Duration > 0 {
+		hi = math.Min(hi, t.Duration)
+	}
+	if hi < lo {
+		return 0, 0, false
+	}
+	// Prefer ending with a turn, then with a word, then anywhere in range
+	var turnEnds, wordEnds []float64
+	for _, s := range t.Segments {
+		if s.End >= lo && s.End <= hi {
+			turnEnds = append(turnEnds, s.End)
+		}
+		for _, w := range s.Words {
+			if w.End >= lo && w.End <= hi {
+				wordEnds = append(wordEnds, w.End)
+			}
+		}
+	}
+	for _, ends := range [][]float64{turnEnds, wordEnds} {
+		if len(ends) > 0 {
+			sort.Slice(ends, func(i, j int) bool { return math.Abs(ends[i]-target) < math.Abs(ends[j]-target) })
+			return start, ends[0], true
+		}
+	}
+	return start, math.Max(lo, math.Min(target, hi)), true
+}
+
+func overlapsClip(clips []clip, start, end float64) bool {
+	for _, c := range clips {
+		if start < c.End && end > c.Start {
+			return true
+		}
+	}
+	return false
+}
+
+// clipCues returns the caption lines of the speech between start and end,
+// relative to start. Turns cut by the clip's end keep only the words within
+// it when word timings are known.
+func clipCues(segments []Segment, start, end float64) []clipCue {
+	var cues []clipCue
+	for _, s := range segments {
+		if s.End <= start || s.Start >= end || s.Kind != "" {
+			continue
+		}
+		text := s.displayText()
+		if len(s.Words) > 0 && (s.Start < start || s.End > end) {
+			var words []string
+			for _, w := range s.Words {
+				if w.Start >= start-0.01 && w.End <= end+0.01 {
+					words = append(words, strings.TrimSpace(w.Text))
+				}
+			}
+			text = strings.Join(words, " ")
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		cues = append(cues, clipCue{
+			Start:   math.Max(s.Start, start) - start,
+			End:     math.Min(s.End, end) - start,
+			Speaker: s.speakerLabel(),
+			Text:    text,
+		})
+	}
+	return cues
+}
+
+// srt renders the clip's captions as SubRip, which audiogram tools import.
+func (c *clip) srt() []byte {
+	var b strings.Builder
+	for i, cue := range c.Cues {
+		text := cue.Text
+		if cue.Speaker != "" {
+			text = cue.Speaker + ": " + text
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(cue.Start, ","), formatTimestamp(cue.End, ","), text)
+	}
+	return []byte(b.String())
+}
+
+// cutClips cuts each clip out of the audio at path into dir with ffmpeg, next
+// to its captions as SubRip. The audio is re-encoded so that the cuts are
+// exact rather than on frame boundaries.
+func (p *Pipeline) cutClips(ctx context.Context, path, dir string, clips []clip) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("ffmpeg, needed to cut clips, is not installed")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create clip directory: %v", err)
+	}
+	for i := range clips {
+		c := &clips[i]
+		name := fmt.Sprintf("clip-%02d", i+1)
+		c.Audio = filepath.Join(dir, name+filepath.Ext(path))
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y",
+			"-ss", strconv.FormatFloat(c.Start, 'f', 3, 64), "-to", strconv.FormatFloat(c.End, 'f', 3, 64),
+			"-i", path, "-vn", c.Audio)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ffmpeg failed cutting %s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+		c.Subtitles = filepath.Join(dir, name+".srt")
+		data, err := sealStored(p.config.EncryptionKey, c.srt())
+		if err == nil {
+			err = writeFileAtomic(c.Subtitles, data, 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write clip captions: %v", err)
+		}
+	}
+	return nil
+}
+
+// renderClips returns the clips as written to the clips file, with their
+// times shifted by offset seconds into the episode.
+func renderClips(clips []clip, offset float64) ([]byte, error) {
+	for i := range clips {
+		clips[i].Start += offset
+		clips[i].End += offset
+		clips[i].StartTime = formatTimestamp(clips[i].Start, ".")
+		clips[i].EndTime = formatTimestamp(clips[i].End, ".")
+	}
+	if clips == nil {
+		clips = []clip{}
+	}
+	data, err := json.MarshalIndent(map[string][]clip{"clips": clips}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
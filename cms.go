@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Credentials of the sites -post-draft posts to. A WordPress application
+// password is made under Users → Profile; a Ghost Admin API key under
+// Settings → Integrations.
+const (
+	wordpressUserEnv     = "WORDPRESS_USERNAME"
+	wordpressPasswordEnv = "WORDPRESS_APP_PASSWORD"
+	ghostKeyEnv          = "GHOST_ADMIN_API_KEY"
+)
+
+// cmsSettings is the site a show's transcripts are posted to, the cms of its
+// profile.
+type cmsSettings struct {
+	// Platform is wordpress or ghost.
+	Platform string `json:"platform"`
+	// URL is the site's address, e.g. https://example.com.
+	URL string `json:"url"`
+	// Username is the WordPress user posting; WORDPRESS_USERNAME overrides it.
+	Username string `json:"username,omitempty"`
+	// Tags are the Ghost tags of the posts, by name.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// validate checks that the settings name a platform and a site.
+func (c *cmsSettings) validate() error {
+	switch c.Platform {
+	case "wordpress", "ghost":
+	default:
+		return fmt.Errorf("unknown cms platform %q (available: wordpress, ghost)", c.Platform)
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("the cms url must be the site's http(s) address, not %q", c.URL)
+	}
+	return nil
+}
+
+// postHTML is the body of a drafted post: the summary, the chapters, and the
+// transcript turn by turn.
+var postHTML = template.Must(template.New("post").Parse(`{{if .Summary}}<p>{{.Summary}}</p>
+{{end}}{{if .Chapters}}<h2>Chapters</h2>
+<ul>
+{{range .Chapters}}<li><code>{{.Time}}</code> {{.Headline}}</li>
+{{end}}</ul>
+{{end}}<h2>Transcript</h2>
+{{range .Turns}}<p><code>{{.Time}}</code> {{if .Speaker}}<strong>{{.Speaker}}:</strong> {{end}}{{.Text}}</p>
+{{end}}`))
+
+// postItem is a chapter or turn of a post.
+type postItem struct {
+	Time     string
+	Speaker  string
+	Headline string
+	Text     string
+}
+
+// renderPost renders t as the HTML of a post.
+func renderPost(t *Transcript) (string, error) {
+	view := struct {
+		Summary  string
+		Chapters []postItem
+		Turns    []postItem
+	}{Summary: t.Summary}
+	for _, c := range t.Chapters {
+		view.Chapters = append(view.Chapters, postItem{Time: formatTimestamp(c.Start, ".")[:8], Headline: c.Headline})
+	}
+	for _, s := range t.Segments {
+		view.Turns = append(view.Turns, postItem{Time: formatTimestamp(s.Start, ".")[:8], Speaker: s.Speaker, Text: s.displayText()})
+	}
+	var b strings.Builder
+	if err := postHTML.Execute(&b, view); err != nil {
+		return "", fmt.Errorf("failed to render post: %v", err)
+	}
+	return b.String(), nil
+}
+
+// postDraft posts t as a draft to the site of c and returns the draft's
+// address.
+func (p *Pipeline) postDraft(ctx context.Context, c *cmsSettings, t *Transcript) (string, error) {
+	body, err := renderPost(t)
+	if err != nil {
+		return "", err
+	}
+	title := episodeTitle(t, "Transcript")
+	site := strings.TrimRight(c.URL, "/")
+	if c.Platform == "ghost" {
+		return p.postGhostDraft(ctx, site, title, body, c.Tags, t.Summary)
+	}
+	return p.postWordPressDraft(ctx, site, title, body, c, t.Summary)
+}
+
+// postWordPressDraft creates the post through the WordPress REST API,
+// authenticating with an application password.
+func (p *Pipeline) postWordPressDraft(ctx context.Context, site, title, body string, c *cmsSettings, excerpt string) (string, error) {
+	user, password := firstNonEmpty(os.Getenv(wordpressUserEnv), c.Username), os.Getenv(wordpressPasswordEnv)
+	if user == "" || password == "" {
+		return "", fmt.Errorf("please set %s, or username in the show's cms, and %s", wordpressUserEnv, wordpressPasswordEnv)
+	}
+	post := map[string]any{"title": title, "content": body, "excerpt": excerpt, "status": "draft"}
+	req, err := newCMSRequest(ctx, site+"/wp-json/wp/v2/posts", post)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(user, password)
+	var res struct {
+		ID int `json:"id"`
+	}
+	if err := p.cmsDo(req, "WordPress", &res); err != nil {
+		return "", err
+	}
+	// A draft's link is a preview; the editor is where it gets published
+	return fmt.Sprintf("%s/wp-admin/post.php?post=%d&action=edit", site, res.ID), nil
+}
+
+// postGhostDraft creates the post through the Ghost Admin API, which takes
+// the HTML as is with source=html.
+func (p *Pipeline) postGhostDraft(ctx context.Context, site, title, body string, tags []string, excerpt string) (string, error) {
+	token, err := ghostToken(os.Getenv(ghostKeyEnv), time.Now())
+	if err != nil {
+		return "", err
+	}
+	post := map[string]any{"title": title, "html": body, "status": "draft"}
+	if len(tags) > 0 {
+		post["tags"] = tags
+	}
+	if excerpt != "" {
+		// Ghost rejects excerpts over 300 characters
+		post["custom_excerpt"] = fitText(excerpt, 300)
+	}
+	req, err := newCMSRequest(ctx, site+"/ghost/api/admin/posts/?source=html", map[string]any{"posts": []any{post}})
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Ghost "+token)
+	var res struct {
+		Posts []struct {
+			ID string `json:"id"`
+		} `json:"posts"`
+	}
+	if err := p.cmsDo(req, "Ghost", &res); err != nil {
+		return "", err
+	}
+	if len(res.Posts) == 0 {
+		return "", fmt.Errorf("Ghost created no post")
+	}
+	return site + "/ghost/#/editor/post/" + res.Posts[0].ID, nil
+}
+
+// ghostToken signs the short-lived JWT the Ghost Admin API takes, from an
+// Admin API key of the form id:secret, with the secret in hex.
+func ghostToken(key string, now time.Time) (string, error) {
+	id, secret, ok := strings.Cut(key, ":")
+	if key == "" || !ok {
+		return "", fmt.Errorf("please set %s to the site's Admin API key (id:secret)", ghostKeyEnv)
+	}
+	secretBytes, err := hex.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("%s: the secret after the colon isn't hex: %v", ghostKeyEnv, err)
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": id})
+	claims, _ := json.Marshal(map[string]any{"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(), "aud": "/admin/"})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, secretBytes)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func newCMSRequest(ctx context.Context, endpoint string, payload any) (*http.Request, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// cmsDo sends a request to a site and decodes its reply.
+func (p *Pipeline) cmsDo(req *http.Request, platform string, out any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("non-2xx response from %s: %d, body: %s%s", platform, resp.StatusCode, string(body), requestRef(resp))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %v", platform, err)
+	}
+	return nil
+}
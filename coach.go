@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// interruptionOverlap is how far, in seconds, a turn must start before the
+// previous speaker's ends to count as an interruption rather than a quick
+// hand-over.
+const interruptionOverlap = 0.3
+
+// sentencePattern splits a turn into sentences.
+var sentencePattern = regexp.MustCompile(`[^.!?]+[.!?]*`)
+
+// hostMetrics are a host's interviewing habits in one episode, or summed over
+// several.
+type hostMetrics struct {
+	Host    string `json:"host"`
+	Episode string `json:"episode,omitempty"`
+	// Episodes counts the episodes summed up, for totals.
+	Episodes         int     `json:"episodes"`
+	SpeechSeconds    float64 `json:"speech_seconds"`
+	TalkSeconds      float64 `json:"talk_seconds"`
+	TalkRatio        float64 `json:"talk_ratio"`
+	Turns            int     `json:"turns"`
+	Questions        int     `json:"questions"`
+	QuestionWords    int     `json:"question_words"`
+	AvgQuestionWords float64 `json:"avg_question_words"`
+	// Interruptions are the host's turns started over someone else's;
+	// Interrupted are the others' started over the host's.
+	Interruptions int `json:"interruptions"`
+	Interrupted   int `json:"interrupted"`
+	// DeadAir is the silence of the episode's gaps between turns of at least
+	// -dead-air seconds.
+	DeadAirSeconds float64 `json:"dead_air_seconds"`
+	DeadAirGaps    int     `json:"dead_air_gaps"`
+}
+
+// coachReport is what the coach command prints.
+type coachReport struct {
+	Episodes []hostMetrics `json:"episodes"`
+	Hosts    []hostMetrics `json:"hosts"`
+}
+
+// runCoach implements the coach command.
+func runCoach(args []string) error {
+	flags := flag.NewFlagSet("coach", flag.ExitOnError)
+	in := flags.String("in", ".", "Directory searched recursively for diarized transcripts")
+	hostList := flags.String("host", "", "Comma-separated names or labels of the hosts (default: speakers with the host or co-host role, else whoever talks most)")
+	deadAir := flags.Float64("dead-air", 2, "Shortest silence between turns, in seconds, counted as dead air")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription coach [-in dir] [-host names] [-dead-air seconds] [-json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *deadAir <= 0 {
+		return fmt.Errorf("-dead-air must be positive")
+	}
+	var hosts []string
+	for _, h := range strings.Split(*hostList, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	episodes, err := loadCatalog(key, *in)
+	if err != nil {
+		return err
+	}
+	if len(episodes) == 0 {
+		return fmt.Errorf("no %s files found under %s", filepath.Base(defaultConfig().DiarizedJSONFile), *in)
+	}
+	var report coachReport
+	totals := map[string]*hostMetrics{}
+	var order []string
+	for _, ep := range episodes {
+		for _, m := range coachEpisode(ep.transcript, hosts, *deadAir) {
+			m.Episode = ep.Title
+			report.Episodes = append(report.Episodes, m)
+			total, ok := totals[m.Host]
+			if !ok {
+				total = &hostMetrics{Host: m.Host}
+				totals[m.Host] = total
+				order = append(order, m.Host)
+			}
+			total.add(m)
+		}
+	}
+	for _, host := range order {
+		report.Hosts = append(report.Hosts, totals[host].finish())
+	}
+	if len(report.Hosts) == 0 {
+		return fmt.Errorf("none of the hosts %s speak in %d episode(s)", strings.Join(hosts, ", "), len(episodes))
+	}
+	if *asJSON {
+		return printJSON(report)
+	}
+	printCoachReport(report)
+	return nil
+}
+
+// coachEpisode measures each host of t. hosts names them; without it they're
+// the speakers with the host or co-host role, or else whoever talks most.
+func coachEpisode(t *Transcript, hosts []string, deadAir float64) []hostMetrics {
+	names := map[string]string{}
+	roles := map[string]string{}
+	for _, info := range t.Speakers {
+		if info.Name != "" {
+			names[info.Label] = info.Name
+		}
+		roles[info.Label], roles[info.Name] = info.Role, info.Role
+	}
+	name := func(label string) string {
+		if n, ok := names[label]; ok {
+			return n
+		}
+		return label
+	}
+
+	talk := map[string]float64{}
+	var speech float64
+	for _, s := range t.Segments {
+		if s.Kind != eventKind && s.Speaker != "" {
+			talk[s.Speaker] += s.End - s.Start
+			speech += s.End - s.Start
+		}
+	}
+	isHost := map[string]bool{}
+	for _, label := range t.speakers() {
+		for _, h := range hosts {
+			if strings.EqualFold(h, label) || strings.EqualFold(h, name(label)) {
+				isHost[label] = true
+			}
+		}
+		if len(hosts) == 0 && (roles[label] == "host" || roles[label] == "co-host") {
+			isHost[label] = true
+		}
+	}
+	if len(hosts) == 0 && len(isHost) == 0 {
+		most := ""
+		for _, label := range t.speakers() {
+			if talk[label] > talk[most] {
+				most = label
+			}
+		}
+		if most != "" {
+			isHost[most] = true
+		}
+	}
+
+	// Dead air is the episode's; events such as laughter aren't silence
+	var gaps int
+	var silence, heard float64
+	for i, s := range t.Segments {
+		if i > 0 && s.Start-heard >= deadAir {
+			gaps++
+			silence += s.Start - heard
+		}
+		heard = max(heard, s.End)
+	}
+
+	var metrics []hostMetrics
+	for _, label := range t.speakers() {
+		if !isHost[label] {
+			continue
+		}
+		m := hostMetrics{Host: name(label), Episodes: 1, SpeechSeconds: speech, TalkSeconds: talk[label], DeadAirSeconds: silence, DeadAirGaps: gaps}
+		var prev *Segment
+		for i := range t.Segments {
+			s := &t.Segments[i]
+			if s.Kind == eventKind || s.Speaker == "" {
+				continue
+			}
+			overlaps := prev != nil && prev.Speaker != s.Speaker && (s.Start < prev.End-interruptionOverlap || s.Crosstalk)
+			if s.Speaker == label {
+				m.Turns++
+				if overlaps {
+					m.Interruptions++
+				}
+				for _, sentence := range sentencePattern.FindAllString(s.Text, -1) {
+					if strings.HasSuffix(sentence, "?") {
+						m.Questions++
+						m.QuestionWords += len(strings.Fields(sentence))
+					}
+				}
+			} else if overlaps && prev.Speaker == label {
+				m.Interrupted++
+			}
+			prev = s
+		}
+		metrics = append(metrics, m.finish())
+	}
+	return metrics
+}
+
+// add sums up the metrics of another episode.
+func (m *hostMetrics) add(o hostMetrics) {
+	m.Episodes += o.Episodes
+	m.SpeechSeconds += o.SpeechSeconds
+	m.TalkSeconds += o.TalkSeconds
+	m.Turns += o.Turns
+	m.Questions += o.Questions
+	m.QuestionWords += o.QuestionWords
+	m.Interruptions += o.Interruptions
+	m.Interrupted += o.Interrupted
+	m.DeadAirSeconds += o.DeadAirSeconds
+	m.DeadAirGaps += o.DeadAirGaps
+}
+
+// finish derives the ratios from the sums.
+func (m hostMetrics) finish() hostMetrics {
+	if m.SpeechSeconds > 0 {
+		m.TalkRatio = m.TalkSeconds / m.SpeechSeconds
+	}
+	if m.Questions > 0 {
+		m.AvgQuestionWords = float64(m.QuestionWords) / float64(m.Questions)
+	}
+	return m
+}
+
+// printCoachReport prints a table of the hosts in each episode, then their
+// totals, with interruptions and dead air per hour of speech so episodes of
+// different lengths compare.
+func printCoachReport(r coachReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(label string, m hostMetrics) {
+		hours := m.SpeechSeconds / 3600
+		perHour := func(n int) string {
+			if hours == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%.1f", float64(n)/hours)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%.0f%%\t%d\t%.1f\t%d (%s/h)\t%d (%s/h)\t%s (%d)\n", label, m.Host, 100*m.TalkRatio,
+			m.Questions, m.AvgQuestionWords, m.Interruptions, perHour(m.Interruptions), m.Interrupted, perHour(m.Interrupted),
+			formatElapsed(m.DeadAirSeconds), m.DeadAirGaps)
+	}
+	header := "  %s\tHost\tTalk\tQuestions\tWords/question\tInterrupts\tInterrupted\tDead air (gaps)\n"
+	fmt.Fprintf(tw, header, "Episode")
+	for _, m := range r.Episodes {
+		row(truncateWords(m.Episode, 8), m)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, header, "Across")
+	for _, m := range r.Hosts {
+		row(fmt.Sprintf("%d episode(s)", m.Episodes), m)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand invoked as the first command-line argument.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+// commands is the registry of subcommands. Running the binary without one
+// transcribes and diarizes a single audio file.
+var commands = map[string]command{
+	"archive":     {summary: "Export an episode as a read-only, content-addressed bundle of its outputs with checksums, for retention", run: runArchive},
+	"boilerplate": {summary: "Learn the intro and outro a show repeats across its transcribed episodes, for leaving them out of later ones", run: runBoilerplate},
+	"cache":       {summary: "Remove temporary artifacts from the cache directory by age or size", run: runCache},
+	"coach":       {summary: "Report each host's questions, talk ratio, interruptions and dead air across episodes", run: runCoach},
+	"completion":  {summary: "Print a bash, zsh or fish completion script of the commands and their flags", run: runCompletion},
+	"dataset":     {summary: "Assemble the -snippet-dir turns of consenting speakers across episodes into a per-speaker voice dataset", run: runDataset},
+	"decrypt":     {summary: "Print files written with -encryption-key in the clear", run: runDecrypt},
+	"doctor":      {summary: "Check API keys, endpoints, model access, ffmpeg and disk space before a long job", run: runDoctor},
+	"eval":        {summary: "Score a transcript against a reference (WER) and RTTM ground truth (DER)", run: runEval},
+	"excerpts":    {summary: "Compile every excerpt of the archive mentioning a subject, with its speaker, time and context, into one document", run: runExcerpts},
+	"import":      {summary: "Process every episode of an RSS feed or directory from a resumable plan with cost and time estimates", run: runImport},
+	"live":        {summary: "Transcribe a stream or microphone as it plays with the OpenAI Realtime API", run: runLive},
+	"publish":     {summary: "Render processed episodes into a static transcript website", run: runPublish},
+	"review":      {summary: "Serve a web UI and API for correcting low-confidence turns, feeding the corrections back into the transcripts", run: runReview},
+	"status":      {summary: "Show the jobs run on this machine as pending, running, done, failed or cancelled, and retry or cancel one", run: runStatus},
+	"topics":      {summary: "Index people, topics and recurring segments across episodes, or find where a subject was discussed", run: runTopics},
+	"validate":    {summary: "Check transcript JSON files against the versioned schema of the canonical format", run: runValidate},
+	"verify":      {summary: "Check the -sign-key signatures of outputs, proving they come unmodified from the key's owner", run: runVerify},
+	"version":     {summary: "Print the version, commit and build date of this binary", run: runVersion},
+}
+
+// dispatchCommand runs the subcommand named by os.Args[1], if any, and reports
+// whether one was found.
+func dispatchCommand() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		return false
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// commandUsage lists the subcommands for the -h output.
+func commandUsage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-12s %s\n", name, commands[name].summary)
+	}
+	return b.String()
+}
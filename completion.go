@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// completionShells are the shells the completion command writes scripts for.
+var completionShells = map[string]func(io.Writer, *cliSpec){
+	"bash": writeBashCompletion,
+	"zsh":  writeZshCompletion,
+	"fish": writeFishCompletion,
+}
+
+// runCompletion implements the completion command.
+func runCompletion(args []string) error {
+	flags := flag.NewFlagSet("completion", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription completion bash|zsh|fish")
+		fmt.Fprintln(flags.Output(), "Load it with: source <(podcast-transcription completion bash), or save the fish script as ~/.config/fish/completions/podcast-transcription.fish")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	write, ok := completionShells[flags.Arg(0)]
+	if flags.NArg() != 1 || !ok {
+		flags.Usage()
+		return fmt.Errorf("name one shell: bash, zsh or fish")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cli, err := describeCLI(ctx, exe)
+	if err != nil {
+		return err
+	}
+	write(os.Stdout, cli)
+	return nil
+}
+
+// cliSpec is the commands and flags of the binary, as its -h output lists
+// them.
+type cliSpec struct {
+	flags    []cliFlag
+	commands []cliCommand
+}
+
+// cliCommand is a subcommand of the binary.
+type cliCommand struct {
+	name, summary string
+	// verbs are the words the command takes before its flags, such as the
+	// clean of cache clean.
+	verbs []string
+	flags []cliFlag
+}
+
+// cliFlag is a flag of the binary or of a subcommand.
+type cliFlag struct {
+	name, usage string
+	// value is whether the flag takes a value, which bool flags don't.
+	value bool
+}
+
+var (
+	// flagHelpLine matches a flag's line of flag.PrintDefaults: its name,
+	// the name of its value if it takes one, and for one-letter flags the
+	// usage after a tab.
+	flagHelpLine = regexp.MustCompile(`^  -(\S+)(?: ([^\t]+))?(?:\t(.*))?$`)
+	// commandHelpLine matches a line of commandUsage.
+	commandHelpLine = regexp.MustCompile(`^  ([a-z]+) +(.+)$`)
+	// verbsHelpLine matches a usage line naming the verbs a command takes
+	// first, such as "cache clean [-cache-dir dir]".
+	verbsHelpLine = regexp.MustCompile(`(?im)^(?:error: )?usage: podcast-transcription \S+ ([a-z]+(?:\|[a-z]+)*)(?: \[.*)?$`)
+)
+
+// describeCLI reads the commands and flags from the -h output of the binary
+// at exe and of each of its commands, so that completions are generated from
+// the flags as they are defined rather than from a list kept by hand.
+func describeCLI(ctx context.Context, exe string) (*cliSpec, error) {
+	out, _ := helpOutput(ctx, exe)
+	cli := &cliSpec{flags: parseFlagHelp(out)}
+	if len(cli.flags) == 0 {
+		return nil, fmt.Errorf("%s -h lists no flags", exe)
+	}
+	inCommands := false
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case line == "Commands:":
+			inCommands = true
+		case line == "":
+			inCommands = false
+		case inCommands:
+			if m := commandHelpLine.FindStringSubmatch(line); m != nil {
+				cli.commands = append(cli.commands, cliCommand{name: m[1], summary: m[2]})
+			}
+		}
+	}
+
+	for i := range cli.commands {
+		c := &cli.commands[i]
+		out, ok := helpOutput(ctx, exe, c.name)
+		if m := verbsHelpLine.FindStringSubmatch(out); m != nil {
+			c.verbs = strings.Split(m[1], "|")
+			if !ok {
+				// The command wants its verb before it takes -h
+				out, _ = helpOutput(ctx, exe, c.name, c.verbs[0])
+			}
+		}
+		c.flags = parseFlagHelp(out)
+	}
+	return cli, nil
+}
+
+// helpOutput returns the -h output of the binary run with args, and whether
+// it exited cleanly.
+func helpOutput(ctx context.Context, exe string, args ...string) (string, bool) {
+	out, err := exec.CommandContext(ctx, exe, append(args, "-h")...).CombinedOutput()
+	return string(out), err == nil
+}
+
+// parseFlagHelp returns the flags listed in the output of
+// flag.PrintDefaults, each with the first clause of its usage.
+func parseFlagHelp(out string) []cliFlag {
+	var flags []cliFlag
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		m := flagHelpLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		usage := m[3]
+		if usage == "" && i+1 < len(lines) {
+			usage = lines[i+1]
+		}
+		flags = append(flags, cliFlag{name: m[1], usage: shortUsage(usage), value: m[2] != ""})
+	}
+	return flags
+}
+
+// shortUsage cuts a flag's usage to its first clause, for the description
+// shells show next to it.
+func shortUsage(usage string) string {
+	usage = strings.TrimSpace(usage)
+	usage, _, _ = strings.Cut(usage, "; ")
+	if i := strings.Index(usage, " (default"); i > 0 {
+		usage = usage[:i]
+	}
+	return truncateWords(usage, 12)
+}
+
+// flagNames returns the flags, or only those taking a value if valued is
+// set, as a space-separated list of -name words.
+func flagNames(flags []cliFlag, valued bool) string {
+	var names []string
+	for _, f := range flags {
+		if f.value || !valued {
+			names = append(names, "-"+f.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// commandNames returns the names of the commands, space-separated.
+func (cli *cliSpec) commandNames() string {
+	names := make([]string, len(cli.commands))
+	for i, c := range cli.commands {
+		names[i] = c.name
+	}
+	return strings.Join(names, " ")
+}
+
+// writeBashCompletion writes a bash completion script. A flag's value is
+// completed as a file name, by complete's default.
+func writeBashCompletion(w io.Writer, cli *cliSpec) {
+	fmt.Fprint(w, `# bash completion for podcast-transcription, written by: podcast-transcription completion bash
+_podcast_transcription() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local flags valued verbs=""
+	case ${COMP_WORDS[1]} in
+`)
+	for _, c := range cli.commands {
+		fmt.Fprintf(w, "\t%s)\n\t\tflags=%q\n\t\tvalued=%q\n", c.name, flagNames(c.flags, false), flagNames(c.flags, true))
+		if len(c.verbs) > 0 {
+			fmt.Fprintf(w, "\t\tverbs=%q\n", strings.Join(c.verbs, " "))
+		}
+		fmt.Fprint(w, "\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\t*)\n\t\tflags=%q\n\t\tvalued=%q\n\t\t;;\n", flagNames(cli.flags, false), flagNames(cli.flags, true))
+	fmt.Fprintf(w, `	esac
+	if [[ $COMP_CWORD -gt 1 && " $valued " == *" $prev "* ]]; then
+		return
+	fi
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+	elif [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+	elif [[ $COMP_CWORD -eq 2 && -n $verbs ]]; then
+		COMPREPLY=($(compgen -W "$verbs" -- "$cur"))
+	fi
+}
+complete -o default -F _podcast_transcription podcast-transcription
+`, cli.commandNames())
+}
+
+// writeZshCompletion writes a zsh completion script, which works both from
+// a file named _podcast-transcription on $fpath and sourced.
+func writeZshCompletion(w io.Writer, cli *cliSpec) {
+	fmt.Fprint(w, `#compdef podcast-transcription
+# zsh completion for podcast-transcription, written by: podcast-transcription completion zsh
+
+_podcast_transcription() {
+	local -a commands
+	commands=(
+`)
+	for _, c := range cli.commands {
+		fmt.Fprintf(w, "\t\t%s\n", zshQuote(c.name+":"+c.summary))
+	}
+	fmt.Fprint(w, `	)
+	if (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then
+		_describe -t commands command commands
+		return
+	fi
+	case $words[2] in
+`)
+	for _, c := range cli.commands {
+		fmt.Fprintf(w, "\t%s)\n\t\tshift words\n\t\t(( CURRENT-- ))\n\t\t_arguments -S", c.name)
+		writeZshFlags(w, c.flags)
+		if len(c.verbs) > 0 {
+			fmt.Fprintf(w, " \\\n\t\t\t%s", zshQuote("1:command:("+strings.Join(c.verbs, " ")+")"))
+		}
+		fmt.Fprintf(w, " \\\n\t\t\t'*:file:_files'\n\t\t;;\n")
+	}
+	fmt.Fprint(w, "\t*)\n\t\t_arguments -S")
+	writeZshFlags(w, cli.flags)
+	fmt.Fprint(w, `
+		;;
+	esac
+}
+
+if [[ $funcstack[1] == _podcast-transcription ]]; then
+	_podcast_transcription "$@"
+else
+	compdef _podcast_transcription podcast-transcription
+fi
+`)
+}
+
+// writeZshFlags writes the _arguments specs of flags, continuing its line.
+func writeZshFlags(w io.Writer, flags []cliFlag) {
+	escape := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`)
+	for _, f := range flags {
+		spec := "-" + f.name + "[" + escape.Replace(f.usage) + "]"
+		if f.value {
+			spec += ":value:_files"
+		}
+		fmt.Fprintf(w, " \\\n\t\t\t%s", zshQuote(spec))
+	}
+}
+
+// zshQuote quotes s for zsh in single quotes.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeFishCompletion writes a fish completion script.
+func writeFishCompletion(w io.Writer, cli *cliSpec) {
+	fmt.Fprint(w, "# fish completion for podcast-transcription, written by: podcast-transcription completion fish\n")
+	names := cli.commandNames()
+	fmt.Fprint(w, "complete -c podcast-transcription -n __fish_use_subcommand -f\n")
+	for _, c := range cli.commands {
+		fmt.Fprintf(w, "complete -c podcast-transcription -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	writeFishFlags(w, "not __fish_seen_subcommand_from "+names, cli.flags)
+	for _, c := range cli.commands {
+		if len(c.verbs) > 0 {
+			verbs := strings.Join(c.verbs, " ")
+			fmt.Fprintf(w, "complete -c podcast-transcription -n %s -f -a %s\n",
+				fishQuote("__fish_seen_subcommand_from "+c.name+"; and not __fish_seen_subcommand_from "+verbs), fishQuote(verbs))
+		}
+		writeFishFlags(w, "__fish_seen_subcommand_from "+c.name, c.flags)
+	}
+}
+
+// writeFishFlags writes a complete line for each of flags, offered when the
+// condition holds. A flag's value is completed as a file name.
+func writeFishFlags(w io.Writer, condition string, flags []cliFlag) {
+	for _, f := range flags {
+		value := ""
+		if f.value {
+			value = " -r -F"
+		}
+		fmt.Fprintf(w, "complete -c podcast-transcription -n %s -o %s%s -d %s\n", fishQuote(condition), f.name, value, fishQuote(f.usage))
+	}
+}
+
+// fishQuote quotes s for fish in single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,77 @@
+package main
+
+// Turns whose provider doesn't report speaker confidence get an estimate: the
+// turns acoustic diarization gets wrong most often are short interjections and
+// turns spoken over another speaker.
+const (
+	shortTurnSeconds  = 2.0
+	crosstalkPenalty  = 0.7
+	shortTurnMinScore = 0.5
+)
+
+// reviewMarker is appended to the speaker label of turns flagged for review in
+// the rendered formats, e.g. "Speaker 2(?)".
+const reviewMarker = "(?)"
+
+// scoreSpeakerConfidence sets the speaker confidence of acoustically diarized
+// turns that don't have one yet: the mean of their words' speaker confidence
+// when the provider reports it, or an estimate from the turn length and
+// crosstalk otherwise.
+func scoreSpeakerConfidence(turns []Segment) {
+	for i := range turns {
+		t := &turns[i]
+		if t.Speaker == "" || t.Kind == eventKind || t.SpeakerConfidence > 0 {
+			continue
+		}
+		if c, ok := wordSpeakerConfidence(t.Words); ok {
+			t.SpeakerConfidence = c
+			continue
+		}
+		c := 1.0
+		if d := t.End - t.Start; d < shortTurnSeconds {
+			c *= shortTurnMinScore + (1-shortTurnMinScore)*max(d, 0)/shortTurnSeconds
+		}
+		if t.Crosstalk {
+			c *= crosstalkPenalty
+		}
+		t.SpeakerConfidence = c
+	}
+}
+
+// wordSpeakerConfidence averages the speaker confidence of words, reporting
+// false when no word carries one.
+func wordSpeakerConfidence(words []Word) (float64, bool) {
+	sum, reported := 0.0, false
+	for _, w := range words {
+		sum += w.SpeakerConfidence
+		if w.SpeakerConfidence > 0 {
+			reported = true
+		}
+	}
+	if !reported {
+		return 0, false
+	}
+	return sum / float64(len(words)), true
+}
+
+// flagForReview marks the turns whose speaker confidence is below threshold and
+// returns how many were marked.
+func flagForReview(turns []Segment, threshold float64) int {
+	n := 0
+	for i := range turns {
+		if turns[i].SpeakerConfidence > 0 && turns[i].SpeakerConfidence < threshold {
+			turns[i].Review = true
+			n++
+		}
+	}
+	return n
+}
+
+// speakerLabel is the speaker as rendered in the human-readable formats, with
+// reviewMarker when the turn is flagged for review.
+func (s Segment) speakerLabel() string {
+	if s.Review && s.Speaker != "" {
+		return s.Speaker + reviewMarker
+	}
+	return s.Speaker
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultMaxDuration is the audio length beyond which a run asks before
+// transcribing, long enough for any ordinary episode.
+const defaultMaxDuration = 4 * time.Hour
+
+// confirmDuration asks before transcribing seconds of audio when that is longer
+// than limit, since a livestream recording passed by mistake can cost tens of
+// dollars. yes answers for the user; without a terminal to ask on the run is
+// refused. A zero limit never asks.
+func (p *Pipeline) confirmDuration(seconds float64, limit time.Duration, yes bool) error {
+	length := time.Duration(seconds * float64(time.Second))
+	if limit <= 0 || length <= limit || yes {
+		return nil
+	}
+	question := fmt.Sprintf("The audio is %s long, more than -max-duration %s", length.Round(time.Minute), limit)
+	if cost := audioCost(p.config.TranscriptionModel, seconds); cost > 0 {
+		question += fmt.Sprintf("; transcribing it with %s costs about $%.2f", p.config.TranscriptionModel, cost)
+	}
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("%s. Pass -yes to transcribe it anyway", question)
+	}
+	fmt.Fprintf(p.console.errOut, "%s. Continue? [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("not transcribing audio longer than -max-duration")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ANSI escapes used on terminals.
+const (
+	ansiBold   = "\033[1m"
+	ansiYellow = "\033[33m"
+	ansiReset  = "\033[0m"
+)
+
+// console writes a run's messages. Progress goes to out and is dropped in quiet
+// mode; warnings go to errOut always. Color is only used when asked for, which
+// newConsole does for terminals.
+type console struct {
+	out, errOut io.Writer
+	quiet       bool
+	color       bool
+}
+
+// newConsole returns a console on stdout and stderr, colored when both are
+// terminals and neither NO_COLOR is set nor TERM is "dumb".
+func newConsole() *console {
+	color := isTerminal(os.Stdout) && isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+	return &console{out: redactingWriter{os.Stdout}, errOut: stderr, color: color}
+}
+
+// isTerminal reports whether f is a character device such as a terminal, as
+// opposed to a file or pipe in CI logs and cron mail.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressf prints a progress message unless the console is quiet.
+func (c *console) progressf(format string, args ...any) {
+	if !c.quiet {
+		fmt.Fprintf(c.out, format, args...)
+	}
+}
+
+// warnf prints a warning, prefixed with "Warning: ".
+func (c *console) warnf(format string, args ...any) {
+	fmt.Fprint(c.errOut, c.paint(ansiYellow, "Warning:")+" ")
+	fmt.Fprintf(c.errOut, format, args...)
+}
+
+// paint wraps s in the escape code when color is on.
+func (c *console) paint(code, s string) string {
+	if !c.color {
+		return s
+	}
+	return code + s + ansiReset
+}
@@ -0,0 +1,94 @@
+package main
+
+import "sort"
+
+// crosstalkLookahead is how many following turns are checked for overlap with
+// each turn.
+const crosstalkLookahead = 3
+
+// Overlap is a stretch of time in which more than one speaker talks.
+type Overlap struct {
+	Start    float64  `json:"start"`
+	End      float64  `json:"end"`
+	Speakers []string `json:"speakers"`
+}
+
+// detectCrosstalk finds regions where turns of different speakers overlap in time
+// by at least minSeconds and marks the turns involved. It relies on the provider's
+// timing: word timings where present, turn boundaries otherwise. Turns aligned
+// from an LLM diarization never overlap, so nothing is found for those.
+func detectCrosstalk(turns []Segment, minSeconds float64) []Overlap {
+	if minSeconds <= 0 {
+		return nil
+	}
+	var overlaps []Overlap
+	for i := range turns {
+		for j := i + 1; j < len(turns) && j <= i+crosstalkLookahead; j++ {
+			a, b := &turns[i], &turns[j]
+			if a.Speaker == b.Speaker || a.Speaker == "" || b.Speaker == "" {
+				continue
+			}
+			start, end := spokenOverlap(*a, *b)
+			if end-start < minSeconds {
+				continue
+			}
+			a.Crosstalk, b.Crosstalk = true, true
+			overlaps = append(overlaps, Overlap{Start: start, End: end, Speakers: []string{a.Speaker, b.Speaker}})
+		}
+	}
+	return mergeOverlaps(overlaps)
+}
+
+// spokenOverlap returns the interval in which both turns are speaking. With word
+// timings, pauses inside a turn don't count as speech.
+func spokenOverlap(a, b Segment) (start, end float64) {
+	if len(a.Words) == 0 || len(b.Words) == 0 {
+		return max(a.Start, b.Start), min(a.End, b.End)
+	}
+	start, end = -1, -1
+	for _, wa := range a.Words {
+		for _, wb := range b.Words {
+			s, e := max(wa.Start, wb.Start), min(wa.End, wb.End)
+			if e <= s {
+				continue
+			}
+			if start < 0 || s < start {
+				start = s
+			}
+			end = max(end, e)
+		}
+	}
+	if start < 0 {
+		return 0, 0
+	}
+	return start, end
+}
+
+// mergeOverlaps joins overlapping regions, collecting their speakers.
+func mergeOverlaps(overlaps []Overlap) []Overlap {
+	sort.Slice(overlaps, func(i, j int) bool { return overlaps[i].Start < overlaps[j].Start })
+	var merged []Overlap
+	for _, o := range overlaps {
+		if n := len(merged); n > 0 && o.Start <= merged[n-1].End {
+			last := &merged[n-1]
+			last.End = max(last.End, o.End)
+			for _, s := range o.Speakers {
+				if !containsString(last.Speakers, s) {
+					last.Speakers = append(last.Speakers, s)
+				}
+			}
+			continue
+		}
+		merged = append(merged, o)
+	}
+	return merged
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// timeRange is a stretch of the episode listed in a cue file, in seconds.
+type timeRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Label string  `json:"label,omitempty"`
+}
+
+// overlaps reports whether the range shares any time with start to end.
+func (r timeRange) overlaps(start, end float64) bool {
+	return start < r.End && end > r.Start
+}
+
+// loadCueFile reads a cue file of time ranges, one per line, sorted by start:
+// either an Audacity label track, with start, end and label separated by tabs
+// in seconds, or "start end label" with times as for -from, where the end may
+// also follow a "-" or "-->" (e.g. "12:00-15:30 off the record"). Blank lines
+// and lines starting with # are skipped.
+func loadCueFile(path string) ([]timeRange, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []timeRange
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseCue(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		ranges = append(ranges, r)
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	return ranges, nil
+}
+
+// parseCue parses one line of a cue file.
+func parseCue(line string) (timeRange, error) {
+	var start, end, label string
+	if fields := strings.Split(line, "\t"); len(fields) >= 2 {
+		start, end = fields[0], fields[1]
+		label = strings.Join(fields[2:], " ")
+	} else {
+		fields := strings.Fields(line)
+		if from, to, ok := strings.Cut(fields[0], "-"); ok && from != "" {
+			fields = append([]string{from, to}, fields[1:]...)
+		}
+		if len(fields) > 2 && (fields[1] == "-" || fields[1] == "-->") {
+			fields = append(fields[:1], fields[2:]...)
+		}
+		if len(fields) < 2 {
+			return timeRange{}, fmt.Errorf("%q has no end time", line)
+		}
+		start, end = fields[0], fields[1]
+		label = strings.Join(fields[2:], " ")
+	}
+	var r timeRange
+	var err error
+	if r.Start, err = parseClock(start); err != nil {
+		return r, err
+	}
+	if r.End, err = parseClock(end); err != nil {
+		return r, err
+	}
+	if r.End <= r.Start {
+		return r, fmt.Errorf("%q doesn't end after it starts", line)
+	}
+	r.Label = strings.TrimSpace(label)
+	return r, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCue(t *testing.T) {
+	tests := []struct {
+		line string
+		want timeRange
+		err  bool
+	}{
+		{"12.5\t20\tad read", timeRange{12.5, 20, "ad read"}, false},
+		{"12.5\t20", timeRange{12.5, 20, ""}, false},
+		{"12:00 15:30 off the record", timeRange{720, 930, "off the record"}, false},
+		{"12:00-15:30 off the record", timeRange{720, 930, "off the record"}, false},
+		{"12:00 - 15:30", timeRange{720, 930, ""}, false},
+		{"1:00:00 --> 1:02:00 sponsor", timeRange{3600, 3720, "sponsor"}, false},
+		{"12:00", timeRange{}, true},
+		{"15:30 12:00 backwards", timeRange{}, true},
+		{"soon later", timeRange{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCue(tt.line)
+		if (err != nil) != tt.err || (err == nil && got != tt.want) {
+			t.Errorf("parseCue(%q) = %+v, %v; want %+v, error %v", tt.line, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestLoadCueFile(t *testing.T) {
+	tests := []struct {
+		name, data string
+		want       []timeRange
+		err        bool
+	}{
+		{"sorted with comments", "# off the record\n\n30:00 31:00 second\r\n1:00 2:00 first\n", []timeRange{{60, 120, "first"}, {1800, 1860, "second"}}, false},
+		{"empty", "# nothing yet\n", nil, false},
+		{"bad line", "1:00 2:00\nbroken\n", nil, true},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "cues.txt")
+		if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadCueFile(path)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: loadCueFile = %+v, %v; want %+v, error %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// datasetManifestFile is the manifest written at the top of a dataset.
+const datasetManifestFile = "dataset.json"
+
+// speakerConsent is a speaker's entry in the consent file: whether they agreed
+// to their voice being used, and for what.
+type speakerConsent struct {
+	Speaker string `json:"speaker"`
+	Consent bool   `json:"consent"`
+	// Scope is what the voice may be used for, e.g. "tts" or "speaker-id".
+	Scope string `json:"scope,omitempty"`
+	// Date is when consent was given, and Note any terms that go with it.
+	Date string `json:"date,omitempty"`
+	Note string `json:"note,omitempty"`
+}
+
+// datasetManifest is dataset.json: who is in the dataset, on what consent, and
+// every clip.
+type datasetManifest struct {
+	CreatedAt       time.Time        `json:"created_at"`
+	SoftwareVersion string           `json:"software_version"`
+	Speakers        []datasetSpeaker `json:"speakers"`
+	Clips           []datasetClip    `json:"clips"`
+	// Excluded counts the snippets of speakers without consent, which were
+	// left out.
+	Excluded int `json:"excluded"`
+}
+
+type datasetSpeaker struct {
+	Speaker  string         `json:"speaker"`
+	Dir      string         `json:"dir"`
+	Consent  speakerConsent `json:"consent"`
+	Clips    int            `json:"clips"`
+	Seconds  float64        `json:"seconds"`
+	Episodes int            `json:"episodes"`
+}
+
+type datasetClip struct {
+	File     string  `json:"file"`
+	Speaker  string  `json:"speaker"`
+	Episode  string  `json:"episode"`
+	Date     string  `json:"date,omitempty"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+	Text     string  `json:"text"`
+	// Source is the snippet the clip was copied from.
+	Source string `json:"source"`
+}
+
+// runDataset implements the dataset command.
+func runDataset(args []string) error {
+	flags := flag.NewFlagSet("dataset", flag.ExitOnError)
+	in := flags.String("in", ".", "Directory searched recursively for the "+snippetIndexFile+" of -snippet-dir runs")
+	out := flags.String("out", "dataset", "Directory the dataset is written to, one subdirectory per speaker")
+	consentPath := flags.String("consent", "", "JSON file of the speakers' consent: [{\"speaker\": \"Jane Doe\", \"consent\": true, \"scope\": \"tts\", \"date\": \"2026-09-01\"}]; only speakers who consented are included")
+	minSeconds := flags.Float64("min-seconds", 1, "Leave out snippets shorter than this")
+	maxSeconds := flags.Float64("max-seconds", 20, "Leave out snippets longer than this (0 keeps them all)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription dataset -consent file [-in dir] [-out dir] [-min-seconds s] [-max-seconds s]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *consentPath == "" {
+		return fmt.Errorf("-consent is required: a dataset only holds the voices of speakers who agreed to it")
+	}
+	consents, err := readConsent(*consentPath)
+	if err != nil {
+		return fmt.Errorf("-consent: %v", err)
+	}
+
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	indexes, err := collectSnippets(key, *in)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		return fmt.Errorf("no %s files found under %s; cut snippets with -snippet-dir first", snippetIndexFile, *in)
+	}
+	m, err := buildDataset(key, indexes, consents, *out, *minSeconds, *maxSeconds)
+	if err != nil {
+		return err
+	}
+	if len(m.Clips) == 0 {
+		return fmt.Errorf("none of the %d episode(s) have snippets of a speaker who consented", len(indexes))
+	}
+	fmt.Printf("Wrote %d clip(s) of %d speaker(s) to %s, leaving out %d snippet(s) of speakers without consent\n",
+		len(m.Clips), len(m.Speakers), *out, m.Excluded)
+	return nil
+}
+
+// readConsent reads the consent file, keyed by lower-case speaker name.
+func readConsent(path string) (map[string]speakerConsent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []speakerConsent
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	consents := map[string]speakerConsent{}
+	for _, c := range list {
+		if c.Speaker = strings.TrimSpace(c.Speaker); c.Speaker == "" {
+			return nil, fmt.Errorf("an entry has no speaker")
+		}
+		consents[strings.ToLower(c.Speaker)] = c
+	}
+	return consents, nil
+}
+
+// episodeSnippets is an episode's snippet index and the directory it's in.
+type episodeSnippets struct {
+	dir   string
+	index snippetIndex
+}
+
+// collectSnippets loads every snippet index under dir, decrypting them with
+// key.
+func collectSnippets(key []byte, dir string) ([]episodeSnippets, error) {
+	var indexes []episodeSnippets
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != snippetIndexFile {
+			return nil
+		}
+		data, err := readStored(key, path)
+		if err != nil {
+			return err
+		}
+		ep := episodeSnippets{dir: filepath.Dir(path)}
+		if err := json.Unmarshal(data, &ep.index); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if ep.index.Episode == "" {
+			ep.index.Episode = filepath.Base(ep.dir)
+		}
+		indexes = append(indexes, ep)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect snippets: %v", err)
+	}
+	return indexes, nil
+}
+
+// buildDataset copies the snippets of the speakers who consented, between
+// minSeconds and maxSeconds long, into one directory per speaker under out,
+// each with a metadata.csv of file|text lines as TTS tools expect, and writes
+// the dataset manifest.
+func buildDataset(key []byte, indexes []episodeSnippets, consents map[string]speakerConsent, out string, minSeconds, maxSeconds float64) (*datasetManifest, error) {
+	m := &datasetManifest{CreatedAt: time.Now().UTC(), SoftwareVersion: version}
+	speakers := map[string]*datasetSpeaker{}
+	episodes := map[string]map[string]bool{}
+	metadata := map[string]*strings.Builder{}
+	for _, ep := range indexes {
+		for _, s := range ep.index.Snippets {
+			c, ok := consents[strings.ToLower(s.Speaker)]
+			if !ok || !c.Consent {
+				m.Excluded++
+				continue
+			}
+			length := s.End - s.Start
+			text := strings.TrimSpace(s.Text)
+			if text == "" || length < minSeconds || (maxSeconds > 0 && length > maxSeconds) {
+				continue
+			}
+			sp, ok := speakers[c.Speaker]
+			if !ok {
+				sp = &datasetSpeaker{Speaker: c.Speaker, Dir: slugify(c.Speaker), Consent: c}
+				speakers[c.Speaker] = sp
+				episodes[c.Speaker] = map[string]bool{}
+				metadata[c.Speaker] = &strings.Builder{}
+			}
+			name := slugify(ep.index.Episode) + "_" + s.File
+			file := filepath.Join(sp.Dir, name)
+			if err := copySnippet(filepath.Join(ep.dir, s.File), filepath.Join(out, file)); err != nil {
+				return nil, err
+			}
+			sp.Clips++
+			sp.Seconds += length
+			episodes[c.Speaker][ep.dir] = true
+			// metadata.csv is pipe-separated, so the text mustn't break a line
+			// or a field
+			fmt.Fprintf(metadata[c.Speaker], "%s|%s\n", strings.TrimSuffix(name, filepath.Ext(name)),
+				strings.Join(strings.Fields(strings.ReplaceAll(text, "|", " ")), " "))
+			m.Clips = append(m.Clips, datasetClip{
+				File:     file,
+				Speaker:  c.Speaker,
+				Episode:  ep.index.Episode,
+				Date:     ep.index.Date,
+				Start:    s.Start,
+				Duration: length,
+				Text:     text,
+				Source:   filepath.Join(ep.dir, s.File),
+			})
+		}
+	}
+	if m.Clips == nil {
+		m.Clips = []datasetClip{}
+	}
+	for name, sp := range speakers {
+		sp.Episodes = len(episodes[name])
+		m.Speakers = append(m.Speakers, *sp)
+		if err := writeDatasetFile(key, filepath.Join(out, sp.Dir, "metadata.csv"), []byte(metadata[name].String())); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(m.Speakers, func(i, j int) bool { return m.Speakers[i].Speaker < m.Speakers[j].Speaker })
+	if len(m.Clips) == 0 {
+		return m, nil
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeDatasetFile(key, filepath.Join(out, datasetManifestFile), append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// copySnippet copies the snippet audio at src to dst.
+func copySnippet(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create dataset directory: %v", err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to read snippet: %v", err)
+	}
+	defer in.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to write dataset clip: %v", err)
+	}
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dataset clip: %v", err)
+	}
+	return f.Close()
+}
+
+// writeDatasetFile writes one of the dataset's index files, encrypted with
+// key when encryption at rest is on.
+func writeDatasetFile(key []byte, path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create dataset directory: %v", err)
+	}
+	data, err := sealStored(key, data)
+	if err == nil {
+		err = writeFileAtomic(path, data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
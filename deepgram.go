@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// deepgramResponse is the subset of Deepgram's /v1/listen response that is used.
+type deepgramResponse struct {
+	Metadata struct {
+		RequestID string  `json:"request_id"`
+		Duration  float64 `json:"duration"`
+	} `json:"metadata"`
+	Results struct {
+		Channels []struct {
+			DetectedLanguage string `json:"detected_language"`
+			Alternatives     []struct {
+				Transcript string `json:"transcript"`
+			} `json:"alternatives"`
+		} `json:"channels"`
+		Utterances []struct {
+			Start      float64        `json:"start"`
+			End        float64        `json:"end"`
+			Speaker    int            `json:"speaker"`
+			Transcript string         `json:"transcript"`
+			Words      []deepgramWord `json:"words"`
+		} `json:"utterances"`
+	} `json:"results"`
+}
+
+type deepgramWord struct {
+	Word              string  `json:"word"`
+	PunctuatedWord    string  `json:"punctuated_word"`
+	Start             float64 `json:"start"`
+	End               float64 `json:"end"`
+	Confidence        float64 `json:"confidence"`
+	Speaker           int     `json:"speaker"`
+	SpeakerConfidence float64 `json:"speaker_confidence"`
+}
+
+// transcribeDeepgram sends the audio to Deepgram with diarization and smart
+// formatting enabled and maps the utterances onto speaker turns. An upload
+// whose connection drops is sent again.
+func (p *Pipeline) transcribeDeepgram(ctx context.Context, apiKey, audioPath string) (*Transcript, error) {
+	var res *deepgramResponse
+	err := p.retryCorrupt(ctx, func() error {
+		var err error
+		res, err = p.sendDeepgram(ctx, apiKey, audioPath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.transcript(filepath.Base(audioPath)), nil
+}
+
+// sendDeepgram makes one transcription request.
+func (p *Pipeline) sendDeepgram(ctx context.Context, apiKey, audioPath string) (*deepgramResponse, error) {
+	fileInfo, err := os.Stat(audioPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %v", err)
+	}
+	file, err := os.Open(audioPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer file.Close()
+
+	q := url.Values{}
+	q.Set("model", p.config.TranscriptionModel)
+	q.Set("diarize", "true")
+	if p.config.Verbatim {
+		// Smart formatting rewrites numbers, dates and the like
+		q.Set("filler_words", "true")
+	} else {
+		q.Set("smart_format", "true")
+	}
+	q.Set("punctuate", "true")
+	q.Set("utterances", "true")
+	q.Set("detect_language", "true")
+	req, err := http.NewRequestWithContext(ctx, "POST", p.config.DeepgramURL+"?"+q.Encode(), file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.ContentLength = fileInfo.Size()
+	req.Header.Set("Authorization", "Token "+apiKey)
+	contentType := mime.TypeByExtension(filepath.Ext(audioPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if isDropped(err) {
+			return nil, &droppedTransfer{"upload", err}
+		}
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return nil, fmt.Errorf("non-200 response from Deepgram: %d, body: %s%s", resp.StatusCode, string(body), requestRef(resp))
+	}
+
+	var res deepgramResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to decode Deepgram response: %v", err)
+	}
+	return &res, nil
+}
+
+// transcript converts the Deepgram response into the canonical model.
+func (res *deepgramResponse) transcript(audio string) *Transcript {
+	t := &Transcript{Audio: audio, Duration: res.Metadata.Duration}
+	if len(res.Results.Channels) > 0 {
+		ch := res.Results.Channels[0]
+		t.Language = ch.DetectedLanguage
+		if len(ch.Alternatives) > 0 {
+			t.Text = ch.Alternatives[0].Transcript
+		}
+	}
+	var segments []Segment
+	for _, u := range res.Results.Utterances {
+		s := Segment{
+			Start:   u.Start,
+			End:     u.End,
+			Speaker: fmt.Sprintf("Speaker %d", u.Speaker+1),
+			Text:    u.Transcript,
+		}
+		for _, w := range u.Words {
+			text := w.PunctuatedWord
+			if text == "" {
+				text = w.Word
+			}
+			s.Words = append(s.Words, Word{Text: text, Start: w.Start, End: w.End, Confidence: w.Confidence, SpeakerConfidence: w.SpeakerConfidence})
+		}
+		segments = append(segments, s)
+	}
+	t.Segments = mergeSpeakerTurns(segments)
+	return t
+}
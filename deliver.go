@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// Endpoints of the folders -deliver-to uploads to.
+const (
+	driveFilesURL  = "https://www.googleapis.com/drive/v3/files"
+	driveUploadURL = "https://www.googleapis.com/upload/drive/v3/files"
+	dropboxURL     = "https://content.dropboxapi.com/2/files/upload"
+)
+
+// dropboxTokenEnv holds the Dropbox access token, of an app with the
+// files.content.write permission.
+const dropboxTokenEnv = "DROPBOX_ACCESS_TOKEN"
+
+// driveFolderType is the MIME type of Google Drive folders.
+const driveFolderType = "application/vnd.google-apps.folder"
+
+// destination is a shared folder outputs are delivered to.
+type destination struct {
+	// kind is gdrive or dropbox.
+	kind string
+	// folder is the Drive folder's ID, or the Dropbox folder's path.
+	folder string
+}
+
+// parseDestinations reads -deliver-to: comma-separated gdrive:FOLDER_ID and
+// dropbox:/PATH destinations.
+func parseDestinations(s string) ([]destination, error) {
+	var dests []destination
+	for _, d := range splitList(s) {
+		kind, folder, _ := strings.Cut(d, ":")
+		switch kind {
+		case "gdrive":
+			if folder == "" {
+				return nil, fmt.Errorf("-deliver-to %s: give the ID of the Drive folder, the last part of its address", d)
+			}
+		case "dropbox":
+			if !strings.HasPrefix(folder, "/") {
+				return nil, fmt.Errorf("-deliver-to %s: the Dropbox folder must be a path from the root, e.g. dropbox:/Shows", d)
+			}
+			folder = strings.TrimRight(folder, "/")
+		default:
+			return nil, fmt.Errorf("-deliver-to %s: unknown destination (available: gdrive:FOLDER_ID, dropbox:/PATH)", d)
+		}
+		dests = append(dests, destination{kind, folder})
+	}
+	return dests, nil
+}
+
+func (d destination) String() string {
+	return d.kind + ":" + d.folder
+}
+
+// deliver uploads the files to a folder named episode inside the
+// destination's, replacing the files of an earlier run of the episode.
+func (p *Pipeline) deliver(ctx context.Context, d destination, episode string, files []string) error {
+	if d.kind == "dropbox" {
+		return p.deliverDropbox(ctx, d.folder+"/"+episode, files)
+	}
+	return p.deliverDrive(ctx, d.folder, episode, files)
+}
+
+// deliverDropbox uploads the files into the Dropbox folder, overwriting
+// files of the same name. Dropbox makes the folder as needed.
+func (p *Pipeline) deliverDropbox(ctx context.Context, folder string, files []string) error {
+	token := os.Getenv(dropboxTokenEnv)
+	if token == "" {
+		return fmt.Errorf("please set the %s environment variable", dropboxTokenEnv)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		arg, _ := json.Marshal(map[string]any{"path": path.Join(folder, filepath.Base(file)), "mode": "overwrite", "mute": true})
+		// HTTP headers are ASCII, so Dropbox takes the rest as JSON escapes
+		var ascii strings.Builder
+		for _, r := range string(arg) {
+			if r < 0x80 {
+				ascii.WriteRune(r)
+			} else {
+				for _, u := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&ascii, `\u%04x`, u)
+				}
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", dropboxURL, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Dropbox-API-Arg", ascii.String())
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %v", err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to upload %s: non-200 response from Dropbox: %d, body: %s%s", filepath.Base(file), resp.StatusCode, string(body), requestRef(resp))
+		}
+	}
+	return nil
+}
+
+// deliverDrive uploads the files into the folder named episode inside the
+// Drive folder, making it if needed, and replaces files of the same name.
+func (p *Pipeline) deliverDrive(ctx context.Context, parent, episode string, files []string) error {
+	token, err := googleAccessToken()
+	if err != nil {
+		return err
+	}
+	folder, err := p.driveFind(ctx, token, parent, episode, true)
+	if err != nil {
+		return err
+	}
+	if folder == "" {
+		var res struct {
+			ID string `json:"id"`
+		}
+		meta := map[string]any{"name": episode, "mimeType": driveFolderType, "parents": []string{parent}}
+		if err := p.googleRequest(ctx, token, "POST", driveFilesURL+"?supportsAllDrives=true", meta, &res); err != nil {
+			return fmt.Errorf("failed to make the Drive folder %s: %v", episode, err)
+		}
+		folder = res.ID
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(file)
+		existing, err := p.driveFind(ctx, token, folder, name, false)
+		if err != nil {
+			return err
+		}
+		if err := p.driveUpload(ctx, token, folder, existing, name, data); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// driveFind returns the ID of the file or, with folder set, the folder named
+// name in the parent folder, or "" if there's none.
+func (p *Pipeline) driveFind(ctx context.Context, token, parent, name string, folder bool) (string, error) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	q := fmt.Sprintf("'%s' in parents and name = '%s' and trashed = false", quote.Replace(parent), quote.Replace(name))
+	if folder {
+		q += fmt.Sprintf(" and mimeType = '%s'", driveFolderType)
+	}
+	query := url.Values{"q": {q}, "fields": {"files(id)"}, "supportsAllDrives": {"true"}, "includeItemsFromAllDrives": {"true"}}
+	var res struct {
+		Files []struct {
+			ID string `json:"id"`
+		} `json:"files"`
+	}
+	if err := p.googleRequest(ctx, token, "GET", driveFilesURL+"?"+query.Encode(), nil, &res); err != nil {
+		return "", fmt.Errorf("failed to search Drive: %v", err)
+	}
+	if len(res.Files) == 0 {
+		return "", nil
+	}
+	return res.Files[0].ID, nil
+}
+
+// driveUpload creates the file in the folder, or replaces the contents of
+// the existing file with that ID, with a multipart upload.
+func (p *Pipeline) driveUpload(ctx context.Context, token, folder, existing, name string, data []byte) error {
+	method, endpoint := "POST", driveUploadURL+"?uploadType=multipart&supportsAllDrives=true"
+	meta := map[string]any{"name": name, "parents": []string{folder}}
+	if existing != "" {
+		// The parents of a file can't be set on update
+		method, endpoint = "PATCH", driveUploadURL+"/"+url.PathEscape(existing)+"?uploadType=multipart&supportsAllDrives=true"
+		meta = map[string]any{"name": name}
+	}
+	metadata, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	part.Write(metadata)
+	part, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {fileContentType(name)}})
+	part.Write(data)
+	mw.Close()
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+	var res struct {
+		ID string `json:"id"`
+	}
+	return p.googleDo(req, &res)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Outcomes of a doctor check.
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "FAIL"
+)
+
+// doctorCheck is the outcome of one preflight check, with what to do about it
+// when it didn't pass.
+type doctorCheck struct {
+	status string
+	name   string
+	detail string
+}
+
+// doctor runs the preflight checks of the doctor command against one run's
+// configuration.
+type doctor struct {
+	p       *Pipeline
+	timeout time.Duration
+	checks  []doctorCheck
+}
+
+// report records the outcome of a check.
+func (d *doctor) report(status, name, format string, args ...any) {
+	d.checks = append(d.checks, doctorCheck{status, name, fmt.Sprintf(format, args...)})
+}
+
+// runDoctor implements the doctor command.
+func runDoctor(args []string) error {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	backendName := fs.String("backend", "openai", "Transcription backend the job will use")
+	fs.StringVar(&cfg.TranscriptionModel, "transcription-model", "", "Transcription model the job will use (default: the backend's)")
+	fs.StringVar(&cfg.DiarizationModel, "diarization-model", cfg.DiarizationModel, "Chat model the job will diarize with")
+	fs.StringVar(&cfg.SummaryModel, "summary-model", cfg.SummaryModel, "Chat model the job will summarize and draft with")
+	fs.StringVar(&cfg.ChatCompletionsURL, "chat-url", cfg.ChatCompletionsURL, "Chat completions endpoint the job will use")
+	fs.StringVar(&cfg.CacheDir, "cache-dir", cfg.CacheDir, "Cache directory the job will use")
+	outputDir := fs.String("output-dir", ".", "Directory the job will write its outputs to")
+	audioPath := fs.String("audio", "", "Audio file the job will transcribe, to check it can be read and that there is room for it")
+	configPath := fs.String("config", defaultConfigPath(), "Path to the JSON configuration file")
+	fs.DurationVar(&cfg.HTTPTimeout, "timeout", 15*time.Second, "Time allowed for each network check")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: podcast-transcription doctor [-backend name] [-audio file] [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	fileConfig, err := loadFileConfig(*configPath, set["config"])
+	if err != nil {
+		return err
+	}
+	if cfg.OpenAIOrganization == "" {
+		cfg.OpenAIOrganization = fileConfig.OpenAIOrganization
+	}
+	if cfg.OpenAIProject == "" {
+		cfg.OpenAIProject = fileConfig.OpenAIProject
+	}
+
+	d := &doctor{p: newPipeline(&cfg, nil), timeout: cfg.HTTPTimeout}
+	be, err := lookupBackend(*backendName)
+	if err != nil {
+		return err
+	}
+	if cfg.TranscriptionModel == "" {
+		cfg.TranscriptionModel = be.defaultModel
+	}
+	d.checkTools()
+	d.checkAudio(*audioPath)
+	d.checkDisk(*outputDir, *audioPath)
+	d.checkOpenAI(*backendName == "openai")
+	d.checkBackend(*backendName, be)
+
+	failed := 0
+	for _, c := range d.checks {
+		fmt.Printf("%-5s %s: %s\n", c.status, c.name, c.detail)
+		if c.status == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d check(s) failed", failed, len(d.checks))
+	}
+	fmt.Println("Ready to run")
+	return nil
+}
+
+// checkTools looks for ffmpeg and ffprobe, which chunking, slicing, clips
+// and the audio checks use.
+func (d *doctor) checkTools() {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			d.report(checkWarn, tool, "not found on PATH; WAV and MP3 files are still measured and cut without it, but other formats can't be chunked or sliced, and format conversion, -speedup, -exclude, -clip-dir and the local backend's parallel parts need it (install it with your package manager, e.g. apt install ffmpeg)")
+			continue
+		}
+		out, err := exec.Command(path, "-version").Output()
+		if err != nil {
+			d.report(checkFail, tool, "%s doesn't run: %v", path, err)
+			continue
+		}
+		version, _, _ := strings.Cut(string(out), "\n")
+		if fields := strings.Fields(version); len(fields) >= 3 {
+			version = strings.Join(fields[:3], " ")
+		}
+		d.report(checkOK, tool, "%s (%s)", path, version)
+	}
+}
+
+// checkAudio checks that the audio can be read and, where ffprobe can tell,
+// how long it is.
+func (d *doctor) checkAudio(path string) {
+	if path == "" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		d.report(checkFail, "audio", "%v", err)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		d.report(checkFail, "audio", "%v", err)
+		return
+	}
+	f.Close()
+	duration, err := probeDuration(path)
+	if err != nil {
+		d.report(checkWarn, "audio", "%s, %s; its length couldn't be read (%v)", path, formatBytes(info.Size()), err)
+		return
+	}
+	d.report(checkOK, "audio", "%s, %s, %s long", path, formatBytes(info.Size()), formatElapsed(duration))
+	if info.Size() > d.p.config.MaxAudioFileSize {
+		d.report(checkWarn, "audio size", "%s is over the %s upload limit; it will be compressed, or use -chunk", formatBytes(info.Size()), formatBytes(d.p.config.MaxAudioFileSize))
+	}
+}
+
+// checkDisk checks that the cache and output directories can be written and
+// have room for the job.
+func (d *doctor) checkDisk(outputDir, audioPath string) {
+	var need int64
+	if info, err := os.Stat(audioPath); err == nil {
+		need = info.Size()
+	}
+	for _, dir := range []struct{ name, path string }{{"cache directory", d.p.config.CacheDir}, {"output directory", outputDir}} {
+		if err := os.MkdirAll(dir.path, 0755); err != nil {
+			d.report(checkFail, dir.name, "%v", err)
+			continue
+		}
+		probe, err := os.CreateTemp(dir.path, ".doctor-*")
+		if err != nil {
+			d.report(checkFail, dir.name, "%s isn't writable: %v", dir.path, err)
+			continue
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+		free, ok := freeDiskSpace(dir.path)
+		switch {
+		case !ok:
+			d.report(checkOK, dir.name, "%s is writable", dir.path)
+		case free < need+diskHeadroom:
+			d.report(checkFail, dir.name, "%s has %s free, about %s needed; free some space or point -cache-dir/-output-dir at another disk", dir.path, formatBytes(free), formatBytes(need+diskHeadroom))
+		default:
+			d.report(checkOK, dir.name, "%s, %s free", dir.path, formatBytes(free))
+		}
+	}
+}
+
+// checkOpenAI checks the OpenAI key and that it can use the chat models, and
+// the transcription model when openai is the backend.
+func (d *doctor) checkOpenAI(transcribes bool) {
+	key := os.Getenv("OPENAI_API_KEY")
+	if key == "" {
+		status := checkWarn
+		if transcribes {
+			status = checkFail
+		}
+		d.report(status, "OPENAI_API_KEY", "not set; needed for transcription with -backend openai and for diarization, summaries and the other chat stages (export it from https://platform.openai.com/api-keys)")
+		return
+	}
+	d.report(checkOK, "OPENAI_API_KEY", "set (%s)", maskKey(key))
+	base := strings.TrimSuffix(d.p.config.ChatCompletionsURL, "/chat/completions")
+	models := []string{d.p.config.DiarizationModel}
+	if d.p.config.SummaryModel != d.p.config.DiarizationModel {
+		models = append(models, d.p.config.SummaryModel)
+	}
+	if transcribes {
+		models = append(models, d.p.config.TranscriptionModel)
+	}
+	for _, model := range models {
+		name := "model " + model
+		status, body, err := d.get(base+"/models/"+url.PathEscape(model), func(req *http.Request) { d.p.setOpenAIHeaders(req, key) })
+		switch {
+		case err != nil:
+			d.report(checkFail, name, "%s is unreachable: %v; check the network, proxy settings or -chat-url", base, err)
+			return
+		case status == http.StatusUnauthorized:
+			d.report(checkFail, name, "the API key was rejected (401); check OPENAI_API_KEY, and -openai-org/-openai-project if the key is limited to them")
+			return
+		case status == http.StatusNotFound || status == http.StatusForbidden:
+			d.report(checkFail, name, "not available to this key (%d); check the model name or the project's model access", status)
+		case status != http.StatusOK:
+			d.report(checkWarn, name, "unexpected response %d: %s", status, truncateWords(body, 20))
+		default:
+			d.report(checkOK, name, "available")
+		}
+	}
+}
+
+// checkBackend checks the transcription backend's credentials and that its
+// endpoint answers, or for the local backend that its pipeline can run.
+func (d *doctor) checkBackend(name string, be backend) {
+	check := "backend " + name
+	if name == "openai" {
+		// Checked with the OpenAI models
+		return
+	}
+	if name == "local" {
+		d.checkLocal(check)
+		return
+	}
+	key, err := be.apiKey()
+	if err != nil {
+		d.report(checkFail, check, "%v", err)
+		return
+	}
+	if be.endpoint == "" {
+		d.report(checkOK, check, "credentials found")
+		return
+	}
+	var (
+		target = be.endpoint
+		auth   func(*http.Request)
+	)
+	switch name {
+	case "deepgram":
+		target = strings.TrimSuffix(be.endpoint, "/listen") + "/projects"
+		auth = func(req *http.Request) { req.Header.Set("Authorization", "Token "+key) }
+	case "assemblyai":
+		target = be.endpoint + "/transcript?limit=1"
+		auth = func(req *http.Request) { req.Header.Set("Authorization", key) }
+	}
+	status, _, err := d.get(target, auth)
+	switch {
+	case err != nil:
+		d.report(checkFail, check, "%s is unreachable: %v", be.endpoint, err)
+	case auth != nil && (status == http.StatusUnauthorized || status == http.StatusForbidden):
+		d.report(checkFail, check, "the API key was rejected (%d); check %s", status, be.keyEnv)
+	case auth != nil && status != http.StatusOK:
+		d.report(checkWarn, check, "unexpected response %d from %s", status, target)
+	case auth != nil:
+		d.report(checkOK, check, "API key accepted")
+	default:
+		// Signed requests aren't checked beyond reaching the endpoint
+		d.report(checkOK, check, "credentials found, %s reachable", be.endpoint)
+	}
+}
+
+// checkLocal checks that the local pipeline's command is installed, or that
+// its server answers, and reports the devices it will run on.
+func (d *doctor) checkLocal(check string) {
+	cfg := d.p.config
+	if cfg.LocalURL != "" {
+		if _, _, err := d.get(cfg.LocalURL, nil); err != nil {
+			d.report(checkFail, check, "%s is unreachable: %v", cfg.LocalURL, err)
+		} else {
+			d.report(checkOK, check, "%s reachable", cfg.LocalURL)
+		}
+		return
+	}
+	program := strings.Fields(cfg.LocalCommand)[0]
+	if _, err := exec.LookPath(program); err != nil {
+		d.report(checkFail, check, "%s not found on PATH; install it (pip install whisperx) or set -local-command", program)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	d.report(checkOK, check, "%s installed; %s", program, describeStreams(d.p.localStreams(ctx)))
+	if os.Getenv("HF_TOKEN") == "" && strings.Contains(cfg.LocalCommand, "--diarize") {
+		d.report(checkWarn, "HF_TOKEN", "not set; pyannote needs it to download its diarization models the first time")
+	}
+}
+
+// get sends a GET request to target, with auth setting its credentials, and
+// returns the status and the start of the body.
+func (d *doctor) get(target string, auth func(*http.Request)) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if auth != nil {
+		auth(req)
+	}
+	resp, err := d.p.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, strings.TrimSpace(string(body)), nil
+}
+
+// maskKey shows enough of an API key to tell which one is set.
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:3] + "..." + key[len(key)-4:]
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archivedEpisode is a processed episode recorded in the state store, so that
+// a re-downloaded copy of its audio isn't transcribed and paid for again.
+type archivedEpisode struct {
+	Dir       string    `json:"dir"`
+	Title     string    `json:"title,omitempty"`
+	Processed time.Time `json:"processed"`
+	Outputs   []string  `json:"outputs"`
+}
+
+// audioKey identifies the audio by its blocks, which leave out the ID3 tag,
+// so that a copy retagged by the feed host or a player still matches.
+func (fp *AudioFingerprint) audioKey() string {
+	h := sha256.New()
+	for _, b := range fp.Blocks {
+		h.Write([]byte(b))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// absDir returns the absolute path of an output directory, "" being the
+// current one.
+func absDir(dir string) string {
+	if dir == "" {
+		dir = "."
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// findDuplicate returns the episode the audio of fp was already processed as,
+// if that was into a directory other than dir and its outputs are still there.
+func (s *stateStore) findDuplicate(fp *AudioFingerprint, dir string) (*archivedEpisode, error) {
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	ep, ok := st.Archive[fp.audioKey()]
+	if !ok || ep.Dir == dir {
+		return nil, nil
+	}
+	for _, path := range ep.Outputs {
+		if _, err := os.Stat(path); err == nil {
+			return &ep, nil
+		}
+	}
+	// The earlier outputs were deleted, so there is nothing to reuse
+	return nil, nil
+}
+
+// recordEpisode adds the episode processed from the audio of fp to the archive.
+func (s *stateStore) recordEpisode(fp *AudioFingerprint, ep archivedEpisode) error {
+	return s.update(func(st *State) {
+		st.Archive[fp.audioKey()] = ep
+	})
+}
+
+// linkDuplicate links the outputs of ep into dir under their own names, in
+// place of processing the audio again. Existing links are replaced; other
+// files are never overwritten.
+func linkDuplicate(ep *archivedEpisode, dir string) ([]string, error) {
+	var linked []string
+	for _, target := range ep.Outputs {
+		if _, err := os.Stat(target); err != nil {
+			continue
+		}
+		link := filepath.Join(dir, filepath.Base(target))
+		if info, err := os.Lstat(link); err == nil {
+			if info.Mode()&os.ModeSymlink == 0 {
+				return linked, fmt.Errorf("not linking %s: the file exists", link)
+			}
+			os.Remove(link)
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return linked, fmt.Errorf("failed to link %s: %v", link, err)
+		}
+		linked = append(linked, link)
+	}
+	return linked, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultSendGridURL is the SendGrid v3 mail send endpoint.
+const defaultSendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// emailMessage is an email with plain-text and optional HTML alternatives,
+// and optional attachments.
+type emailMessage struct {
+	from        string
+	to          []string
+	subject     string
+	text        string
+	html        string
+	attachments []emailAttachment
+}
+
+// emailAttachment is a file attached to an email.
+type emailAttachment struct {
+	name string
+	data []byte
+}
+
+// fileContentType returns the MIME type of a file by its extension.
+func fileContentType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// smtpSettings are read from the SMTP_* environment variables, so that
+// passwords stay out of flags and the configuration file.
+type smtpSettings struct {
+	host, port         string
+	username, password string
+}
+
+func smtpSettingsFromEnv() (smtpSettings, error) {
+	s := smtpSettings{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     os.Getenv("SMTP_PORT"),
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+	}
+	if s.host == "" {
+		return s, fmt.Errorf("SMTP_HOST is not set")
+	}
+	if s.port == "" {
+		s.port = "587"
+	}
+	return s, nil
+}
+
+// bytes renders the message as MIME: multipart/alternative when it has HTML,
+// inside multipart/mixed when it has attachments.
+func (m *emailMessage) bytes() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if len(m.attachments) == 0 {
+		if err := m.writeBody(&b); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+	boundary, err := mimeBoundary("mixed")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	if err := m.writeBody(&b); err != nil {
+		return nil, err
+	}
+	for _, a := range m.attachments {
+		fmt.Fprintf(&b, "--%s\r\nContent-Type: %s\r\nContent-Transfer-Encoding: base64\r\n", boundary, fileContentType(a.name))
+		fmt.Fprintf(&b, "Content-Disposition: attachment; filename=%q\r\n\r\n", mime.QEncoding.Encode("utf-8", a.name))
+		writeBase64Lines(&b, string(a.data))
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
+
+// writeBody writes the headers and body of the message's text, with its HTML
+// alternative if it has one.
+func (m *emailMessage) writeBody(b *bytes.Buffer) error {
+	if m.html == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64Lines(b, m.text)
+		return nil
+	}
+	boundary, err := mimeBoundary("alt")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct{ kind, body string }{{"text/plain", m.text}, {"text/html", m.html}} {
+		fmt.Fprintf(b, "--%s\r\nContent-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n", boundary, part.kind)
+		writeBase64Lines(b, part.body)
+	}
+	fmt.Fprintf(b, "--%s--\r\n", boundary)
+	return nil
+}
+
+// mimeBoundary returns a random multipart boundary starting with prefix.
+func mimeBoundary(prefix string) (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return prefix + "-" + hex.EncodeToString(nonce), nil
+}
+
+// writeBase64Lines writes s base64-encoded in 76-character lines, as MIME
+// requires.
+func writeBase64Lines(w io.Writer, s string) {
+	enc := base64.StdEncoding.EncodeToString([]byte(s))
+	for len(enc) > 76 {
+		fmt.Fprintf(w, "%s\r\n", enc[:76])
+		enc = enc[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", enc)
+}
+
+// sendSMTP delivers m through the server in s. Port 465 uses implicit TLS;
+// other ports upgrade with STARTTLS when the server offers it, which
+// smtp.SendMail does.
+func sendSMTP(m *emailMessage, s smtpSettings) error {
+	data, err := m.bytes()
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(s.host, s.port)
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	if s.port != "465" {
+		if err := smtp.SendMail(addr, auth, m.from, m.to, data); err != nil {
+			return fmt.Errorf("failed to send email: %v", err)
+		}
+		return nil
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: s.host})
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("failed to send email to %s: %v", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return c.Quit()
+}
+
+// sendSendGrid delivers m through SendGrid's API with the key in
+// SENDGRID_API_KEY.
+func (p *Pipeline) sendSendGrid(ctx context.Context, m *emailMessage) error {
+	key := os.Getenv("SENDGRID_API_KEY")
+	if key == "" {
+		return fmt.Errorf("SENDGRID_API_KEY is not set")
+	}
+	type address struct {
+		Email string `json:"email"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	type attachment struct {
+		Content  string `json:"content"`
+		Filename string `json:"filename"`
+		Type     string `json:"type"`
+	}
+	var to []address
+	for _, addr := range m.to {
+		to = append(to, address{addr})
+	}
+	contents := []content{{"text/plain", m.text}}
+	if m.html != "" {
+		contents = append(contents, content{"text/html", m.html})
+	}
+	mail := map[string]any{
+		"personalizations": []map[string]any{{"to": to}},
+		"from":             address{m.from},
+		"subject":          m.subject,
+		"content":          contents,
+	}
+	if len(m.attachments) > 0 {
+		var attachments []attachment
+		for _, a := range m.attachments {
+			attachments = append(attachments, attachment{base64.StdEncoding.EncodeToString(a.data), a.name, fileContentType(a.name)})
+		}
+		mail["attachments"] = attachments
+	}
+	body, err := json.Marshal(mail)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", defaultSendGridURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("non-2xx response from SendGrid: %d, body: %s", resp.StatusCode, string(msg))
+	}
+	return nil
+}
+
+// sendEmail delivers m with the given method, "smtp" or "sendgrid".
+func (p *Pipeline) sendEmail(ctx context.Context, method string, m *emailMessage) error {
+	switch method {
+	case "", "smtp":
+		s, err := smtpSettingsFromEnv()
+		if err != nil {
+			return err
+		}
+		if m.from == "" {
+			m.from = s.username
+		}
+		if m.from == "" {
+			return fmt.Errorf("no sender address; set it with -email-from")
+		}
+		return sendSMTP(m, s)
+	case "sendgrid":
+		if m.from == "" {
+			return fmt.Errorf("no sender address; set it with -email-from")
+		}
+		return p.sendSendGrid(ctx, m)
+	}
+	return fmt.Errorf("unknown email delivery %q (available: smtp, sendgrid)", method)
+}
+
+// maxAttachmentBytes caps the files attached to an -email-outputs email.
+// Base64 makes them a third larger, and many mail servers reject emails over
+// 25 MB.
+const maxAttachmentBytes = 15 << 20
+
+// outputsEmail is the -email-outputs email about the episode: its outputs
+// attached, in order, as far as they fit in maxAttachmentBytes, and the rest
+// listed by path.
+func outputsEmail(title string, outputs []string) (*emailMessage, error) {
+	m := &emailMessage{subject: "Transcript: " + title}
+	var attached, listed strings.Builder
+	total := 0
+	for _, path := range outputs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		if total+len(data) > maxAttachmentBytes {
+			abs, _ := filepath.Abs(path)
+			fmt.Fprintf(&listed, "  %s (%s)\n", abs, formatBytes(int64(len(data))))
+			continue
+		}
+		total += len(data)
+		m.attachments = append(m.attachments, emailAttachment{filepath.Base(path), data})
+		fmt.Fprintf(&attached, "  %s (%s)\n", filepath.Base(path), formatBytes(int64(len(data))))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "The outputs of %s are ready.\n", title)
+	if attached.Len() > 0 {
+		fmt.Fprintf(&b, "\nAttached:\n%s", attached.String())
+	}
+	if listed.Len() > 0 {
+		host, _ := os.Hostname()
+		fmt.Fprintf(&b, "\nToo large to attach, on %s:\n%s", firstNonEmpty(host, "the machine that processed it"), listed.String())
+	}
+	m.text = b.String()
+	return m, nil
+}
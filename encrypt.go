@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// encryptionKeyEnv holds the at-rest encryption key itself, for when no
+// -encryption-key file is given.
+const encryptionKeyEnv = "PODCAST_TRANSCRIPTION_KEY"
+
+// sealedMagic starts every file encrypted at rest. It is followed by the GCM
+// nonce and the AES-256-GCM ciphertext of the original contents.
+var sealedMagic = []byte("PTSEALED1\n")
+
+// loadEncryptionKey reads the AES-256 key outputs, cached transcripts and the
+// state store are encrypted at rest with from the file at path, or from
+// $PODCAST_TRANSCRIPTION_KEY if path is empty. Without either it returns nil,
+// and files are written in the clear.
+func loadEncryptionKey(path string) ([]byte, error) {
+	text := os.Getenv(encryptionKeyEnv)
+	source := "$" + encryptionKeyEnv
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key: %v", err)
+		}
+		text, source = string(data), path
+	}
+	if strings.TrimSpace(text) == "" {
+		if path != "" {
+			return nil, fmt.Errorf("encryption key file %s is empty", path)
+		}
+		return nil, nil
+	}
+	key, err := parseEncryptionKey(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return key, nil
+}
+
+// parseEncryptionKey decodes a 256-bit key written as hex or base64, e.g. by
+// openssl rand -base64 32.
+func parseEncryptionKey(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("want a 32-byte key as hex or base64, e.g. from openssl rand -base64 32")
+}
+
+// storageCipher returns the AEAD of the at-rest key.
+func storageCipher(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, fmt.Errorf("file is encrypted; give its key with -encryption-key or $%s", encryptionKeyEnv)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealStored encrypts data with key for writing, and returns it unchanged
+// when key is nil and encryption at rest is off.
+func sealStored(key, data []byte) ([]byte, error) {
+	if key == nil {
+		return data, nil
+	}
+	aead, err := storageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, sealedMagic...), nonce...)
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// isSealed reports whether data was written by sealStored.
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// openStored decrypts data written by sealStored with key, and returns
+// anything else unchanged, so files written in the clear stay readable.
+func openStored(key, data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	aead, err := storageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(sealedMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt: wrong key or corrupted file")
+	}
+	return plain, nil
+}
+
+// readStored reads the file at path, decrypting it if it's encrypted.
+func readStored(key []byte, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return openStored(key, data)
+}
+
+// storedReader returns a reader of the contents of r, decrypting them if
+// they're encrypted. Files in the clear are streamed rather than read whole.
+func storedReader(key []byte, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(sealedMagic)); !isSealed(head) {
+		return br, nil
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openStored(key, data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// runDecrypt implements the decrypt command, which prints files encrypted at
+// rest for tools that can't read them.
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFile := fs.String("encryption-key", "", "File holding the key (default: $"+encryptionKeyEnv+")")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: podcast-transcription decrypt [flags] <file>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no files given")
+	}
+	key, err := loadEncryptionKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args() {
+		data, err := readStored(key, path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+	tests := []struct {
+		in  string
+		err bool
+	}{
+		{hex.EncodeToString(key), false},
+		{base64.StdEncoding.EncodeToString(key) + "\n", false},
+		{hex.EncodeToString(key[:16]), true},
+		{base64.StdEncoding.EncodeToString(key[:24]), true},
+		{"correct horse battery staple", true},
+	}
+	for _, tt := range tests {
+		got, err := parseEncryptionKey(tt.in)
+		if (err != nil) != tt.err || (err == nil && !bytes.Equal(got, key)) {
+			t.Errorf("parseEncryptionKey(%q) = %x, %v; want error %v", tt.in, got, err, tt.err)
+		}
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0x01}, 32)
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0o600)
+	emptyFile := filepath.Join(dir, "empty")
+	os.WriteFile(emptyFile, []byte("\n"), 0o600)
+
+	tests := []struct {
+		name, path, env string
+		want            []byte
+		err             bool
+	}{
+		{"off", "", "", nil, false},
+		{"environment", "", base64.StdEncoding.EncodeToString(key), key, false},
+		{"file over environment", keyFile, "not a key", key, false},
+		{"empty file", emptyFile, "", nil, true},
+		{"missing file", filepath.Join(dir, "missing"), "", nil, true},
+		{"bad environment", "", "not a key", nil, true},
+	}
+	for _, tt := range tests {
+		t.Setenv(encryptionKeyEnv, tt.env)
+		got, err := loadEncryptionKey(tt.path)
+		if (err != nil) != tt.err || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: loadEncryptionKey = %x, %v; want %x, error %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestSealStored(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	other := bytes.Repeat([]byte{0x24}, 32)
+	plain := []byte(`{"text": "Welcome to the show."}`)
+
+	sealed, err := sealStored(key, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(sealed) || bytes.Contains(sealed, plain) {
+		t.Fatalf("sealStored = %q, want it encrypted", sealed)
+	}
+	if again, _ := sealStored(key, plain); bytes.Equal(again, sealed) {
+		t.Errorf("sealing twice gave the same ciphertext; the nonce is reused")
+	}
+	if clear, _ := sealStored(nil, plain); !bytes.Equal(clear, plain) {
+		t.Errorf("sealStored(nil) = %q, want it unchanged", clear)
+	}
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+	tests := []struct {
+		name string
+		key  []byte
+		data []byte
+		want []byte
+		err  string
+	}{
+		{"round trip", key, sealed, plain, ""},
+		{"clear file", key, plain, plain, ""},
+		{"clear file without key", nil, plain, plain, ""},
+		{"wrong key", other, sealed, nil, "wrong key or corrupted"},
+		{"tampered", key, flipped, nil, "wrong key or corrupted"},
+		{"truncated", key, sealed[:len(sealedMagic)+4], nil, "truncated"},
+		{"no key", nil, sealed, nil, "-encryption-key"},
+	}
+	for _, tt := range tests {
+		got, err := openStored(tt.key, tt.data)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: openStored error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: openStored = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, data := range [][]byte{sealed, plain} {
+		r, err := storedReader(key, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(r); !bytes.Equal(got, plain) {
+			t.Errorf("storedReader = %q, want %q", got, plain)
+		}
+	}
+}
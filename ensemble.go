@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ensembleAgreement is the share of their words two transcriptions of a
+// segment must have in common for the first one to be kept as is. Below it,
+// the more confident of the two wins.
+const ensembleAgreement = 0.9
+
+// transcribeEnsemble transcribes the audio with be and, at the same time, with
+// the -ensemble backend other, and merges the second transcription into the
+// first with mergeEnsemble. It returns the merged transcript, which keeps the
+// segments and speakers of be's, and how many segments took other's words.
+func (p *Pipeline) transcribeEnsemble(ctx context.Context, be backend, key string, other backend, audioPath string) (*Transcript, int, error) {
+	otherKey, err := other.apiKey()
+	if err != nil {
+		return nil, 0, fmt.Errorf("-ensemble: %v", err)
+	}
+	// The other backend transcribes with its own default model
+	cfg := *p.config
+	cfg.TranscriptionModel = other.defaultModel
+	second := &Pipeline{config: &cfg, client: p.client, identity: p.identity, console: p.console}
+
+	type result struct {
+		t   *Transcript
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		t, err := other.transcribe(second, ctx, otherKey, audioPath)
+		done <- result{t, err}
+	}()
+	primary, err := be.transcribe(p, ctx, key, audioPath)
+	res := <-done
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.err != nil {
+		return nil, 0, fmt.Errorf("-ensemble: %v", res.err)
+	}
+	n := mergeEnsemble(primary, res.t)
+	if primary.Models == nil {
+		primary.Models = map[string]string{}
+	}
+	primary.Models["ensemble"] = firstNonEmpty(res.t.Models["transcription"], other.defaultModel)
+	return primary, n, nil
+}
+
+// mergeEnsemble compares each segment of primary with the words of secondary
+// spoken during it. Where the two mostly agree, or either's confidence is
+// unknown, the segment is kept; where they disagree, it takes secondary's
+// words if secondary is the more confident. It returns how many segments
+// were replaced.
+func mergeEnsemble(primary, secondary *Transcript) int {
+	words := confidentWords(secondary)
+	replaced, next := 0, 0
+	for i := range primary.Segments {
+		s := &primary.Segments[i]
+		if s.Kind == eventKind {
+			continue
+		}
+		// Each word of secondary goes to the segment its middle falls in
+		for next < len(words) && (words[next].Start+words[next].End)/2 < s.Start {
+			next++
+		}
+		var alt []Word
+		for next < len(words) && (words[next].Start+words[next].End)/2 < s.End {
+			alt = append(alt, words[next])
+			next++
+		}
+		if len(alt) == 0 {
+			continue
+		}
+		texts := make([]string, len(alt))
+		for j, w := range alt {
+			texts[j] = strings.TrimSpace(w.Text)
+		}
+		text := strings.Join(texts, " ")
+		a, b := normalizedWords(s.Text), normalizedWords(text)
+		if len(a)+len(b) == 0 {
+			continue
+		}
+		d, _ := editDistance(a, b, len(a)+len(b))
+		if 1-float64(d)/float64(len(a)+len(b)) >= ensembleAgreement {
+			continue
+		}
+		mine, ok := segmentConfidence(*s)
+		theirs, _ := wordConfidence(alt)
+		if !ok || theirs <= mine {
+			continue
+		}
+		if c, ok := wordSpeakerConfidence(s.Words); ok && s.SpeakerConfidence == 0 {
+			// The speaker confidence is of the words being replaced
+			s.SpeakerConfidence = c
+		}
+		s.Text, s.Words = text, alt
+		replaced++
+	}
+	if replaced > 0 {
+		primary.Text = joinSegmentText(primary.Segments)
+	}
+	return replaced
+}
+
+// confidentWords returns the words of t's speech with a confidence each: the
+// provider's, or else that of their segment. Segments without word timings are
+// split into words spread evenly over the segment.
+func confidentWords(t *Transcript) []Word {
+	var words []Word
+	for _, s := range t.Segments {
+		if s.Kind == eventKind {
+			continue
+		}
+		conf, _ := segmentConfidence(s)
+		if len(s.Words) > 0 {
+			for _, w := range s.Words {
+				if w.Confidence == 0 {
+					w.Confidence = conf
+				}
+				w.SpeakerConfidence = 0
+				words = append(words, w)
+			}
+			continue
+		}
+		fields := strings.Fields(s.Text)
+		step := (s.End - s.Start) / float64(max(len(fields), 1))
+		for j, f := range fields {
+			start := s.Start + float64(j)*step
+			words = append(words, Word{Text: f, Start: start, End: start + step, Confidence: conf})
+		}
+	}
+	return words
+}
+
+// segmentConfidence is the mean confidence of the segment's words as the
+// provider reports it, or else Whisper's mean token probability, reporting
+// false when there's neither.
+func segmentConfidence(s Segment) (float64, bool) {
+	if c, ok := wordConfidence(s.Words); ok {
+		return c, true
+	}
+	if s.AvgLogprob != 0 {
+		return math.Exp(s.AvgLogprob), true
+	}
+	return 0, false
+}
+
+// wordConfidence averages the confidence of words, reporting false when no
+// word carries one.
+func wordConfidence(words []Word) (float64, bool) {
+	sum, reported := 0.0, false
+	for _, w := range words {
+		sum += w.Confidence
+		if w.Confidence > 0 {
+			reported = true
+		}
+	}
+	if !reported {
+		return 0, false
+	}
+	return sum / float64(len(words)), true
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// descriptionTokens caps the episode description included in the diarization prompt.
+const descriptionTokens = 400
+
+// loadEpisodeContext fills in config.Title and config.Description, which tell the
+// diarization model who the host and guests are. Values given on the command line
+// win; missing ones come from the show's RSS feed and then the audio file's ID3
+// tag. Lookup failures are warnings, since the context is optional.
+func (p *Pipeline) loadEpisodeContext(audioPath string) {
+	if strings.HasPrefix(p.config.Description, "@") {
+		data, err := os.ReadFile(p.config.Description[1:])
+		if err != nil {
+			p.console.warnf("failed to read description file: %v\n", err)
+			p.config.Description = ""
+		} else {
+			p.config.Description = string(data)
+		}
+	}
+
+	var tag id3Info
+	if audioPath != "" {
+		var err error
+		if tag, err = readID3(audioPath); err != nil {
+			p.console.warnf("failed to read ID3 tag: %v\n", err)
+		}
+	}
+	if p.config.FeedURL != "" && (p.config.Title == "" || p.config.Description == "") {
+		ctx, cancel := context.WithTimeout(context.Background(), p.config.HTTPTimeout)
+		defer cancel()
+		title := p.config.Title
+		if title == "" {
+			title = tag.title
+		}
+		audioName := ""
+		if audioPath != "" {
+			audioName = filepath.Base(audioPath)
+		}
+		item, err := p.findFeedItem(ctx, p.config.FeedURL, audioName, title)
+		switch {
+		case err != nil:
+			p.console.warnf("failed to read feed: %v\n", err)
+		case item != nil:
+			p.config.Title = firstNonEmpty(p.config.Title, item.Title)
+			p.config.Description = firstNonEmpty(p.config.Description, item.description())
+		}
+	}
+	p.config.Title = firstNonEmpty(p.config.Title, tag.title)
+	p.config.Description = firstNonEmpty(p.config.Description, tag.description)
+	p.config.Description = truncateTokens(strings.TrimSpace(p.config.Description), descriptionTokens)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// truncateTokens cuts s at a word boundary to roughly maxTokens.
+func truncateTokens(s string, maxTokens int) string {
+	if estimateTokens(s) <= maxTokens {
+		return s
+	}
+	words := strings.Fields(s)
+	var b strings.Builder
+	for _, w := range words {
+		if estimateTokens(b.String()+" "+w) > maxTokens {
+			break
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(w)
+	}
+	return b.String() + " …"
+}
+
+// feedItem is one <item> of a podcast RSS feed.
+type feedItem struct {
+	Title       string `xml:"title"`
+	Description string `xml:"description"`
+	Summary     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate     string `xml:"pubDate"`
+	Duration    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Enclosure   struct {
+		URL string `xml:"url,attr"`
+	} `xml:"enclosure"`
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// description returns the item's show notes as plain text.
+func (it *feedItem) description() string {
+	text := firstNonEmpty(it.Content, it.Description, it.Summary)
+	text = htmlTag.ReplaceAllString(text, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// findFeedItem fetches the RSS feed and returns the item whose enclosure has the
+// audio file's name or, failing that, whose title matches. It returns nil if
+// nothing matches.
+func (p *Pipeline) findFeedItem(ctx context.Context, feedURL, audioName, title string) (*feedItem, error) {
+	items, err := p.fetchFeed(ctx, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	for i, it := range items {
+		if u, err := url.Parse(it.Enclosure.URL); err == nil && audioName != "" && path.Base(u.Path) == audioName {
+			return &items[i], nil
+		}
+	}
+	for i, it := range items {
+		if title != "" && strings.EqualFold(strings.TrimSpace(it.Title), title) {
+			return &items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// fetchFeed fetches the RSS feed and returns its items.
+func (p *Pipeline) fetchFeed(ctx context.Context, feedURL string) ([]feedItem, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response from feed: %d", resp.StatusCode)
+	}
+	var feed struct {
+		Items []feedItem `xml:"channel>item"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %v", err)
+	}
+	return feed.Items, nil
+}
+
+// id3Info is the episode metadata found in an ID3v2 tag.
+type id3Info struct {
+	title       string
+	description string
+}
+
+// readID3 reads the title and description from the ID3v2 tag at the start of an
+// MP3 file. Files without a tag yield an empty result.
+func readID3(audioPath string) (id3Info, error) {
+	f, err := os.Open(audioPath)
+	if err != nil {
+		return id3Info{}, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:3]) != "ID3" {
+		return id3Info{}, nil
+	}
+	major, flags := header[3], header[5]
+	size := syncsafe(header[6:10])
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(f, tag); err != nil {
+		return id3Info{}, fmt.Errorf("truncated ID3 tag: %v", err)
+	}
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		// Skip the extended header
+		ext := int(binary.BigEndian.Uint32(tag[:4]))
+		if major == 4 {
+			ext = syncsafe(tag[:4])
+		} else {
+			ext += 4
+		}
+		tag = tag[min(ext, len(tag)):]
+	}
+
+	idLen, headerLen := 4, 10
+	if major == 2 {
+		idLen, headerLen = 3, 6
+	}
+	frames := map[string]string{}
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var n int
+		switch major {
+		case 2:
+			n = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 4:
+			n = syncsafe(tag[4:8])
+		default:
+			n = int(binary.BigEndian.Uint32(tag[4:8]))
+		}
+		if n <= 0 || headerLen+n > len(tag) {
+			break
+		}
+		body := tag[headerLen : headerLen+n]
+		tag = tag[headerLen+n:]
+		if _, seen := frames[id]; !seen {
+			frames[id] = id3Text(id, body)
+		}
+	}
+	return id3Info{
+		title:       firstNonEmpty(frames["TIT2"], frames["TT2"]),
+		description: firstNonEmpty(frames["TDES"], frames["COMM"], frames["COM"], frames["TIT3"], frames["TT3"]),
+	}, nil
+}
+
+// syncsafe decodes a 28-bit ID3 syncsafe integer.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// id3Text decodes a text or comment frame body; other frames yield "".
+func id3Text(id string, body []byte) string {
+	if len(body) < 1 || (id[0] != 'T' && id != "COMM" && id != "COM") {
+		return ""
+	}
+	enc, body := body[0], body[1:]
+	if id == "COMM" || id == "COM" {
+		// Skip the language code and the short content description
+		if len(body) < 3 {
+			return ""
+		}
+		body = body[3:]
+		_, body = splitID3String(enc, body)
+	}
+	s, _ := splitID3String(enc, body)
+	return strings.TrimSpace(s)
+}
+
+// splitID3String decodes one terminated string in encoding enc and returns it with
+// the remaining bytes.
+func splitID3String(enc byte, b []byte) (string, []byte) {
+	if enc == 1 || enc == 2 {
+		end := len(b)
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		rest := b[min(end+2, len(b)):]
+		return decodeUTF16(b[:end], enc == 2), rest
+	}
+	end := bytes.IndexByte(b, 0)
+	if end < 0 {
+		end = len(b)
+	}
+	rest := b[min(end+1, len(b)):]
+	if enc == 0 {
+		runes := make([]rune, end)
+		for i, c := range b[:end] {
+			runes[i] = rune(c)
+		}
+		return string(runes), rest
+	}
+	return string(b[:end]), rest
+}
+
+// decodeUTF16 decodes UTF-16 text, honouring a byte order mark if present.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// derFrame is the time resolution used to score diarization, as in md-eval.
+const derFrame = 0.01
+
+// evalReport is the result of the eval command.
+type evalReport struct {
+	WER *werScore `json:"wer,omitempty"`
+	DER *derScore `json:"der,omitempty"`
+}
+
+// werScore is a word error rate and its breakdown.
+type werScore struct {
+	ReferenceWords int     `json:"reference_words"`
+	Substitutions  int     `json:"substitutions"`
+	Deletions      int     `json:"deletions"`
+	Insertions     int     `json:"insertions"`
+	Rate           float64 `json:"rate"`
+}
+
+// derScore is a diarization error rate and its components, in seconds of scored
+// reference speech.
+type derScore struct {
+	ScoredSeconds    float64           `json:"scored_seconds"`
+	MissedSeconds    float64           `json:"missed_seconds"`
+	FalseAlarm       float64           `json:"false_alarm_seconds"`
+	ConfusionSeconds float64           `json:"confusion_seconds"`
+	Rate             float64           `json:"rate"`
+	Collar           float64           `json:"collar"`
+	Mapping          map[string]string `json:"mapping"`
+}
+
+// speakerSpan is one stretch of speech by one speaker.
+type speakerSpan struct {
+	Start, End float64
+	Speaker    string
+}
+
+// runEval implements the eval command.
+func runEval(args []string) error {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	hyp := flags.String("hyp", defaultConfig().DiarizedJSONFile, "Hypothesis: a diarized.json transcript or an RTTM file")
+	refText := flags.String("ref-text", "", "Reference transcript for WER: plain text or a canonical transcript JSON")
+	refRTTM := flags.String("rttm", "", "Reference RTTM file for DER")
+	collar := flags.Float64("collar", 0.25, "Seconds around each reference boundary excluded from DER scoring")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription eval [-hyp diarized.json] [-ref-text ref.txt] [-rttm ref.rttm] [-collar s] [-json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *refText == "" && *refRTTM == "" {
+		return fmt.Errorf("give -ref-text for WER, -rttm for DER, or both")
+	}
+
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	var report evalReport
+	if *refText != "" {
+		ref, err := loadReferenceText(key, *refText)
+		if err != nil {
+			return err
+		}
+		hypText, err := loadReferenceText(key, *hyp)
+		if err != nil {
+			return err
+		}
+		report.WER = wordErrorRate(normalizedWords(ref), normalizedWords(hypText))
+	}
+	if *refRTTM != "" {
+		ref, err := loadSpans(key, *refRTTM)
+		if err != nil {
+			return err
+		}
+		spans, err := loadSpans(key, *hyp)
+		if err != nil {
+			return err
+		}
+		report.DER = diarizationErrorRate(ref, spans, *collar)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if w := report.WER; w != nil {
+		fmt.Printf("WER: %.2f%% (%d reference words: %d substitutions, %d deletions, %d insertions)\n",
+			100*w.Rate, w.ReferenceWords, w.Substitutions, w.Deletions, w.Insertions)
+	}
+	if d := report.DER; d != nil {
+		fmt.Printf("DER: %.2f%% (%.1fs scored, collar %.2fs: %.1fs missed, %.1fs false alarm, %.1fs confusion)\n",
+			100*d.Rate, d.ScoredSeconds, d.Collar, d.MissedSeconds, d.FalseAlarm, d.ConfusionSeconds)
+		refs := make([]string, 0, len(d.Mapping))
+		for r := range d.Mapping {
+			refs = append(refs, r)
+		}
+		sort.Strings(refs)
+		for _, r := range refs {
+			fmt.Printf("  %s -> %s\n", r, d.Mapping[r])
+		}
+	}
+	return nil
+}
+
+// loadReferenceText returns the words of a plain-text or canonical JSON transcript.
+// Speaker labels in a diarized text file are not counted as words.
+func loadReferenceText(key []byte, path string) (string, error) {
+	data, err := readStored(key, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var t Transcript
+	if json.Unmarshal(data, &t) == nil && len(t.Segments) > 0 {
+		return joinSegmentText(t.Segments), nil
+	}
+	if turns := parseDiarized(string(data)); len(turns) > 0 && turns[0].Speaker != "Unknown" {
+		return joinSegmentText(turns), nil
+	}
+	return string(data), nil
+}
+
+// loadSpans reads speaker spans from an RTTM file or a canonical transcript JSON.
+func loadSpans(key []byte, path string) ([]speakerSpan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	if !strings.HasSuffix(strings.ToLower(path), ".rttm") {
+		t, err := loadTranscript(key, path)
+		if err != nil {
+			return nil, err
+		}
+		var spans []speakerSpan
+		for _, s := range t.Segments {
+			if s.Speaker != "" && s.End > s.Start {
+				spans = append(spans, speakerSpan{s.Start, s.End, s.Speaker})
+			}
+		}
+		return spans, nil
+	}
+
+	var spans []speakerSpan
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || fields[0] != "SPEAKER" {
+			continue
+		}
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("%s:%d: malformed RTTM line", path, line)
+		}
+		onset, err1 := strconv.ParseFloat(fields[3], 64)
+		dur, err2 := strconv.ParseFloat(fields[4], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s:%d: malformed RTTM times", path, line)
+		}
+		spans = append(spans, speakerSpan{onset, onset + dur, fields[7]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return spans, nil
+}
+
+// wordErrorRate aligns hyp against ref with unit-cost substitutions, deletions and
+// insertions.
+func wordErrorRate(ref, hyp []string) *werScore {
+	type cell struct{ cost, sub, del, ins int }
+	prev := make([]cell, len(hyp)+1)
+	cur := make([]cell, len(hyp)+1)
+	for j := range prev {
+		prev[j] = cell{cost: j, ins: j}
+	}
+	for i := 1; i <= len(ref); i++ {
+		cur[0] = cell{cost: i, del: i}
+		for j := 1; j <= len(hyp); j++ {
+			best := prev[j-1]
+			if ref[i-1] != hyp[j-1] {
+				best.cost++
+				best.sub++
+			}
+			if c := prev[j]; c.cost+1 < best.cost {
+				best = c
+				best.cost++
+				best.del++
+			}
+			if c := cur[j-1]; c.cost+1 < best.cost {
+				best = c
+				best.cost++
+				best.ins++
+			}
+			cur[j] = best
+		}
+		prev, cur = cur, prev
+	}
+	last := prev[len(hyp)]
+	w := &werScore{ReferenceWords: len(ref), Substitutions: last.sub, Deletions: last.del, Insertions: last.ins}
+	if len(ref) > 0 {
+		w.Rate = float64(last.cost) / float64(len(ref))
+	}
+	return w
+}
+
+// diarizationErrorRate scores hyp against ref frame by frame after mapping each
+// hypothesis speaker to the reference speaker it overlaps most (an optimal
+// one-to-one assignment). Overlapping speech is scored; frames within collar
+// seconds of a reference boundary are not.
+func diarizationErrorRate(ref, hyp []speakerSpan, collar float64) *derScore {
+	end := 0.0
+	for _, s := range append(append([]speakerSpan{}, ref...), hyp...) {
+		end = max(end, s.End)
+	}
+	frames := int(math.Ceil(end / derFrame))
+	refIdx, refNames := speakerIndex(ref)
+	hypIdx, hypNames := speakerIndex(hyp)
+	refAt := activeSpeakers(ref, refIdx, frames)
+	hypAt := activeSpeakers(hyp, hypIdx, frames)
+
+	scored := make([]bool, frames)
+	for i := range scored {
+		scored[i] = true
+	}
+	for _, s := range ref {
+		for _, b := range []float64{s.Start, s.End} {
+			for f := max(0, int((b-collar)/derFrame)); f < min(frames, int(math.Ceil((b+collar)/derFrame))); f++ {
+				scored[f] = false
+			}
+		}
+	}
+
+	// Overlap between every reference and hypothesis speaker
+	overlap := make([][]float64, len(refNames))
+	for i := range overlap {
+		overlap[i] = make([]float64, len(hypNames))
+	}
+	for f := 0; f < frames; f++ {
+		if !scored[f] {
+			continue
+		}
+		for _, r := range refAt[f] {
+			for _, h := range hypAt[f] {
+				overlap[r][h] += derFrame
+			}
+		}
+	}
+	assign := maxAssignment(overlap)
+
+	d := &derScore{Collar: collar, Mapping: map[string]string{}}
+	for r, h := range assign {
+		if h >= 0 {
+			d.Mapping[refNames[r]] = hypNames[h]
+		}
+	}
+	var scoredFrames, missed, falseAlarm, confusion int
+	for f := 0; f < frames; f++ {
+		if !scored[f] {
+			continue
+		}
+		nRef, nHyp := len(refAt[f]), len(hypAt[f])
+		correct := 0
+		for _, r := range refAt[f] {
+			for _, h := range hypAt[f] {
+				if assign[r] == h {
+					correct++
+				}
+			}
+		}
+		scoredFrames += nRef
+		missed += max(0, nRef-nHyp)
+		falseAlarm += max(0, nHyp-nRef)
+		confusion += min(nRef, nHyp) - correct
+	}
+	d.ScoredSeconds = float64(scoredFrames) * derFrame
+	d.MissedSeconds = float64(missed) * derFrame
+	d.FalseAlarm = float64(falseAlarm) * derFrame
+	d.ConfusionSeconds = float64(confusion) * derFrame
+	if scoredFrames > 0 {
+		d.Rate = float64(missed+falseAlarm+confusion) / float64(scoredFrames)
+	}
+	return d
+}
+
+// speakerIndex numbers the distinct speakers of spans.
+func speakerIndex(spans []speakerSpan) (map[string]int, []string) {
+	idx := map[string]int{}
+	var names []string
+	for _, s := range spans {
+		if _, ok := idx[s.Speaker]; !ok {
+			idx[s.Speaker] = len(names)
+			names = append(names, s.Speaker)
+		}
+	}
+	return idx, names
+}
+
+// activeSpeakers lists, for every frame, the speakers talking in it.
+func activeSpeakers(spans []speakerSpan, idx map[string]int, frames int) [][]int {
+	at := make([][]int, frames)
+	for _, s := range spans {
+		sp := idx[s.Speaker]
+		for f := max(0, int(s.Start/derFrame+0.5)); f < min(frames, int(s.End/derFrame+0.5)); f++ {
+			if !containsInt(at[f], sp) {
+				at[f] = append(at[f], sp)
+			}
+		}
+	}
+	return at
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// maxAssignment returns, for each row of weight, the column assigned to it in a
+// one-to-one assignment maximising the total weight, or -1. It uses the Hungarian
+// algorithm on the padded square cost matrix.
+func maxAssignment(weight [][]float64) []int {
+	rows := len(weight)
+	cols := 0
+	if rows > 0 {
+		cols = len(weight[0])
+	}
+	n := max(rows, cols)
+	maxW := 0.0
+	for _, row := range weight {
+		for _, w := range row {
+			maxW = max(maxW, w)
+		}
+	}
+	cost := func(i, j int) float64 {
+		if i < rows && j < cols {
+			return maxW - weight[i][j]
+		}
+		return maxW
+	}
+
+	// u, v are the potentials; p[j] is the row matched to column j (1-based).
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				if c := cost(i0-1, j-1) - u[i0] - v[j]; c < minv[j] {
+					minv[j], way[j] = c, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assign := make([]int, rows)
+	for i := range assign {
+		assign[i] = -1
+	}
+	for j := 1; j <= n; j++ {
+		if i := p[j] - 1; i < rows && j-1 < cols && weight[i][j-1] > 0 {
+			assign[i] = j - 1
+		}
+	}
+	return assign
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWordErrorRate(t *testing.T) {
+	tests := []struct {
+		ref, hyp      string
+		sub, del, ins int
+		rate          float64
+	}{
+		{"the cat sat", "the cat sat", 0, 0, 0, 0},
+		{"the cat sat", "the dog sat", 1, 0, 0, 1.0 / 3},
+		{"the cat sat", "the sat", 0, 1, 0, 1.0 / 3},
+		{"the cat sat", "the cat sat down", 0, 0, 1, 1.0 / 3},
+		{"the cat sat", "", 0, 3, 0, 1},
+		{"a b c d", "x a b d", 0, 1, 1, 0.5},
+	}
+	for _, tt := range tests {
+		w := wordErrorRate(strings.Fields(tt.ref), strings.Fields(tt.hyp))
+		if w.Substitutions != tt.sub || w.Deletions != tt.del || w.Insertions != tt.ins || math.Abs(w.Rate-tt.rate) > 1e-9 {
+			t.Errorf("WER(%q, %q) = %d sub %d del %d ins %.3f, want %d %d %d %.3f", tt.ref, tt.hyp,
+				w.Substitutions, w.Deletions, w.Insertions, w.Rate, tt.sub, tt.del, tt.ins, tt.rate)
+		}
+	}
+}
+
+func TestMaxAssignment(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight [][]float64
+		want   []int
+	}{
+		{"empty", nil, []int{}},
+		{"diagonal", [][]float64{{5, 1}, {1, 5}}, []int{0, 1}},
+		{"crossed", [][]float64{{1, 5}, {5, 1}}, []int{1, 0}},
+		{"greedy loses", [][]float64{{9, 8}, {8, 1}}, []int{1, 0}},
+		{"more rows", [][]float64{{1}, {7}, {3}}, []int{-1, 0, -1}},
+		{"more columns", [][]float64{{1, 7, 3}}, []int{1}},
+		{"three", [][]float64{{4, 1, 3}, {2, 0, 5}, {3, 2, 2}}, []int{0, 2, 1}},
+	}
+	for _, tt := range tests {
+		got := maxAssignment(tt.weight)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: maxAssignment = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiarizationErrorRate(t *testing.T) {
+	ref := []speakerSpan{{0, 10, "alice"}, {10, 20, "bob"}}
+	tests := []struct {
+		name   string
+		hyp    []speakerSpan
+		collar float64
+		rate   float64
+		// missed, false alarm and confusion seconds
+		missed, falseAlarm, confusion float64
+	}{
+		{"perfect with other labels", []speakerSpan{{0, 10, "S1"}, {10, 20, "S2"}}, 0, 0, 0, 0, 0},
+		{"one speaker throughout", []speakerSpan{{0, 20, "S1"}}, 0, 0.5, 0, 0, 10},
+		{"second half missed", []speakerSpan{{0, 10, "S1"}}, 0, 0.5, 10, 0, 0},
+		{"extra speech", []speakerSpan{{0, 10, "S1"}, {10, 20, "S2"}, {5, 10, "S3"}}, 0, 0.25, 0, 5, 0},
+		{"late change within the collar", []speakerSpan{{0, 10.2, "S1"}, {10.2, 20, "S2"}}, 0.25, 0, 0, 0, 0},
+		{"late change outside a collar", []speakerSpan{{0, 11, "S1"}, {11, 20, "S2"}}, 0, 0.05, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		d := diarizationErrorRate(ref, tt.hyp, tt.collar)
+		near := func(a, b float64) bool { return math.Abs(a-b) < 0.02 }
+		if !near(d.Rate, tt.rate) || !near(d.MissedSeconds, tt.missed) || !near(d.FalseAlarm, tt.falseAlarm) || !near(d.ConfusionSeconds, tt.confusion) {
+			t.Errorf("%s: DER %.3f, missed %.2fs, false alarm %.2fs, confusion %.2fs; want %.3f, %.2f, %.2f, %.2f", tt.name,
+				d.Rate, d.MissedSeconds, d.FalseAlarm, d.ConfusionSeconds, tt.rate, tt.missed, tt.falseAlarm, tt.confusion)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// eventKind is the Segment.Kind of non-speech events such as laughter or music.
+const eventKind = "event"
+
+// noSpeechThreshold is the Whisper no_speech_prob above which a segment is taken
+// to be something other than speech.
+const noSpeechThreshold = 0.8
+
+// eventToken matches sound annotations Whisper writes into the text, such as
+// "[Music]", "(laughs)" or "♪".
+var eventToken = regexp.MustCompile(`(?i)[\[(]\s*(music|laughter|laughs|laughing|applause|clapping|silence|inaudible|crosstalk|sighs|coughs)\s*[\])]|♪+`)
+
+// eventLabels maps annotation words to the canonical event labels.
+var eventLabels = map[string]string{
+	"music": "music", "laughter": "laughter", "laughs": "laughter", "laughing": "laughter",
+	"applause": "applause", "clapping": "applause", "silence": "pause", "inaudible": "inaudible",
+	"crosstalk": "crosstalk", "sighs": "sigh", "coughs": "cough",
+}
+
+// eventText renders an event label as it appears in the transcript.
+func eventText(label string) string {
+	return "[" + label + "]"
+}
+
+// annotateEvents inserts non-speech events into the turns of t: sound annotations
+// left in the text by Whisper, Whisper segments it judged not to be speech, gaps
+// of at least pause seconds between turns, and, if a classifier command is
+// configured, the events it detects in the audio.
+func (p *Pipeline) annotateEvents(ctx context.Context, t *Transcript, source []Segment, audioPath string, pause float64) error {
+	var events []Segment
+	var turns []Segment
+	for _, s := range t.Segments {
+		if s.Kind == eventKind {
+			continue
+		}
+		text := eventToken.ReplaceAllStringFunc(s.Text, normalizeEventToken)
+		if strings.TrimSpace(eventToken.ReplaceAllString(text, "")) == "" {
+			// The whole turn is an annotation
+			events = append(events, Segment{Kind: eventKind, Start: s.Start, End: s.End, Text: strings.TrimSpace(text)})
+			continue
+		}
+		s.Text = text
+		turns = append(turns, s)
+	}
+
+	for _, s := range source {
+		if s.NoSpeechProb >= noSpeechThreshold {
+			label := "non-speech"
+			if m := eventToken.FindString(s.Text); m != "" {
+				label = strings.Trim(normalizeEventToken(m), "[]")
+			}
+			events = append(events, Segment{Kind: eventKind, Start: s.Start, End: s.End, Text: eventText(label)})
+		}
+	}
+
+	if p.config.EventClassifier != "" && audioPath != "" {
+		classified, err := p.classifyEvents(ctx, audioPath)
+		if err != nil {
+			return err
+		}
+		events = append(events, classified...)
+	}
+
+	if pause > 0 {
+		// Gaps already explained by another event aren't pauses
+		for i := 1; i < len(turns); i++ {
+			start, end := turns[i-1].End, turns[i].Start
+			if end-start >= pause && !overlapsEvent(events, start, end) {
+				events = append(events, Segment{Kind: eventKind, Start: start, End: end, Text: eventText("pause")})
+			}
+		}
+	}
+
+	t.Segments = mergeEvents(turns, events)
+	return nil
+}
+
+func overlapsEvent(events []Segment, start, end float64) bool {
+	for _, e := range events {
+		if e.Start < end && e.End > start {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEventToken rewrites one annotation as its canonical "[label]".
+func normalizeEventToken(tok string) string {
+	if strings.HasPrefix(tok, "♪") {
+		return eventText("music")
+	}
+	word := strings.ToLower(strings.Trim(tok, "[]() \t"))
+	if label, ok := eventLabels[word]; ok {
+		return eventText(label)
+	}
+	return tok
+}
+
+// mergeEvents inserts the events into turns by start time. Events of the same
+// label that overlap are combined.
+func mergeEvents(turns, events []Segment) []Segment {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Start < events[j].Start })
+	var merged []Segment
+	for _, e := range events {
+		if n := len(merged); n > 0 && merged[n-1].Text == e.Text && e.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, e.End)
+			continue
+		}
+		merged = append(merged, e)
+	}
+
+	out := make([]Segment, 0, len(turns)+len(merged))
+	i := 0
+	for _, t := range turns {
+		for i < len(merged) && merged[i].Start < t.Start {
+			out = append(out, merged[i])
+			i++
+		}
+		out = append(out, t)
+	}
+	out = append(out, merged[i:]...)
+	for j := range out {
+		out[j].ID = j
+	}
+	return out
+}
+
+// classifyEvents runs the configured audio-event classifier. The command gets the
+// audio path substituted for {audio} and must print a JSON array of
+// {"start", "end", "label"} objects, e.g. from a YAMNet or PANNs script.
+func (p *Pipeline) classifyEvents(ctx context.Context, audioPath string) ([]Segment, error) {
+	args := strings.Fields(p.config.EventClassifier)
+	for i, a := range args {
+		args[i] = strings.ReplaceAll(a, "{audio}", audioPath)
+	}
+	stdout := p.newCappedBuffer()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("event classifier %s failed: %v", args[0], err)
+	}
+	var detected []struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Label string  `json:"label"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &detected); err != nil {
+		return nil, fmt.Errorf("failed to parse event classifier output: %v", err)
+	}
+	events := make([]Segment, 0, len(detected))
+	for _, d := range detected {
+		label := strings.ToLower(strings.TrimSpace(d.Label))
+		if canonical, ok := eventLabels[label]; ok {
+			label = canonical
+		}
+		if label == "" || label == "speech" {
+			continue
+		}
+		events = append(events, Segment{Kind: eventKind, Start: d.Start, End: d.End, Text: eventText(label)})
+	}
+	return events, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// diarizationExample is a worked transcript→diarization pair shown to the model
+// before the real transcript.
+type diarizationExample struct {
+	Transcript string
+	Turns      []Segment
+}
+
+// exampleFilePair is one entry of an examples file. Diarized is free-form
+// "Speaker: text" prose, as found in a corrected diarized.txt.
+type exampleFilePair struct {
+	Transcript string `json:"transcript"`
+	Diarized   string `json:"diarized"`
+}
+
+// loadExamples reads few-shot examples from each path. A file may be a JSON array of
+// {"transcript", "diarized"} pairs or a canonical diarized transcript JSON (for
+// example a corrected diarized.json from an earlier episode). Each example is cut
+// down to roughly maxTokens so the examples don't crowd out the real transcript.
+func loadExamples(paths []string, maxTokens int) ([]diarizationExample, error) {
+	var examples []diarizationExample
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read examples file: %v", err)
+		}
+		var pairs []exampleFilePair
+		if err := json.Unmarshal(data, &pairs); err == nil {
+			for _, p := range pairs {
+				examples = append(examples, truncateExample(parseDiarized(p.Diarized), maxTokens))
+			}
+			continue
+		}
+		var t Transcript
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("failed to parse examples file %s: %v", path, err)
+		}
+		examples = append(examples, truncateExample(t.Segments, maxTokens))
+	}
+	for i, ex := range examples {
+		if len(ex.Turns) == 0 {
+			return nil, fmt.Errorf("example %d has no speaker turns", i+1)
+		}
+	}
+	return examples, nil
+}
+
+// truncateExample keeps leading turns up to maxTokens and derives the undiarized
+// transcript from them, so the example input and output always match.
+func truncateExample(turns []Segment, maxTokens int) diarizationExample {
+	var kept []Segment
+	tokens := 0
+	for _, t := range turns {
+		if t.Speaker == "" || strings.TrimSpace(t.Text) == "" {
+			continue
+		}
+		n := estimateTokens(t.Text)
+		if len(kept) > 0 && maxTokens > 0 && tokens+n > maxTokens {
+			break
+		}
+		kept = append(kept, Segment{Speaker: t.Speaker, Text: t.Text})
+		tokens += n
+	}
+	return diarizationExample{Transcript: joinSegmentText(kept), Turns: kept}
+}
+
+// exampleMessages renders the examples as alternating user/assistant chat messages
+// in the same shape as the real request and reply.
+func (p *Pipeline) exampleMessages(examples []diarizationExample) ([]map[string]string, error) {
+	var msgs []map[string]string
+	for _, ex := range examples {
+		prompt, err := p.buildDiarizationPrompt(ex.Transcript, "", nil, countSpeakers(ex.Turns))
+		if err != nil {
+			return nil, err
+		}
+		var reply string
+		if p.config.StructuredOutput {
+			prompt += structuredInstruction
+			type turn struct {
+				Speaker string `json:"speaker"`
+				Text    string `json:"text"`
+			}
+			out := struct {
+				Turns []turn `json:"turns"`
+			}{}
+			for _, t := range ex.Turns {
+				out.Turns = append(out.Turns, turn{t.Speaker, t.Text})
+			}
+			data, err := json.Marshal(out)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal example: %v", err)
+			}
+			reply = string(data)
+		} else {
+			reply = formatTurns(ex.Turns)
+		}
+		msgs = append(msgs,
+			map[string]string{"role": "user", "content": prompt},
+			map[string]string{"role": "assistant", "content": reply})
+	}
+	return msgs, nil
+}
+
+// countSpeakers returns the number of distinct speaker labels in turns.
+func countSpeakers(turns []Segment) int {
+	seen := map[string]bool{}
+	for _, t := range turns {
+		if t.Speaker != "" {
+			seen[t.Speaker] = true
+		}
+	}
+	return len(seen)
+}
+
+// exampleTokens estimates the prompt space taken by the loaded examples.
+func (p *Pipeline) exampleTokens() int {
+	n := 0
+	for _, ex := range p.config.Examples {
+		n += 2 * estimateTokens(ex.Transcript)
+	}
+	return n
+}
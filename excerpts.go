@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// compilation is the document the excerpts command compiles: every excerpt
+// of the archive mentioning a subject.
+type compilation struct {
+	Subject  string    `json:"subject"`
+	Episodes int       `json:"episodes_searched"`
+	Excerpts []excerpt `json:"excerpts"`
+}
+
+// excerpt is a stretch of one episode around one or more mentions.
+type excerpt struct {
+	Episode indexEpisode  `json:"episode"`
+	Start   float64       `json:"start"`
+	End     float64       `json:"end"`
+	Turns   []excerptTurn `json:"turns"`
+}
+
+// excerptTurn is a turn of an excerpt; Match marks the turns mentioning the
+// subject, and the rest are context.
+type excerptTurn struct {
+	Time    float64 `json:"time"`
+	Speaker string  `json:"speaker,omitempty"`
+	Text    string  `json:"text"`
+	Match   bool    `json:"match,omitempty"`
+}
+
+// runExcerpts implements the excerpts command.
+func runExcerpts(args []string) error {
+	flags := flag.NewFlagSet("excerpts", flag.ExitOnError)
+	in := flags.String("in", ".", "Directory searched recursively for diarized transcripts")
+	out := flags.String("out", "", "Write the compilation to this file instead of standard output")
+	format := flags.String("format", "md", "Format of the compilation: md, txt or json")
+	context := flags.Int("context", 1, "Turns of context before and after each mention")
+	speaker := flags.String("speaker", "", "Only compile what this speaker said about the subject")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription excerpts [-in dir] [-out file] [-format md|txt|json] [-context n] [-speaker name] subject ...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	subject := strings.Join(flags.Args(), " ")
+	if strings.TrimSpace(subject) == "" {
+		flags.Usage()
+		return fmt.Errorf("no subject given")
+	}
+	if *format != "md" && *format != "txt" && *format != "json" {
+		return fmt.Errorf("unknown -format %q (available: md, txt, json)", *format)
+	}
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	episodes, err := loadCatalog(key, *in)
+	if err != nil {
+		return err
+	}
+	if len(episodes) == 0 {
+		return fmt.Errorf("no %s files found under %s", filepath.Base(defaultConfig().DiarizedJSONFile), *in)
+	}
+	c := compileExcerpts(episodes, subject, max(*context, 0), *speaker)
+
+	var data []byte
+	switch *format {
+	case "json":
+		data, err = json.MarshalIndent(c, "", "  ")
+		data = append(data, '\n')
+	case "txt":
+		data = []byte(c.renderText())
+	default:
+		data = []byte(c.renderMarkdown())
+	}
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := writeFileAtomic(*out, data, 0644); err != nil {
+		return fmt.Errorf("failed to write compilation: %v", err)
+	}
+	fmt.Printf("Compiled %d excerpt(s) about %q from %d of %d episode(s) into %s\n",
+		len(c.Excerpts), subject, c.matchedEpisodes(), len(episodes), *out)
+	return nil
+}
+
+// compileExcerpts finds the turns of every episode mentioning subject, by
+// speaker if one is given, and joins each with context turns either side of
+// it into excerpts, merging those that overlap or touch.
+func compileExcerpts(episodes []catalogEpisode, subject string, context int, speaker string) *compilation {
+	re := subjectPattern(subject)
+	c := &compilation{Subject: subject, Episodes: len(episodes), Excerpts: []excerpt{}}
+	for _, ep := range episodes {
+		segments := ep.transcript.Segments
+		var matches []int
+		for i, s := range segments {
+			if s.Kind == eventKind || (speaker != "" && !strings.EqualFold(s.Speaker, speaker)) {
+				continue
+			}
+			if re.MatchString(s.Text) {
+				matches = append(matches, i)
+			}
+		}
+		for k := 0; k < len(matches); {
+			from, to := max(matches[k]-context, 0), min(matches[k]+context, len(segments)-1)
+			k++
+			for k < len(matches) && matches[k]-context <= to+1 {
+				to = min(matches[k]+context, len(segments)-1)
+				k++
+			}
+			x := excerpt{Episode: ep.indexEpisode, Start: segments[from].Start, End: segments[to].End}
+			for i := from; i <= to; i++ {
+				s := segments[i]
+				match := s.Kind != eventKind && (speaker == "" || strings.EqualFold(s.Speaker, speaker)) && re.MatchString(s.Text)
+				x.Turns = append(x.Turns, excerptTurn{Time: s.Start, Speaker: s.Speaker, Text: s.Text, Match: match})
+			}
+			c.Excerpts = append(c.Excerpts, x)
+		}
+	}
+	return c
+}
+
+// matchedEpisodes counts the episodes with at least one excerpt.
+func (c *compilation) matchedEpisodes() int {
+	seen := map[string]bool{}
+	for _, x := range c.Excerpts {
+		seen[x.Episode.Path] = true
+	}
+	return len(seen)
+}
+
+// excerptHeading is the heading of an episode in the compilation.
+func excerptHeading(ep indexEpisode) string {
+	if ep.Date != "" {
+		return ep.Date + " — " + ep.Title
+	}
+	return ep.Title
+}
+
+// renderMarkdown writes the compilation as Markdown, an episode per section
+// and the mentions in bold.
+func (c *compilation) renderMarkdown() string {
+	re := subjectPattern(c.Subject)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", c.Subject)
+	fmt.Fprintf(&b, "%d excerpt(s) from %d of %d episode(s).\n", len(c.Excerpts), c.matchedEpisodes(), c.Episodes)
+	previous := ""
+	for _, x := range c.Excerpts {
+		if x.Episode.Path != previous {
+			fmt.Fprintf(&b, "\n## %s\n", excerptHeading(x.Episode))
+			previous = x.Episode.Path
+		}
+		fmt.Fprintf(&b, "\n### %s–%s\n", formatTimestamp(x.Start, ".")[:8], formatTimestamp(x.End, ".")[:8])
+		for _, t := range x.Turns {
+			text := t.Text
+			if t.Match {
+				text = highlightMatches(re, text)
+			}
+			if t.Speaker != "" {
+				text = "**" + t.Speaker + ":** " + text
+			}
+			fmt.Fprintf(&b, "\n%s\n", text)
+		}
+	}
+	return b.String()
+}
+
+// renderText writes the compilation as plain text.
+func (c *compilation) renderText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%d excerpt(s) from %d of %d episode(s)\n", c.Subject, len(c.Excerpts), c.matchedEpisodes(), c.Episodes)
+	previous := ""
+	for _, x := range c.Excerpts {
+		if x.Episode.Path != previous {
+			heading := excerptHeading(x.Episode)
+			fmt.Fprintf(&b, "\n%s\n%s\n", heading, strings.Repeat("=", len([]rune(heading))))
+			previous = x.Episode.Path
+		}
+		b.WriteString("\n")
+		for _, t := range x.Turns {
+			line := t.Text
+			if t.Speaker != "" {
+				line = t.Speaker + ": " + line
+			}
+			fmt.Fprintf(&b, "[%s] %s\n", formatTimestamp(t.Time, ".")[:8], line)
+		}
+	}
+	return b.String()
+}
+
+// highlightMatches puts the mentions in text in bold.
+func highlightMatches(re *regexp.Regexp, text string) string {
+	return re.ReplaceAllStringFunc(text, func(m string) string { return "**" + m + "**" })
+}
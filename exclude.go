@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shiftRanges returns the ranges moved by offset seconds, dropping any that
+// end up entirely before zero, as when they're moved onto a -from slice.
+func shiftRanges(ranges []timeRange, offset float64) []timeRange {
+	var shifted []timeRange
+	for _, r := range ranges {
+		r.Start, r.End = max(r.Start+offset, 0), r.End+offset
+		if r.End > 0 {
+			shifted = append(shifted, r)
+		}
+	}
+	return shifted
+}
+
+// muteRanges writes a copy of the audio with the ranges silenced, so that
+// what was said in them is never uploaded, while every time stays where it
+// is in the episode. The audio is re-encoded. The caller removes the copy
+// with the returned cleanup function.
+func (p *Pipeline) muteRanges(ctx context.Context, path string, ranges []timeRange) (string, func(), error) {
+	dir, err := p.makeTempDir("exclude")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out := filepath.Join(dir, "excluded"+filepath.Ext(path))
+	between := make([]string, len(ranges))
+	for i, r := range ranges {
+		between[i] = fmt.Sprintf("between(t,%s,%s)", strconv.FormatFloat(r.Start, 'f', 3, 64), strconv.FormatFloat(r.End, 'f', 3, 64))
+	}
+	filter := "volume=enable='" + strings.Join(between, "+") + "':volume=0"
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y", "-i", path, "-vn", "-af", filter, out)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, cleanup, nil
+}
+
+// dropExcluded removes from segments what falls in the ranges: the words
+// inside them, and the segments left with none, or without word times, whose
+// middle is inside one. Whisper can hallucinate text over the silence the
+// ranges were muted to. It returns the segments kept and how many were
+// dropped.
+func dropExcluded(segments []Segment, ranges []timeRange) ([]Segment, int) {
+	inside := func(start, end float64) bool {
+		mid := (start + end) / 2
+		for _, r := range ranges {
+			if mid >= r.Start && mid < r.End {
+				return true
+			}
+		}
+		return false
+	}
+	kept := segments[:0:0]
+	dropped := 0
+	for _, s := range segments {
+		if len(s.Words) == 0 {
+			if inside(s.Start, s.End) {
+				dropped++
+			} else {
+				kept = append(kept, s)
+			}
+			continue
+		}
+		words := make([]Word, 0, len(s.Words))
+		for _, w := range s.Words {
+			if !inside(w.Start, w.End) {
+				words = append(words, w)
+			}
+		}
+		switch {
+		case len(words) == 0:
+			dropped++
+		case len(words) < len(s.Words):
+			s.Words = words
+			s.Start, s.End = words[0].Start, words[len(words)-1].End
+			texts := make([]string, len(words))
+			for i, w := range words {
+				texts[i] = strings.TrimSpace(w.Text)
+			}
+			s.Text = strings.Join(texts, " ")
+			kept = append(kept, s)
+		default:
+			kept = append(kept, s)
+		}
+	}
+	return kept, dropped
+}
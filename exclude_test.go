@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShiftRanges(t *testing.T) {
+	ranges := []timeRange{{10, 20, "a"}, {50, 70, "b"}, {100, 110, "c"}}
+	tests := []struct {
+		offset float64
+		want   []timeRange
+	}{
+		{0, ranges},
+		{5, []timeRange{{15, 25, "a"}, {55, 75, "b"}, {105, 115, "c"}}},
+		{-60, []timeRange{{0, 10, "b"}, {40, 50, "c"}}},
+		{-200, nil},
+	}
+	for _, tt := range tests {
+		if got := shiftRanges(ranges, tt.offset); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shiftRanges(%g) = %v, want %v", tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestDropExcluded(t *testing.T) {
+	words := []Word{{Text: " before", Start: 10, End: 11}, {Text: " secret", Start: 12, End: 13}, {Text: " after", Start: 16, End: 17}}
+	segments := []Segment{
+		{Start: 0, End: 5, Text: "kept"},
+		{Start: 9, End: 14, Text: "inside"},
+		{Start: 10, End: 17, Text: "before secret after", Words: words},
+		{Start: 12, End: 13, Text: "secret", Words: words[1:2]},
+	}
+	tests := []struct {
+		name    string
+		ranges  []timeRange
+		texts   []string
+		dropped int
+	}{
+		{"none", nil, []string{"kept", "inside", "before secret after", "secret"}, 0},
+		{"middle inside", []timeRange{{11.5, 15, ""}}, []string{"kept", "before after"}, 2},
+		{"edge only", []timeRange{{4, 8, ""}}, []string{"kept", "inside", "before secret after", "secret"}, 0},
+	}
+	for _, tt := range tests {
+		kept, dropped := dropExcluded(append([]Segment(nil), segments...), tt.ranges)
+		var texts []string
+		for _, s := range kept {
+			texts = append(texts, s.Text)
+		}
+		if !reflect.DeepEqual(texts, tt.texts) || dropped != tt.dropped {
+			t.Errorf("%s: dropExcluded = %q, %d dropped; want %q, %d", tt.name, texts, dropped, tt.texts, tt.dropped)
+		}
+	}
+	// A segment keeps the times of the words left in it
+	kept, _ := dropExcluded(append([]Segment(nil), segments...), []timeRange{{15, 20, ""}})
+	if s := kept[2]; s.Start != 10 || s.End != 13 || s.Text != "before secret" {
+		t.Errorf("trimmed segment = %.0f-%.0f %q, want 10-13 \"before secret\"", s.Start, s.End, s.Text)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// exporter renders a transcript into one output format. Text formats are
+// written with the configured byte order mark and line endings, wrapped ones
+// also with the line width, and numbered ones with line numbers if -verbatim
+// asks for them.
+type exporter struct {
+	ext      string
+	render   func(t *Transcript, opts renderOptions) ([]byte, error)
+	text     bool
+	wrap     bool
+	numbered bool
+}
+
+// renderOptions are the settings that change how exporters render.
+type renderOptions struct {
+	// timestamps is the style of the times in the readable formats; timed is
+	// set when it was chosen explicitly, which adds times to the txt format.
+	timestamps timestampStyle
+	timed      bool
+	// assStyles overrides the look of speakers in the ass format.
+	assStyles map[string]assStyle
+}
+
+// exporters is the registry of output formats selectable with -format.
+var exporters = map[string]exporter{
+	"txt":     {ext: ".txt", render: func(t *Transcript, opts renderOptions) ([]byte, error) { return []byte(renderText(t, opts)), nil }, text: true, wrap: true, numbered: true},
+	"json":    {ext: ".json", render: renderJSON},
+	"srt":     {ext: ".srt", render: renderSRT, text: true, wrap: true},
+	"vtt":     {ext: ".vtt", render: renderVTT, text: true, wrap: true},
+	"md":      {ext: ".md", render: renderMarkdown, text: true},
+	"rttm":    {ext: ".rttm", render: renderRTTM},
+	"karaoke": {ext: ".karaoke.vtt", render: renderKaraoke, text: true},
+	"ass":     {ext: ".ass", render: renderASS, text: true},
+	"whisper": {ext: ".whisper.json", render: renderWhisperJSON},
+}
+
+// exporterNames returns the registered format names in sorted order.
+func exporterNames() []string {
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseFormats validates a comma-separated -format value.
+func parseFormats(s string) ([]string, error) {
+	var formats []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if !strings.HasPrefix(strings.ToLower(f), templatePrefix) {
+			f = strings.ToLower(f)
+		} else {
+			// Keep the case of the template's path
+			f = templatePrefix + f[len(templatePrefix):]
+		}
+		if f == "" || seen[f] {
+			continue
+		}
+		if _, err := lookupExporter(f); err != nil {
+			return nil, err
+		}
+		seen[f] = true
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no output format given")
+	}
+	return formats, nil
+}
+
+// outputFile returns the path an exporter writes to, derived from DiarizedFile,
+// with the language before the extension for a translation.
+func (p *Pipeline) outputFile(format, lang string) string {
+	base := strings.TrimSuffix(p.config.DiarizedFile, filepath.Ext(p.config.DiarizedFile))
+	if lang != "" {
+		base += "." + lang
+	}
+	// The formats were validated when the flags were parsed
+	e, _ := lookupExporter(format)
+	return base + e.ext
+}
+
+// renderOptions returns the render settings of the configuration.
+func (p *Pipeline) renderOptions() renderOptions {
+	// -timestamps was validated when the flags were parsed
+	ts, _ := parseTimestampStyle(p.config.Timestamps)
+	styles, _ := parseASSStyles(p.config.ASSStyles)
+	return renderOptions{timestamps: ts, timed: p.config.Timestamps != "", assStyles: styles}
+}
+
+// exportAll renders every requested format concurrently and returns the paths
+// written. All formats are attempted even if one fails. lang names the
+// language of a translation of the transcript, and is empty for the
+// transcript itself. The times of all but the canonical json are moved by
+// -offset and -drift, so that -reexport can line the outputs up with another
+// cut of the episode.
+func (p *Pipeline) exportAll(t *Transcript, formats []string, lang string) ([]string, error) {
+	published := t
+	if p.config.Offset != 0 || p.config.Drift != 0 {
+		published = t.retimed(p.config.Offset, p.config.Drift)
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	paths := make([]string, len(formats))
+	for i, f := range formats {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := p.outputFile(f, lang)
+			e, err := lookupExporter(f)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", f, err))
+				mu.Unlock()
+				return
+			}
+			src := published
+			if f == "json" && lang == "" {
+				src = t
+			}
+			data, err := e.render(src, p.renderOptions())
+			if err == nil && e.text {
+				data = p.encodeText(data, e)
+			}
+			if err == nil {
+				err = p.writeOutput(path, data)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", f, err))
+				mu.Unlock()
+				return
+			}
+			paths[i] = path
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("failed to export %s", strings.Join(errs, "; "))
+	}
+	return paths, nil
+}
+
+func renderJSON(t *Transcript, _ renderOptions) ([]byte, error) {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func renderSRT(t *Transcript, _ renderOptions) ([]byte, error) {
+	var b strings.Builder
+	for i, s := range t.Segments {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(s.Start, ","), formatTimestamp(s.End, ","), cueText(s))
+	}
+	return []byte(b.String()), nil
+}
+
+func renderVTT(t *Transcript, _ renderOptions) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	if legend := speakerLegend(t); legend != "" {
+		fmt.Fprintf(&b, "NOTE Speakers: %s\n\n", legend)
+	}
+	for _, s := range t.Segments {
+		text := s.displayText()
+		if s.Speaker != "" {
+			text = fmt.Sprintf("<v %s>%s", s.speakerLabel(), text)
+		}
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", formatTimestamp(s.Start, "."), formatTimestamp(s.End, "."), text)
+	}
+	return []byte(b.String()), nil
+}
+
+// renderRTTM writes speaker turns in the NIST RTTM format used by diarization
+// scoring tools. Speaker names can't contain spaces there, so they are joined
+// with underscores.
+func renderRTTM(t *Transcript, _ renderOptions) ([]byte, error) {
+	file := strings.TrimSuffix(t.Audio, filepath.Ext(t.Audio))
+	if file == "" {
+		file = "audio"
+	}
+	var b strings.Builder
+	for _, s := range t.Segments {
+		if s.Speaker == "" || s.End <= s.Start {
+			continue
+		}
+		fmt.Fprintf(&b, "SPEAKER %s 1 %.3f %.3f <NA> <NA> %s <NA> <NA>\n",
+			file, s.Start, s.End-s.Start, strings.Join(strings.Fields(s.Speaker), "_"))
+	}
+	return []byte(b.String()), nil
+}
+
+func renderMarkdown(t *Transcript, opts renderOptions) ([]byte, error) {
+	var b strings.Builder
+	writeFrontMatter(&b, t)
+	title := t.Title
+	if title == "" {
+		title = "Transcript"
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	for _, s := range t.Segments {
+		ts := opts.timestamps.format(s.Start)
+		if s.Speaker != "" {
+			fmt.Fprintf(&b, "**%s** [%s]: %s\n\n", s.speakerLabel(), ts, s.displayText())
+		} else {
+			fmt.Fprintf(&b, "[%s] %s\n\n", ts, s.displayText())
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// writeFrontMatter writes YAML front matter for static site generators such as
+// Hugo and Jekyll. Strings are written JSON-quoted, which is valid YAML.
+func writeFrontMatter(b *strings.Builder, t *Transcript) {
+	b.WriteString("---\n")
+	if t.Title != "" {
+		fmt.Fprintf(b, "title: %s\n", yamlString(t.Title))
+	}
+	if t.Date != "" {
+		fmt.Fprintf(b, "date: %s\n", t.Date)
+	}
+	if t.Duration > 0 {
+		fmt.Fprintf(b, "duration: %s\n", yamlString(formatTimestamp(t.Duration, ".")[:8]))
+	}
+	if speakers := t.speakers(); len(speakers) > 0 {
+		b.WriteString("speakers:\n")
+		for _, s := range speakers {
+			fmt.Fprintf(b, "  - %s\n", yamlString(s))
+		}
+		if speakerLegend(t) != "" {
+			b.WriteString("roles:\n")
+			for _, s := range speakers {
+				if role := t.speakerRole(s); role != "" {
+					fmt.Fprintf(b, "  %s: %s\n", yamlString(s), role)
+				}
+			}
+		}
+	}
+	if len(t.Models) > 0 {
+		b.WriteString("models:\n")
+		stages := make([]string, 0, len(t.Models))
+		for stage := range t.Models {
+			stages = append(stages, stage)
+		}
+		sort.Strings(stages)
+		for _, stage := range stages {
+			fmt.Fprintf(b, "  %s: %s\n", stage, yamlString(t.Models[stage]))
+		}
+	}
+	if t.Description != "" {
+		fmt.Fprintf(b, "description: %s\n", yamlString(t.Description))
+	}
+	if t.Summary != "" {
+		fmt.Fprintf(b, "summary: %s\n", yamlString(t.Summary))
+	}
+	b.WriteString("---\n\n")
+}
+
+// yamlString quotes s as a YAML double-quoted scalar.
+func yamlString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// cueText prefixes a subtitle cue with its speaker, if known.
+func cueText(s Segment) string {
+	if s.Speaker == "" {
+		return s.displayText()
+	}
+	return s.speakerLabel() + ": " + s.displayText()
+}
+
+// formatTimestamp formats seconds as HH:MM:SS<sep>mmm, the layout used by SRT
+// (sep ",") and WebVTT (sep ".").
+func formatTimestamp(seconds float64, sep string) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	ms := int64(seconds*1000 + 0.5)
+	h := ms / 3600000
+	m := ms / 60000 % 60
+	sec := ms / 1000 % 60
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", h, m, sec, sep, ms%1000)
+}
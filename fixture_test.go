@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRecordRedactsResponses(t *testing.T) {
+	tests := []struct {
+		name, body, secret string
+	}{
+		{"oauth refresh", `{"access_token": "ya29.a0AfB_byC1234567890", "expires_in": 3599}`, "ya29.a0AfB_byC1234567890"},
+		{"refresh token", `{"refresh_token":"1//0gLongLivedRefreshToken","token_type":"Bearer"}`, "1//0gLongLivedRefreshToken"},
+		{"api key echoed", `{"error": "invalid key sk-proj-abcdefghijklmnop"}`, "sk-proj-abcdefghijklmnop"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		rt := &recordTransport{dir: dir, next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			// Transports close the request body once it's sent
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+			return &http.Response{StatusCode: 200, Header: http.Header{"X-Echo": {"Bearer ya29.HeaderToken12345"}}, Body: io.NopCloser(strings.NewReader(tt.body))}, nil
+		})}
+		req, _ := http.NewRequest("POST", "https://oauth2.googleapis.com/token", strings.NewReader("grant_type=refresh_token"))
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// The caller still gets the real reply
+		if got, _ := io.ReadAll(resp.Body); string(got) != tt.body {
+			t.Errorf("%s: response body = %q, want %q", tt.name, got, tt.body)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "0001.json"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if strings.Contains(string(data), tt.secret) || strings.Contains(string(data), "HeaderToken") {
+			t.Errorf("%s: fixture contains the secret:\n%s", tt.name, data)
+		}
+	}
+}
+
+func TestBodyHasherIgnoresBoundary(t *testing.T) {
+	upload := func(boundary string, chunk int) string {
+		req, _ := http.NewRequest("POST", "https://example.com", nil)
+		req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		body := "--" + boundary + "\r\nContent-Disposition: form-data; name=\"file\"\r\n\r\naudio\r\n--" + boundary + "--\r\n"
+		h := newBodyHasher(req)
+		for len(body) > 0 {
+			n := min(chunk, len(body))
+			h.Write([]byte(body[:n]))
+			body = body[n:]
+		}
+		return h.sum()
+	}
+	want := upload("aaaaaaaaaaaa", 1000)
+	tests := []struct {
+		boundary string
+		chunk    int
+	}{
+		{"bbbbbbbbbbbb", 1000},
+		{"cccccccccccc", 1},
+		{"dddddddddddd", 7},
+	}
+	for _, tt := range tests {
+		if got := upload(tt.boundary, tt.chunk); got != want {
+			t.Errorf("boundary %q in %d-byte writes hashed %s, want %s", tt.boundary, tt.chunk, got, want)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// glossaryMinFuzzy is the shortest term, in letters and digits, matched fuzzily.
+// Shorter terms are too close to ordinary words and only match exactly or via
+// their listed mis-hearings.
+const glossaryMinFuzzy = 5
+
+// glossaryTerm is a correct spelling and the mis-hearings that map to it.
+type glossaryTerm struct {
+	Term     string
+	norm     string
+	words    int
+	variants map[string]bool
+}
+
+// Correction records that a glossary term replaced what the transcription heard.
+type Correction struct {
+	Term  string    `json:"term"`
+	Heard string    `json:"heard"`
+	Count int       `json:"count"`
+	Times []float64 `json:"times"`
+}
+
+// loadGlossary reads a glossary file: one correct spelling per line, optionally
+// followed by a colon and a comma-separated list of known mis-hearings, e.g.
+//
+//	Kubernetes
+//	OpenAI: open eye, opening eye
+//
+// Blank lines and lines starting with # are ignored.
+func loadGlossary(path string) ([]glossaryTerm, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open glossary: %v", err)
+	}
+	defer f.Close()
+
+	var terms []glossaryTerm
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		term, variants, _ := strings.Cut(line, ":")
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		g := glossaryTerm{Term: term, norm: phraseKey(strings.Fields(term)), words: len(strings.Fields(term)), variants: map[string]bool{}}
+		for _, v := range strings.Split(variants, ",") {
+			if key := phraseKey(strings.Fields(v)); key != "" {
+				g.variants[key] = true
+			}
+		}
+		terms = append(terms, g)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read glossary: %v", err)
+	}
+	return terms, nil
+}
+
+// phraseKey joins the normalized words of a phrase without spaces, so "open AI"
+// and "OpenAI" compare equal.
+func phraseKey(tokens []string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(normalizeWord(t))
+	}
+	return b.String()
+}
+
+// applyGlossary corrects mis-heard glossary terms in the segments of t and
+// returns the substitutions made, most frequent first.
+func applyGlossary(t *Transcript, terms []glossaryTerm) []Correction {
+	if len(terms) == 0 {
+		return nil
+	}
+	byChange := map[[2]string]*Correction{}
+	for i := range t.Segments {
+		s := &t.Segments[i]
+		if s.Kind == eventKind {
+			continue
+		}
+		rewriteSegment(s, func(rest []string, start float64) (int, []string) {
+			term, n, ok := matchGlossary(rest, terms)
+			if !ok || term.Term == "" {
+				return n, nil
+			}
+			replacement := wrapReplacement(rest[:n], term.Term)
+			if strings.Join(replacement, " ") == strings.Join(rest[:n], " ") {
+				return n, nil
+			}
+			key := [2]string{term.Term, strings.ToLower(strings.TrimFunc(strings.Join(trimTokens(rest[:n]), " "), isPunct))}
+			c := byChange[key]
+			if c == nil {
+				c = &Correction{Term: term.Term, Heard: key[1]}
+				byChange[key] = c
+			}
+			c.Count++
+			c.Times = append(c.Times, start)
+			return n, replacement
+		})
+	}
+	if len(byChange) == 0 {
+		return nil
+	}
+	t.Text = paragraphText(t.Segments)
+
+	corrections := make([]Correction, 0, len(byChange))
+	for _, c := range byChange {
+		corrections = append(corrections, *c)
+	}
+	sort.Slice(corrections, func(i, j int) bool {
+		if corrections[i].Count != corrections[j].Count {
+			return corrections[i].Count > corrections[j].Count
+		}
+		return corrections[i].Term+corrections[i].Heard < corrections[j].Term+corrections[j].Heard
+	})
+	return corrections
+}
+
+// matchGlossary finds the glossary term best matching the tokens at the start of
+// tokens, trying windows one word shorter and longer than the term since
+// mis-hearings often split or merge words. It returns the term and the number of
+// tokens it covers. A window that is already an acceptable spelling of a term is
+// reported with an empty term, so it is skipped rather than matched fuzzily.
+func matchGlossary(tokens []string, terms []glossaryTerm) (glossaryTerm, int, bool) {
+	var best glossaryTerm
+	bestN, bestDist := 0, -1
+	for _, g := range terms {
+		for n := max(1, g.words-1); n <= g.words+1 && n <= len(tokens); n++ {
+			key := phraseKey(trimTokens(tokens[:n]))
+			if key == "" || normalizeWord(tokens[0]) == "" {
+				continue
+			}
+			dist := -1
+			switch {
+			case key == g.norm:
+				// Spelled right apart from spacing and casing; only fix the case
+				// of terms whose casing is distinctive, like acronyms
+				if !distinctiveCase(g.Term) && n == g.words {
+					return glossaryTerm{}, n, true
+				}
+				dist = 0
+			case g.variants[key]:
+				dist = 0
+			case len(g.norm) >= glossaryMinFuzzy && key[0] == g.norm[0] && !strings.HasPrefix(key, g.norm):
+				if d := levenshtein(key, g.norm); d <= len([]rune(g.norm))/5 {
+					dist = d
+				}
+			}
+			if dist >= 0 && (bestDist < 0 || dist < bestDist || (dist == bestDist && n > bestN)) {
+				best, bestN, bestDist = g, n, dist
+			}
+		}
+	}
+	return best, bestN, bestDist >= 0
+}
+
+// distinctiveCase reports whether term has capitals past its first letter, as in
+// "OpenAI" or "NASA", so that a lower-cased transcription of it is wrong.
+func distinctiveCase(term string) bool {
+	for i, r := range []rune(term) {
+		if i > 0 && unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimTokens drops a trailing possessive from the last token, so "Kubernete's"
+// matches "Kubernetes" and keeps its "'s".
+func trimTokens(tokens []string) []string {
+	out := append([]string(nil), tokens...)
+	last := out[len(out)-1]
+	for _, suffix := range []string{"'s", "’s"} {
+		if i := strings.LastIndex(strings.ToLower(last), suffix); i > 0 && strings.TrimRightFunc(last[i+len(suffix):], isPunct) == "" {
+			out[len(out)-1] = last[:i]
+		}
+	}
+	return out
+}
+
+// wrapReplacement returns the words of term carrying over the leading
+// punctuation of the first heard token and the trailing punctuation and
+// possessive of the last, e.g. `"open eye's,` becomes `"OpenAI's,`.
+func wrapReplacement(heard []string, term string) []string {
+	words := strings.Fields(term)
+	first, last := heard[0], heard[len(heard)-1]
+	prefix := first[:len(first)-len(strings.TrimLeftFunc(first, isPunct))]
+	trimmed := trimTokens([]string{last})[0]
+	suffix := last[len(trimmed):]
+	suffix = trimmed[len(strings.TrimRightFunc(trimmed, isPunct)):] + suffix
+	words[0] = prefix + words[0]
+	words[len(words)-1] += suffix
+	return words
+}
+
+func isPunct(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// replaceWords replaces n timed words at pos with the replacement words, spread
+// evenly over the time the replaced words took and keeping their mean speaker
+// confidence.
+func replaceWords(words []Word, pos, n int, replacement []string) []Word {
+	start, end := words[pos].Start, words[pos+n-1].End
+	step := (end - start) / float64(len(replacement))
+	speaker, _ := wordSpeakerConfidence(words[pos : pos+n])
+	repl := make([]Word, len(replacement))
+	for i, r := range replacement {
+		repl[i] = Word{Text: r, Start: start + float64(i)*step, End: start + float64(i+1)*step, SpeakerConfidence: speaker}
+	}
+	return append(words[:pos], append(repl, words[pos+n:]...)...)
+}
+
+// levenshtein returns the number of single-character edits between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// writeCorrections saves the substitution report next to the outputs.
+func (p *Pipeline) writeCorrections(path string, corrections []Correction) error {
+	if corrections == nil {
+		corrections = []Correction{}
+	}
+	data, err := json.MarshalIndent(corrections, "", "  ")
+	if err != nil {
+		return err
+	}
+	return p.writeOutput(path, append(data, '\n'))
+}
+
+// learnGlossary adds the suggested terms to the glossary file at path, which
+// is created if it doesn't exist: mis-hearings of a term already listed are
+// added to its line, and new terms are appended. Comments and the order of
+// the file are kept. It returns how many terms and mis-hearings were added.
+func learnGlossary(path string, suggestions []glossarySuggestion) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read glossary: %v", err)
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+	added := 0
+	for _, s := range suggestions {
+		key := phraseKey(strings.Fields(s.Term))
+		found := false
+		for i, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			term, variants, _ := strings.Cut(trimmed, ":")
+			if phraseKey(strings.Fields(term)) != key {
+				continue
+			}
+			found = true
+			known := map[string]bool{key: true}
+			var list []string
+			for _, v := range strings.Split(variants, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					known[phraseKey(strings.Fields(v))] = true
+					list = append(list, v)
+				}
+			}
+			for _, h := range s.Heard {
+				if k := phraseKey(strings.Fields(h)); k != "" && !known[k] {
+					known[k] = true
+					list = append(list, strings.ToLower(h))
+					added++
+				}
+			}
+			lines[i] = strings.TrimSpace(term)
+			if len(list) > 0 {
+				lines[i] += ": " + strings.Join(list, ", ")
+			}
+			break
+		}
+		if !found {
+			lines = append(lines, s.Line)
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	if err := writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write glossary: %v", err)
+	}
+	return added, nil
+}
+
+// misheardTerms returns the glossary terms with known mis-hearings, which are
+// worth naming to the transcription up front.
+func misheardTerms(terms []glossaryTerm) []string {
+	var names []string
+	for _, t := range terms {
+		if len(t.variants) > 0 {
+			names = append(names, t.Term)
+		}
+	}
+	return names
+}
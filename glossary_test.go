@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyGlossary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "glossary.txt")
+	glossary := "# Show terms\nKubernetes\nOpenAI: open eye, opening eye\nRust\n\nPostgreSQL: post gress\n"
+	if err := os.WriteFile(path, []byte(glossary), 0644); err != nil {
+		t.Fatal(err)
+	}
+	terms, err := loadGlossary(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(terms) != 4 {
+		t.Fatalf("loaded %d terms, want 4", len(terms))
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"we run it on kubernetis now", "we run it on Kubernetes now"},
+		{"the open eye model", "the OpenAI model"},
+		{"ask opening eye about it", "ask OpenAI about it"},
+		{"\"open eye's, new model", "\"OpenAI's, new model"},
+		{"openai shipped it", "OpenAI shipped it"},
+		{"a post gress database", "a PostgreSQL database"},
+		// Spelled right but for casing that isn't distinctive
+		{"the kubernetes cluster", "the kubernetes cluster"},
+		// Too short to match fuzzily
+		{"take a rest first", "take a rest first"},
+		{"nothing to fix here", "nothing to fix here"},
+	}
+	for _, tt := range tests {
+		tr := &Transcript{Segments: []Segment{{Text: tt.in, Start: 1, End: 3}}}
+		applyGlossary(tr, terms)
+		if got := tr.Segments[0].Text; got != tt.want {
+			t.Errorf("glossary %q = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGlossaryCorrections(t *testing.T) {
+	terms := []glossaryTerm{{Term: "OpenAI", norm: "openai", words: 1, variants: map[string]bool{"openeye": true}}}
+	tr := &Transcript{Segments: []Segment{
+		{Text: "open eye again", Start: 5},
+		{Text: "and open eye", Start: 9},
+		{Text: "then openai", Start: 12},
+	}}
+	corrections := applyGlossary(tr, terms)
+	if len(corrections) != 2 {
+		t.Fatalf("got %d corrections, want 2: %+v", len(corrections), corrections)
+	}
+	if c := corrections[0]; c.Heard != "open eye" || c.Count != 2 || len(c.Times) != 2 {
+		t.Errorf("most frequent correction = %+v, want open eye twice", c)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"kubernetes", "kubernetes", 0},
+		{"kubernetis", "kubernetes", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
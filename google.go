@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// googleOperation is a long-running Speech-to-Text v2 batchRecognize operation.
+type googleOperation struct {
+	Name  string `json:"name"`
+	Done  bool   `json:"done"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Response struct {
+		Results map[string]struct {
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+			InlineResult struct {
+				Transcript struct {
+					Results []struct {
+						LanguageCode    string `json:"languageCode"`
+						ResultEndOffset string `json:"resultEndOffset"`
+						Alternatives    []struct {
+							Transcript string `json:"transcript"`
+							Words      []struct {
+								Word         string  `json:"word"`
+								StartOffset  string  `json:"startOffset"`
+								EndOffset    string  `json:"endOffset"`
+								Confidence   float64 `json:"confidence"`
+								SpeakerLabel string  `json:"speakerLabel"`
+							} `json:"words"`
+						} `json:"alternatives"`
+					} `json:"results"`
+				} `json:"transcript"`
+			} `json:"inlineResult"`
+		} `json:"results"`
+	} `json:"response"`
+}
+
+// googleAccessToken returns an OAuth access token from GOOGLE_OAUTH_ACCESS_TOKEN
+// or, failing that, from the gcloud CLI's active account.
+func googleAccessToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return "", fmt.Errorf("Please set the GOOGLE_OAUTH_ACCESS_TOKEN environment variable or log in with gcloud: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// googleProject returns the Google Cloud project from GOOGLE_CLOUD_PROJECT or the
+// gcloud CLI's configuration.
+func googleProject() (string, error) {
+	if p := os.Getenv("GOOGLE_CLOUD_PROJECT"); p != "" {
+		return p, nil
+	}
+	out, err := exec.Command("gcloud", "config", "get-value", "project").Output()
+	if p := strings.TrimSpace(string(out)); err == nil && p != "" {
+		return p, nil
+	}
+	return "", fmt.Errorf("Please set the GOOGLE_CLOUD_PROJECT environment variable")
+}
+
+// transcribeGoogle stages the audio in Cloud Storage, runs a Speech-to-Text v2
+// batchRecognize job with speaker diarization, and polls until it finishes.
+func (p *Pipeline) transcribeGoogle(ctx context.Context, token, audioPath string) (*Transcript, error) {
+	if p.config.CloudBucket == "" {
+		return nil, fmt.Errorf("the google backend needs -bucket to stage the audio in Cloud Storage")
+	}
+	project, err := googleProject()
+	if err != nil {
+		return nil, err
+	}
+	location := p.config.CloudRegion
+	if location == "" {
+		location = "global"
+	}
+	host := "https://speech.googleapis.com"
+	if location != "global" {
+		host = "https://" + location + "-speech.googleapis.com"
+	}
+
+	object := fmt.Sprintf("podcast-transcription/%d%s", time.Now().UnixNano(), filepath.Ext(audioPath))
+	if err := p.googleUpload(ctx, token, audioPath, object); err != nil {
+		return nil, err
+	}
+	defer p.googleDelete(token, object)
+	uri := "gs://" + p.config.CloudBucket + "/" + object
+
+	language := p.config.Language
+	if language == "" {
+		language = "en-US"
+	}
+	features := map[string]interface{}{
+		"enableWordTimeOffsets":      true,
+		"enableWordConfidence":       true,
+		"enableAutomaticPunctuation": true,
+	}
+	if p.config.Speakers > 0 {
+		features["diarizationConfig"] = map[string]int{
+			"minSpeakerCount": p.config.Speakers,
+			"maxSpeakerCount": p.config.Speakers,
+		}
+	}
+	payload := map[string]interface{}{
+		"config": map[string]interface{}{
+			"autoDecodingConfig": map[string]interface{}{},
+			"model":              p.config.TranscriptionModel,
+			"languageCodes":      []string{language},
+			"features":           features,
+		},
+		"files":                   []map[string]string{{"uri": uri}},
+		"recognitionOutputConfig": map[string]interface{}{"inlineResponseConfig": map[string]interface{}{}},
+	}
+	endpoint := fmt.Sprintf("%s/v2/projects/%s/locations/%s/recognizers/_:batchRecognize", host, project, location)
+	var op googleOperation
+	if err := p.googleRequest(ctx, token, "POST", endpoint, payload, &op); err != nil {
+		return nil, err
+	}
+	for !op.Done {
+		if err := waitPoll(ctx, "Speech-to-Text operation "+op.Name); err != nil {
+			return nil, err
+		}
+		if err := p.googleRequest(ctx, token, "GET", host+"/v2/"+op.Name, nil, &op); err != nil {
+			return nil, err
+		}
+	}
+	if op.Error != nil {
+		return nil, fmt.Errorf("Speech-to-Text operation failed: %s", op.Error.Message)
+	}
+	return op.transcript(uri, filepath.Base(audioPath))
+}
+
+// googleUpload stores the audio file as object in the staging bucket with a
+// resumable upload: when the connection drops, the upload carries on from the
+// last byte Cloud Storage received instead of starting over. The size and MD5
+// Cloud Storage reports are checked against the file's, and the file is
+// uploaded again in a new session on a mismatch.
+func (p *Pipeline) googleUpload(ctx context.Context, token, audioPath, object string) error {
+	fileInfo, err := os.Stat(audioPath)
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+	size := fileInfo.Size()
+	sum, err := fileMD5(audioPath)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s",
+		url.PathEscape(p.config.CloudBucket), url.QueryEscape(object))
+
+	var session string
+	return p.retryCorrupt(ctx, func() error {
+		var (
+			offset int64
+			done   bool
+			res    googleObject
+		)
+		if session == "" {
+			if session, err = p.googleStartUpload(ctx, token, endpoint, size); err != nil {
+				return err
+			}
+		} else {
+			offset, done, err = p.googleUploadStatus(ctx, token, session, size, &res)
+			if err == nil && !done && offset > 0 {
+				p.console.progressf("Resuming the upload at %.1f of %.1f MB\n", float64(offset)/1e6, float64(size)/1e6)
+			}
+		}
+		if err == nil && !done {
+			err = p.googleUploadFrom(ctx, token, session, audioPath, offset, size, &res)
+		}
+		if errors.Is(err, errUploadExpired) {
+			// Start over in a new session
+			session = ""
+			return &droppedTransfer{"upload to Cloud Storage", err}
+		}
+		if err != nil {
+			return err
+		}
+		if res.Size != "" && res.Size != strconv.FormatInt(size, 10) {
+			session = ""
+			return &corruptTransfer{"upload", fmt.Sprintf("Cloud Storage received %s of %d bytes", res.Size, size)}
+		}
+		if res.MD5Hash != "" && res.MD5Hash != base64.StdEncoding.EncodeToString(sum) {
+			session = ""
+			return &corruptTransfer{"upload", "Cloud Storage reported a different MD5 checksum"}
+		}
+		return nil
+	})
+}
+
+// errUploadExpired is a resumable upload session Cloud Storage no longer knows.
+var errUploadExpired = errors.New("the upload session expired")
+
+// googleObject is the part of a Cloud Storage object's metadata that uploads check.
+type googleObject struct {
+	Size    string `json:"size"`
+	MD5Hash string `json:"md5Hash"`
+}
+
+// googleStartUpload opens a resumable upload session of size bytes and returns
+// its URI.
+func (p *Pipeline) googleStartUpload(ctx context.Context, token, endpoint string, size int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Upload-Content-Type", "application/octet-stream")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if isDropped(err) {
+			return "", &droppedTransfer{"upload to Cloud Storage", err}
+		}
+		return "", fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return "", fmt.Errorf("failed to start upload to Cloud Storage: %d, body: %s", resp.StatusCode, string(body))
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("failed to start upload to Cloud Storage: no session URI in the response")
+	}
+	return session, nil
+}
+
+// googleUploadStatus asks how much of an interrupted upload arrived. It
+// returns the offset to carry on from, or done with the object's metadata in
+// res if the upload completed after all.
+func (p *Pipeline) googleUploadStatus(ctx context.Context, token, session string, size int64, res *googleObject) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", session, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	return p.googleUploadReply(req, res)
+}
+
+// googleUploadFrom uploads the file from offset to the end into the session.
+func (p *Pipeline) googleUploadFrom(ctx context.Context, token, session, audioPath string, offset, size int64, res *googleObject) error {
+	file, err := os.Open(audioPath)
+	if err != nil {
+		return fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek audio file: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", session, file)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.ContentLength = size - offset
+	req.Header.Set("Authorization", "Bearer "+token)
+	if size > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, size-1, size))
+	}
+	received, done, err := p.googleUploadReply(req, res)
+	if err == nil && !done {
+		err = &droppedTransfer{"upload to Cloud Storage", fmt.Errorf("%d of %d bytes arrived", received, size)}
+	}
+	return err
+}
+
+// googleUploadReply sends a request of a resumable upload session. A 308
+// reply means the upload is incomplete, and its Range header how many bytes
+// arrived; a 200 or 201 means it is done, with the object's metadata.
+func (p *Pipeline) googleUploadReply(req *http.Request, res *googleObject) (int64, bool, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if isDropped(err) {
+			return 0, false, &droppedTransfer{"upload to Cloud Storage", err}
+		}
+		return 0, false, fmt.Errorf("failed to upload audio to Cloud Storage: %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPermanentRedirect:
+		// Range is "bytes=0-N" for the N+1 bytes received, absent for none
+		var last int64 = -1
+		if r, ok := strings.CutPrefix(resp.Header.Get("Range"), "bytes=0-"); ok {
+			if n, err := strconv.ParseInt(r, 10, 64); err == nil {
+				last = n
+			}
+		}
+		return last + 1, false, nil
+	case http.StatusOK, http.StatusCreated:
+		if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(res); err != nil {
+			return 0, false, fmt.Errorf("failed to decode Google Cloud response: %v", err)
+		}
+		return 0, true, nil
+	case http.StatusNotFound, http.StatusGone:
+		return 0, false, errUploadExpired
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+	return 0, false, fmt.Errorf("failed to upload audio to Cloud Storage: %d, body: %s", resp.StatusCode, string(body))
+}
+
+// googleDelete removes the staged audio. Failures are only reported, since the
+// transcript has already been produced.
+func (p *Pipeline) googleDelete(token, object string) {
+	endpoint := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s",
+		url.PathEscape(p.config.CloudBucket), url.PathEscape(object))
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.HTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint, nil)
+	if err == nil {
+		req.Header.Set("Authorization", "Bearer "+token)
+		var resp *http.Response
+		if resp, err = p.client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+	}
+	if err != nil {
+		p.console.warnf("failed to delete gs://%s/%s: %v\n", p.config.CloudBucket, object, err)
+	}
+}
+
+// googleRequest sends a JSON API request and decodes the reply into out.
+func (p *Pipeline) googleRequest(ctx context.Context, token, method, endpoint string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return p.googleDo(req, out)
+}
+
+func (p *Pipeline) googleDo(req *http.Request, out interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if isDropped(err) {
+			return &droppedTransfer{"request to Google Cloud", err}
+		}
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return fmt.Errorf("non-200 response from Google Cloud: %d, body: %s%s", resp.StatusCode, string(body), requestRef(resp))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode Google Cloud response: %v", err)
+	}
+	return nil
+}
+
+// transcript converts the finished operation's result for uri into the canonical
+// model.
+func (op *googleOperation) transcript(uri, audio string) (*Transcript, error) {
+	file, ok := op.Response.Results[uri]
+	if !ok {
+		return nil, fmt.Errorf("Speech-to-Text returned no result for %s", uri)
+	}
+	if file.Error != nil {
+		return nil, fmt.Errorf("Speech-to-Text failed on %s: %s", uri, file.Error.Message)
+	}
+	t := &Transcript{Audio: audio}
+	var texts []string
+	var words []labeledWord
+	for _, r := range file.InlineResult.Transcript.Results {
+		if t.Language == "" {
+			t.Language = r.LanguageCode
+		}
+		t.Duration = max(t.Duration, googleOffset(r.ResultEndOffset))
+		if len(r.Alternatives) == 0 {
+			continue
+		}
+		alt := r.Alternatives[0]
+		texts = append(texts, strings.TrimSpace(alt.Transcript))
+		for _, w := range alt.Words {
+			words = append(words, labeledWord{
+				Word: Word{
+					Text:       w.Word,
+					Start:      googleOffset(w.StartOffset),
+					End:        googleOffset(w.EndOffset),
+					Confidence: w.Confidence,
+				},
+				speaker: w.SpeakerLabel,
+			})
+		}
+	}
+	t.Text = strings.Join(texts, " ")
+	t.Segments = wordTurns(words)
+	return t, nil
+}
+
+// googleOffset parses a protobuf JSON duration such as "12.340s".
+func googleOffset(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSuffix(s, "s"), 64)
+	return f
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// guestListDate is the date that may start a line of a guest list.
+var guestListDate = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})[\s,;]+`)
+
+// readGuests returns the guests of the episode recorded on date (YYYY-MM-DD)
+// from a calendar invite or a guest list, leaving out the hosts. An .ics file
+// gives the attendees of its events on that day; any other file is a guest
+// list of one line per episode or guest: names separated by commas, after
+// the recording date for lines that only apply to that day.
+func readGuests(path, date string, hosts []string) ([]string, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, fmt.Errorf("-date %q isn't a YYYY-MM-DD date", date)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		names = calendarAttendees(string(data), day)
+	} else {
+		names = guestListNames(string(data), date)
+	}
+
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		seen[strings.ToLower(h)] = true
+	}
+	var guests []string
+	for _, n := range names {
+		key := strings.ToLower(n)
+		if n != "" && !seen[key] {
+			seen[key] = true
+			guests = append(guests, n)
+		}
+	}
+	return guests, nil
+}
+
+// guestListNames returns the names of a guest list's lines without a date
+// and of those dated date. Blank lines and lines starting with # are skipped.
+func guestListNames(list, date string) []string {
+	var names []string
+	sc := bufio.NewScanner(strings.NewReader(list))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := guestListDate.FindStringSubmatch(line); m != nil {
+			if m[1] != date {
+				continue
+			}
+			line = line[len(m[0]):]
+		}
+		for _, n := range strings.Split(line, ",") {
+			names = append(names, strings.TrimSpace(n))
+		}
+	}
+	return names
+}
+
+// calendarAttendees returns the common names of the organizers and attendees
+// of the iCalendar events starting on day, leaving out rooms and resources
+// and those who declined.
+func calendarAttendees(ics string, day time.Time) []string {
+	// Long lines are folded onto lines starting with a space or tab
+	ics = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(ics)
+	var names, event []string
+	onDay, inEvent := false, false
+	for _, line := range strings.Split(ics, "\n") {
+		name, params, value := icsProperty(strings.TrimRight(line, "\r"))
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			inEvent, onDay, event = true, false, nil
+		case name == "END" && value == "VEVENT":
+			if onDay {
+				names = append(names, event...)
+			}
+			inEvent = false
+		case !inEvent:
+		case name == "DTSTART":
+			onDay = icsDate(value) == day.Format("20060102")
+		case name == "ORGANIZER" || name == "ATTENDEE":
+			switch strings.ToUpper(params["CUTYPE"]) {
+			case "ROOM", "RESOURCE":
+				continue
+			}
+			if strings.ToUpper(params["PARTSTAT"]) == "DECLINED" {
+				continue
+			}
+			cn := params["CN"]
+			if last, first, ok := strings.Cut(cn, ", "); ok && !strings.Contains(first, ",") {
+				// Directories often give names as "Doe, Jane"
+				cn = first + " " + last
+			}
+			if cn != "" && !strings.Contains(cn, "@") {
+				event = append(event, cn)
+			}
+		}
+	}
+	return names
+}
+
+// icsProperty splits an unfolded iCalendar content line into its upper-case
+// name, its parameters, and its value. Parameter values may be quoted.
+func icsProperty(line string) (string, map[string]string, string) {
+	params := map[string]string{}
+	i := strings.IndexAny(line, ";:")
+	if i < 0 {
+		return strings.ToUpper(line), params, ""
+	}
+	name := strings.ToUpper(line[:i])
+	for i < len(line) && line[i] == ';' {
+		j := strings.IndexByte(line[i:], '=')
+		if j < 0 {
+			break
+		}
+		key := strings.ToUpper(line[i+1 : i+j])
+		i += j + 1
+		var val string
+		if i < len(line) && line[i] == '"' {
+			end := strings.IndexByte(line[i+1:], '"')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			val, i = line[i+1:i+1+end], i+end+2
+		} else {
+			end := strings.IndexAny(line[i:], ";:")
+			if end < 0 {
+				end = len(line) - i
+			}
+			val, i = line[i:i+end], i+end
+		}
+		params[key] = val
+	}
+	if i >= len(line) {
+		return name, params, ""
+	}
+	return name, params, line[i+1:]
+}
+
+// icsDate returns the YYYYMMDD date of a DTSTART: the local date of UTC
+// times, and the date as written otherwise, which is in the event's time zone.
+func icsDate(value string) string {
+	if strings.HasSuffix(value, "Z") {
+		if t, err := time.Parse("20060102T150405Z", value); err == nil {
+			return t.Local().Format("20060102")
+		}
+	}
+	if len(value) < 8 {
+		return ""
+	}
+	return value[:8]
+}
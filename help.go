@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// flagGroup is a heading the -h output lists flags under.
+type flagGroup struct {
+	title string
+	flags []string
+}
+
+// flagGroups orders the flags of the -h output by what they're for. Flags
+// not in any group are listed last, so a new flag is never left out.
+var flagGroups = []flagGroup{
+	{"Input", []string{"audio", "from", "to", "chunk", "stream", "speedup", "exclude", "boilerplate", "skip-boilerplate",
+		"import-transcript", "sample", "max-duration", "yes", "min-bitrate"}},
+	{"Episode", []string{"title", "date", "description", "feed", "lookup", "guests", "show", "config"}},
+	{"Transcription", []string{"backend", "transcription-model", "transcription-timeout", "language", "languages", "word-timestamps",
+		"ensemble", "retry-suspect", "local-command", "local-url", "gpu", "threads", "bucket", "region",
+		"cleanup", "normalize", "glossary", "verbatim"}},
+	{"Diarization", []string{"speakers", "diarization-model", "diarization-timeout", "diarizer", "diarize-by-chapter", "speaker-gap",
+		"min-crosstalk", "prompt", "examples", "example-tokens", "json-mode", "temperature", "top-p", "seed",
+		"max-prompt-tokens", "max-output-tokens", "verify", "verify-retries", "max-drift", "retry-speakers",
+		"name-speakers", "speaker-roles", "review-threshold", "events", "event-classifier", "pause"}},
+	{"Outputs", []string{"output-dir", "format", "timestamps", "wrap", "bom", "line-endings", "ass-style", "offset", "drift",
+		"backups", "anonymize", "withhold-speakers", "withhold-style", "off-record", "encryption-key", "sign-key"}},
+	{"Summaries and content", []string{"summarize", "summary-model", "summary-preset", "chapters", "min-chapter", "max-chapter",
+		"max-chapters", "clips", "clip-dir", "snippet-dir", "blog", "blog-style", "social", "newsletter", "minutes",
+		"study-notes", "title-variants", "translate", "translate-to", "translation-glossary"}},
+	{"Delivery", []string{"deliver-to", "email-outputs", "email-to", "email-from", "email-via", "post-draft",
+		"upload-transcript", "hosting-episode", "youtube-video-id", "on-transcript", "on-complete", "notify"}},
+	{"Cost and runs", []string{"profile", "batch", "rediarize", "reexport", "monthly-budget", "override-budget", "on-duplicate",
+		"cache-dir", "state", "wait", "window", "record", "replay", "audit-log", "max-memory", "max-upload-rate",
+		"openai-org", "openai-project", "user-agent", "quiet", "no-color"}},
+}
+
+// usageExample is a common workflow shown at the end of the -h output.
+type usageExample struct {
+	summary string
+	args    string
+}
+
+// usageExamples are the workflows of the -h output.
+var usageExamples = []usageExample{
+	{"Transcribe and diarize one episode into text and subtitles, with a summary", "-audio episode.mp3 -speakers 3 -format txt,srt -summarize"},
+	{"Read the start of a long episode while the rest is transcribed", "-audio episode.mp3 -chunk 10m -stream partial.txt"},
+	{"Spend as little as possible, with chat requests batched at half price", "-audio episode.mp3 -profile economy"},
+	{"Process every episode of a feed from a resumable plan", "import -feed https://example.com/feed.xml -out shows/mine -- -format txt,srt"},
+	{"Serve the review UI for correcting low-confidence turns", "review -in shows/mine -addr localhost:8765"},
+	{"Load completions into the current bash session", "completion bash > /tmp/pt.bash && source /tmp/pt.bash"},
+}
+
+// printUsage writes the -h output: the commands, the flags of fs by group,
+// and the examples.
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: podcast-transcription [flags] -audio <file>\n       podcast-transcription <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprint(w, commandUsage())
+
+	grouped := map[string]bool{}
+	for _, g := range flagGroups {
+		in := map[string]bool{}
+		for _, name := range g.flags {
+			in[name], grouped[name] = true, true
+		}
+		printFlags(w, g.title, fs, func(f *flag.Flag) bool { return in[f.Name] })
+	}
+	printFlags(w, "Other", fs, func(f *flag.Flag) bool { return !grouped[f.Name] })
+
+	fmt.Fprintln(w, "\nExamples:")
+	for _, ex := range usageExamples {
+		fmt.Fprintf(w, "  %s:\n    podcast-transcription %s\n", ex.summary, ex.args)
+	}
+}
+
+// printFlags writes the flags of fs that keep selects under title, as
+// flag.PrintDefaults would.
+func printFlags(w io.Writer, title string, fs *flag.FlagSet, keep func(*flag.Flag) bool) {
+	group := flag.NewFlagSet(title, flag.ContinueOnError)
+	var b strings.Builder
+	group.SetOutput(&b)
+	fs.VisitAll(func(f *flag.Flag) {
+		if keep(f) {
+			group.Var(f.Value, f.Name, f.Usage)
+			// Flags parsed before -h mustn't show up as the defaults
+			group.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	group.PrintDefaults()
+	if b.Len() > 0 {
+		fmt.Fprintf(w, "\n%s:\n%s", title, b.String())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// hookTimeout is how long a hook script may run.
+const hookTimeout = 10 * time.Minute
+
+// hookPayload is the JSON a hook script reads on stdin. Paths are absolute.
+type hookPayload struct {
+	Event      string   `json:"event"`
+	Audio      string   `json:"audio,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Date       string   `json:"date,omitempty"`
+	Duration   float64  `json:"duration"`
+	Speakers   []string `json:"speakers"`
+	Transcript string   `json:"transcript"`
+	Outputs    []string `json:"outputs"`
+	// Manifest and Warnings are only known once the run is complete.
+	Manifest string   `json:"manifest,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// newHookPayload describes the transcript t of the audio at audioPath and the
+// files written so far for the hook of event.
+func newHookPayload(event string, t *Transcript, audioPath, transcriptPath string, outputs []string) hookPayload {
+	h := hookPayload{
+		Event:      event,
+		Audio:      t.Audio,
+		Title:      t.Title,
+		Date:       t.Date,
+		Duration:   t.Duration,
+		Speakers:   t.speakers(),
+		Transcript: absPath(transcriptPath),
+		Outputs:    []string{},
+	}
+	if audioPath != "" {
+		h.Audio = absPath(audioPath)
+	}
+	for _, path := range outputs {
+		h.Outputs = append(h.Outputs, absPath(path))
+	}
+	return h
+}
+
+// runHook runs a -on-transcript or -on-complete command: its words, then the
+// output paths as arguments, with the payload as JSON on stdin. What it prints
+// is passed through to stderr.
+func (p *Pipeline) runHook(name, command string, payload hookPayload) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	args = append(args, payload.Outputs...)
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), "PODCAST_TRANSCRIPTION_EVENT="+payload.Event)
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("-%s hook %s timed out after %s", name, args[0], hookTimeout)
+		}
+		return fmt.Errorf("-%s hook %s failed: %v", name, args[0], err)
+	}
+	p.console.progressf("Ran the -%s hook %s in %s\n", name, filepath.Base(args[0]), formatElapsed(time.Since(start).Seconds()))
+	return nil
+}
+
+// checkHook fails if the program of a hook command can't be found.
+func checkHook(name, command string) error {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return fmt.Errorf("-%s: %v", name, err)
+	}
+	return nil
+}
+
+// absPath makes path absolute, leaving it as it is if that fails.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
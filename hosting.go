@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// transistorURL is the Transistor API, the podcast host -upload-transcript
+// attaches transcripts on. Buzzsprout's and Libsyn's APIs don't take
+// transcripts.
+const transistorURL = "https://api.transistor.fm/v1"
+
+// transistorKeyEnv holds the Transistor API key, from Account → API.
+const transistorKeyEnv = "TRANSISTOR_API_KEY"
+
+// hostingSettings is where a show is hosted, the hosting of its profile.
+type hostingSettings struct {
+	// Platform is the podcast host: transistor.
+	Platform string `json:"platform"`
+	// ShowID is the host's ID of the show, which episodes are searched in.
+	ShowID string `json:"show_id"`
+}
+
+// validate checks that the settings name a supported host and a show.
+func (h *hostingSettings) validate() error {
+	if h.Platform != "transistor" {
+		return fmt.Errorf("unknown hosting platform %q (available: transistor)", h.Platform)
+	}
+	if h.ShowID == "" {
+		return fmt.Errorf("hosting needs the show_id of the show on %s", h.Platform)
+	}
+	return nil
+}
+
+// uploadTranscript attaches t as text to its episode on the host and returns
+// the episode's ID there. episodeID names the episode; without it, the
+// episode is the one with the audio file's name or the transcript's title.
+func (p *Pipeline) uploadTranscript(ctx context.Context, h *hostingSettings, t *Transcript, episodeID string) (string, error) {
+	key := os.Getenv(transistorKeyEnv)
+	if key == "" {
+		return "", fmt.Errorf("please set the %s environment variable", transistorKeyEnv)
+	}
+	if episodeID == "" {
+		id, err := p.findTransistorEpisode(ctx, key, h.ShowID, t)
+		if err != nil {
+			return "", err
+		}
+		if id == "" {
+			return "", fmt.Errorf("no episode of show %s on %s is titled %q or has the audio %s; give it with -hosting-episode", h.ShowID, h.Platform, t.Title, t.Audio)
+		}
+		episodeID = id
+	}
+	form := url.Values{"episode[transcript_text]": {renderText(t, p.renderOptions())}}
+	req, err := http.NewRequestWithContext(ctx, "PATCH", transistorURL+"/episodes/"+url.PathEscape(episodeID), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var res struct{}
+	if err := p.transistorDo(req, key, &res); err != nil {
+		return "", err
+	}
+	return episodeID, nil
+}
+
+// findTransistorEpisode searches the show's episodes for the one whose audio
+// has the name of t's, or else whose title is t's, and returns its ID, or ""
+// if none matches.
+func (p *Pipeline) findTransistorEpisode(ctx context.Context, key, showID string, t *Transcript) (string, error) {
+	query := url.Values{"show_id": {showID}, "pagination[per]": {"50"}}
+	if t.Title != "" {
+		query.Set("query", t.Title)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", transistorURL+"/episodes?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	var res struct {
+		Data []struct {
+			ID         string `json:"id"`
+			Attributes struct {
+				Title    string `json:"title"`
+				MediaURL string `json:"media_url"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := p.transistorDo(req, key, &res); err != nil {
+		return "", err
+	}
+	for _, ep := range res.Data {
+		if u, err := url.Parse(ep.Attributes.MediaURL); err == nil && t.Audio != "" && path.Base(u.Path) == t.Audio {
+			return ep.ID, nil
+		}
+	}
+	for _, ep := range res.Data {
+		if t.Title != "" && strings.EqualFold(strings.TrimSpace(ep.Attributes.Title), t.Title) {
+			return ep.ID, nil
+		}
+	}
+	return "", nil
+}
+
+func (p *Pipeline) transistorDo(req *http.Request, key string, out any) error {
+	req.Header.Set("x-api-key", key)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return fmt.Errorf("non-200 response from Transistor: %d, body: %s%s", resp.StatusCode, string(body), requestRef(resp))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode Transistor response: %v", err)
+	}
+	return nil
+}
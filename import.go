@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// importPlanFile is the plan an import keeps in its output directory. It is
+// saved after every episode, so that an interrupted import resumes where it
+// stopped.
+const importPlanFile = "import.json"
+
+// Rough rates for estimating an import before it has timed any episodes.
+const (
+	// speechTokensPerMinute is about how many tokens a minute of conversation
+	// transcribes to.
+	speechTokensPerMinute = 200
+	// importSpeed is the fraction of its length an episode is assumed to take
+	// to process.
+	importSpeed = 0.25
+)
+
+// importStopDelay is how long a stopped episode's run has to exit before it
+// is killed.
+const importStopDelay = 10 * time.Second
+
+// importFormats are the audio file types picked up from an import directory:
+// those Whisper accepts and common ones that are transcoded first.
+var importFormats = map[string]bool{"aac": true, "aiff": true, "m4b": true, "opus": true, "wma": true}
+
+// importPlan is the processing plan of an import.
+type importPlan struct {
+	Source   string          `json:"source"`
+	Episodes []importEpisode `json:"episodes"`
+}
+
+// importEpisode is one episode of an import and how far it got.
+type importEpisode struct {
+	Slug     string  `json:"slug"`
+	Title    string  `json:"title,omitempty"`
+	Date     string  `json:"date,omitempty"`
+	Audio    string  `json:"audio"`
+	Duration float64 `json:"duration,omitempty"`
+	Cost     float64 `json:"estimated_cost,omitempty"`
+	// Status is "pending", "done" or "failed".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Elapsed is how many seconds the episode's run took.
+	Elapsed float64 `json:"elapsed,omitempty"`
+
+	published time.Time
+}
+
+// runImport implements the import command.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	feed := flags.String("feed", "", "Podcast RSS feed whose episodes are imported")
+	dir := flags.String("dir", "", "Directory searched recursively for the audio files to import")
+	out := flags.String("out", "import", "Directory the episodes are processed into, one subdirectory each, next to the plan in "+importPlanFile)
+	order := flags.String("order", "oldest", "Processing order: oldest or newest first (by publication date for -feed, by file name for -dir)")
+	limit := flags.Int("limit", 0, "Process at most this many episodes in this run (0 for all)")
+	planOnly := flags.Bool("plan", false, "Print the plan with its estimated cost and time and exit without processing anything")
+	windowSpec := flags.String("window", "", "Only start episodes within this daily local time window, e.g. 01:00-07:00")
+	maxRuntime := flags.Duration("max-runtime", 0, "Stop after this long, e.g. 2h, leaving the episodes not done for the next run of the same command (0 for no limit)")
+	every := flags.Int("progress-every", 10, "Print a progress summary after every this many episodes (0 prints one only at the end)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription import -feed url | -dir dir [-out dir] [-plan] [flags] [-- run flags]")
+		fmt.Fprintln(flags.Output(), "Flags after -- are passed to the run of every episode, e.g. -- -format txt,srt -summarize")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (*feed == "") == (*dir == "") {
+		return fmt.Errorf("give one of -feed or -dir")
+	}
+	if *order != "oldest" && *order != "newest" {
+		return fmt.Errorf("unknown -order %q (available: oldest, newest)", *order)
+	}
+	var window *timeWindow
+	if *windowSpec != "" {
+		w, err := parseWindow(*windowSpec)
+		if err != nil {
+			return fmt.Errorf("-window: %v", err)
+		}
+		window = &w
+	}
+	var deadline time.Time
+	if *maxRuntime > 0 {
+		deadline = time.Now().Add(*maxRuntime)
+	}
+	runArgs := flags.Args()
+	cfg := defaultConfig()
+	cfg.TranscriptionModel = importTranscriptionModel(runArgs)
+	cfg.DiarizationModel = firstNonEmpty(profileFlag(runArgs, "diarization-model"), cfg.DiarizationModel)
+	cfg.Batch = runBoolFlag(runArgs, "batch") || profileFlag(runArgs, "batch") == "true"
+	if speedup, err := strconv.ParseFloat(profileFlag(runArgs, "speedup"), 64); err == nil && speedup > 1 {
+		cfg.Speedup = speedup
+	}
+	p := newPipeline(&cfg, nil)
+
+	// Ctrl-C or a SIGTERM stops the episode being processed, which is run
+	// again on resume
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source := *dir
+	var found []importEpisode
+	var err error
+	if *feed != "" {
+		source = *feed
+		found, err = p.feedEpisodes(ctx, *feed)
+	} else {
+		found, err = dirEpisodes(*dir)
+	}
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no episodes found in %s", source)
+	}
+	sortImport(found, *order == "newest")
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return fmt.Errorf("failed to create import directory: %v", err)
+	}
+	lock, err := p.lockOutputDir(*out, false)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	planPath := filepath.Join(*out, importPlanFile)
+	plan, err := loadImportPlan(planPath)
+	if err != nil {
+		return err
+	}
+	plan.merge(source, found)
+	plan.estimate(&cfg)
+	if err := plan.save(planPath); err != nil {
+		return err
+	}
+
+	if *planOnly {
+		plan.print(os.Stdout)
+		plan.summary(os.Stdout, &cfg)
+		return nil
+	}
+	plan.summary(os.Stdout, &cfg)
+
+	// The episodes left are queued as jobs, which the status command lists
+	// and can cancel
+	key, err := loadEncryptionKey(runFlag(runArgs, "encryption-key"))
+	if err != nil {
+		return fmt.Errorf("-encryption-key: %v", err)
+	}
+	store := openStateStore(firstNonEmpty(runFlag(runArgs, "state"), defaultStatePath()), key)
+	for i := range plan.Episodes {
+		ep := &plan.Episodes[i]
+		if ep.Status != "done" {
+			dir := filepath.Join(*out, ep.Slug)
+			if err := store.queueJob(ep.Audio, dir, importRunArgs(ep, dir, *feed, runArgs)); err != nil {
+				p.console.warnf("failed to queue %s in the state store: %v\n", ep.Slug, err)
+			}
+		}
+	}
+
+	processed := 0
+	outOfTime := false
+	for i := range plan.Episodes {
+		ep := &plan.Episodes[i]
+		if ep.Status == "done" {
+			continue
+		}
+		if store.jobCancelled(ep.Audio, filepath.Join(*out, ep.Slug)) {
+			fmt.Printf("Skipping %s, cancelled (retry it with the status command)\n", ep.label())
+			continue
+		}
+		if *limit > 0 && processed == *limit {
+			break
+		}
+		runCtx, cancel := ctx, func() {}
+		if !deadline.IsZero() {
+			runCtx, cancel = context.WithDeadline(ctx, deadline)
+		}
+		if window != nil {
+			err := p.waitForWindow(runCtx, *window)
+			if err != nil && ctx.Err() == nil {
+				cancel()
+				outOfTime = true
+				break
+			}
+			if err != nil {
+				cancel()
+				return fmt.Errorf("import interrupted; run the same command again to resume")
+			}
+		}
+		// An episode is only started if it's expected to finish in the time
+		// left, except the first, so that a long one can't stall every run
+		if !deadline.IsZero() && processed > 0 {
+			if need := ep.Duration * plan.speed(); time.Until(deadline).Seconds() < need {
+				cancel()
+				outOfTime = true
+				break
+			}
+		}
+		fmt.Printf("\n[%d/%d] %s\n", i+1, len(plan.Episodes), ep.label())
+		began := time.Now()
+		err := runImportEpisode(runCtx, ep, filepath.Join(*out, ep.Slug), *feed, runArgs)
+		timedOut := runCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if ctx.Err() != nil {
+			return fmt.Errorf("import interrupted; run the same command again to resume")
+		}
+		if timedOut {
+			// The episode stays pending and runs again on resume, from its
+			// cached transcription if it got that far
+			p.console.warnf("-max-runtime reached during %s, which was stopped\n", ep.Slug)
+			dir := filepath.Join(*out, ep.Slug)
+			if err := store.queueJob(ep.Audio, dir, importRunArgs(ep, dir, *feed, runArgs)); err != nil {
+				p.console.warnf("failed to record %s in the state store: %v\n", ep.Slug, err)
+			}
+			outOfTime = true
+			break
+		}
+		ep.Elapsed = time.Since(began).Seconds()
+		if err != nil {
+			ep.Status, ep.Error = "failed", err.Error()
+			p.console.warnf("%s failed: %v\n", ep.Slug, err)
+			if err := store.finishJob(jobID(ep.Audio, filepath.Join(*out, ep.Slug)), jobFailed, ep.Error); err != nil {
+				p.console.warnf("failed to record %s in the state store: %v\n", ep.Slug, err)
+			}
+		} else {
+			ep.Status, ep.Error = "done", ""
+		}
+		if err := plan.save(planPath); err != nil {
+			return err
+		}
+		processed++
+		if *every > 0 && processed%*every == 0 {
+			fmt.Println()
+			plan.summary(os.Stdout, &cfg)
+		}
+	}
+	fmt.Println()
+	plan.summary(os.Stdout, &cfg)
+	if outOfTime {
+		fmt.Printf("Stopped at -max-runtime %s; run the same command again to resume\n", *maxRuntime)
+	}
+	if failed := plan.count("failed"); failed > 0 {
+		return fmt.Errorf("%d episode(s) failed; run the same command again to retry them", failed)
+	}
+	return nil
+}
+
+// importTranscriptionModel returns the transcription model the runs will use,
+// for the cost estimate, from the -transcription-model or -backend passed to
+// them.
+func importTranscriptionModel(runArgs []string) string {
+	if model := runFlag(runArgs, "transcription-model"); model != "" {
+		return model
+	}
+	if name := runFlag(runArgs, "backend"); name != "" {
+		if b, err := lookupBackend(name); err == nil && b.defaultModel != "" {
+			return b.defaultModel
+		}
+	}
+	return defaultConfig().TranscriptionModel
+}
+
+// runFlag returns the last value given for the named flag in args.
+func runFlag(args []string, name string) string {
+	value := ""
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimLeft(args[i], "-")
+		if arg == name && i+1 < len(args) {
+			value = args[i+1]
+			i++
+		} else if v, ok := strings.CutPrefix(arg, name+"="); ok {
+			value = v
+		}
+	}
+	return value
+}
+
+// runBoolFlag reports whether the boolean flag name is set among the run
+// flags, as -name or -name=true.
+func runBoolFlag(args []string, name string) bool {
+	set := false
+	for _, arg := range args {
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name {
+			set = true
+		} else if v, ok := strings.CutPrefix(arg, name+"="); ok {
+			set, _ = strconv.ParseBool(v)
+		}
+	}
+	return set
+}
+
+// feedEpisodes returns the episodes of the feed that have audio.
+func (p *Pipeline) feedEpisodes(ctx context.Context, feedURL string) ([]importEpisode, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.HTTPTimeout)
+	defer cancel()
+	items, err := p.fetchFeed(ctx, feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %v", err)
+	}
+	var episodes []importEpisode
+	for _, it := range items {
+		if it.Enclosure.URL == "" {
+			continue
+		}
+		ep := importEpisode{Title: strings.TrimSpace(it.Title), Audio: it.Enclosure.URL}
+		for _, layout := range []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"} {
+			if t, err := time.Parse(layout, strings.TrimSpace(it.PubDate)); err == nil {
+				ep.published, ep.Date = t, t.Format("2006-01-02")
+				break
+			}
+		}
+		if d, err := parseClock(it.Duration); err == nil {
+			ep.Duration = d
+		}
+		episodes = append(episodes, ep)
+	}
+	return episodes, nil
+}
+
+// dirEpisodes returns an episode for each audio file under dir.
+func dirEpisodes(dir string) ([]importEpisode, error) {
+	var episodes []importEpisode
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+		if d.IsDir() || !(whisperFormats[ext] || importFormats[ext]) {
+			return nil
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		episodes = append(episodes, importEpisode{Title: strings.TrimSuffix(d.Name(), filepath.Ext(path)), Audio: abs})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+	return episodes, nil
+}
+
+// sortImport orders episodes oldest first, or newest first: feed episodes by
+// publication date and files by path, which is usually their episode number.
+func sortImport(episodes []importEpisode, newest bool) {
+	sort.SliceStable(episodes, func(i, j int) bool {
+		a, b := episodes[i], episodes[j]
+		if newest {
+			a, b = b, a
+		}
+		if !a.published.IsZero() && !b.published.IsZero() {
+			return a.published.Before(b.published)
+		}
+		if a.published.IsZero() != b.published.IsZero() {
+			// Undated episodes go last either way
+			return !episodes[i].published.IsZero()
+		}
+		return a.Audio < b.Audio
+	})
+}
+
+// loadImportPlan reads the plan at path; a missing file yields an empty plan.
+func loadImportPlan(path string) (*importPlan, error) {
+	plan := &importPlan{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return plan, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import plan: %v", err)
+	}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("failed to parse import plan %s: %v", path, err)
+	}
+	return plan, nil
+}
+
+// save writes the plan by writing a temporary file and renaming it into place,
+// so that an interrupted save leaves the previous plan.
+func (plan *importPlan) save(path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal import plan: %v", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save import plan: %v", err)
+	}
+	return nil
+}
+
+// merge replaces the plan's episodes with found, in found's order, keeping
+// the slug, status and timing of the ones already planned. Episodes already
+// processed that are no longer found, say because they dropped off the feed,
+// stay at the end.
+func (plan *importPlan) merge(source string, found []importEpisode) {
+	planned := map[string]importEpisode{}
+	slugs := map[string]bool{}
+	for _, ep := range plan.Episodes {
+		planned[ep.Audio] = ep
+		slugs[ep.Slug] = true
+	}
+	episodes := make([]importEpisode, 0, len(found))
+	for _, ep := range found {
+		if old, ok := planned[ep.Audio]; ok {
+			ep.Slug, ep.Status, ep.Error, ep.Elapsed = old.Slug, old.Status, old.Error, old.Elapsed
+			if ep.Duration == 0 {
+				ep.Duration = old.Duration
+			}
+			delete(planned, ep.Audio)
+		} else {
+			ep.Slug = uniqueSlug(slugify(strings.TrimSpace(ep.Date+" "+ep.Title)), slugs)
+			ep.Status = "pending"
+		}
+		if ep.Duration == 0 && !isRemoteAudio(ep.Audio) {
+			// An ffprobe failure leaves the length unknown
+			ep.Duration, _ = probeDuration(ep.Audio)
+		}
+		episodes = append(episodes, ep)
+	}
+	for _, ep := range plan.Episodes {
+		if _, ok := planned[ep.Audio]; ok && ep.Status == "done" {
+			episodes = append(episodes, ep)
+		}
+	}
+	plan.Source, plan.Episodes = source, episodes
+}
+
+// uniqueSlug returns slug, numbered if it is already in taken, and adds it.
+func uniqueSlug(slug string, taken map[string]bool) string {
+	unique := slug
+	for n := 2; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", slug, n)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// estimate sets each episode's estimated cost from its length: transcription
+// of the audio, sped up with -speedup, plus a diarization reply as long as the
+// transcript.
+func (plan *importPlan) estimate(cfg *Config) {
+	for i := range plan.Episodes {
+		ep := &plan.Episodes[i]
+		tokens := int(ep.Duration / 60 * speechTokensPerMinute)
+		chat := chatCost(cfg.DiarizationModel, TokenUsage{PromptTokens: tokens, CompletionTokens: tokens})
+		if cfg.Batch {
+			chat *= batchDiscount
+		}
+		ep.Cost = audioCost(cfg.TranscriptionModel, ep.Duration/cfg.Speedup) + chat
+	}
+}
+
+func (plan *importPlan) count(status string) int {
+	n := 0
+	for _, ep := range plan.Episodes {
+		if ep.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (ep *importEpisode) label() string {
+	label := firstNonEmpty(ep.Title, ep.Slug)
+	if ep.Date != "" {
+		label = ep.Date + "  " + label
+	}
+	return label
+}
+
+// print lists the plan's episodes in processing order.
+func (plan *importPlan) print(w io.Writer) {
+	for i, ep := range plan.Episodes {
+		length, cost := "?", "?"
+		if ep.Duration > 0 {
+			length = roundedDuration(ep.Duration).String()
+			cost = fmt.Sprintf("$%.2f", ep.Cost)
+		}
+		fmt.Fprintf(w, "%4d. %-8s %9s %7s  %s\n", i+1, ep.Status, length, cost, ep.label())
+	}
+	fmt.Fprintln(w)
+}
+
+// summary writes where the import stands: the episodes done, failed and
+// left, and the estimated cost and time of the rest. The time is projected
+// from how long the episodes processed so far took, or from importSpeed
+// before any have been.
+func (plan *importPlan) summary(w io.Writer, cfg *Config) {
+	var doneAudio, doneTime, doneCost, leftAudio, leftCost float64
+	unknown := 0
+	for _, ep := range plan.Episodes {
+		switch {
+		case ep.Status == "done":
+			doneAudio += ep.Duration
+			doneCost += ep.Cost
+			if ep.Duration > 0 {
+				doneTime += ep.Elapsed
+			}
+		case ep.Duration == 0:
+			unknown++
+		default:
+			leftAudio += ep.Duration
+			leftCost += ep.Cost
+		}
+	}
+	speed := plan.speed()
+	done, failed := plan.count("done"), plan.count("failed")
+	fmt.Fprintf(w, "Import of %s: %d episodes, %d done, %d failed, %d left\n", plan.Source, len(plan.Episodes), done, failed, len(plan.Episodes)-done)
+	if done > 0 {
+		fmt.Fprintf(w, "  Processed: %s of audio in %s, about $%.2f\n", roundedDuration(doneAudio), roundedDuration(doneTime), doneCost)
+	}
+	if left := len(plan.Episodes) - done; left > 0 {
+		switch {
+		case leftAudio == 0:
+			fmt.Fprintf(w, "  Left: %d episode(s) of unknown length\n", unknown)
+		case unknown > 0:
+			fmt.Fprintf(w, "  Left: %s of audio, about $%.2f and %s, plus %d episode(s) of unknown length\n", roundedDuration(leftAudio), leftCost, roundedDuration(leftAudio*speed), unknown)
+		default:
+			fmt.Fprintf(w, "  Left: %s of audio, about $%.2f and %s\n", roundedDuration(leftAudio), leftCost, roundedDuration(leftAudio*speed))
+		}
+		fmt.Fprintf(w, "  Costs are estimates at list prices for %s transcription and %s diarization\n", cfg.TranscriptionModel, cfg.DiarizationModel)
+	}
+}
+
+// speed is the fraction of its length an episode takes to process, as timed
+// on the episodes done so far, or importSpeed before any are.
+func (plan *importPlan) speed() float64 {
+	var audio, elapsed float64
+	for _, ep := range plan.Episodes {
+		if ep.Status == "done" && ep.Duration > 0 {
+			audio += ep.Duration
+			elapsed += ep.Elapsed
+		}
+	}
+	if audio > 0 && elapsed > 0 {
+		return elapsed / audio
+	}
+	return importSpeed
+}
+
+func roundedDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Minute)
+}
+
+// importRunArgs returns the arguments of the run processing ep into dir.
+func importRunArgs(ep *importEpisode, dir, feedURL string, runArgs []string) []string {
+	args := []string{"-audio", ep.Audio, "-output-dir", dir}
+	if ep.Title != "" {
+		args = append(args, "-title", ep.Title)
+	}
+	if ep.Date != "" {
+		args = append(args, "-date", ep.Date)
+	}
+	if feedURL != "" {
+		args = append(args, "-feed", feedURL)
+	}
+	return append(args, runArgs...)
+}
+
+// runImportEpisode processes one episode by running this program on it, so
+// that every episode gets a fresh run with the same flags as a single-episode
+// invocation. Flags in runArgs come last and win. The run's output is passed
+// through, and the last line it printed to stderr is the error if it failed.
+func runImportEpisode(ctx context.Context, ep *importEpisode, dir, feedURL string, runArgs []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the program to run: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create episode directory: %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, importRunArgs(ep, dir, feedURL, runArgs)...)
+	// The run is sent a SIGTERM rather than killed, so that it re-queues its
+	// job with its checkpoint saved
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = importStopDelay
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+			return fmt.Errorf("%s", last)
+		}
+		return fmt.Errorf("run failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fingerprintBlockSize is the size of the audio blocks hashed separately so that
+// a file extended at the end can be recognised by its unchanged leading blocks.
+const fingerprintBlockSize = 1 << 20
+
+// incrementalOverlap is how many seconds before the end of the previous audio are
+// transcribed again, so the join doesn't fall in the middle of a word.
+const incrementalOverlap = 10
+
+// AudioFingerprint identifies the audio file a transcription was made from.
+type AudioFingerprint struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Blocks are truncated SHA-256 digests of consecutive fingerprintBlockSize
+	// blocks of the audio after any leading ID3 tag, so that retagging the file
+	// doesn't shift them.
+	Blocks []string `json:"blocks"`
+}
+
+// fingerprintAudio hashes the audio file as a whole and in blocks.
+func fingerprintAudio(path string) (*AudioFingerprint, error) {
+	size, sum, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err == nil && string(header[:3]) == "ID3" {
+		_, err = f.Seek(int64(10+syncsafe(header[6:10])), io.SeekStart)
+	} else {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %v", err)
+	}
+
+	fp := &AudioFingerprint{Size: size, SHA256: sum}
+	buf := make([]byte, fingerprintBlockSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			h := sha256.Sum256(buf[:n])
+			fp.Blocks = append(fp.Blocks, hex.EncodeToString(h[:8]))
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audio file: %v", err)
+		}
+	}
+	return fp, nil
+}
+
+// extends reports whether fp is the audio of old with more appended: every block
+// of old but its last, possibly partial, one is unchanged.
+func (fp *AudioFingerprint) extends(old *AudioFingerprint) bool {
+	if fp.Size <= old.Size || len(old.Blocks) == 0 || len(fp.Blocks) < len(old.Blocks) {
+		return false
+	}
+	for i := 0; i < len(old.Blocks)-1; i++ {
+		if fp.Blocks[i] != old.Blocks[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// extendTranscription transcribes only the audio appended since cached was made
+// and merges it in. The last incrementalOverlap seconds of the old audio are
+// transcribed again and replace the old segments there.
+func (p *Pipeline) extendTranscription(ctx context.Context, be backend, apiKey string, cached *Transcript, audioPath string) (*Transcript, error) {
+	oldEnd := cached.Duration
+	if n := len(cached.Segments); n > 0 {
+		oldEnd = max(oldEnd, cached.Segments[n-1].End)
+	}
+	if newDuration, err := probeDuration(audioPath); err == nil && newDuration <= oldEnd {
+		return nil, fmt.Errorf("audio is not longer than the cached transcription (%.1fs <= %.1fs)", newDuration, oldEnd)
+	}
+
+	// Keep the old segments that end well before the join and cut the audio there
+	var kept []Segment
+	start := 0.0
+	for _, s := range cached.Segments {
+		if s.End > oldEnd-incrementalOverlap {
+			break
+		}
+		kept = append(kept, s)
+		start = s.End
+	}
+
+	tailPath, cleanup, err := p.cutAudio(ctx, audioPath, start, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	tail, err := be.transcribe(p, ctx, apiKey, tailPath)
+	if err != nil {
+		return nil, err
+	}
+	if be.diarizes {
+		p.console.warnf("speaker labels of the newly transcribed tail may not match the earlier part\n")
+	}
+
+	merged := *cached
+	merged.Segments = kept
+	for _, s := range tail.Segments {
+		s.Start += start
+		s.End += start
+		for i := range s.Words {
+			s.Words[i].Start += start
+			s.Words[i].End += start
+		}
+		merged.Segments = append(merged.Segments, s)
+	}
+	for i := range merged.Segments {
+		merged.Segments[i].ID = i
+	}
+	merged.Chapters = nil
+	for _, c := range cached.Chapters {
+		if c.Start < start {
+			merged.Chapters = append(merged.Chapters, c)
+		}
+	}
+	for _, c := range tail.Chapters {
+		c.Start += start
+		c.End += start
+		merged.Chapters = append(merged.Chapters, c)
+	}
+	merged.Entities = nil
+	for _, e := range cached.Entities {
+		if e.End <= start {
+			merged.Entities = append(merged.Entities, e)
+		}
+	}
+	for _, e := range tail.Entities {
+		e.Start += start
+		e.End += start
+		merged.Entities = append(merged.Entities, e)
+	}
+	merged.Text = joinSegmentText(merged.Segments)
+	merged.Duration = start + tail.Duration
+	return &merged, nil
+}
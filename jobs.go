@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Job statuses. A job recorded as running whose output directory is no longer
+// locked exited without finishing, and is shown as failed.
+const (
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// jobRecord is a run of one input into one output directory, as kept in the
+// state store.
+type jobRecord struct {
+	ID     string `json:"id"`
+	Input  string `json:"input"`
+	Output string `json:"output_dir"`
+	// WorkDir and Args are where and how the run was started, for retrying it.
+	WorkDir    string     `json:"work_dir"`
+	Args       []string   `json:"args"`
+	Status     string     `json:"status"`
+	Stage      string     `json:"stage,omitempty"`
+	PID        int        `json:"pid,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// jobID identifies the job of processing input into dir, so that runs of the
+// same episode update the same record.
+func jobID(input, dir string) string {
+	if !strings.Contains(input, "://") {
+		if abs, err := filepath.Abs(input); err == nil {
+			input = abs
+		}
+	}
+	sum := sha256.Sum256([]byte(input + "\x00" + absDir(dir)))
+	return hex.EncodeToString(sum[:4])
+}
+
+// startJob records the job of this run as running.
+func (s *stateStore) startJob(input, dir string, args []string) (string, error) {
+	id := jobID(input, dir)
+	wd, _ := os.Getwd()
+	now := time.Now().UTC()
+	err := s.update(func(st *State) {
+		st.Jobs[id] = jobRecord{
+			ID: id, Input: input, Output: absDir(dir), WorkDir: wd, Args: args,
+			Status: jobRunning, PID: os.Getpid(), UpdatedAt: now, StartedAt: &now,
+		}
+	})
+	return id, err
+}
+
+// jobStage records the stage a running job is at.
+func (s *stateStore) jobStage(id, stage string) error {
+	return s.update(func(st *State) {
+		if j, ok := st.Jobs[id]; ok {
+			j.Stage, j.UpdatedAt = stage, time.Now().UTC()
+			st.Jobs[id] = j
+		}
+	})
+}
+
+// finishJob records the job done, cancelled, or failed with errMsg.
+func (s *stateStore) finishJob(id, status, errMsg string) error {
+	return s.update(func(st *State) {
+		if j, ok := st.Jobs[id]; ok {
+			now := time.Now().UTC()
+			j.Status, j.Error, j.PID = status, errMsg, 0
+			j.UpdatedAt, j.FinishedAt = now, &now
+			if status == jobDone {
+				j.Stage = ""
+			}
+			st.Jobs[id] = j
+		}
+	})
+}
+
+// requeueJob records a job stopped before it finished, by a SIGTERM, as
+// pending again, keeping the stage it stopped at.
+func (s *stateStore) requeueJob(id string) error {
+	return s.update(func(st *State) {
+		if j, ok := st.Jobs[id]; ok {
+			j.Status, j.PID, j.UpdatedAt = jobPending, 0, time.Now().UTC()
+			st.Jobs[id] = j
+		}
+	})
+}
+
+// queueJob records a job that is yet to run, such as an episode of an
+// import, unless it's running or was cancelled.
+func (s *stateStore) queueJob(input, dir string, args []string) error {
+	id := jobID(input, dir)
+	wd, _ := os.Getwd()
+	return s.update(func(st *State) {
+		if j, ok := st.Jobs[id]; ok && (j.Status == jobCancelled || j.live()) {
+			return
+		}
+		st.Jobs[id] = jobRecord{
+			ID: id, Input: input, Output: absDir(dir), WorkDir: wd, Args: args,
+			Status: jobPending, UpdatedAt: time.Now().UTC(),
+		}
+	})
+}
+
+// jobCancelled reports whether the job of processing input into dir was
+// cancelled.
+func (s *stateStore) jobCancelled(input, dir string) bool {
+	st, err := s.load()
+	if err != nil {
+		return false
+	}
+	return st.Jobs[jobID(input, dir)].Status == jobCancelled
+}
+
+// live reports whether the job is running: recorded as running, with its
+// output directory still locked by a run.
+func (j jobRecord) live() bool {
+	if j.Status != jobRunning {
+		return false
+	}
+	path := filepath.Join(j.Output, lockFileName)
+	f, ok, err := tryLockFile(path)
+	if err != nil {
+		return false
+	}
+	if ok {
+		unlockFile(f, path)
+		return false
+	}
+	return true
+}
+
+// current is the job's status as shown, with runs that exited without
+// finishing as failed.
+func (j jobRecord) current() (string, string) {
+	if j.Status == jobRunning && !j.live() {
+		msg := "exited without finishing"
+		if j.Stage != "" {
+			msg += " during " + j.Stage
+		}
+		return jobFailed, msg
+	}
+	return j.Status, j.Error
+}
+
+// cached reports whether the job's transcription is cached in its output
+// directory, so that a retry doesn't transcribe again.
+func (j jobRecord) cached() bool {
+	_, err := os.Stat(filepath.Join(j.Output, filepath.Base(defaultConfig().TranscriptionJSONFile)))
+	return err == nil
+}
+
+// runStatus implements the status command: the jobs, or with retry or cancel
+// and a job ID, that operation.
+func runStatus(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	statePath := flags.String("state", defaultStatePath(), "Path to the local state store")
+	asJSON := flags.Bool("json", false, "Print the jobs as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription status [-state file] [-json] [retry id | cancel id]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	// The state is encrypted with the key of the runs, from the environment
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	store := openStateStore(*statePath, key)
+	st, err := store.load()
+	if err != nil {
+		return err
+	}
+	switch op := flags.Arg(0); op {
+	case "":
+		return printJobs(st, *asJSON)
+	case "retry", "cancel":
+		if flags.NArg() != 2 {
+			return fmt.Errorf("%s needs a job ID", op)
+		}
+		j, ok := st.Jobs[flags.Arg(1)]
+		if !ok {
+			return fmt.Errorf("no job %s", flags.Arg(1))
+		}
+		if op == "retry" {
+			return retryJob(store, j)
+		}
+		return cancelJob(store, j)
+	default:
+		return fmt.Errorf("unknown operation %q (available: retry, cancel)", op)
+	}
+}
+
+// printJobs lists the jobs, the most recently updated first.
+func printJobs(st *State, asJSON bool) error {
+	jobs := make([]jobRecord, 0, len(st.Jobs))
+	for _, j := range st.Jobs {
+		j.Status, j.Error = j.current()
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].UpdatedAt.After(jobs[b].UpdatedAt) })
+	if asJSON {
+		return printJSON(map[string][]jobRecord{"jobs": jobs})
+	}
+	if len(jobs) == 0 {
+		fmt.Println("No jobs recorded.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSTAGE\tCACHED\tUPDATED\tINPUT")
+	for _, j := range jobs {
+		cached := "no"
+		if j.cached() {
+			cached = "yes"
+		}
+		stage := j.Stage
+		if j.Status == jobFailed && j.Error != "" {
+			stage = j.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", j.ID, j.Status, firstNonEmpty(stage, "-"), cached,
+			j.UpdatedAt.Local().Format("2006-01-02 15:04"), j.Input)
+	}
+	return w.Flush()
+}
+
+// retryJob runs the job again, as it was started, in the foreground.
+func retryJob(store *stateStore, j jobRecord) error {
+	if j.live() {
+		return fmt.Errorf("job %s is running", j.ID)
+	}
+	if len(j.Args) == 0 {
+		return fmt.Errorf("job %s has no recorded arguments to run it with", j.ID)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the program to run: %v", err)
+	}
+	fmt.Printf("Retrying job %s: %s\n", j.ID, j.Input)
+	started := time.Now().UTC()
+	cmd := exec.Command(exe, j.Args...)
+	cmd.Dir = j.WorkDir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		// A run that failed before it recorded itself would keep the job's
+		// old status
+		if st, lerr := store.load(); lerr == nil {
+			if r := st.Jobs[j.ID]; r.StartedAt == nil || r.StartedAt.Before(started) {
+				store.finishJob(j.ID, jobFailed, err.Error())
+			}
+		}
+		return fmt.Errorf("job %s failed again: %v", j.ID, err)
+	}
+	return nil
+}
+
+// cancelJob stops the job if it's running, and marks it cancelled so that
+// an import skips it until it's retried.
+func cancelJob(store *stateStore, j jobRecord) error {
+	if j.live() {
+		pid := lockHolder(filepath.Join(j.Output, lockFileName))
+		if pid <= 0 {
+			pid = j.PID
+		}
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("failed to find job %s's process %d: %v", j.ID, pid, err)
+		}
+		if err := proc.Signal(os.Interrupt); err != nil {
+			// Not every platform delivers interrupts to other processes
+			if err := proc.Kill(); err != nil {
+				return fmt.Errorf("failed to stop job %s: %v", j.ID, err)
+			}
+		}
+	} else if status, _ := j.current(); status == jobDone {
+		return fmt.Errorf("job %s is already done", j.ID)
+	}
+	if err := store.finishJob(j.ID, jobCancelled, ""); err != nil {
+		return err
+	}
+	fmt.Printf("Cancelled job %s\n", j.ID)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Karaoke cues are short lines for captions burned into vertical video: at
+// most karaokeWords words or karaokeChars characters, broken early at a pause
+// of karaokePause seconds.
+const (
+	karaokeWords = 7
+	karaokeChars = 36
+	karaokePause = 0.6
+)
+
+// karaokeStyle highlights the words already spoken, in players that support
+// the WebVTT :past pseudo-class.
+const karaokeStyle = `STYLE
+::cue(:past) {
+  color: #ffd400;
+}
+
+`
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// renderKaraoke writes WebVTT captions in short lines with an inline timestamp
+// before every word, so that each word is highlighted as it is spoken. Events
+// are plain cues.
+func renderKaraoke(t *Transcript, _ renderOptions) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n" + karaokeStyle)
+	for _, s := range t.Segments {
+		if s.Kind != "" {
+			fmt.Fprintf(&b, "%s --> %s\n%s\n\n", formatTimestamp(s.Start, "."), formatTimestamp(s.End, "."), vttEscaper.Replace(s.Text))
+			continue
+		}
+		for _, line := range karaokeLines(s) {
+			first, last := line[0], line[len(line)-1]
+			fmt.Fprintf(&b, "%s --> %s\n", formatTimestamp(first.Start, "."), formatTimestamp(max(last.End, first.Start), "."))
+			if s.Speaker != "" {
+				fmt.Fprintf(&b, "<v %s>", vttEscaper.Replace(s.speakerLabel()))
+			}
+			at := first.Start
+			for i, w := range line {
+				if i > 0 {
+					// Inline timestamps must increase within the cue
+					at = min(max(w.Start, at), last.End)
+					fmt.Fprintf(&b, " <%s>", formatTimestamp(at, "."))
+				}
+				b.WriteString(vttEscaper.Replace(w.Text))
+			}
+			b.WriteString("\n\n")
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// karaokeLines splits the words of a turn into caption lines.
+func karaokeLines(s Segment) [][]Word {
+	var lines [][]Word
+	var line []Word
+	chars := 0
+	for _, w := range segmentWords(s) {
+		if n := len(line); n > 0 && (n == karaokeWords || chars+1+len([]rune(w.Text)) > karaokeChars || w.Start-line[n-1].End >= karaokePause) {
+			lines = append(lines, line)
+			line, chars = nil, 0
+		}
+		if len(line) > 0 {
+			chars++
+		}
+		line = append(line, w)
+		chars += len([]rune(w.Text))
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// segmentWords returns the words of a turn with their timing. Word lists that
+// match the turn text word for word are shown with its punctuation and
+// casing, which Whisper's words lack. Without word timings the turn's
+// duration is spread evenly over its words.
+func segmentWords(s Segment) []Word {
+	fields := strings.Fields(s.Text)
+	if len(s.Words) == 0 {
+		words := make([]Word, len(fields))
+		step := (s.End - s.Start) / float64(max(len(fields), 1))
+		for i, f := range fields {
+			words[i] = Word{Text: f, Start: s.Start + step*float64(i), End: s.Start + step*float64(i+1)}
+		}
+		return words
+	}
+	words := make([]Word, 0, len(s.Words))
+	for i, w := range s.Words {
+		if len(fields) == len(s.Words) {
+			w.Text = fields[i]
+		}
+		if w.Text = strings.TrimSpace(w.Text); w.Text != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
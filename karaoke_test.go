@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSegmentWords(t *testing.T) {
+	tests := []struct {
+		name string
+		s    Segment
+		want []Word
+	}{
+		{"spread evenly", Segment{Start: 10, End: 13, Text: "One two three"}, []Word{
+			{Text: "One", Start: 10, End: 11}, {Text: "two", Start: 11, End: 12}, {Text: "three", Start: 12, End: 13},
+		}},
+		{"punctuation from the text", Segment{Text: "Hi, Bob!", Words: []Word{{Text: " hi", Start: 1, End: 2}, {Text: " bob", Start: 2, End: 3}}}, []Word{
+			{Text: "Hi,", Start: 1, End: 2}, {Text: "Bob!", Start: 2, End: 3},
+		}},
+		{"words kept when the text differs", Segment{Text: "Hi Bob.", Words: []Word{{Text: " hi", Start: 1, End: 2}, {Text: " ", Start: 2, End: 2}, {Text: " there", Start: 2, End: 3}}}, []Word{
+			{Text: "hi", Start: 1, End: 2}, {Text: "there", Start: 2, End: 3},
+		}},
+		{"empty", Segment{Start: 1, End: 2}, []Word{}},
+	}
+	for _, tt := range tests {
+		if got := segmentWords(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: segmentWords = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKaraokeLines(t *testing.T) {
+	timed := func(texts string, pauseAfter int) Segment {
+		var s Segment
+		at := 0.0
+		for i, w := range strings.Fields(texts) {
+			s.Words = append(s.Words, Word{Text: w, Start: at, End: at + 0.3})
+			at += 0.3
+			if i == pauseAfter {
+				at += karaokePause
+			}
+		}
+		s.Text = texts
+		return s
+	}
+	tests := []struct {
+		name string
+		s    Segment
+		want []int
+	}{
+		{"one line", timed("a short line", -1), []int{3}},
+		{"word limit", timed("one two three four five six seven eight nine", -1), []int{7, 2}},
+		{"character limit", timed("extraordinarily uncharacteristically straightforward", -1), []int{2, 1}},
+		{"pause", timed("before the pause after it", 2), []int{3, 2}},
+	}
+	for _, tt := range tests {
+		var got []int
+		for _, line := range karaokeLines(tt.s) {
+			got = append(got, len(line))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: karaokeLines sizes = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderKaraoke(t *testing.T) {
+	tr := &Transcript{Segments: []Segment{
+		{Speaker: "A&B", Review: true, Start: 1, End: 2, Text: "Hi there.", Words: []Word{{Text: " hi", Start: 1, End: 1.5}, {Text: " there", Start: 0.9, End: 2}}},
+		{Kind: eventKind, Start: 2, End: 5, Text: "[music]"},
+	}}
+	data, err := renderKaraoke(tr, renderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "00:00:01.000 --> 00:00:02.000\n<v A&amp;B(?)>Hi <00:00:01.000>there.\n\n00:00:02.000 --> 00:00:05.000\n[music]\n\n"
+	if got := string(data); !strings.HasPrefix(got, "WEBVTT\n\n"+karaokeStyle) || !strings.HasSuffix(got, want) {
+		t.Errorf("renderKaraoke =\n%s\nwant cues\n%s", got, want)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// languageBatchTokens caps the transcript tokens of one language tagging
+// request, whose reply is just a code per turn.
+const languageBatchTokens = 6000
+
+// languageTurnWords is how much of each turn is shown for tagging its
+// language; a sentence or two is plenty.
+const languageTurnWords = 40
+
+var languageCode = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// parseLanguageCodes reads the comma-separated BCP-47 codes of the flag name,
+// such as en,es or en,pt-br.
+func parseLanguageCodes(s, name string) ([]string, error) {
+	var codes []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if !languageCode.MatchString(c) {
+			return nil, fmt.Errorf("invalid language code %q in -%s (e.g. en or pt-br)", c, name)
+		}
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+// parseLanguages reads -languages, which names at least two languages.
+func parseLanguages(s string) ([]string, error) {
+	codes, err := parseLanguageCodes(s, "languages")
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) < 2 {
+		return nil, fmt.Errorf("-languages needs at least two languages, e.g. en,es")
+	}
+	return codes, nil
+}
+
+// parseTranslationRoutes reads -translate: comma-separated FROM=TO language
+// pairs such as es=en,en=es, each a translation pass for the turns in FROM.
+func parseTranslationRoutes(s string) (map[string]string, error) {
+	routes := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		from, to = strings.ToLower(strings.TrimSpace(from)), strings.ToLower(strings.TrimSpace(to))
+		if !ok || !languageCode.MatchString(from) || !languageCode.MatchString(to) {
+			return nil, fmt.Errorf("invalid -translate route %q (want FROM=TO, e.g. es=en)", pair)
+		}
+		if from == to {
+			return nil, fmt.Errorf("-translate route %q translates a language into itself", pair)
+		}
+		if _, dup := routes[from]; dup {
+			return nil, fmt.Errorf("-translate has more than one route for %s", from)
+		}
+		routes[from] = to
+	}
+	return routes, nil
+}
+
+// segmentLanguage returns the language of a turn: its own tag, or else the
+// transcript's.
+func (t *Transcript) segmentLanguage(s Segment) string {
+	if s.Language != "" {
+		return s.Language
+	}
+	return strings.ToLower(t.Language)
+}
+
+// sameLanguage reports whether two language codes name the same language,
+// ignoring the region when only one of them has one: "en" matches "en-us",
+// but "pt-br" doesn't match "pt-pt".
+func sameLanguage(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+	pa, _, regionA := strings.Cut(a, "-")
+	pb, _, regionB := strings.Cut(b, "-")
+	return pa == pb && !(regionA && regionB)
+}
+
+// turnExcerpt is the text of a turn cut to words words, or all of it when
+// words is 0.
+func turnExcerpt(s Segment, words int) string {
+	if words == 0 {
+		return strings.TrimSpace(s.Text)
+	}
+	return truncateWords(s.Text, words)
+}
+
+// languageBatches groups the indexes of the speech turns of t that include
+// accepts into requests of at most budget estimated tokens of turnExcerpt.
+func languageBatches(t *Transcript, budget, words int, include func(Segment) bool) [][]int {
+	var batches [][]int
+	var cur []int
+	tokens := 0
+	for i, s := range t.Segments {
+		if s.Kind == eventKind || strings.TrimSpace(s.Text) == "" || !include(s) {
+			continue
+		}
+		n := estimateTokens(turnExcerpt(s, words))
+		if len(cur) > 0 && tokens+n > budget {
+			batches = append(batches, cur)
+			cur, tokens = nil, 0
+		}
+		cur = append(cur, i)
+		tokens += n
+	}
+	if len(cur) > 0 {
+		batches = append(batches, cur)
+	}
+	return batches
+}
+
+// numberedTurns lists the turns of a batch as "index: text" lines.
+func numberedTurns(t *Transcript, batch []int, words int) string {
+	lines := make([]string, len(batch))
+	for i, idx := range batch {
+		lines[i] = fmt.Sprintf("%d: %s", idx, turnExcerpt(t.Segments[idx], words))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// languagePrompt asks the model for the language of each numbered turn.
+const languagePrompt = `The podcast turns below are numbered. The show is in these languages: %s. Give the language each turn is spoken in, as one of those codes. A turn in one language with a borrowed word or name from another is still in the first language.
+
+%s
+
+Respond with a JSON object whose "turns" array has one entry per numbered turn.`
+
+// tagLanguages asks the chat model which of languages each turn of t is
+// spoken in. The most common one becomes the transcript's language, and
+// turns in any other language are tagged with theirs.
+func (p *Pipeline) tagLanguages(ctx context.Context, apiKey string, t *Transcript, languages []string) (TokenUsage, error) {
+	format := map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":   "turn_languages",
+			"strict": true,
+			"schema": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"turns": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"index":    map[string]any{"type": "integer"},
+								"language": map[string]any{"type": "string", "enum": languages},
+							},
+							"required":             []string{"index", "language"},
+							"additionalProperties": false,
+						},
+					},
+				},
+				"required":             []string{"turns"},
+				"additionalProperties": false,
+			},
+		},
+	}
+
+	var usage TokenUsage
+	tags := map[int]string{}
+	all := func(Segment) bool { return true }
+	for _, batch := range languageBatches(t, languageBatchTokens, languageTurnWords, all) {
+		payload := map[string]interface{}{
+			"model":           p.config.DiarizationModel,
+			"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(languagePrompt, strings.Join(languages, ", "), numberedTurns(t, batch, languageTurnWords))}},
+			"temperature":     p.config.Temperature,
+			"response_format": format,
+		}
+		content, u, err := p.chatCompletion(ctx, apiKey, payload)
+		usage.Add(u)
+		if err != nil {
+			return usage, fmt.Errorf("failed to tag languages: %v", err)
+		}
+		var res struct {
+			Turns []struct {
+				Index    int    `json:"index"`
+				Language string `json:"language"`
+			} `json:"turns"`
+		}
+		if err := json.Unmarshal([]byte(content), &res); err != nil {
+			return usage, fmt.Errorf("failed to decode turn languages: %v", err)
+		}
+		for _, r := range res.Turns {
+			tags[r.Index] = r.Language
+		}
+	}
+
+	count := map[string]int{}
+	for _, lang := range tags {
+		count[lang]++
+	}
+	primary := languages[0]
+	for _, lang := range languages {
+		if count[lang] > count[primary] {
+			primary = lang
+		}
+	}
+	t.Language = primary
+	for i := range t.Segments {
+		if lang := tags[i]; lang != primary {
+			t.Segments[i].Language = lang
+		} else {
+			t.Segments[i].Language = ""
+		}
+	}
+	return usage, nil
+}
+
+// translatePrompt asks the model to translate each numbered turn; the source
+// is a language, or for -translate-to "the language it is in".
+const translatePrompt = `Translate each numbered podcast turn below from %s into %s. Keep the speaker's tone and register, keep names as they are, and translate every turn on its own without merging or dropping any.
+
+%s
+
+Respond with a JSON object whose "turns" array has one entry per numbered turn.`
+
+// translationFormat is the JSON schema of a translation reply.
+var translationFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "turn_translations",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"turns": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"index": map[string]any{"type": "integer"},
+							"text":  map[string]any{"type": "string"},
+						},
+						"required":             []string{"index", "text"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"turns"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// translationPass translates the turns in from into to. A pass without from,
+// from -translate-to, translates every turn not already in to.
+type translationPass struct {
+	from, to string
+}
+
+// translationPasses combines the routes of -translate with the targets of
+// -translate-to. A target of -translate-to covers every route into it.
+func translationPasses(routes map[string]string, targets []string) []translationPass {
+	var passes []translationPass
+	whole := map[string]bool{}
+	for _, to := range targets {
+		whole[to] = true
+		passes = append(passes, translationPass{to: to})
+	}
+	froms := make([]string, 0, len(routes))
+	for from := range routes {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+	for _, from := range froms {
+		if !whole[routes[from]] {
+			passes = append(passes, translationPass{from: from, to: routes[from]})
+		}
+	}
+	return passes
+}
+
+// translateTurns runs the translation passes with the summary model
+// concurrently and records the results in the turns' Translations. Terms of
+// the translation glossary are passed along to be translated consistently,
+// and the translations that leave them out are returned as misses. All passes
+// are attempted even if one fails.
+func (p *Pipeline) translateTurns(ctx context.Context, apiKey string, t *Transcript, passes []translationPass, terms []translationTerm) (TokenUsage, []translationMiss, error) {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		usage TokenUsage
+		errs  []string
+	)
+	results := make([]map[int]string, len(passes))
+	for i, pass := range passes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			texts, u, err := p.translatePass(ctx, apiKey, t, pass, terms)
+			mu.Lock()
+			defer mu.Unlock()
+			usage.Add(u)
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			results[i] = texts
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return usage, nil, fmt.Errorf("failed to translate into %s", strings.Join(errs, "; "))
+	}
+	var misses []translationMiss
+	for i, pass := range passes {
+		misses = append(misses, glossaryMisses(terms, t, results[i], pass.to)...)
+		for idx, text := range results[i] {
+			s := &t.Segments[idx]
+			if s.Translations == nil {
+				s.Translations = map[string]string{}
+			}
+			s.Translations[pass.to] = text
+		}
+		source := pass.from
+		if source == "" {
+			source = "other languages"
+		}
+		p.console.progressf("Translated %d turn(s) from %s into %s\n", len(results[i]), source, pass.to)
+	}
+	return usage, misses, nil
+}
+
+// translatePass translates the turns of t that pass covers, in requests that
+// fit the summary model's output, and returns the translations by turn index.
+func (p *Pipeline) translatePass(ctx context.Context, apiKey string, t *Transcript, pass translationPass, terms []translationTerm) (map[int]string, TokenUsage, error) {
+	include := func(s Segment) bool { return sameLanguage(t.segmentLanguage(s), pass.from) }
+	source := pass.from
+	if pass.from == "" {
+		include = func(s Segment) bool { return !sameLanguage(t.segmentLanguage(s), pass.to) }
+		source = "the language it is in"
+	}
+	var usage TokenUsage
+	texts := map[int]string{}
+	for _, batch := range languageBatches(t, p.diarizationBudget(p.config.SummaryModel), 0, include) {
+		prompt := fmt.Sprintf(translatePrompt, source, pass.to, numberedTurns(t, batch, 0)+glossaryHints(terms, t, batch, pass.to))
+		payload := map[string]interface{}{
+			"model":           p.config.SummaryModel,
+			"messages":        []map[string]string{{"role": "user", "content": prompt}},
+			"temperature":     p.config.Temperature,
+			"response_format": translationFormat,
+		}
+		content, u, err := p.chatCompletion(ctx, apiKey, payload)
+		usage.Add(u)
+		if err != nil {
+			return nil, usage, fmt.Errorf("%s: %v", pass.to, err)
+		}
+		var res struct {
+			Turns []struct {
+				Index int    `json:"index"`
+				Text  string `json:"text"`
+			} `json:"turns"`
+		}
+		if err := json.Unmarshal([]byte(content), &res); err != nil {
+			return nil, usage, fmt.Errorf("%s: failed to decode the reply: %v", pass.to, err)
+		}
+		inBatch := map[int]bool{}
+		for _, idx := range batch {
+			inBatch[idx] = true
+		}
+		for _, r := range res.Turns {
+			if inBatch[r.Index] && strings.TrimSpace(r.Text) != "" {
+				texts[r.Index] = strings.TrimSpace(r.Text)
+			}
+		}
+	}
+	return texts, usage, nil
+}
+
+// translated returns a copy of t in the language lang: turns with a
+// translation into lang read as the translation, and the rest are kept as
+// they are, tagged with their language.
+func (t *Transcript) translated(lang string) *Transcript {
+	c := *t
+	c.Segments = make([]Segment, len(t.Segments))
+	for i, s := range t.Segments {
+		orig := t.segmentLanguage(s)
+		if text, ok := s.Translations[lang]; ok {
+			s.Text, orig = text, lang
+			s.Words = nil
+		}
+		s.Translations = nil
+		s.Language = ""
+		if !sameLanguage(orig, lang) && s.Kind == "" {
+			s.Language = orig
+		}
+		c.Segments[i] = s
+	}
+	c.Language = lang
+	c.Text = joinSegmentText(c.Segments)
+	return &c
+}
+
+// exportTranslations writes the formats of t in every target language of
+// passes that turns were translated into, as diarized.<lang>.<ext>, and
+// returns the paths written.
+func (p *Pipeline) exportTranslations(t *Transcript, passes []translationPass, formats []string) ([]string, error) {
+	seen := map[string]bool{}
+	var paths []string
+	for _, pass := range passes {
+		if seen[pass.to] || !t.hasTranslation(pass.to) {
+			continue
+		}
+		seen[pass.to] = true
+		written, err := p.exportAll(t.translated(pass.to), formats, pass.to)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, written...)
+	}
+	return paths, nil
+}
+
+// hasTranslation reports whether any turn of t was translated into lang.
+func (t *Transcript) hasTranslation(lang string) bool {
+	for _, s := range t.Segments {
+		if _, ok := s.Translations[lang]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// defaultRealtimeURL is the OpenAI Realtime API endpoint for transcription-only
+// sessions.
+const defaultRealtimeURL = "wss://api.openai.com/v1/realtime?intent=transcription"
+
+// The Realtime API takes 16-bit little-endian mono PCM at 24kHz, sent here in
+// frames of liveFrame.
+const (
+	liveSampleRate = 24000
+	liveFrame      = 100 * time.Millisecond
+)
+
+// liveDrainTimeout is how long to wait for the last turns to be transcribed
+// once the audio ends or the run is interrupted.
+const liveDrainTimeout = 15 * time.Second
+
+// liveEvent is the part of a Realtime server event the live command reads.
+type liveEvent struct {
+	Type         string `json:"type"`
+	ItemID       string `json:"item_id"`
+	Delta        string `json:"delta"`
+	Transcript   string `json:"transcript"`
+	AudioStartMS int    `json:"audio_start_ms"`
+	AudioEndMS   int    `json:"audio_end_ms"`
+	Error        *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// liveTurn is a stretch of speech found by the server's voice activity
+// detection, transcribed once the speaker pauses.
+type liveTurn struct {
+	start, end float64
+	text       string
+	done       bool
+	// streamed is set once the turn's deltas have been printed as they came.
+	streamed bool
+}
+
+// liveSession tracks the turns of a Realtime transcription session and prints
+// them in order as they complete.
+type liveSession struct {
+	p      *Pipeline
+	out    io.Writer
+	record io.Writer
+	// stream prints deltas of the oldest pending turn as they arrive, for
+	// terminals.
+	stream bool
+
+	turns map[string]*liveTurn
+	order []string
+	// awaitingCommit is set between committing the final audio and the server
+	// acknowledging it or reporting the buffer empty.
+	awaitingCommit bool
+	segments       []Segment
+}
+
+// runLive implements the live command.
+func runLive(args []string) error {
+	cfg := defaultConfig()
+	cfg.TranscriptionModel = "gpt-4o-transcribe"
+	flags := flag.NewFlagSet("live", flag.ExitOnError)
+	audio := flags.String("audio", "", "Audio to transcribe as it plays: a file, a URL such as a livestream, - for stdin, or a capture device with -input-format")
+	inputFormat := flags.String("input-format", "", "ffmpeg input format of -audio, e.g. pulse, alsa or avfoundation for a microphone")
+	flags.StringVar(&cfg.TranscriptionModel, "model", cfg.TranscriptionModel, "Realtime transcription model: gpt-4o-transcribe, gpt-4o-mini-transcribe or whisper-1")
+	flags.StringVar(&cfg.Language, "language", "", "Spoken language as an ISO-639-1 code such as en (default: detect)")
+	vocabulary := flags.String("vocabulary", "", "Comma-separated names and terms to prompt the model with")
+	output := flags.String("output", "", "Append each finished turn to this text file as it arrives")
+	jsonOut := flags.String("json", "", "Write the canonical transcript JSON here when the session ends")
+	silence := flags.Duration("silence", 500*time.Millisecond, "Pause that ends a turn")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription live -audio input [-input-format fmt] [-model m] [-output file.txt] [-json file.json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *audio == "" {
+		return fmt.Errorf("-audio is required")
+	}
+	cfg.Vocabulary = splitList(*vocabulary)
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("please set the OPENAI_API_KEY environment variable")
+	}
+
+	// The first Ctrl-C stops the audio and waits for the last turns; a second
+	// one exits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	p := newPipeline(&cfg, nil)
+	s := &liveSession{p: p, out: os.Stdout, stream: isTerminal(os.Stdout), turns: map[string]*liveTurn{}}
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open output file: %v", err)
+		}
+		defer f.Close()
+		s.record = f
+	}
+
+	// ffmpeg decodes whatever the input is and reads files no faster than they
+	// play, as a livestream would arrive
+	ffArgs := []string{"-hide_banner", "-loglevel", "error"}
+	if *inputFormat != "" {
+		ffArgs = append(ffArgs, "-f", *inputFormat)
+	} else if *audio != "-" && !isRemoteAudio(*audio) {
+		ffArgs = append(ffArgs, "-re")
+	}
+	input := *audio
+	if input == "-" {
+		input = "pipe:0"
+	}
+	ffArgs = append(ffArgs, "-i", input, "-ac", "1", "-ar", fmt.Sprint(liveSampleRate), "-f", "s16le", "pipe:1")
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	pcm, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	conn, err := p.dialRealtime(context.Background(), apiKey)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	if err := s.configure(conn, *silence); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+	fmt.Fprintln(stderr, "Listening; press Ctrl-C to stop")
+	err = s.run(conn, pcm)
+	if werr := cmd.Wait(); werr != nil && ctx.Err() == nil && err == nil {
+		err = fmt.Errorf("ffmpeg failed: %v", werr)
+	}
+	if *jsonOut != "" && len(s.segments) > 0 {
+		t := &Transcript{Version: transcriptVersion, Language: cfg.Language, Segments: s.segments}
+		texts := make([]string, len(s.segments))
+		for i, seg := range s.segments {
+			texts[i] = seg.Text
+		}
+		t.Text = strings.Join(texts, " ")
+		t.Duration = s.segments[len(s.segments)-1].End
+		if serr := p.saveTranscript(*jsonOut, t); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// dialRealtime opens a transcription session with the Realtime API.
+func (p *Pipeline) dialRealtime(ctx context.Context, apiKey string) (*wsConn, error) {
+	req, err := http.NewRequest("GET", p.config.RealtimeURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	p.setOpenAIHeaders(req, apiKey)
+	req.Header.Set("OpenAI-Beta", "realtime=v1")
+	conn, err := dialWebSocket(ctx, p.client, req, p.config.MaxResponseBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open realtime session: %v", err)
+	}
+	return conn, nil
+}
+
+// configure sets the session's audio format, model and turn detection.
+func (s *liveSession) configure(conn *wsConn, silence time.Duration) error {
+	transcription := map[string]any{"model": s.p.config.TranscriptionModel}
+	if s.p.config.Language != "" {
+		transcription["language"] = s.p.config.Language
+	}
+	if len(s.p.config.Vocabulary) > 0 {
+		transcription["prompt"] = whisperPrompt(s.p.config.Vocabulary)
+	}
+	return s.send(conn, map[string]any{
+		"type": "transcription_session.update",
+		"session": map[string]any{
+			"input_audio_format":        "pcm16",
+			"input_audio_transcription": transcription,
+			"turn_detection": map[string]any{
+				"type":                "server_vad",
+				"silence_duration_ms": silence.Milliseconds(),
+			},
+		},
+	})
+}
+
+func (s *liveSession) send(conn *wsConn, event map[string]any) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := conn.writeText(data); err != nil {
+		return fmt.Errorf("failed to send %s: %v", event["type"], err)
+	}
+	return nil
+}
+
+// run streams pcm to the session while printing the transcript events it gets
+// back, until the audio ends and the last turn is transcribed.
+func (s *liveSession) run(conn *wsConn, pcm io.Reader) error {
+	sent := make(chan error, 1)
+	go func() {
+		sent <- s.sendAudio(conn, pcm)
+	}()
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			msg, err := conn.readMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var drain <-chan time.Time
+	sending := true
+	for {
+		if !sending && len(s.order) == 0 && !s.awaitingCommit {
+			return nil
+		}
+		select {
+		case err := <-sent:
+			if err != nil {
+				return err
+			}
+			sending = false
+			s.awaitingCommit = true
+			drain = time.After(liveDrainTimeout)
+		case msg := <-messages:
+			if err := s.handle(msg); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			if isClosedConn(err) {
+				err = fmt.Errorf("realtime session closed by the server")
+			}
+			return err
+		case <-drain:
+			if n := len(s.order); n > 0 {
+				s.p.console.warnf("%d turn(s) weren't transcribed before the session ended\n", n)
+			}
+			return nil
+		}
+	}
+}
+
+// sendAudio appends pcm to the session's input buffer frame by frame, then
+// commits whatever the voice activity detection hasn't yet.
+func (s *liveSession) sendAudio(conn *wsConn, pcm io.Reader) error {
+	frame := make([]byte, int(liveSampleRate*liveFrame.Seconds())*2)
+	for {
+		n, err := io.ReadFull(pcm, frame)
+		if n > 0 {
+			if serr := s.send(conn, map[string]any{
+				"type":  "input_audio_buffer.append",
+				"audio": base64.StdEncoding.EncodeToString(frame[:n]),
+			}); serr != nil {
+				return serr
+			}
+		}
+		if err != nil {
+			// The audio ended, or ffmpeg was stopped by Ctrl-C
+			return s.send(conn, map[string]any{"type": "input_audio_buffer.commit"})
+		}
+	}
+}
+
+// handle applies one server event.
+func (s *liveSession) handle(msg []byte) error {
+	var ev liveEvent
+	if err := json.Unmarshal(msg, &ev); err != nil {
+		return fmt.Errorf("failed to decode realtime event: %v", err)
+	}
+	turn := s.turns[ev.ItemID]
+	switch ev.Type {
+	case "input_audio_buffer.speech_started":
+		s.turns[ev.ItemID] = &liveTurn{start: float64(ev.AudioStartMS) / 1000}
+	case "input_audio_buffer.speech_stopped":
+		if turn != nil {
+			turn.end = float64(ev.AudioEndMS) / 1000
+		}
+	case "input_audio_buffer.committed":
+		if turn == nil {
+			// Committed by hand at the end, without a detected start
+			turn = &liveTurn{}
+			if len(s.segments) > 0 {
+				turn.start = s.segments[len(s.segments)-1].End
+			}
+			s.turns[ev.ItemID] = turn
+		}
+		s.order = append(s.order, ev.ItemID)
+		s.awaitingCommit = false
+	case "conversation.item.input_audio_transcription.delta":
+		if turn != nil && s.stream && len(s.order) > 0 && s.order[0] == ev.ItemID {
+			if !turn.streamed {
+				fmt.Fprintf(s.out, "[%s] ", formatTimestamp(turn.start, ".")[:8])
+				turn.streamed = true
+			}
+			fmt.Fprint(s.out, ev.Delta)
+		}
+	case "conversation.item.input_audio_transcription.completed":
+		if turn != nil {
+			turn.text = strings.TrimSpace(ev.Transcript)
+			turn.done = true
+			return s.flush()
+		}
+	case "conversation.item.input_audio_transcription.failed":
+		if turn != nil {
+			turn.done = true
+			if ev.Error != nil {
+				s.p.console.warnf("turn at %s wasn't transcribed: %s\n", formatTimestamp(turn.start, ".")[:8], ev.Error.Message)
+			}
+			return s.flush()
+		}
+	case "error":
+		if ev.Error == nil {
+			break
+		}
+		if s.awaitingCommit && ev.Error.Code == "input_audio_buffer_commit_empty" {
+			// Nothing was left over after the last detected turn
+			s.awaitingCommit = false
+			break
+		}
+		s.p.console.warnf("realtime API: %s\n", ev.Error.Message)
+	}
+	return nil
+}
+
+// flush prints and records the finished turns at the front of the queue.
+func (s *liveSession) flush() error {
+	for len(s.order) > 0 {
+		id := s.order[0]
+		turn := s.turns[id]
+		if !turn.done {
+			return nil
+		}
+		s.order = s.order[1:]
+		delete(s.turns, id)
+		if turn.end < turn.start {
+			turn.end = turn.start
+		}
+		line := fmt.Sprintf("[%s] %s", formatTimestamp(turn.start, ".")[:8], turn.text)
+		if turn.streamed {
+			fmt.Fprintln(s.out)
+		} else if turn.text != "" {
+			fmt.Fprintln(s.out, line)
+		}
+		if turn.text == "" {
+			continue
+		}
+		s.segments = append(s.segments, Segment{ID: len(s.segments), Start: turn.start, End: turn.end, Text: turn.text})
+		if s.record != nil {
+			if _, err := fmt.Fprintln(s.record, line); err != nil {
+				return fmt.Errorf("failed to write output file: %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultLocalCommand runs whisperX with pyannote diarization. The placeholders
+// are substituted per argument, so paths with spaces need no quoting.
+const defaultLocalCommand = "whisperx {audio} --model {model} --diarize --min_speakers {speakers} --max_speakers {speakers} --device {device} --compute_type {compute_type} --batch_size {batch_size} --threads {threads} --output_format json --output_dir {output_dir}"
+
+// whisperXResult is whisperX's JSON output, which the local backend also accepts
+// from any other pipeline (e.g. Vosk + pyannote) that writes the same shape.
+type whisperXResult struct {
+	Language string `json:"language"`
+	Segments []struct {
+		Start   float64 `json:"start"`
+		End     float64 `json:"end"`
+		Text    string  `json:"text"`
+		Speaker string  `json:"speaker"`
+		Words   []struct {
+			Word  string  `json:"word"`
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+			Score float64 `json:"score"`
+			// Speaker is the word's own speaker, which can disagree with the segment's
+			Speaker string `json:"speaker"`
+		} `json:"words"`
+	} `json:"segments"`
+}
+
+// transcribeLocal runs the local pipeline, either over HTTP when -local-url is set
+// or as a subprocess, and ingests its speaker-attributed JSON. No audio leaves the
+// machine. When the machine has room for more than one subprocess at a time,
+// long audio is split between them.
+func (p *Pipeline) transcribeLocal(ctx context.Context, _, audioPath string) (*Transcript, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if p.config.LocalURL != "" {
+		data, err = p.runLocalHTTP(ctx, audioPath)
+	} else {
+		streams := p.localStreams(ctx)
+		duration, probeErr := probeDuration(audioPath)
+		if len(streams) > 1 && probeErr == nil && duration >= 2*minLocalPart {
+			p.console.progressf("Local pipeline: %s\n", describeStreams(streams))
+			res, err := p.transcribeLocalParallel(ctx, audioPath, duration, streams)
+			if err != nil {
+				return nil, err
+			}
+			return res.transcript(filepath.Base(audioPath)), nil
+		}
+		p.console.progressf("Local pipeline: %s\n", describeStreams(streams[:1]))
+		data, err = p.runLocalCommand(ctx, audioPath, streams[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res whisperXResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse local pipeline output: %v", err)
+	}
+	return res.transcript(filepath.Base(audioPath)), nil
+}
+
+// runLocalCommand runs config.LocalCommand on the stream's device in a scratch
+// output directory and returns the JSON file it wrote, or its stdout if it
+// wrote none.
+func (p *Pipeline) runLocalCommand(ctx context.Context, audioPath string, stream localStream) ([]byte, error) {
+	outDir, err := p.makeTempDir("local")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+
+	replacer := strings.NewReplacer(
+		"{audio}", audioPath,
+		"{output_dir}", outDir,
+		"{model}", p.config.TranscriptionModel,
+		"{speakers}", strconv.Itoa(p.config.Speakers),
+		"{language}", p.config.Language,
+		"{device}", stream.device(),
+		"{compute_type}", stream.computeType,
+		"{batch_size}", strconv.Itoa(stream.batchSize),
+		"{threads}", strconv.Itoa(stream.threads),
+	)
+	args := strings.Fields(p.config.LocalCommand)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("-local-command is empty")
+	}
+	for i, a := range args {
+		args[i] = replacer.Replace(a)
+	}
+
+	stdout := p.newCappedBuffer()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if stream.gpu >= 0 {
+		// Each stream sees only its own GPU
+		cmd.Env = append(os.Environ(), "CUDA_VISIBLE_DEVICES="+strconv.Itoa(stream.gpu))
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("local pipeline %s failed: %v", args[0], err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(outDir, "*.json"))
+	if len(matches) == 0 {
+		return stdout.Bytes(), nil
+	}
+	data, err := p.readFileCapped(matches[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local pipeline output: %v", err)
+	}
+	return data, nil
+}
+
+// runLocalHTTP posts the audio to a local transcription server as multipart form
+// data and returns its JSON reply.
+func (p *Pipeline) runLocalHTTP(ctx context.Context, audioPath string) ([]byte, error) {
+	req, err := newMultipartRequest(ctx, p.config.LocalURL, "file", audioPath, filepath.Base(audioPath), []formField{
+		{"model", p.config.TranscriptionModel},
+		{"speakers", strconv.Itoa(p.config.Speakers)},
+		{"language", p.config.Language},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response from local pipeline: %d, body: %s", resp.StatusCode, string(data))
+	}
+	return data, nil
+}
+
+// transcript converts whisperX output into the canonical model.
+func (res *whisperXResult) transcript(audio string) *Transcript {
+	t := &Transcript{Audio: audio, Language: res.Language}
+	name := speakerNamer()
+	var texts []string
+	var segments []Segment
+	for _, s := range res.Segments {
+		text := strings.TrimSpace(s.Text)
+		texts = append(texts, text)
+		seg := Segment{Start: s.Start, End: s.End, Speaker: name(s.Speaker), Text: text}
+		labelled, agreeing := 0, 0
+		for _, w := range s.Words {
+			seg.Words = append(seg.Words, Word{Text: w.Word, Start: w.Start, End: w.End, Confidence: w.Score})
+			if w.Speaker != "" {
+				labelled++
+				if w.Speaker == s.Speaker {
+					agreeing++
+				}
+			}
+		}
+		if labelled > 0 {
+			// The share of words whose own speaker agrees with the segment's
+			seg.SpeakerConfidence = float64(agreeing) / float64(labelled)
+		}
+		segments = append(segments, seg)
+		t.Duration = max(t.Duration, s.End)
+	}
+	t.Text = strings.Join(texts, " ")
+	t.Segments = mergeSpeakerTurns(segments)
+	return t
+}
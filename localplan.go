@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// minLocalPart is the shortest part the local backend splits audio into
+	// for parallel streams; shorter parts load the model more often than
+	// they save.
+	minLocalPart = 5 * 60
+	// localOverlap is how far each part reaches back into the previous one.
+	// Both diarize the overlap, which is how their speakers are matched up.
+	localOverlap = 30.0
+	// cpuStreamThreads is the fewest threads a CPU stream is given.
+	cpuStreamThreads = 8
+	// maxGPUStreams is the most streams run on one GPU.
+	maxGPUStreams = 4
+)
+
+// gpuDevice is an NVIDIA GPU as reported by nvidia-smi. Memory is in MiB, 0
+// when unknown.
+type gpuDevice struct {
+	index      int
+	name       string
+	freeMemory int
+}
+
+// localStream is one instance of the local pipeline run at a time, with the
+// device and settings substituted into -local-command.
+type localStream struct {
+	gpu         int // -1 for the CPU
+	name        string
+	threads     int
+	batchSize   int
+	computeType string
+}
+
+// device is the {device} placeholder of the stream: cuda or cpu.
+func (s localStream) device() string {
+	if s.gpu < 0 {
+		return "cpu"
+	}
+	return "cuda"
+}
+
+// parseGPUs reads -gpu: auto, none, or comma-separated device indexes. It
+// returns the indexes, nil for auto, or an empty list for none.
+func parseGPUs(s string) ([]int, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "auto":
+		return nil, nil
+	case "none", "cpu":
+		return []int{}, nil
+	}
+	indexes := []int{}
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid -gpu %q (want auto, none, or device indexes such as 0,1)", s)
+		}
+		indexes = append(indexes, n)
+	}
+	return indexes, nil
+}
+
+// detectGPUs lists the NVIDIA GPUs with nvidia-smi, or none when it isn't
+// installed or finds none.
+func detectGPUs(ctx context.Context) []gpuDevice {
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=index,name,memory.free", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil
+	}
+	var gpus []gpuDevice
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			continue
+		}
+		free, _ := strconv.Atoi(strings.TrimSpace(fields[2]))
+		gpus = append(gpus, gpuDevice{index: index, name: strings.TrimSpace(fields[1]), freeMemory: free})
+	}
+	return gpus
+}
+
+// streamMemory is the GPU memory in MiB one stream of the model needs,
+// transcription and diarization together, at a batch size of 8.
+func streamMemory(model string) int {
+	switch {
+	case strings.HasPrefix(model, "large"):
+		return 8000
+	case strings.HasPrefix(model, "medium"):
+		return 5000
+	default:
+		return 3000
+	}
+}
+
+// planLocalStreams decides how the local pipeline uses the machine: each GPU
+// runs as many streams as its free memory fits, each with the largest batch
+// that fits beside the others, and without GPUs the CPU threads are shared
+// between streams of at least cpuStreamThreads. threads of 0 uses every core.
+func planLocalStreams(gpus []gpuDevice, threads int, model string) []localStream {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	if len(gpus) == 0 {
+		n := max(threads/cpuStreamThreads, 1)
+		streams := make([]localStream, n)
+		for i := range streams {
+			streams[i] = localStream{gpu: -1, name: "CPU", threads: threads / n, batchSize: 4, computeType: "int8"}
+		}
+		return streams
+	}
+	need := streamMemory(model)
+	perGPU := make([][]localStream, len(gpus))
+	for i, g := range gpus {
+		n, batch := 1, 8
+		if g.freeMemory > 0 {
+			n = min(max(g.freeMemory/need, 1), maxGPUStreams)
+			// Memory left over after n streams goes to bigger batches
+			switch share := g.freeMemory / n; {
+			case share >= 2*need:
+				batch = 16
+			case share < need:
+				batch = 4
+			}
+		}
+		for range n {
+			perGPU[i] = append(perGPU[i], localStream{gpu: g.index, name: g.name, batchSize: batch, computeType: "float16"})
+		}
+	}
+	// Taking the GPUs in turn spreads fewer parts than streams over all of them
+	var streams []localStream
+	for round := range maxGPUStreams {
+		for _, gs := range perGPU {
+			if round < len(gs) {
+				streams = append(streams, gs[round])
+			}
+		}
+	}
+	// The CPU threads feed the GPU streams
+	for i := range streams {
+		streams[i].threads = max(threads/len(streams), 1)
+	}
+	return streams
+}
+
+// localStreams returns the streams for this run, from -gpu and -threads.
+func (p *Pipeline) localStreams(ctx context.Context) []localStream {
+	wanted, _ := parseGPUs(p.config.GPUs)
+	var gpus []gpuDevice
+	if wanted == nil || len(wanted) > 0 {
+		detected := detectGPUs(ctx)
+		if wanted == nil {
+			gpus = detected
+		}
+		for _, index := range wanted {
+			g := gpuDevice{index: index, name: fmt.Sprintf("GPU %d", index)}
+			for _, d := range detected {
+				if d.index == index {
+					g = d
+				}
+			}
+			gpus = append(gpus, g)
+		}
+	}
+	return planLocalStreams(gpus, p.config.Threads, p.config.TranscriptionModel)
+}
+
+// describeStreams summarizes a plan for the progress output, e.g.
+// "2 stream(s), 8 thread(s) each: 2 on NVIDIA A100 (batch 16)".
+func describeStreams(streams []localStream) string {
+	type group struct {
+		name  string
+		batch int
+		n     int
+	}
+	var groups []*group
+	for _, s := range streams {
+		found := false
+		for _, g := range groups {
+			if g.name == s.name && g.batch == s.batchSize {
+				g.n++
+				found = true
+			}
+		}
+		if !found {
+			groups = append(groups, &group{s.name, s.batchSize, 1})
+		}
+	}
+	parts := make([]string, len(groups))
+	for i, g := range groups {
+		parts[i] = fmt.Sprintf("%d on %s (batch %d)", g.n, g.name, g.batch)
+	}
+	return fmt.Sprintf("%d stream(s), %d thread(s) each: %s", len(streams), streams[0].threads, strings.Join(parts, ", "))
+}
+
+// localPart is a stretch of the audio transcribed by one stream, and the
+// result once it has been.
+type localPart struct {
+	start, end float64
+	result     *whisperXResult
+}
+
+// transcribeLocalParallel splits audio of duration seconds into one part per
+// stream, with each part overlapping the one before by localOverlap, and runs
+// the local pipeline on the parts at the same time. The speakers of each part
+// are matched to the previous part's by who speaks when in the overlap, and
+// the parts are joined in the middle of it.
+func (p *Pipeline) transcribeLocalParallel(ctx context.Context, audioPath string, duration float64, streams []localStream) (*whisperXResult, error) {
+	n := min(len(streams), int(duration/minLocalPart))
+	length := duration / float64(n)
+	parts := make([]localPart, n)
+	for i := range parts {
+		parts[i] = localPart{start: max(float64(i)*length-localOverlap, 0), end: float64(i+1) * length}
+	}
+	parts[n-1].end = 0
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := p.transcribeLocalPart(ctx, audioPath, parts[i], streams[i])
+			p.stats.chunks.Add(1)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("part %d/%d: %v", i+1, n, err)
+					cancel()
+				}
+				return
+			}
+			parts[i].result = res
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return joinLocalParts(parts), nil
+}
+
+// transcribeLocalPart runs the local pipeline on one part of the audio and
+// moves its times onto the whole file.
+func (p *Pipeline) transcribeLocalPart(ctx context.Context, audioPath string, part localPart, stream localStream) (*whisperXResult, error) {
+	path, cleanup, err := p.cutAudio(ctx, audioPath, part.start, part.end)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	data, err := p.runLocalCommand(ctx, path, stream)
+	if err != nil {
+		return nil, err
+	}
+	var res whisperXResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse local pipeline output: %v", err)
+	}
+	for i := range res.Segments {
+		s := &res.Segments[i]
+		s.Start, s.End = s.Start+part.start, s.End+part.start
+		for j := range s.Words {
+			s.Words[j].Start, s.Words[j].End = s.Words[j].Start+part.start, s.Words[j].End+part.start
+		}
+	}
+	return &res, nil
+}
+
+// joinLocalParts joins the results of overlapping parts into one, relabeling
+// the speakers of each part as those of the previous part they speak at the
+// same times as in the overlap. A speaker with no match, or whose match is
+// taken, gets a label of their own.
+func joinLocalParts(parts []localPart) *whisperXResult {
+	joined := &whisperXResult{Language: parts[0].result.Language}
+	var previous []segmentSpan
+	for i, part := range parts {
+		res := part.result
+		cutAt := 0.0
+		if i > 0 {
+			cutAt = part.start + localOverlap/2
+		}
+		cutNext := -1.0
+		if i+1 < len(parts) {
+			cutNext = parts[i+1].start + localOverlap/2
+		}
+
+		labels := map[string]string{}
+		if i > 0 {
+			labels = matchSpeakers(previous, spans(res, part.start, part.start+localOverlap))
+		}
+		label := func(speaker string) {
+			if _, ok := labels[speaker]; !ok && speaker != "" {
+				labels[speaker] = fmt.Sprintf("%d/%s", i+1, speaker)
+			}
+		}
+		for _, s := range res.Segments {
+			label(s.Speaker)
+			for _, w := range s.Words {
+				label(w.Speaker)
+			}
+		}
+
+		var kept []segmentSpan
+		for _, s := range res.Segments {
+			s.Speaker = labels[s.Speaker]
+			for j := range s.Words {
+				s.Words[j].Speaker = labels[s.Words[j].Speaker]
+			}
+			mid := (s.Start + s.End) / 2
+			if mid >= cutAt && (cutNext < 0 || mid < cutNext) {
+				joined.Segments = append(joined.Segments, s)
+			}
+			kept = append(kept, segmentSpan{s.Start, s.End, s.Speaker})
+		}
+		if i+1 < len(parts) {
+			next := parts[i+1].start
+			previous = nil
+			for _, s := range kept {
+				if s.end > next {
+					previous = append(previous, s)
+				}
+			}
+		}
+	}
+	return joined
+}
+
+// segmentSpan is when a speaker speaks.
+type segmentSpan struct {
+	start, end float64
+	speaker    string
+}
+
+// spans returns the speaker spans of res between from and to.
+func spans(res *whisperXResult, from, to float64) []segmentSpan {
+	var out []segmentSpan
+	for _, s := range res.Segments {
+		if s.End > from && s.Start < to && s.Speaker != "" {
+			out = append(out, segmentSpan{max(s.Start, from), min(s.End, to), s.Speaker})
+		}
+	}
+	return out
+}
+
+// matchSpeakers maps the speakers of the overlap spans of a part onto those of
+// the previous part, pairing the speakers who speak together longest first.
+func matchSpeakers(previous, current []segmentSpan) map[string]string {
+	type pair struct {
+		from, to string
+		seconds  float64
+	}
+	together := map[[2]string]float64{}
+	for _, c := range current {
+		for _, p := range previous {
+			if d := min(c.end, p.end) - max(c.start, p.start); d > 0 && p.speaker != "" {
+				together[[2]string{c.speaker, p.speaker}] += d
+			}
+		}
+	}
+	pairs := make([]pair, 0, len(together))
+	for k, d := range together {
+		pairs = append(pairs, pair{k[0], k[1], d})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].seconds != pairs[j].seconds {
+			return pairs[i].seconds > pairs[j].seconds
+		}
+		return pairs[i].from+pairs[i].to < pairs[j].from+pairs[j].to
+	})
+	labels := map[string]string{}
+	taken := map[string]bool{}
+	for _, pr := range pairs {
+		if _, ok := labels[pr.from]; ok || taken[pr.to] {
+			continue
+		}
+		labels[pr.from] = pr.to
+		taken[pr.to] = true
+	}
+	return labels
+}
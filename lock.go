@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockFileName is the lock file created in the output directory while a run
+// writes there.
+const lockFileName = ".podcast-transcription.lock"
+
+// lockPollInterval is how often a waiting run retries the lock.
+const lockPollInterval = time.Second
+
+// outputLock is an exclusive lock on an output directory.
+type outputLock struct {
+	path string
+	file *os.File
+}
+
+// lockOutputDir takes the lock on dir so that two runs on the same episode can't
+// clobber each other's cache and outputs. If another run holds it, lockOutputDir
+// fails unless wait is set, in which case it waits for the lock to be released.
+// A lock left behind by a run that exited without releasing it doesn't block.
+func (p *Pipeline) lockOutputDir(dir string, wait bool) (*outputLock, error) {
+	if dir == "" {
+		dir = "."
+	}
+	path := filepath.Join(dir, lockFileName)
+	waiting := false
+	for {
+		f, ok, err := tryLockFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if ok {
+			f.Truncate(0)
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return &outputLock{path: path, file: f}, nil
+		}
+		holder := "another run"
+		if pid := lockHolder(path); pid > 0 {
+			holder = fmt.Sprintf("another run (pid %d)", pid)
+		}
+		if !wait {
+			return nil, fmt.Errorf("%s is in use by %s; use -wait to wait for it to finish", dir, holder)
+		}
+		if !waiting {
+			p.console.progressf("Waiting for %s to finish with %s\n", holder, dir)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// release gives up the lock.
+func (l *outputLock) release() {
+	unlockFile(l.file, l.path)
+}
+
+// lockHolder returns the process ID recorded in the lock file, or 0.
+func lockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
@@ -0,0 +1,56 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// tryLockFile creates path exclusively. A lock file left by a process that no
+// longer exists is stale and taken over.
+func tryLockFile(path string) (*os.File, bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+		if err == nil {
+			return f, true, nil
+		}
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+		pid := lockHolder(path)
+		if pid <= 0 {
+			return nil, false, nil
+		}
+		if processAlive(pid) {
+			return nil, false, nil
+		}
+		os.Remove(path)
+	}
+	return nil, false, nil
+}
+
+// processAlive reports whether the process with the ID pid is running. On
+// Windows os.FindProcess opens the process, failing if it has exited; on the
+// other systems it always succeeds, so the process is sent signal 0, which
+// only checks that it could be signalled.
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = proc.Signal(syscall.Signal(0))
+	// A process of another user can't be signalled but is running
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// unlockFile releases the lock by removing the lock file.
+func unlockFile(f *os.File, path string) {
+	f.Close()
+	os.Remove(path)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Endpoints of the podcast directories -lookup queries.
+const (
+	podcastIndexURL = "https://api.podcastindex.org/api/1.0"
+	listenNotesURL  = "https://listen-api.listennotes.com/api/v2"
+)
+
+// Credentials of the podcast directories. Podcast Index signs each request
+// with its key and secret; Listen Notes takes the key as is.
+const (
+	podcastIndexKeyEnv    = "PODCASTINDEX_API_KEY"
+	podcastIndexSecretEnv = "PODCASTINDEX_API_SECRET"
+	listenNotesKeyEnv     = "LISTENNOTES_API_KEY"
+)
+
+// PodcastMetadata is the canonical record of an episode in a podcast
+// directory, for joining transcripts with other podcast datasets.
+type PodcastMetadata struct {
+	// Directory is the directory the record is from: podcastindex or
+	// listennotes. The IDs are that directory's.
+	Directory string `json:"directory"`
+	ShowID    string `json:"show_id,omitempty"`
+	Show      string `json:"show,omitempty"`
+	// ShowGUID is the show's podcast:guid, the same in every directory.
+	ShowGUID    string `json:"show_guid,omitempty"`
+	FeedURL     string `json:"feed_url,omitempty"`
+	EpisodeID   string `json:"episode_id,omitempty"`
+	Episode     string `json:"episode,omitempty"`
+	EpisodeGUID string `json:"episode_guid,omitempty"`
+	Published   string `json:"published,omitempty"`
+	Artwork     string `json:"artwork,omitempty"`
+}
+
+// lookupPodcast finds the episode in the directory named by config.Lookup.
+// Podcast Index is searched by the show's feed, and the episode matched by its
+// audio file's name or title; Listen Notes is searched by the episode title.
+// It returns nil if the directory doesn't list the episode. audio is the
+// audio file's path or URL.
+func (p *Pipeline) lookupPodcast(ctx context.Context, audio string) (*PodcastMetadata, error) {
+	switch p.config.Lookup {
+	case "podcastindex":
+		audioName := filepath.Base(audio)
+		if u, err := url.Parse(audio); err == nil && isRemoteAudio(audio) {
+			audioName = path.Base(u.Path)
+		}
+		return p.lookupPodcastIndex(ctx, audioName)
+	case "listennotes":
+		return p.lookupListenNotes(ctx)
+	}
+	return nil, fmt.Errorf("unknown directory %q", p.config.Lookup)
+}
+
+// lookupEndpoint returns the API endpoint of a directory.
+func lookupEndpoint(directory string) string {
+	if directory == "listennotes" {
+		return listenNotesURL
+	}
+	return podcastIndexURL
+}
+
+func (p *Pipeline) lookupPodcastIndex(ctx context.Context, audioName string) (*PodcastMetadata, error) {
+	if p.config.FeedURL == "" {
+		return nil, fmt.Errorf("podcastindex finds episodes by their show's feed; give -feed or the show's feed_url")
+	}
+	var res struct {
+		// Feed is an empty array for a feed the index doesn't list
+		Feed json.RawMessage `json:"feed"`
+	}
+	if err := p.podcastIndexGet(ctx, "/podcasts/byfeedurl?url="+url.QueryEscape(p.config.FeedURL), &res); err != nil {
+		return nil, err
+	}
+	var feed struct {
+		ID          int64  `json:"id"`
+		Title       string `json:"title"`
+		URL         string `json:"url"`
+		PodcastGUID string `json:"podcastGuid"`
+		Image       string `json:"image"`
+		Artwork     string `json:"artwork"`
+	}
+	if err := json.Unmarshal(res.Feed, &feed); err != nil || feed.ID == 0 {
+		return nil, nil
+	}
+	var episodes struct {
+		Items []struct {
+			ID            int64  `json:"id"`
+			Title         string `json:"title"`
+			GUID          string `json:"guid"`
+			EnclosureURL  string `json:"enclosureUrl"`
+			DatePublished int64  `json:"datePublished"`
+			Image         string `json:"image"`
+		} `json:"items"`
+	}
+	if err := p.podcastIndexGet(ctx, fmt.Sprintf("/episodes/byfeedid?id=%d&max=1000", feed.ID), &episodes); err != nil {
+		return nil, err
+	}
+	match := -1
+	for i, it := range episodes.Items {
+		if u, err := url.Parse(it.EnclosureURL); err == nil && audioName != "" && path.Base(u.Path) == audioName {
+			match = i
+			break
+		}
+	}
+	for i, it := range episodes.Items {
+		if match < 0 && p.config.Title != "" && strings.EqualFold(strings.TrimSpace(it.Title), p.config.Title) {
+			match = i
+		}
+	}
+	if match < 0 {
+		return nil, nil
+	}
+	it := episodes.Items[match]
+	m := &PodcastMetadata{
+		Directory:   "podcastindex",
+		ShowID:      strconv.FormatInt(feed.ID, 10),
+		Show:        feed.Title,
+		ShowGUID:    feed.PodcastGUID,
+		FeedURL:     firstNonEmpty(feed.URL, p.config.FeedURL),
+		EpisodeID:   strconv.FormatInt(it.ID, 10),
+		Episode:     it.Title,
+		EpisodeGUID: it.GUID,
+		Artwork:     firstNonEmpty(it.Image, feed.Artwork, feed.Image),
+	}
+	if it.DatePublished > 0 {
+		m.Published = time.Unix(it.DatePublished, 0).UTC().Format(time.RFC3339)
+	}
+	return m, nil
+}
+
+// podcastIndexGet sends a signed request to the Podcast Index API: the
+// Authorization header is the SHA-1 of the key, the secret and the time.
+func (p *Pipeline) podcastIndexGet(ctx context.Context, endpoint string, out any) error {
+	key, secret := os.Getenv(podcastIndexKeyEnv), os.Getenv(podcastIndexSecretEnv)
+	if key == "" || secret == "" {
+		return fmt.Errorf("please set the %s and %s environment variables", podcastIndexKeyEnv, podcastIndexSecretEnv)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", podcastIndexURL+endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	sum := sha1.Sum([]byte(key + secret + now))
+	req.Header.Set("X-Auth-Key", key)
+	req.Header.Set("X-Auth-Date", now)
+	req.Header.Set("Authorization", hex.EncodeToString(sum[:]))
+	return p.directoryDo(req, "Podcast Index", out)
+}
+
+func (p *Pipeline) lookupListenNotes(ctx context.Context) (*PodcastMetadata, error) {
+	if p.config.Title == "" {
+		return nil, fmt.Errorf("listennotes finds episodes by their title; give -title, -feed or an audio file with an ID3 title")
+	}
+	var search struct {
+		Results []struct {
+			ID            string `json:"id"`
+			TitleOriginal string `json:"title_original"`
+		} `json:"results"`
+	}
+	query := url.Values{"q": {`"` + p.config.Title + `"`}, "type": {"episode"}}
+	if err := p.listenNotesGet(ctx, "/search?"+query.Encode(), &search); err != nil {
+		return nil, err
+	}
+	id := ""
+	for _, r := range search.Results {
+		if strings.EqualFold(strings.TrimSpace(r.TitleOriginal), p.config.Title) {
+			id = r.ID
+			break
+		}
+	}
+	if id == "" {
+		return nil, nil
+	}
+	var episode struct {
+		ID          string `json:"id"`
+		Title       string `json:"title"`
+		GUIDFromRSS string `json:"guid_from_rss"`
+		PubDateMS   int64  `json:"pub_date_ms"`
+		Image       string `json:"image"`
+		Podcast     struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+			RSS   string `json:"rss"`
+			Image string `json:"image"`
+		} `json:"podcast"`
+	}
+	if err := p.listenNotesGet(ctx, "/episodes/"+url.PathEscape(id), &episode); err != nil {
+		return nil, err
+	}
+	m := &PodcastMetadata{
+		Directory:   "listennotes",
+		ShowID:      episode.Podcast.ID,
+		Show:        episode.Podcast.Title,
+		FeedURL:     episode.Podcast.RSS,
+		EpisodeID:   episode.ID,
+		Episode:     episode.Title,
+		EpisodeGUID: episode.GUIDFromRSS,
+		Artwork:     firstNonEmpty(episode.Image, episode.Podcast.Image),
+	}
+	if episode.PubDateMS > 0 {
+		m.Published = time.UnixMilli(episode.PubDateMS).UTC().Format(time.RFC3339)
+	}
+	return m, nil
+}
+
+func (p *Pipeline) listenNotesGet(ctx context.Context, endpoint string, out any) error {
+	key := os.Getenv(listenNotesKeyEnv)
+	if key == "" {
+		return fmt.Errorf("please set the %s environment variable", listenNotesKeyEnv)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", listenNotesURL+endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-ListenAPI-Key", key)
+	return p.directoryDo(req, "Listen Notes", out)
+}
+
+// directoryDo sends a request to a podcast directory and decodes its reply.
+func (p *Pipeline) directoryDo(req *http.Request, directory string, out any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return fmt.Errorf("non-200 response from %s: %d, body: %s%s", directory, resp.StatusCode, string(body), requestRef(resp))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %v", directory, err)
+	}
+	return nil
+}
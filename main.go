@@ -4,223 +4,2343 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
 	"time"
 )
 
 type Config struct {
-	WhisperURL           string
-	ChatCompletionsURL   string
-	TranscriptionFile    string
-	DiarizedFile         string
-	TranscriptionTimeout time.Duration
-	DiarizationTimeout   time.Duration
-	MaxResponseBodySize  int64
-	MaxAudioFileSize     int64
-	HTTPTimeout          time.Duration
+	WhisperURL            string
+	RealtimeURL           string
+	ChatCompletionsURL    string
+	OpenAIOrganization    string
+	OpenAIProject         string
+	DeepgramURL           string
+	AssemblyAIURL         string
+	LocalCommand          string
+	LocalURL              string
+	Threads               int
+	GPUs                  string
+	TranscriptionModel    string
+	DiarizationModel      string
+	SummaryModel          string
+	Summarize             bool
+	SummaryInstructions   string
+	Temperature           float64
+	TopP                  float64
+	MaxOutputTokens       int
+	Seed                  *int64
+	StructuredOutput      bool
+	Batch                 bool
+	Speedup               float64
+	VerifyWords           bool
+	MaxWordDrift          float64
+	MinCrosstalk          float64
+	SpeakerGap            float64
+	ChapterDiarization    bool
+	EventClassifier       string
+	VerifyRetries         int
+	RetrySuspect          bool
+	PromptTemplate        string
+	Examples              []diarizationExample
+	Verbatim              bool
+	LineNumbers           bool
+	SpeakerNames          []string
+	Guests                []string
+	Vocabulary            []string
+	Speakers              int
+	Language              string
+	CloudBucket           string
+	CloudRegion           string
+	FeedURL               string
+	Lookup                string
+	Title                 string
+	UserAgent             string
+	Description           string
+	MaxPromptTokens       int
+	TranscriptionFile     string
+	TranscriptionJSONFile string
+	DiarizedFile          string
+	DiarizedJSONFile      string
+	ManifestFile          string
+	CorrectionsFile       string
+	TranslationMissesFile string
+	ChaptersFile          string
+	BlogFile              string
+	MinutesFile           string
+	MinutesJSONFile       string
+	StudyNotesFile        string
+	StudyNotesJSONFile    string
+	NewsletterHTMLFile    string
+	NewsletterTextFile    string
+	XThreadFile           string
+	LinkedInFile          string
+	YouTubeFile           string
+	ClipsFile             string
+	MetadataFile          string
+	ChunkReportFile       string
+	CacheDir              string
+	Backups               int
+	BOM                   bool
+	LineEndings           string
+	WrapWidth             int
+	Timestamps            string
+	WordTimestamps        bool
+	ASSStyles             string
+	Offset                float64
+	Drift                 float64
+	MaxUploadRate         int64
+	TranscriptionTimeout  time.Duration
+	DiarizationTimeout    time.Duration
+	MaxResponseBodySize   int64
+	MaxAudioFileSize      int64
+	MinBitrate            int
+	HTTPTimeout           time.Duration
+	// EncryptionKey is the AES-256 key outputs, cached transcripts and the
+	// state store are encrypted at rest with, or nil to write them in the
+	// clear.
+	EncryptionKey []byte
 }
 
-var config = Config{
-	WhisperURL:           "https://api.openai.com/v1/audio/transcriptions",
-	ChatCompletionsURL:   "https://api.openai.com/v1/chat/completions",
-	TranscriptionFile:    "transcription.txt",
-	DiarizedFile:         "diarized.txt",
-	TranscriptionTimeout: 5 * time.Minute,
-	DiarizationTimeout:   2 * time.Minute,
-	MaxResponseBodySize:  10 * 1024 * 1024,
-	MaxAudioFileSize:     25 * 1024 * 1024,
-	HTTPTimeout:          30 * time.Second,
+// Default endpoints of the hosted APIs.
+const (
+	defaultWhisperURL         = "https://api.openai.com/v1/audio/transcriptions"
+	defaultChatCompletionsURL = "https://api.openai.com/v1/chat/completions"
+	defaultDeepgramURL        = "https://api.deepgram.com/v1/listen"
+	defaultAssemblyAIURL      = "https://api.assemblyai.com/v2"
+)
+
+// defaultConfig returns the configuration a run starts from before flags, the
+// environment and the configuration file are applied.
+func defaultConfig() Config {
+	return Config{
+		WhisperURL:            defaultWhisperURL,
+		RealtimeURL:           defaultRealtimeURL,
+		ChatCompletionsURL:    defaultChatCompletionsURL,
+		OpenAIOrganization:    os.Getenv("OPENAI_ORG_ID"),
+		OpenAIProject:         os.Getenv("OPENAI_PROJECT_ID"),
+		DeepgramURL:           defaultDeepgramURL,
+		AssemblyAIURL:         defaultAssemblyAIURL,
+		LocalCommand:          defaultLocalCommand,
+		TranscriptionModel:    "whisper-1",
+		DiarizationModel:      "gpt-4o",
+		SummaryModel:          "gpt-4o",
+		Temperature:           0.3,
+		StructuredOutput:      true,
+		Speedup:               1,
+		VerifyWords:           true,
+		MaxWordDrift:          0.05,
+		MinCrosstalk:          0.3,
+		VerifyRetries:         2,
+		TranscriptionFile:     "transcription.txt",
+		TranscriptionJSONFile: "transcription.json",
+		DiarizedFile:          "diarized.txt",
+		DiarizedJSONFile:      "diarized.json",
+		ManifestFile:          "manifest.json",
+		CorrectionsFile:       "corrections.json",
+		TranslationMissesFile: "translation-misses.json",
+		ChaptersFile:          "chapters.json",
+		BlogFile:              "blog.md",
+		MinutesFile:           "minutes.md",
+		MinutesJSONFile:       "minutes.json",
+		StudyNotesFile:        "study-notes.md",
+		StudyNotesJSONFile:    "study-notes.json",
+		NewsletterHTMLFile:    "newsletter.html",
+		NewsletterTextFile:    "newsletter.txt",
+		XThreadFile:           "x-thread.txt",
+		LinkedInFile:          "linkedin.txt",
+		YouTubeFile:           "youtube-description.txt",
+		ClipsFile:             "clips.json",
+		MetadataFile:          "metadata.json",
+		ChunkReportFile:       "chunks.json",
+		CacheDir:              defaultCacheDir(),
+		MaxResponseBodySize:   10 * 1024 * 1024,
+		MaxAudioFileSize:      25 * 1024 * 1024,
+		LineEndings:           "lf",
+		MinBitrate:            24,
+		HTTPTimeout:           30 * time.Second,
+	}
 }
 
-var httpClient = &http.Client{
-	Timeout: config.HTTPTimeout,
-}
+func main() {
+	if dispatchCommand() {
+		return
+	}
+	config := defaultConfig()
+
+	// Parse command-line arguments
+	audioPath := flag.String("audio", "", "Path or http(s) URL of the audio file")
+	backendName := flag.String("backend", "openai", "Transcription provider: "+strings.Join(backendNames(), ", ")+", or a "+pluginPrefix+"* plugin on PATH")
+	flag.BoolVar(&config.ChapterDiarization, "diarize-by-chapter", false, "Diarize one topic chapter at a time, passing a recap of the speakers so far, for more consistent labels over long episodes")
+	flag.Float64Var(&config.SpeakerGap, "speaker-gap", config.SpeakerGap, "List pauses of at least this many seconds between Whisper segments in the diarization prompt as likely speaker changes (0 disables)")
+	flag.Float64Var(&config.MinCrosstalk, "min-crosstalk", config.MinCrosstalk, "Seconds speakers must talk over each other to be annotated as crosstalk (0 disables)")
+	annotate := flag.Bool("events", false, "Annotate laughter, applause, music and long pauses as [event] lines")
+	pauseSeconds := flag.Float64("pause", 3, "Silence in seconds between turns annotated as [pause] with -events (0 disables)")
+	flag.StringVar(&config.EventClassifier, "event-classifier", "", "Command printing JSON audio events for -events; {audio} is substituted")
+	flag.BoolVar(&config.RetrySuspect, "retry-suspect", false, "Transcribe stretches that look like hallucinations again with different parameters and keep the better result (needs ffmpeg)")
+	sampleSpec := flag.String("sample", "", "Transcribe and diarize evenly spaced excerpts, e.g. 3x60s for three one-minute excerpts, and print them without writing any files (needs ffmpeg)")
+	fromFlag := flag.String("from", "", "Process only the audio from this position, e.g. 00:12:00 (needs ffmpeg)")
+	toFlag := flag.String("to", "", "Process only the audio up to this position, e.g. 00:40:00 (needs ffmpeg)")
+	maxDuration := flag.Duration("max-duration", defaultMaxDuration, "Ask before transcribing audio longer than this, or refuse without -yes when not on a terminal (0 disables)")
+	assumeYes := flag.Bool("yes", false, "Transcribe audio longer than -max-duration without asking")
+	ensembleName := flag.String("ensemble", "", "Also transcribe with this second backend, e.g. deepgram, and take its words for the segments where the two disagree and it is the more confident (twice the transcription cost)")
+	profileName := flag.String("profile", "", "Processing profile trading cost against latency: "+profileNames()+"; flags given explicitly override it")
+	flag.Float64Var(&config.Speedup, "speedup", config.Speedup, "Speed the audio up this many times, at most 2, before transcribing, so that less audio is billed; times are mapped back (needs ffmpeg)")
+	streamPath := flag.String("stream", "", "With -chunk, append each chunk's turns to this text file as soon as it's diarized, to read the start of a long episode before the run finishes")
+	chunkLength := flag.Duration("chunk", 0, "Transcribe the audio in chunks of this length (needs ffmpeg), diarizing each chunk while the next is transcribed (0 disables)")
+	normalizeList := flag.String("normalize", "", "Comma-separated normalizations applied to the transcript: numbers, currency, acronyms, or all")
+	flag.BoolVar(&config.Verbatim, "verbatim", false, "Legal and archival mode: keep fillers and the words as spoken, with no cleanup, normalization, glossary or anonymization, time and number every line of the txt output, and certify in the manifest that the text wasn't transformed")
+	cleanupMode := flag.String("cleanup", "", "Restore casing and punctuation and split the transcription into paragraphs before diarization: rules or llm")
+	reviewThreshold := flag.Float64("review-threshold", 0, "With acoustic diarization, mark turns whose speaker confidence (0-1) is below this for review, e.g. \"Speaker 2(?)\"")
+	languagesFlag := flag.String("languages", "", "Comma-separated languages of a multilingual show, e.g. en,es; the chat model tags each turn with the one it is spoken in")
+	translateFlag := flag.String("translate", "", "Comma-separated FROM=TO translation passes, e.g. es=en, translating the turns in FROM with the summary model into diarized.TO.* outputs")
+	translateTo := flag.String("translate-to", "", "Comma-separated languages to translate the whole transcript into concurrently, e.g. es,de,fr, written as diarized.LANG.* outputs in every -format")
+	translationGlossaryPath := flag.String("translation-glossary", "", "Path to a bilingual glossary of how terms and names are translated, for -translate and -translate-to; translations that leave one out are reported in translation-misses.json")
+	speakerRolesFlag := flag.Bool("speaker-roles", false, "Classify each speaker as host, co-host, guest or advertisement voice with the chat model")
+	nameSpeakersFlag := flag.Bool("name-speakers", false, "Ask the chat model to put names and roles to the anonymous speakers of acoustic diarization, keeping its turns")
+	boilerplatePath := flag.String("boilerplate", "", "File of a show's intro and outro, learned with the boilerplate command, whose turns are left out of the transcript")
+	skipBoilerplate := flag.Bool("skip-boilerplate", false, "Also cut the -boilerplate intro and outro off the audio before transcribing, where they're at a fixed place at its start or end (needs ffmpeg)")
+	excludePath := flag.String("exclude", "", "Cue file of time ranges, such as ads or off-record chat, left out of transcription, diarization and every output, e.g. a line \"12:00-15:30 ad read\"")
+	withholdSpeakers := flag.String("withhold-speakers", "", "Also write an approval copy, diarized."+approvalVariant+".<ext>, withholding these comma-separated speakers' turns")
+	offRecord := flag.String("off-record", "", "Also write an approval copy withholding the time ranges in this cue file, e.g. a line \"12:00-15:30 off the record\"")
+	withholdStyle := flag.String("withhold-style", "mask", "How the approval copy withholds turns: mask (each turn's text) or collapse (each run of turns into one marker)")
+	anonymizeFlag := flag.Bool("anonymize", false, "Replace speakers with Speaker A, Speaker B, ... and everyone named with PERSON_1, PERSON_2, ... in the transcript and every output derived from it")
+	diarizerName := flag.String("diarizer", "", "Name of a "+pluginPrefix+"* plugin on PATH to diarize with instead of the chat model")
+	flag.StringVar(&config.TranscriptionModel, "transcription-model", config.TranscriptionModel, "Transcription model (default depends on -backend)")
+	numSpeakers := flag.Int("speakers", 2, "Number of speakers in the podcast")
+	retrySpeakers := flag.Bool("retry-speakers", false, "Diarize again with the number of speakers found when the chat model's labels don't match -speakers")
+	flag.StringVar(&config.Language, "language", "", "Spoken language as a BCP-47 code such as en-US (default: detect where the backend supports it)")
+	flag.StringVar(&config.CloudBucket, "bucket", "", "GCS or S3 bucket the audio is staged in for the google and aws backends")
+	flag.StringVar(&config.LocalCommand, "local-command", config.LocalCommand, "Command run by the local backend; {audio}, {output_dir}, {model}, {speakers} and {language} are substituted")
+	flag.StringVar(&config.LocalURL, "local-url", "", "URL of a local transcription server used by the local backend instead of -local-command")
+	flag.IntVar(&config.Threads, "threads", 0, "CPU threads the local backend uses, shared between its streams (0 uses every core)")
+	flag.StringVar(&config.GPUs, "gpu", "auto", "GPUs the local backend runs on: auto (every NVIDIA GPU nvidia-smi finds), none for the CPU only, or device indexes such as 0,1")
+	flag.DurationVar(&config.TranscriptionTimeout, "transcription-timeout", 0, "Maximum time to wait for transcription (default: twice the audio duration, at least 2m)")
+	flag.DurationVar(&config.DiarizationTimeout, "diarization-timeout", 0, "Maximum time to wait for each chat model request (default: 2m plus time to write the expected reply)")
+	flag.StringVar(&config.CloudRegion, "region", "", "Cloud region for the google (default: global) and aws (default: $AWS_REGION) backends")
+	rediarize := flag.Bool("rediarize", false, "Reuse the cached transcription and only redo diarization")
+	reexport := flag.Bool("reexport", false, "Regenerate output files from the cached diarized JSON without calling any API")
+	importTranscript := flag.String("import-transcript", "", "Start from this transcript made elsewhere instead of transcribing audio: SRT, WebVTT, or canonical or provider JSON; speakers in it are kept unless -rediarize is given")
+	glossaryPath := flag.String("glossary", "", "Path to a glossary of correct spellings used to fix mis-heard names and terms; substitutions are reported in corrections.json")
+	promptFile := flag.String("prompt", "", "Path to a custom diarization prompt template (Go text/template)")
+	flag.Float64Var(&config.Temperature, "temperature", config.Temperature, "Sampling temperature for the diarization request")
+	flag.Float64Var(&config.TopP, "top-p", 0, "Nucleus sampling top_p for the diarization request (0 uses the API default)")
+	flag.IntVar(&config.MaxOutputTokens, "max-output-tokens", 0, "Maximum completion tokens per diarization request (0 uses the model's limit)")
+	flag.Func("seed", "Integer seed for best-effort deterministic diarization", func(s string) error {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		config.Seed = &n
+		return nil
+	})
+	flag.BoolVar(&config.StructuredOutput, "json-mode", config.StructuredOutput, "Request structured JSON speaker turns from the diarization model instead of parsing prose")
+	flag.BoolVar(&config.Batch, "batch", false, "Send the chat model requests, such as diarization and summaries, through the provider's Batch API at about half the price; each may take up to 24h")
+	flag.BoolVar(&config.VerifyWords, "verify", config.VerifyWords, "Check that diarization kept the transcript's words and retry or fail if it didn't")
+	flag.Float64Var(&config.MaxWordDrift, "max-drift", config.MaxWordDrift, "Fraction of source words diarization may drop, reorder or invent before the result is rejected")
+	flag.IntVar(&config.VerifyRetries, "verify-retries", config.VerifyRetries, "How many times to retry a diarization request that fails verification")
+	flag.IntVar(&config.MaxPromptTokens, "max-prompt-tokens", 0, "Maximum transcript tokens per diarization request (0 derives it from the model's limits)")
+	examplesList := flag.String("examples", "", "Comma-separated few-shot example files (JSON pairs or a corrected diarized.json)")
+	exampleTokens := flag.Int("example-tokens", 1500, "Approximate token limit per few-shot example")
+	formatList := flag.String("format", "txt", "Comma-separated output formats: "+strings.Join(exporterNames(), ",")+", or template:FILE to render a Go template of your own")
+	flag.BoolVar(&config.BOM, "bom", false, "Start the text output formats with a UTF-8 byte order mark, for Windows tools that need one")
+	flag.StringVar(&config.LineEndings, "line-endings", config.LineEndings, "Line endings of the text output formats: lf or crlf")
+	flag.StringVar(&config.Timestamps, "timestamps", "", "Style of the turn times in the txt and md outputs: hh:mm:ss, mm:ss, hh:mm:ss.mmm, hh:mm:ss,mmm or frames:FPS (default: hh:mm:ss in md, none in txt)")
+	flag.BoolVar(&config.WordTimestamps, "word-timestamps", false, "Ask Whisper for the timing of every word, as the other backends give anyway (implied by -format karaoke)")
+	flag.StringVar(&config.ASSStyles, "ass-style", "", "Comma-separated Speaker=#RRGGBB@position overrides of the speaker styles in the ass output; positions are left, center, right, top-left, top and top-right")
+	offsetFlag := flag.String("offset", "", "Shift the times of the -format outputs by this much, e.g. 2.5s or -1:30, for a published episode whose intro differs from the recording")
+	driftFlag := flag.String("drift", "", "Stretch the times of the -format outputs by this much per hour of audio, e.g. 1.2s or -0.8s, for a published episode that drifts from the recording")
+	flag.IntVar(&config.WrapWidth, "wrap", 0, "Wrap lines of the txt, srt and vtt outputs at this many characters (0 disables)")
+	flag.StringVar(&config.Title, "title", "", "Episode title recorded in the transcript metadata and given to the diarization model")
+	flag.StringVar(&config.Description, "description", "", "Episode description or show notes given to the diarization model so it knows the guests (@file reads a file)")
+	flag.StringVar(&config.FeedURL, "feed", "", "Podcast RSS feed to look up the episode's title and description in")
+	flag.StringVar(&config.Lookup, "lookup", "", "Podcast directory to look up the episode's canonical show, GUID and artwork in: podcastindex (by -feed) or listennotes (by title)")
+	date := flag.String("date", time.Now().Format("2006-01-02"), "Episode date (YYYY-MM-DD) recorded in the transcript metadata")
+	flag.BoolVar(&config.Summarize, "summarize", false, "Generate a short episode summary with the chat model")
+	summaryPreset := flag.String("summary-preset", "", "Style of the -summarize summary: description (default), one-liner, paragraph, outline, kid-friendly, executive-brief, or one from summary_presets in the config file; implies -summarize")
+	flag.StringVar(&config.DiarizationModel, "diarization-model", config.DiarizationModel, "Chat model used for diarization, speaker naming and the other per-turn stages")
+	flag.StringVar(&config.SummaryModel, "summary-model", config.SummaryModel, "Chat model used for -summarize and the content drafts")
+	chaptersFlag := flag.Bool("chapters", false, "Write chapters for podcast apps to chapters.json, from the provider's or the summary model's suggestions, kept to -min-chapter, -max-chapter and -max-chapters")
+	minChapter := flag.String("min-chapter", "2m", "Shortest chapter -chapters writes, e.g. 90s or 3m")
+	maxChapter := flag.String("max-chapter", "", "Longest chapter -chapters writes, e.g. 20m (default: no limit)")
+	maxChapters := flag.Int("max-chapters", 0, "Most chapters -chapters writes (0 is no limit)")
+	blogFlag := flag.Bool("blog", false, "Draft a blog post from the diarized transcript into blog.md with the summary model")
+	blogStyle := flag.String("blog-style", "", "Path to a file of style instructions for -blog, e.g. tone, length and audience")
+	minutesFlag := flag.Bool("minutes", false, "Write meeting minutes (attendees, per-topic summaries, decisions and action items) of a recorded meeting to minutes.md and minutes.json with the summary model")
+	studyNotesFlag := flag.Bool("study-notes", false, "Write study notes of a recorded lecture (key concepts and definitions with timestamps, quiz questions) to study-notes.md and study-notes.json with the summary model")
+	newsletterFlag := flag.Bool("newsletter", false, "Write a newsletter email about the episode (summary, highlights with timestamps) to newsletter.html and newsletter.txt")
+	titleVariants := flag.Int("title-variants", 0, fmt.Sprintf("Write this many alternative titles and descriptions for Apple Podcasts, YouTube and RSS, each within the platform's lengths, to metadata.json for A/B testing (at most %d)", maxTitleVariants))
+	socialFlag := flag.Bool("social", false, "Write social posts about the episode: an X thread, a LinkedIn post and a YouTube description with chapters, each within the platform's length limit")
+	clipCount := flag.Int("clips", 0, "Suggest this many 30-60 second clips for audiograms, with exact timestamps and captions, in clips.json (0 disables)")
+	snippetDir := flag.String("snippet-dir", "", "Cut every speaker turn out of the audio into this directory, named by its start time and speaker, with an index of their text (needs ffmpeg)")
+	clipDir := flag.String("clip-dir", "", "Cut the -clips out of the audio into this directory with their captions as SRT (needs ffmpeg)")
+	youtubeVideoID := flag.String("youtube-video-id", "", "Upload the srt (or vtt) captions, and those of the translations, to this YouTube video")
+	uploadTranscriptFlag := flag.Bool("upload-transcript", false, "Attach the transcript to the episode on the -show's podcast host, set in the profile's hosting")
+	hostingEpisode := flag.String("hosting-episode", "", "The host's ID of the episode -upload-transcript attaches the transcript to (default: the episode with the audio file's name or the title)")
+	deliverTo := flag.String("deliver-to", "", "Comma-separated shared folders to upload the outputs to, into a folder per episode: gdrive:FOLDER_ID or dropbox:/PATH")
+	postDraft := flag.Bool("post-draft", false, "Post the transcript with its summary and chapters as a draft to the -show's WordPress or Ghost site, set in the profile's cms")
+	emailTo := flag.String("email-to", "", "Comma-separated addresses to email the -newsletter to when the run completes")
+	emailOutputs := flag.String("email-outputs", "", "Comma-separated addresses to email the outputs to when the run completes, attached up to 15 MiB in all and listed by path beyond")
+	emailFrom := flag.String("email-from", "", "Sender address of emails (default: SMTP_USERNAME)")
+	emailVia := flag.String("email-via", "smtp", "How emails are sent: smtp (SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD) or sendgrid (SENDGRID_API_KEY)")
+	onTranscript := flag.String("on-transcript", "", "Command run once the transcript outputs are written, with their paths as arguments and a JSON description of the run on stdin")
+	onComplete := flag.String("on-complete", "", "Command run at the end of the run, with every output's path as arguments and a JSON description of the run on stdin")
+	notifyFlag := flag.String("notify", "", "Comma-separated sinks told when the job starts, each chunk is done, a stage fails and the job completes: "+notifySinkNames())
+	auditPath := flag.String("audit-log", "", "Append a JSON line per external API call to this file")
+	recordDir := flag.String("record", "", "Save every API response to a fixture file in this directory for -replay")
+	replayDir := flag.String("replay", "", "Serve API responses from the fixtures recorded with -record instead of calling the APIs")
+	statePath := flag.String("state", defaultStatePath(), "Path to the local state store")
+	onDuplicate := flag.String("on-duplicate", "skip", "What to do with audio already processed into another output directory, recognized by its hash in the state store: skip, link (symlink the earlier outputs here) or process")
+	monthlyBudget := flag.Float64("monthly-budget", 0, "Refuse to start once this month's estimated spend reaches this many USD (default from the config file)")
+	overrideBudget := flag.Bool("override-budget", false, "Run even if the monthly budget has been reached")
+	configPath := flag.String("config", defaultConfigPath(), "Path to the JSON configuration file")
+	flag.StringVar(&config.UserAgent, "user-agent", "", "Text added to the User-Agent of API requests, such as a contact address providers can reach you at")
+	flag.StringVar(&config.OpenAIOrganization, "openai-org", config.OpenAIOrganization, "OpenAI organization ID sent as OpenAI-Organization (default: $OPENAI_ORG_ID or the config file)")
+	flag.StringVar(&config.OpenAIProject, "openai-project", config.OpenAIProject, "OpenAI project ID sent as OpenAI-Project (default: $OPENAI_PROJECT_ID, the show profile or the config file)")
+	showName := flag.String("show", "", "Name of a show profile from the configuration file")
+	guestsPath := flag.String("guests", "", "Calendar invite (.ics) or guest list of the recording, whose attendees on -date are given to diarization and speaker naming as the episode's guests")
+	outputDir := flag.String("output-dir", "", "Directory for cached and generated files (default: current directory)")
+	flag.IntVar(&config.MinBitrate, "min-bitrate", config.MinBitrate, "Lowest bitrate in kbps audio over the Whisper size limit may be re-encoded at to fit (0 disables re-encoding)")
+	signKeyPath := flag.String("sign-key", "", "Sign the manifest and every output with the Ed25519 private key in this PEM file, writing a "+signatureExt+" signature next to each for the verify command")
+	encryptionKey := flag.String("encryption-key", "", "Encrypt outputs, cached transcripts and the state store with the AES-256 key in this file (default: $PODCAST_TRANSCRIPTION_KEY if set)")
+	flag.IntVar(&config.Backups, "backups", 0, "Keep this many previous versions of each output file as file.1, file.2, ... when a re-run changes it")
+	windowSpec := flag.String("window", "", "Only start processing within this daily local time window, e.g. 01:00-07:00; a run started outside it waits until the window opens")
+	waitForLock := flag.Bool("wait", false, "Wait for another run using the same output directory to finish instead of failing")
+	flag.StringVar(&config.CacheDir, "cache-dir", config.CacheDir, "Directory for temporary artifacts such as audio chunks; stale ones are removed at startup")
+	quiet := flag.Bool("quiet", false, "Print only warnings, errors and the files written, one per line, e.g. for CI and cron")
+	noColor := flag.Bool("no-color", false, "Never color the output (default: color only on a terminal without $NO_COLOR)")
+	maxUploadRate := flag.String("max-upload-rate", "", "Cap the bandwidth of uploads, in bytes per second shared by all requests, e.g. 500KB or 2MB/s; KB and MB are powers of 1000, k, M, KiB and MiB of 1024")
+	maxMemory := flag.String("max-memory", "", "Soft memory ceiling for the process, e.g. 256MiB; also caps response and command output sizes. MB and GB are powers of 1000, M, G, MiB and GiB of 1024")
+	flag.Usage = func() { printUsage(flag.CommandLine.Output(), flag.CommandLine) }
+	flag.Parse()
+	var profiled []string
+	if *profileName != "" {
+		var err error
+		if profiled, err = applyProcessingProfile(*profileName); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if config.Speedup < 1 || config.Speedup > maxSpeedup {
+		fmt.Fprintf(stderr, "Error: -speedup must be between 1 and %g\n", maxSpeedup)
+		os.Exit(1)
+	}
+	fixtures, err := fixtureTransport(*recordDir, *replayDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if config.EncryptionKey, err = loadEncryptionKey(*encryptionKey); err != nil {
+		fmt.Fprintf(stderr, "Error: -encryption-key: %v\n", err)
+		os.Exit(1)
+	}
+	var signer *signingKey
+	if *signKeyPath != "" {
+		if signer, err = loadSigningKey(*signKeyPath); err != nil {
+			fmt.Fprintf(stderr, "Error: -sign-key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	p := newPipeline(&config, fixtures)
+	p.console.quiet = *quiet
+	if *noColor {
+		p.console.color = false
+	}
+	if len(profiled) > 0 {
+		p.console.progressf("Profile %s: %s\n", *profileName, strings.Join(profiled, ", "))
+	}
+
+	if *maxMemory != "" {
+		limit, err := parseByteSize(*maxMemory)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: -max-memory: %v\n", err)
+			os.Exit(1)
+		}
+		p.applyMemoryLimit(limit)
+	}
+	p.cleanStaleArtifacts()
+
+	fileConfig, err := loadFileConfig(*configPath, setFlags()["config"])
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	set := setFlags()
+	if !set["openai-org"] && config.OpenAIOrganization == "" {
+		config.OpenAIOrganization = fileConfig.OpenAIOrganization
+	}
+	if !set["openai-project"] && config.OpenAIProject == "" {
+		config.OpenAIProject = fileConfig.OpenAIProject
+	}
+	if *summaryPreset != "" {
+		if config.SummaryInstructions, err = summaryInstructions(*summaryPreset, fileConfig.SummaryPresets); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		config.Summarize = true
+	}
+	var cms *cmsSettings
+	var hosting *hostingSettings
+	if *showName != "" {
+		show, err := fileConfig.show(*showName)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cms, hosting = show.CMS, show.Hosting
+		// Explicit flags win over the profile
+		if !set["speakers"] && len(show.Speakers) > 0 {
+			*numSpeakers = len(show.Speakers)
+		}
+		if !set["glossary"] && show.Glossary != "" {
+			*glossaryPath = fileConfig.resolve(show.Glossary)
+		}
+		if !set["translation-glossary"] && show.TranslationGlossary != "" {
+			*translationGlossaryPath = fileConfig.resolve(show.TranslationGlossary)
+		}
+		if !set["prompt"] && show.PromptTemplate != "" {
+			*promptFile = fileConfig.resolve(show.PromptTemplate)
+		}
+		if !set["examples"] && len(show.Examples) > 0 {
+			resolved := make([]string, len(show.Examples))
+			for i, e := range show.Examples {
+				resolved[i] = fileConfig.resolve(e)
+			}
+			*examplesList = strings.Join(resolved, ",")
+		}
+		if !set["output-dir"] {
+			*outputDir = fileConfig.resolve(show.OutputDir)
+		}
+		if !set["boilerplate"] && show.Boilerplate != "" {
+			*boilerplatePath = fileConfig.resolve(show.Boilerplate)
+		}
+		if !set["guests"] && show.Guests != "" {
+			*guestsPath = fileConfig.resolve(show.Guests)
+		}
+		config.SpeakerNames = show.Speakers
+		config.Vocabulary = show.Vocabulary
+		if !set["feed"] {
+			config.FeedURL = show.FeedURL
+		}
+		if !set["openai-project"] && show.OpenAIProject != "" {
+			config.OpenAIProject = show.OpenAIProject
+		}
+	}
+	if err := p.applyOutputDir(*outputDir); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *windowSpec != "" && !*reexport {
+		w, err := parseWindow(*windowSpec)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: -window: %v\n", err)
+			os.Exit(1)
+		}
+		if err := p.waitForWindow(context.Background(), w); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	lock, err := p.lockOutputDir(*outputDir, *waitForLock)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer lock.release()
+
+	state := openStateStore(*statePath, config.EncryptionKey)
+	var audit *auditLog
+	if *auditPath != "" {
+		if audit, err = openAuditLog(*auditPath); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer audit.Close()
+	}
+	p.wrapTransport(func(next http.RoundTripper) http.RoundTripper {
+		if *replayDir != "" {
+			// Replayed responses cost nothing
+			return &auditTransport{next: next, log: audit, config: p.config}
+		}
+		return &auditTransport{next: next, log: audit, state: state, config: p.config}
+	})
+	if *replayDir == "" {
+		p.batches = state
+	}
+	if *maxUploadRate != "" {
+		if config.MaxUploadRate, err = parseRate(*maxUploadRate); err != nil {
+			fmt.Fprintf(stderr, "Error: -max-upload-rate: %v\n", err)
+			os.Exit(1)
+		}
+		p.wrapTransport(func(next http.RoundTripper) http.RoundTripper {
+			return newThrottledTransport(next, config.MaxUploadRate)
+		})
+	}
+
+	formats, err := parseFormats(*formatList)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if config.LineEndings, err = parseLineEndings(config.LineEndings); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if config.Verbatim {
+		for _, c := range []struct {
+			flag string
+			on   bool
+		}{
+			{"cleanup", *cleanupMode != "" && *cleanupMode != "none"},
+			{"normalize", *normalizeList != ""},
+			{"glossary", set["glossary"]},
+			{"anonymize", *anonymizeFlag},
+		} {
+			if c.on {
+				fmt.Fprintf(stderr, "Error: -verbatim can't be combined with -%s, which changes the transcript's text\n", c.flag)
+				os.Exit(1)
+			}
+		}
+		if (set["verify"] && !config.VerifyWords) || (set["max-drift"] && config.MaxWordDrift > 0) {
+			fmt.Fprintln(stderr, "Error: -verbatim checks that diarization keeps every word; leave out -verify and -max-drift")
+			os.Exit(1)
+		}
+		// The show's glossary doesn't apply either
+		*glossaryPath = ""
+		config.VerifyWords, config.MaxWordDrift = true, 0
+		config.LineNumbers = true
+		if config.Timestamps == "" {
+			config.Timestamps = "hh:mm:ss"
+		}
+	}
+	if config.Timestamps != "" {
+		if _, err := parseTimestampStyle(config.Timestamps); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if _, err := parseGPUs(config.GPUs); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if config.Threads < 0 {
+		fmt.Fprintln(stderr, "Error: -threads can't be negative")
+		os.Exit(1)
+	}
+	for name, command := range map[string]string{"on-transcript": *onTranscript, "on-complete": *onComplete} {
+		// A typo shouldn't surface only after the whole run
+		if err := checkHook(name, command); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *offsetFlag != "" {
+		if config.Offset, err = parseOffset(*offsetFlag); err != nil {
+			fmt.Fprintf(stderr, "Error: invalid -offset: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *driftFlag != "" {
+		if config.Drift, err = parseOffset(*driftFlag); err != nil {
+			fmt.Fprintf(stderr, "Error: invalid -drift: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var chapterLimit chapterLimits
+	if chapterLimit.min, err = parseClock(*minChapter); err != nil {
+		fmt.Fprintf(stderr, "Error: invalid -min-chapter: %v\n", err)
+		os.Exit(1)
+	}
+	if *maxChapter != "" {
+		if chapterLimit.max, err = parseClock(*maxChapter); err != nil {
+			fmt.Fprintf(stderr, "Error: invalid -max-chapter: %v\n", err)
+			os.Exit(1)
+		}
+		if chapterLimit.max < 2*chapterLimit.min {
+			fmt.Fprintln(stderr, "Error: -max-chapter must be at least twice -min-chapter, so long chapters can be cut in two")
+			os.Exit(1)
+		}
+	}
+	if *maxChapters < 0 {
+		fmt.Fprintln(stderr, "Error: -max-chapters can't be negative")
+		os.Exit(1)
+	}
+	chapterLimit.count = *maxChapters
+	switch config.Lookup {
+	case "", "podcastindex", "listennotes":
+	default:
+		fmt.Fprintf(stderr, "Error: unknown -lookup directory %q (available: podcastindex, listennotes)\n", config.Lookup)
+		os.Exit(1)
+	}
+	if *titleVariants < 0 || *titleVariants > maxTitleVariants {
+		fmt.Fprintf(stderr, "Error: -title-variants must be from 0 to %d\n", maxTitleVariants)
+		os.Exit(1)
+	}
+	var languages []string
+	if *languagesFlag != "" {
+		if languages, err = parseLanguages(*languagesFlag); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	routes, err := parseTranslationRoutes(*translateFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	targets, err := parseLanguageCodes(*translateTo, "translate-to")
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	passes := translationPasses(routes, targets)
+	if len(routes) > 0 && languages == nil && config.Language == "" && !*reexport {
+		fmt.Fprintln(stderr, "Error: -translate needs the languages spoken: -languages for a multilingual show, or -language")
+		os.Exit(1)
+	}
+	if _, err := parseASSStyles(config.ASSStyles); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, f := range formats {
+		if f == "karaoke" {
+			config.WordTimestamps = true
+		}
+	}
+	if config.WrapWidth < 0 {
+		fmt.Fprintln(stderr, "Error: -wrap must not be negative")
+		os.Exit(1)
+	}
+	var intros *boilerplate
+	if *boilerplatePath != "" {
+		if intros, err = loadBoilerplate(*boilerplatePath); err != nil {
+			fmt.Fprintf(stderr, "Error: -boilerplate: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *skipBoilerplate {
+		fmt.Fprintln(stderr, "Error: -skip-boilerplate needs the -boilerplate learned for the show")
+		os.Exit(1)
+	}
+	var excluded []timeRange
+	if *excludePath != "" {
+		if excluded, err = loadCueFile(*excludePath); err != nil {
+			fmt.Fprintf(stderr, "Error: -exclude: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	withhold, err := parseWithholding(*withholdSpeakers, *offRecord, *withholdStyle)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	destinations, err := parseDestinations(*deliverTo)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	sinks, err := parseNotifySinks(*notifyFlag, fileConfig)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(sinks) > 0 {
+		p.notify = &notifier{sinks: sinks, client: &http.Client{Transport: &identityTransport{next: http.DefaultTransport, config: p.config}}}
+	}
+	if *youtubeVideoID != "" && captionFormat(formats) == "" {
+		fmt.Fprintln(stderr, "Error: -youtube-video-id uploads the srt or vtt output; add one to -format")
+		os.Exit(1)
+	}
+
+	if *reexport {
+		t, err := loadTranscript(config.EncryptionKey, config.DiarizedJSONFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading diarized transcript: %v\n", err)
+			os.Exit(1)
+		}
+		paths, err := p.exportAll(t, formats, "")
+		if err != nil {
+			fmt.Fprintf(stderr, "Error writing diarized transcript to file: %v\n", err)
+			os.Exit(1)
+		}
+		translations, err := p.exportTranslations(t, passes, formats)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error writing translated transcript to file: %v\n", err)
+			os.Exit(1)
+		}
+		paths = append(paths, translations...)
+		if withhold != nil {
+			approval, err := p.exportApproval(t, withhold, formats)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error writing the approval copy: %v\n", err)
+				os.Exit(1)
+			}
+			paths = append(paths, approval...)
+		}
+		if p.console.quiet {
+			fmt.Fprintln(p.console.out, strings.Join(paths, "\n"))
+			return
+		}
+		p.console.progressf("Re-exported %s from %s\n", strings.Join(paths, ", "), config.DiarizedJSONFile)
+		return
+	}
+
+	var imported *Transcript
+	var importedFormat string
+	if *importTranscript != "" {
+		if *audioPath != "" {
+			fmt.Fprintln(stderr, "Error: -import-transcript replaces transcribing -audio; give only one of them")
+			os.Exit(1)
+		}
+		if imported, importedFormat, err = loadForeignTranscript(config.EncryptionKey, *importTranscript); err != nil {
+			fmt.Fprintf(stderr, "Error importing transcript: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *audioPath == "" && !*rediarize {
+		fmt.Fprintln(stderr, "Please provide the path to the audio file using -audio")
+		os.Exit(1)
+	}
+
+	switch *onDuplicate {
+	case "skip", "link", "process":
+	default:
+		fmt.Fprintf(stderr, "Error: unknown -on-duplicate %q (available: skip, link, process)\n", *onDuplicate)
+		os.Exit(1)
+	}
+	if *clipDir != "" && *clipCount <= 0 {
+		fmt.Fprintln(stderr, "Error: -clip-dir cuts the -clips, which aren't enabled")
+		os.Exit(1)
+	}
+	if *emailTo != "" && !*newsletterFlag {
+		fmt.Fprintln(stderr, "Error: -email-to sends the -newsletter, which isn't enabled")
+		os.Exit(1)
+	}
+	if *uploadTranscriptFlag {
+		if hosting == nil {
+			fmt.Fprintln(stderr, "Error: -upload-transcript attaches the transcript on the host in the -show profile's hosting, which isn't set")
+			os.Exit(1)
+		}
+		if err := hosting.validate(); err != nil {
+			fmt.Fprintf(stderr, "Error: -show %s: %v\n", *showName, err)
+			os.Exit(1)
+		}
+	}
+	if *hostingEpisode != "" && !*uploadTranscriptFlag {
+		fmt.Fprintln(stderr, "Error: -hosting-episode names the episode of -upload-transcript, which isn't enabled")
+		os.Exit(1)
+	}
+	if *postDraft {
+		if cms == nil {
+			fmt.Fprintln(stderr, "Error: -post-draft posts to the site in the -show profile's cms, which isn't set")
+			os.Exit(1)
+		}
+		if err := cms.validate(); err != nil {
+			fmt.Fprintf(stderr, "Error: -show %s: %v\n", *showName, err)
+			os.Exit(1)
+		}
+	}
+	var blogStyleText string
+	if *blogStyle != "" {
+		data, err := os.ReadFile(*blogStyle)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading blog style: %v\n", err)
+			os.Exit(1)
+		}
+		blogStyleText = string(data)
+	}
+
+	if *promptFile != "" {
+		data, err := os.ReadFile(*promptFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading prompt template: %v\n", err)
+			os.Exit(1)
+		}
+		config.PromptTemplate = string(data)
+	}
+
+	var terms []translationTerm
+	if *translationGlossaryPath != "" {
+		if terms, err = loadTranslationGlossary(*translationGlossaryPath); err != nil {
+			fmt.Fprintf(stderr, "Error loading translation glossary: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var glossary []glossaryTerm
+	if *glossaryPath != "" {
+		glossary, err = loadGlossary(*glossaryPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading glossary: %v\n", err)
+			os.Exit(1)
+		}
+		if *showName != "" && !set["glossary"] {
+			// Terms the show's transcriptions are known to mis-hear, e.g. as
+			// learned in review, are named to the models after its vocabulary
+			known := map[string]bool{}
+			for _, v := range config.Vocabulary {
+				known[strings.ToLower(v)] = true
+			}
+			for _, term := range misheardTerms(glossary) {
+				if !known[strings.ToLower(term)] {
+					config.Vocabulary = append(config.Vocabulary, term)
+				}
+			}
+		}
+	}
+
+	if *examplesList != "" {
+		examples, err := loadExamples(strings.Split(*examplesList, ","), *exampleTokens)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading examples: %v\n", err)
+			os.Exit(1)
+		}
+		config.Examples = examples
+	}
+
+	if *guestsPath != "" {
+		guests, err := readGuests(*guestsPath, *date, config.SpeakerNames)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading guests: %v\n", err)
+			os.Exit(1)
+		}
+		if len(guests) == 0 {
+			p.console.warnf("%s lists no guests for %s\n", filepath.Base(*guestsPath), *date)
+		} else if !set["speakers"] {
+			// The hosts, or at least one, and the guests
+			*numSpeakers = max(len(config.SpeakerNames), 1) + len(guests)
+		}
+		config.Guests = guests
+	}
+
+	var audioURL string
+	if isRemoteAudio(*audioPath) {
+		downloaded, cleanupDownload, err := p.downloadAudio(context.Background(), *audioPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error downloading audio: %v\n", err)
+			os.Exit(1)
+		}
+		defer cleanupDownload()
+		audioURL, *audioPath = *audioPath, downloaded
+	}
+
+	p.loadEpisodeContext(*audioPath)
+
+	if !setFlags()["monthly-budget"] {
+		*monthlyBudget = fileConfig.MonthlyBudget
+	}
+	if !*overrideBudget && *replayDir == "" {
+		if err := state.checkBudget(*monthlyBudget); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	config.Speakers = *numSpeakers
+	switch *cleanupMode {
+	case "", "none", "rules", "llm":
+	default:
+		fmt.Fprintf(stderr, "Error: unknown -cleanup mode %q (available: rules, llm)\n", *cleanupMode)
+		os.Exit(1)
+	}
+	normalize, err := parseNormalize(*normalizeList)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	be, err := lookupBackend(*backendName)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !setFlags()["transcription-model"] {
+		config.TranscriptionModel = be.defaultModel
+	}
+	if *backendName == "openai" && diarizingModel(config.TranscriptionModel) {
+		be.diarizes = true
+	}
+	var ensemble *backend
+	if *ensembleName != "" {
+		other, err := lookupBackend(*ensembleName)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: -ensemble: %v\n", err)
+			os.Exit(1)
+		}
+		switch {
+		case *ensembleName == *backendName:
+			fmt.Fprintln(stderr, "Error: -ensemble needs a backend other than -backend")
+			os.Exit(1)
+		case *chunkLength > 0:
+			fmt.Fprintln(stderr, "Error: -ensemble can't be combined with -chunk")
+			os.Exit(1)
+		}
+		ensemble = &other
+		// Words are compared by when they are spoken
+		config.WordTimestamps = true
+	}
+	if imported != nil {
+		// The imported transcript takes the place of the backend's
+		be.diarizes = imported.diarized()
+	}
+
+	var diarizerPath string
+	if *diarizerName != "" {
+		if diarizerPath, _, err = lookupPlugin(*diarizerName, "diarize"); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Get the OpenAI API key from the environment; it is needed for the LLM stages
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" && *replayDir != "" {
+		apiKey = replayKey
+	}
+	llmDiarize := (!be.diarizes || *rediarize) && diarizerPath == ""
+	if *streamPath != "" {
+		switch {
+		case *chunkLength == 0 || !llmDiarize:
+			fmt.Fprintln(stderr, "Error: -stream writes the turns of each -chunk as the chat model diarizes it; add -chunk, with a backend that doesn't diarize or with -rediarize")
+			os.Exit(1)
+		case config.EncryptionKey != nil:
+			fmt.Fprintln(stderr, "Error: -stream writes the transcript in the clear, so it can't be used with -encryption-key")
+			os.Exit(1)
+		case *anonymizeFlag:
+			fmt.Fprintln(stderr, "Error: -stream writes each chunk's turns before -anonymize replaces the names in them, so the two can't be used together")
+			os.Exit(1)
+		}
+	}
+	if apiKey == "" && (llmDiarize || *nameSpeakersFlag || *speakerRolesFlag || *anonymizeFlag || languages != nil || len(passes) > 0 || *chaptersFlag || config.Summarize || *blogFlag || *minutesFlag || *studyNotesFlag || *newsletterFlag || *socialFlag || *titleVariants > 0 || *clipCount > 0 || *cleanupMode == "llm") {
+		fmt.Fprintln(stderr, "Please set the OPENAI_API_KEY environment variable")
+		os.Exit(1)
+	}
+
+	if *sampleSpec != "" {
+		backendKey, err := be.apiKey()
+		if err != nil && *replayDir != "" {
+			backendKey, err = replayKey, nil
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			os.Exit(1)
+		}
+		if err := p.runSample(be, backendKey, apiKey, *audioPath, *sampleSpec, *numSpeakers, llmDiarize, normalize, glossary); err != nil {
+			fmt.Fprintf(stderr, "Error sampling audio: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	manifest, err := newManifest(*audioPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error preparing manifest: %v\n", err)
+		os.Exit(1)
+	}
+	if p.notify != nil {
+		p.notify.setBase(notification{Input: firstNonEmpty(audioURL, *audioPath, *importTranscript), Title: config.Title, OutputDir: absDir(*outputDir)})
+		manifest.onStage = p.notify.enterStage
+	}
+	if *audioPath != "" && *replayDir == "" {
+		// Runs that exit on an error leave the job running, which the status
+		// command shows as failed once the output directory is unlocked
+		job, err := state.startJob(firstNonEmpty(audioURL, *audioPath), *outputDir, os.Args[1:])
+		if err != nil {
+			p.console.warnf("failed to record the job in the state store: %v\n", err)
+		} else {
+			p.notify.setJob(job)
+			manifest.onStage = func(name string) {
+				p.notify.enterStage(name)
+				if err := state.jobStage(job, name); err != nil {
+					p.console.warnf("failed to record the job's stage: %v\n", err)
+				}
+			}
+			defer func() {
+				if err := state.finishJob(job, jobDone, ""); err != nil {
+					p.console.warnf("failed to record the job as done: %v\n", err)
+				}
+			}()
+			// A SIGTERM, which preemptible VMs get before they're reclaimed,
+			// re-queues the job; a chunked run resumes from its checkpoint
+			terminated := make(chan os.Signal, 1)
+			signal.Notify(terminated, syscall.SIGTERM)
+			go func() {
+				<-terminated
+				if err := state.requeueJob(job); err != nil {
+					p.console.warnf("failed to re-queue the job: %v\n", err)
+				}
+				p.exitf(143, "Error: terminated; job %s was re-queued and resumes when run again\n", job)
+			}()
+		}
+	}
+	p.publish(notification{Event: notifyJobStarted})
+	var sliceStart float64
+	episodeName := filepath.Base(*audioPath)
+	if *audioPath != "" && *skipBoilerplate && *fromFlag == "" && *toFlag == "" {
+		// Cut like a -from and -to slice, so every time stays the episode's
+		duration, _ := probeDuration(*audioPath)
+		*fromFlag, *toFlag = intros.skipRange(duration)
+		if *fromFlag != "" || *toFlag != "" {
+			manifest.Parameters["skip_boilerplate"] = true
+			p.console.progressf("Skipping the intro and outro learned in %s\n", *boilerplatePath)
+		}
+	}
+	if *audioPath != "" && !haveTool("ffmpeg") {
+		// Without ffmpeg, audio is probed and cut but never decoded
+		for _, c := range []struct {
+			flag string
+			on   bool
+		}{
+			{"exclude", len(excluded) > 0},
+			{"speedup", config.Speedup > 1},
+			{"retry-suspect", config.RetrySuspect},
+			{"clip-dir", *clipDir != ""},
+			{"snippet-dir", *snippetDir != ""},
+		} {
+			if c.on {
+				p.fatalf("Error: -%s needs ffmpeg, which is not installed\n", c.flag)
+			}
+		}
+	}
+	if *audioPath != "" && (*chunkLength > 0 || *fromFlag != "" || *toFlag != "") {
+		cutBy := "-from/-to"
+		switch {
+		case *chunkLength > 0:
+			cutBy = "-chunk"
+		case manifest.Parameters["skip_boilerplate"] == true:
+			cutBy = "-skip-boilerplate"
+		}
+		if err := checkCuttable(*audioPath, cutBy); err != nil {
+			p.fatalf("Error: %v\n", err)
+		}
+	}
+	if *audioPath != "" && (*fromFlag != "" || *toFlag != "") {
+		// Transcribe only part of the audio; the cut is cached and fingerprinted
+		// like any other audio
+		slice, start, cleanupSlice, err := p.sliceAudio(context.Background(), *audioPath, *fromFlag, *toFlag)
+		if err != nil {
+			p.fatalf("Error: %v\n", err)
+		}
+		defer cleanupSlice()
+		*audioPath, sliceStart = slice, start
+		manifest.Parameters["from"] = *fromFlag
+		manifest.Parameters["to"] = *toFlag
+	}
+	// The excluded ranges, on the timeline of the audio transcribed
+	var excludedHere []timeRange
+	if *audioPath != "" && len(excluded) > 0 {
+		// Silenced rather than cut out, so that every time stays the episode's;
+		// the copy is fingerprinted like any other audio
+		excludedHere = shiftRanges(excluded, -sliceStart)
+		muted, cleanupMuted, err := p.muteRanges(context.Background(), *audioPath, excludedHere)
+		if err != nil {
+			p.fatalf("Error excluding time ranges: %v\n", err)
+		}
+		defer cleanupMuted()
+		*audioPath = muted
+		manifest.Excluded = excluded
+		p.console.progressf("Excluding %d time range(s) from %s\n", len(excluded), *excludePath)
+	}
+	// The audio as it was before -speedup, which the stages after
+	// transcription work on
+	unsped := ""
+	if *audioPath != "" && config.Speedup > 1 {
+		// The sped-up copy is fingerprinted like any other audio, so its
+		// transcription is cached apart from the audio's own
+		sped, cleanupSped, err := p.speedUpAudio(context.Background(), *audioPath, config.Speedup)
+		if err != nil {
+			p.fatalf("Error speeding up audio: %v\n", err)
+		}
+		defer cleanupSped()
+		unsped, *audioPath = *audioPath, sped
+		manifest.Parameters["speedup"] = config.Speedup
+		p.console.progressf("Transcribing the audio sped up %gx\n", config.Speedup)
+	}
+	var audioSeconds float64
+	if *audioPath != "" {
+		// Stage timeouts scale with the audio length
+		audioSeconds = audioDuration(*audioPath)
+	}
+	manifest.Parameters["backend"] = *backendName
+	if ensemble != nil {
+		manifest.Parameters["ensemble"] = *ensembleName
+	}
+	if audioURL != "" {
+		manifest.Parameters["audio_url"] = audioURL
+	}
+	manifest.Parameters["speakers"] = *numSpeakers
+	manifest.Parameters["temperature"] = config.Temperature
+	if config.TopP > 0 {
+		manifest.Parameters["top_p"] = config.TopP
+	}
+	if config.MaxOutputTokens > 0 {
+		manifest.Parameters["max_output_tokens"] = config.MaxOutputTokens
+	}
+	if config.Seed != nil {
+		manifest.Parameters["seed"] = *config.Seed
+	}
+	manifest.Parameters["json_mode"] = config.StructuredOutput
+	if config.Batch {
+		manifest.Parameters["batch"] = true
+	}
+	if *profileName != "" {
+		manifest.Parameters["profile"] = *profileName
+	}
+	if config.VerifyWords {
+		manifest.Parameters["max_drift"] = config.MaxWordDrift
+	}
+	manifest.Parameters["custom_prompt"] = *promptFile != ""
+	if config.ChapterDiarization {
+		manifest.Parameters["diarize_by_chapter"] = true
+	}
+	if config.SpeakerGap > 0 {
+		manifest.Parameters["speaker_gap"] = config.SpeakerGap
+	}
+	manifest.Parameters["few_shot_examples"] = len(config.Examples)
+	if *showName != "" {
+		manifest.Parameters["show"] = *showName
+	}
+	manifest.Parameters["formats"] = formats
+	if config.BOM {
+		manifest.Parameters["bom"] = true
+	}
+	if config.LineEndings != "lf" {
+		manifest.Parameters["line_endings"] = config.LineEndings
+	}
+	if config.WrapWidth > 0 {
+		manifest.Parameters["wrap"] = config.WrapWidth
+	}
+	if config.Timestamps != "" {
+		manifest.Parameters["timestamps"] = config.Timestamps
+	}
+	if config.WordTimestamps {
+		manifest.Parameters["word_timestamps"] = true
+	}
+	if config.EncryptionKey != nil {
+		manifest.Parameters["encryption"] = "aes-256-gcm"
+	}
+	if *anonymizeFlag {
+		manifest.Parameters["anonymize"] = true
+	}
+	if withhold != nil {
+		manifest.Parameters["withhold_style"] = *withholdStyle
+		if *withholdSpeakers != "" {
+			manifest.Parameters["withhold_speakers"] = *withholdSpeakers
+		}
+		if *offRecord != "" {
+			manifest.Parameters["off_record"] = withhold.ranges
+		}
+	}
+	if config.Lookup != "" {
+		manifest.Parameters["lookup"] = config.Lookup
+	}
+	if len(config.Guests) > 0 {
+		manifest.Parameters["guests"] = config.Guests
+	}
+	if config.Verbatim {
+		manifest.Parameters["verbatim"] = true
+	}
+	if config.Threads > 0 {
+		manifest.Parameters["threads"] = config.Threads
+	}
+	if config.GPUs != "auto" {
+		manifest.Parameters["gpu"] = config.GPUs
+	}
+	if *chaptersFlag {
+		manifest.Parameters["chapters"] = map[string]any{"min": chapterLimit.min, "max": chapterLimit.max, "count": chapterLimit.count}
+	}
+	if *summaryPreset != "" {
+		manifest.Parameters["summary_preset"] = *summaryPreset
+	}
+	if config.ASSStyles != "" {
+		manifest.Parameters["ass_style"] = config.ASSStyles
+	}
+	if config.Offset != 0 {
+		manifest.Parameters["offset"] = config.Offset
+	}
+	if languages != nil {
+		manifest.Parameters["languages"] = languages
+	}
+	if len(routes) > 0 {
+		manifest.Parameters["translate"] = routes
+	}
+	if len(targets) > 0 {
+		manifest.Parameters["translate_to"] = targets
+	}
+	if config.Drift != 0 {
+		manifest.Parameters["drift"] = config.Drift
+	}
+
+	// Look up the episode's canonical record; like the feed, it's optional
+	var podcast *PodcastMetadata
+	if config.Lookup != "" {
+		stage := manifest.beginStage("lookup", config.Lookup, lookupEndpoint(config.Lookup))
+		ctx, cancel := context.WithTimeout(context.Background(), config.HTTPTimeout)
+		podcast, err = p.lookupPodcast(ctx, firstNonEmpty(audioURL, *audioPath))
+		cancel()
+		switch {
+		case err != nil:
+			p.console.warnf("failed to look up the episode in %s: %v\n", config.Lookup, err)
+		case podcast == nil:
+			p.console.warnf("%s doesn't list the episode\n", config.Lookup)
+		default:
+			p.console.progressf("Found the episode in %s: %s, GUID %s\n", config.Lookup, podcast.Show, firstNonEmpty(podcast.EpisodeGUID, "unknown"))
+		}
+		stage.end(manifest, nil)
+	}
+
+	// Reuse the cached transcription if there is one and it is of this audio
+	stage := manifest.beginStage("transcription", config.TranscriptionModel, be.endpoint)
+	var fingerprint *AudioFingerprint
+	if *audioPath != "" {
+		if fingerprint, err = fingerprintAudio(*audioPath); err != nil {
+			p.fatalf("Error fingerprinting audio: %v\n", err)
+		}
+	}
+	transcript, err := p.loadCachedTranscription()
+	var (
+		previous       *Transcript
+		pipelinedTurns []Segment
+		pipelineStart  time.Time
+		pipelineUsage  TokenUsage
+		seams          *chunkReport
+	)
+	if err == nil && !*rediarize && fingerprint != nil && transcript.Source != nil && transcript.Source.SHA256 != fingerprint.SHA256 {
+		if fingerprint.extends(transcript.Source) {
+			previous = transcript
+		} else {
+			p.console.warnf("the cached transcription is of different audio; transcribing again\n")
+		}
+		err = errors.New("cached transcription is of different audio")
+	}
+	if err != nil && !*rediarize && fingerprint != nil && *onDuplicate != "process" && *replayDir == "" {
+		// Re-downloaded audio of an episode already in the archive
+		dup, derr := state.findDuplicate(fingerprint, absDir(*outputDir))
+		if derr != nil {
+			p.console.warnf("failed to check for an earlier run on this audio: %v\n", derr)
+		}
+		if dup != nil {
+			about := dup.Dir
+			if dup.Title != "" {
+				about = fmt.Sprintf("%q in %s", dup.Title, dup.Dir)
+			}
+			if *onDuplicate == "link" {
+				linked, err := linkDuplicate(dup, absDir(*outputDir))
+				if err != nil {
+					p.fatalf("Error: %v\n", err)
+				}
+				if p.console.quiet {
+					fmt.Fprintln(p.console.out, strings.Join(linked, "\n"))
+					return
+				}
+				p.console.progressf("This audio was already processed on %s as %s; linked its %d output(s) instead of processing it again\n", dup.Processed.Format("2006-01-02"), about, len(linked))
+				return
+			}
+			p.console.progressf("This audio was already processed on %s as %s; skipping it (use -on-duplicate process to process it again)\n", dup.Processed.Format("2006-01-02"), about)
+			return
+		}
+	}
+	switch {
+	case imported != nil:
+		stage.Model, stage.Endpoint = "imported "+importedFormat, ""
+		transcript = imported
+		transcript.Models = map[string]string{"transcription": stage.Model}
+		p.console.progressf("Imported %d segments from %s (%s)\n", len(transcript.Segments), *importTranscript, importedFormat)
+
+		// Saved as the transcription so that -rediarize and -reexport reuse it
+		if err := p.writeOutput(config.TranscriptionFile, []byte(transcript.Text)); err != nil {
+			p.fatalf("Error writing transcription to file: %v\n", err)
+		}
+		if err := p.saveTranscript(config.TranscriptionJSONFile, transcript); err != nil {
+			p.fatalf("Error writing transcription to file: %v\n", err)
+		}
+	case err == nil:
+		stage.Cached = true
+		p.console.progressf("Loaded transcription from cache\n")
+	case *rediarize:
+		p.fatalf("Error: -rediarize needs a cached transcription: %v\n", err)
+	default:
+		backendKey, err := be.apiKey()
+		if err != nil && *replayDir != "" {
+			backendKey, err = replayKey, nil
+		}
+		if err != nil {
+			p.fatalf("%v\n", err)
+		}
+		if previous == nil && *replayDir == "" {
+			// Guard against transcribing a livestream recording by mistake
+			if err := p.confirmDuration(audioSeconds, *maxDuration, *assumeYes); err != nil {
+				p.fatalf("Error: %v\n", err)
+			}
+		}
+		if previous != nil || *chunkLength > 0 || *backendName == "local" {
+			// These stages write copies of the audio to the cache directory
+			if err := checkDiskSpace(config.CacheDir, manifest.Input.Size); err != nil {
+				p.fatalf("Error: %v\n", err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.transcriptionTimeout(audioSeconds))
+		defer cancel()
+		if previous != nil {
+			// The audio was extended; only the new tail needs transcribing
+			transcript, err = p.extendTranscription(ctx, be, backendKey, previous, *audioPath)
+			if err != nil {
+				p.console.warnf("failed to transcribe only the appended audio (%v); transcribing all of it\n", err)
+				previous = nil
+			} else {
+				p.console.progressf("Transcribed the audio appended since the cached transcription\n")
+			}
+		}
+		switch {
+		case previous != nil:
+			// Extended from the cached transcription above
+		case *chunkLength > 0 && llmDiarize:
+			// Diarize finished chunks while later ones are still being transcribed
+			if *replayDir == "" {
+				checkpoint, cerr := loadCheckpoint(filepath.Join(*outputDir, checkpointFile), config.EncryptionKey, fingerprint, config.TranscriptionModel, chunkLength.Seconds(), p.diarizationSettings(*numSpeakers, *cleanupMode))
+				if cerr != nil {
+					p.console.warnf("%v; starting over\n", cerr)
+				} else if n := len(checkpoint.Chunks); n > 0 {
+					p.console.progressf("Resuming from the checkpoint of %d chunk(s)\n", n)
+				}
+				p.checkpoint = checkpoint
+			}
+			if *streamPath != "" {
+				at := func(x float64) float64 { return x*config.Speedup + sliceStart }
+				if p.stream, err = openTranscriptStream(*streamPath, p.renderOptions(), at); err != nil {
+					p.fatalf("Error: %v\n", err)
+				}
+				p.console.progressf("Streaming the transcript to %s as chunks are diarized\n", *streamPath)
+			}
+			transcript, pipelinedTurns, pipelineStart, pipelineUsage, err = p.transcribeAndDiarize(context.Background(),
+				be, backendKey, apiKey, *audioPath, chunkLength.Seconds(), *numSpeakers, *cleanupMode)
+			if err != nil {
+				p.fatalf("Error transcribing audio: %v\n", err)
+			}
+		case ensemble != nil:
+			var n int
+			transcript, n, err = p.transcribeEnsemble(ctx, be, backendKey, *ensemble, *audioPath)
+			if err != nil {
+				p.fatalf("Error transcribing audio: %v\n", err)
+			}
+			p.console.progressf("Merged the %s transcription: %d of %d segment(s) took its words\n", *ensembleName, n, len(transcript.Segments))
+		default:
+			transcript, err = be.transcribe(p, ctx, backendKey, *audioPath)
+			if err != nil {
+				p.fatalf("Error transcribing audio: %v\n", err)
+			}
+		}
 
-func main() {
-	// Parse command-line arguments
-	audioPath := flag.String("audio", "", "Path to the audio file")
-	numSpeakers := flag.Int("speakers", 2, "Number of speakers in the podcast")
-	flag.Parse()
+		if transcript.Text == "" && previous == nil && pipelinedTurns == nil && audioSeconds > 0 {
+			// Whisper sometimes returns nothing for audio that has speech
+			retry, err := p.retryRegion(context.Background(), be, backendKey, *audioPath, 0, audioSeconds)
+			if err == nil && retry.Text != "" {
+				p.console.progressf("The transcription came back empty; transcribed again with different parameters\n")
+				transcript = retry
+			}
+		}
+		issues := assessTranscript(transcript)
+		if len(issues) > 0 && config.RetrySuspect && !be.diarizes && pipelinedTurns == nil {
+			issues = p.retrySuspectRegions(context.Background(), be, backendKey, *audioPath, transcript, issues)
+		}
+		manifest.Warnings = append(manifest.Warnings, p.reportQuality(issues)...)
 
-	if *audioPath == "" {
-		fmt.Fprintln(os.Stderr, "Please provide the path to the audio file using -audio")
-		os.Exit(1)
+		if unsped != "" {
+			transcript.slowDown(config.Speedup)
+			if pipelinedTurns != nil {
+				(&Transcript{Segments: pipelinedTurns}).slowDown(config.Speedup)
+			}
+		}
+		if pipelinedTurns != nil {
+			seams = buildChunkReport(transcript.Segments, pipelinedTurns, transcript.Duration, chunkLength.Seconds()*config.Speedup)
+		}
+
+		transcript.Models = map[string]string{"transcription": config.TranscriptionModel}
+		transcript.Source = fingerprint
+		transcript.Audio = episodeName
+
+		// Save the transcription to transcription.txt and transcription.json
+		if err := p.writeOutput(config.TranscriptionFile, []byte(transcript.Text)); err != nil {
+			p.fatalf("Error writing transcription to file: %v\n", err)
+		}
+		if err := p.saveTranscript(config.TranscriptionJSONFile, transcript); err != nil {
+			p.fatalf("Error writing transcription to file: %v\n", err)
+		}
+		p.console.progressf("Transcription saved to %s\n", config.TranscriptionFile)
+		p.checkpoint.remove()
+	}
+	stage.end(manifest, nil)
+	if unsped != "" {
+		*audioPath = unsped
+	}
+	if len(excludedHere) > 0 {
+		var n int
+		transcript.Segments, n = dropExcluded(transcript.Segments, excludedHere)
+		transcript.Text = joinSegmentText(transcript.Segments)
+		if pipelinedTurns != nil {
+			pipelinedTurns, _ = dropExcluded(pipelinedTurns, excludedHere)
+		}
+		if n > 0 {
+			p.console.progressf("Left out %d segment(s) transcribed in the excluded ranges\n", n)
+		}
+	}
+	if intros != nil {
+		var n int
+		transcript.Segments, n = intros.drop(transcript.Segments)
+		transcript.Text = joinSegmentText(transcript.Segments)
+		if pipelinedTurns != nil {
+			pipelinedTurns, _ = intros.drop(pipelinedTurns)
+		}
+		if n > 0 {
+			p.console.progressf("Left out %d turn(s) of the intro and outro in %s\n", n, *boilerplatePath)
+		}
+		manifest.Parameters["boilerplate"] = *boilerplatePath
+	}
+	if model := transcript.Models["ensemble"]; ensemble != nil && model != "" && !stage.Cached {
+		// The second transcription ran alongside the first
+		started, seconds := stage.StartedAt, stage.DurationSeconds
+		stage = manifest.beginStage("ensemble", model, ensemble.endpoint)
+		stage.StartedAt, stage.DurationSeconds = started, seconds
+	}
+	if pipelinedTurns != nil {
+		// Diarization overlapped the transcription
+		stage = manifest.beginStage("diarization", config.DiarizationModel, config.ChatCompletionsURL)
+		stage.StartedAt = pipelineStart
+		stage.end(manifest, &pipelineUsage)
 	}
 
-	// Get the OpenAI API key from the environment
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		fmt.Fprintln(os.Stderr, "Please set the OPENAI_API_KEY environment variable")
-		os.Exit(1)
+	if *cleanupMode != "" && *cleanupMode != "none" && pipelinedTurns == nil {
+		// Clean up the cached transcription in memory only; files stay raw. The
+		// pipelined run already cleaned up each chunk before diarizing it
+		model, endpoint := "rules", ""
+		if *cleanupMode == "llm" {
+			model, endpoint = config.DiarizationModel, config.ChatCompletionsURL
+		}
+		stage = manifest.beginStage("cleanup", model, endpoint)
+		usage, err := p.cleanupTranscript(context.Background(), apiKey, transcript, *cleanupMode)
+		if err != nil {
+			p.fatalf("Error cleaning up transcription: %v\n", err)
+		}
+		stage.end(manifest, &usage)
 	}
 
-	var transcript string
+	diarized := &Transcript{
+		Title:       config.Title,
+		Description: config.Description,
+		Date:        *date,
+		Audio:       transcript.Audio,
+		Language:    transcript.Language,
+		Duration:    transcript.Duration,
+		Text:        transcript.Text,
+		Chapters:    transcript.Chapters,
+		Entities:    transcript.Entities,
+		Podcast:     podcast,
+		Models:      map[string]string{"transcription": config.TranscriptionModel},
+	}
+	if m := transcript.Models["transcription"]; m != "" {
+		diarized.Models["transcription"] = m
+	}
 
-	// Check if transcription.txt exists
-	if _, err := os.Stat(config.TranscriptionFile); err == nil {
-		// File exists, load it
-		data, err := os.ReadFile(config.TranscriptionFile)
+	acoustic := false
+	if transcript.diarized() && !*rediarize {
+		// The provider already attributed speakers
+		acoustic = true
+		diarized.Segments = transcript.Segments
+		diarized.Models["diarization"] = diarized.Models["transcription"]
+	} else if pipelinedTurns != nil {
+		diarized.Segments = pipelinedTurns
+		diarized.Models["diarization"] = config.DiarizationModel
+	} else if diarizerPath != "" {
+		stage = manifest.beginStage("diarization", *diarizerName, diarizerPath)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(estimateTokens(transcript.Text)))
+		defer cancel()
+		result, err := p.callPlugin(ctx, diarizerPath, pluginRequest{
+			Action:     "diarize",
+			Speakers:   *numSpeakers,
+			Language:   config.Language,
+			Vocabulary: config.Vocabulary,
+			Transcript: transcript,
+		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", config.TranscriptionFile, err)
-			os.Exit(1)
+			p.fatalf("Error diarizing transcript: %v\n", err)
 		}
-		transcript = string(data)
-		fmt.Printf("Loaded transcription from %s\n", config.TranscriptionFile)
+		stage.end(manifest, nil)
+		acoustic = true
+		diarized.Segments = result.Segments
+		diarized.Models["diarization"] = *diarizerName
 	} else {
-		// File doesn't exist, perform transcription
-		ctx, cancel := context.WithTimeout(context.Background(), config.TranscriptionTimeout)
-		defer cancel()
-		transcript, err = transcribeAudio(ctx, apiKey, *audioPath)
+		// Diarize the transcription using the o1 model
+		stage = manifest.beginStage("diarization", config.DiarizationModel, config.ChatCompletionsURL)
+		turns, speakers, usage, err := p.diarizeSpeakers(context.Background(), apiKey, transcript, *numSpeakers, *retrySpeakers)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error transcribing audio: %v\n", err)
-			os.Exit(1)
+			p.fatalf("Error diarizing transcript: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		if speakers != *numSpeakers {
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("diarized again for %d speakers, the number the first attempt found, instead of the %d of -speakers", speakers, *numSpeakers))
+			*numSpeakers = speakers
+		}
+		diarized.Segments = alignTurns(transcript.Segments, turns)
+		diarized.Models["diarization"] = config.DiarizationModel
+	}
+	if found := countSpeakers(diarized.Segments); found > 0 && found != *numSpeakers && (!acoustic || set["speakers"]) {
+		// The labels may split one speaker in two or merge two into one
+		w := fmt.Sprintf("the transcript has %d speaker label(s) but %d speakers were expected; check the labels", found, *numSpeakers)
+		if !acoustic && pipelinedTurns == nil && !*retrySpeakers {
+			w += ", or run with -retry-speakers"
 		}
+		p.console.warnf("%s\n", w)
+		manifest.Warnings = append(manifest.Warnings, w)
+	}
 
-		// Save the transcription to transcription.txt
-		if err := os.WriteFile(config.TranscriptionFile, []byte(transcript), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing transcription to file: %v\n", err)
-			os.Exit(1)
+	if len(normalize) > 0 {
+		stage = manifest.beginStage("normalize", "rules", "")
+		n := normalizeTranscript(diarized, normalize)
+		stage.end(manifest, nil)
+		p.console.progressf("Normalized %d numbers, amounts and acronyms\n", n)
+	}
+
+	if glossary != nil {
+		stage = manifest.beginStage("glossary", "rules", "")
+		corrections := applyGlossary(diarized, glossary)
+		stage.end(manifest, nil)
+		if err := p.writeCorrections(config.CorrectionsFile, corrections); err != nil {
+			p.fatalf("Error writing glossary corrections: %v\n", err)
 		}
-		fmt.Printf("Transcription saved to %s\n", config.TranscriptionFile)
+		total := 0
+		for _, c := range corrections {
+			total += c.Count
+		}
+		p.console.progressf("Glossary corrected %d mis-hearings (%d distinct); see %s\n", total, len(corrections), config.CorrectionsFile)
 	}
 
-	// Diarize the transcription using the o1 model
-	ctx, cancel := context.WithTimeout(context.Background(), config.DiarizationTimeout)
-	defer cancel()
-	diarizedTranscript, err := diarizeTranscript(ctx, apiKey, transcript, *numSpeakers)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error diarizing transcript: %v\n", err)
-		os.Exit(1)
+	if config.Verbatim {
+		cert, err := certifyVerbatim(transcript, diarized)
+		if err != nil {
+			p.fatalf("Error: -verbatim: %v\n", err)
+		}
+		manifest.Verbatim = cert
+		p.console.progressf("Checked the transcript's %d words against the transcription\n", cert.Words)
 	}
 
-	// Write the diarized transcript to diarized.txt
-	if err = os.WriteFile(config.DiarizedFile, []byte("=== Diarized Transcript ===\n"+diarizedTranscript+"\n"), 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing diarized transcript to file: %v\n", err)
-		os.Exit(1)
+	if *nameSpeakersFlag {
+		stage = manifest.beginStage("speaker-naming", config.DiarizationModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(0))
+		infos, usage, err := p.nameSpeakers(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error naming speakers: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		applySpeakerNames(diarized, infos)
+		diarized.Models["speaker_naming"] = config.DiarizationModel
 	}
 
-	fmt.Printf("Diarized transcript saved to %s\n", config.DiarizedFile)
-}
+	if *speakerRolesFlag {
+		stage = manifest.beginStage("speaker-roles", config.DiarizationModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(0))
+		infos, usage, err := p.classifySpeakerRoles(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error classifying speaker roles: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		diarized.Speakers = infos
+		diarized.Models["speaker_roles"] = config.DiarizationModel
+	}
 
-// transcribeAudio uploads the audio file to OpenAI's Whisper API and returns the transcription text.
-func transcribeAudio(ctx context.Context, apiKey, audioPath string) (string, error) {
-	fileInfo, err := os.Stat(audioPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to get file info: %v", err)
+	if languages != nil {
+		stage = manifest.beginStage("languages", config.DiarizationModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(10*len(diarized.Segments)))
+		usage, err := p.tagLanguages(ctx, apiKey, diarized, languages)
+		cancel()
+		if err != nil {
+			p.fatalf("Error tagging languages: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		diarized.Models["languages"] = config.DiarizationModel
+	} else if len(passes) > 0 && config.Language != "" {
+		// The turns are all in the declared language
+		diarized.Language = config.Language
+	}
+	if len(passes) > 0 {
+		stage = manifest.beginStage("translation", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(estimateTokens(joinSegmentText(diarized.Segments))))
+		usage, misses, err := p.translateTurns(ctx, apiKey, diarized, passes, terms)
+		cancel()
+		if err != nil {
+			p.fatalf("Error translating: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		diarized.Models["translation"] = config.SummaryModel
+		if terms != nil {
+			if err := p.writeTranslationMisses(config.TranslationMissesFile, misses); err != nil {
+				p.fatalf("Error writing translation glossary misses: %v\n", err)
+			}
+			if len(misses) > 0 {
+				p.console.warnf("%d translated turn(s) leave out a glossary term's translation; see %s\n", len(misses), config.TranslationMissesFile)
+			}
+		}
 	}
-	if fileInfo.Size() > config.MaxAudioFileSize {
-		return "", fmt.Errorf("audio file too large: %d bytes (max: %d bytes)", fileInfo.Size(), config.MaxAudioFileSize)
+
+	if *annotate {
+		ctx, cancel := context.WithTimeout(context.Background(), p.transcriptionTimeout(audioSeconds))
+		err := p.annotateEvents(ctx, diarized, transcript.Segments, *audioPath, *pauseSeconds)
+		cancel()
+		if err != nil {
+			p.fatalf("Error annotating events: %v\n", err)
+		}
 	}
 
-	file, err := os.Open(audioPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open audio file: %v", err)
+	diarized.Overlaps = detectCrosstalk(diarized.Segments, config.MinCrosstalk)
+	if n := len(diarized.Overlaps); n > 0 {
+		p.console.progressf("Found %d crosstalk regions; these are the turns most likely to need review\n", n)
 	}
-	defer func() {
-		if cerr := file.Close(); cerr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing audio file: %v\n", cerr)
+	if acoustic {
+		scoreSpeakerConfidence(diarized.Segments)
+		if *reviewThreshold > 0 {
+			n := flagForReview(diarized.Segments, *reviewThreshold)
+			p.console.progressf("Flagged %d turns with speaker confidence below %.2f for review\n", n, *reviewThreshold)
 		}
-	}()
+	}
+
+	if *anonymizeFlag {
+		stage = manifest.beginStage("anonymize", config.DiarizationModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(0))
+		people, usage, err := p.findPeople(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error anonymizing: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		n := newPseudonymizer(diarized, people).anonymize(diarized)
+		diarized.Models["anonymize"] = config.DiarizationModel
+		// The later stages' prompts mustn't bring the names back
+		config.Title, config.Description, config.SpeakerNames, config.Guests = diarized.Title, diarized.Description, nil, nil
+		p.console.progressf("Anonymized %d speakers and %d mentions of names\n", len(diarized.speakers()), n)
+	}
+
+	if *chaptersFlag {
+		stage = manifest.beginStage("chapters", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(0))
+		usage, err := p.buildChapters(ctx, apiKey, diarized, chapterLimit)
+		cancel()
+		if err != nil {
+			p.fatalf("Error building chapters: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		diarized.Models["chapters"] = config.SummaryModel
+		p.console.progressf("Wrote %d chapters\n", len(diarized.Chapters))
+	}
+	if config.Summarize {
+		stage = manifest.beginStage("summary", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(0))
+		summary, usage, err := p.summarizeTranscript(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error summarizing transcript: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		diarized.Summary = summary
+		diarized.Models["summary"] = config.SummaryModel
+	}
+	var blog string
+	if *blogFlag {
+		stage = manifest.beginStage("blog", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(draftTokens))
+		draft, usage, err := p.blogDraft(ctx, apiKey, diarized, blogStyleText)
+		cancel()
+		if err != nil {
+			p.fatalf("Error drafting blog post: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		blog = draft
+		diarized.Models["blog"] = config.SummaryModel
+	}
+	var meeting *minutes
+	if *minutesFlag {
+		stage = manifest.beginStage("minutes", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(draftTokens))
+		m, usage, err := p.draftMinutes(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error drafting minutes: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		meeting = m
+		diarized.Models["minutes"] = config.SummaryModel
+		p.console.progressf("Drafted minutes with %d decision(s) and %d action item(s)\n", len(m.Decisions), len(m.ActionItems))
+	}
+	var notes *studyNotes
+	if *studyNotesFlag {
+		stage = manifest.beginStage("study-notes", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(draftTokens))
+		n, usage, err := p.draftStudyNotes(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error drafting study notes: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		notes = n
+		diarized.Models["study_notes"] = config.SummaryModel
+		p.console.progressf("Drafted study notes with %d concept(s) and %d quiz question(s)\n", len(n.Concepts), len(n.Quiz))
+	}
+	var letter *newsletter
+	if *newsletterFlag {
+		stage = manifest.beginStage("newsletter", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(draftTokens))
+		n, usage, err := p.draftNewsletter(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error drafting newsletter: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		letter = n
+		diarized.Models["newsletter"] = config.SummaryModel
+	}
+	var social []string
+	if *socialFlag {
+		stage = manifest.beginStage("social", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(draftTokens))
+		pack, usage, err := p.draftSocialPack(ctx, apiKey, diarized)
+		cancel()
+		if err != nil {
+			p.fatalf("Error drafting social posts: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		thread, linkedIn, youTube := pack.render(diarized)
+		social = []string{thread, linkedIn, youTube}
+		diarized.Models["social"] = config.SummaryModel
+	}
+	var metadata *episodeMetadata
+	if *titleVariants > 0 {
+		stage = manifest.beginStage("title-variants", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(draftTokens))
+		m, usage, err := p.draftTitleVariants(ctx, apiKey, diarized, *titleVariants)
+		cancel()
+		if err != nil {
+			p.fatalf("Error drafting title variants: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		metadata = m
+		diarized.Models["title_variants"] = config.SummaryModel
+		variants, platforms := m.counts()
+		p.console.progressf("Drafted %d title and description variants for %d platforms\n", variants, platforms)
+	}
+	var clips []clip
+	if *clipCount > 0 {
+		stage = manifest.beginStage("clips", config.SummaryModel, config.ChatCompletionsURL)
+		ctx, cancel := context.WithTimeout(context.Background(), p.chatTimeout(draftTokens))
+		suggested, usage, err := p.suggestClips(ctx, apiKey, diarized, *clipCount)
+		cancel()
+		if err != nil {
+			p.fatalf("Error suggesting clips: %v\n", err)
+		}
+		stage.end(manifest, &usage)
+		clips = suggested
+		diarized.Models["clips"] = config.SummaryModel
+		if len(clips) < *clipCount {
+			p.console.warnf("only %d of %d clip(s) fit in %.0f-%.0f seconds\n", len(clips), *clipCount, minClip, maxClip)
+		}
+		if *clipDir != "" && len(clips) > 0 {
+			if *audioPath == "" {
+				p.console.warnf("-clip-dir needs the audio; clips not cut\n")
+			} else if err := p.cutClips(context.Background(), *audioPath, *clipDir, clips); err != nil {
+				p.fatalf("Error cutting clips: %v\n", err)
+			}
+		}
+	}
+	if *snippetDir != "" {
+		if *audioPath == "" {
+			p.console.warnf("-snippet-dir needs the audio; snippets not cut\n")
+		} else {
+			n, err := p.cutSnippets(context.Background(), *audioPath, *snippetDir, diarized, sliceStart)
+			if err != nil {
+				p.fatalf("Error cutting snippets: %v\n", err)
+			}
+			p.console.progressf("Cut %d turn snippet(s) into %s\n", n, *snippetDir)
+		}
+	}
+	if sliceStart > 0 {
+		// Timestamps refer to the whole episode, not the slice
+		diarized.shift(sliceStart)
+	}
+	if err := p.saveTranscript(config.DiarizedJSONFile, diarized); err != nil {
+		p.fatalf("Error writing diarized transcript to file: %v\n", err)
+	}
 
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
+	if config.WordTimestamps && !diarized.hasWords() {
+		p.console.warnf("the transcript has no word timings, e.g. because the transcription was cached without them; karaoke captions spread each turn's words evenly\n")
+	}
 
-	part, err := writer.CreateFormFile("file", filepath.Base(audioPath))
+	// Write the diarized transcript in every requested format
+	paths, err := p.exportAll(diarized, formats, "")
+	if err != nil {
+		p.fatalf("Error writing diarized transcript to file: %v\n", err)
+	}
+	translations, err := p.exportTranslations(diarized, passes, formats)
 	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %v", err)
+		p.fatalf("Error writing translated transcript to file: %v\n", err)
+	}
+	paths = append(paths, translations...)
+	if withhold != nil {
+		approval, err := p.exportApproval(diarized, withhold, formats)
+		if err != nil {
+			p.fatalf("Error writing the approval copy: %v\n", err)
+		}
+		paths = append(paths, approval...)
+	}
+
+	manifest.Outputs = []string{config.TranscriptionFile, config.TranscriptionJSONFile, config.DiarizedJSONFile}
+	for _, path := range paths {
+		if path != config.DiarizedJSONFile {
+			manifest.Outputs = append(manifest.Outputs, path)
+		}
+	}
+	if *onTranscript != "" {
+		payload := newHookPayload("transcript", diarized, *audioPath, config.DiarizedJSONFile, manifest.Outputs)
+		if err := p.runHook("on-transcript", *onTranscript, payload); err != nil {
+			// The outputs are written; a failed hook shouldn't fail the run
+			p.console.warnf("%v\n", err)
+			manifest.Warnings = append(manifest.Warnings, err.Error())
+		}
+	}
+	if *chaptersFlag {
+		published := diarized
+		if config.Offset != 0 || config.Drift != 0 {
+			published = diarized.retimed(config.Offset, config.Drift)
+		}
+		data, err := renderPodcastChapters(published)
+		if err == nil {
+			err = p.writeOutput(config.ChaptersFile, data)
+		}
+		if err != nil {
+			p.fatalf("Error writing chapters: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.ChaptersFile)
+	}
+	if blog != "" {
+		if err := p.writeOutput(config.BlogFile, []byte(blog)); err != nil {
+			p.fatalf("Error writing blog draft: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.BlogFile)
+	}
+	if meeting != nil {
+		data, err := meeting.json()
+		if err == nil {
+			err = p.writeOutput(config.MinutesJSONFile, data)
+		}
+		if err == nil {
+			err = p.writeOutput(config.MinutesFile, []byte(meeting.markdown()))
+		}
+		if err != nil {
+			p.fatalf("Error writing minutes: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.MinutesFile, config.MinutesJSONFile)
+	}
+	if notes != nil {
+		data, err := notes.json()
+		if err == nil {
+			err = p.writeOutput(config.StudyNotesJSONFile, data)
+		}
+		if err == nil {
+			err = p.writeOutput(config.StudyNotesFile, []byte(notes.markdown()))
+		}
+		if err != nil {
+			p.fatalf("Error writing study notes: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.StudyNotesFile, config.StudyNotesJSONFile)
+	}
+	if letter != nil {
+		html, err := letter.html()
+		if err == nil {
+			err = p.writeOutput(config.NewsletterHTMLFile, []byte(html))
+		}
+		if err == nil {
+			err = p.writeOutput(config.NewsletterTextFile, []byte(letter.text()))
+		}
+		if err != nil {
+			p.fatalf("Error writing newsletter: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.NewsletterHTMLFile, config.NewsletterTextFile)
+		if *emailTo != "" {
+			msg := &emailMessage{from: *emailFrom, to: splitList(*emailTo), subject: letter.Subject, text: letter.text(), html: html}
+			ctx, cancel := context.WithTimeout(context.Background(), p.config.HTTPTimeout)
+			err := p.sendEmail(ctx, *emailVia, msg)
+			cancel()
+			if err != nil {
+				// The files are written; a failed send shouldn't fail the run
+				p.console.warnf("newsletter not sent: %v\n", err)
+				manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("newsletter not sent: %v", err))
+			} else {
+				p.console.progressf("Sent the newsletter to %d recipient(s)\n", len(msg.to))
+			}
+		}
+	}
+	if seams != nil {
+		data, err := seams.render(sliceStart)
+		if err == nil {
+			err = p.writeOutput(config.ChunkReportFile, data)
+		}
+		if err != nil {
+			p.fatalf("Error writing chunk report: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.ChunkReportFile)
+		for _, w := range seams.warnings() {
+			p.console.warnf("%s; check the transcript there (see %s)\n", w, config.ChunkReportFile)
+			manifest.Warnings = append(manifest.Warnings, w)
+		}
+	}
+	if *clipCount > 0 {
+		data, err := renderClips(clips, sliceStart)
+		if err == nil {
+			err = p.writeOutput(config.ClipsFile, data)
+		}
+		if err != nil {
+			p.fatalf("Error writing clips: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.ClipsFile)
+		for _, c := range clips {
+			if c.Audio != "" {
+				manifest.Outputs = append(manifest.Outputs, c.Audio, c.Subtitles)
+			}
+		}
+	}
+	if social != nil {
+		files := []string{config.XThreadFile, config.LinkedInFile, config.YouTubeFile}
+		for i, path := range files {
+			if err := p.writeOutput(path, []byte(social[i])); err != nil {
+				p.fatalf("Error writing social posts: %v\n", err)
+			}
+		}
+		manifest.Outputs = append(manifest.Outputs, files...)
+	}
+	if metadata != nil {
+		data, err := json.MarshalIndent(metadata, "", "  ")
+		if err == nil {
+			err = p.writeOutput(config.MetadataFile, append(data, '\n'))
+		}
+		if err != nil {
+			p.fatalf("Error writing title variants: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, config.MetadataFile)
+	}
+	// The manifest and signatures are written first, so that they're
+	// delivered with the outputs they let recipients verify
+	manifest.Grade = gradeTranscript(diarized, len(assessTranscript(transcript)))
+	if err := p.writeManifest(manifest, config.ManifestFile); err != nil {
+		p.fatalf("Error writing manifest: %v\n", err)
+	}
+	listed, warnings := manifest.Outputs, len(manifest.Warnings)
+	manifest.Outputs = append(manifest.Outputs, config.ManifestFile)
+	if signer != nil {
+		sigs, err := signOutputs(signer, manifest.Outputs)
+		if err != nil {
+			p.fatalf("Error signing outputs: %v\n", err)
+		}
+		manifest.Outputs = append(manifest.Outputs, sigs...)
+		p.console.progressf("Signed %d output(s)\n", len(sigs))
+	}
+	for _, d := range destinations {
+		ctx, cancel := context.WithTimeout(context.Background(), p.uploadTimeout(filesSize(manifest.Outputs...)))
+		err := p.deliver(ctx, d, slugify(episodeTitle(diarized, episodeName)), manifest.Outputs)
+		cancel()
+		if err != nil {
+			// The files are written; a failed upload shouldn't fail the run
+			p.console.warnf("outputs not delivered to %s: %v\n", d, err)
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("outputs not delivered to %s: %v", d, err))
+		} else {
+			p.console.progressf("Delivered %d output(s) to %s\n", len(manifest.Outputs), d)
+		}
+	}
+	if *emailOutputs != "" {
+		msg, err := outputsEmail(episodeTitle(diarized, episodeName), manifest.Outputs)
+		if err == nil {
+			msg.from, msg.to = *emailFrom, splitList(*emailOutputs)
+			// Attachments grow by a third, encoded as base64
+			ctx, cancel := context.WithTimeout(context.Background(), p.uploadTimeout(filesSize(manifest.Outputs...)*4/3))
+			err = p.sendEmail(ctx, *emailVia, msg)
+			cancel()
+		}
+		if err != nil {
+			// The files are written; a failed send shouldn't fail the run
+			p.console.warnf("outputs not emailed: %v\n", err)
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("outputs not emailed: %v", err))
+		} else {
+			p.console.progressf("Emailed %d output(s) to %d recipient(s)\n", len(msg.attachments), len(msg.to))
+		}
+	}
+	if *postDraft {
+		published := diarized
+		if config.Offset != 0 || config.Drift != 0 {
+			published = diarized.retimed(config.Offset, config.Drift)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.uploadTimeout(filesSize(config.DiarizedJSONFile)))
+		draft, err := p.postDraft(ctx, cms, published)
+		cancel()
+		if err != nil {
+			// The files are written; a failed post shouldn't fail the run
+			p.console.warnf("draft not posted: %v\n", err)
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("draft not posted: %v", err))
+		} else {
+			p.console.progressf("Posted a draft to %s: %s\n", cms.Platform, draft)
+		}
+	}
+	if *uploadTranscriptFlag {
+		published := diarized
+		if config.Offset != 0 || config.Drift != 0 {
+			published = diarized.retimed(config.Offset, config.Drift)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.uploadTimeout(filesSize(config.DiarizedJSONFile)))
+		episode, err := p.uploadTranscript(ctx, hosting, published, *hostingEpisode)
+		cancel()
+		if err != nil {
+			// The files are written; a failed upload shouldn't fail the run
+			p.console.warnf("transcript not uploaded to %s: %v\n", hosting.Platform, err)
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("transcript not uploaded to %s: %v", hosting.Platform, err))
+		} else {
+			p.console.progressf("Attached the transcript to episode %s on %s\n", episode, hosting.Platform)
+		}
+	}
+	if *youtubeVideoID != "" {
+		tracks, err := p.youtubeCaptionTracks(diarized, formats, passes)
+		uploaded := 0
+		if err == nil {
+			var size int64
+			for _, track := range tracks {
+				size += filesSize(track.path)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), p.uploadTimeout(size))
+			uploaded, err = p.uploadYouTubeCaptions(ctx, *youtubeVideoID, tracks)
+			cancel()
+		}
+		if err != nil {
+			// The files are written; a failed upload shouldn't fail the run
+			p.console.warnf("captions not uploaded to YouTube: %v\n", err)
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("captions not uploaded to YouTube: %v", err))
+		}
+		if uploaded > 0 {
+			p.console.progressf("Uploaded %d caption track(s) to YouTube video %s\n", uploaded, *youtubeVideoID)
+		}
+	}
+	if len(manifest.Warnings) > warnings {
+		// Record what wasn't delivered in the local manifest, signed again
+		all := manifest.Outputs
+		manifest.Outputs = listed
+		err := p.writeManifest(manifest, config.ManifestFile)
+		manifest.Outputs = all
+		if err != nil {
+			p.fatalf("Error writing manifest: %v\n", err)
+		}
+		if signer != nil {
+			if _, err := signOutputs(signer, []string{config.ManifestFile}); err != nil {
+				p.fatalf("Error signing outputs: %v\n", err)
+			}
+		}
+	}
+	if fingerprint != nil && *replayDir == "" {
+		ep := archivedEpisode{Dir: absDir(*outputDir), Title: config.Title, Processed: time.Now()}
+		for _, path := range manifest.Outputs {
+			if abs, err := filepath.Abs(path); err == nil {
+				ep.Outputs = append(ep.Outputs, abs)
+			}
+		}
+		if err := state.recordEpisode(fingerprint, ep); err != nil {
+			p.console.warnf("failed to record the episode in the state store: %v\n", err)
+		}
+	}
+	if *onComplete != "" {
+		payload := newHookPayload("complete", diarized, *audioPath, config.DiarizedJSONFile, manifest.Outputs)
+		payload.Manifest = absPath(config.ManifestFile)
+		payload.Warnings = manifest.Warnings
+		if err := p.runHook("on-complete", *onComplete, payload); err != nil {
+			p.console.warnf("%v\n", err)
+		}
+	}
+	p.publish(notification{Event: notifyJobComplete, Outputs: manifest.Outputs, Seconds: time.Since(manifest.StartedAt).Seconds(),
+		Cost: runCost(manifest, audioSeconds), Warnings: len(manifest.Warnings)})
+	printSummary(p.console, manifest, &p.stats, audioSeconds)
+}
+
+// setOpenAIHeaders authenticates an OpenAI API request and, in accounts with
+// several organizations or projects, says which one the usage is billed to.
+func (p *Pipeline) setOpenAIHeaders(req *http.Request, apiKey string) {
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if p.config.OpenAIOrganization != "" {
+		req.Header.Set("OpenAI-Organization", p.config.OpenAIOrganization)
+	}
+	if p.config.OpenAIProject != "" {
+		req.Header.Set("OpenAI-Project", p.config.OpenAIProject)
+	}
+}
+
+// loadCachedTranscription returns the transcription saved by a previous run. It
+// prefers the timed JSON cache and falls back to the plain-text one, which has no
+// segment timing.
+func (p *Pipeline) loadCachedTranscription() (*Transcript, error) {
+	if _, err := os.Stat(p.config.TranscriptionJSONFile); err == nil {
+		return loadTranscript(p.config.EncryptionKey, p.config.TranscriptionJSONFile)
 	}
-	if _, err = io.Copy(part, file); err != nil {
-		return "", fmt.Errorf("failed to copy file content: %v", err)
+	data, err := readStored(p.config.EncryptionKey, p.config.TranscriptionFile)
+	if err != nil {
+		return nil, err
 	}
+	text := string(data)
+	return &Transcript{Text: text, Segments: []Segment{{Text: text}}}, nil
+}
 
-	if err := writer.WriteField("model", "whisper-1"); err != nil {
-		return "", fmt.Errorf("failed to write model field: %v", err)
+// transcribeAudio uploads the audio file to OpenAI's Whisper API and returns the timed
+// transcription.
+func (p *Pipeline) transcribeAudio(ctx context.Context, apiKey, audioPath string) (*Transcript, error) {
+	uploadPath, uploadName, cleanup, err := p.prepareForWhisper(ctx, audioPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	fileInfo, err := os.Stat(uploadPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %v", err)
+	}
+	if fileInfo.Size() > p.config.MaxAudioFileSize {
+		shrunk, cleanupShrunk, err := p.shrinkToFit(ctx, uploadPath, fileInfo.Size(), p.config.MaxAudioFileSize)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanupShrunk()
+		uploadPath = shrunk
+		uploadName = strings.TrimSuffix(uploadName, filepath.Ext(uploadName)) + filepath.Ext(shrunk)
 	}
 
-	if err = writer.Close(); err != nil {
-		return "", fmt.Errorf("failed to close writer: %v", err)
+	fields := p.transcriptionFields(whisperOptionsFrom(ctx))
+	var res *Transcript
+	err = p.retryCorrupt(ctx, func() error {
+		res, err = p.sendWhisper(ctx, apiKey, uploadPath, uploadName, fields)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Audio = filepath.Base(audioPath)
+	if res.Duration == 0 {
+		// The GPT-4o models report no duration or timestamps
+		if d, err := probeDuration(uploadPath); err == nil {
+			res.Duration = d
+		}
+	}
+	if len(res.Segments) == 0 {
+		res.Segments = []Segment{{End: res.Duration, Text: res.Text}}
 	}
+	return res, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", config.WhisperURL, &requestBody)
+// sendWhisper makes one transcription request. A reply covering noticeably less
+// audio than was uploaded means the upload was cut short and is reported as a
+// corruptTransfer.
+func (p *Pipeline) sendWhisper(ctx context.Context, apiKey, uploadPath, uploadName string, fields []formField) (*Transcript, error) {
+	req, err := newMultipartRequest(ctx, p.config.WhisperURL, "file", uploadPath, uploadName, fields)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	p.setOpenAIHeaders(req, apiKey)
 
-	resp, err := httpClient.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		if isDropped(err) {
+			return nil, &droppedTransfer{"upload", err}
+		}
+		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing transcription response body: %v\n", cerr)
+			fmt.Fprintf(stderr, "Error closing transcription response body: %v\n", cerr)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, config.MaxResponseBodySize))
-		return "", fmt.Errorf("non-200 response: %d, body: %s", resp.StatusCode, string(body))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return nil, fmt.Errorf("non-200 response: %d, body: %s%s", resp.StatusCode, string(body), requestRef(resp))
 	}
 
-	var res struct {
-		Text string `json:"text"`
+	body := io.LimitReader(resp.Body, p.config.MaxResponseBodySize)
+	var res Transcript
+	switch {
+	case strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"):
+		streamed, err := decodeTranscriptionStream(body)
+		if err != nil {
+			return nil, err
+		}
+		res = *streamed
+	case diarizingModel(p.config.TranscriptionModel):
+		var d diarizedResponse
+		if err := json.NewDecoder(body).Decode(&d); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+		res = *d.transcript()
+	default:
+		// Word timings come as a list of their own beside the segments
+		var verbose struct {
+			Transcript
+			Words []Word `json:"words"`
+		}
+		if err := json.NewDecoder(body).Decode(&verbose); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+		res = verbose.Transcript
+		attachWords(res.Segments, verbose.Words)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+	if want, err := probeDuration(uploadPath); err == nil && res.Duration > 0 && truncated(res.Duration, want) {
+		return nil, &corruptTransfer{"upload", fmt.Sprintf("Whisper heard %.1f of %.1f seconds", res.Duration, want)}
 	}
-	return res.Text, nil
+	return &res, nil
 }
 
-// diarizeTranscript sends the transcription to a ChatCompletion endpoint using the o1 model.
-// It does not set a maximum token limit in the request.
-func diarizeTranscript(ctx context.Context, apiKey, transcript string, numSpeakers int) (string, error) {
-	prompt := fmt.Sprintf(`You are an expert in speaker diarization.
-Given the following transcript of a podcast and knowing there are %d speakers, please insert clear breaks and label each segment with the appropriate speaker (e.g., "Speaker 1:", "Speaker 2:", etc.).
-
+// defaultPromptTemplate is the diarization prompt used unless -prompt supplies another.
+const defaultPromptTemplate = `You are an expert in speaker diarization.
+Given the following transcript of a podcast and knowing there are {{.Speakers}} speakers, please insert clear breaks and label each segment with the appropriate speaker (e.g., "Speaker 1:", "Speaker 2:", etc.).
+{{if .SpeakerNames}}
+The speakers are {{join .SpeakerNames ", "}}. Label each segment with the speaker's name instead of a number.
+{{end}}{{if .Guests}}
+The guests of this episode are {{join .Guests ", "}}. Label their segments with their names.
+{{end}}{{if .Vocabulary}}
+Names and terms that may appear: {{join .Vocabulary ", "}}.
+{{end}}{{if or .Title .Description}}
+Episode information, which may name the host and guests; use it to tell who is speaking:
+{{if .Title}}Title: {{.Title}}
+{{end}}{{if .Description}}Description: {{.Description}}
+{{end}}{{end}}
+{{if .Previous}}
+This transcript continues an earlier part. The earlier part ended as follows; keep using the same speaker labels for the same people:
+{{.Previous}}
+{{end}}{{if .Pauses}}
+Pauses in the audio, which often (but not always) mark a change of speaker:
+{{range .Pauses}}- {{.}}
+{{end}}{{end}}
 Transcript:
-%s
+{{.Transcript}}
+
+Return the diarized transcript.`
+
+// buildDiarizationPrompt renders the configured prompt template for transcript.
+// previous holds the tail of the preceding part's diarization, if any, and
+// pauses the candidate speaker changes from pauseHints.
+func (p *Pipeline) buildDiarizationPrompt(transcript, previous string, pauses []string, numSpeakers int) (string, error) {
+	src := p.config.PromptTemplate
+	if src == "" {
+		src = defaultPromptTemplate
+	}
+	tmpl, err := template.New("prompt").Funcs(template.FuncMap{"join": strings.Join}).Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse prompt template: %v", err)
+	}
+	var b strings.Builder
+	data := struct {
+		Speakers     int
+		SpeakerNames []string
+		Guests       []string
+		Vocabulary   []string
+		Title        string
+		Description  string
+		Transcript   string
+		Previous     string
+		Pauses       []string
+	}{numSpeakers, p.config.SpeakerNames, p.config.Guests, p.config.Vocabulary, p.config.Title, p.config.Description, transcript, previous, pauses}
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// diarizeTranscript sends the transcription to a ChatCompletion endpoint and returns the
+// speaker turns. In JSON mode the model must answer with the diarizationResponseFormat
+// schema; otherwise its prose is parsed. The returned usage is the token accounting
+// reported by the API.
+func (p *Pipeline) diarizeTranscript(ctx context.Context, apiKey, transcript, previous string, pauses []string, numSpeakers int) ([]Segment, TokenUsage, error) {
+	prompt, err := p.buildDiarizationPrompt(transcript, previous, pauses, numSpeakers)
+	if err != nil {
+		return nil, TokenUsage{}, err
+	}
+	if p.config.StructuredOutput {
+		prompt += structuredInstruction
+	}
 
-Return the diarized transcript.`, numSpeakers, transcript)
+	messages, err := p.exampleMessages(p.config.Examples)
+	if err != nil {
+		return nil, TokenUsage{}, err
+	}
+	messages = append(messages, map[string]string{"role": "user", "content": prompt})
 
 	payload := map[string]interface{}{
-		"model":       "gpt-4o",
-		"messages":    []map[string]string{{"role": "user", "content": prompt}},
-		"temperature": 0.3,
-		// "max_tokens" is intentionally omitted to allow the API to use the model's full output capacity.
+		"model":       p.config.DiarizationModel,
+		"messages":    messages,
+		"temperature": p.config.Temperature,
+	}
+	// Unset limits are omitted so the API applies the model's defaults and full output capacity.
+	if p.config.TopP > 0 {
+		payload["top_p"] = p.config.TopP
+	}
+	if p.config.MaxOutputTokens > 0 {
+		payload["max_completion_tokens"] = p.config.MaxOutputTokens
+	}
+	if p.config.Seed != nil {
+		payload["seed"] = *p.config.Seed
+	}
+	if p.config.StructuredOutput {
+		payload["response_format"] = diarizationResponseFormat
 	}
 
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, err
+	}
+	if !p.config.StructuredOutput {
+		return parseDiarized(content), usage, nil
+	}
+	turns, err := parseStructuredTurns(content)
+	if err != nil {
+		return nil, usage, err
+	}
+	return turns, usage, nil
+}
+
+// chatCompletion posts payload to the chat completions endpoint, or with -batch
+// submits it as a batch, and returns the content of the first choice along
+// with the reported token usage.
+func (p *Pipeline) chatCompletion(ctx context.Context, apiKey string, payload map[string]interface{}) (string, TokenUsage, error) {
+	if p.config.Batch {
+		return p.batchCompletion(ctx, apiKey, payload)
+	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal payload: %v", err)
+		return "", TokenUsage{}, fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", config.ChatCompletionsURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.config.ChatCompletionsURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		return "", fmt.Errorf("failed to create chat completion request: %v", err)
+		return "", TokenUsage{}, fmt.Errorf("failed to create chat completion request: %v", err)
 	}
-	req.Header.Add("Authorization", "Bearer "+apiKey)
+	p.setOpenAIHeaders(req, apiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send chat completion request: %v", err)
+		return "", TokenUsage{}, fmt.Errorf("failed to send chat completion request: %v", err)
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing chat completion response body: %v\n", cerr)
+			fmt.Fprintf(stderr, "Error closing chat completion response body: %v\n", cerr)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, config.MaxResponseBodySize))
-		return "", fmt.Errorf("non-200 response from chat completion: %d, body: %s", resp.StatusCode, string(body))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return "", TokenUsage{}, fmt.Errorf("non-200 response from chat completion: %d, body: %s%s", resp.StatusCode, string(body), requestRef(resp))
 	}
 
 	var res struct {
@@ -229,13 +2349,14 @@ Return the diarized transcript.`, numSpeakers, transcript)
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage TokenUsage `json:"usage"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return "", fmt.Errorf("failed to decode chat completion response: %v", err)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(&res); err != nil {
+		return "", TokenUsage{}, fmt.Errorf("failed to decode chat completion response: %v", err)
 	}
 
 	if len(res.Choices) == 0 {
-		return "", fmt.Errorf("no choices returned from chat completion")
+		return "", res.Usage, fmt.Errorf("no choices returned from chat completion")
 	}
-	return res.Choices[0].Message.Content, nil
+	return res.Choices[0].Message.Content, res.Usage, nil
 }
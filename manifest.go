@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Manifest records the provenance of a single run: what went in, which models and
+// parameters were used, where requests were sent, and how long each stage took.
+type Manifest struct {
+	SoftwareVersion string          `json:"software_version"`
+	SoftwareCommit  string          `json:"software_commit,omitempty"`
+	BuildDate       string          `json:"build_date,omitempty"`
+	GoVersion       string          `json:"go_version"`
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      time.Time       `json:"finished_at"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Input           ManifestInput   `json:"input"`
+	Parameters      map[string]any  `json:"parameters"`
+	Stages          []ManifestStage `json:"stages"`
+	Usage           TokenUsage      `json:"usage"`
+	Outputs         []string        `json:"outputs"`
+	// Warnings lists problems found along the way, such as stretches of the
+	// transcription that look like hallucinations.
+	Warnings []string `json:"warnings,omitempty"`
+	// Grade rates how ready the transcript is to publish.
+	Grade *transcriptGrade `json:"grade,omitempty"`
+	// Verbatim certifies the text of a -verbatim run untransformed.
+	Verbatim *VerbatimCertificate `json:"verbatim,omitempty"`
+	// Excluded lists the time ranges of -exclude, left out of the transcript
+	// and every output.
+	Excluded []timeRange `json:"excluded,omitempty"`
+
+	// onStage, if set, is told the name of every stage as it begins.
+	onStage func(name string)
+}
+
+// ManifestInput identifies the audio file a run was produced from.
+type ManifestInput struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestStage describes one pipeline stage. Cached stages reused earlier output
+// and made no API call.
+type ManifestStage struct {
+	Name            string      `json:"name"`
+	Model           string      `json:"model,omitempty"`
+	Endpoint        string      `json:"endpoint,omitempty"`
+	Cached          bool        `json:"cached"`
+	StartedAt       time.Time   `json:"started_at"`
+	DurationSeconds float64     `json:"duration_seconds"`
+	Usage           *TokenUsage `json:"usage,omitempty"`
+}
+
+// TokenUsage mirrors the usage block returned by the chat completions API.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Add accumulates u into the receiver.
+func (t *TokenUsage) Add(u TokenUsage) {
+	t.PromptTokens += u.PromptTokens
+	t.CompletionTokens += u.CompletionTokens
+	t.TotalTokens += u.TotalTokens
+}
+
+// newManifest starts a manifest for a run over audioPath, hashing the input file.
+func newManifest(audioPath string) (*Manifest, error) {
+	m := &Manifest{
+		SoftwareVersion: currentBuild().Version,
+		SoftwareCommit:  currentBuild().shortCommit(),
+		BuildDate:       currentBuild().BuildDate,
+		GoVersion:       runtime.Version(),
+		StartedAt:       time.Now().UTC(),
+		Parameters:      map[string]any{},
+	}
+	if audioPath == "" {
+		return m, nil
+	}
+	size, sum, err := hashFile(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	m.Input = ManifestInput{Path: audioPath, Size: size, SHA256: sum}
+	return m, nil
+}
+
+// beginStage appends a new stage and returns it so the caller can fill in the result.
+func (m *Manifest) beginStage(name, model, endpoint string) *ManifestStage {
+	if m.onStage != nil {
+		m.onStage(name)
+	}
+	m.Stages = append(m.Stages, ManifestStage{
+		Name:      name,
+		Model:     model,
+		Endpoint:  endpoint,
+		StartedAt: time.Now().UTC(),
+	})
+	return &m.Stages[len(m.Stages)-1]
+}
+
+// end records the stage duration and, if non-nil, its token usage.
+func (s *ManifestStage) end(m *Manifest, usage *TokenUsage) {
+	s.DurationSeconds = time.Since(s.StartedAt).Seconds()
+	if usage != nil {
+		s.Usage = usage
+		m.Usage.Add(*usage)
+	}
+}
+
+// write finalises the manifest timing and saves it as indented JSON.
+func (p *Pipeline) writeManifest(m *Manifest, path string) error {
+	m.FinishedAt = time.Now().UTC()
+	m.DurationSeconds = m.FinishedAt.Sub(m.StartedAt).Seconds()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	// The input may be a presigned URL, and warnings quote API errors
+	data = []byte(redactSecrets(string(data)))
+	if err := p.writeOutput(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+// hashFile returns the size and hex-encoded SHA-256 digest of the file at path.
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open file for hashing: %v", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to hash file: %v", err)
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// formField is one plain field of a multipart upload.
+type formField struct {
+	name, value string
+}
+
+// newMultipartRequest builds a POST request uploading the file at path, under
+// the given file name, as a multipart form, after fields. The file is streamed from disk rather than
+// copied into memory, so peak memory doesn't grow with the audio size. The
+// request has an exact Content-Length and can be replayed by the HTTP client.
+func newMultipartRequest(ctx context.Context, url, fileField, path, filename string, fields []formField) (*http.Request, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	// Everything but the file content is small and rendered up front
+	var head bytes.Buffer
+	writer := multipart.NewWriter(&head)
+	for _, f := range fields {
+		if err := writer.WriteField(f.name, f.value); err != nil {
+			return nil, fmt.Errorf("failed to write %s field: %v", f.name, err)
+		}
+	}
+	if _, err := writer.CreateFormFile(fileField, filename); err != nil {
+		return nil, fmt.Errorf("failed to create form file: %v", err)
+	}
+	tail := "\r\n--" + writer.Boundary() + "--\r\n"
+
+	getBody := func() (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audio file: %v", err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head.Bytes()), file, strings.NewReader(tail)), file}, nil
+	}
+	body, err := getBody()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.GetBody = getBody
+	req.ContentLength = int64(head.Len()) + info.Size() + int64(len(tail))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
+// cappedBuffer collects the output of external commands without trusting them
+// to be small: writes beyond limit bytes fail.
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	limit int64
+}
+
+// newCappedBuffer returns a buffer bounded by MaxResponseBodySize.
+func (p *Pipeline) newCappedBuffer() *cappedBuffer {
+	return &cappedBuffer{limit: p.config.MaxResponseBodySize}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if int64(b.buf.Len()+len(p)) > b.limit {
+		return 0, fmt.Errorf("output exceeds %d bytes", b.limit)
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the collected output.
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// readFileCapped reads a file written by an external command, refusing files
+// larger than MaxResponseBodySize.
+func (p *Pipeline) readFileCapped(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := p.newCappedBuffer()
+	if _, err := io.Copy(buf, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseByteSize parses sizes such as "512MiB", "2G" or "1048576". KiB, MiB
+// and GiB, and k, M and G on their own, are powers of 1024; KB, MB and GB are
+// powers of 1000. A size must come to at least a byte.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+		{"kb", 1000}, {"mb", 1000 * 1000}, {"gb", 1000 * 1000 * 1000},
+		{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"b", 1},
+	}
+	lower := strings.ToLower(s)
+	scale := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, scale = strings.TrimSpace(strings.TrimSuffix(lower, u.suffix)), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	size := n * float64(scale)
+	// Written so that NaN fails too
+	if err != nil || !(size >= 1 && size < math.MaxInt64) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(size), nil
+}
+
+// applyMemoryLimit makes limit the Go runtime's soft memory limit and shrinks the
+// response and command output caps to fit within it. The runtime collects garbage
+// more aggressively as the heap approaches the limit.
+func (p *Pipeline) applyMemoryLimit(limit int64) {
+	debug.SetMemoryLimit(limit)
+	// Decoding a response can briefly need a few times its size
+	if maxBody := limit / 8; p.config.MaxResponseBodySize > maxBody {
+		p.config.MaxResponseBodySize = maxBody
+	}
+}
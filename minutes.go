@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// minutesResponseFormat is the JSON schema of the meeting minutes reply.
+var minutesResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "minutes",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"summary": map[string]any{"type": "string", "description": "Two or three sentences on what the meeting was for and what came of it"},
+				"attendees": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"name": map[string]any{"type": "string", "description": "The attendee's name, or their speaker label if they aren't named"},
+							"role": map[string]any{"type": "string", "description": "Their role or team if it's said, else empty"},
+						},
+						"required":             []string{"name", "role"},
+						"additionalProperties": false,
+					},
+				},
+				"topics": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":    map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn where the topic starts, copied from the transcript"},
+							"title":   map[string]any{"type": "string", "description": "A few words naming the topic"},
+							"summary": map[string]any{"type": "string", "description": "What was said about it, in a few sentences"},
+						},
+						"required":             []string{"time", "title", "summary"},
+						"additionalProperties": false,
+					},
+				},
+				"decisions": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":     map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn where it was decided, copied from the transcript"},
+							"decision": map[string]any{"type": "string", "description": "What was decided, as one sentence"},
+						},
+						"required":             []string{"time", "decision"},
+						"additionalProperties": false,
+					},
+				},
+				"action_items": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":  map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn where it was agreed, copied from the transcript"},
+							"task":  map[string]any{"type": "string", "description": "What is to be done"},
+							"owner": map[string]any{"type": "string", "description": "Who is to do it, or empty if no one took it on"},
+							"due":   map[string]any{"type": "string", "description": "When it is due, as said, or empty"},
+						},
+						"required":             []string{"time", "task", "owner", "due"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"summary", "attendees", "topics", "decisions", "action_items"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// minutesPrompt asks for the minutes of a recorded meeting.
+const minutesPrompt = `Write the minutes of the following recorded meeting: a short summary, the attendees, the topics discussed in the order they come up with a summary of each, the decisions made, and the action items agreed with their owner and due date. Only list decisions and action items the meeting actually agreed on, not ideas floated; give each the timestamp of the turn where it happened, copied exactly. Don't invent anything that isn't in the transcript.
+%s
+Transcript:
+%s`
+
+// minutes are the structured minutes of a meeting, as written to the minutes
+// JSON file.
+type minutes struct {
+	Title       string              `json:"title,omitempty"`
+	Date        string              `json:"date,omitempty"`
+	Summary     string              `json:"summary"`
+	Attendees   []minutesAttendee   `json:"attendees"`
+	Topics      []minutesTopic      `json:"topics"`
+	Decisions   []minutesDecision   `json:"decisions"`
+	ActionItems []minutesActionItem `json:"action_items"`
+}
+
+type minutesAttendee struct {
+	Name string `json:"name"`
+	Role string `json:"role,omitempty"`
+}
+
+type minutesTopic struct {
+	Time    string `json:"time,omitempty"`
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+type minutesDecision struct {
+	Time     string `json:"time,omitempty"`
+	Decision string `json:"decision"`
+}
+
+type minutesActionItem struct {
+	Time  string `json:"time,omitempty"`
+	Task  string `json:"task"`
+	Owner string `json:"owner,omitempty"`
+	Due   string `json:"due,omitempty"`
+}
+
+// draftMinutes asks the summary model for the minutes of the meeting recorded
+// in t. Times are checked against the transcript and snapped to the start of
+// the turn they fall in; items with a time outside the recording are kept
+// without one.
+func (p *Pipeline) draftMinutes(ctx context.Context, apiKey string, t *Transcript) (*minutes, TokenUsage, error) {
+	var meeting string
+	if t.Title != "" {
+		meeting += "\nMeeting title: " + t.Title + "\n"
+	}
+	if t.Description != "" {
+		meeting += "Agenda or description: " + t.Description + "\n"
+	}
+	if len(p.config.Guests) > 0 {
+		meeting += "Invited: " + strings.Join(p.config.Guests, ", ") + "\n"
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(minutesPrompt, meeting, fitContext(formatTimedTurns(t.Segments), p.config.SummaryModel))}},
+		"temperature":     p.config.Temperature,
+		"response_format": minutesResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to draft minutes: %v", err)
+	}
+	m := &minutes{Title: t.Title, Date: t.Date}
+	if err := json.Unmarshal([]byte(content), m); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode minutes: %v", err)
+	}
+	for i := range m.Topics {
+		m.Topics[i].Time = snapClock(t, m.Topics[i].Time)
+	}
+	for i := range m.Decisions {
+		m.Decisions[i].Time = snapClock(t, m.Decisions[i].Time)
+	}
+	for i := range m.ActionItems {
+		m.ActionItems[i].Time = snapClock(t, m.ActionItems[i].Time)
+	}
+	return m, usage, nil
+}
+
+// snapClock returns the HH:MM:SS start of the turn of t that the model's
+// clock time falls in, or "" if it isn't a time within the recording.
+func snapClock(t *Transcript, clock string) string {
+	at, err := parseClock(clock)
+	if err != nil || (t.Duration > 0 && at > t.Duration) {
+		return ""
+	}
+	return formatTimestamp(turnStart(t.Segments, at), ".")[:8]
+}
+
+// atClock returns the parenthesized time that follows an item in Markdown.
+func atClock(clock string) string {
+	if clock == "" {
+		return ""
+	}
+	return " (" + clock + ")"
+}
+
+// json renders the minutes as written to the minutes JSON file.
+func (m *minutes) json() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// markdown renders the minutes as a Markdown document, with the action items
+// as a task list.
+func (m *minutes) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", firstNonEmpty(m.Title, "Meeting minutes"))
+	if m.Date != "" {
+		fmt.Fprintf(&b, "Date: %s\n\n", m.Date)
+	}
+	if m.Summary != "" {
+		fmt.Fprintf(&b, "%s\n\n", m.Summary)
+	}
+	if len(m.Attendees) > 0 {
+		b.WriteString("## Attendees\n\n")
+		for _, a := range m.Attendees {
+			if a.Role != "" {
+				fmt.Fprintf(&b, "- %s, %s\n", a.Name, a.Role)
+			} else {
+				fmt.Fprintf(&b, "- %s\n", a.Name)
+			}
+		}
+		b.WriteString("\n")
+	}
+	if len(m.Decisions) > 0 {
+		b.WriteString("## Decisions\n\n")
+		for _, d := range m.Decisions {
+			fmt.Fprintf(&b, "- %s%s\n", d.Decision, atClock(d.Time))
+		}
+		b.WriteString("\n")
+	}
+	if len(m.ActionItems) > 0 {
+		b.WriteString("## Action items\n\n")
+		for _, a := range m.ActionItems {
+			item := a.Task
+			if a.Owner != "" {
+				item = "**" + a.Owner + "**: " + item
+			}
+			if a.Due != "" {
+				item += ", due " + a.Due
+			}
+			fmt.Fprintf(&b, "- [ ] %s%s\n", item, atClock(a.Time))
+		}
+		b.WriteString("\n")
+	}
+	if len(m.Topics) > 0 {
+		b.WriteString("## Discussion\n\n")
+		for _, t := range m.Topics {
+			fmt.Fprintf(&b, "### %s%s\n\n%s\n\n", t.Title, atClock(t.Time), t.Summary)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
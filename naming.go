@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SpeakerInfo records who an anonymous speaker cluster was identified as.
+type SpeakerInfo struct {
+	// Label is the cluster label assigned by diarization, e.g. "Speaker 2".
+	Label string `json:"label"`
+	Name  string `json:"name,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// These bound the excerpt shown to the model: the opening turns, where
+// introductions usually happen, plus a few turns of every speaker, each cut to
+// namingExcerptWords words.
+const (
+	namingOpeningTurns    = 30
+	namingTurnsPerSpeaker = 8
+	namingExcerptWords    = 80
+)
+
+// namingResponseFormat is the JSON schema of the speaker naming reply.
+var namingResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "speaker_names",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"speakers": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"label": map[string]any{"type": "string", "description": "The anonymous speaker label as given"},
+							"name":  map[string]any{"type": "string", "description": "The speaker's name, or an empty string if it can't be determined"},
+							"role":  map[string]any{"type": "string", "description": `The speaker's role, e.g. "host", "co-host", "guest", or an empty string`},
+						},
+						"required":             []string{"label", "name", "role"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"speakers"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// namingPrompt asks the model to identify the speakers behind anonymous labels.
+const namingPrompt = `The following podcast transcript excerpt has already been split into speaker turns by an acoustic diarization system, which labels speakers anonymously ("Speaker 1", "Speaker 2", ...). The turn boundaries are reliable; do not change them.
+
+Identify who each labeled speaker is from introductions, how speakers address each other, and the episode information. Give each label a name and role (host, co-host, guest, ...). Leave the name empty rather than guess when the transcript gives no evidence.
+%s
+Excerpt:
+%s
+
+Respond with a JSON object whose "speakers" array has one entry per label: %s.`
+
+// nameSpeakers asks the chat model to put names and roles to the anonymous
+// speaker labels of t, without changing the turns themselves.
+func (p *Pipeline) nameSpeakers(ctx context.Context, apiKey string, t *Transcript) ([]SpeakerInfo, TokenUsage, error) {
+	labels := t.speakers()
+	if len(labels) == 0 {
+		return nil, TokenUsage{}, nil
+	}
+
+	var info strings.Builder
+	if len(p.config.SpeakerNames) > 0 {
+		fmt.Fprintf(&info, "\nThe people on the show are probably among: %s.\n", strings.Join(p.config.SpeakerNames, ", "))
+	}
+	if len(p.config.Guests) > 0 {
+		fmt.Fprintf(&info, "\nThis episode's guests: %s.\n", strings.Join(p.config.Guests, ", "))
+	}
+	if p.config.Title != "" {
+		fmt.Fprintf(&info, "\nEpisode title: %s\n", p.config.Title)
+	}
+	if p.config.Description != "" {
+		fmt.Fprintf(&info, "Episode description: %s\n", p.config.Description)
+	}
+	prompt := fmt.Sprintf(namingPrompt, info.String(), namingExcerpt(t.Segments), strings.Join(labels, ", "))
+
+	payload := map[string]interface{}{
+		"model":           p.config.DiarizationModel,
+		"messages":        []map[string]string{{"role": "user", "content": prompt}},
+		"temperature":     p.config.Temperature,
+		"response_format": namingResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to name speakers: %v", err)
+	}
+	var res struct {
+		Speakers []SpeakerInfo `json:"speakers"`
+	}
+	if err := json.Unmarshal([]byte(content), &res); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode speaker names: %v", err)
+	}
+
+	// Keep only labels that exist, in order of appearance
+	byLabel := map[string]SpeakerInfo{}
+	for _, s := range res.Speakers {
+		byLabel[strings.TrimSpace(s.Label)] = SpeakerInfo{
+			Name: strings.TrimSpace(s.Name),
+			Role: strings.ToLower(strings.TrimSpace(s.Role)),
+		}
+	}
+	infos := make([]SpeakerInfo, len(labels))
+	for i, l := range labels {
+		infos[i] = byLabel[l]
+		infos[i].Label = l
+	}
+	return infos, usage, nil
+}
+
+// namingExcerpt picks the opening turns plus the first turns of every speaker,
+// each cut to namingExcerptWords words, in transcript order.
+func namingExcerpt(turns []Segment) string {
+	count := map[string]int{}
+	var lines []string
+	for i, t := range turns {
+		if t.Kind == eventKind {
+			continue
+		}
+		if i >= namingOpeningTurns && count[t.Speaker] >= namingTurnsPerSpeaker {
+			continue
+		}
+		count[t.Speaker]++
+		words := strings.Fields(t.Text)
+		if len(words) > namingExcerptWords {
+			words = append(words[:namingExcerptWords], "…")
+		}
+		lines = append(lines, t.Speaker+": "+strings.Join(words, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// applySpeakerNames relabels the turns of t with the identified names. Labels
+// left unnamed, and names claimed by more than one label, keep their label.
+func applySpeakerNames(t *Transcript, infos []SpeakerInfo) {
+	claims := map[string]int{}
+	for _, s := range infos {
+		if s.Name != "" {
+			claims[strings.ToLower(s.Name)]++
+		}
+	}
+	rename := map[string]string{}
+	for _, s := range infos {
+		if s.Name != "" && claims[strings.ToLower(s.Name)] == 1 {
+			rename[s.Label] = s.Name
+		}
+	}
+	for i, s := range t.Segments {
+		if name, ok := rename[s.Speaker]; ok {
+			t.Segments[i].Speaker = name
+		}
+	}
+	t.Speakers = infos
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Without ffmpeg and ffprobe, audio is probed and chunked here instead: WAV
+// by its samples and MP3 by its frames, which is all -chunk, -from/-to and
+// the transfer checks do, so that the binary runs on its own where ffmpeg
+// can't be installed. Ogg files are measured but not cut, their pages being
+// too long to cut between exactly, so -chunk and -from/-to refuse them
+// before any work is done. Nothing here decodes audio; what does, such as
+// -speedup, -exclude and format conversion, still needs ffmpeg, and the
+// flags that need it are refused up front when it's missing.
+
+// haveTool reports whether the named program is on PATH.
+func haveTool(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// nativeDuration returns the duration of the audio file in seconds without
+// ffprobe.
+func nativeDuration(path string) (float64, error) {
+	format, err := sniffAudioFormat(path)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer f.Close()
+	switch format {
+	case "wav":
+		w, err := readWAV(f)
+		if err != nil {
+			return 0, err
+		}
+		return float64(w.dataSize) / float64(w.byteRate), nil
+	case "mp3":
+		return mp3Frames(f, nil)
+	case "ogg":
+		return oggDuration(f)
+	}
+	return 0, fmt.Errorf("ffprobe is not installed, and without it only WAV, MP3 and Ogg files can be measured, not %s", describeFormat(format))
+}
+
+// nativeCut copies the audio from start seconds to end seconds (0 meaning
+// the end of the file) of a WAV or MP3 file into out without ffmpeg. WAV is
+// cut to the sample, MP3 to the frame, about 26 milliseconds.
+func nativeCut(path, out string, start, end float64) error {
+	format, err := sniffAudioFormat(path)
+	if err != nil {
+		return err
+	}
+	if err := nativeCutSupported(format); err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer in.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create cut: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	if format == "wav" {
+		err = cutWAV(in, w, start, end)
+	} else {
+		err = cutMP3(in, w, start, end)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cut audio: %v", err)
+	}
+	return nil
+}
+
+// checkCuttable fails, before any work is done, if the audio file at path
+// couldn't be cut for flag, -chunk or -from/-to: without ffmpeg, only WAV
+// and MP3 files are.
+func checkCuttable(path, flag string) error {
+	if haveTool("ffmpeg") {
+		return nil
+	}
+	format, err := sniffAudioFormat(path)
+	if err != nil {
+		return err
+	}
+	if nativeCutSupported(format) != nil {
+		return fmt.Errorf("%s needs ffmpeg to cut %s, and it is not installed; without it only WAV and MP3 files can be cut", flag, describeFormat(format))
+	}
+	return nil
+}
+
+// nativeCutSupported fails unless nativeCut cuts files of format.
+func nativeCutSupported(format string) error {
+	if format != "wav" && format != "mp3" {
+		return fmt.Errorf("ffmpeg is not installed, and without it only WAV and MP3 files can be cut, not %s", describeFormat(format))
+	}
+	return nil
+}
+
+// describeFormat names a format sniffAudioFormat returned for messages.
+func describeFormat(format string) string {
+	if format == "" {
+		return "this format"
+	}
+	return format
+}
+
+// wavFile is what cutting and measuring a WAV file takes from its header.
+type wavFile struct {
+	// format is the body of the fmt chunk, copied into cuts as it is.
+	format     []byte
+	byteRate   int64
+	blockAlign int64
+	dataOffset int64
+	dataSize   int64
+}
+
+// readWAV walks the chunks of a RIFF WAVE file up to its data chunk. A data
+// chunk size that runs past the end of the file, as streaming writers leave
+// it, is taken to mean the rest of the file.
+func readWAV(f *os.File) (*wavFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(f, head); err != nil || string(head[:4]) != "RIFF" || string(head[8:]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+	w := &wavFile{}
+	offset := int64(12)
+	for {
+		chunk := make([]byte, 8)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return nil, fmt.Errorf("WAV file has no data chunk")
+		}
+		id, size := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+		offset += 8
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return nil, fmt.Errorf("WAV file has a malformed fmt chunk")
+			}
+			w.format = make([]byte, size)
+			if _, err := f.ReadAt(w.format, offset); err != nil {
+				return nil, fmt.Errorf("WAV file has a malformed fmt chunk")
+			}
+			w.byteRate = int64(binary.LittleEndian.Uint32(w.format[8:]))
+			w.blockAlign = int64(binary.LittleEndian.Uint16(w.format[12:]))
+		case "data":
+			if w.format == nil || w.byteRate == 0 || w.blockAlign == 0 {
+				return nil, fmt.Errorf("WAV file has no usable fmt chunk before its data")
+			}
+			w.dataOffset = offset
+			w.dataSize = min(size, info.Size()-offset)
+			w.dataSize -= w.dataSize % w.blockAlign
+			return w, nil
+		}
+		// Chunks are padded to an even length
+		offset += size + size%2
+	}
+}
+
+// cutWAV writes the samples of a WAV file between start and end seconds as
+// a WAV file of the same format.
+func cutWAV(in *os.File, out io.Writer, start, end float64) error {
+	w, err := readWAV(in)
+	if err != nil {
+		return err
+	}
+	from := min(w.sampleOffset(start), w.dataSize)
+	to := w.dataSize
+	if end > 0 {
+		to = min(max(w.sampleOffset(end), from), w.dataSize)
+	}
+	n := to - from
+
+	pad := int64(len(w.format) % 2)
+	var head bytes.Buffer
+	head.WriteString("RIFF")
+	binary.Write(&head, binary.LittleEndian, uint32(4+8+int64(len(w.format))+pad+8+n+n%2))
+	head.WriteString("WAVEfmt ")
+	binary.Write(&head, binary.LittleEndian, uint32(len(w.format)))
+	head.Write(w.format)
+	if pad == 1 {
+		head.WriteByte(0)
+	}
+	head.WriteString("data")
+	binary.Write(&head, binary.LittleEndian, uint32(n))
+	if _, err := out.Write(head.Bytes()); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(in, w.dataOffset+from, n)); err != nil {
+		return err
+	}
+	if n%2 == 1 {
+		_, err = out.Write([]byte{0})
+	}
+	return err
+}
+
+// sampleOffset returns the offset into the data chunk of the sample at t
+// seconds.
+func (w *wavFile) sampleOffset(t float64) int64 {
+	return int64(t*float64(w.byteRate)) / w.blockAlign * w.blockAlign
+}
+
+// mp3Bitrates are the bitrates in kbit/s by bitrate index, for MPEG-1
+// layers I, II and III, then MPEG-2 and 2.5 layer I, then layers II and III.
+var mp3Bitrates = [5][15]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// mp3SampleRates are the sample rates by sample rate index, for MPEG-1,
+// MPEG-2 and MPEG-2.5.
+var mp3SampleRates = [3][3]int{
+	{44100, 48000, 32000},
+	{22050, 24000, 16000},
+	{11025, 12000, 8000},
+}
+
+// mp3Header is the part of an MPEG audio frame header that says how long
+// the frame is.
+type mp3Header struct {
+	// version is 1 for MPEG-1, 2 for MPEG-2 and 3 for MPEG-2.5; layer is 1
+	// to 3.
+	version, layer int
+	sampleRate     int
+	samples        int
+	size           int
+	mono           bool
+}
+
+// parseMP3Header parses the four bytes of a frame header, reporting false
+// if they aren't one. Free-format frames, which don't say their size, are
+// treated as not a header.
+func parseMP3Header(b []byte) (mp3Header, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Header{}, false
+	}
+	var h mp3Header
+	switch (b[1] >> 3) & 3 {
+	case 3:
+		h.version = 1
+	case 2:
+		h.version = 2
+	case 0:
+		h.version = 3
+	default:
+		return mp3Header{}, false
+	}
+	h.layer = 4 - int((b[1]>>1)&3)
+	bitrateIndex, rateIndex, padding := int(b[2]>>4), int((b[2]>>2)&3), int((b[2]>>1)&1)
+	if h.layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Header{}, false
+	}
+	table := h.layer - 1
+	if h.version > 1 {
+		table = min(3+h.layer-1, 4)
+	}
+	bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+	h.sampleRate = mp3SampleRates[h.version-1][rateIndex]
+	h.mono = b[3]>>6 == 3
+	switch {
+	case h.layer == 1:
+		h.samples = 384
+		h.size = (12*bitrate/h.sampleRate + padding) * 4
+	case h.layer == 3 && h.version > 1:
+		h.samples = 576
+		h.size = 72*bitrate/h.sampleRate + padding
+	default:
+		h.samples = 1152
+		h.size = 144*bitrate/h.sampleRate + padding
+	}
+	return h, true
+}
+
+// sameStream reports whether two frame headers can belong to the same
+// stream, which tells a frame from a sync pattern in tag or junk data.
+func (h mp3Header) sameStream(o mp3Header) bool {
+	return h.version == o.version && h.layer == o.layer && h.sampleRate == o.sampleRate
+}
+
+// vbrHeader reports whether a frame is a Xing, Info or VBRI header rather
+// than audio. Such a frame counts the frames of the whole file, so it's left
+// out of cuts, where it would be wrong.
+func (h mp3Header) vbrHeader(frame []byte) bool {
+	side := 32
+	switch {
+	case h.version == 1 && h.mono:
+		side = 17
+	case h.version > 1 && !h.mono:
+		side = 17
+	case h.version > 1:
+		side = 9
+	}
+	at := func(off int, tag string) bool {
+		return len(frame) >= 4+off+4 && string(frame[4+off:4+off+4]) == tag
+	}
+	return h.layer == 3 && (at(side, "Xing") || at(side, "Info") || at(32, "VBRI"))
+}
+
+// mp3Frames reads the frames of an MP3 file in order, skipping an ID3v2 tag
+// and any junk between frames, and returns its duration in seconds. Each
+// audio frame is passed to fn, if set, with the time it starts at; the frame
+// is only valid during the call.
+func mp3Frames(r io.Reader, fn func(frame []byte, at float64) error) (float64, error) {
+	br := bufio.NewReaderSize(r, 8192)
+	if head, err := br.Peek(10); err == nil && string(head[:3]) == "ID3" {
+		size := int(head[6]&0x7F)<<21 | int(head[7]&0x7F)<<14 | int(head[8]&0x7F)<<7 | int(head[9]&0x7F)
+		if head[5]&0x10 != 0 {
+			// A footer follows the tag
+			size += 10
+		}
+		if _, err := br.Discard(10 + size); err != nil {
+			return 0, fmt.Errorf("MP3 file ends inside its ID3 tag")
+		}
+	}
+
+	var stream *mp3Header
+	var at float64
+	// synced is set while each frame has followed straight on from the last
+	first, synced := true, false
+	for {
+		b, _ := br.Peek(4)
+		if len(b) < 4 {
+			break
+		}
+		h, ok := parseMP3Header(b)
+		if !ok || (stream != nil && !h.sameStream(*stream)) {
+			if string(b[:3]) == "TAG" && stream != nil {
+				// ID3v1 tag at the end
+				break
+			}
+			br.Discard(1)
+			synced = false
+			continue
+		}
+		frame, err := br.Peek(h.size)
+		if err != nil {
+			// The last frame is cut short
+			break
+		}
+		if !synced && !nextFrameFollows(br, h, stream != nil) {
+			// A sync pattern in tag or junk data is taken for a frame only
+			// if another frame of the stream follows it
+			br.Discard(1)
+			continue
+		}
+		synced = true
+		if stream == nil {
+			stream = &h
+		}
+		if first && h.vbrHeader(frame) {
+			first = false
+			br.Discard(h.size)
+			continue
+		}
+		first = false
+		if fn != nil {
+			if err := fn(frame, at); err != nil {
+				return 0, err
+			}
+		}
+		at += float64(h.samples) / float64(h.sampleRate)
+		br.Discard(h.size)
+	}
+	if stream == nil {
+		return 0, fmt.Errorf("no MPEG audio frames found")
+	}
+	return at, nil
+}
+
+// nextFrameFollows reports whether another frame header of the same stream
+// as h follows the frame h heads at the start of br. At the end of the file
+// there's nothing to check, and the frame is taken if the stream was already
+// found.
+func nextFrameFollows(br *bufio.Reader, h mp3Header, found bool) bool {
+	b, err := br.Peek(h.size + 4)
+	if err != nil {
+		return found
+	}
+	next, ok := parseMP3Header(b[h.size:])
+	return ok && next.sameStream(h)
+}
+
+// errCutDone stops mp3Frames once a cut has its last frame.
+var errCutDone = errors.New("cut done")
+
+// cutMP3 writes the frames of an MP3 file starting between start and end
+// seconds. The first frames may borrow bits from frames left out, which
+// decoders play as a moment of silence, as with ffmpeg's own copied cuts.
+func cutMP3(in io.Reader, out io.Writer, start, end float64) error {
+	_, err := mp3Frames(in, func(frame []byte, at float64) error {
+		if end > 0 && at >= end {
+			return errCutDone
+		}
+		if at < start {
+			return nil
+		}
+		_, err := out.Write(frame)
+		return err
+	})
+	if err == errCutDone {
+		return nil
+	}
+	return err
+}
+
+// oggDuration returns the duration of an Ogg Vorbis or Opus file from the
+// granule position of its last page.
+func oggDuration(f *os.File) (float64, error) {
+	page := make([]byte, 27+255)
+	n, _ := io.ReadFull(f, page)
+	page = page[:n]
+	if n < 27 || string(page[:4]) != "OggS" || n < 27+int(page[26]) {
+		return 0, fmt.Errorf("not an Ogg file")
+	}
+	serial := binary.LittleEndian.Uint32(page[14:])
+	packet := make([]byte, 19)
+	if _, err := f.ReadAt(packet, int64(27+int(page[26]))); err != nil {
+		return 0, fmt.Errorf("Ogg file ends in its first page")
+	}
+	var rate, skip float64
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		rate = float64(binary.LittleEndian.Uint32(packet[12:]))
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		// Opus granules count 48 kHz samples, whatever the input rate
+		rate, skip = 48000, float64(binary.LittleEndian.Uint16(packet[10:]))
+	default:
+		return 0, fmt.Errorf("only Ogg Vorbis and Opus files can be measured without ffprobe")
+	}
+	if rate == 0 {
+		return 0, fmt.Errorf("Ogg file has no sample rate")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// The last page is well within the last 64 KiB, pages being at most
+	// about 64 KiB long
+	tail := make([]byte, min(info.Size(), 1<<16+27+255))
+	if _, err := f.ReadAt(tail, info.Size()-int64(len(tail))); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to read audio file: %v", err)
+	}
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if i+27 > len(tail) || binary.LittleEndian.Uint32(tail[i+14:]) != serial {
+			continue
+		}
+		granule := binary.LittleEndian.Uint64(tail[i+6:])
+		if granule == ^uint64(0) {
+			// No packet ends on this page
+			continue
+		}
+		return max(float64(granule)-skip, 0) / rate, nil
+	}
+	return 0, fmt.Errorf("Ogg file has no last page")
+}
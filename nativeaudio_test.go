@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseMP3Header(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        []byte
+		ok            bool
+		version       int
+		layer         int
+		sampleRate    int
+		samples, size int
+	}{
+		{"MPEG-1 layer III 128k", []byte{0xFF, 0xFB, 0x90, 0x00}, true, 1, 3, 44100, 1152, 417},
+		{"padded", []byte{0xFF, 0xFB, 0x92, 0x00}, true, 1, 3, 44100, 1152, 418},
+		{"MPEG-2 layer III 64k", []byte{0xFF, 0xF3, 0x80, 0x00}, true, 2, 3, 22050, 576, 208},
+		{"MPEG-1 layer II 192k", []byte{0xFF, 0xFD, 0xA4, 0x00}, true, 1, 2, 48000, 1152, 576},
+		{"free format", []byte{0xFF, 0xFB, 0x00, 0x00}, false, 0, 0, 0, 0, 0},
+		{"bad bitrate", []byte{0xFF, 0xFB, 0xF0, 0x00}, false, 0, 0, 0, 0, 0},
+		{"reserved sample rate", []byte{0xFF, 0xFB, 0x9C, 0x00}, false, 0, 0, 0, 0, 0},
+		{"reserved version", []byte{0xFF, 0xEB, 0x90, 0x00}, false, 0, 0, 0, 0, 0},
+		{"reserved layer", []byte{0xFF, 0xF9, 0x90, 0x00}, false, 0, 0, 0, 0, 0},
+		{"no sync", []byte{0x49, 0x44, 0x33, 0x04}, false, 0, 0, 0, 0, 0},
+		{"short", []byte{0xFF, 0xFB}, false, 0, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		h, ok := parseMP3Header(tt.header)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if h.version != tt.version || h.layer != tt.layer || h.sampleRate != tt.sampleRate || h.samples != tt.samples || h.size != tt.size {
+			t.Errorf("%s: got version %d layer %d rate %d samples %d size %d, want %d %d %d %d %d", tt.name,
+				h.version, h.layer, h.sampleRate, h.samples, h.size, tt.version, tt.layer, tt.sampleRate, tt.samples, tt.size)
+		}
+	}
+}
+
+// testMP3Frame is an MPEG-1 layer III frame at 128 kbit/s and 44.1 kHz,
+// 1152 samples long, with its audio data filled with fill.
+func testMP3Frame(fill byte) []byte {
+	frame := bytes.Repeat([]byte{fill}, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return frame
+}
+
+const testMP3FrameSeconds = 1152.0 / 44100
+
+func TestMP3Frames(t *testing.T) {
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x05"), "abcde"...)
+	frames := func(n int) []byte {
+		var b []byte
+		for i := 0; i < n; i++ {
+			b = append(b, testMP3Frame(byte(i))...)
+		}
+		return b
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+	}{
+		{"bare frames", frames(10), 10},
+		{"ID3v2 tag", join(id3, frames(10)), 10},
+		{"junk with a sync pattern", join([]byte{0, 1, 0xFF, 0xFB, 0x90, 0x00, 9, 9}, frames(10)), 10},
+		{"junk between frames", join(frames(4), []byte{0xFF, 0xFB, 0x90, 0x00, 1, 2, 3}, frames(6)), 10},
+		{"ID3v1 tag", join(frames(10), []byte("TAG"), make([]byte, 125)), 10},
+		{"truncated last frame", join(frames(10), testMP3Frame(0)[:100]), 10},
+	}
+	for _, tt := range tests {
+		var n int
+		d, err := mp3Frames(bytes.NewReader(tt.data), func(frame []byte, at float64) error {
+			if len(frame) != 417 || frame[0] != 0xFF {
+				t.Errorf("%s: frame %d isn't a whole frame", tt.name, n)
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if n != tt.frames || math.Abs(d-float64(tt.frames)*testMP3FrameSeconds) > 1e-9 {
+			t.Errorf("%s: %d frames, %.4fs, want %d frames", tt.name, n, d, tt.frames)
+		}
+	}
+	if _, err := mp3Frames(bytes.NewReader([]byte("not audio at all")), nil); err == nil {
+		t.Error("no frames: want an error")
+	}
+}
+
+func TestCutMP3(t *testing.T) {
+	var in bytes.Buffer
+	for i := 0; i < 100; i++ {
+		in.Write(testMP3Frame(byte(i)))
+	}
+	tests := []struct {
+		start, end    float64
+		first, frames int
+	}{
+		{0, 0, 0, 100},
+		{0, 1, 0, 39},
+		{1, 2, 39, 38},
+		{2, 0, 77, 23},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := cutMP3(bytes.NewReader(in.Bytes()), &out, tt.start, tt.end); err != nil {
+			t.Errorf("cut %g-%g: %v", tt.start, tt.end, err)
+			continue
+		}
+		if got := out.Len() / 417; got != tt.frames || out.Len()%417 != 0 {
+			t.Errorf("cut %g-%g: %d bytes, want %d frames", tt.start, tt.end, out.Len(), tt.frames)
+		} else if out.Bytes()[4] != byte(tt.first) {
+			t.Errorf("cut %g-%g starts at frame %d, want %d", tt.start, tt.end, out.Bytes()[4], tt.first)
+		}
+	}
+}
+
+// writeTestWAV writes one second of 8 kHz 16-bit mono PCM, whose samples
+// count up, with an odd-sized LIST chunk before the data.
+func writeTestWAV(t *testing.T) string {
+	t.Helper()
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:], 1)
+	binary.LittleEndian.PutUint16(format[2:], 1)
+	binary.LittleEndian.PutUint32(format[4:], 8000)
+	binary.LittleEndian.PutUint32(format[8:], 16000)
+	binary.LittleEndian.PutUint16(format[12:], 2)
+	binary.LittleEndian.PutUint16(format[14:], 16)
+	data := make([]byte, 16000)
+	for i := 0; i < 8000; i++ {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(i))
+	}
+	var b bytes.Buffer
+	chunk := func(id string, body []byte) {
+		b.WriteString(id)
+		binary.Write(&b, binary.LittleEndian, uint32(len(body)))
+		b.Write(body)
+		if len(body)%2 == 1 {
+			b.WriteByte(0)
+		}
+	}
+	b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	chunk("fmt ", format)
+	chunk("LIST", []byte("odd"))
+	chunk("data", data)
+	path := filepath.Join(t.TempDir(), "test.wav")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWAVDurationAndCut(t *testing.T) {
+	path := writeTestWAV(t)
+	d, err := nativeDuration(path)
+	if err != nil || d != 1 {
+		t.Fatalf("duration = %g, %v, want 1", d, err)
+	}
+	tests := []struct {
+		start, end float64
+		first      uint16
+		samples    int
+	}{
+		{0, 0, 0, 8000},
+		{0.25, 0.5, 2000, 2000},
+		{0.5, 0, 4000, 4000},
+		{0.9, 2, 7200, 800},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(t.TempDir(), "cut.wav")
+		if err := nativeCut(path, out, tt.start, tt.end); err != nil {
+			t.Errorf("cut %g-%g: %v", tt.start, tt.end, err)
+			continue
+		}
+		f, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := readWAV(f)
+		if err != nil {
+			f.Close()
+			t.Errorf("cut %g-%g: %v", tt.start, tt.end, err)
+			continue
+		}
+		first := make([]byte, 2)
+		f.ReadAt(first, w.dataOffset)
+		f.Close()
+		if w.dataSize != int64(2*tt.samples) || binary.LittleEndian.Uint16(first) != tt.first {
+			t.Errorf("cut %g-%g: %d samples from %d, want %d from %d", tt.start, tt.end,
+				w.dataSize/2, binary.LittleEndian.Uint16(first), tt.samples, tt.first)
+		}
+	}
+}
+
+func TestReadWAVErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"not RIFF":     []byte("RIFX\x00\x00\x00\x00WAVE"),
+		"no data":      []byte("RIFF\x00\x00\x00\x00WAVE"),
+		"data first":   []byte("RIFF\x00\x00\x00\x00WAVEdata\x04\x00\x00\x00abcd"),
+		"tiny fmt":     []byte("RIFF\x00\x00\x00\x00WAVEfmt \x04\x00\x00\x00abcd"),
+		"empty header": nil,
+	}
+	for name, data := range tests {
+		path := filepath.Join(t.TempDir(), "bad.wav")
+		os.WriteFile(path, data, 0644)
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readWAV(f); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+		f.Close()
+	}
+}
+
+func TestCheckCuttableWithoutFFmpeg(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	dir := t.TempDir()
+	ogg := filepath.Join(dir, "episode.ogg")
+	if err := os.WriteFile(ogg, append([]byte("OggS"), make([]byte, 60)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, flag string
+		err        bool
+	}{
+		{writeTestWAV(t), "-chunk", false},
+		{ogg, "-chunk", true},
+		{ogg, "-from/-to", true},
+	}
+	for _, tt := range tests {
+		err := checkCuttable(tt.path, tt.flag)
+		if (err != nil) != tt.err {
+			t.Errorf("checkCuttable(%s, %s) error = %v, want error %v", filepath.Base(tt.path), tt.flag, err, tt.err)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), tt.flag+" needs ffmpeg") {
+			t.Errorf("checkCuttable(%s, %s) error = %q, want it to name the flag", filepath.Base(tt.path), tt.flag, err)
+		}
+	}
+}
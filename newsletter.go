@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// newsletterResponseFormat is the JSON schema of the newsletter reply.
+var newsletterResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "newsletter",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"subject":   map[string]any{"type": "string", "description": "Email subject line, under 70 characters"},
+				"preheader": map[string]any{"type": "string", "description": "Preview text shown after the subject, under 100 characters"},
+				"summary":   map[string]any{"type": "string", "description": "One paragraph on what the episode is about"},
+				"highlights": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":     map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn where the moment starts, copied from the transcript"},
+							"headline": map[string]any{"type": "string", "description": "A few words naming the moment"},
+							"text":     map[string]any{"type": "string", "description": "One or two sentences on what is said"},
+						},
+						"required":             []string{"time", "headline", "text"},
+						"additionalProperties": false,
+					},
+				},
+				"closing": map[string]any{"type": "string", "description": "A sentence inviting the reader to listen"},
+			},
+			"required":             []string{"subject", "preheader", "summary", "highlights", "closing"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// newsletterPrompt asks for the parts of an episode newsletter.
+const newsletterPrompt = `Write an email newsletter about the following podcast episode for its subscribers. Give a subject line, a preheader, a one-paragraph summary, three to six highlights of the best moments in the order they happen, and a closing line. Each highlight starts at the timestamp of a turn in the transcript, copied exactly. Be specific and concrete; don't invent anything that isn't in the transcript.
+%s
+Transcript:
+%s`
+
+// newsletter is an episode digest for subscribers.
+type newsletter struct {
+	Title      string
+	Date       string
+	Subject    string                `json:"subject"`
+	Preheader  string                `json:"preheader"`
+	Summary    string                `json:"summary"`
+	Highlights []newsletterHighlight `json:"highlights"`
+	Closing    string                `json:"closing"`
+}
+
+// newsletterHighlight is a moment of the episode worth listening to.
+type newsletterHighlight struct {
+	Time     string `json:"time"`
+	Headline string `json:"headline"`
+	Text     string `json:"text"`
+}
+
+// draftNewsletter asks the summary model for a newsletter about t. Highlight
+// times are checked against the transcript and snapped to the start of the
+// turn they fall in.
+func (p *Pipeline) draftNewsletter(ctx context.Context, apiKey string, t *Transcript) (*newsletter, TokenUsage, error) {
+	var episode string
+	if t.Title != "" {
+		episode += "\nEpisode title: " + t.Title + "\n"
+	}
+	if t.Description != "" {
+		episode += "Episode description: " + t.Description + "\n"
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(newsletterPrompt, episode, fitContext(formatTimedTurns(t.Segments), p.config.SummaryModel))}},
+		"temperature":     p.config.Temperature,
+		"response_format": newsletterResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to draft newsletter: %v", err)
+	}
+	n := &newsletter{Title: t.Title, Date: t.Date}
+	if err := json.Unmarshal([]byte(content), n); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode newsletter: %v", err)
+	}
+	var highlights []newsletterHighlight
+	for _, h := range n.Highlights {
+		at, err := parseClock(h.Time)
+		if err != nil || (t.Duration > 0 && at > t.Duration) {
+			continue
+		}
+		h.Time = formatTimestamp(turnStart(t.Segments, at), ".")[:8]
+		highlights = append(highlights, h)
+	}
+	n.Highlights = highlights
+	return n, usage, nil
+}
+
+// turnStart returns the start of the segment that at seconds falls in.
+func turnStart(segments []Segment, at float64) float64 {
+	start := 0.0
+	for _, s := range segments {
+		if s.Start > at {
+			break
+		}
+		start = s.Start
+	}
+	return start
+}
+
+// newsletterHTML is the HTML newsletter. Email clients ignore style sheets, so
+// the styles are inline.
+var newsletterHTML = template.Must(template.New("newsletter").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Subject}}</title>
+</head>
+<body style="margin:0;padding:0;background:#f4f4f4;">
+<span style="display:none;max-height:0;overflow:hidden;">{{.Preheader}}</span>
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="background:#f4f4f4;">
+<tr><td align="center" style="padding:24px 12px;">
+<table role="presentation" width="600" cellpadding="0" cellspacing="0" style="max-width:600px;background:#ffffff;font-family:Helvetica,Arial,sans-serif;color:#222222;line-height:1.5;">
+<tr><td style="padding:28px 32px 8px;">
+{{- if .Title}}<p style="margin:0;font-size:13px;color:#777777;text-transform:uppercase;letter-spacing:1px;">{{.Title}}{{if .Date}} &middot; {{.Date}}{{end}}</p>{{end}}
+<h1 style="margin:8px 0 0;font-size:24px;">{{.Subject}}</h1>
+</td></tr>
+<tr><td style="padding:8px 32px;"><p style="margin:0;font-size:16px;">{{.Summary}}</p></td></tr>
+{{- if .Highlights}}
+<tr><td style="padding:16px 32px 0;"><h2 style="margin:0;font-size:18px;">Highlights</h2></td></tr>
+{{- range .Highlights}}
+<tr><td style="padding:12px 32px 0;">
+<p style="margin:0;font-size:16px;"><span style="font-family:monospace;color:#777777;">{{.Time}}</span> <strong>{{.Headline}}</strong></p>
+<p style="margin:4px 0 0;font-size:15px;">{{.Text}}</p>
+</td></tr>
+{{- end}}
+{{- end}}
+<tr><td style="padding:24px 32px 32px;"><p style="margin:0;font-size:16px;">{{.Closing}}</p></td></tr>
+</table>
+</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// html renders the newsletter as an email-safe HTML page.
+func (n *newsletter) html() (string, error) {
+	var b strings.Builder
+	if err := newsletterHTML.Execute(&b, n); err != nil {
+		return "", fmt.Errorf("failed to render newsletter: %v", err)
+	}
+	return b.String(), nil
+}
+
+// text renders the newsletter as plain text.
+func (n *newsletter) text() string {
+	var b strings.Builder
+	if n.Title != "" {
+		b.WriteString(n.Title)
+		if n.Date != "" {
+			b.WriteString(" · " + n.Date)
+		}
+		b.WriteString("\n\n")
+	}
+	fmt.Fprintf(&b, "%s\n\n%s\n", n.Subject, n.Summary)
+	if len(n.Highlights) > 0 {
+		b.WriteString("\nHighlights\n\n")
+		for _, h := range n.Highlights {
+			fmt.Fprintf(&b, "%s  %s\n    %s\n", h.Time, h.Headline, h.Text)
+		}
+	}
+	fmt.Fprintf(&b, "\n%s\n", n.Closing)
+	return b.String()
+}
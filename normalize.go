@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// normalizers are the text normalizations -normalize can turn on.
+var normalizers = []string{"numbers", "currency", "acronyms"}
+
+// parseNormalize parses the -normalize list; "all" turns on every normalizer.
+func parseNormalize(s string) (map[string]bool, error) {
+	enabled := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "":
+		case name == "all":
+			for _, n := range normalizers {
+				enabled[n] = true
+			}
+		case containsString(normalizers, name):
+			enabled[name] = true
+		default:
+			return nil, fmt.Errorf("unknown normalization %q (available: all, %s)", name, strings.Join(normalizers, ", "))
+		}
+	}
+	return enabled, nil
+}
+
+// normalizeTranscript rewrites spelled-out numbers, currency amounts and letter
+// by letter acronyms in the segments of t in one consistent style, whatever
+// style the transcription model happened to use. It returns the number of
+// rewrites.
+func normalizeTranscript(t *Transcript, enabled map[string]bool) int {
+	if len(enabled) == 0 {
+		return 0
+	}
+	count := 0
+	for i := range t.Segments {
+		if t.Segments[i].Kind == eventKind {
+			continue
+		}
+		count += rewriteSegment(&t.Segments[i], func(rest []string, _ float64) (int, []string) {
+			return normalizeAt(rest, enabled)
+		})
+	}
+	if count > 0 {
+		t.Text = paragraphText(t.Segments)
+	}
+	return count
+}
+
+// rewriteSegment walks the tokens of s, letting rewrite replace the n tokens at
+// the start of the rest of the segment with others; start is the time of the
+// first of them. It returns the number of replacements made. Word timings are
+// kept in step when the segment has one timed word per token.
+func rewriteSegment(s *Segment, rewrite func(rest []string, start float64) (int, []string)) int {
+	tokens := strings.Fields(s.Text)
+	timed := len(s.Words) == len(tokens)
+	count := 0
+	for pos := 0; pos < len(tokens); pos++ {
+		start := s.Start
+		if timed {
+			start = s.Words[pos].Start
+		}
+		n, repl := rewrite(tokens[pos:], start)
+		if n == 0 {
+			continue
+		}
+		if repl == nil || strings.Join(repl, " ") == strings.Join(tokens[pos:pos+n], " ") {
+			pos += n - 1
+			continue
+		}
+		if timed {
+			s.Words = replaceWords(s.Words, pos, n, repl)
+		}
+		tokens = append(tokens[:pos], append(repl, tokens[pos+n:]...)...)
+		pos += len(repl) - 1
+		count++
+	}
+	if count > 0 {
+		s.Text = strings.Join(tokens, " ")
+	}
+	return count
+}
+
+// normalizeAt rewrites an acronym or number at the start of tokens, returning
+// the number of tokens consumed and their replacement, or 0.
+func normalizeAt(tokens []string, enabled map[string]bool) (int, []string) {
+	if enabled["acronyms"] {
+		if n, acronym := spelledAcronym(tokens); n > 0 {
+			return n, []string{acronym}
+		}
+	}
+	if !enabled["numbers"] && !enabled["currency"] {
+		return 0, nil
+	}
+
+	prefix, _, _ := splitPunct(tokens[0])
+	num, n, spelled := spelledNumber(tokens)
+	if !enabled["numbers"] {
+		n, spelled = 0, false
+	}
+	if n == 0 {
+		if _, core, _ := splitPunct(tokens[0]); digitNumber.MatchString(core) {
+			num, n = core, 1
+		} else {
+			return 0, nil
+		}
+	}
+	_, _, suffix := splitPunct(tokens[n-1])
+	if suffix == "" && n < len(tokens) {
+		_, unit, unitSuffix := splitPunct(tokens[n])
+		unit = strings.ToLower(unit)
+		if symbol, ok := currencySymbols[unit]; ok && enabled["currency"] {
+			amount := num
+			used := n + 1
+			if unitSuffix == "" {
+				if cents, k := centsAfter(tokens[used:], enabled["numbers"]); k > 0 && !strings.ContainsAny(num, ". ") {
+					amount += "." + cents
+					used += k
+					_, _, unitSuffix = splitPunct(tokens[used-1])
+				}
+			}
+			return used, strings.Fields(prefix + symbol + amount + unitSuffix)
+		}
+		if unit == "percent" && enabled["numbers"] {
+			return n + 1, []string{prefix + num + "%" + unitSuffix}
+		}
+	}
+	if !spelled {
+		return 0, nil
+	}
+	return n, strings.Fields(prefix + num + suffix)
+}
+
+// currencySymbols maps spoken currency units to their symbols. Pounds are left
+// out since they are as often a weight.
+var currencySymbols = map[string]string{
+	"dollar": "$", "dollars": "$", "bucks": "$",
+	"euro": "€", "euros": "€",
+}
+
+// digitNumber matches a number already written in digits, such as "1,200" or "4.99".
+var digitNumber = regexp.MustCompile(`^\d{1,3}(,\d{3})*(\.\d+)?$|^\d+(\.\d+)?$`)
+
+// digitCents matches cents written in digits.
+var digitCents = regexp.MustCompile(`^\d{1,2}$`)
+
+// centsAfter parses "and ninety-nine cents" after a dollar amount, returning the
+// cents as two digits and the number of tokens used.
+func centsAfter(tokens []string, spelledOK bool) (string, int) {
+	if len(tokens) < 3 || strings.ToLower(tokens[0]) != "and" {
+		return "", 0
+	}
+	var cents string
+	var n int
+	if _, core, suffix := splitPunct(tokens[1]); suffix == "" && digitCents.MatchString(core) {
+		cents, n = core, 1
+	} else if spelledOK {
+		cents, n, _ = spelledNumber(tokens[1:])
+	}
+	if n == 0 || n+1 >= len(tokens) {
+		return "", 0
+	}
+	if _, unit, _ := splitPunct(tokens[n+1]); !strings.EqualFold(unit, "cents") && !strings.EqualFold(unit, "cent") {
+		return "", 0
+	}
+	v, err := strconv.Atoi(cents)
+	if err != nil || v >= 100 {
+		return "", 0
+	}
+	return fmt.Sprintf("%02d", v), n + 2
+}
+
+// splitPunct splits a token into leading punctuation, the word, and trailing
+// punctuation.
+func splitPunct(token string) (prefix, core, suffix string) {
+	core = strings.TrimLeftFunc(token, isPunct)
+	prefix = token[:len(token)-len(core)]
+	trimmed := strings.TrimRightFunc(core, isPunct)
+	return prefix, trimmed, core[len(trimmed):]
+}
+
+// Number words by kind.
+var (
+	unitWords = map[string]int64{
+		"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+		"six": 6, "seven": 7, "eight": 8, "nine": 9,
+	}
+	teenWords = map[string]int64{
+		"ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14,
+		"fifteen": 15, "sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19,
+	}
+	tensWords = map[string]int64{
+		"twenty": 20, "thirty": 30, "forty": 40, "fifty": 50,
+		"sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+	}
+	scaleWords = map[string]int64{
+		"thousand": 1e3, "million": 1e6, "billion": 1e9, "trillion": 1e12,
+	}
+)
+
+// The kinds of word a spelled number is built from.
+const (
+	numNone = iota
+	numUnit
+	numTeen
+	numTens
+	numHundred
+	numScale
+)
+
+// spelledNumber parses a number written out in words at the start of tokens,
+// e.g. "two hundred and five", "twenty-four", "nineteen eighty-four" (a year),
+// "two point five million". It returns the number in digits, the number of
+// tokens used, and whether it is worth rewriting: numbers below ten stay words,
+// as most style guides keep them.
+func spelledNumber(tokens []string) (string, int, bool) {
+	if year, n := spelledYear(tokens); n > 0 {
+		return year, n, true
+	}
+
+	var total, current, lastScale int64
+	last := numNone
+	used, words := 0, 0
+	decimals := ""
+	pendingAnd := false
+	for i, token := range tokens {
+		prefix, core, suffix := splitPunct(token)
+		if i > 0 && prefix != "" {
+			break
+		}
+		core = strings.ToLower(core)
+		if core == "and" && (last == numHundred || last == numScale) && suffix == "" && !pendingAnd {
+			pendingAnd = true
+			continue
+		}
+		if core == "a" && i == 0 && len(tokens) > 1 {
+			// "a hundred", "a thousand"
+			_, next, _ := splitPunct(tokens[1])
+			if next = strings.ToLower(next); next == "hundred" || scaleWords[next] > 0 {
+				current, last, used = 1, numUnit, 1
+				continue
+			}
+			break
+		}
+		if core == "point" && decimals == "" && last != numNone && last != numScale && suffix == "" {
+			digits, k := spelledDigits(tokens[i+1:])
+			if k == 0 {
+				break
+			}
+			decimals = digits
+			used = i + 1 + k
+			words += k + 1
+			_, _, s := splitPunct(tokens[used-1])
+			if s != "" || used >= len(tokens) {
+				break
+			}
+			// Only a scale may follow the decimals: "two point five million"
+			_, next, _ := splitPunct(tokens[used])
+			if scale, ok := scaleWords[strings.ToLower(next)]; ok && scale >= 1e6 {
+				return formatNumber(total+current, decimals, scale, true), used + 1, true
+			}
+			break
+		}
+		if decimals != "" {
+			break
+		}
+
+		ok := true
+		for _, w := range strings.Split(core, "-") {
+			if !feedNumberWord(w, &total, &current, &lastScale, &last) {
+				ok = false
+				break
+			}
+			words++
+		}
+		if !ok {
+			break
+		}
+		pendingAnd = false
+		used = i + 1
+		if suffix != "" {
+			break
+		}
+	}
+	if used == 0 {
+		return "", 0, false
+	}
+	value := total + current
+	if decimals == "" && words == 1 && value < 10 {
+		return strconv.FormatInt(value, 10), used, false
+	}
+	if decimals == "" && current == 0 && lastScale >= 1e6 && last == numScale {
+		// "five million" reads better as "5 million" than "5,000,000"
+		return formatNumber(total/lastScale, "", lastScale, true), used, true
+	}
+	return formatNumber(value, decimals, 1, false), used, true
+}
+
+// feedNumberWord adds one number word to a number being parsed, reporting false
+// if the word isn't a number word or can't follow the previous one, as in "one
+// two" or "twenty thirty".
+func feedNumberWord(w string, total, current, lastScale *int64, last *int) bool {
+	if v, ok := unitWords[w]; ok {
+		if *last != numNone && *last != numTens && *last != numHundred && *last != numScale {
+			return false
+		}
+		*current += v
+		*last = numUnit
+		return true
+	}
+	if v, ok := teenWords[w]; ok {
+		if *last != numNone && *last != numHundred && *last != numScale {
+			return false
+		}
+		*current += v
+		*last = numTeen
+		return true
+	}
+	if v, ok := tensWords[w]; ok {
+		if *last != numNone && *last != numHundred && *last != numScale {
+			return false
+		}
+		*current += v
+		*last = numTens
+		return true
+	}
+	if w == "hundred" {
+		if *current <= 0 || *current >= 100 || *last == numHundred || *last == numScale {
+			return false
+		}
+		*current *= 100
+		*last = numHundred
+		return true
+	}
+	if scale, ok := scaleWords[w]; ok {
+		if *current <= 0 || (*lastScale != 0 && scale >= *lastScale) {
+			return false
+		}
+		*total += *current * scale
+		*current = 0
+		*lastScale = scale
+		*last = numScale
+		return true
+	}
+	return false
+}
+
+// spelledYear parses a year said as two pairs of digits, such as "nineteen
+// eighty-four", "twenty twenty four" or "nineteen oh five".
+func spelledYear(tokens []string) (string, int) {
+	if len(tokens) < 2 {
+		return "", 0
+	}
+	_, first, suffix := splitPunct(tokens[0])
+	first = strings.ToLower(first)
+	century, ok := teenWords[first]
+	if !ok {
+		century, ok = tensWords[first]
+	}
+	if !ok || century < 15 || century > 20 || suffix != "" {
+		return "", 0
+	}
+
+	_, second, suffix := splitPunct(tokens[1])
+	second = strings.ToLower(second)
+	parts := strings.Split(second, "-")
+	var rest int64
+	used := 2
+	switch {
+	case second == "oh" && len(tokens) > 2 && suffix == "":
+		_, unit, _ := splitPunct(tokens[2])
+		v, ok := unitWords[strings.ToLower(unit)]
+		if !ok || v == 0 {
+			return "", 0
+		}
+		rest, used = v, 3
+	case len(parts) == 1 && teenWords[second] > 0:
+		rest = teenWords[second]
+	case tensWords[parts[0]] > 0:
+		rest = tensWords[parts[0]]
+		switch {
+		case len(parts) == 2:
+			v, ok := unitWords[parts[1]]
+			if !ok || v == 0 {
+				return "", 0
+			}
+			rest += v
+		case suffix == "" && len(tokens) > 2:
+			_, unit, _ := splitPunct(tokens[2])
+			if v, ok := unitWords[strings.ToLower(unit)]; ok && v > 0 {
+				rest += v
+				used = 3
+			}
+		}
+	default:
+		return "", 0
+	}
+	_, _, suffix = splitPunct(tokens[used-1])
+	if used < len(tokens) && suffix == "" {
+		// "twenty twenty thousand" is not a year
+		_, next, _ := splitPunct(tokens[used])
+		next = strings.ToLower(next)
+		if next == "hundred" || scaleWords[next] > 0 {
+			return "", 0
+		}
+	}
+	return strconv.FormatInt(century*100+rest, 10), used
+}
+
+// spelledDigits parses the digits after "point", e.g. "five", "oh seven".
+func spelledDigits(tokens []string) (string, int) {
+	var digits strings.Builder
+	n := 0
+	for _, token := range tokens {
+		_, core, suffix := splitPunct(token)
+		core = strings.ToLower(core)
+		if core == "oh" {
+			core = "zero"
+		}
+		v, ok := unitWords[core]
+		if !ok {
+			break
+		}
+		digits.WriteString(strconv.FormatInt(v, 10))
+		n++
+		if suffix != "" {
+			break
+		}
+	}
+	return digits.String(), n
+}
+
+// formatNumber writes value in digits, grouping thousands from 10,000 up so that
+// years and four-digit numbers stay ungrouped. With named set, the scale is
+// written as a word: formatNumber(5, "", 1e6, true) is "5 million".
+func formatNumber(value int64, decimals string, scale int64, named bool) string {
+	s := strconv.FormatInt(value, 10)
+	if value >= 10000 {
+		var b strings.Builder
+		for i, r := range s {
+			if i > 0 && (len(s)-i)%3 == 0 {
+				b.WriteByte(',')
+			}
+			b.WriteRune(r)
+		}
+		s = b.String()
+	}
+	if decimals != "" {
+		s += "." + decimals
+	}
+	if named {
+		for word, v := range scaleWords {
+			if v == scale {
+				s += " " + word
+			}
+		}
+	}
+	return s
+}
+
+// dottedAcronym matches an acronym written with dots, such as "U.S" or "a.p.i",
+// once its trailing punctuation is split off.
+var dottedAcronym = regexp.MustCompile(`^[A-Za-z](\.[A-Za-z])+$`)
+
+// notAcronyms are dotted abbreviations that must keep their dots.
+var notAcronyms = map[string]bool{"EG": true, "IE": true, "AM": true, "PM": true}
+
+// spelledAcronym joins an acronym transcribed letter by letter, such as "a p i",
+// "A. P. I." or "a.p.i.", into "API". A run of only the words "a" and "I",
+// such as "a I", is left alone, as is a run of two letters one of which is
+// such a word, such as "a B". A final dot is kept only at the end of a
+// segment, where it also ends the sentence.
+func spelledAcronym(tokens []string) (int, string) {
+	prefix, core, suffix := splitPunct(tokens[0])
+	if dottedAcronym.MatchString(core) {
+		letters := strings.ToUpper(strings.ReplaceAll(core, ".", ""))
+		if notAcronyms[letters] {
+			return 0, ""
+		}
+		return 1, prefix + letters + acronymEnd(suffix, len(tokens) == 1)
+	}
+
+	var letters strings.Builder
+	n := 0
+	wordLike := 0
+	end := ""
+	for i, token := range tokens {
+		p, core, s := splitPunct(token)
+		if len(core) != 1 || !isASCIILetter(core[0]) || (i > 0 && p != "") {
+			break
+		}
+		if s == "" && (core == "a" || core == "i" || core == "I") {
+			wordLike++
+		}
+		letters.WriteString(strings.ToUpper(core))
+		n++
+		if s != "" && s != "." {
+			// Punctuation other than the letter's own dot ends the run
+			end = acronymEnd(s, false)
+			break
+		}
+		if s == "." && i == len(tokens)-1 {
+			end = "."
+		}
+	}
+	if n < 2 || wordLike == n || (wordLike > 0 && n < 3) {
+		return 0, ""
+	}
+	return n, prefix + letters.String() + end
+}
+
+// acronymEnd returns the punctuation to keep after a joined acronym: what
+// followed its last letter, less the letter's own dot unless last is set.
+func acronymEnd(suffix string, last bool) string {
+	if last {
+		return suffix
+	}
+	return strings.TrimPrefix(suffix, ".")
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
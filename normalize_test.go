@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestNormalizeAcronyms(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"call the a p i first", "call the API first"},
+		{"i b m shipped it", "IBM shipped it"},
+		{"A. P. I. calls", "API calls"},
+		{"the a.p.i. is down", "the API is down"},
+		{"it ends with a p i.", "it ends with API."},
+		{"we use a i for that", "we use a i for that"},
+		{"a B grade", "a B grade"},
+		{"no letters here", "no letters here"},
+		{"i.e. later", "i.e. later"},
+	}
+	enabled := map[string]bool{"acronyms": true}
+	for _, tt := range tests {
+		tr := &Transcript{Segments: []Segment{{Text: tt.in}}}
+		normalizeTranscript(tr, enabled)
+		if got := tr.Segments[0].Text; got != tt.want {
+			t.Errorf("normalize %q = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeNumbers(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"twenty five people", "25 people"},
+		{"it cost five dollars", "it cost $5"},
+		{"one million downloads", "1 million downloads"},
+	}
+	enabled := map[string]bool{"numbers": true, "currency": true}
+	for _, tt := range tests {
+		tr := &Transcript{Segments: []Segment{{Text: tt.in}}}
+		normalizeTranscript(tr, enabled)
+		if got := tr.Segments[0].Text; got != tt.want {
+			t.Errorf("normalize %q = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
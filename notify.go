@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyTimeout is how long a sink may take to accept a notification.
+const notifyTimeout = 10 * time.Second
+
+// The events of a run that notifications are sent for.
+const (
+	notifyJobStarted  = "job-started"
+	notifyChunkDone   = "chunk-done"
+	notifyStageFailed = "stage-failed"
+	notifyJobComplete = "job-complete"
+)
+
+// notifyEvents lists the events, for validating the config file's filters.
+var notifyEvents = []string{notifyJobStarted, notifyChunkDone, notifyStageFailed, notifyJobComplete}
+
+// notification is what sinks are told of an event. Webhooks receive it as
+// JSON; the other sinks render it with text.
+type notification struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Job       string    `json:"job,omitempty"`
+	Input     string    `json:"input,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	OutputDir string    `json:"output_dir,omitempty"`
+	// Stage is the stage that failed, for stage-failed.
+	Stage string `json:"stage,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Chunk counts from 1 of Chunks, for chunk-done. Restored marks a chunk
+	// a resumed run took from its checkpoint.
+	Chunk    int  `json:"chunk,omitempty"`
+	Chunks   int  `json:"chunks,omitempty"`
+	Restored bool `json:"restored,omitempty"`
+	// The rest are set for job-complete.
+	Outputs  []string `json:"outputs,omitempty"`
+	Seconds  float64  `json:"duration_seconds,omitempty"`
+	Cost     float64  `json:"cost_usd,omitempty"`
+	Warnings int      `json:"warnings,omitempty"`
+}
+
+// text renders the notification as one line for people to read.
+func (n notification) text() string {
+	name := n.Title
+	if name == "" && n.Input != "" {
+		name = filepath.Base(n.Input)
+	}
+	name = firstNonEmpty(name, "the run")
+	switch n.Event {
+	case notifyJobStarted:
+		return "Started " + name
+	case notifyChunkDone:
+		return fmt.Sprintf("Chunk %d/%d of %s done", n.Chunk, n.Chunks, name)
+	case notifyStageFailed:
+		return fmt.Sprintf("%s failed in %s: %s", name, firstNonEmpty(n.Stage, "setup"), n.Error)
+	case notifyJobComplete:
+		text := fmt.Sprintf("Finished %s in %s (%s), %d output(s)", name, formatElapsed(n.Seconds), formatCost(n.Cost), len(n.Outputs))
+		if n.Warnings > 0 {
+			text += fmt.Sprintf(", %d warning(s)", n.Warnings)
+		}
+		return text
+	}
+	return n.Event + ": " + name
+}
+
+// notifySinkKind is a kind of sink notifications can be sent to.
+type notifySinkKind struct {
+	// target names what follows the kind and a colon, such as URL, or is
+	// empty for kinds that take nothing. optional marks it as optional.
+	target   string
+	optional bool
+	send     func(p *Pipeline, ctx context.Context, target string, n notification) error
+}
+
+// notifySinkKinds is the registry of sink kinds, by name.
+var notifySinkKinds = map[string]notifySinkKind{
+	"log":     {target: "FILE", optional: true, send: (*Pipeline).notifyLog},
+	"webhook": {target: "URL", send: (*Pipeline).notifyWebhook},
+	"slack":   {target: "URL", send: (*Pipeline).notifySlack},
+	"desktop": {send: (*Pipeline).notifyDesktop},
+	"metrics": {target: "FILE", send: (*Pipeline).notifyMetrics},
+}
+
+// notifySinkNames lists the sink kinds with their targets for messages.
+func notifySinkNames() string {
+	var names []string
+	for name, kind := range notifySinkKinds {
+		switch {
+		case kind.target == "":
+		case kind.optional:
+			name += "[:" + kind.target + "]"
+		default:
+			name += ":" + kind.target
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// notifySettings is a sink of the configuration file, told of every run.
+type notifySettings struct {
+	// Sink is written as for -notify, e.g. slack:https://hooks.slack.com/...
+	Sink string `json:"sink"`
+	// Events are the events sent to the sink; all of them if empty.
+	Events []string `json:"events,omitempty"`
+}
+
+// notifySink is a sink a run's notifications are sent to.
+type notifySink struct {
+	kind, target string
+	// events are the events sent to the sink; all of them if empty.
+	events map[string]bool
+}
+
+// parseNotifySinks reads the comma-separated sinks of -notify, which are
+// sent every event, and adds those of the configuration file.
+func parseNotifySinks(s string, fc *FileConfig) ([]notifySink, error) {
+	var sinks []notifySink
+	for _, spec := range splitList(s) {
+		sink, err := parseNotifySink(spec, nil)
+		if err != nil {
+			return nil, fmt.Errorf("-notify %s: %v", spec, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	for _, settings := range fc.Notify {
+		sink, err := parseNotifySink(settings.Sink, fc)
+		if err != nil {
+			return nil, fmt.Errorf("config file notify %s: %v", settings.Sink, err)
+		}
+		for _, event := range settings.Events {
+			if !contains(notifyEvents, event) {
+				return nil, fmt.Errorf("config file notify %s: unknown event %q (available: %s)", settings.Sink, event, strings.Join(notifyEvents, ", "))
+			}
+			if sink.events == nil {
+				sink.events = map[string]bool{}
+			}
+			sink.events[event] = true
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// parseNotifySink reads one KIND[:TARGET] sink. File targets of the
+// configuration file are resolved against its directory.
+func parseNotifySink(spec string, fc *FileConfig) (notifySink, error) {
+	name, target, _ := strings.Cut(spec, ":")
+	kind, ok := notifySinkKinds[name]
+	if !ok {
+		return notifySink{}, fmt.Errorf("unknown sink (available: %s)", notifySinkNames())
+	}
+	switch {
+	case kind.target == "" && target != "":
+		return notifySink{}, fmt.Errorf("%s takes nothing after it", name)
+	case kind.target != "" && target == "" && !kind.optional:
+		return notifySink{}, fmt.Errorf("give one, as %s:%s", name, kind.target)
+	case kind.target == "URL" && !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "http://"):
+		return notifySink{}, fmt.Errorf("the URL must start with https:// or http://")
+	case kind.target == "FILE" && target != "" && fc != nil:
+		target = fc.resolve(target)
+	}
+	return notifySink{kind: name, target: target}, nil
+}
+
+// contains reports whether list has s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// notifier is the event bus of a run: what it publishes is sent to every
+// sink subscribed to the event.
+type notifier struct {
+	sinks []notifySink
+	// client posts to the webhook and slack sinks. It's kept apart from the
+	// run's client, whose audit log and -record fixtures would otherwise keep
+	// the secrets in the sinks' URLs, and whose -replay fixtures have no
+	// answer for them.
+	client *http.Client
+
+	// mu guards base and stage, since a SIGTERM publishes the failure from
+	// the goroutine that catches it while the run goes on.
+	mu sync.Mutex
+	// base is copied into every notification: the job, its input and where
+	// its outputs go.
+	base notification
+	// stage is the stage the run is in, which a failure is reported in.
+	stage string
+}
+
+// setBase sets what every notification is told of the run.
+func (b *notifier) setBase(n notification) {
+	if b != nil {
+		b.mu.Lock()
+		b.base = n
+		b.mu.Unlock()
+	}
+}
+
+// setJob records the run as the job with id in notifications.
+func (b *notifier) setJob(id string) {
+	if b != nil {
+		b.mu.Lock()
+		b.base.Job = id
+		b.mu.Unlock()
+	}
+}
+
+// enterStage records the stage the run is in.
+func (b *notifier) enterStage(name string) {
+	if b != nil {
+		b.mu.Lock()
+		b.stage = name
+		b.mu.Unlock()
+	}
+}
+
+// publish prints n on the console if it's an event the console shows, then
+// sends it to the sinks subscribed to its event, one after another. A sink
+// that fails is warned about; notifications never fail the run.
+func (p *Pipeline) publish(n notification) {
+	p.printEvent(n)
+	b := p.notify
+	if b == nil {
+		return
+	}
+	n.Time = time.Now().UTC()
+	b.mu.Lock()
+	n.Job, n.Input, n.Title, n.OutputDir = b.base.Job, b.base.Input, b.base.Title, b.base.OutputDir
+	if n.Event == notifyStageFailed && n.Stage == "" {
+		n.Stage = b.stage
+	}
+	b.mu.Unlock()
+	for _, s := range b.sinks {
+		if len(s.events) > 0 && !s.events[n.Event] {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := notifySinkKinds[s.kind].send(p, ctx, s.target, n)
+		cancel()
+		if err != nil {
+			p.console.warnf("%s notification not sent to %s: %v\n", n.Event, s.kind, err)
+		}
+	}
+}
+
+// printEvent prints the console's line for the events it shows: the progress
+// of chunk-done and the error of stage-failed. The start of a job and its
+// completion have fuller lines of their own, such as the run summary.
+func (p *Pipeline) printEvent(n notification) {
+	switch n.Event {
+	case notifyChunkDone:
+		if n.Restored {
+			p.console.progressf("Diarized chunk %d/%d restored from the checkpoint\n", n.Chunk, n.Chunks)
+		} else {
+			p.console.progressf("Diarized chunk %d/%d\n", n.Chunk, n.Chunks)
+		}
+	case notifyStageFailed:
+		fmt.Fprintln(stderr, n.Error)
+	}
+}
+
+// fatalf publishes an error as the run's last words, which prints it and
+// tells the sinks the stage it was in failed, and exits.
+func (p *Pipeline) fatalf(format string, args ...any) {
+	p.exitf(1, format, args...)
+}
+
+// exitf is fatalf exiting with code. publish returns once every sink has
+// been sent the failure, so none is cut off by the exit.
+func (p *Pipeline) exitf(code int, format string, args ...any) {
+	p.publish(notification{Event: notifyStageFailed, Error: strings.TrimSpace(fmt.Sprintf(format, args...))})
+	os.Exit(code)
+}
+
+// notifyLog prints the notification to stderr, or appends it as a JSON line
+// to the file target.
+func (p *Pipeline) notifyLog(ctx context.Context, target string, n notification) error {
+	if target == "" {
+		_, err := fmt.Fprintf(stderr, "%s %s\n", n.Time.Local().Format("2006-01-02 15:04:05"), n.text())
+		return err
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// notifyWebhook posts the notification as JSON to the URL.
+func (p *Pipeline) notifyWebhook(ctx context.Context, url string, n notification) error {
+	return p.postNotification(ctx, url, n)
+}
+
+// notifySlack posts the notification's text to a Slack incoming webhook.
+func (p *Pipeline) notifySlack(ctx context.Context, url string, n notification) error {
+	return p.postNotification(ctx, url, map[string]string{"text": n.text()})
+}
+
+// postNotification posts body as JSON to url.
+func (p *Pipeline) postNotification(ctx context.Context, url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.notify.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("non-2xx response: %d, body: %s", resp.StatusCode, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// notifyDesktop shows the notification on the desktop, with notify-send on
+// Linux and the BSDs and osascript on macOS.
+func (p *Pipeline) notifyDesktop(ctx context.Context, _ string, n notification) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+		cmd = exec.CommandContext(ctx, "osascript", "-e", fmt.Sprintf(`display notification "%s" with title "podcast-transcription"`, quote(n.text())))
+	case "windows":
+		return fmt.Errorf("desktop notifications aren't supported on Windows; use a webhook or slack sink")
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=podcast-transcription", "podcast-transcription", n.text())
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// metricLine matches a sample of the metrics file.
+var metricLine = regexp.MustCompile(`^(podcast_transcription_\w+)(?:\{event="([^"]*)"\})? (\S+)$`)
+
+// notifyMetrics counts the notification in a Prometheus textfile, for the
+// node exporter's textfile collector: notifications by event and when each
+// was last sent, and the duration and cost of the last job completed. The
+// counts carry on across runs, each run adding to the file.
+func (p *Pipeline) notifyMetrics(ctx context.Context, path string, n notification) error {
+	unlock, err := lockMetrics(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	samples := map[string]float64{}
+	if data, err := os.ReadFile(path); err == nil {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			if m := metricLine.FindStringSubmatch(sc.Text()); m != nil {
+				if v, err := strconv.ParseFloat(m[3], 64); err == nil {
+					samples[m[1]+"\x00"+m[2]] = v
+				}
+			}
+		}
+	}
+	samples["podcast_transcription_notifications_total\x00"+n.Event]++
+	samples["podcast_transcription_last_notification_timestamp_seconds\x00"+n.Event] = float64(n.Time.Unix())
+	if n.Event == notifyJobComplete {
+		samples["podcast_transcription_last_job_duration_seconds\x00"] = n.Seconds
+		samples["podcast_transcription_last_job_cost_usd\x00"] = n.Cost
+	}
+
+	metrics := []struct{ name, kind, help string }{
+		{"podcast_transcription_notifications_total", "counter", "Notifications sent, by event."},
+		{"podcast_transcription_last_notification_timestamp_seconds", "gauge", "When a notification of the event was last sent."},
+		{"podcast_transcription_last_job_duration_seconds", "gauge", "How long the last job completed took."},
+		{"podcast_transcription_last_job_cost_usd", "gauge", "Estimated cost of the last job completed."},
+	}
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, key := range keys {
+			name, event, _ := strings.Cut(key, "\x00")
+			if name != m.name {
+				continue
+			}
+			value := strconv.FormatFloat(samples[key], 'g', -1, 64)
+			if event == "" {
+				fmt.Fprintf(&b, "%s %s\n", name, value)
+			} else {
+				fmt.Fprintf(&b, "%s{event=%q} %s\n", name, event, value)
+			}
+		}
+	}
+	return writeFileAtomic(path, []byte(b.String()), 0644)
+}
+
+// lockMetrics takes the lock of the metrics file at path, in a file beside
+// it, so that runs sharing the file add to each other's counts instead of
+// overwriting them. It waits for another run's lock until ctx is done.
+func lockMetrics(ctx context.Context, path string) (func(), error) {
+	lockPath := path + ".lock"
+	for {
+		f, ok, err := tryLockFile(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if ok {
+			f.Truncate(0)
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return func() { unlockFile(f, lockPath) }, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s is still locked by another run (see %s)", path, lockPath)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// runCost is the estimated cost of the stages of a run, as its summary
+// shows it.
+func runCost(m *Manifest, audioSeconds float64) float64 {
+	var total float64
+	for _, s := range m.Stages {
+		total += stageCost(s, audioSeconds, m.Parameters["batch"] == true)
+	}
+	return total
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseNotifySink(t *testing.T) {
+	tests := []struct {
+		spec, kind, target string
+		err                bool
+	}{
+		{"log", "log", "", false},
+		{"log:events.jsonl", "log", "events.jsonl", false},
+		{"webhook:https://example.com/hook", "webhook", "https://example.com/hook", false},
+		{"webhook:http://localhost:8080/hook", "webhook", "http://localhost:8080/hook", false},
+		{"slack:https://hooks.slack.com/services/T/B/X", "slack", "https://hooks.slack.com/services/T/B/X", false},
+		{"desktop", "desktop", "", false},
+		{"metrics:/tmp/run.prom", "metrics", "/tmp/run.prom", false},
+		{"webhook:ftp://example.com", "", "", true},
+		{"webhook", "", "", true},
+		{"desktop:now", "", "", true},
+		{"metrics", "", "", true},
+		{"pager", "", "", true},
+	}
+	for _, tt := range tests {
+		sink, err := parseNotifySink(tt.spec, nil)
+		if (err != nil) != tt.err {
+			t.Errorf("parseNotifySink(%q) error = %v, want error %v", tt.spec, err, tt.err)
+			continue
+		}
+		if err == nil && (sink.kind != tt.kind || sink.target != tt.target) {
+			t.Errorf("parseNotifySink(%q) = %s %q, want %s %q", tt.spec, sink.kind, sink.target, tt.kind, tt.target)
+		}
+	}
+}
+
+func TestNotificationText(t *testing.T) {
+	tests := []struct {
+		n    notification
+		want string
+	}{
+		{notification{Event: notifyJobStarted, Title: "Episode 42"}, "Started Episode 42"},
+		{notification{Event: notifyChunkDone, Input: "/shows/ep42.mp3", Chunk: 3, Chunks: 10}, "Chunk 3/10 of ep42.mp3 done"},
+		{notification{Event: notifyStageFailed, Stage: "diarization", Error: "timeout"}, "the run failed in diarization: timeout"},
+		{notification{Event: notifyStageFailed, Error: "bad flag"}, "the run failed in setup: bad flag"},
+		{notification{Event: notifyJobComplete, Title: "Ep", Seconds: 90, Cost: 0.5, Outputs: []string{"a", "b"}, Warnings: 1}, "Finished Ep in "},
+	}
+	for _, tt := range tests {
+		if got := tt.n.text(); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s text = %q, want %q", tt.n.Event, got, tt.want)
+		}
+	}
+}
+
+func TestNotifyMetricsConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.prom")
+	p := &Pipeline{}
+	const runs = 20
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := p.notifyMetrics(ctx, path, notification{Event: notifyJobComplete, Time: time.Now()}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `podcast_transcription_notifications_total{event="job-complete"} 20`; !strings.Contains(string(data), want) {
+		t.Errorf("metrics file lost counts; want %s in:\n%s", want, data)
+	}
+}
+
+func TestNotifierStage(t *testing.T) {
+	b := &notifier{}
+	var sent []notification
+	notifySinkKinds["test"] = notifySinkKind{send: func(_ *Pipeline, _ context.Context, _ string, n notification) error {
+		sent = append(sent, n)
+		return nil
+	}}
+	defer delete(notifySinkKinds, "test")
+	b.sinks = []notifySink{{kind: "test"}}
+	p := &Pipeline{notify: b, console: &console{quiet: true}}
+	defer func(w io.Writer) { stderr = w }(stderr)
+	stderr = io.Discard
+
+	b.setBase(notification{Input: "ep.mp3"})
+	b.setJob("job1")
+	done := make(chan struct{})
+	go func() {
+		// As a SIGTERM does while the run moves through its stages
+		p.publish(notification{Event: notifyStageFailed, Error: "terminated"})
+		close(done)
+	}()
+	for _, stage := range []string{"transcription", "diarization", "export"} {
+		b.enterStage(stage)
+	}
+	<-done
+	if len(sent) != 1 || sent[0].Job != "job1" || sent[0].Input != "ep.mp3" {
+		t.Errorf("sent %+v, want the job and input", sent)
+	}
+}
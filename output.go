@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a half-written file and a failed write leaves
+// the previous version intact.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// writeOutput replaces an output file atomically, first keeping the current
+// version as a numbered backup when -backups is set. The file is encrypted
+// when -encryption-key is set.
+func (p *Pipeline) writeOutput(path string, data []byte) error {
+	if err := rotateBackups(p.config.EncryptionKey, path, p.config.Backups, data); err != nil {
+		return fmt.Errorf("failed to back up %s: %v", path, err)
+	}
+	sealed, err := sealStored(p.config.EncryptionKey, data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, sealed, 0644)
+}
+
+// rotateBackups shifts path.1 … path.(keep-1) up by one and copies the current
+// contents of path to path.1, dropping the oldest. Encrypted contents are
+// compared decrypted with key. Nothing happens if path
+// doesn't exist yet or already holds data, so identical re-runs don't push out
+// older versions.
+func rotateBackups(key []byte, path string, keep int, data []byte) error {
+	if keep <= 0 {
+		return nil
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	// Encrypted copies of the same contents differ, so compare them decrypted
+	if plain, err := openStored(key, current); err == nil && bytes.Equal(plain, data) {
+		return nil
+	}
+	for i := keep - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFileAtomic(path+".1", current, 0644)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// chunkResult is the transcription of one chunk of audio, with times already
+// shifted to the whole file.
+type chunkResult struct {
+	transcript *Transcript
+	err        error
+}
+
+// chunkCount is how many chunks of chunkSeconds the audio is cut into.
+func chunkCount(duration, chunkSeconds float64) int {
+	return int(math.Ceil(duration / chunkSeconds))
+}
+
+// transcribeChunks cuts the audio into its n chunks of chunkSeconds and transcribes
+// them one after another in the background, sending each result as soon as it is
+// ready. The channel is closed after the last chunk or the first error.
+func (p *Pipeline) transcribeChunks(ctx context.Context, be backend, apiKey, audioPath string, duration, chunkSeconds float64, n int) <-chan chunkResult {
+	results := make(chan chunkResult, n)
+	go func() {
+		defer close(results)
+		for i := 0; i < n; i++ {
+			start := float64(i) * chunkSeconds
+			if start >= duration {
+				break
+			}
+			end := min(start+chunkSeconds, duration)
+			if ch := p.checkpoint.chunk(i); ch != nil {
+				p.console.progressf("Chunk %d/%d restored from the checkpoint\n", i+1, n)
+				results <- chunkResult{transcript: ch.Transcript}
+				continue
+			}
+			t, err := p.transcribeChunk(ctx, be, apiKey, audioPath, start, end)
+			p.stats.chunks.Add(1)
+			if err != nil {
+				results <- chunkResult{err: fmt.Errorf("chunk %d/%d: %v", i+1, n, err)}
+				return
+			}
+			if strings.TrimSpace(t.Text) == "" {
+				// An empty chunk is either silence or a failed transcription
+				if retry, err := p.retryRegion(ctx, be, apiKey, audioPath, start, end); err == nil && strings.TrimSpace(retry.Text) != "" {
+					p.console.progressf("Chunk %d/%d came back empty; transcribed again with different parameters\n", i+1, n)
+					t = retry
+				}
+			} else if p.config.RetrySuspect && !be.diarizes {
+				// Retry hallucinations before the chunk is diarized
+				if issues := assessTranscript(t); len(issues) > 0 {
+					p.retrySuspectRegions(ctx, be, apiKey, audioPath, t, issues)
+				}
+			}
+			if err := p.checkpoint.transcribed(i, t); err != nil {
+				p.console.warnf("%v\n", err)
+			}
+			results <- chunkResult{transcript: t}
+		}
+	}()
+	return results
+}
+
+// transcribeChunk transcribes the audio between start and end seconds.
+func (p *Pipeline) transcribeChunk(ctx context.Context, be backend, apiKey, audioPath string, start, end float64) (*Transcript, error) {
+	path, cleanup, err := p.cutAudio(ctx, audioPath, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(ctx, p.transcriptionTimeout(end-start))
+	defer cancel()
+	t, err := be.transcribe(p, ctx, apiKey, path)
+	if err != nil {
+		return nil, err
+	}
+	t.shift(start)
+	return t, nil
+}
+
+// transcribeAndDiarize transcribes chunked audio and diarizes it with the chat
+// model at the same time: while chunk N is being transcribed, chunk N-1 is
+// cleaned up and diarized. Speaker labels are kept consistent by giving each
+// diarization request the end of the previous one. It returns the raw merged
+// transcription, the aligned turns, when diarization of the first chunk started,
+// and the diarization token usage.
+func (p *Pipeline) transcribeAndDiarize(ctx context.Context, be backend, backendKey, apiKey, audioPath string, chunkSeconds float64, numSpeakers int, cleanupMode string) (*Transcript, []Segment, time.Time, TokenUsage, error) {
+	var (
+		diarizeStarted time.Time
+		usage          TokenUsage
+	)
+	duration, err := probeDuration(audioPath)
+	if err != nil {
+		return nil, nil, diarizeStarted, usage, err
+	}
+	total := chunkCount(duration, chunkSeconds)
+	results := p.transcribeChunks(ctx, be, backendKey, audioPath, duration, chunkSeconds, total)
+
+	merged := &Transcript{Audio: filepath.Base(audioPath), Duration: duration}
+	var (
+		turns    []Segment
+		previous string
+		chunks   int
+	)
+	budget := p.diarizationBudget(p.config.DiarizationModel)
+	for r := range results {
+		if r.err != nil {
+			return nil, nil, diarizeStarted, usage, fmt.Errorf("failed to transcribe %v", r.err)
+		}
+		chunks++
+		p.console.progressf("Transcribed chunk %d\n", chunks)
+		if diarizeStarted.IsZero() {
+			diarizeStarted = time.Now()
+		}
+
+		t := r.transcript
+		offset := len(merged.Segments)
+		for _, s := range t.Segments {
+			s.ID += offset
+			merged.Segments = append(merged.Segments, s)
+		}
+		merged.Chapters = append(merged.Chapters, t.Chapters...)
+		merged.Entities = append(merged.Entities, t.Entities...)
+		if merged.Language == "" {
+			merged.Language = t.Language
+		}
+
+		if ch := p.checkpoint.chunk(chunks - 1); ch != nil && ch.Turns != nil {
+			turns = append(turns, ch.Turns...)
+			previous = ch.Context
+			if err := p.stream.write(ch.Turns); err != nil {
+				p.console.warnf("%v\n", err)
+			}
+			p.publish(notification{Event: notifyChunkDone, Chunk: chunks, Chunks: total, Restored: true})
+			continue
+		}
+
+		// Diarize a cleaned-up copy so the cached transcription stays raw
+		source := append([]Segment(nil), t.Segments...)
+		cleaned := &Transcript{Segments: source}
+		if cleanupMode != "" && cleanupMode != "none" {
+			u, err := p.cleanupTranscript(ctx, apiKey, cleaned, cleanupMode)
+			usage.Add(u)
+			if err != nil {
+				return nil, nil, diarizeStarted, usage, err
+			}
+		}
+		chunkStart := len(turns)
+		for _, part := range splitSegments(cleaned.Segments, budget) {
+			partTurns, u, err := p.diarizeVerified(ctx, apiKey, paragraphText(part), previous, pauseHints(part, p.config.SpeakerGap), numSpeakers)
+			usage.Add(u)
+			if err != nil {
+				return nil, nil, diarizeStarted, usage, fmt.Errorf("failed to diarize chunk %d: %v", chunks, err)
+			}
+			turns = append(turns, alignTurns(part, partTurns)...)
+			previous = formatTurns(partTurns[max(0, len(partTurns)-contextLines):])
+		}
+		if err := p.checkpoint.diarized(chunks-1, turns[chunkStart:], previous); err != nil {
+			p.console.warnf("%v\n", err)
+		}
+		if err := p.stream.write(turns[chunkStart:]); err != nil {
+			p.console.warnf("%v\n", err)
+		}
+		p.publish(notification{Event: notifyChunkDone, Chunk: chunks, Chunks: total})
+	}
+	if chunks == 0 {
+		return nil, nil, diarizeStarted, usage, fmt.Errorf("no audio to transcribe")
+	}
+
+	for i := range turns {
+		turns[i].ID = i
+	}
+	merged.Text = joinSegmentText(merged.Segments)
+	return merged, turns, diarizeStarted, usage, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestChunkCount(t *testing.T) {
+	tests := []struct {
+		duration, chunkSeconds float64
+		want                   int
+	}{
+		{1200, 600, 2},
+		{1200.5, 600, 3},
+		{599, 600, 1},
+		{600, 600, 1},
+		{0, 600, 0},
+		{0.3, 0.1, 3},
+	}
+	for _, tt := range tests {
+		if got := chunkCount(tt.duration, tt.chunkSeconds); got != tt.want {
+			t.Errorf("chunkCount(%v, %v) = %d, want %d", tt.duration, tt.chunkSeconds, got, tt.want)
+		}
+		if r := buildChunkReport(nil, nil, tt.duration, tt.chunkSeconds); len(r.Chunks) != tt.want {
+			t.Errorf("chunk report of %v in chunks of %v has %d chunks, want %d", tt.duration, tt.chunkSeconds, len(r.Chunks), tt.want)
+		}
+	}
+}
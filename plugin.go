@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pluginPrefix is the executable name prefix of provider plugins found on PATH;
+// "transcriber-provider-foo" is selected with -backend foo or -diarizer foo.
+const pluginPrefix = "transcriber-provider-"
+
+// pluginProtocolVersion is the version of the stdin/stdout contract sent to plugins.
+const pluginProtocolVersion = 1
+
+// pluginInfo is what a plugin prints when run as "<plugin> describe".
+type pluginInfo struct {
+	DefaultModel string `json:"default_model"`
+	// Diarizes reports that "transcribe" already returns speaker turns.
+	Diarizes bool `json:"diarizes"`
+	// Actions lists the supported actions: "transcribe" and/or "diarize".
+	Actions []string `json:"actions"`
+}
+
+// pluginRequest is written to the plugin's stdin. Transcript is set for "diarize".
+type pluginRequest struct {
+	Version    int         `json:"version"`
+	Action     string      `json:"action"`
+	Audio      string      `json:"audio,omitempty"`
+	Model      string      `json:"model,omitempty"`
+	Speakers   int         `json:"speakers,omitempty"`
+	Language   string      `json:"language,omitempty"`
+	Vocabulary []string    `json:"vocabulary,omitempty"`
+	Transcript *Transcript `json:"transcript,omitempty"`
+}
+
+// pluginResponse is read from the plugin's stdout: a canonical transcript, or an
+// error message.
+type pluginResponse struct {
+	Transcript
+	Error string `json:"error,omitempty"`
+}
+
+// findPlugins returns the provider plugins on PATH by name. Earlier PATH entries
+// win, as with command lookup.
+func findPlugins() map[string]string {
+	plugins := map[string]string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(dir, pluginPrefix+"*"))
+		for _, path := range matches {
+			name := strings.TrimPrefix(filepath.Base(path), pluginPrefix)
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			if _, ok := plugins[name]; ok {
+				continue
+			}
+			if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				plugins[name] = path
+			}
+		}
+	}
+	return plugins
+}
+
+// pluginNames returns the names of the plugins on PATH in sorted order.
+func pluginNames() []string {
+	var names []string
+	for name := range findPlugins() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describePlugin asks the plugin at path for its capabilities.
+func describePlugin(path string) (pluginInfo, error) {
+	out, err := exec.Command(path, "describe").Output()
+	if err != nil {
+		return pluginInfo{}, fmt.Errorf("failed to describe plugin %s: %v", path, err)
+	}
+	var info pluginInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return pluginInfo{}, fmt.Errorf("failed to parse description of plugin %s: %v", path, err)
+	}
+	return info, nil
+}
+
+// supports reports whether the plugin implements action.
+func (info pluginInfo) supports(action string) bool {
+	for _, a := range info.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupPlugin finds the named plugin on PATH and checks that it supports action.
+func lookupPlugin(name, action string) (string, pluginInfo, error) {
+	path, ok := findPlugins()[name]
+	if !ok {
+		return "", pluginInfo{}, fmt.Errorf("no %s%s executable on PATH", pluginPrefix, name)
+	}
+	info, err := describePlugin(path)
+	if err != nil {
+		return "", pluginInfo{}, err
+	}
+	if !info.supports(action) {
+		return "", pluginInfo{}, fmt.Errorf("plugin %s does not support %q", name, action)
+	}
+	return path, info, nil
+}
+
+// pluginBackend adapts a transcription plugin to the backend registry.
+func pluginBackend(name string) (backend, error) {
+	path, info, err := lookupPlugin(name, "transcribe")
+	if err != nil {
+		return backend{}, err
+	}
+	return backend{
+		defaultModel: info.DefaultModel,
+		endpoint:     path,
+		diarizes:     info.Diarizes,
+		credentials:  func() (string, error) { return "", nil },
+		transcribe: func(p *Pipeline, ctx context.Context, _, audioPath string) (*Transcript, error) {
+			abs, err := filepath.Abs(audioPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve audio path: %v", err)
+			}
+			t, err := p.callPlugin(ctx, path, pluginRequest{
+				Action:     "transcribe",
+				Audio:      abs,
+				Model:      p.config.TranscriptionModel,
+				Speakers:   p.config.Speakers,
+				Language:   p.config.Language,
+				Vocabulary: p.config.Vocabulary,
+			})
+			if err != nil {
+				return nil, err
+			}
+			t.Audio = filepath.Base(audioPath)
+			return t, nil
+		},
+	}, nil
+}
+
+// callPlugin runs the plugin with req on stdin and decodes the transcript it
+// prints. The plugin's stderr is passed through for progress and diagnostics.
+func (p *Pipeline) callPlugin(ctx context.Context, path string, req pluginRequest) (*Transcript, error) {
+	req.Version = pluginProtocolVersion
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plugin request: %v", err)
+	}
+	stdout := p.newCappedBuffer()
+	cmd := exec.CommandContext(ctx, path, req.Action)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	runErr := cmd.Run()
+
+	var res pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("plugin %s failed: %v", filepath.Base(path), runErr)
+		}
+		return nil, fmt.Errorf("failed to parse output of plugin %s: %v", filepath.Base(path), err)
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("plugin %s failed: %s", filepath.Base(path), res.Error)
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("plugin %s failed: %v", filepath.Base(path), runErr)
+	}
+	return &res.Transcript, nil
+}
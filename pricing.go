@@ -0,0 +1,67 @@
+package main
+
+import "strings"
+
+// chatPrice is the list price of a chat model in USD per million tokens.
+type chatPrice struct {
+	Input  float64
+	Output float64
+}
+
+// chatPrices are published list prices used for cost estimates. They are
+// estimates only; the provider's invoice is authoritative.
+var chatPrices = map[string]chatPrice{
+	"gpt-4o":       {Input: 2.50, Output: 10.00},
+	"gpt-4o-mini":  {Input: 0.15, Output: 0.60},
+	"gpt-4.1":      {Input: 2.00, Output: 8.00},
+	"gpt-4.1-mini": {Input: 0.40, Output: 1.60},
+	"gpt-4-turbo":  {Input: 10.00, Output: 30.00},
+	"o1":           {Input: 15.00, Output: 60.00},
+	"o3-mini":      {Input: 1.10, Output: 4.40},
+}
+
+// audioPricesPerMinute are transcription list prices in USD per audio minute.
+var audioPricesPerMinute = map[string]float64{
+	"whisper-1":                 0.006,
+	"gpt-4o-transcribe":         0.006,
+	"gpt-4o-mini-transcribe":    0.003,
+	"gpt-4o-transcribe-diarize": 0.006,
+	"nova-3":                    0.0043,
+	"nova-2":                    0.0043,
+	"best":                      0.0062,
+	"nano":                      0.002,
+	"long":                      0.016,
+	"chirp_2":                   0.016,
+	"transcribe":                0.024,
+}
+
+// lookupModel finds the entry for model in table, matching dated snapshots such as
+// "gpt-4o-2024-08-06" by their longest base name.
+func lookupModel[T any](table map[string]T, model string) (T, bool) {
+	var best T
+	bestLen := 0
+	for name, p := range table {
+		if (model == name || strings.HasPrefix(model, name+"-")) && len(name) > bestLen {
+			best, bestLen = p, len(name)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// chatCost estimates the cost in USD of a chat completion.
+func chatCost(model string, usage TokenUsage) float64 {
+	p, ok := lookupModel(chatPrices, model)
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*p.Input + float64(usage.CompletionTokens)*p.Output) / 1e6
+}
+
+// audioCost estimates the cost in USD of transcribing seconds of audio with model.
+func audioCost(model string, seconds float64) float64 {
+	p, ok := lookupModel(audioPricesPerMinute, model)
+	if !ok {
+		return 0
+	}
+	return p * seconds / 60
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// processingProfile is a set of flag values -profile applies together, so
+// that a run trades cost against latency with one flag.
+type processingProfile struct {
+	summary string
+	// flags are set to these values unless given on the command line.
+	flags map[string]string
+}
+
+// processingProfiles are the profiles -profile accepts.
+var processingProfiles = map[string]processingProfile{
+	"fast": {
+		summary: "results soonest: chunks diarized while later ones are transcribed, with the most capable chat models",
+		flags: map[string]string{
+			"chunk":             "10m",
+			"diarization-model": "gpt-4.1",
+			"summary-model":     "gpt-4.1",
+		},
+	},
+	"economy": {
+		summary: "lowest cost: chat requests batched at half price, smaller chat models, and the audio sped up half as much again",
+		flags: map[string]string{
+			"batch":             "true",
+			"speedup":           "1.5",
+			"diarization-model": "gpt-4o-mini",
+			"summary-model":     "gpt-4o-mini",
+		},
+	},
+}
+
+// profileNames lists the processing profiles for messages.
+func profileNames() string {
+	names := make([]string, 0, len(processingProfiles))
+	for name := range processingProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyProcessingProfile sets the flags of the named profile that weren't
+// given on the command line, and returns the flags it set.
+func applyProcessingProfile(name string) ([]string, error) {
+	profile, ok := processingProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown -profile %q (available: %s)", name, profileNames())
+	}
+	set := setFlags()
+	var applied []string
+	for _, f := range sortedKeys(profile.flags) {
+		// -ensemble transcribes the whole file twice, which chunks can't
+		if set[f] || (f == "chunk" && set["ensemble"]) {
+			continue
+		}
+		if err := flag.Set(f, profile.flags[f]); err != nil {
+			return nil, fmt.Errorf("-profile %s: -%s: %v", name, f, err)
+		}
+		applied = append(applied, "-"+f+" "+profile.flags[f])
+	}
+	return applied, nil
+}
+
+// profileFlag returns the value of the named flag among an import's run
+// flags: the one given, or else the one their -profile sets.
+func profileFlag(runArgs []string, name string) string {
+	if value := runFlag(runArgs, name); value != "" {
+		return value
+	}
+	return processingProfiles[runFlag(runArgs, "profile")].flags[name]
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileConfig is the optional JSON configuration file.
+type FileConfig struct {
+	Shows map[string]ShowProfile `json:"shows"`
+
+	// MonthlyBudget is the estimated USD spend per calendar month after which new
+	// runs are refused. Zero disables the limit.
+	MonthlyBudget float64 `json:"monthly_budget,omitempty"`
+
+	// OpenAIOrganization and OpenAIProject select where OpenAI usage is billed.
+	OpenAIOrganization string `json:"openai_organization,omitempty"`
+	OpenAIProject      string `json:"openai_project,omitempty"`
+
+	// SummaryPresets adds -summary-preset styles, or replaces built-in ones,
+	// by name: the instructions given to the summary model.
+	SummaryPresets map[string]string `json:"summary_presets,omitempty"`
+
+	// Notify are sinks told of every run, in addition to those of -notify.
+	Notify []notifySettings `json:"notify,omitempty"`
+
+	// dir is the directory the file was loaded from; relative paths inside the
+	// file are resolved against it.
+	dir string
+}
+
+// ShowProfile collects the per-show settings selected with -show.
+type ShowProfile struct {
+	Speakers       []string `json:"speakers,omitempty"`
+	Vocabulary     []string `json:"vocabulary,omitempty"`
+	PromptTemplate string   `json:"prompt_template,omitempty"`
+	// Glossary is the path of a glossary file of correct spellings for -glossary.
+	Glossary string `json:"glossary,omitempty"`
+	// TranslationGlossary is the path of a bilingual glossary for
+	// -translation-glossary.
+	TranslationGlossary string   `json:"translation_glossary,omitempty"`
+	Examples            []string `json:"examples,omitempty"`
+	OutputDir           string   `json:"output_dir,omitempty"`
+	FeedURL             string   `json:"feed_url,omitempty"`
+	// Guests is the path of a calendar or guest list for -guests.
+	Guests string `json:"guests,omitempty"`
+	// Boilerplate is the path of the show's intro and outro, as learned by
+	// the boilerplate command, for -boilerplate.
+	Boilerplate string `json:"boilerplate,omitempty"`
+	// OpenAIProject bills this show's OpenAI usage to its own project.
+	OpenAIProject string `json:"openai_project,omitempty"`
+	// CMS is the site -post-draft posts the show's transcripts to.
+	CMS *cmsSettings `json:"cms,omitempty"`
+	// Hosting is the podcast host -upload-transcript attaches transcripts on.
+	Hosting *hostingSettings `json:"hosting,omitempty"`
+}
+
+// defaultConfigPath returns the config file location used when -config isn't given.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "podcast-transcription", "config.json")
+}
+
+// loadFileConfig reads the configuration file at path. A missing file is only an
+// error when required is set, i.e. when the user named it explicitly.
+func loadFileConfig(path string, required bool) (*FileConfig, error) {
+	fc := &FileConfig{dir: filepath.Dir(path)}
+	if path == "" {
+		return fc, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return fc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := json.Unmarshal(data, fc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return fc, nil
+}
+
+// show looks up a profile by name.
+func (fc *FileConfig) show(name string) (ShowProfile, error) {
+	p, ok := fc.Shows[name]
+	if !ok {
+		names := make([]string, 0, len(fc.Shows))
+		for n := range fc.Shows {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return ShowProfile{}, fmt.Errorf("unknown show %q: no shows are configured", name)
+		}
+		return ShowProfile{}, fmt.Errorf("unknown show %q (configured: %s)", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// resolve makes a path from the config file absolute relative to the file's
+// directory and expands a leading "~/".
+func (fc *FileConfig) resolve(path string) string {
+	if path == "" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(fc.dir, path)
+}
+
+// setFlags returns the names of the flags given explicitly on the command line,
+// which take precedence over profile values.
+func setFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// applyOutputDir places every cached and generated file under dir.
+func (p *Pipeline) applyOutputDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	for _, path := range []*string{
+		&p.config.TranscriptionFile,
+		&p.config.TranscriptionJSONFile,
+		&p.config.DiarizedFile,
+		&p.config.DiarizedJSONFile,
+		&p.config.ManifestFile,
+		&p.config.CorrectionsFile,
+		&p.config.TranslationMissesFile,
+		&p.config.ChaptersFile,
+		&p.config.BlogFile,
+		&p.config.MinutesFile,
+		&p.config.MinutesJSONFile,
+		&p.config.StudyNotesFile,
+		&p.config.StudyNotesJSONFile,
+		&p.config.NewsletterHTMLFile,
+		&p.config.NewsletterTextFile,
+		&p.config.XThreadFile,
+		&p.config.LinkedInFile,
+		&p.config.YouTubeFile,
+		&p.config.ClipsFile,
+		&p.config.MetadataFile,
+		&p.config.ChunkReportFile,
+	} {
+		*path = filepath.Join(dir, filepath.Base(*path))
+	}
+	return nil
+}
+
+// whisperPromptTokens is the part of Whisper's 224-token prompt window used for
+// vocabulary hints.
+const whisperPromptTokens = 200
+
+// whisperPrompt renders vocabulary as a Whisper prompt, dropping terms that would
+// overflow the prompt window.
+func whisperPrompt(vocabulary []string) string {
+	var kept []string
+	tokens := 0
+	for _, v := range vocabulary {
+		n := estimateTokens(v) + 1
+		if tokens+n > whisperPromptTokens {
+			break
+		}
+		kept = append(kept, v)
+		tokens += n
+	}
+	return strings.Join(kept, ", ") + "."
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxPauseHints caps the pauses listed in one diarization prompt; the longest
+// are kept, since they are the likeliest changes of speaker.
+const maxPauseHints = 150
+
+// pauseHints describes the pauses of at least minGap seconds between segments,
+// which often mark a change of speaker, for the diarization prompt. Each is
+// given by the words on either side, as the prompt has no timestamps. minGap 0
+// disables the hints.
+func pauseHints(segments []Segment, minGap float64) []string {
+	if minGap <= 0 {
+		return nil
+	}
+	type pause struct {
+		pos    int
+		gap    float64
+		before string
+		after  string
+	}
+	var pauses []pause
+	var prev *Segment
+	for i := range segments {
+		s := &segments[i]
+		if s.Kind == eventKind || strings.TrimSpace(s.Text) == "" {
+			continue
+		}
+		if prev != nil {
+			if gap := s.Start - prev.End; gap >= minGap {
+				pauses = append(pauses, pause{len(pauses), gap, lastWords(prev.Text, 4), truncateWords(s.Text, 6)})
+			}
+		}
+		prev = s
+	}
+	if len(pauses) > maxPauseHints {
+		sort.SliceStable(pauses, func(i, j int) bool { return pauses[i].gap > pauses[j].gap })
+		pauses = pauses[:maxPauseHints]
+		sort.Slice(pauses, func(i, j int) bool { return pauses[i].pos < pauses[j].pos })
+	}
+	hints := make([]string, len(pauses))
+	for i, p := range pauses {
+		hints[i] = fmt.Sprintf("%.1fs pause between %q and %q", p.gap, p.before, p.after)
+	}
+	return hints
+}
+
+// lastWords returns the last n words of text, marking what was cut.
+func lastWords(text string, n int) string {
+	words := strings.Fields(text)
+	if len(words) <= n {
+		return strings.Join(words, " ")
+	}
+	return "… " + strings.Join(words[len(words)-n:], " ")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPauseHints(t *testing.T) {
+	segments := []Segment{
+		{Start: 0, End: 4, Text: "So that's the first half of the show."},
+		{Start: 4.2, End: 6, Text: "Right."},
+		{Start: 6, End: 9, Text: "[music]", Kind: eventKind},
+		{Start: 9.5, End: 12, Text: "Welcome back to the second half of the show, everyone."},
+		{Start: 13, End: 14, Text: "  "},
+		{Start: 15, End: 16, Text: "Thanks."},
+	}
+	tests := []struct {
+		name   string
+		minGap float64
+		want   []string
+	}{
+		{"off", 0, nil},
+		{"long pauses", 2, []string{
+			`3.5s pause between "Right." and "Welcome back to the second half …"`,
+			`3.0s pause between "… of the show, everyone." and "Thanks."`,
+		}},
+		{"none long enough", 5, []string{}},
+	}
+	for _, tt := range tests {
+		if got := pauseHints(segments, tt.minGap); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: pauseHints(%g) = %q, want %q", tt.name, tt.minGap, got, tt.want)
+		}
+	}
+}
+
+func TestPauseHintsKeepsLongest(t *testing.T) {
+	var segments []Segment
+	for i := 0; i <= maxPauseHints+10; i++ {
+		gap := 1.0
+		if i%10 == 0 {
+			gap = 3
+		}
+		start := float64(i) * 10
+		segments = append(segments, Segment{Start: start, End: start + 10 - gap, Text: "words"})
+	}
+	hints := pauseHints(segments, 1)
+	if len(hints) != maxPauseHints {
+		t.Fatalf("got %d hints, want %d", len(hints), maxPauseHints)
+	}
+	long := 0
+	for _, h := range hints {
+		if h[:4] == "3.0s" {
+			long++
+		}
+	}
+	if long != 16 {
+		t.Errorf("kept %d of the 16 longest pauses", long)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed templates/site
+var siteFiles embed.FS
+
+// siteEpisode is the view model of one episode page.
+type siteEpisode struct {
+	Slug     string
+	Title    string
+	Date     string
+	Duration string
+	Summary  string
+	Speakers []string
+	Turns    []siteTurn
+}
+
+// siteTurn is one rendered speaker turn.
+type siteTurn struct {
+	Seconds      int
+	Time         string
+	Speaker      string
+	SpeakerIndex int
+	Text         string
+}
+
+// searchEntry is an episode in search.json, read by the site's search script.
+type searchEntry struct {
+	Slug  string       `json:"slug"`
+	Title string       `json:"title"`
+	Turns []searchTurn `json:"turns"`
+}
+
+type searchTurn struct {
+	Seconds int    `json:"t"`
+	Time    string `json:"ts"`
+	Speaker string `json:"s,omitempty"`
+	Text    string `json:"x"`
+}
+
+// runPublish implements the publish command.
+func runPublish(args []string) error {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	in := flags.String("in", ".", "Directory searched recursively for diarized transcripts")
+	out := flags.String("out", "site", "Directory the static site is written to")
+	title := flags.String("title", "Transcripts", "Site title")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription publish [-in dir] [-out dir] [-title title]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	episodes, err := collectEpisodes(key, *in)
+	if err != nil {
+		return err
+	}
+	if len(episodes) == 0 {
+		return fmt.Errorf("no %s files found under %s", filepath.Base(defaultConfig().DiarizedJSONFile), *in)
+	}
+	if err := renderSite(*out, *title, episodes); err != nil {
+		return err
+	}
+	fmt.Printf("Published %d episodes to %s\n", len(episodes), *out)
+	return nil
+}
+
+// collectEpisodes loads every diarized transcript under dir, newest first.
+func collectEpisodes(key []byte, dir string) ([]siteEpisode, error) {
+	name := filepath.Base(defaultConfig().DiarizedJSONFile)
+	var episodes []siteEpisode
+	slugs := map[string]int{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != name {
+			return nil
+		}
+		t, err := loadTranscript(key, path)
+		if err != nil {
+			return err
+		}
+		ep := newSiteEpisode(t, filepath.Base(filepath.Dir(path)))
+		base := ep.Slug
+		slugs[base]++
+		if n := slugs[base]; n > 1 {
+			ep.Slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		episodes = append(episodes, ep)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect transcripts: %v", err)
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		if episodes[i].Date != episodes[j].Date {
+			return episodes[i].Date > episodes[j].Date
+		}
+		return episodes[i].Title < episodes[j].Title
+	})
+	return episodes, nil
+}
+
+// newSiteEpisode builds the page model for t. dirName names the episode when the
+// transcript has no title.
+func newSiteEpisode(t *Transcript, dirName string) siteEpisode {
+	title := episodeTitle(t, dirName)
+	ep := siteEpisode{
+		Slug:     slugify(title),
+		Title:    title,
+		Date:     t.Date,
+		Summary:  t.Summary,
+		Speakers: t.speakers(),
+	}
+	if t.Duration > 0 {
+		ep.Duration = formatTimestamp(t.Duration, ".")[:8]
+	}
+	index := map[string]int{}
+	for i, s := range ep.Speakers {
+		index[s] = i
+	}
+	for _, s := range t.Segments {
+		ep.Turns = append(ep.Turns, siteTurn{
+			Seconds:      int(s.Start),
+			Time:         formatTimestamp(s.Start, ".")[:8],
+			Speaker:      s.Speaker,
+			SpeakerIndex: index[s.Speaker] % 5,
+			Text:         s.displayText(),
+		})
+	}
+	return ep
+}
+
+// episodeTitle returns the title of t, falling back to its audio file name and
+// then to dirName.
+func episodeTitle(t *Transcript, dirName string) string {
+	title := t.Title
+	if title == "" {
+		title = strings.TrimSuffix(t.Audio, filepath.Ext(t.Audio))
+	}
+	if title == "" || title == "." {
+		title = dirName
+	}
+	return title
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a title into a file-name-safe slug.
+func slugify(s string) string {
+	slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if slug == "" {
+		return "episode"
+	}
+	return slug
+}
+
+// renderSite writes the index, one page per episode, the search index and the
+// static assets into out.
+func renderSite(out, title string, episodes []siteEpisode) error {
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return fmt.Errorf("failed to create site directory: %v", err)
+	}
+	tmpl, err := template.New("site").Funcs(template.FuncMap{"join": strings.Join}).
+		ParseFS(siteFiles, "templates/site/*.html")
+	if err != nil {
+		return fmt.Errorf("failed to parse site templates: %v", err)
+	}
+
+	write := func(name, tmplName string, data any) error {
+		f, err := os.Create(filepath.Join(out, name))
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %v", name, err)
+		}
+		defer f.Close()
+		if err := tmpl.ExecuteTemplate(f, tmplName, data); err != nil {
+			return fmt.Errorf("failed to render %s: %v", name, err)
+		}
+		return nil
+	}
+
+	index := struct {
+		Title     string
+		Generated string
+		Episodes  []siteEpisode
+	}{title, time.Now().Format("2006-01-02"), episodes}
+	if err := write("index.html", "index.html", index); err != nil {
+		return err
+	}
+
+	search := make([]searchEntry, 0, len(episodes))
+	for _, ep := range episodes {
+		page := struct {
+			SiteTitle string
+			Episode   siteEpisode
+		}{title, ep}
+		if err := write(ep.Slug+".html", "episode.html", page); err != nil {
+			return err
+		}
+		entry := searchEntry{Slug: ep.Slug, Title: ep.Title}
+		for _, t := range ep.Turns {
+			entry.Turns = append(entry.Turns, searchTurn{t.Seconds, t.Time, t.Speaker, t.Text})
+		}
+		search = append(search, entry)
+	}
+
+	data, err := json.Marshal(search)
+	if err != nil {
+		return fmt.Errorf("failed to marshal search index: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "search.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write search index: %v", err)
+	}
+	for _, asset := range []string{"style.css", "search.js"} {
+		data, err := siteFiles.ReadFile("templates/site/" + asset)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(out, asset), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", asset, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Thresholds of the transcription quality heuristics. Conversational speech
+// runs at about 2.5 words a second; Whisper hallucinations show up as loops,
+// long stretches with almost no words, or more words than anyone can say.
+const (
+	minLoopRepeats    = 4
+	minLoopWords      = 8
+	maxLoopPhrase     = 8
+	minIdenticalRun   = 3
+	minIdenticalWords = 3
+	sparseSegmentSecs = 10.0
+	minWordsPerSecond = 0.4
+	maxWordsPerSecond = 6.0
+	suspectRegionPad  = 1.0
+	maxReportedIssues = 10
+	retryTemperature  = 0.4
+)
+
+// Whisper's stock hallucinations over silence and music, learned from subtitled
+// videos, normalized as by normalizeWord. A segment opening with a credit line
+// is never speech; sign-offs may be, so they only count when Whisper itself
+// doubts the segment is speech.
+var (
+	creditHallucinations = []string{
+		"subtitles by", "transcribed by", "transcription by", "captions by", "translated by",
+	}
+	signOffHallucinations = []string{
+		"thank you for watching", "thanks for watching", "please subscribe",
+		"like and subscribe", "see you in the next video", "thank you",
+	}
+)
+
+// hallucinationNoSpeech is the no-speech probability above which a sign-off
+// phrase is taken for a hallucination.
+const hallucinationNoSpeech = 0.5
+
+// stockHallucination returns the stock phrase a segment consists of, or "".
+func stockHallucination(s Segment) string {
+	words := normalizedWords(s.Text)
+	text := strings.Join(words, " ")
+	if strings.Contains(text, "amaraorg") {
+		return "amara.org"
+	}
+	for _, p := range creditHallucinations {
+		if strings.HasPrefix(text, p+" ") {
+			return p
+		}
+	}
+	if s.NoSpeechProb < hallucinationNoSpeech {
+		return ""
+	}
+	for _, p := range signOffHallucinations {
+		// The phrase, perhaps with a few words more: "thanks for watching everyone"
+		if text == p || (strings.HasPrefix(text, p+" ") && len(words) <= len(strings.Fields(p))+3) {
+			return p
+		}
+	}
+	return ""
+}
+
+// qualityIssue is a stretch of a transcription that looks like a Whisper
+// hallucination rather than what was said.
+type qualityIssue struct {
+	Kind       string
+	Start, End float64
+	Detail     string
+}
+
+func (q qualityIssue) String() string {
+	return fmt.Sprintf("%s-%s %s: %s", formatTimestamp(q.Start, ".")[:8], formatTimestamp(q.End, ".")[:8], q.Kind, q.Detail)
+}
+
+// assessTranscript runs the hallucination heuristics over the segments of a
+// transcription: stock phrases Whisper invents over silence, repetition loops
+// within a segment, runs of identical
+// segments of a few words (repeated "Yeah." is just conversation), and segments with implausibly few or many words for their length.
+func assessTranscript(t *Transcript) []qualityIssue {
+	var issues []qualityIssue
+	segments := t.Segments
+	for i := 0; i < len(segments); i++ {
+		s := segments[i]
+		if s.Kind == eventKind {
+			continue
+		}
+		words := normalizedWords(s.Text)
+		if phrase := stockHallucination(s); phrase != "" {
+			issues = append(issues, qualityIssue{"stock hallucination", s.Start, s.End, fmt.Sprintf("%q, typical of silence or music", truncateWords(s.Text, 8))})
+			continue
+		}
+		if phrase, repeats := repetitionLoop(words); repeats > 0 {
+			issues = append(issues, qualityIssue{"repetition loop", s.Start, s.End, fmt.Sprintf("%q repeated %d times", phrase, repeats)})
+		}
+
+		if len(words) >= minIdenticalWords {
+			run := i + 1
+			for run < len(segments) && strings.Join(normalizedWords(segments[run].Text), " ") == strings.Join(words, " ") {
+				run++
+			}
+			if run-i >= minIdenticalRun {
+				issues = append(issues, qualityIssue{"identical segments", s.Start, segments[run-1].End, fmt.Sprintf("%q %d times in a row", truncateWords(s.Text, 8), run-i)})
+				i = run - 1
+				continue
+			}
+		}
+
+		d := s.End - s.Start
+		rate := float64(len(words)) / max(d, 0.001)
+		switch {
+		case d >= sparseSegmentSecs && rate < minWordsPerSecond:
+			issues = append(issues, qualityIssue{"low word density", s.Start, s.End, fmt.Sprintf("%d words in %.0f seconds", len(words), d)})
+		case d > 0 && len(words) >= minLoopWords && rate > maxWordsPerSecond:
+			issues = append(issues, qualityIssue{"high word density", s.Start, s.End, fmt.Sprintf("%d words in %.1f seconds", len(words), d)})
+		}
+	}
+	return issues
+}
+
+// repetitionLoop finds a phrase of up to maxLoopPhrase words repeated back to
+// back at least minLoopRepeats times and covering at least minLoopWords words,
+// the signature of Whisper getting stuck.
+func repetitionLoop(words []string) (string, int) {
+	for n := 1; n <= maxLoopPhrase; n++ {
+		for i := 0; i+n <= len(words); i++ {
+			repeats := 1
+			for j := i + n; j+n <= len(words) && equalWords(words[i:i+n], words[j:j+n]); j += n {
+				repeats++
+			}
+			if repeats >= minLoopRepeats && repeats*n >= minLoopWords {
+				return strings.Join(words[i:i+n], " "), repeats
+			}
+		}
+	}
+	return "", 0
+}
+
+func equalWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// truncateWords shortens text to at most n words for display.
+func truncateWords(text string, n int) string {
+	words := strings.Fields(text)
+	if len(words) <= n {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:n], " ") + " …"
+}
+
+// reportQuality prints the issues found as warnings and returns them as
+// strings for the run manifest.
+func (p *Pipeline) reportQuality(issues []qualityIssue) []string {
+	if len(issues) == 0 {
+		return nil
+	}
+	p.console.warnf("%d stretches of the transcription look like hallucinations and may need review:\n", len(issues))
+	var lines []string
+	for i, q := range issues {
+		lines = append(lines, q.String())
+		if i < maxReportedIssues {
+			fmt.Fprintf(p.console.errOut, "  %s\n", q)
+		}
+	}
+	if len(issues) > maxReportedIssues {
+		fmt.Fprintf(p.console.errOut, "  ... and %d more (see the manifest)\n", len(issues)-maxReportedIssues)
+	}
+	return lines
+}
+
+// whisperOptions adjusts a single Whisper request, for retries.
+type whisperOptions struct {
+	// Temperature is sent as Whisper's sampling temperature when set.
+	Temperature float64
+	// NoPrompt leaves out the vocabulary prompt, which can itself seed loops.
+	NoPrompt bool
+}
+
+type whisperOptionsKey struct{}
+
+// withWhisperOptions returns a context that makes transcribeAudio use opts.
+func withWhisperOptions(ctx context.Context, opts whisperOptions) context.Context {
+	return context.WithValue(ctx, whisperOptionsKey{}, opts)
+}
+
+// whisperOptionsFrom returns the options set with withWhisperOptions, if any.
+func whisperOptionsFrom(ctx context.Context) whisperOptions {
+	opts, _ := ctx.Value(whisperOptionsKey{}).(whisperOptions)
+	return opts
+}
+
+// retrySuspectRegions transcribes the stretches of audio around the issues
+// again with retryRegion, and splices each retry into t when it passes the
+// heuristics where the first attempt didn't. It returns the issues that remain.
+func (p *Pipeline) retrySuspectRegions(ctx context.Context, be backend, apiKey, audioPath string, t *Transcript, issues []qualityIssue) []qualityIssue {
+	var remaining []qualityIssue
+	for _, region := range mergeIssueRegions(issues) {
+		first, last := segmentRange(t.Segments, region.Start-suspectRegionPad, region.End+suspectRegionPad)
+		if first < 0 {
+			remaining = append(remaining, region.issues...)
+			continue
+		}
+		start, end := t.Segments[first].Start, t.Segments[last].End
+		retry, err := p.retryRegion(ctx, be, apiKey, audioPath, start, end)
+		if err != nil {
+			p.console.warnf("failed to retry %s-%s: %v\n", formatTimestamp(start, ".")[:8], formatTimestamp(end, ".")[:8], err)
+			remaining = append(remaining, region.issues...)
+			continue
+		}
+		if again := assessTranscript(retry); len(again) > 0 {
+			remaining = append(remaining, region.issues...)
+			continue
+		}
+		p.console.progressf("Retranscribed %s-%s, which looked like a hallucination\n", formatTimestamp(start, ".")[:8], formatTimestamp(end, ".")[:8])
+		spliceSegments(t, first, last, retry.Segments)
+	}
+	return remaining
+}
+
+// retryRegion transcribes the audio between start and end seconds again with a
+// higher temperature and no prompt, after trimming leading and trailing silence
+// so that Whisper isn't handed the quiet stretches it tends to fill with
+// invented text. A region with no speech at all comes back empty without a
+// request.
+func (p *Pipeline) retryRegion(ctx context.Context, be backend, apiKey, audioPath string, start, end float64) (*Transcript, error) {
+	from, to, silent, err := speechBounds(ctx, audioPath, start, end)
+	switch {
+	case err != nil:
+		p.console.warnf("failed to detect silence, retrying untrimmed: %v\n", err)
+	case silent:
+		return &Transcript{}, nil
+	default:
+		start, end = start+from, start+to
+	}
+	p.stats.retries.Add(1)
+	ctx = withWhisperOptions(ctx, whisperOptions{Temperature: retryTemperature, NoPrompt: true})
+	return p.transcribeChunk(ctx, be, apiKey, audioPath, start, end)
+}
+
+// issueRegion is a stretch of audio covering one or more overlapping issues.
+type issueRegion struct {
+	Start, End float64
+	issues     []qualityIssue
+}
+
+// mergeIssueRegions joins issues that overlap or nearly touch, in time order
+// as assessTranscript reports them.
+func mergeIssueRegions(issues []qualityIssue) []issueRegion {
+	var regions []issueRegion
+	for _, q := range issues {
+		if n := len(regions); n > 0 && q.Start <= regions[n-1].End+2*suspectRegionPad {
+			regions[n-1].End = max(regions[n-1].End, q.End)
+			regions[n-1].issues = append(regions[n-1].issues, q)
+			continue
+		}
+		regions = append(regions, issueRegion{q.Start, q.End, []qualityIssue{q}})
+	}
+	return regions
+}
+
+// segmentRange returns the indexes of the first and last segments overlapping
+// start to end, or -1 if none do.
+func segmentRange(segments []Segment, start, end float64) (int, int) {
+	first, last := -1, -1
+	for i, s := range segments {
+		if s.End > start && s.Start < end {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	return first, last
+}
+
+// spliceSegments replaces segments first to last of t with replacement and
+// renumbers them.
+func spliceSegments(t *Transcript, first, last int, replacement []Segment) {
+	segments := append([]Segment(nil), t.Segments[:first]...)
+	segments = append(segments, replacement...)
+	segments = append(segments, t.Segments[last+1:]...)
+	for i := range segments {
+		segments[i].ID = i
+	}
+	t.Segments = segments
+	t.Text = joinSegmentText(segments)
+}
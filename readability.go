@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Thresholds of the transcript grade. Spoken English transcribes at around
+// grade 6 to 10 in sentences of 10 to 20 words; far longer sentences usually
+// mean the transcription lost its punctuation.
+const (
+	maxEasyGrade         = 12.0
+	maxSentenceWords     = 25.0
+	minWordConfidence    = 0.85
+	maxQualityPenalty    = 30
+	maxReviewPenalty     = 20
+	maxReadingPenalty    = 15
+	maxRunOnPenalty      = 15
+	maxConfidencePenalty = 20
+)
+
+// readability measures how easily a transcript reads. The Flesch formulas are
+// for English, so other languages score as harder than they are.
+type readability struct {
+	Sentences          int     `json:"sentences"`
+	Words              int     `json:"words"`
+	AvgSentenceWords   float64 `json:"avg_sentence_words"`
+	FleschReadingEase  float64 `json:"flesch_reading_ease"`
+	FleschKincaidGrade float64 `json:"flesch_kincaid_grade"`
+}
+
+// transcriptGrade is the overall quality grade of a run's transcript, from A
+// for one ready to publish to F for one that needs heavy editing.
+type transcriptGrade struct {
+	Grade       string      `json:"grade"`
+	Score       int         `json:"score"`
+	Readability readability `json:"readability"`
+	// Reasons lists what lowered the score.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// measureReadability scores the speech of t.
+func measureReadability(t *Transcript) readability {
+	var r readability
+	syllables := 0
+	for _, s := range t.Segments {
+		if s.Kind == eventKind {
+			continue
+		}
+		for _, sentence := range sentencePattern.FindAllString(s.Text, -1) {
+			words := strings.Fields(sentence)
+			if len(words) == 0 {
+				continue
+			}
+			r.Sentences++
+			r.Words += len(words)
+			for _, w := range words {
+				syllables += countSyllables(w)
+			}
+		}
+	}
+	if r.Sentences == 0 {
+		return r
+	}
+	wordsPerSentence := float64(r.Words) / float64(r.Sentences)
+	syllablesPerWord := float64(syllables) / float64(r.Words)
+	r.AvgSentenceWords = wordsPerSentence
+	r.FleschReadingEase = 206.835 - 1.015*wordsPerSentence - 84.6*syllablesPerWord
+	r.FleschKincaidGrade = max(0, 0.39*wordsPerSentence+11.8*syllablesPerWord-15.59)
+	return r
+}
+
+// countSyllables estimates the syllables of an English word by its groups of
+// vowels, not counting a silent final e.
+func countSyllables(word string) int {
+	word = strings.ToLower(strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }))
+	if word == "" {
+		return 0
+	}
+	n, vowel := 0, false
+	for _, r := range word {
+		v := strings.ContainsRune("aeiouy", r)
+		if v && !vowel {
+			n++
+		}
+		vowel = v
+	}
+	if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && n > 1 {
+		n--
+	}
+	return max(n, 1)
+}
+
+// gradeTranscript grades t from its readability, the quality issues found in
+// the transcription, the turns flagged for review, and the words' confidence.
+func gradeTranscript(t *Transcript, issues int) *transcriptGrade {
+	g := &transcriptGrade{Readability: measureReadability(t)}
+	score := 100
+	penalize := func(points, limit int, reason string) {
+		if points > 0 {
+			score -= min(points, limit)
+			g.Reasons = append(g.Reasons, reason)
+		}
+	}
+	penalize(5*issues, maxQualityPenalty, fmt.Sprintf("%d quality warning(s)", issues))
+
+	turns, review := 0, 0
+	var confidence float64
+	words := 0
+	for _, s := range t.Segments {
+		if s.Kind == eventKind {
+			continue
+		}
+		turns++
+		if s.Review {
+			review++
+		}
+		for _, w := range s.Words {
+			if w.Confidence > 0 {
+				confidence += w.Confidence
+				words++
+			}
+		}
+	}
+	if turns > 0 {
+		penalize(int(100*float64(review)/float64(turns)), maxReviewPenalty, fmt.Sprintf("%d turn(s) flagged for review", review))
+	}
+	if words > 0 {
+		if avg := confidence / float64(words); avg < minWordConfidence {
+			penalize(int(100*(minWordConfidence-avg)), maxConfidencePenalty, fmt.Sprintf("average word confidence %.2f", avg))
+		}
+	}
+	r := g.Readability
+	if r.FleschKincaidGrade > maxEasyGrade {
+		penalize(int(3*(r.FleschKincaidGrade-maxEasyGrade)+0.5), maxReadingPenalty, fmt.Sprintf("hard to read (grade %.1f)", r.FleschKincaidGrade))
+	}
+	if r.AvgSentenceWords > maxSentenceWords {
+		penalize(int(r.AvgSentenceWords-maxSentenceWords+0.5), maxRunOnPenalty, fmt.Sprintf("run-on sentences (%.0f words on average)", r.AvgSentenceWords))
+	}
+
+	g.Score = max(score, 0)
+	switch {
+	case g.Score >= 90:
+		g.Grade = "A"
+	case g.Score >= 80:
+		g.Grade = "B"
+	case g.Score >= 70:
+		g.Grade = "C"
+	case g.Score >= 60:
+		g.Grade = "D"
+	default:
+		g.Grade = "F"
+	}
+	return g
+}
+
+// String writes the grade on one line for the run summary.
+func (g *transcriptGrade) String() string {
+	r := g.Readability
+	s := fmt.Sprintf("Transcript grade %s (%d/100): Flesch-Kincaid grade %.1f, reading ease %.0f, %.1f words/sentence", g.Grade, g.Score, r.FleschKincaidGrade, r.FleschReadingEase, r.AvgSentenceWords)
+	if len(g.Reasons) > 0 {
+		s += "; " + strings.Join(g.Reasons, ", ")
+	}
+	return s
+}
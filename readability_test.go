@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCountSyllables(t *testing.T) {
+	tests := []struct {
+		word string
+		want int
+	}{
+		{"cat", 1},
+		{"make", 1},
+		{"the", 1},
+		{"table", 2},
+		{"Hello,", 2},
+		{"beautiful", 3},
+		{"rhythm", 1},
+		{"queue", 1},
+		{"123", 0},
+	}
+	for _, tt := range tests {
+		if got := countSyllables(tt.word); got != tt.want {
+			t.Errorf("countSyllables(%q) = %d, want %d", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestMeasureReadability(t *testing.T) {
+	tr := &Transcript{Segments: []Segment{
+		{Text: "The cat sat. The dog ran."},
+		{Text: "[music]", Kind: eventKind},
+	}}
+	r := measureReadability(tr)
+	if r.Sentences != 2 || r.Words != 6 || r.AvgSentenceWords != 3 || r.FleschKincaidGrade != 0 || math.Abs(r.FleschReadingEase-119.19) > 0.01 {
+		t.Errorf("measureReadability = %+v, want 2 sentences of 3 one-syllable words", r)
+	}
+	if r := measureReadability(&Transcript{}); r != (readability{}) {
+		t.Errorf("measureReadability of nothing = %+v, want zero", r)
+	}
+}
+
+func TestGradeTranscript(t *testing.T) {
+	turn := func(review bool, confidence float64) Segment {
+		return Segment{Text: "We talked about the show.", Review: review, Words: []Word{{Text: "show", Confidence: confidence}}}
+	}
+	tests := []struct {
+		name     string
+		segments []Segment
+		issues   int
+		grade    string
+		score    int
+		reason   string
+	}{
+		{"clean", []Segment{turn(false, 0.95), turn(false, 0.95)}, 0, "A", 100, ""},
+		{"quality warnings", []Segment{turn(false, 0.95)}, 3, "B", 85, "3 quality warning(s)"},
+		{"warnings capped", []Segment{turn(false, 0.95)}, 10, "C", 70, "10 quality warning(s)"},
+		{"review", []Segment{turn(true, 0.95), turn(false, 0.95)}, 0, "B", 80, "1 turn(s) flagged for review"},
+		{"low confidence", []Segment{turn(false, 0.5)}, 0, "B", 80, "average word confidence 0.50"},
+		{"run-on", []Segment{{Text: strings.Repeat("and then we ", 15) + "stopped."}}, 0, "C", 78, "run-on sentences"},
+		{"everything", []Segment{turn(true, 0.5)}, 10, "F", 30, "flagged for review"},
+	}
+	for _, tt := range tests {
+		g := gradeTranscript(&Transcript{Segments: tt.segments}, tt.issues)
+		if g.Grade != tt.grade || g.Score != tt.score {
+			t.Errorf("%s: grade %s (%d), want %s (%d); %v", tt.name, g.Grade, g.Score, tt.grade, tt.score, g.Reasons)
+		}
+		if tt.reason == "" && len(g.Reasons) > 0 || !strings.Contains(strings.Join(g.Reasons, "; "), tt.reason) {
+			t.Errorf("%s: reasons %q, want %q", tt.name, g.Reasons, tt.reason)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces a secret in error messages and logs.
+const redacted = "[REDACTED]"
+
+// secretEnvVars hold credentials besides the backends' keys, whose values are
+// redacted wherever they appear.
+var secretEnvVars = []string{"AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "HF_TOKEN", "SMTP_PASSWORD", "SENDGRID_API_KEY", encryptionKeyEnv,
+	podcastIndexKeyEnv, podcastIndexSecretEnv, listenNotesKeyEnv, wordpressPasswordEnv, ghostKeyEnv,
+	youtubeTokenEnv, youtubeClientSecretEnv, youtubeRefreshTokenEnv, transistorKeyEnv, dropboxTokenEnv}
+
+// secretPatterns match credentials by their shape, for those the tool doesn't
+// know the value of, such as a token quoted back in an API's error body or the
+// signature of a presigned URL.
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Authorization header values
+	{regexp.MustCompile(`(?i)\b(bearer|basic)(\s+)[A-Za-z0-9._~+/=-]*[0-9][A-Za-z0-9._~+/=-]*`), "${1}${2}" + redacted},
+	// OpenAI, SendGrid and Google OAuth keys and tokens
+	{regexp.MustCompile(`\b(sk-|SG\.|ya29\.)[A-Za-z0-9._-]{8,}`), "${1}" + redacted},
+	// AWS access key IDs
+	{regexp.MustCompile(`\b(AKIA|ASIA)[A-Z0-9]{16}\b`), "${1}" + redacted},
+	// Signatures and credentials in the query of signed URLs
+	{regexp.MustCompile(`(?i)([?&](?:x-amz-signature|x-amz-credential|x-amz-security-token|x-goog-signature|x-goog-credential|signature|sig|key|api_key|apikey|token|access_token|password)=)[^&\s"'<>]+`), "${1}" + redacted},
+	// Tokens and secrets in JSON replies, such as an OAuth token refresh's
+	{regexp.MustCompile(`("(?:access_token|refresh_token|id_token|client_secret|api_key|password)"\s*:\s*")[^"]+`), "${1}" + redacted},
+	// Passwords in URLs
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}" + redacted + "@"},
+}
+
+var (
+	secretsMu sync.Mutex
+	// secretValues are the credentials met at runtime, such as a token from
+	// gcloud, that aren't in the environment.
+	secretValues []string
+)
+
+// registerSecret has s redacted from then on.
+func registerSecret(s string) {
+	if len(s) < 8 {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secretValues = append(secretValues, s)
+}
+
+// knownSecrets returns the credentials whose values are redacted: those in
+// the environment and those registered.
+func knownSecrets() []string {
+	names := append([]string{}, secretEnvVars...)
+	for _, b := range backends {
+		if b.keyEnv != "" {
+			names = append(names, b.keyEnv)
+		}
+	}
+	var values []string
+	for _, name := range names {
+		if v := os.Getenv(name); len(v) >= 8 {
+			values = append(values, v)
+		}
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	return append(values, secretValues...)
+}
+
+// redactSecrets removes API keys, tokens and URL signatures from s. Every
+// error message and log line passes through it before it's written.
+func redactSecrets(s string) string {
+	for _, v := range knownSecrets() {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// redactingWriter redacts secrets from everything written to w. Each write
+// should be a whole message, as fmt's are, so no secret is split across two.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stderr is where errors are written, with secrets redacted.
+var stderr io.Writer = redactingWriter{os.Stderr}
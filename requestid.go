@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// clientRequestIDHeader carries the ID the tool gives each API request.
+// OpenAI keeps it with the request, so its support can find a request by it.
+const clientRequestIDHeader = "X-Client-Request-Id"
+
+// providerRequestIDHeaders are the response headers in which providers return
+// their own ID of a request.
+var providerRequestIDHeaders = []string{"X-Request-Id", "Dg-Request-Id", "X-Amzn-Requestid", "X-Amz-Request-Id", "X-Guploader-Uploadid"}
+
+// newRequestID returns a random ID for an API request.
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "pt-" + hex.EncodeToString(b)
+}
+
+// identityTransport sets the User-Agent of requests that don't set their own,
+// with -user-agent appended, and gives each request an ID of its own. It sits
+// outside all other middleware, so the audit log sees the ID.
+type identityTransport struct {
+	next   http.RoundTripper
+	config *Config
+}
+
+func (t *identityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		ua := userAgent()
+		if extra := strings.TrimSpace(t.config.UserAgent); extra != "" {
+			ua += " " + extra
+		}
+		req.Header.Set("User-Agent", ua)
+	}
+	if req.Header.Get(clientRequestIDHeader) == "" {
+		req.Header.Set(clientRequestIDHeader, newRequestID())
+	}
+	return t.next.RoundTrip(req)
+}
+
+// providerRequestID returns the provider's ID of the request resp answers.
+func providerRequestID(resp *http.Response) string {
+	for _, h := range providerRequestIDHeaders {
+		if id := resp.Header.Get(h); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// requestRef names the request resp answers for an error message, e.g.
+// " (request pt-1a2b..., provider request req_9f8e...)", so a failure can be
+// looked up on both sides.
+func requestRef(resp *http.Response) string {
+	var ids []string
+	if resp.Request != nil {
+		if id := resp.Request.Header.Get(clientRequestIDHeader); id != "" {
+			ids = append(ids, "request "+id)
+		}
+	}
+	if id := providerRequestID(resp); id != "" {
+		ids = append(ids, "provider request "+id)
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", strings.Join(ids, ", "))
+}
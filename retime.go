@@ -0,0 +1,105 @@
+package main
+
+import "strings"
+
+// parseOffset parses a signed -offset or -drift amount: a position as read by
+// parseClock, optionally preceded by a minus sign, such as "2.5s" or "-1:30".
+func parseOffset(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	sign := 1.0
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		s, sign = rest, -1
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	v, err := parseClock(s)
+	if err != nil {
+		return 0, err
+	}
+	return sign * v, nil
+}
+
+// mapTimes replaces every timestamp x in t with at(x).
+func (t *Transcript) mapTimes(at func(float64) float64) {
+	for i := range t.Segments {
+		s := &t.Segments[i]
+		s.Start, s.End = at(s.Start), at(s.End)
+		for j := range s.Words {
+			s.Words[j].Start, s.Words[j].End = at(s.Words[j].Start), at(s.Words[j].End)
+		}
+	}
+	for i := range t.Chapters {
+		t.Chapters[i].Start, t.Chapters[i].End = at(t.Chapters[i].Start), at(t.Chapters[i].End)
+	}
+	for i := range t.Entities {
+		t.Entities[i].Start, t.Entities[i].End = at(t.Entities[i].Start), at(t.Entities[i].End)
+	}
+	for i := range t.Overlaps {
+		t.Overlaps[i].Start, t.Overlaps[i].End = at(t.Overlaps[i].Start), at(t.Overlaps[i].End)
+	}
+}
+
+// retimed returns a copy of t moved onto the timeline of the published
+// episode, for -offset and -drift: every time x becomes x + offset plus drift
+// seconds per hour of x. Anything that ends up entirely before the start, as
+// when the published intro is shorter, is dropped, and what straddles it is
+// cut at zero.
+func (t *Transcript) retimed(offset, drift float64) *Transcript {
+	c := *t
+	c.Segments = make([]Segment, 0, len(t.Segments))
+	for _, s := range t.Segments {
+		s.Words = append([]Word(nil), s.Words...)
+		c.Segments = append(c.Segments, s)
+	}
+	c.Chapters = append([]Chapter(nil), t.Chapters...)
+	c.Entities = append([]Entity(nil), t.Entities...)
+	c.Overlaps = append([]Overlap(nil), t.Overlaps...)
+	at := func(x float64) float64 { return x + offset + drift*x/3600 }
+	c.mapTimes(at)
+	if c.Duration > 0 {
+		c.Duration = max(at(c.Duration), 0)
+	}
+
+	segments := c.Segments[:0]
+	for _, s := range c.Segments {
+		if s.End <= 0 {
+			continue
+		}
+		s.Start = max(s.Start, 0)
+		words := s.Words[:0]
+		for _, w := range s.Words {
+			if w.End > 0 {
+				w.Start = max(w.Start, 0)
+				words = append(words, w)
+			}
+		}
+		s.Words = words
+		segments = append(segments, s)
+	}
+	c.Segments = segments
+	chapters := c.Chapters[:0]
+	for _, ch := range c.Chapters {
+		if ch.End > 0 {
+			ch.Start = max(ch.Start, 0)
+			chapters = append(chapters, ch)
+		}
+	}
+	c.Chapters = chapters
+	entities := c.Entities[:0]
+	for _, e := range c.Entities {
+		if e.End > 0 {
+			e.Start = max(e.Start, 0)
+			entities = append(entities, e)
+		}
+	}
+	c.Entities = entities
+	overlaps := c.Overlaps[:0]
+	for _, o := range c.Overlaps {
+		if o.End > 0 {
+			o.Start = max(o.Start, 0)
+			overlaps = append(overlaps, o)
+		}
+	}
+	c.Overlaps = overlaps
+	return &c
+}
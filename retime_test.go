@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseOffset(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		err  bool
+	}{
+		{"2.5", 2.5, false},
+		{"-2.5", -2.5, false},
+		{"+1:30", 90, false},
+		{" -1:00:00 ", -3600, false},
+		{"--1", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseOffset(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseOffset(%q) = %g, %v; want %g, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRetimed(t *testing.T) {
+	source := func() *Transcript {
+		return &Transcript{
+			Duration: 7200,
+			Segments: []Segment{
+				{Start: 0, End: 4, Text: "intro", Words: []Word{{Text: "intro", Start: 0, End: 4}}},
+				{Start: 4, End: 12, Text: "welcome back", Words: []Word{{Text: "welcome", Start: 4, End: 6}, {Text: "back", Start: 8, End: 12}}},
+				{Start: 3600, End: 3610, Text: "an hour in"},
+			},
+			Chapters: []Chapter{{Headline: "Intro", Start: 0, End: 5}, {Headline: "Main", Start: 5, End: 7200}},
+		}
+	}
+	type span struct{ Start, End float64 }
+	tests := []struct {
+		name          string
+		offset, drift float64
+		segments      []span
+		words         []span
+		chapters      []string
+		duration      float64
+	}{
+		{"none", 0, 0, []span{{0, 4}, {4, 12}, {3600, 3610}}, []span{{0, 4}, {4, 6}, {8, 12}}, []string{"Intro", "Main"}, 7200},
+		{"later", 10, 0, []span{{10, 14}, {14, 22}, {3610, 3620}}, []span{{10, 14}, {14, 16}, {18, 22}}, []string{"Intro", "Main"}, 7210},
+		{"shorter intro", -7, 0, []span{{0, 5}, {3593, 3603}}, []span{{1, 5}}, []string{"Main"}, 7193},
+		{"drift", 0, 2, []span{{0, 4.002}, {4.002, 12.007}, {3602, 3612.006}}, nil, nil, 7204},
+	}
+	for _, tt := range tests {
+		src := source()
+		got := src.retimed(tt.offset, tt.drift)
+		var segments, words []span
+		for _, s := range got.Segments {
+			segments = append(segments, span{millis(s.Start), millis(s.End)})
+			for _, w := range s.Words {
+				words = append(words, span{millis(w.Start), millis(w.End)})
+			}
+		}
+		if !reflect.DeepEqual(segments, tt.segments) {
+			t.Errorf("%s: segments = %v, want %v", tt.name, segments, tt.segments)
+		}
+		if tt.words != nil && !reflect.DeepEqual(words, tt.words) {
+			t.Errorf("%s: words = %v, want %v", tt.name, words, tt.words)
+		}
+		if tt.chapters != nil {
+			var headlines []string
+			for _, ch := range got.Chapters {
+				headlines = append(headlines, ch.Headline)
+			}
+			if !reflect.DeepEqual(headlines, tt.chapters) {
+				t.Errorf("%s: chapters = %v, want %v", tt.name, headlines, tt.chapters)
+			}
+		}
+		if millis(got.Duration) != tt.duration {
+			t.Errorf("%s: duration = %g, want %g", tt.name, got.Duration, tt.duration)
+		}
+		if !reflect.DeepEqual(src, source()) {
+			t.Errorf("%s: retimed changed the source transcript", tt.name)
+		}
+	}
+}
+
+func millis(x float64) float64 { return math.Round(x*1000) / 1000 }
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed templates/review
+var reviewFiles embed.FS
+
+// reviewFile is the log of an episode's human corrections, written next to
+// its diarized transcript.
+const reviewFile = "reviewed.json"
+
+// maxGlossaryWords is the longest correction, in words, suggested as a
+// glossary entry; longer ones are rewrites rather than mis-hearings.
+const maxGlossaryWords = 4
+
+// reviewItem is a turn in the review queue.
+type reviewItem struct {
+	// Path identifies the episode by its diarized transcript, and Index the
+	// turn within it.
+	Path       string   `json:"path"`
+	Episode    string   `json:"episode"`
+	Index      int      `json:"index"`
+	Start      float64  `json:"start"`
+	StartTime  string   `json:"start_time"`
+	Speaker    string   `json:"speaker"`
+	Text       string   `json:"text"`
+	Reason     string   `json:"reason"`
+	Confidence float64  `json:"confidence"`
+	Speakers   []string `json:"speakers"`
+}
+
+// reviewCorrection is one correction made in review, as logged in reviewFile.
+type reviewCorrection struct {
+	Time       float64   `json:"time"`
+	ReviewedAt time.Time `json:"reviewed_at"`
+	// Heard and Corrected are the words that changed, and Speaker and
+	// CorrectedSpeaker the speaker label if it did.
+	Heard            string `json:"heard,omitempty"`
+	Corrected        string `json:"corrected,omitempty"`
+	Speaker          string `json:"speaker,omitempty"`
+	CorrectedSpeaker string `json:"corrected_speaker,omitempty"`
+}
+
+// reviewServer serves the review queue of the transcripts under a directory
+// and applies the corrections sent back.
+type reviewServer struct {
+	p         *Pipeline
+	dir       string
+	threshold float64
+	// glossary is the show glossary corrections are learned into once made
+	// learnAfter times, or empty.
+	glossary   string
+	learnAfter int
+	// mu serializes the reading and rewriting of transcripts.
+	mu sync.Mutex
+}
+
+// runReview implements the review command.
+func runReview(args []string) error {
+	flags := flag.NewFlagSet("review", flag.ExitOnError)
+	in := flags.String("in", ".", "Directory searched recursively for diarized transcripts")
+	addr := flags.String("addr", "localhost:8765", "Address the review web UI and API listen on")
+	threshold := flags.Float64("threshold", 0.6, "Queue turns whose word confidence (0-1) is below this, besides those flagged with -review-threshold")
+	showName := flags.String("show", "", "Show profile whose glossary learns the corrections made at least -learn-after times")
+	learnAfter := flags.Int("learn-after", 2, "How many times a correction must be made before -show's glossary learns it")
+	configPath := flags.String("config", defaultConfigPath(), "Path to the JSON configuration file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription review [-in dir] [-addr host:port] [-threshold confidence] [-show name [-learn-after n]]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *threshold < 0 || *threshold > 1 {
+		return fmt.Errorf("-threshold must be between 0 and 1")
+	}
+	if *learnAfter < 1 {
+		return fmt.Errorf("-learn-after must be at least 1")
+	}
+	cfg := defaultConfig()
+	var err error
+	if cfg.EncryptionKey, err = loadEncryptionKey(""); err != nil {
+		return err
+	}
+	s := &reviewServer{p: newPipeline(&cfg, nil), dir: *in, threshold: *threshold, learnAfter: *learnAfter}
+	if *showName != "" {
+		set := map[string]bool{}
+		flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		fileConfig, err := loadFileConfig(*configPath, set["config"])
+		if err != nil {
+			return err
+		}
+		show, err := fileConfig.show(*showName)
+		if err != nil {
+			return err
+		}
+		if show.Glossary == "" {
+			return fmt.Errorf("show %q has no glossary to learn corrections into; set its \"glossary\" file", *showName)
+		}
+		s.glossary = fileConfig.resolve(show.Glossary)
+	}
+	queue, err := s.queue()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reviewing %d turn(s) at http://%s/\n", len(queue), *addr)
+	return http.ListenAndServe(*addr, s.handler())
+}
+
+// handler routes the web UI and the API:
+//
+//	GET  /api/queue        the turns awaiting review
+//	POST /api/review       a correction: {"path", "index", "start", "text", "speaker"}
+//	GET  /api/suggestions  glossary entries suggested by recurring corrections
+func (s *reviewServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		data, _ := reviewFiles.ReadFile("templates/review/index.html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(data)
+	})
+	mux.HandleFunc("/api/queue", func(w http.ResponseWriter, r *http.Request) {
+		queue, err := s.queue()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeReviewJSON(w, map[string][]reviewItem{"queue": queue})
+	})
+	mux.HandleFunc("/api/review", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST a correction", http.StatusMethodNotAllowed)
+			return
+		}
+		// Browsers only send JSON across origins after a preflight this server
+		// doesn't answer, so other sites can't post corrections
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "corrections must be sent as application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req struct {
+			Path    string  `json:"path"`
+			Index   int     `json:"index"`
+			Start   float64 `json:"start"`
+			Text    string  `json:"text"`
+			Speaker string  `json:"speaker"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := s.correct(req.Path, req.Index, req.Start, req.Text, req.Speaker)
+		var bad *reviewError
+		switch {
+		case errors.As(err, &bad):
+			http.Error(w, err.Error(), bad.status)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("/api/suggestions", func(w http.ResponseWriter, r *http.Request) {
+		suggestions, err := s.suggestions()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeReviewJSON(w, map[string][]glossarySuggestion{"suggestions": suggestions})
+	})
+	return mux
+}
+
+// reviewError is a correction the server refuses, with the HTTP status to
+// answer it with.
+type reviewError struct {
+	status int
+	msg    string
+}
+
+func (e *reviewError) Error() string { return e.msg }
+
+func writeReviewJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// queue returns the turns awaiting review in every episode, oldest episode
+// first: those flagged for their speaker confidence, and those whose word
+// confidence is below the threshold. Turns already reviewed are left out.
+func (s *reviewServer) queue() ([]reviewItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	episodes, err := loadCatalog(s.p.config.EncryptionKey, s.dir)
+	if err != nil {
+		return nil, err
+	}
+	queue := []reviewItem{}
+	for _, ep := range episodes {
+		t := ep.transcript
+		for i, seg := range t.Segments {
+			if seg.Kind == eventKind || seg.Reviewed {
+				continue
+			}
+			item := reviewItem{
+				Path:      ep.Path,
+				Episode:   ep.Title,
+				Index:     i,
+				Start:     seg.Start,
+				StartTime: formatTimestamp(seg.Start, ".")[:8],
+				Speaker:   seg.Speaker,
+				Text:      seg.Text,
+				Speakers:  t.speakers(),
+			}
+			if c, ok := segmentConfidence(seg); ok && c < s.threshold {
+				item.Reason, item.Confidence = "words", c
+			}
+			if seg.Review {
+				item.Reason, item.Confidence = "speaker", seg.SpeakerConfidence
+			}
+			if item.Reason != "" {
+				queue = append(queue, item)
+			}
+		}
+	}
+	return queue, nil
+}
+
+// correct applies a reviewer's text and speaker to the turn at index of the
+// transcript at path, marks it reviewed, and logs what changed in the
+// episode's reviewFile. start must be the turn's, so corrections made against
+// a transcript that has since changed are refused.
+func (s *reviewServer) correct(path string, index int, start float64, text, speaker string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.inCatalog(path) {
+		return &reviewError{http.StatusNotFound, "no such transcript under the review directory"}
+	}
+	t, err := loadTranscript(s.p.config.EncryptionKey, path)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= len(t.Segments) || t.Segments[index].Start != start {
+		return &reviewError{http.StatusConflict, "the turn has moved; reload the queue"}
+	}
+	seg := &t.Segments[index]
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return &reviewError{http.StatusBadRequest, "the corrected text is empty"}
+	}
+	c := reviewCorrection{Time: seg.Start, ReviewedAt: time.Now().UTC()}
+	if text != seg.Text {
+		c.Heard, c.Corrected = changedWords(seg.Text, text)
+		correctWords(seg, text)
+		if t.Text != "" {
+			t.Text = joinSegmentText(t.Segments)
+		}
+	}
+	if speaker = strings.TrimSpace(speaker); speaker != "" && speaker != seg.Speaker {
+		c.Speaker, c.CorrectedSpeaker = seg.Speaker, speaker
+		seg.Speaker = speaker
+	}
+	seg.Review, seg.Reviewed = false, true
+	if err := s.p.saveTranscript(path, t); err != nil {
+		return err
+	}
+	if c.Heard == "" && c.Corrected == "" && c.CorrectedSpeaker == "" {
+		// Confirmed as is
+		return nil
+	}
+	log, err := readReviewLog(s.p.config.EncryptionKey, filepath.Join(filepath.Dir(path), reviewFile))
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(append(log, c), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.p.writeOutput(filepath.Join(filepath.Dir(path), reviewFile), append(data, '\n')); err != nil {
+		return err
+	}
+	if s.glossary != "" {
+		return s.learn()
+	}
+	return nil
+}
+
+// learn adds the suggestions made at least learnAfter times to the show's
+// glossary, so future episodes are corrected, and prompted with the terms.
+func (s *reviewServer) learn() error {
+	suggestions, err := s.collectSuggestions()
+	if err != nil {
+		return err
+	}
+	var learned []glossarySuggestion
+	for _, g := range suggestions {
+		if g.Count >= s.learnAfter {
+			learned = append(learned, g)
+		}
+	}
+	n, err := learnGlossary(s.glossary, learned)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		fmt.Printf("Learned %d glossary term(s) and mis-hearing(s) into %s\n", n, s.glossary)
+	}
+	return nil
+}
+
+// inCatalog reports whether path is a diarized transcript under the review
+// directory, so that the API can't be used to write anywhere else.
+func (s *reviewServer) inCatalog(path string) bool {
+	rel, err := filepath.Rel(s.dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return filepath.Base(path) == filepath.Base(defaultConfig().DiarizedJSONFile)
+}
+
+// readReviewLog reads an episode's reviewFile, which may not exist yet.
+func readReviewLog(key []byte, path string) ([]reviewCorrection, error) {
+	data, err := readStored(key, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var log []reviewCorrection
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return log, nil
+}
+
+// changedWords returns the words of before and after that differ, between
+// the words the two start and end with in common.
+func changedWords(before, after string) (string, string) {
+	a, b := strings.Fields(before), strings.Fields(after)
+	head := 0
+	for head < len(a) && head < len(b) && a[head] == b[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(a)-head && tail < len(b)-head && a[len(a)-1-tail] == b[len(b)-1-tail] {
+		tail++
+	}
+	return strings.Join(a[head:len(a)-tail], " "), strings.Join(b[head:len(b)-tail], " ")
+}
+
+// correctWords gives seg the corrected text. Word timings are kept when the
+// correction has as many words, and dropped otherwise, since they'd no longer
+// line up with the text.
+func correctWords(seg *Segment, text string) {
+	fields := strings.Fields(text)
+	if len(fields) == len(seg.Words) {
+		for i := range seg.Words {
+			seg.Words[i].Text = fields[i]
+		}
+	} else {
+		seg.Words = nil
+	}
+	seg.Text = text
+}
+
+// glossarySuggestion is a term corrected in review, with what it was heard as,
+// as a line of a -glossary file would give it.
+type glossarySuggestion struct {
+	Term  string   `json:"term"`
+	Heard []string `json:"heard"`
+	Count int      `json:"count"`
+	Line  string   `json:"line"`
+}
+
+// suggestions collects the short corrections of every episode's review log
+// into glossary entries, the most corrected first.
+func (s *reviewServer) suggestions() ([]glossarySuggestion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.collectSuggestions()
+}
+
+// collectSuggestions is suggestions with the lock held.
+func (s *reviewServer) collectSuggestions() ([]glossarySuggestion, error) {
+	episodes, err := loadCatalog(s.p.config.EncryptionKey, s.dir)
+	if err != nil {
+		return nil, err
+	}
+	byTerm := map[string]*glossarySuggestion{}
+	var order []string
+	for _, ep := range episodes {
+		log, err := readReviewLog(s.p.config.EncryptionKey, filepath.Join(filepath.Dir(ep.Path), reviewFile))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range log {
+			term, heard := trimPunct(c.Corrected), trimPunct(c.Heard)
+			if term == "" || heard == "" || strings.EqualFold(term, heard) ||
+				len(strings.Fields(term)) > maxGlossaryWords || len(strings.Fields(heard)) > maxGlossaryWords {
+				continue
+			}
+			g, ok := byTerm[term]
+			if !ok {
+				g = &glossarySuggestion{Term: term}
+				byTerm[term] = g
+				order = append(order, term)
+			}
+			g.Count++
+			known := false
+			for _, h := range g.Heard {
+				known = known || strings.EqualFold(h, heard)
+			}
+			if !known {
+				g.Heard = append(g.Heard, heard)
+			}
+		}
+	}
+	suggestions := []glossarySuggestion{}
+	for _, term := range order {
+		g := byTerm[term]
+		g.Line = g.Term + ": " + strings.ToLower(strings.Join(g.Heard, ", "))
+		suggestions = append(suggestions, *g)
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].Count > suggestions[j].Count })
+	return suggestions, nil
+}
+
+// trimPunct trims the punctuation around a phrase.
+func trimPunct(phrase string) string {
+	return strings.TrimFunc(phrase, isPunct)
+}
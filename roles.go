@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// speakerRoles are the roles a speaker can be classified as. Advertisement is
+// for voices heard only in ad reads and promos, such as pre-recorded spots.
+var speakerRoles = []string{"host", "co-host", "guest", "advertisement"}
+
+// rolesResponseFormat is the JSON schema of the role classification reply.
+var rolesResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "speaker_roles",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"speakers": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"label": map[string]any{"type": "string", "description": "The speaker label as given"},
+							"role":  map[string]any{"type": "string", "enum": speakerRoles},
+						},
+						"required":             []string{"label", "role"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"speakers"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// rolesPrompt asks the model to classify the role of each speaker.
+const rolesPrompt = `The following is an excerpt of a diarized podcast transcript. Classify the role of each speaker:
+- host: runs the show, opens and closes it, introduces guests and segments
+- co-host: a regular presenter alongside the host
+- guest: someone invited on this episode, interviewed or introduced
+- advertisement: a voice heard only in ad reads, sponsor spots or promos for other shows
+
+A host reading an ad is still a host. There is usually one host; use co-host only when presenting is clearly shared.
+%s
+Excerpt:
+%s
+
+Respond with a JSON object whose "speakers" array has one entry per label: %s.`
+
+// classifySpeakerRoles asks the chat model for the role of every speaker in t,
+// using the episode metadata and the content of their turns. It returns the
+// speaker information of t with roles filled in.
+func (p *Pipeline) classifySpeakerRoles(ctx context.Context, apiKey string, t *Transcript) ([]SpeakerInfo, TokenUsage, error) {
+	labels := t.speakers()
+	if len(labels) == 0 {
+		return t.Speakers, TokenUsage{}, nil
+	}
+
+	var info strings.Builder
+	if len(p.config.SpeakerNames) > 0 {
+		fmt.Fprintf(&info, "\nThe show's regular presenters are probably among: %s.\n", strings.Join(p.config.SpeakerNames, ", "))
+	}
+	if len(p.config.Guests) > 0 {
+		fmt.Fprintf(&info, "\nThis episode's guests: %s.\n", strings.Join(p.config.Guests, ", "))
+	}
+	if p.config.Title != "" {
+		fmt.Fprintf(&info, "\nEpisode title: %s\n", p.config.Title)
+	}
+	if p.config.Description != "" {
+		fmt.Fprintf(&info, "Episode description: %s\n", p.config.Description)
+	}
+	prompt := fmt.Sprintf(rolesPrompt, info.String(), namingExcerpt(t.Segments), strings.Join(labels, ", "))
+
+	payload := map[string]interface{}{
+		"model":           p.config.DiarizationModel,
+		"messages":        []map[string]string{{"role": "user", "content": prompt}},
+		"temperature":     p.config.Temperature,
+		"response_format": rolesResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to classify speaker roles: %v", err)
+	}
+	var res struct {
+		Speakers []struct {
+			Label string `json:"label"`
+			Role  string `json:"role"`
+		} `json:"speakers"`
+	}
+	if err := json.Unmarshal([]byte(content), &res); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode speaker roles: %v", err)
+	}
+	roles := map[string]string{}
+	for _, s := range res.Speakers {
+		roles[strings.TrimSpace(s.Label)] = strings.ToLower(strings.TrimSpace(s.Role))
+	}
+
+	// Keep the names found by -name-speakers; turns carry the name when one was
+	// applied and the cluster label otherwise
+	infos := make([]SpeakerInfo, len(labels))
+	for i, l := range labels {
+		infos[i] = SpeakerInfo{Label: l}
+		for _, s := range t.Speakers {
+			if s.Label == l || (s.Name != "" && s.Name == l) {
+				infos[i] = s
+				break
+			}
+		}
+		if role := roles[l]; role != "" {
+			infos[i].Role = role
+		}
+	}
+	return infos, usage, nil
+}
+
+// speakerRole returns the role recorded for the speaker of a turn, or "".
+func (t *Transcript) speakerRole(speaker string) string {
+	for _, s := range t.Speakers {
+		if s.Label == speaker || (s.Name != "" && s.Name == speaker) {
+			return s.Role
+		}
+	}
+	return ""
+}
+
+// speakerLegend lists the speakers of t with their roles, e.g.
+// "Alice (host), Bob (guest)", or "" when no roles are known.
+func speakerLegend(t *Transcript) string {
+	var parts []string
+	known := false
+	for _, s := range t.speakers() {
+		if role := t.speakerRole(s); role != "" {
+			parts = append(parts, fmt.Sprintf("%s (%s)", s, role))
+			known = true
+		} else {
+			parts = append(parts, s)
+		}
+	}
+	if !known {
+		return ""
+	}
+	return strings.Join(parts, ", ")
+}
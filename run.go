@@ -0,0 +1,42 @@
+package main
+
+import "net/http"
+
+// Pipeline is a single run of the transcription stages. It carries the run's
+// configuration and the HTTP client its API requests go through, so that
+// several runs can proceed side by side without sharing mutable state.
+type Pipeline struct {
+	config   *Config
+	client   *http.Client
+	identity *identityTransport
+	console  *console
+	stats    runStats
+	// checkpoint, when set, keeps the chunks of a chunked run as they finish.
+	checkpoint *chunkCheckpoint
+	// stream, when set, is written the turns of each chunk as it's diarized.
+	stream *transcriptStream
+	// batches, when set, keeps the batches of -batch until they're collected.
+	batches *stateStore
+	// notify, when set, sends the run's events to the -notify sinks.
+	notify *notifier
+}
+
+// newPipeline returns a run using cfg. The stages read cfg as they execute, so
+// it belongs to this run alone. Requests are sent with transport, or with
+// http.DefaultTransport when it is nil; tests pass one serving recorded
+// responses. Every request carries the tool's User-Agent and an ID of its
+// own.
+func newPipeline(cfg *Config, transport http.RoundTripper) *Pipeline {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	identity := &identityTransport{next: transport, config: cfg}
+	return &Pipeline{config: cfg, client: &http.Client{Transport: identity}, identity: identity, console: newConsole()}
+}
+
+// wrapTransport adds middleware such as caching, recording or authentication
+// around the run's transport. The middleware added last sees each request
+// first, after the User-Agent and request ID are set.
+func (p *Pipeline) wrapTransport(middleware func(next http.RoundTripper) http.RoundTripper) {
+	p.identity.next = middleware(p.identity.next)
+}
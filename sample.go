@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseSampleSpec parses a -sample spec such as "3x60s": the number of
+// excerpts and the length of each.
+func parseSampleSpec(spec string) (int, float64, error) {
+	count, length, ok := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), "x")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 1 {
+		return 0, 0, fmt.Errorf("invalid -sample %q (use COUNTxLENGTH, e.g. 3x60s)", spec)
+	}
+	d, err := time.ParseDuration(length)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("invalid -sample %q (use COUNTxLENGTH, e.g. 3x60s)", spec)
+	}
+	return n, d.Seconds(), nil
+}
+
+// sampleStarts spaces count excerpts of length seconds evenly over duration
+// seconds, each centred in its share of the audio.
+func sampleStarts(duration float64, count int, length float64) []float64 {
+	starts := make([]float64, count)
+	for i := range starts {
+		center := duration * (float64(i) + 0.5) / float64(count)
+		starts[i] = min(max(center-length/2, 0), max(duration-length, 0))
+	}
+	return starts
+}
+
+// runSample transcribes and diarizes a few short excerpts of the audio and
+// prints them, so that the language, vocabulary hints, glossary and speaker
+// names can be checked before paying for the whole episode. Nothing is cached
+// or written.
+func (p *Pipeline) runSample(be backend, backendKey, apiKey, audioPath, spec string, numSpeakers int, llmDiarize bool, normalize map[string]bool, glossary []glossaryTerm) error {
+	count, length, err := parseSampleSpec(spec)
+	if err != nil {
+		return err
+	}
+	duration, err := probeDuration(audioPath)
+	if err != nil {
+		return fmt.Errorf("failed to measure the audio: %v", err)
+	}
+	var usage TokenUsage
+	for i, start := range sampleStarts(duration, count, length) {
+		end := min(start+length, duration)
+		fmt.Printf("\n=== Sample %d of %d: %s to %s ===\n", i+1, count, formatTimestamp(start, ".")[:8], formatTimestamp(end, ".")[:8])
+		t, err := p.transcribeChunk(context.Background(), be, backendKey, audioPath, start, end)
+		if err != nil {
+			return fmt.Errorf("failed to transcribe sample %d: %v", i+1, err)
+		}
+		if t.Language != "" {
+			fmt.Printf("Language: %s\n", t.Language)
+		}
+		turns := t.Segments
+		if llmDiarize && !t.diarized() {
+			diarized, u, err := p.diarizeInParts(context.Background(), apiKey, t, numSpeakers)
+			if err != nil {
+				return fmt.Errorf("failed to diarize sample %d: %v", i+1, err)
+			}
+			usage.Add(u)
+			turns = alignTurns(t.Segments, diarized)
+		}
+		sample := &Transcript{Segments: turns}
+		normalizeTranscript(sample, normalize)
+		applyGlossary(sample, glossary)
+		for _, s := range sample.Segments {
+			ts := formatTimestamp(s.Start, ".")[:8]
+			if s.Speaker != "" {
+				fmt.Printf("[%s] %s: %s\n", ts, s.Speaker, s.displayText())
+			} else {
+				fmt.Printf("[%s] %s\n", ts, s.displayText())
+			}
+		}
+	}
+	fmt.Printf("\nSampled %d of %s; run without -sample to process the whole episode\n", count, formatTimestamp(duration, ".")[:8])
+	if usage.TotalTokens > 0 {
+		fmt.Printf("Diarizing the samples used %d tokens\n", usage.TotalTokens)
+	}
+	return nil
+}
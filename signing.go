@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureExt is appended to the name of a signed file for its signature.
+const signatureExt = ".minisig"
+
+// signatureAlgorithm is minisign's identifier of Ed25519 signatures over the
+// file itself, as opposed to over its BLAKE2b hash.
+var signatureAlgorithm = []byte("Ed")
+
+// signingKey is the Ed25519 key outputs are signed with, and the ID that
+// its signatures name it by.
+type signingKey struct {
+	private ed25519.PrivateKey
+	id      [8]byte
+}
+
+// loadSigningKey reads an Ed25519 private key in PEM, as written by
+// openssl genpkey -algorithm ed25519.
+func loadSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s isn't a PEM key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't an Ed25519 key", path)
+	}
+	return &signingKey{private: private, id: keyID(private.Public().(ed25519.PublicKey))}, nil
+}
+
+// keyID derives the key ID of a PEM key, which unlike a minisign key has
+// none of its own, from its public key.
+func keyID(public ed25519.PublicKey) [8]byte {
+	var id [8]byte
+	sum := sha256.Sum256(public)
+	copy(id[:], sum[:])
+	return id
+}
+
+// sign writes the signature of the file at path next to it, in minisign's
+// format. The trusted comment, which is signed too, names the file, so that
+// a signature can't be passed off as another file's.
+func (k *signingKey) sign(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s for signing: %v", path, err)
+	}
+	sig := ed25519.Sign(k.private, data)
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\tsigner:podcast-transcription %s", time.Now().Unix(), filepath.Base(path), version)
+	global := ed25519.Sign(k.private, append(append([]byte(nil), sig...), trusted...))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "untrusted comment: signature from podcast-transcription key %s\n", strings.ToUpper(hex.EncodeToString(k.id[:])))
+	b.WriteString(base64.StdEncoding.EncodeToString(bytes.Join([][]byte{signatureAlgorithm, k.id[:], sig}, nil)) + "\n")
+	b.WriteString("trusted comment: " + trusted + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(global) + "\n")
+	out := path + signatureExt
+	if err := writeFileAtomic(out, []byte(b.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write signature: %v", err)
+	}
+	return out, nil
+}
+
+// verifyKey is a public key signatures are checked against.
+type verifyKey struct {
+	public ed25519.PublicKey
+	id     [8]byte
+}
+
+// loadVerifyKey reads a public key in PEM, as written by openssl pkey
+// -pubout, or a minisign public key file.
+func loadVerifyKey(path string) (*verifyKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %v", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s isn't an Ed25519 key", path)
+		}
+		return &verifyKey{public: public, id: keyID(public)}, nil
+	}
+	lines := signatureLines(data)
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("%s is neither a PEM nor a minisign public key", path)
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || !bytes.Equal(raw[:2], signatureAlgorithm) {
+		return nil, fmt.Errorf("%s is neither a PEM nor a minisign public key", path)
+	}
+	k := &verifyKey{public: ed25519.PublicKey(raw[10:])}
+	copy(k.id[:], raw[2:10])
+	return k, nil
+}
+
+// signatureLines returns the lines of a minisign file.
+func signatureLines(data []byte) []string {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		lines = append(lines, strings.TrimRight(sc.Text(), "\r"))
+	}
+	return lines
+}
+
+// verify checks the signature of the file at path, and returns its trusted
+// comment.
+func (k *verifyKey) verify(path string) (string, error) {
+	sigData, err := os.ReadFile(path + signatureExt)
+	if err != nil {
+		return "", fmt.Errorf("no signature: %v", err)
+	}
+	lines := signatureLines(sigData)
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", fmt.Errorf("malformed signature file")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed signature")
+	}
+	if !bytes.Equal(raw[:2], signatureAlgorithm) {
+		return "", fmt.Errorf("unsupported signature algorithm %q; only Ed25519 signatures of the whole file are checked", raw[:2])
+	}
+	if !bytes.Equal(raw[2:10], k.id[:]) {
+		return "", fmt.Errorf("signed with key %s, not this one", strings.ToUpper(hex.EncodeToString(raw[2:10])))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sig := raw[10:]
+	if !ed25519.Verify(k.public, data, sig) {
+		return "", fmt.Errorf("signature doesn't match: the file was modified or signed by another key")
+	}
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(k.public, append(append([]byte(nil), sig...), trusted...), global) {
+		return "", fmt.Errorf("the trusted comment was modified")
+	}
+	for _, field := range strings.Split(trusted, "\t") {
+		if name, ok := strings.CutPrefix(field, "file:"); ok && name != filepath.Base(path) {
+			return "", fmt.Errorf("the signature is of %s", name)
+		}
+	}
+	return trusted, nil
+}
+
+// signOutputs signs every output of a run, the manifest among them, and
+// returns the signatures written.
+func signOutputs(k *signingKey, outputs []string) ([]string, error) {
+	var sigs []string
+	for _, path := range outputs {
+		sig, err := k.sign(path)
+		if err != nil {
+			return sigs, err
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// runVerify implements the verify command.
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyPath := flags.String("key", "", "Public key of the -sign-key the outputs were signed with, in PEM or minisign format")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription verify -key public-key <file or directory>...")
+		fmt.Fprintln(flags.Output(), "A directory has every file with a "+signatureExt+" signature under it checked")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" {
+		return fmt.Errorf("-key is required")
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no files given")
+	}
+	key, err := loadVerifyKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, strings.TrimSuffix(arg, signatureExt))
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.HasSuffix(path, signatureExt) {
+				files = append(files, strings.TrimSuffix(path, signatureExt))
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", arg, err)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no signatures found")
+	}
+	failed := 0
+	for _, path := range files {
+		trusted, err := key.verify(path)
+		if err != nil {
+			fmt.Printf("FAIL  %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		signed := ""
+		for _, field := range strings.Split(trusted, "\t") {
+			if ts, ok := strings.CutPrefix(field, "timestamp:"); ok {
+				if sec, err := strconv.ParseInt(ts, 10, 64); err == nil {
+					signed = ", signed " + time.Unix(sec, 0).Local().Format("2006-01-02 15:04")
+				}
+			}
+		}
+		fmt.Printf("OK    %s%s\n", path, signed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed verification", failed, len(files))
+	}
+	return nil
+}
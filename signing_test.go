@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestKeys writes a new Ed25519 key pair as PEM files, and the public
+// key also as a minisign public key file, returning their paths.
+func writeTestKeys(t *testing.T, dir string) (private, public, minisign string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, public, minisign = filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub.pem"), filepath.Join(dir, "key.pub")
+	id := keyID(pub)
+	raw := append(append(append([]byte{}, signatureAlgorithm...), id[:]...), pub...)
+	for path, data := range map[string][]byte{
+		private:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}),
+		public:   pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}),
+		minisign: []byte("untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"),
+	} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return private, public, minisign
+}
+
+func TestSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	privPath, pubPath, minisignPath := writeTestKeys(t, dir)
+	_, otherPub, _ := writeTestKeys(t, t.TempDir())
+	signer, err := loadSigningKey(privPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// tamper changes the signed file or its signature before it's checked
+		tamper func(path string)
+		key    string
+		// want is part of the error, or empty for a good signature
+		want string
+	}{
+		{"PEM key", nil, pubPath, ""},
+		{"minisign key", nil, minisignPath, ""},
+		{"other key", nil, otherPub, "not this one"},
+		{"file modified", func(path string) { os.WriteFile(path, []byte("changed"), 0644) }, pubPath, "doesn't match"},
+		{"trusted comment modified", func(path string) {
+			sig, _ := os.ReadFile(path + signatureExt)
+			os.WriteFile(path+signatureExt, []byte(strings.Replace(string(sig), "timestamp:", "timestamp:1", 1)), 0644)
+		}, pubPath, "trusted comment was modified"},
+		{"signature of another file", func(path string) {
+			other := filepath.Join(filepath.Dir(path), "other.txt")
+			os.WriteFile(other, []byte("other"), 0644)
+			signer.sign(other)
+			os.Rename(other, path)
+			os.Rename(other+signatureExt, path+signatureExt)
+		}, pubPath, "the signature is of other.txt"},
+		{"no signature", func(path string) { os.Remove(path + signatureExt) }, pubPath, "no signature"},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, "out"+string(rune('a'+i))+".txt")
+		if err := os.WriteFile(path, []byte("transcript"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sigs, err := signOutputs(signer, []string{path})
+		if err != nil || len(sigs) != 1 || sigs[0] != path+signatureExt {
+			t.Fatalf("%s: signOutputs = %v, %v", tt.name, sigs, err)
+		}
+		if tt.tamper != nil {
+			tt.tamper(path)
+		}
+		k, err := loadVerifyKey(tt.key)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		trusted, err := k.verify(path)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want == "" && !strings.Contains(trusted, "file:"+filepath.Base(path)):
+			t.Errorf("%s: trusted comment %q doesn't name the file", tt.name, trusted)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
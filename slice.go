@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseClock parses a position in the audio given as HH:MM:SS, MM:SS, plain
+// seconds, or a Go duration such as "12m30s". Seconds may have a fraction.
+func parseClock(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil && strings.ContainsAny(s, "hms") {
+		return d.Seconds(), nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q (use HH:MM:SS, MM:SS or seconds)", s)
+	}
+	seconds := 0.0
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || (i > 0 && v >= 60) || (i < len(parts)-1 && v != float64(int(v))) {
+			return 0, fmt.Errorf("invalid time %q (use HH:MM:SS, MM:SS or seconds)", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// sliceAudio cuts the part of the audio between the -from and -to positions
+// (either may be empty) into a temporary file, so that only that part is
+// uploaded. It returns the file, the position it starts at, and a cleanup
+// function.
+func (p *Pipeline) sliceAudio(ctx context.Context, path, from, to string) (string, float64, func(), error) {
+	var start, end float64
+	var err error
+	if from != "" {
+		if start, err = parseClock(from); err != nil {
+			return "", 0, nil, fmt.Errorf("invalid -from: %v", err)
+		}
+	}
+	if to != "" {
+		if end, err = parseClock(to); err != nil {
+			return "", 0, nil, fmt.Errorf("invalid -to: %v", err)
+		}
+		if end <= start {
+			return "", 0, nil, fmt.Errorf("-to %s is not after -from %s", to, from)
+		}
+	}
+	if d, err := probeDuration(path); err == nil && start >= d {
+		return "", 0, nil, fmt.Errorf("-from %s is past the end of the audio (%s)", from, formatTimestamp(d, ".")[:8])
+	}
+
+	out, cleanup, err := p.cutAudio(ctx, path, start, end)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("failed to cut audio: %v", err)
+	}
+	span := formatTimestamp(start, ".")[:8] + " to "
+	if end > 0 {
+		span += formatTimestamp(end, ".")[:8]
+	} else {
+		span += "the end"
+	}
+	p.console.progressf("Processing only %s of the audio\n", span)
+	return out, start, cleanup, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// snippetIndexFile is the index of the snippets, written next to them.
+const snippetIndexFile = "snippets.json"
+
+// snippetIndex is the index of an episode's snippets.
+type snippetIndex struct {
+	Episode  string    `json:"episode,omitempty"`
+	Date     string    `json:"date,omitempty"`
+	Snippets []snippet `json:"snippets"`
+}
+
+// snippet is one speaker turn cut out of the audio, as listed in the index.
+type snippet struct {
+	File      string  `json:"file"`
+	Speaker   string  `json:"speaker"`
+	Start     float64 `json:"start"`
+	End       float64 `json:"end"`
+	StartTime string  `json:"start_time"`
+	Text      string  `json:"text"`
+}
+
+// cutSnippets cuts every speaker turn of t out of the audio at path into dir
+// with ffmpeg, named by the turn's start in the episode (offset seconds in)
+// and its speaker, e.g. 00-12-34.500_jane-doe.mp3, and writes their index.
+// The audio is re-encoded so that the cuts are exact. It returns how many
+// snippets were cut.
+func (p *Pipeline) cutSnippets(ctx context.Context, path, dir string, t *Transcript, offset float64) (int, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return 0, fmt.Errorf("ffmpeg, needed to cut snippets, is not installed")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create snippet directory: %v", err)
+	}
+	snippets := []snippet{}
+	for _, s := range t.Segments {
+		if s.Kind == eventKind || s.End <= s.Start {
+			continue
+		}
+		start := formatTimestamp(s.Start+offset, ".")
+		name := strings.ReplaceAll(start, ":", "-") + "_" + slugify(firstNonEmpty(s.Speaker, "unknown")) + filepath.Ext(path)
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y",
+			"-ss", strconv.FormatFloat(s.Start, 'f', 3, 64), "-to", strconv.FormatFloat(s.End, 'f', 3, 64),
+			"-i", path, "-vn", filepath.Join(dir, name))
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return len(snippets), fmt.Errorf("ffmpeg failed cutting %s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+		snippets = append(snippets, snippet{
+			File:      name,
+			Speaker:   s.Speaker,
+			Start:     s.Start + offset,
+			End:       s.End + offset,
+			StartTime: start,
+			Text:      s.Text,
+		})
+	}
+	index := snippetIndex{Episode: t.Title, Date: t.Date, Snippets: snippets}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err == nil {
+		data, err = sealStored(p.config.EncryptionKey, append(data, '\n'))
+	}
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(dir, snippetIndexFile), data, 0644)
+	}
+	if err != nil {
+		return len(snippets), fmt.Errorf("failed to write snippet index: %v", err)
+	}
+	return len(snippets), nil
+}
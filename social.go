@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Platform limits, in characters.
+const (
+	xPostLimit        = 280
+	linkedInLimit     = 3000
+	youTubeLimit      = 5000
+	youTubeMinChapter = 10.0
+	// youTubeMinChapters is the fewest chapters YouTube turns into a chapter
+	// list; with fewer the timestamps are left out.
+	youTubeMinChapters = 3
+	// xURLLength is what X counts any link as, however long.
+	xURLLength = 23
+)
+
+// socialResponseFormat is the JSON schema of the social pack reply.
+var socialResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "social_pack",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"thread": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Posts of an X thread, unnumbered, each under 270 characters",
+				},
+				"linkedin":            map[string]any{"type": "string", "description": "A LinkedIn post of 150 to 300 words"},
+				"youtube_description": map[string]any{"type": "string", "description": "A YouTube description of two or three paragraphs, without the chapter list"},
+				"chapters": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":  map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn the chapter starts at, copied from the transcript"},
+							"title": map[string]any{"type": "string", "description": "Chapter title of a few words"},
+						},
+						"required":             []string{"time", "title"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"thread", "linkedin", "youtube_description", "chapters"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// socialPrompt asks for the posts of a social pack.
+const socialPrompt = `Write social media posts promoting the following podcast episode:
+- an X (Twitter) thread of 4 to 8 posts: a hook, the most interesting points or quotes, and a call to listen. Don't number the posts
+- a LinkedIn post of 150 to 300 words in a professional tone, with a few short paragraphs
+- a YouTube video description of two or three paragraphs
+- chapters for YouTube, 5 to 12 of them, each starting at the timestamp of a turn in the transcript, copied exactly, the first at 00:00:00
+Be specific and concrete; don't invent anything that isn't in the transcript.
+%s
+Transcript:
+%s`
+
+// socialPack is ready-to-post promotional content for one episode.
+type socialPack struct {
+	Thread   []string `json:"thread"`
+	LinkedIn string   `json:"linkedin"`
+	YouTube  string   `json:"youtube_description"`
+	Chapters []struct {
+		Time  string `json:"time"`
+		Title string `json:"title"`
+	} `json:"chapters"`
+}
+
+// draftSocialPack asks the summary model for the social pack of t.
+func (p *Pipeline) draftSocialPack(ctx context.Context, apiKey string, t *Transcript) (*socialPack, TokenUsage, error) {
+	var episode string
+	if t.Title != "" {
+		episode += "\nEpisode title: " + t.Title + "\n"
+	}
+	if t.Description != "" {
+		episode += "Episode description: " + t.Description + "\n"
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(socialPrompt, episode, fitContext(formatTimedTurns(t.Segments), p.config.SummaryModel))}},
+		"temperature":     p.config.Temperature,
+		"response_format": socialResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to draft social posts: %v", err)
+	}
+	var pack socialPack
+	if err := json.Unmarshal([]byte(content), &pack); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode social posts: %v", err)
+	}
+	return &pack, usage, nil
+}
+
+var urlPattern = regexp.MustCompile(`https?://\S+`)
+
+// xLength is the length of a post as X counts it, with every link as
+// xURLLength characters.
+func xLength(s string) int {
+	n := utf8.RuneCountInString(urlPattern.ReplaceAllString(s, ""))
+	return n + xURLLength*len(urlPattern.FindAllString(s, -1))
+}
+
+// threadPosts returns the thread with every post within X's limit after a
+// " n/m" counter is added, splitting long posts between sentences or words.
+func (pack *socialPack) threadPosts() []string {
+	const counter = len(" 99/99")
+	var posts []string
+	for _, post := range pack.Thread {
+		if post = strings.TrimSpace(post); post != "" {
+			posts = append(posts, splitPost(post, xPostLimit-counter)...)
+		}
+	}
+	for i := range posts {
+		posts[i] = fmt.Sprintf("%s %d/%d", posts[i], i+1, len(posts))
+	}
+	return posts
+}
+
+// splitPost cuts text into pieces of at most limit as X counts them, at
+// sentence ends where possible, otherwise between words.
+func splitPost(text string, limit int) []string {
+	var pieces []string
+	for xLength(text) > limit {
+		words := strings.Fields(text)
+		cut, sentenceCut := 0, 0
+		for i := 1; i <= len(words); i++ {
+			if xLength(strings.Join(words[:i], " ")) > limit {
+				break
+			}
+			cut = i
+			if strings.ContainsAny(words[i-1][len(words[i-1])-1:], ".!?") {
+				sentenceCut = i
+			}
+		}
+		if sentenceCut > 0 {
+			cut = sentenceCut
+		}
+		if cut == 0 {
+			// A single word longer than a post
+			runes := []rune(text)
+			pieces = append(pieces, string(runes[:limit]))
+			text = string(runes[limit:])
+			continue
+		}
+		pieces = append(pieces, strings.Join(words[:cut], " "))
+		text = strings.Join(words[cut:], " ")
+	}
+	return append(pieces, text)
+}
+
+// fitText shortens text to limit characters, ending at the last paragraph or
+// sentence break that fits.
+func fitText(text string, limit int) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	cut := string(runes[:limit-1])
+	if i := strings.LastIndex(cut, "\n\n"); i > len(cut)/2 {
+		return strings.TrimSpace(cut[:i])
+	}
+	if i := strings.LastIndexAny(cut, ".!?"); i > len(cut)/2 {
+		return cut[:i+1]
+	}
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// youTubeChapter is one line of a YouTube chapter list.
+type youTubeChapter struct {
+	start float64
+	title string
+}
+
+// youTubeChapters returns the chapter list for t: the provider's chapters when
+// it found any, or else the drafted ones, snapped to turn starts. YouTube needs
+// the first at 0:00 and each at least youTubeMinChapter seconds long, and
+// ignores lists shorter than youTubeMinChapters.
+func (pack *socialPack) youTubeChapters(t *Transcript) []youTubeChapter {
+	var chapters []youTubeChapter
+	if len(t.Chapters) > 0 {
+		for _, c := range t.Chapters {
+			chapters = append(chapters, youTubeChapter{c.Start, c.Headline})
+		}
+	} else {
+		for _, c := range pack.Chapters {
+			at, err := parseClock(c.Time)
+			if err != nil || (t.Duration > 0 && at >= t.Duration) {
+				continue
+			}
+			chapters = append(chapters, youTubeChapter{turnStart(t.Segments, at), strings.TrimSpace(c.Title)})
+		}
+	}
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].start < chapters[j].start })
+	var kept []youTubeChapter
+	for _, c := range chapters {
+		if c.title == "" {
+			continue
+		}
+		if len(kept) == 0 {
+			c.start = 0
+		} else if c.start-kept[len(kept)-1].start < youTubeMinChapter {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	if len(kept) > 0 && t.Duration > 0 && t.Duration-kept[len(kept)-1].start < youTubeMinChapter {
+		kept = kept[:len(kept)-1]
+	}
+	if len(kept) < youTubeMinChapters {
+		return nil
+	}
+	return kept
+}
+
+// youTubeTime formats seconds as YouTube writes chapter times: M:SS, or
+// H:MM:SS from an hour on.
+func youTubeTime(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// youTubeDescription returns the description followed by the chapter list,
+// within YouTube's limit; the description is shortened to fit the chapters.
+func (pack *socialPack) youTubeDescription(t *Transcript) string {
+	var list strings.Builder
+	if chapters := pack.youTubeChapters(t); len(chapters) > 0 {
+		list.WriteString("\n\nChapters:\n")
+		for _, c := range chapters {
+			fmt.Fprintf(&list, "%s %s\n", youTubeTime(c.start), c.title)
+		}
+	}
+	chapters := list.String()
+	return fitText(pack.YouTube, youTubeLimit-utf8.RuneCountInString(chapters)) + strings.TrimRight(chapters, "\n") + "\n"
+}
+
+// render returns the X thread, the LinkedIn post and the YouTube description
+// as written to their files.
+func (pack *socialPack) render(t *Transcript) (thread, linkedIn, youTube string) {
+	thread = strings.Join(pack.threadPosts(), "\n\n---\n\n") + "\n"
+	linkedIn = fitText(pack.LinkedIn, linkedInLimit) + "\n"
+	return thread, linkedIn, pack.youTubeDescription(t)
+}
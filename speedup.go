@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxSpeedup is the fastest -speedup, beyond which transcription of fast
+// talkers suffers.
+const maxSpeedup = 2.0
+
+// speedUpAudio writes a copy of the audio played factor times as fast,
+// without changing its pitch, so that less audio is uploaded and billed. The
+// caller removes the copy with the returned cleanup function.
+func (p *Pipeline) speedUpAudio(ctx context.Context, path string, factor float64) (string, func(), error) {
+	dir, err := p.makeTempDir("speedup")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out := filepath.Join(dir, "speedup"+filepath.Ext(path))
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y", "-i", path, "-vn", "-af", "atempo="+strconv.FormatFloat(factor, 'f', 3, 64), out)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, cleanup, nil
+}
+
+// slowDown moves the times of a transcript of audio sped up factor times
+// back onto the audio's own timeline.
+func (t *Transcript) slowDown(factor float64) {
+	t.mapTimes(func(x float64) float64 { return x * factor })
+	t.Duration *= factor
+}
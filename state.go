@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is the local state store shared by all runs on this machine.
+type State struct {
+	// Spend maps a month ("2006-01") to the estimated USD spent in it.
+	Spend map[string]float64 `json:"spend"`
+	// Archive maps the audioKey of every processed episode to where its
+	// outputs are.
+	Archive map[string]archivedEpisode `json:"archive,omitempty"`
+	// Jobs maps job IDs to the runs started on this machine and how far they
+	// got, for the status command.
+	Jobs map[string]jobRecord `json:"jobs,omitempty"`
+	// Batches maps a hash of each chat request submitted with -batch to its
+	// batch, until the result is collected.
+	Batches map[string]batchRecord `json:"batches,omitempty"`
+}
+
+// stateStore loads and saves State at a fixed path. Every update re-reads the file
+// so that sequential runs see each other's changes.
+type stateStore struct {
+	mu   sync.Mutex
+	path string
+	// key encrypts the state at rest, or is nil to write it in the clear.
+	key []byte
+}
+
+// defaultStatePath returns the state file location used when -state isn't given.
+func defaultStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "podcast-transcription", "state.json")
+}
+
+func openStateStore(path string, key []byte) *stateStore {
+	return &stateStore{path: path, key: key}
+}
+
+// load reads the state; a missing file yields an empty state.
+func (s *stateStore) load() (*State, error) {
+	st := &State{}
+	data, err := readStored(s.key, s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		st.init()
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse state %s: %v", s.path, err)
+	}
+	st.init()
+	return st, nil
+}
+
+func (st *State) init() {
+	if st.Spend == nil {
+		st.Spend = map[string]float64{}
+	}
+	if st.Archive == nil {
+		st.Archive = map[string]archivedEpisode{}
+	}
+	if st.Jobs == nil {
+		st.Jobs = map[string]jobRecord{}
+	}
+	if st.Batches == nil {
+		st.Batches = map[string]batchRecord{}
+	}
+}
+
+// update applies fn to the current state and saves the result by writing a
+// temporary file and renaming it into place. The whole update holds the
+// state's lock, so runs and commands updating it at once don't lose each
+// other's jobs and spend.
+func (s *stateStore) update(fn func(*State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+	fn(st)
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+	data, err = sealStored(s.key, append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	return nil
+}
+
+// stateLockTimeout is how long an update waits for another to finish with
+// the state.
+const stateLockTimeout = time.Minute
+
+// lock takes the lock file beside the state, waiting for another process
+// holding it. The state file itself can't be locked, as every save renames a
+// new one into place.
+func (s *stateStore) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %v", err)
+	}
+	path := s.path + ".lock"
+	deadline := time.Now().Add(stateLockTimeout)
+	for {
+		f, ok, err := tryLockFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock state: %v", err)
+		}
+		if ok {
+			f.Truncate(0)
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return func() { unlockFile(f, path) }, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("state %s is still locked after %s by another run (see %s)", s.path, stateLockTimeout, path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// monthKey returns the Spend key for t.
+func monthKey(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// addSpend records usd against the current month.
+func (s *stateStore) addSpend(usd float64) error {
+	if usd <= 0 {
+		return nil
+	}
+	return s.update(func(st *State) {
+		st.Spend[monthKey(time.Now())] += usd
+	})
+}
+
+// checkBudget refuses to start when this month's estimated spend has already
+// reached budget. A budget of zero or less disables the check.
+func (s *stateStore) checkBudget(budget float64) error {
+	if budget <= 0 {
+		return nil
+	}
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+	spent := st.Spend[monthKey(time.Now())]
+	if spent >= budget {
+		return fmt.Errorf("monthly budget reached: estimated $%.2f spent of $%.2f this month (use -override-budget to run anyway)", spent, budget)
+	}
+	return nil
+}
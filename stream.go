@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// streamHeader starts a -stream file, as it does the txt format.
+const streamHeader = "=== Diarized Transcript ===\n"
+
+// transcriptStream appends the turns of a chunked run to a file as each
+// chunk is diarized, for -stream, so that the start of a long episode can be
+// read while the rest is still being transcribed. Chunks are diarized in
+// order, so the file is too.
+type transcriptStream struct {
+	path string
+	opts renderOptions
+	// at maps a time of the audio transcribed onto the episode, undoing
+	// -from and -speedup.
+	at func(float64) float64
+}
+
+// openTranscriptStream starts the file at path over with the header of the
+// txt format.
+func openTranscriptStream(path string, opts renderOptions, at func(float64) float64) (*transcriptStream, error) {
+	if err := os.WriteFile(path, []byte(streamHeader), 0644); err != nil {
+		return nil, fmt.Errorf("failed to start -stream file: %v", err)
+	}
+	opts.timed = true
+	return &transcriptStream{path: path, opts: opts, at: at}, nil
+}
+
+// write appends turns to the file, timed like the txt format with
+// -timestamps. The file is closed after every chunk, so that what's in it
+// can be read at once.
+func (s *transcriptStream) write(turns []Segment) error {
+	if s == nil || len(turns) == 0 {
+		return nil
+	}
+	t := &Transcript{Segments: make([]Segment, len(turns))}
+	for i, turn := range turns {
+		turn.Words = nil
+		t.Segments[i] = turn
+	}
+	t.mapTimes(s.at)
+	text := strings.TrimPrefix(renderText(t, s.opts), streamHeader)
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write -stream file: %v", err)
+	}
+	if info, err := f.Stat(); err == nil && info.Size() > int64(len(streamHeader)) {
+		// A blank line between turns, as between those of one chunk
+		text = "\n" + text
+	}
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write -stream file: %v", err)
+	}
+	return f.Close()
+}
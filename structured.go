@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// diarizationResponseFormat asks the chat completions API for a strict JSON
+// object holding the speaker turns instead of free-form prose.
+var diarizationResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "diarized_transcript",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"turns": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"speaker": map[string]any{"type": "string", "description": `Speaker label, e.g. "Speaker 1"`},
+							"text":    map[string]any{"type": "string", "description": "Words spoken in this turn, verbatim"},
+						},
+						"required":             []string{"speaker", "text"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"turns"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// structuredInstruction is appended to the diarization prompt in JSON mode.
+const structuredInstruction = `
+
+Respond with a JSON object whose "turns" array lists every speaker turn in order. Each turn has a "speaker" label and the "text" spoken, copied verbatim from the transcript.`
+
+// parseStructuredTurns decodes and validates the JSON-mode diarization reply.
+func parseStructuredTurns(content string) ([]Segment, error) {
+	var res struct {
+		Turns []struct {
+			Speaker string `json:"speaker"`
+			Text    string `json:"text"`
+		} `json:"turns"`
+	}
+	if err := json.Unmarshal([]byte(content), &res); err != nil {
+		return nil, fmt.Errorf("failed to decode structured diarization: %v", err)
+	}
+	if len(res.Turns) == 0 {
+		return nil, fmt.Errorf("structured diarization contained no turns")
+	}
+	turns := make([]Segment, 0, len(res.Turns))
+	for i, t := range res.Turns {
+		speaker, text := strings.TrimSpace(t.Speaker), strings.TrimSpace(t.Text)
+		if speaker == "" {
+			return nil, fmt.Errorf("structured diarization turn %d has no speaker", i+1)
+		}
+		if text == "" {
+			continue
+		}
+		turns = append(turns, Segment{Speaker: speaker, Text: text})
+	}
+	return turns, nil
+}
+
+// formatTurns renders turns as "Speaker: text" lines, used to give the model the
+// end of the previous part as context.
+func formatTurns(turns []Segment) string {
+	lines := make([]string, len(turns))
+	for i, t := range turns {
+		lines[i] = t.Speaker + ": " + t.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatTimedTurns renders turns one per line prefixed with their start time,
+// for prompts that ask the model to point at moments in the episode.
+func formatTimedTurns(turns []Segment) string {
+	lines := make([]string, 0, len(turns))
+	for _, t := range turns {
+		if t.Kind == eventKind {
+			continue
+		}
+		line := "[" + formatTimestamp(t.Start, ".")[:8] + "] "
+		if t.Speaker != "" {
+			line += t.Speaker + ": "
+		}
+		lines = append(lines, line+t.Text)
+	}
+	return strings.Join(lines, "\n")
+}
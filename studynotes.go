@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// studyNotesResponseFormat is the JSON schema of the study notes reply.
+var studyNotesResponseFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name":   "study_notes",
+		"strict": true,
+		"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"summary": map[string]any{"type": "string", "description": "One paragraph on what the lecture covers"},
+				"concepts": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":        map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn where the concept is explained, copied from the transcript"},
+							"concept":     map[string]any{"type": "string", "description": "The concept, in a few words"},
+							"explanation": map[string]any{"type": "string", "description": "The concept as the lecture explains it, in two or three sentences"},
+						},
+						"required":             []string{"time", "concept", "explanation"},
+						"additionalProperties": false,
+					},
+				},
+				"definitions": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":       map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn where the term is defined, copied from the transcript"},
+							"term":       map[string]any{"type": "string", "description": "The term defined"},
+							"definition": map[string]any{"type": "string", "description": "Its definition, as close to the lecture's wording as possible"},
+						},
+						"required":             []string{"time", "term", "definition"},
+						"additionalProperties": false,
+					},
+				},
+				"quiz": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"time":     map[string]any{"type": "string", "description": "HH:MM:SS timestamp of the turn that answers the question, copied from the transcript"},
+							"question": map[string]any{"type": "string", "description": "A question testing understanding of the lecture"},
+							"answer":   map[string]any{"type": "string", "description": "Its answer, in a sentence or two"},
+						},
+						"required":             []string{"time", "question", "answer"},
+						"additionalProperties": false,
+					},
+				},
+			},
+			"required":             []string{"summary", "concepts", "definitions", "quiz"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+// studyNotesPrompt asks for the study notes of a lecture.
+const studyNotesPrompt = `Write study notes of the following recorded lecture for a student revising it: a one-paragraph summary, the key concepts in the order they are taught with a short explanation of each, the terms the lecturer defines with their definitions, and five to ten quiz questions with answers that test understanding rather than recall of details. Give each the timestamp of the turn where it is covered, copied exactly. Only use what the lecture says; don't add material from elsewhere.
+%s
+Transcript:
+%s`
+
+// studyNotes are the study notes of a lecture, as written to the study notes
+// JSON file.
+type studyNotes struct {
+	Title       string            `json:"title,omitempty"`
+	Date        string            `json:"date,omitempty"`
+	Summary     string            `json:"summary"`
+	Concepts    []studyConcept    `json:"concepts"`
+	Definitions []studyDefinition `json:"definitions"`
+	Quiz        []quizQuestion    `json:"quiz"`
+}
+
+type studyConcept struct {
+	Time        string `json:"time,omitempty"`
+	Concept     string `json:"concept"`
+	Explanation string `json:"explanation"`
+}
+
+type studyDefinition struct {
+	Time       string `json:"time,omitempty"`
+	Term       string `json:"term"`
+	Definition string `json:"definition"`
+}
+
+type quizQuestion struct {
+	Time     string `json:"time,omitempty"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// draftStudyNotes asks the summary model for study notes of the lecture
+// recorded in t. Times are checked against the transcript and snapped to the
+// start of the turn they fall in, as with the minutes.
+func (p *Pipeline) draftStudyNotes(ctx context.Context, apiKey string, t *Transcript) (*studyNotes, TokenUsage, error) {
+	var lecture string
+	if t.Title != "" {
+		lecture += "\nLecture title: " + t.Title + "\n"
+	}
+	if t.Description != "" {
+		lecture += "Course or description: " + t.Description + "\n"
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(studyNotesPrompt, lecture, fitContext(formatTimedTurns(t.Segments), p.config.SummaryModel))}},
+		"temperature":     p.config.Temperature,
+		"response_format": studyNotesResponseFormat,
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to draft study notes: %v", err)
+	}
+	n := &studyNotes{Title: t.Title, Date: t.Date}
+	if err := json.Unmarshal([]byte(content), n); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode study notes: %v", err)
+	}
+	for i := range n.Concepts {
+		n.Concepts[i].Time = snapClock(t, n.Concepts[i].Time)
+	}
+	for i := range n.Definitions {
+		n.Definitions[i].Time = snapClock(t, n.Definitions[i].Time)
+	}
+	for i := range n.Quiz {
+		n.Quiz[i].Time = snapClock(t, n.Quiz[i].Time)
+	}
+	return n, usage, nil
+}
+
+// json renders the notes as written to the study notes JSON file.
+func (n *studyNotes) json() ([]byte, error) {
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// markdown renders the notes as a Markdown document, with the quiz's answers
+// after its questions so they can be tried first.
+func (n *studyNotes) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", firstNonEmpty(n.Title, "Study notes"))
+	if n.Date != "" {
+		fmt.Fprintf(&b, "Date: %s\n\n", n.Date)
+	}
+	if n.Summary != "" {
+		fmt.Fprintf(&b, "%s\n\n", n.Summary)
+	}
+	if len(n.Concepts) > 0 {
+		b.WriteString("## Key concepts\n\n")
+		for _, c := range n.Concepts {
+			fmt.Fprintf(&b, "### %s%s\n\n%s\n\n", c.Concept, atClock(c.Time), c.Explanation)
+		}
+	}
+	if len(n.Definitions) > 0 {
+		b.WriteString("## Definitions\n\n")
+		for _, d := range n.Definitions {
+			fmt.Fprintf(&b, "- **%s**: %s%s\n", d.Term, d.Definition, atClock(d.Time))
+		}
+		b.WriteString("\n")
+	}
+	if len(n.Quiz) > 0 {
+		b.WriteString("## Quiz\n\n")
+		for i, q := range n.Quiz {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, q.Question)
+		}
+		b.WriteString("\n### Answers\n\n")
+		for i, q := range n.Quiz {
+			fmt.Fprintf(&b, "%d. %s%s\n", i+1, q.Answer, atClock(q.Time))
+		}
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
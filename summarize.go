@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// summaryPrompt wraps the instructions of a summary preset around a diarized
+// transcript.
+const summaryPrompt = `%s Mention the speakers by name if they are named. Respond with the summary only.
+
+Transcript:
+%s`
+
+// defaultSummaryPreset is the summary written by -summarize alone.
+const defaultSummaryPreset = "description"
+
+// summaryPresets are the built-in summary styles of -summary-preset, as
+// instructions for the summary model. The configuration file can add more
+// under summary_presets.
+var summaryPresets = map[string]string{
+	"description":     "Summarize the following podcast transcript in 2-3 sentences suitable for an episode description.",
+	"one-liner":       "Summarize the following podcast transcript in a single sentence of at most 25 words, like a tagline.",
+	"paragraph":       "Summarize the following podcast transcript in one paragraph of 5-7 sentences covering the main topics in the order they come up.",
+	"outline":         "Write a detailed outline of the following podcast transcript as a nested Markdown bullet list: one top-level bullet per topic in the order they come up, with the key points, arguments and examples under each.",
+	"kid-friendly":    "Summarize the following podcast transcript in 3-4 short sentences a ten-year-old can follow: plain words, no jargon, and nothing unsuitable for children.",
+	"executive-brief": "Write an executive brief of the following podcast transcript: a one-sentence bottom line, then 3-5 bullets with the key takeaways, decisions or recommendations, and any figures or dates mentioned.",
+}
+
+// summaryInstructions returns the instructions of the named preset, looking
+// in the configuration file's presets before the built-in ones.
+func summaryInstructions(name string, custom map[string]string) (string, error) {
+	if text, ok := custom[name]; ok && strings.TrimSpace(text) != "" {
+		return strings.TrimSpace(text), nil
+	}
+	if text, ok := summaryPresets[name]; ok {
+		return text, nil
+	}
+	names := make([]string, 0, len(summaryPresets)+len(custom))
+	for n := range summaryPresets {
+		names = append(names, n)
+	}
+	for n := range custom {
+		if _, ok := summaryPresets[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unknown -summary-preset %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// summarizeTranscript asks the chat model for a summary of the diarized turns
+// in the style of the chosen preset. Very long transcripts are cut to fit the
+// model's context window.
+func (p *Pipeline) summarizeTranscript(ctx context.Context, apiKey string, t *Transcript) (string, TokenUsage, error) {
+	text := fitContext(formatTurns(t.Segments), p.config.SummaryModel)
+	instructions := p.config.SummaryInstructions
+	if instructions == "" {
+		instructions = summaryPresets[defaultSummaryPreset]
+	}
+	payload := map[string]interface{}{
+		"model":       p.config.SummaryModel,
+		"messages":    []map[string]string{{"role": "user", "content": fmt.Sprintf(summaryPrompt, instructions, text)}},
+		"temperature": p.config.Temperature,
+	}
+	summary, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return "", usage, fmt.Errorf("failed to summarize transcript: %v", err)
+	}
+	return strings.TrimSpace(summary), usage, nil
+}
+
+// fitContext cuts text to what fits in model's context window alongside the
+// instructions.
+func fitContext(text, model string) string {
+	budget := limitsFor(model).Context - promptOverheadTokens
+	if n := estimateTokens(text); n > budget {
+		text = strings.ToValidUTF8(text[:len(text)*budget/n], "")
+	}
+	return text
+}
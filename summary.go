@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// runStats counts the work a run did below the level of its stages, for the
+// end-of-run summary. Chunks may be transcribed concurrently with diarization,
+// so the counters are atomic.
+type runStats struct {
+	// chunks counts audio chunks transcribed with -chunk.
+	chunks atomic.Int64
+	// requests counts diarization requests, one per part of a long transcript.
+	requests atomic.Int64
+	// retries counts requests repeated after a failed check or an empty or
+	// suspect result.
+	retries atomic.Int64
+}
+
+// printSummary writes a table of the stages of the run recorded in m, with
+// their durations, token usage and estimated cost, followed by the work counts
+// and the output files. audioSeconds prices the transcription. A quiet console
+// gets only the output files, one per line.
+func printSummary(c *console, m *Manifest, stats *runStats, audioSeconds float64) {
+	if c.quiet {
+		for _, o := range m.Outputs {
+			fmt.Fprintln(c.out, o)
+		}
+		return
+	}
+	w := c.out
+	fmt.Fprintln(w, "\n"+c.paint(ansiBold, "Run summary:"))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Stage\tModel\tTime\tTokens\tCost")
+	var total float64
+	var tokens int
+	for _, s := range m.Stages {
+		cost, used := stageCost(s, audioSeconds, m.Parameters["batch"] == true), "-"
+		if s.Usage != nil && s.Usage.TotalTokens > 0 {
+			used = formatCount(s.Usage.TotalTokens)
+			tokens += s.Usage.TotalTokens
+		}
+		elapsed := formatElapsed(s.DurationSeconds)
+		if s.Cached {
+			elapsed = "cached"
+		}
+		total += cost
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", s.Name, s.Model, elapsed, used, formatCost(cost))
+	}
+	fmt.Fprintf(tw, "  total\t\t%s\t%s\t%s\n", formatElapsed(time.Since(m.StartedAt).Seconds()), formatCount(tokens), formatCost(total))
+	tw.Flush()
+
+	var work []string
+	if n := stats.chunks.Load(); n > 0 {
+		work = append(work, fmt.Sprintf("%d chunk(s) transcribed", n))
+	}
+	if n := stats.requests.Load(); n > 1 {
+		work = append(work, fmt.Sprintf("%d diarization requests", n))
+	}
+	if n := stats.retries.Load(); n > 0 {
+		work = append(work, fmt.Sprintf("%d retried request(s)", n))
+	}
+	if n := len(m.Warnings); n > 0 {
+		work = append(work, fmt.Sprintf("%d warning(s) in the manifest", n))
+	}
+	if len(work) > 0 {
+		fmt.Fprintf(w, "  %s\n", strings.Join(work, ", "))
+	}
+	if m.Grade != nil {
+		fmt.Fprintf(w, "  %s\n", m.Grade)
+	}
+	fmt.Fprintln(w, "  Outputs:")
+	for _, o := range m.Outputs {
+		fmt.Fprintf(w, "    %s\n", o)
+	}
+}
+
+// stageCost estimates what a stage cost: tokens at the chat model's price,
+// discounted if they were batched, or the audio length at the transcription
+// model's.
+func stageCost(s ManifestStage, audioSeconds float64, batched bool) float64 {
+	switch {
+	case s.Cached:
+		return 0
+	case s.Usage != nil && batched:
+		return chatCost(s.Model, *s.Usage) * batchDiscount
+	case s.Usage != nil:
+		return chatCost(s.Model, *s.Usage)
+	case s.Name == "transcription" || s.Name == "ensemble":
+		return audioCost(s.Model, audioSeconds)
+	}
+	return 0
+}
+
+func formatElapsed(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	if d < 10*time.Second {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+func formatCost(usd float64) string {
+	if usd == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.4f", usd)
+}
+
+// formatCount writes n with thousands separators.
+func formatCount(n int) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
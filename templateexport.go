@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templatePrefix marks a -format entry rendered with a template of the
+// user's, e.g. template:shownotes.html.tmpl.
+const templatePrefix = "template:"
+
+// lookupExporter returns the exporter of a -format entry: a registered
+// format, or a template.
+func lookupExporter(format string) (exporter, error) {
+	if path, ok := strings.CutPrefix(format, templatePrefix); ok {
+		return templateExporter(path)
+	}
+	e, ok := exporters[format]
+	if !ok {
+		return exporter{}, fmt.Errorf("unknown format %q (available: %s, template:FILE)", format, strings.Join(exporterNames(), ", "))
+	}
+	return e, nil
+}
+
+// templateExporter renders the text/template at path with the transcript as
+// its data, so that in-house formats need no code of their own. The output is
+// named after the template without .tmpl, e.g. diarized.shownotes.html.
+func templateExporter(path string) (exporter, error) {
+	if path == "" {
+		return exporter{}, fmt.Errorf("no template given in -format %s (want %sFILE)", templatePrefix, templatePrefix)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return exporter{}, fmt.Errorf("failed to read template: %v", err)
+	}
+	name := filepath.Base(path)
+	tmpl, err := template.New(name).Funcs(templateFuncs(renderOptions{})).Parse(string(src))
+	if err != nil {
+		return exporter{}, fmt.Errorf("failed to parse template: %v", err)
+	}
+	render := func(t *Transcript, opts renderOptions) ([]byte, error) {
+		var b strings.Builder
+		// The time functions follow -timestamps
+		if err := template.Must(tmpl.Clone()).Funcs(templateFuncs(opts)).Execute(&b, t); err != nil {
+			return nil, fmt.Errorf("failed to render template %s: %v", name, err)
+		}
+		return []byte(b.String()), nil
+	}
+	return exporter{ext: "." + strings.TrimSuffix(name, ".tmpl"), render: render, text: true}, nil
+}
+
+// templateFuncs are the functions available to -format templates besides the
+// built-in ones such as html, js, printf and len.
+func templateFuncs(opts renderOptions) template.FuncMap {
+	return template.FuncMap{
+		"time":     opts.timestamps.format,
+		"srttime":  func(seconds float64) string { return formatTimestamp(seconds, ",") },
+		"vtttime":  func(seconds float64) string { return formatTimestamp(seconds, ".") },
+		"speakers": func(t *Transcript) []string { return t.speakers() },
+		"label":    func(s Segment) string { return s.speakerLabel() },
+		"text":     func(s Segment) string { return s.displayText() },
+		"join":     strings.Join,
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"trim":     strings.TrimSpace,
+		"inc":      func(i int) int { return i + 1 },
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+}
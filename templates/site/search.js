@@ -0,0 +1,41 @@
+(function () {
+  var input = document.getElementById("search");
+  var results = document.getElementById("results");
+  var episodes = document.getElementById("episodes");
+  var index = null;
+
+  function load(cb) {
+    if (index) return cb();
+    fetch("search.json").then(function (r) { return r.json(); }).then(function (data) { index = data; cb(); });
+  }
+
+  function escape(s) {
+    return s.replace(/[&<>"]/g, function (c) { return { "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;" }[c]; });
+  }
+
+  function render(q) {
+    results.innerHTML = "";
+    var n = 0;
+    index.forEach(function (ep) {
+      ep.turns.forEach(function (t) {
+        if (n >= 200 || t.x.toLowerCase().indexOf(q) < 0) return;
+        n++;
+        var li = document.createElement("li");
+        var i = t.x.toLowerCase().indexOf(q);
+        var text = escape(t.x.slice(0, i)) + "<mark>" + escape(t.x.slice(i, i + q.length)) + "</mark>" + escape(t.x.slice(i + q.length));
+        li.innerHTML = '<a href="' + ep.slug + ".html#t" + t.t + '">' + escape(ep.title) + " · " + t.ts + "</a>" +
+          "<p>" + (t.s ? "<strong>" + escape(t.s) + "</strong> " : "") + text + "</p>";
+        results.appendChild(li);
+      });
+    });
+    if (n === 0) results.innerHTML = "<li>No matches.</li>";
+  }
+
+  input.addEventListener("input", function () {
+    var q = input.value.trim().toLowerCase();
+    results.hidden = episodes.hidden = false;
+    if (q.length < 2) { results.hidden = true; return; }
+    episodes.hidden = true;
+    load(function () { render(q); });
+  });
+})();
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// translationTerm is a term and how it is to be translated into each
+// language.
+type translationTerm struct {
+	Term    string
+	match   *regexp.Regexp
+	targets map[string]string
+}
+
+// translationMiss records a turn whose translation lacks the glossary's
+// translation of a term in it.
+type translationMiss struct {
+	Language    string  `json:"language"`
+	Term        string  `json:"term"`
+	Expected    string  `json:"expected"`
+	Time        float64 `json:"time"`
+	Speaker     string  `json:"speaker,omitempty"`
+	Translation string  `json:"translation"`
+}
+
+// loadTranslationGlossary reads a bilingual glossary for translations: one
+// term per line, followed by a colon and comma-separated LANG=translation
+// pairs, e.g.
+//
+//	observability: es=observabilidad, de=Observability
+//	Tom: es=Tom
+//
+// A term translated as itself stays untranslated, as for names. Blank lines
+// and lines starting with # are ignored.
+func loadTranslationGlossary(path string) ([]translationTerm, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open translation glossary: %v", err)
+	}
+	defer f.Close()
+
+	var terms []translationTerm
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		term, pairs, ok := strings.Cut(line, ":")
+		term = strings.TrimSpace(term)
+		if !ok || term == "" {
+			return nil, fmt.Errorf("translation glossary line %d: want \"term: lang=translation, ...\"", n)
+		}
+		t := translationTerm{Term: term, match: termPattern(term), targets: map[string]string{}}
+		for _, pair := range strings.Split(pairs, ",") {
+			lang, text, ok := strings.Cut(pair, "=")
+			lang, text = strings.ToLower(strings.TrimSpace(lang)), strings.TrimSpace(text)
+			if !ok || !languageCode.MatchString(lang) || text == "" {
+				return nil, fmt.Errorf("translation glossary line %d: invalid translation %q (want lang=translation)", n, strings.TrimSpace(pair))
+			}
+			t.targets[lang] = text
+		}
+		terms = append(terms, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read translation glossary: %v", err)
+	}
+	return terms, nil
+}
+
+// termPattern matches a term as whole words, ignoring case and the spacing
+// between its words.
+func termPattern(term string) *regexp.Regexp {
+	words := strings.Fields(term)
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	return regexp.MustCompile(`(?i)(^|[^\pL\pN])` + strings.Join(words, `\s+`) + `($|[^\pL\pN])`)
+}
+
+// targetFor returns the translation of the term into lang.
+func (t translationTerm) targetFor(lang string) (string, bool) {
+	for l, text := range t.targets {
+		if sameLanguage(l, lang) {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// glossaryHints lists the glossary translations into lang of the terms in the
+// given turns, for the translation prompt, or "" when none occur.
+func glossaryHints(terms []translationTerm, t *Transcript, batch []int, lang string) string {
+	var lines []string
+	seen := map[string]bool{}
+	for _, idx := range batch {
+		for _, term := range terms {
+			target, ok := term.targetFor(lang)
+			if !ok || seen[term.Term] || !term.match.MatchString(t.Segments[idx].Text) {
+				continue
+			}
+			seen[term.Term] = true
+			lines = append(lines, fmt.Sprintf("- %s → %s", term.Term, target))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n\nTranslate these terms exactly as given, adapting only grammatical endings where the language requires it:\n" + strings.Join(lines, "\n")
+}
+
+// glossaryMisses returns the turns whose translation into lang leaves out the
+// glossary's translation of a term their text contains.
+func glossaryMisses(terms []translationTerm, t *Transcript, texts map[int]string, lang string) []translationMiss {
+	indexes := make([]int, 0, len(texts))
+	for idx := range texts {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	var misses []translationMiss
+	for _, idx := range indexes {
+		s := t.Segments[idx]
+		for _, term := range terms {
+			target, ok := term.targetFor(lang)
+			if !ok || !term.match.MatchString(s.Text) {
+				continue
+			}
+			if !strings.Contains(strings.ToLower(texts[idx]), strings.ToLower(target)) {
+				misses = append(misses, translationMiss{Language: lang, Term: term.Term, Expected: target, Time: s.Start, Speaker: s.Speaker, Translation: texts[idx]})
+			}
+		}
+	}
+	return misses
+}
+
+// writeTranslationMisses writes the glossary misses of the translations as
+// JSON to path.
+func (p *Pipeline) writeTranslationMisses(path string, misses []translationMiss) error {
+	if misses == nil {
+		misses = []translationMiss{}
+	}
+	data, err := json.MarshalIndent(misses, "", "  ")
+	if err != nil {
+		return err
+	}
+	return p.writeOutput(path, append(data, '\n'))
+}
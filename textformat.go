@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark some Windows tools need to read UTF-8.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// parseLineEndings validates a -line-endings value.
+func parseLineEndings(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case "lf", "crlf":
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown line endings %q (available: lf, crlf)", s)
+}
+
+// encodeText applies the configured text layout to a rendered text format of
+// e: lines wrapped at WrapWidth if e wraps, line numbers if e is numbered, the
+// line endings, and the byte order mark.
+func (p *Pipeline) encodeText(data []byte, e exporter) []byte {
+	if e.wrap && p.config.WrapWidth > 0 {
+		data = []byte(wrapLines(string(data), p.config.WrapWidth))
+	}
+	if e.numbered && p.config.LineNumbers {
+		data = []byte(numberLines(string(data)))
+	}
+	if p.config.LineEndings == "crlf" {
+		data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	}
+	if p.config.BOM && !bytes.HasPrefix(data, utf8BOM) {
+		data = append(append([]byte{}, utf8BOM...), data...)
+	}
+	return data
+}
+
+// wrapLines breaks lines longer than width characters between words. Cue
+// timing lines are left alone, and a word longer than width gets a line of
+// its own.
+func wrapLines(text string, width int) string {
+	lines := strings.Split(text, "\n")
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if utf8.RuneCountInString(line) <= width || strings.Contains(line, "-->") {
+			b.WriteString(line)
+			continue
+		}
+		n := 0
+		for j, word := range strings.Fields(line) {
+			w := utf8.RuneCountInString(word)
+			switch {
+			case j == 0:
+			case n+1+w > width:
+				b.WriteByte('\n')
+				n = 0
+			default:
+				b.WriteByte(' ')
+				n++
+			}
+			b.WriteString(word)
+			n += w
+		}
+	}
+	return b.String()
+}
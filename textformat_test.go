@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapLines(t *testing.T) {
+	tests := []struct {
+		name, in string
+		width    int
+		want     string
+	}{
+		{"short", "a short line", 20, "a short line"},
+		{"wrapped", "one two three four five", 10, "one two\nthree four\nfive"},
+		{"long word alone", "a supercalifragilistic word", 10, "a\nsupercalifragilistic\nword"},
+		{"cue timing kept", "00:00:01,000 --> 00:00:04,000 with more text", 10, "00:00:01,000 --> 00:00:04,000 with more text"},
+		{"counts characters", "héllo wörld ünïcode", 11, "héllo wörld\nünïcode"},
+		{"lines kept", "first line here\n\nsecond", 10, "first line\nhere\n\nsecond"},
+	}
+	for _, tt := range tests {
+		if got := wrapLines(tt.in, tt.width); got != tt.want {
+			t.Errorf("%s: wrapLines = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseLineEndings(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"lf", "lf", false},
+		{"CRLF", "crlf", false},
+		{"cr", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseLineEndings(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseLineEndings(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		e    exporter
+		in   string
+		want string
+	}{
+		{"as is", Config{LineEndings: "lf"}, exporter{wrap: true}, "one\ntwo\n", "one\ntwo\n"},
+		{"crlf", Config{LineEndings: "crlf"}, exporter{}, "one\ntwo\n", "one\r\ntwo\r\n"},
+		{"crlf not doubled", Config{LineEndings: "crlf"}, exporter{}, "one\r\ntwo\n", "one\r\ntwo\r\n"},
+		{"bom", Config{LineEndings: "lf", BOM: true}, exporter{}, "text", "\ufefftext"},
+		{"wrapped", Config{LineEndings: "lf", WrapWidth: 8}, exporter{wrap: true}, "one two three", "one two\nthree"},
+		{"not a wrapping format", Config{LineEndings: "lf", WrapWidth: 8}, exporter{}, "one two three", "one two three"},
+		{"wrapped then crlf", Config{LineEndings: "crlf", WrapWidth: 8}, exporter{wrap: true}, "one two three\n", "one two\r\nthree\r\n"},
+	}
+	for _, tt := range tests {
+		p := &Pipeline{config: &tt.cfg}
+		if got := string(p.encodeText([]byte(tt.in), tt.e)); got != tt.want {
+			t.Errorf("%s: encodeText = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	p := &Pipeline{config: &Config{BOM: true}}
+	if got := p.encodeText(p.encodeText([]byte("x"), exporter{}), exporter{}); strings.Count(string(got), "\ufeff") != 1 {
+		t.Errorf("the byte order mark was added twice: %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most a throttled upload sends at once, which keeps the
+// rate smooth rather than bursty.
+const throttleChunk = 16 * 1024
+
+// parseRate parses a -max-upload-rate value in bytes per second, such as
+// "500KB", "2MB/s" or "1MiB".
+func parseRate(s string) (int64, error) {
+	n, err := parseByteSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q (e.g. 500KB or 2MB/s)", s)
+	}
+	return n, nil
+}
+
+// rateLimiter paces bytes to a rate shared by every upload of the run, so
+// concurrent requests together stay under it.
+type rateLimiter struct {
+	rate int64
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more bytes may be sent.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mu.Unlock()
+	if d := time.Until(at); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	return nil
+}
+
+// throttledTransport caps the rate request bodies are sent at.
+type throttledTransport struct {
+	next    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func newThrottledTransport(next http.RoundTripper, rate int64) *throttledTransport {
+	return &throttledTransport{next: next, limiter: &rateLimiter{rate: rate}}
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Body = &throttledBody{body: req.Body, ctx: req.Context(), limiter: t.limiter}
+	if getBody := req.GetBody; getBody != nil {
+		// Redirects and retried requests resend the body at the same rate
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return &throttledBody{body: body, ctx: req.Context(), limiter: t.limiter}, nil
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// throttledBody is a request body read no faster than its limiter allows.
+type throttledBody struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	limiter *rateLimiter
+}
+
+func (b *throttledBody) Read(buf []byte) (int, error) {
+	if len(buf) > throttleChunk {
+		buf = buf[:throttleChunk]
+	}
+	n, err := b.body.Read(buf)
+	if n > 0 {
+		if werr := b.limiter.wait(b.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	return b.body.Close()
+}
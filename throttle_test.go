@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"1048576", 1048576, false},
+		{"512MiB", 512 << 20, false},
+		{"2G", 2 << 30, false},
+		{"1k", 1024, false},
+		{"1kb", 1000, false},
+		{"1.5 MB", 1500000, false},
+		{"10b", 10, false},
+		{"0.1b", 0, true},
+		{"0.0001k", 0, true},
+		{"0", 0, true},
+		{"-5MB", 0, true},
+		{"NaN", 0, true},
+		{"inf", 0, true},
+		{"1e30GB", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"500KB", 500000, false},
+		{"2MB/s", 2000000, false},
+		{"1MiB/s", 1 << 20, false},
+		{"0.5b/s", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseRate(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Stage timeouts scale with the work instead of being fixed: transcription with
+// the audio length, chat requests with the number of tokens the model has to
+// write. -transcription-timeout and -diarization-timeout override them.
+const (
+	// transcriptionRealtimeFactor allows transcription this many times the audio
+	// duration, enough for uploads on slow links and local models on CPU.
+	transcriptionRealtimeFactor = 2
+	minTranscriptionTimeout     = 2 * time.Minute
+
+	// chatTokensPerSecond is a conservative output rate for the chat models,
+	// reasoning models included.
+	chatTokensPerSecond = 15
+	minChatTimeout      = 2 * time.Minute
+
+	// assumedBitrate estimates the duration of audio ffprobe can't measure. It is
+	// on the low side so the estimate, and the timeout, errs long.
+	assumedBitrate = 64000
+
+	// uploadBitrate is the highest bitrate uploads are expected to have, for
+	// allowing the time a -max-upload-rate upload needs.
+	uploadBitrate = 320000
+
+	// uploadBytesPerSecond is a conservative upload rate for an ordinary
+	// link, for allowing the time deliveries of the outputs need.
+	uploadBytesPerSecond = 128 * 1024
+)
+
+// audioDuration measures the audio length with ffprobe, falling back to an
+// estimate from the file size.
+func audioDuration(path string) float64 {
+	if d, err := probeDuration(path); err == nil {
+		return d
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return float64(info.Size()) * 8 / assumedBitrate
+}
+
+// transcriptionTimeout is the time allowed to transcribe seconds of audio.
+func (p *Pipeline) transcriptionTimeout(seconds float64) time.Duration {
+	if p.config.TranscriptionTimeout > 0 {
+		return p.config.TranscriptionTimeout
+	}
+	timeout := max(minTranscriptionTimeout, time.Duration(transcriptionRealtimeFactor*seconds*float64(time.Second)))
+	if rate := p.config.MaxUploadRate; rate > 0 {
+		timeout += time.Duration(seconds * uploadBitrate / 8 / float64(rate) * float64(time.Second))
+	}
+	return timeout
+}
+
+// chatTimeout is the time allowed for a chat request whose reply is about
+// outputTokens long. A -batch request may take the whole completion window.
+func (p *Pipeline) chatTimeout(outputTokens int) time.Duration {
+	if p.config.DiarizationTimeout > 0 {
+		return p.config.DiarizationTimeout
+	}
+	if p.config.Batch {
+		return batchWindow + minChatTimeout
+	}
+	return minChatTimeout + time.Duration(outputTokens)*time.Second/chatTokensPerSecond
+}
+
+// uploadTimeout is the time allowed to send size bytes: the -timeout of a
+// request, plus the bytes at a slow link's rate or -max-upload-rate if that
+// is slower.
+func (p *Pipeline) uploadTimeout(size int64) time.Duration {
+	rate := int64(uploadBytesPerSecond)
+	if r := p.config.MaxUploadRate; r > 0 && r < rate {
+		rate = r
+	}
+	return p.config.HTTPTimeout + time.Duration(size)*time.Second/time.Duration(rate)
+}
+
+// filesSize is the total size of the files; one that can't be read counts as
+// nothing.
+func filesSize(paths ...string) int64 {
+	var total int64
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
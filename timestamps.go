@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// timestampStyle is how the readable output formats write times, chosen with
+// -timestamps. The zero value is HH:MM:SS.
+type timestampStyle struct {
+	layout string
+	fps    float64
+}
+
+// timestampLayouts are the styles -timestamps accepts besides frames:FPS.
+var timestampLayouts = []string{"hh:mm:ss", "mm:ss", "hh:mm:ss.mmm", "hh:mm:ss,mmm"}
+
+// parseTimestampStyle validates a -timestamps value: one of timestampLayouts,
+// or frames:FPS for broadcast timecode at FPS frames per second.
+func parseTimestampStyle(s string) (timestampStyle, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if rate, ok := strings.CutPrefix(s, "frames:"); ok {
+		fps, err := strconv.ParseFloat(rate, 64)
+		if err != nil || fps <= 0 || fps > 120 {
+			return timestampStyle{}, fmt.Errorf("invalid frame rate %q in -timestamps (e.g. frames:25 or frames:29.97)", rate)
+		}
+		return timestampStyle{layout: "frames", fps: fps}, nil
+	}
+	for _, l := range timestampLayouts {
+		if s == l {
+			return timestampStyle{layout: s}, nil
+		}
+	}
+	return timestampStyle{}, fmt.Errorf("unknown timestamp style %q (available: %s, frames:FPS)", s, strings.Join(timestampLayouts, ", "))
+}
+
+// format writes seconds in the style.
+func (ts timestampStyle) format(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	switch ts.layout {
+	case "mm:ss":
+		s := int64(seconds)
+		return fmt.Sprintf("%02d:%02d", s/60, s%60)
+	case "hh:mm:ss.mmm":
+		return formatTimestamp(seconds, ".")
+	case "hh:mm:ss,mmm":
+		return formatTimestamp(seconds, ",")
+	case "frames":
+		// HH:MM:SS:FF, counting frames of real time at the nominal rate
+		s := math.Floor(seconds)
+		frame := int((seconds - s) * ts.fps)
+		return fmt.Sprintf("%s:%02d", formatTimestamp(s, ".")[:8], frame)
+	}
+	return formatTimestamp(seconds, ".")[:8]
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestTimestampStyles(t *testing.T) {
+	tests := []struct {
+		style   string
+		seconds float64
+		want    string
+	}{
+		{"hh:mm:ss", 3723.4, "01:02:03"},
+		{"", 3723.4, "01:02:03"},
+		{"mm:ss", 83.9, "01:23"},
+		{"mm:ss", 3723, "62:03"},
+		{"hh:mm:ss.mmm", 3723.25, "01:02:03.250"},
+		{"hh:mm:ss,mmm", 3723.25, "01:02:03,250"},
+		{"HH:MM:SS", 5, "00:00:05"},
+		{"frames:25", 10.5, "00:00:10:12"},
+		{"frames:29.97", 1.999, "00:00:01:29"},
+		{"hh:mm:ss", -3, "00:00:00"},
+	}
+	for _, tt := range tests {
+		var ts timestampStyle
+		if tt.style != "" {
+			var err error
+			if ts, err = parseTimestampStyle(tt.style); err != nil {
+				t.Errorf("parseTimestampStyle(%q): %v", tt.style, err)
+				continue
+			}
+		}
+		if got := ts.format(tt.seconds); got != tt.want {
+			t.Errorf("%q format(%v) = %q, want %q", tt.style, tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimestampStyleErrors(t *testing.T) {
+	for _, s := range []string{"seconds", "frames:", "frames:0", "frames:-25", "frames:240", "frames:fast"} {
+		if _, err := parseTimestampStyle(s); err == nil {
+			t.Errorf("parseTimestampStyle(%q) succeeded, want an error", s)
+		}
+	}
+}
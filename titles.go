@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxTitleVariants caps -title-variants.
+const maxTitleVariants = 5
+
+// metadataPlatform is where an episode's title and description are shown,
+// with the lengths that fit there, in characters. Titles get cut off in
+// listings far sooner than the hard limits.
+type metadataPlatform struct {
+	name             string
+	titleLimit       int
+	descriptionLimit int
+	// brief tells the model what the platform's listeners see.
+	brief string
+}
+
+// metadataPlatforms are the platforms variants are written for, in the order
+// of metadata.json.
+var metadataPlatforms = []metadataPlatform{
+	{"apple", 60, 4000, "Apple Podcasts: titles over 60 characters are cut off in the episode list, so lead with the hook; the description is the show notes, a few short paragraphs"},
+	{"youtube", 70, 5000, "YouTube: a title under 70 characters that makes viewers click without being clickbait, with the key words early for search; a description whose first two lines, shown above the fold, sell the episode"},
+	{"rss", 100, 255, "RSS feeds and other apps: a plain descriptive title, and a description of one or two sentences for the feed's short summary"},
+}
+
+// titlesPrompt asks for the variants of every platform.
+const titlesPrompt = `Write %d alternative titles and descriptions for each platform below, for A/B testing the following podcast episode. Give each variant a different angle, e.g. the guest, the most surprising claim, a question, the practical takeaway, and name the angle in a word or two.
+%s
+Keep within the lengths given. Be specific and concrete; don't invent anything that isn't in the transcript.
+%s
+Transcript:
+%s`
+
+// metadataVariant is one title and description to test.
+type metadataVariant struct {
+	Angle       string `json:"angle"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// platformMetadata lists the variants of one platform in metadata.json.
+type platformMetadata struct {
+	Platform         string            `json:"platform"`
+	TitleLimit       int               `json:"title_limit"`
+	DescriptionLimit int               `json:"description_limit"`
+	Variants         []metadataVariant `json:"variants"`
+}
+
+// episodeMetadata is metadata.json, for the publishing pipeline.
+type episodeMetadata struct {
+	Title     string             `json:"title,omitempty"`
+	Date      string             `json:"date,omitempty"`
+	Platforms []platformMetadata `json:"platforms"`
+}
+
+// titlesResponseFormat is the JSON schema of the variants reply: an array of
+// variants per platform.
+func titlesResponseFormat() map[string]any {
+	variants := map[string]any{
+		"type": "array",
+		"items": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"angle":       map[string]any{"type": "string"},
+				"title":       map[string]any{"type": "string"},
+				"description": map[string]any{"type": "string"},
+			},
+			"required":             []string{"angle", "title", "description"},
+			"additionalProperties": false,
+		},
+	}
+	properties := map[string]any{}
+	required := []string{}
+	for _, pl := range metadataPlatforms {
+		properties[pl.name] = variants
+		required = append(required, pl.name)
+	}
+	return map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":   "title_variants",
+			"strict": true,
+			"schema": map[string]any{
+				"type":                 "object",
+				"properties":           properties,
+				"required":             required,
+				"additionalProperties": false,
+			},
+		},
+	}
+}
+
+// draftTitleVariants asks the summary model for n title and description
+// variants per platform, and fits them to its limits.
+func (p *Pipeline) draftTitleVariants(ctx context.Context, apiKey string, t *Transcript, n int) (*episodeMetadata, TokenUsage, error) {
+	var platforms strings.Builder
+	for _, pl := range metadataPlatforms {
+		fmt.Fprintf(&platforms, "- %s (title at most %d characters, description at most %d): %s\n", pl.name, pl.titleLimit, pl.descriptionLimit, pl.brief)
+	}
+	var episode string
+	if t.Title != "" {
+		episode += "\nThe episode's current title: " + t.Title + "\n"
+	}
+	if t.Description != "" {
+		episode += "Its current description: " + t.Description + "\n"
+	}
+	payload := map[string]interface{}{
+		"model":           p.config.SummaryModel,
+		"messages":        []map[string]string{{"role": "user", "content": fmt.Sprintf(titlesPrompt, n, platforms.String(), episode, fitContext(formatTimedTurns(t.Segments), p.config.SummaryModel))}},
+		"temperature":     p.config.Temperature,
+		"response_format": titlesResponseFormat(),
+	}
+	content, usage, err := p.chatCompletion(ctx, apiKey, payload)
+	if err != nil {
+		return nil, usage, fmt.Errorf("failed to draft title variants: %v", err)
+	}
+	var res map[string][]metadataVariant
+	if err := json.Unmarshal([]byte(content), &res); err != nil {
+		return nil, usage, fmt.Errorf("failed to decode title variants: %v", err)
+	}
+
+	m := &episodeMetadata{Title: t.Title, Date: t.Date}
+	for _, pl := range metadataPlatforms {
+		pm := platformMetadata{Platform: pl.name, TitleLimit: pl.titleLimit, DescriptionLimit: pl.descriptionLimit, Variants: []metadataVariant{}}
+		for _, v := range res[pl.name] {
+			v.Title = strings.TrimRight(fitText(v.Title, pl.titleLimit), ".")
+			v.Description = fitText(v.Description, pl.descriptionLimit)
+			if v.Title == "" || len(pm.Variants) == n {
+				continue
+			}
+			pm.Variants = append(pm.Variants, metadataVariant{Angle: strings.TrimSpace(v.Angle), Title: v.Title, Description: v.Description})
+		}
+		m.Platforms = append(m.Platforms, pm)
+	}
+	return m, usage, nil
+}
+
+// counts returns how many variants were written for how many platforms.
+func (m *episodeMetadata) counts() (variants, platforms int) {
+	for _, pm := range m.Platforms {
+		variants += len(pm.Variants)
+	}
+	return variants, len(m.Platforms)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// modelLimits describes the context window and maximum completion length of a chat model.
+type modelLimits struct {
+	Context   int
+	MaxOutput int
+}
+
+// knownModelLimits lists the limits of chat models commonly used for diarization.
+// Unknown models fall back to defaultModelLimits.
+var knownModelLimits = map[string]modelLimits{
+	"gpt-4o":       {Context: 128000, MaxOutput: 16384},
+	"gpt-4o-mini":  {Context: 128000, MaxOutput: 16384},
+	"gpt-4.1":      {Context: 1047576, MaxOutput: 32768},
+	"gpt-4.1-mini": {Context: 1047576, MaxOutput: 32768},
+	"gpt-4-turbo":  {Context: 128000, MaxOutput: 4096},
+	"o1":           {Context: 200000, MaxOutput: 100000},
+	"o3-mini":      {Context: 200000, MaxOutput: 100000},
+}
+
+var defaultModelLimits = modelLimits{Context: 128000, MaxOutput: 4096}
+
+// limitsFor returns the limits for model, or defaultModelLimits if it is unknown.
+func limitsFor(model string) modelLimits {
+	if l, ok := lookupModel(knownModelLimits, model); ok {
+		return l
+	}
+	return defaultModelLimits
+}
+
+// pretoken approximates the pre-tokenisation split used by OpenAI's BPE encodings:
+// contractions, runs of letters, short digit groups, punctuation runs, and whitespace.
+var pretoken = regexp.MustCompile(`'(?:s|t|re|ve|m|ll|d)|[\p{L}]+|[\p{N}]{1,3}|[^\s\p{L}\p{N}]+|\s+`)
+
+// tokenMargin is the share added to every estimate, for text the heuristic
+// counts short, such as rare words, names and symbols.
+const tokenMargin = 0.2
+
+// estimateTokens approximates the number of BPE tokens in s. It is an estimate,
+// not the count of a real tokenizer, whose vocabularies this binary doesn't
+// carry. Common English words are a single token and longer ones roughly one
+// per four characters; words in other Latin, Cyrillic and Greek scripts are
+// split about twice as finely, and Chinese, Japanese and Korean text costs
+// about a token a character. Punctuation, as in code or URLs, counts a token
+// per two characters. With tokenMargin on top it errs on the high side, so
+// the budgets derived from it stay under the real limits.
+func estimateTokens(s string) int {
+	n := 0
+	for _, p := range pretoken.FindAllString(s, -1) {
+		r := utf8.RuneCountInString(p)
+		switch {
+		case strings.TrimSpace(p) == "":
+			if len(p) > 1 {
+				n++
+			}
+		case isIdeographic(p):
+			n += r
+		case !unicode.IsLetter([]rune(p)[0]) && !unicode.IsNumber([]rune(p)[0]):
+			n += (r + 1) / 2
+		case len(p) > r:
+			// Letters outside ASCII
+			n += (r + 1) / 2
+		case r <= 6:
+			n++
+		default:
+			n += (r + 3) / 4
+		}
+	}
+	return n + int(math.Ceil(float64(n)*tokenMargin))
+}
+
+// isIdeographic reports whether s is in a script written without spaces
+// between words, which BPE vocabularies hold few multi-character tokens of.
+func isIdeographic(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai)
+}
+
+// diarizationBudget is the largest number of transcript tokens that can be sent in
+// one diarization request. The reply repeats the whole transcript plus speaker
+// labels, so the completion limit is usually the binding constraint.
+func (p *Pipeline) diarizationBudget(model string) int {
+	if p.config.MaxPromptTokens > 0 {
+		return p.config.MaxPromptTokens
+	}
+	l := limitsFor(model)
+	if p.config.MaxOutputTokens > 0 {
+		l.MaxOutput = min(l.MaxOutput, p.config.MaxOutputTokens)
+	}
+	byOutput := l.MaxOutput * 3 / 4
+	byContext := (l.Context - promptOverheadTokens - p.exampleTokens()) / 2
+	return min(byOutput, byContext)
+}
+
+// promptOverheadTokens reserves room for the instructions wrapped around the transcript.
+const promptOverheadTokens = 1000
+
+// splitSegments groups consecutive segments into parts whose estimated token count
+// stays within budget. Segments larger than the budget are cut with
+// splitLongSegments first. Unless one is cut, the parts share the segments'
+// backing array, so changes to them are seen by the caller.
+func splitSegments(segments []Segment, budget int) [][]Segment {
+	segments = splitLongSegments(segments, budget)
+	var parts [][]Segment
+	start, tokens := 0, 0
+	for i, s := range segments {
+		n := estimateTokens(s.Text)
+		if i > start && tokens+n > budget {
+			parts = append(parts, segments[start:i:i])
+			start, tokens = i, 0
+		}
+		tokens += n
+	}
+	if start < len(segments) {
+		parts = append(parts, segments[start:])
+	}
+	return parts
+}
+
+// sentenceEnd matches the end of a sentence and the space after it.
+var sentenceEnd = regexp.MustCompile(`[.!?]+["')\]]*\s+|[。！？]+\s*`)
+
+// wordRun matches a word and the space after it.
+var wordRun = regexp.MustCompile(`\S+\s*`)
+
+// splitLongSegments cuts every segment larger than budget into pieces that
+// fit, such as the single segment of a transcript without timestamps. Cuts
+// fall at sentence ends where they can, between words where a sentence alone
+// is too large, and between characters in text written without spaces. The
+// pieces share the segment's time in proportion to their length, and its
+// words by their start. segments is returned as it is if none is too large.
+func splitLongSegments(segments []Segment, budget int) []Segment {
+	var out []Segment
+	for i, s := range segments {
+		if estimateTokens(s.Text) <= budget {
+			if out != nil {
+				out = append(out, s)
+			}
+			continue
+		}
+		if out == nil {
+			out = append([]Segment(nil), segments[:i]...)
+		}
+		out = append(out, splitLongSegment(s, budget)...)
+	}
+	if out == nil {
+		return segments
+	}
+	return out
+}
+
+// splitLongSegment cuts s into pieces whose estimated token count stays within
+// budget.
+func splitLongSegment(s Segment, budget int) []Segment {
+	// Break the text into units that fit, keeping the space after each
+	var units []string
+	for _, sentence := range splitAfter(s.Text, sentenceEnd) {
+		if estimateTokens(sentence) <= budget {
+			units = append(units, sentence)
+			continue
+		}
+		for _, word := range wordRun.FindAllString(sentence, -1) {
+			if estimateTokens(word) <= budget {
+				units = append(units, word)
+				continue
+			}
+			var run strings.Builder
+			tokens := 0
+			for _, r := range word {
+				n := estimateTokens(string(r))
+				if run.Len() > 0 && tokens+n > budget {
+					units = append(units, run.String())
+					run.Reset()
+					tokens = 0
+				}
+				run.WriteRune(r)
+				tokens += n
+			}
+			units = append(units, run.String())
+		}
+	}
+
+	// Group the units into pieces, in the way splitSegments groups segments.
+	// The estimates of the units add up to at least that of their text.
+	var texts []string
+	var cur strings.Builder
+	tokens := 0
+	for _, u := range units {
+		n := estimateTokens(u)
+		if cur.Len() > 0 && tokens+n > budget {
+			texts = append(texts, cur.String())
+			cur.Reset()
+			tokens = 0
+		}
+		cur.WriteString(u)
+		tokens += n
+	}
+	if cur.Len() > 0 {
+		texts = append(texts, cur.String())
+	}
+
+	pieces := make([]Segment, 0, len(texts))
+	offset, length := 0, len(s.Text)
+	for i, text := range texts {
+		piece := s
+		piece.Text = strings.TrimSpace(text)
+		piece.Words = nil
+		piece.Start = s.Start + (s.End-s.Start)*float64(offset)/float64(length)
+		offset += len(text)
+		piece.End = s.Start + (s.End-s.Start)*float64(offset)/float64(length)
+		piece.Paragraph = s.Paragraph && i == 0
+		pieces = append(pieces, piece)
+	}
+	for _, w := range s.Words {
+		i := len(pieces) - 1
+		for i > 0 && w.Start < pieces[i].Start {
+			i--
+		}
+		pieces[i].Words = append(pieces[i].Words, w)
+	}
+	return pieces
+}
+
+// splitAfter splits s after every match of re, keeping the matches.
+func splitAfter(s string, re *regexp.Regexp) []string {
+	var out []string
+	start := 0
+	for _, m := range re.FindAllStringIndex(s, -1) {
+		out = append(out, s[start:m[1]])
+		start = m[1]
+	}
+	if start < len(s) {
+		out = append(out, s[start:])
+	}
+	return out
+}
+
+// joinSegmentText joins the text of segments with single spaces.
+func joinSegmentText(segments []Segment) string {
+	texts := make([]string, 0, len(segments))
+	for _, s := range segments {
+		if s.Kind == eventKind {
+			continue
+		}
+		if t := strings.TrimSpace(s.Text); t != "" {
+			texts = append(texts, t)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// contextLines is how many diarized turns from the previous part are passed along
+// to keep speaker labels consistent across parts.
+const contextLines = 6
+
+// diarizeInParts diarizes transcript, splitting it into several requests when its
+// estimated size exceeds what the model can return in one completion. Each part is
+// given the tail of the previous part's result so speaker labels stay consistent.
+func (p *Pipeline) diarizeInParts(ctx context.Context, apiKey string, transcript *Transcript, numSpeakers int) ([]Segment, TokenUsage, error) {
+	if p.config.ChapterDiarization {
+		return p.diarizeByChapter(ctx, apiKey, transcript, numSpeakers)
+	}
+	budget := p.diarizationBudget(p.config.DiarizationModel)
+	total := estimateTokens(transcript.Text)
+	if total <= budget {
+		return p.diarizeVerified(ctx, apiKey, transcript.Text, "", pauseHints(transcript.Segments, p.config.SpeakerGap), numSpeakers)
+	}
+
+	parts := splitSegments(transcript.Segments, budget)
+	if len(parts) > 1 {
+		p.console.warnf("transcript is ~%d tokens, more than the ~%d %s can return in one reply; diarizing in %d parts\n",
+			total, budget, p.config.DiarizationModel, len(parts))
+	}
+
+	var (
+		turns    []Segment
+		usage    TokenUsage
+		previous string
+	)
+	for i, part := range parts {
+		partTurns, u, err := p.diarizeVerified(ctx, apiKey, paragraphText(part), previous, pauseHints(part, p.config.SpeakerGap), numSpeakers)
+		if err != nil {
+			return nil, usage, fmt.Errorf("part %d/%d: %v", i+1, len(parts), err)
+		}
+		usage.Add(u)
+		turns = append(turns, partTurns...)
+		previous = formatTurns(partTurns[max(0, len(partTurns)-contextLines):])
+		p.console.progressf("Diarized part %d/%d\n", i+1, len(parts))
+	}
+	return turns, usage, nil
+}
+
+// diarizeSpeakers diarizes transcript with diarizeInParts and, when retry is
+// set and the turns use another number of speaker labels than numSpeakers,
+// diarizes it again for the number they use: a model that hears a different
+// count from the one it was given tends to split or merge speakers
+// inconsistently. The second result is kept only if its labels agree with the
+// new count. It returns the turns and the count they were diarized for.
+func (p *Pipeline) diarizeSpeakers(ctx context.Context, apiKey string, transcript *Transcript, numSpeakers int, retry bool) ([]Segment, int, TokenUsage, error) {
+	turns, usage, err := p.diarizeInParts(ctx, apiKey, transcript, numSpeakers)
+	found := countSpeakers(turns)
+	if err != nil || !retry || found == 0 || found == numSpeakers {
+		return turns, numSpeakers, usage, err
+	}
+	p.console.warnf("diarization used %d speaker label(s) for %d speakers; diarizing again for %d\n", found, numSpeakers, found)
+	retried, u, err := p.diarizeInParts(ctx, apiKey, transcript, found)
+	usage.Add(u)
+	switch {
+	case err != nil:
+		p.console.warnf("diarizing again for %d speakers failed, keeping the first result: %v\n", found, err)
+	case countSpeakers(retried) != found:
+		p.console.warnf("diarizing again for %d speakers used %d label(s); keeping the first result\n", found, countSpeakers(retried))
+	default:
+		return retried, found, usage, nil
+	}
+	return turns, numSpeakers, usage, nil
+}
+
+// diarizeVerified diarizes text and, when verification is enabled, checks that the
+// turns preserve the source words within MaxWordDrift, retrying up to VerifyRetries
+// times before rejecting the result.
+func (p *Pipeline) diarizeVerified(ctx context.Context, apiKey, text, previous string, pauses []string, numSpeakers int) ([]Segment, TokenUsage, error) {
+	var usage TokenUsage
+	for attempt := 0; ; attempt++ {
+		turns, u, err := p.diarizeWithTimeout(ctx, apiKey, text, previous, pauses, numSpeakers)
+		usage.Add(u)
+		if err != nil || !p.config.VerifyWords {
+			return turns, usage, err
+		}
+		drift := measureDrift(text, turns, p.config.MaxWordDrift)
+		if drift.withinTolerance(p.config.MaxWordDrift) {
+			if p.config.Verbatim {
+				// The model may still have recased or repunctuated the words
+				restoreSourceWords(text, turns)
+			}
+			return turns, usage, nil
+		}
+		if attempt >= p.config.VerifyRetries {
+			return nil, usage, fmt.Errorf("diarized output does not preserve the transcript: %s, tolerance %.1f%%", drift, 100*p.config.MaxWordDrift)
+		}
+		p.console.warnf("diarized output drifted from the transcript (%s); retrying\n", drift)
+		p.stats.retries.Add(1)
+	}
+}
+
+// diarizeWithTimeout runs one diarization request under a timeout scaled to the
+// length of text, which the model writes back out.
+func (p *Pipeline) diarizeWithTimeout(ctx context.Context, apiKey, text, previous string, pauses []string, numSpeakers int) ([]Segment, TokenUsage, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.chatTimeout(estimateTokens(text)))
+	defer cancel()
+	p.stats.requests.Add(1)
+	return p.diarizeTranscript(ctx, apiKey, text, previous, pauses, numSpeakers)
+}
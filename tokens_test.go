@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		// atLeast is the count of OpenAI's tokenizers, which the estimate
+		// mustn't fall below; atMost keeps it from wasting the budget.
+		atLeast, atMost int
+	}{
+		{"", 0, 0},
+		{"Hello, world!", 4, 6},
+		{"The quick brown fox jumps over the lazy dog.", 10, 13},
+		{"internationalization", 3, 8},
+		{"Größenwahnsinnige Straßenbahnhaltestelle", 8, 30},
+		{"Привет, как дела?", 6, 15},
+		{"我们今天讨论播客", 8, 12},
+		{`if (x != nil) { return fmt.Errorf("bad: %v", err); }`, 18, 40},
+	}
+	for _, tt := range tests {
+		if got := estimateTokens(tt.text); got < tt.atLeast || got > tt.atMost {
+			t.Errorf("estimateTokens(%q) = %d, want %d to %d", tt.text, got, tt.atLeast, tt.atMost)
+		}
+	}
+	if cjk := "播客"; estimateTokens(strings.Repeat(cjk, 50)) < 50*utf8.RuneCountInString(cjk) {
+		t.Error("Chinese text must cost at least a token a character")
+	}
+}
+
+func TestSplitSegments(t *testing.T) {
+	seg := func(words int) Segment { return Segment{Text: strings.TrimSpace(strings.Repeat("word ", words))} }
+	tests := []struct {
+		name     string
+		segments []Segment
+		budget   int
+		sizes    []int
+	}{
+		{"fits", []Segment{seg(5), seg(5)}, 100, []int{2}},
+		{"split", []Segment{seg(10), seg(10), seg(10)}, 30, []int{2, 1}},
+		{"one per part", []Segment{seg(10), seg(10), seg(10)}, 12, []int{1, 1, 1}},
+		{"oversized segment cut", []Segment{seg(2), seg(100), seg(2)}, 20, []int{2, 1, 1, 1, 1, 1, 1, 1, 1, 2}},
+		{"empty", nil, 10, nil},
+	}
+	for _, tt := range tests {
+		parts := splitSegments(tt.segments, tt.budget)
+		var sizes []int
+		var got []string
+		for _, part := range parts {
+			sizes = append(sizes, len(part))
+			tokens := 0
+			for _, s := range part {
+				got = append(got, s.Text)
+				tokens += estimateTokens(s.Text)
+			}
+			if tokens > tt.budget {
+				t.Errorf("%s: part of %d tokens over the budget of %d", tt.name, tokens, tt.budget)
+			}
+		}
+		var want []string
+		for _, s := range tt.segments {
+			want = append(want, s.Text)
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: parts don't hold the text in order", tt.name)
+		}
+		if len(sizes) != len(tt.sizes) {
+			t.Errorf("%s: parts of %v segments, want %v", tt.name, sizes, tt.sizes)
+			continue
+		}
+		for i := range sizes {
+			if sizes[i] != tt.sizes[i] {
+				t.Errorf("%s: parts of %v segments, want %v", tt.name, sizes, tt.sizes)
+				break
+			}
+		}
+	}
+}
+
+func TestSplitLongSegment(t *testing.T) {
+	sentences := strings.Repeat("This is one sentence of the episode. ", 20)
+	tests := []struct {
+		name   string
+		text   string
+		budget int
+		// cuts are strings every piece but the last must end with
+		cut string
+	}{
+		{"sentences", sentences, 30, "."},
+		{"one long sentence", strings.Repeat("and then ", 100), 20, "then"},
+		{"no spaces", strings.Repeat("我们今天讨论播客", 20), 25, ""},
+	}
+	for _, tt := range tests {
+		s := Segment{Start: 10, End: 110, Text: strings.TrimSpace(tt.text), Paragraph: true, Speaker: "Alice",
+			Words: []Word{{Text: "first", Start: 10}, {Text: "last", Start: 109}}}
+		pieces := splitLongSegment(s, tt.budget)
+		if len(pieces) < 2 {
+			t.Errorf("%s: %d piece(s), want the segment cut", tt.name, len(pieces))
+			continue
+		}
+		var text strings.Builder
+		for i, piece := range pieces {
+			if n := estimateTokens(piece.Text); n > tt.budget {
+				t.Errorf("%s: piece %d is %d tokens, over the budget of %d", tt.name, i, n, tt.budget)
+			}
+			if i < len(pieces)-1 && !strings.HasSuffix(piece.Text, tt.cut) {
+				t.Errorf("%s: piece %d ends %q, want a cut after %q", tt.name, i, piece.Text, tt.cut)
+			}
+			if i > 0 && piece.Start != pieces[i-1].End {
+				t.Errorf("%s: piece %d starts at %v, not where piece %d ends", tt.name, i, piece.Start, i-1)
+			}
+			if piece.Speaker != "Alice" || piece.Paragraph != (i == 0) {
+				t.Errorf("%s: piece %d has speaker %q and paragraph %v", tt.name, i, piece.Speaker, piece.Paragraph)
+			}
+			if tt.cut != "" && i > 0 {
+				text.WriteString(" ")
+			}
+			text.WriteString(piece.Text)
+		}
+		if text.String() != s.Text {
+			t.Errorf("%s: pieces don't add up to the segment's text", tt.name)
+		}
+		first, last := pieces[0], pieces[len(pieces)-1]
+		if first.Start != s.Start || last.End != s.End {
+			t.Errorf("%s: pieces span %v to %v, want %v to %v", tt.name, first.Start, last.End, s.Start, s.End)
+		}
+		if len(first.Words) != 1 || len(last.Words) != 1 || last.Words[0].Text != "last" {
+			t.Errorf("%s: words not handed to the pieces they start in", tt.name)
+		}
+	}
+}
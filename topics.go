@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Index construction parameters.
+const (
+	// topicsPerEpisode is how many of an episode's most distinctive words are
+	// considered its topics.
+	topicsPerEpisode = 12
+	// recurringShingle is the length, in words, of the phrases compared across
+	// episodes to find recurring segments such as intros and ad reads.
+	recurringShingle = 6
+	// indexEntries caps each list of the catalog overview.
+	indexEntries = 20
+)
+
+// genericSpeaker matches the labels diarization assigns before speakers are
+// named, which say nothing across episodes.
+var genericSpeaker = regexp.MustCompile(`(?i)^speaker[ _]?[a-z0-9]{1,3}$`)
+
+// catalogIndex is the cross-episode index built by the topics command.
+type catalogIndex struct {
+	Episodes  []indexEpisode `json:"episodes"`
+	People    []indexEntry   `json:"people"`
+	Topics    []indexEntry   `json:"topics"`
+	Recurring []indexEntry   `json:"recurring"`
+}
+
+// indexEpisode is one transcript in the catalog.
+type indexEpisode struct {
+	Title string `json:"title"`
+	Date  string `json:"date,omitempty"`
+	Path  string `json:"path"`
+}
+
+// indexEntry is a person, topic or recurring segment and where it occurs.
+type indexEntry struct {
+	Name     string         `json:"name"`
+	Episodes int            `json:"episodes"`
+	Mentions []indexMention `json:"mentions"`
+}
+
+// indexMention is one place in one episode.
+type indexMention struct {
+	// Episode indexes catalogIndex.Episodes.
+	Episode int     `json:"episode"`
+	Time    float64 `json:"time"`
+	Speaker string  `json:"speaker,omitempty"`
+	Text    string  `json:"text"`
+}
+
+// catalogEpisode is a loaded transcript with its place in the index.
+type catalogEpisode struct {
+	indexEpisode
+	transcript *Transcript
+}
+
+// runTopics implements the topics command.
+func runTopics(args []string) error {
+	flags := flag.NewFlagSet("topics", flag.ExitOnError)
+	in := flags.String("in", ".", "Directory searched recursively for diarized transcripts")
+	indexPath := flags.String("index", "", "Also write the cross-episode index as JSON to this file")
+	limit := flags.Int("limit", 5, "Mentions shown per episode when searching")
+	asJSON := flags.Bool("json", false, "Print the overview or search results as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription topics [-in dir] [-index index.json] [-json] [subject ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	episodes, err := loadCatalog(key, *in)
+	if err != nil {
+		return err
+	}
+	if len(episodes) == 0 {
+		return fmt.Errorf("no %s files found under %s", filepath.Base(defaultConfig().DiarizedJSONFile), *in)
+	}
+	index := buildCatalogIndex(episodes)
+	if *indexPath != "" {
+		data, err := json.MarshalIndent(index, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(*indexPath, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write index: %v", err)
+		}
+	}
+
+	subject := strings.Join(flags.Args(), " ")
+	if subject == "" {
+		if *asJSON {
+			return printJSON(index)
+		}
+		printCatalogOverview(index)
+		return nil
+	}
+	mentions := searchCatalog(episodes, subject)
+	if *asJSON {
+		return printJSON(indexEntry{Name: subject, Episodes: countEpisodes(mentions), Mentions: mentions})
+	}
+	printMentions(index.Episodes, subject, mentions, *limit)
+	return nil
+}
+
+func printJSON(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// loadCatalog reads every diarized transcript under dir, oldest first.
+func loadCatalog(key []byte, dir string) ([]catalogEpisode, error) {
+	name := filepath.Base(defaultConfig().DiarizedJSONFile)
+	var episodes []catalogEpisode
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != name {
+			return nil
+		}
+		t, err := loadTranscript(key, path)
+		if err != nil {
+			return err
+		}
+		episodes = append(episodes, catalogEpisode{
+			indexEpisode: indexEpisode{Title: episodeTitle(t, filepath.Base(filepath.Dir(path))), Date: t.Date, Path: path},
+			transcript:   t,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect transcripts: %v", err)
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		if episodes[i].Date != episodes[j].Date {
+			return episodes[i].Date < episodes[j].Date
+		}
+		return episodes[i].Title < episodes[j].Title
+	})
+	return episodes, nil
+}
+
+// indexBuilder accumulates entries keyed by their normalized name.
+type indexBuilder struct {
+	entries map[string]*indexEntry
+	seen    map[string]map[int]bool
+}
+
+func newIndexBuilder() *indexBuilder {
+	return &indexBuilder{entries: map[string]*indexEntry{}, seen: map[string]map[int]bool{}}
+}
+
+func (b *indexBuilder) add(name string, m indexMention) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if key == "" {
+		return
+	}
+	e := b.entries[key]
+	if e == nil {
+		e = &indexEntry{Name: strings.TrimSpace(name)}
+		b.entries[key] = e
+		b.seen[key] = map[int]bool{}
+	}
+	if !b.seen[key][m.Episode] {
+		b.seen[key][m.Episode] = true
+		e.Episodes++
+	}
+	e.Mentions = append(e.Mentions, m)
+}
+
+// list returns the entries found in at least minEpisodes episodes, the most
+// widespread first.
+func (b *indexBuilder) list(minEpisodes int) []indexEntry {
+	var list []indexEntry
+	for _, e := range b.entries {
+		if e.Episodes >= minEpisodes {
+			list = append(list, *e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Episodes != list[j].Episodes {
+			return list[i].Episodes > list[j].Episodes
+		}
+		if len(list[i].Mentions) != len(list[j].Mentions) {
+			return len(list[i].Mentions) > len(list[j].Mentions)
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// buildCatalogIndex indexes the people, topics and recurring segments of
+// episodes. People are named speakers and person entities; topics are chapter
+// headlines and each episode's most distinctive words; recurring segments are
+// chapter headlines and phrases that come back in several episodes.
+func buildCatalogIndex(episodes []catalogEpisode) catalogIndex {
+	var index catalogIndex
+	people, topics, recurring := newIndexBuilder(), newIndexBuilder(), newIndexBuilder()
+
+	// Document frequency of words, for picking each episode's distinctive ones
+	df := map[string]int{}
+	counts := make([]map[string]int, len(episodes))
+	for i, ep := range episodes {
+		counts[i] = map[string]int{}
+		for _, s := range ep.transcript.Segments {
+			for _, w := range topicWords(s.Text) {
+				counts[i][w]++
+			}
+		}
+		for w := range counts[i] {
+			df[w]++
+		}
+	}
+
+	// shingles counts the episodes each phrase of recurringShingle words is in
+	shingles := map[string]int{}
+	for i, ep := range episodes {
+		index.Episodes = append(index.Episodes, ep.indexEpisode)
+		t := ep.transcript
+
+		named := map[string]string{}
+		for _, info := range t.Speakers {
+			if info.Name != "" {
+				named[info.Label] = info.Name
+			}
+		}
+		first := map[string]bool{}
+		for _, s := range t.Segments {
+			name := s.Speaker
+			if n, ok := named[name]; ok {
+				name = n
+			}
+			if name == "" || genericSpeaker.MatchString(name) || first[name] {
+				continue
+			}
+			first[name] = true
+			people.add(name, indexMention{Episode: i, Time: s.Start, Speaker: name, Text: truncateWords(s.Text, 20)})
+		}
+		for _, e := range t.Entities {
+			if strings.Contains(strings.ToLower(e.Type), "person") {
+				people.add(e.Text, indexMention{Episode: i, Time: e.Start, Text: e.Text})
+			}
+		}
+
+		for _, c := range t.Chapters {
+			m := indexMention{Episode: i, Time: c.Start, Text: c.Headline}
+			topics.add(c.Headline, m)
+			recurring.add(c.Headline, m)
+		}
+		for _, w := range distinctiveWords(counts[i], df, len(episodes)) {
+			if s, ok := firstMention(t.Segments, w); ok {
+				topics.add(w, indexMention{Episode: i, Time: s.Start, Speaker: s.Speaker, Text: truncateWords(s.Text, 20)})
+			}
+		}
+
+		seen := map[string]bool{}
+		for _, s := range t.Segments {
+			words := shingleWords(s.Text)
+			for j := 0; j+recurringShingle <= len(words); j++ {
+				key := strings.Join(words[j:j+recurringShingle], " ")
+				if !seen[key] {
+					seen[key] = true
+					shingles[key]++
+				}
+			}
+		}
+	}
+
+	// Phrases heard in at least three episodes, or all of two, are recurring.
+	// Overlapping phrases are joined into the longest run in each turn, so an
+	// intro is listed once rather than as every window over it.
+	minRecurring := max(2, min(3, len(episodes)))
+	for i, ep := range episodes {
+		for _, s := range ep.transcript.Segments {
+			words := shingleWords(s.Text)
+			for j := 0; j+recurringShingle <= len(words); j++ {
+				if shingles[strings.Join(words[j:j+recurringShingle], " ")] < minRecurring {
+					continue
+				}
+				end := j + 1
+				for end+recurringShingle <= len(words) && shingles[strings.Join(words[end:end+recurringShingle], " ")] >= minRecurring {
+					end++
+				}
+				recurring.add(strings.Join(words[j:end+recurringShingle-1], " "), indexMention{Episode: i, Time: s.Start, Speaker: s.Speaker, Text: truncateWords(s.Text, 20)})
+				j = end + recurringShingle - 2
+			}
+		}
+	}
+
+	index.People = truncateEntries(people.list(1))
+	index.Topics = truncateEntries(topics.list(1))
+	index.Recurring = truncateEntries(recurring.list(2))
+	return index
+}
+
+// distinctiveWords returns the words of one episode with the highest tf-idf
+// weight among n episodes.
+func distinctiveWords(counts map[string]int, df map[string]int, n int) []string {
+	type scored struct {
+		word  string
+		score float64
+	}
+	var words []scored
+	for w, c := range counts {
+		if c < 3 {
+			continue
+		}
+		words = append(words, scored{w, float64(c) * math.Log(1+float64(n)/float64(df[w]))})
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].score != words[j].score {
+			return words[i].score > words[j].score
+		}
+		return words[i].word < words[j].word
+	})
+	var out []string
+	for _, w := range words[:min(len(words), topicsPerEpisode)] {
+		out = append(out, w.word)
+	}
+	return out
+}
+
+// firstMention returns the first segment using word.
+func firstMention(segments []Segment, word string) (Segment, bool) {
+	for _, s := range segments {
+		for _, w := range topicWords(s.Text) {
+			if w == word {
+				return s, true
+			}
+		}
+	}
+	return Segment{}, false
+}
+
+// shingleWords returns the lowercased words of text without punctuation.
+func shingleWords(text string) []string {
+	return strings.Fields(nonSlug.ReplaceAllString(strings.ToLower(text), " "))
+}
+
+// truncateEntries keeps the first indexEntries entries of an overview list.
+func truncateEntries(entries []indexEntry) []indexEntry {
+	return entries[:min(len(entries), indexEntries)]
+}
+
+// searchCatalog finds the turns, chapters and entities of episodes mentioning
+// subject, matched as whole words regardless of case.
+func searchCatalog(episodes []catalogEpisode, subject string) []indexMention {
+	re := subjectPattern(subject)
+	var mentions []indexMention
+	for i, ep := range episodes {
+		for _, c := range ep.transcript.Chapters {
+			if re.MatchString(c.Headline) || re.MatchString(c.Summary) {
+				mentions = append(mentions, indexMention{Episode: i, Time: c.Start, Text: "Chapter: " + c.Headline})
+			}
+		}
+		for _, s := range ep.transcript.Segments {
+			if loc := re.FindStringIndex(s.Text); loc != nil {
+				mentions = append(mentions, indexMention{Episode: i, Time: s.Start, Speaker: s.Speaker, Text: snippetAround(s.Text, loc[0], loc[1])})
+			}
+		}
+	}
+	sort.SliceStable(mentions, func(i, j int) bool {
+		if mentions[i].Episode != mentions[j].Episode {
+			return mentions[i].Episode < mentions[j].Episode
+		}
+		return mentions[i].Time < mentions[j].Time
+	})
+	return mentions
+}
+
+// subjectPattern matches subject as whole words regardless of case, with any
+// spacing between them.
+func subjectPattern(subject string) *regexp.Regexp {
+	words := strings.Fields(subject)
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	return regexp.MustCompile(`(?i)\b` + strings.Join(words, `\s+`) + `\b`)
+}
+
+// snippetAround returns up to ten words either side of text[start:end].
+func snippetAround(text string, start, end int) string {
+	before, after := text[:start], text[end:]
+	if words := strings.Fields(before); len(words) > 10 {
+		before = "… " + strings.Join(words[len(words)-10:], " ")
+		if strings.HasSuffix(text[:start], " ") {
+			before += " "
+		}
+	}
+	if words := strings.Fields(after); len(words) > 10 {
+		after = strings.Join(words[:10], " ") + " …"
+		if strings.HasPrefix(text[end:], " ") {
+			after = " " + after
+		}
+	}
+	return strings.TrimSpace(before + text[start:end] + after)
+}
+
+func countEpisodes(mentions []indexMention) int {
+	seen := map[int]bool{}
+	for _, m := range mentions {
+		seen[m.Episode] = true
+	}
+	return len(seen)
+}
+
+// printCatalogOverview lists the catalog's people, topics and recurring
+// segments with the number of episodes each appears in.
+func printCatalogOverview(index catalogIndex) {
+	fmt.Printf("%d episode(s) indexed\n", len(index.Episodes))
+	for _, section := range []struct {
+		heading string
+		entries []indexEntry
+	}{
+		{"People", index.People},
+		{"Topics", index.Topics},
+		{"Recurring segments", index.Recurring},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s:\n", section.heading)
+		for _, e := range section.entries {
+			fmt.Printf("  %-50s %d episode(s)\n", truncateWords(e.Name, 8), e.Episodes)
+		}
+	}
+}
+
+// printMentions lists where subject was discussed, episode by episode, at most
+// limit mentions each.
+func printMentions(episodes []indexEpisode, subject string, mentions []indexMention, limit int) {
+	if len(mentions) == 0 {
+		fmt.Printf("%q isn't mentioned in %d episode(s)\n", subject, len(episodes))
+		return
+	}
+	fmt.Printf("%q is mentioned %d time(s) in %d of %d episode(s)\n", subject, len(mentions), countEpisodes(mentions), len(episodes))
+	for i := 0; i < len(mentions); {
+		ep := episodes[mentions[i].Episode]
+		j := i
+		for j < len(mentions) && mentions[j].Episode == mentions[i].Episode {
+			j++
+		}
+		heading := ep.Title
+		if ep.Date != "" {
+			heading = ep.Date + "  " + heading
+		}
+		fmt.Printf("\n%s (%s)\n", heading, ep.Path)
+		for k, m := range mentions[i:j] {
+			if limit > 0 && k == limit {
+				fmt.Printf("  … and %d more\n", j-i-limit)
+				break
+			}
+			line := m.Text
+			if m.Speaker != "" {
+				line = m.Speaker + ": " + line
+			}
+			fmt.Printf("  %s  %s\n", formatTimestamp(m.Time, ".")[:8], line)
+		}
+		i = j
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// transcriptVersion is the version of the canonical transcript JSON layout.
+const transcriptVersion = 1
+
+// Segment is a timed span of speech. Transcription segments have no speaker;
+// diarized segments are speaker turns.
+type Segment struct {
+	ID      int     `json:"id"`
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker,omitempty"`
+	Text    string  `json:"text"`
+	Words   []Word  `json:"words,omitempty"`
+	// Kind is empty for speech and eventKind for non-speech events such as
+	// laughter, whose Text is the "[label]" annotation.
+	Kind string `json:"kind,omitempty"`
+	// Crosstalk marks a turn that overlaps another speaker's.
+	Crosstalk bool `json:"crosstalk,omitempty"`
+	// NoSpeechProb is Whisper's estimate that the segment isn't speech, and
+	// AvgLogprob the mean log probability of its tokens.
+	NoSpeechProb float64 `json:"no_speech_prob,omitempty"`
+	AvgLogprob   float64 `json:"avg_logprob,omitempty"`
+	// Paragraph marks a segment that starts a new paragraph.
+	Paragraph bool `json:"paragraph,omitempty"`
+	// SpeakerConfidence is how sure acoustic diarization is of the speaker, from
+	// 0 to 1; Review marks a turn below -review-threshold, and Reviewed one
+	// a person has checked with the review command.
+	SpeakerConfidence float64 `json:"speaker_confidence,omitempty"`
+	Review            bool    `json:"review,omitempty"`
+	Reviewed          bool    `json:"reviewed,omitempty"`
+	// Language tags a turn spoken in another language than the transcript's,
+	// with -languages; Translations maps languages to the turn's translation
+	// into them, with -translate.
+	Language     string            `json:"language,omitempty"`
+	Translations map[string]string `json:"translations,omitempty"`
+}
+
+// crosstalkMarker is prefixed to the text of overlapping turns in the rendered
+// formats.
+const crosstalkMarker = "[crosstalk] "
+
+// displayText is the turn text as rendered in the human-readable formats.
+func (s Segment) displayText() string {
+	text := s.Text
+	if s.Language != "" {
+		text = "[" + s.Language + "] " + text
+	}
+	if s.Crosstalk {
+		return crosstalkMarker + text
+	}
+	return text
+}
+
+// Word is a single timed word, present when the provider reports word timing.
+type Word struct {
+	Text       string  `json:"word"`
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Confidence float64 `json:"confidence,omitempty"`
+	// SpeakerConfidence is the provider's confidence in the word's speaker.
+	SpeakerConfidence float64 `json:"speaker_confidence,omitempty"`
+}
+
+// Chapter is a topical section of an episode.
+type Chapter struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Headline string  `json:"headline"`
+	Gist     string  `json:"gist,omitempty"`
+	Summary  string  `json:"summary,omitempty"`
+}
+
+// Entity is a named entity, such as a person or organization, mentioned in the audio.
+type Entity struct {
+	Type  string  `json:"type"`
+	Text  string  `json:"text"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Transcript is the canonical transcript model shared by the pipeline stages and
+// the exporters. It is what gets cached on disk between runs.
+type Transcript struct {
+	Version int    `json:"version"`
+	Title   string `json:"title,omitempty"`
+	Date    string `json:"date,omitempty"`
+	// Description is the episode description or show notes.
+	Description string    `json:"description,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Audio       string    `json:"audio,omitempty"`
+	Language    string    `json:"language,omitempty"`
+	Duration    float64   `json:"duration,omitempty"`
+	Text        string    `json:"text"`
+	Segments    []Segment `json:"segments"`
+
+	// Source fingerprints the audio a transcription was made from.
+	Source *AudioFingerprint `json:"source,omitempty"`
+
+	// Podcast is the episode's record in a podcast directory, from -lookup.
+	Podcast *PodcastMetadata `json:"podcast,omitempty"`
+
+	// Speakers describes who each diarized speaker label was identified as.
+	Speakers []SpeakerInfo `json:"speakers,omitempty"`
+
+	// Overlaps lists the regions where speakers talk over each other.
+	Overlaps []Overlap `json:"overlaps,omitempty"`
+
+	// Chapters and Entities are filled in by providers that detect them.
+	Chapters []Chapter `json:"chapters,omitempty"`
+	Entities []Entity  `json:"entities,omitempty"`
+
+	// Models maps each pipeline stage to the model that produced it.
+	Models map[string]string `json:"models,omitempty"`
+}
+
+// shift moves every timestamp in t by offset seconds, as when a transcript
+// of part of the audio is placed back into the whole.
+func (t *Transcript) shift(offset float64) {
+	t.mapTimes(func(x float64) float64 { return x + offset })
+}
+
+// speakers returns the distinct speaker labels in order of first appearance.
+func (t *Transcript) speakers() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, s := range t.Segments {
+		if s.Speaker != "" && !seen[s.Speaker] {
+			seen[s.Speaker] = true
+			names = append(names, s.Speaker)
+		}
+	}
+	return names
+}
+
+// diarized reports whether every speech segment carries a speaker label.
+func (t *Transcript) diarized() bool {
+	for _, s := range t.Segments {
+		if s.Speaker == "" && s.Kind != eventKind {
+			return false
+		}
+	}
+	return len(t.Segments) > 0
+}
+
+// loadTranscript reads a canonical transcript JSON file.
+func loadTranscript(key []byte, path string) (*Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	defer f.Close()
+	// Decode straight from the file rather than holding the raw JSON as well
+	r, err := storedReader(key, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var t Transcript
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if t.Version > transcriptVersion {
+		return nil, fmt.Errorf("%s has transcript version %d, newer than supported %d", path, t.Version, transcriptVersion)
+	}
+	return &t, nil
+}
+
+// saveTranscript writes t to path as indented JSON.
+func (p *Pipeline) saveTranscript(path string, t *Transcript) error {
+	t.Version = transcriptVersion
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript: %v", err)
+	}
+	if err := p.writeOutput(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// speakerLine matches the start of a speaker turn in free-form diarized text, e.g.
+// "Speaker 1: ...", "**Alice:** ..." or "- Bob: ...".
+var speakerLine = regexp.MustCompile(`^\s*[-*]*\s*\**([\p{L}][\p{L}\p{N} .'&-]{0,40}?)\**\s*(?:\(\?\))?\s*:\**\s*(.*)$`)
+
+// parseDiarized splits model-written diarized text into speaker turns. Lines that
+// don't start a new turn are appended to the current one.
+func parseDiarized(text string) []Segment {
+	var turns []Segment
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "===") || strings.HasPrefix(line, "```") {
+			continue
+		}
+		if m := speakerLine.FindStringSubmatch(line); m != nil && len(strings.Fields(m[1])) <= 4 {
+			turns = append(turns, Segment{Speaker: strings.TrimSpace(m[1]), Text: strings.TrimSpace(m[2])})
+			continue
+		}
+		if len(turns) == 0 {
+			turns = append(turns, Segment{Speaker: "Unknown"})
+		}
+		last := &turns[len(turns)-1]
+		last.Text = strings.TrimSpace(last.Text + " " + line)
+	}
+	out := turns[:0]
+	for _, t := range turns {
+		if t.Text != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// timedWord is a single word of the source transcript with an estimated time span.
+type timedWord struct {
+	norm       string
+	start, end float64
+	// word is the provider's word, when it gave word timing.
+	word *Word
+}
+
+// wordTimeline lists the words of the segments with their timing where the
+// provider gave it, and otherwise spreads each segment's duration evenly across
+// its words.
+func wordTimeline(segments []Segment) []timedWord {
+	var words []timedWord
+	for _, s := range segments {
+		if len(s.Words) > 0 {
+			for _, w := range s.Words {
+				words = append(words, timedWord{norm: normalizeWord(w.Text), start: w.Start, end: w.End, word: &w})
+			}
+			continue
+		}
+		fields := strings.Fields(s.Text)
+		if len(fields) == 0 {
+			continue
+		}
+		step := (s.End - s.Start) / float64(len(fields))
+		for i, f := range fields {
+			words = append(words, timedWord{
+				norm:  normalizeWord(f),
+				start: s.Start + step*float64(i),
+				end:   s.Start + step*float64(i+1),
+			})
+		}
+	}
+	return words
+}
+
+// attachWords gives each segment the words of a separate word list that
+// start within it, as Whisper returns them.
+func attachWords(segments []Segment, words []Word) {
+	j := 0
+	for i := range segments {
+		s := &segments[i]
+		for ; j < len(words) && (words[j].Start < s.End || i == len(segments)-1); j++ {
+			w := words[j]
+			w.Text = strings.TrimSpace(w.Text)
+			s.Words = append(s.Words, w)
+		}
+	}
+}
+
+// hasWords reports whether any segment has word timings.
+func (t *Transcript) hasWords() bool {
+	for _, s := range t.Segments {
+		if len(s.Words) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeWord lower-cases w and strips everything but letters and digits so
+// that punctuation or casing changes made by the model don't break matching.
+func normalizeWord(w string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, w)
+}
+
+// alignWindow is how far ahead alignTurns looks for a matching source word.
+const alignWindow = 25
+
+// alignTurns assigns start and end times to diarized turns by matching their words
+// against the timed words of the source transcription. Words the model changed or
+// invented inherit the position of the last matched word. Turns get the
+// provider's timed words from their first to their last match.
+func alignTurns(source []Segment, turns []Segment) []Segment {
+	words := wordTimeline(source)
+	out := make([]Segment, len(turns))
+	pos := 0
+	for i, turn := range turns {
+		out[i] = turn
+		out[i].ID = i
+		first, last := -1, -1
+		for _, f := range strings.Fields(turn.Text) {
+			n := normalizeWord(f)
+			if n == "" {
+				continue
+			}
+			for j := pos; j < len(words) && j < pos+alignWindow; j++ {
+				if words[j].norm == n {
+					if first < 0 {
+						first = j
+					}
+					last = j
+					pos = j + 1
+					break
+				}
+			}
+		}
+		switch {
+		case first >= 0:
+			out[i].Start, out[i].End = words[first].start, words[last].end
+			if len(turn.Words) == 0 {
+				for _, w := range words[first : last+1] {
+					if w.word != nil {
+						out[i].Words = append(out[i].Words, *w.word)
+					}
+				}
+			}
+		case len(words) > 0:
+			idx := min(pos, len(words)-1)
+			out[i].Start, out[i].End = words[idx].start, words[idx].start
+		}
+	}
+	return out
+}
+
+// renderText renders speaker turns in the plain-text diarized format, each
+// prefixed with its start time when a timestamp style was chosen.
+func renderText(t *Transcript, opts renderOptions) string {
+	var b strings.Builder
+	b.WriteString("=== Diarized Transcript ===\n")
+	if legend := speakerLegend(t); legend != "" {
+		fmt.Fprintf(&b, "=== Speakers: %s ===\n", legend)
+	}
+	for i, s := range t.Segments {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if opts.timed {
+			fmt.Fprintf(&b, "[%s] ", opts.timestamps.format(s.Start))
+		}
+		if s.Speaker != "" {
+			fmt.Fprintf(&b, "%s: %s\n", s.speakerLabel(), s.displayText())
+		} else {
+			fmt.Fprintf(&b, "%s\n", s.displayText())
+		}
+	}
+	return b.String()
+}
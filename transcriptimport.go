@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxImportedSpeakers is the most distinct "Name:" prefixes a subtitle file
+// can have for them to be read as speakers rather than as part of the text.
+const maxImportedSpeakers = 20
+
+// loadForeignTranscript reads a transcript made elsewhere, for -import-transcript:
+// SubRip, WebVTT, a canonical transcript, or the JSON reply of one of the
+// supported providers. It returns the transcript and the name of the format
+// it was recognized as.
+func loadForeignTranscript(key []byte, path string) (*Transcript, string, error) {
+	data, err := readStored(key, path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+	var t *Transcript
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("WEBVTT")):
+		t, format = parseCues(string(data), true), "vtt"
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		if t, format, err = parseTranscriptJSON(data); err != nil {
+			return nil, "", fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	case format == "srt" || bytes.Contains(data, []byte("-->")):
+		t, format = parseCues(string(data), false), "srt"
+	default:
+		return nil, "", fmt.Errorf("%s is not a transcript in a known format (srt, vtt or json)", path)
+	}
+	if len(t.Segments) == 0 && t.Text == "" {
+		return nil, "", fmt.Errorf("%s has no transcript text", path)
+	}
+	if t.Text == "" {
+		texts := make([]string, 0, len(t.Segments))
+		for _, s := range t.Segments {
+			if s.Kind == "" {
+				texts = append(texts, s.Text)
+			}
+		}
+		t.Text = strings.Join(texts, " ")
+	}
+	if t.Duration == 0 && len(t.Segments) > 0 {
+		t.Duration = t.Segments[len(t.Segments)-1].End
+	}
+	return t, format, nil
+}
+
+var (
+	cueTag     = regexp.MustCompile(`<[^>]*>`)
+	cueVoice   = regexp.MustCompile(`^<v(?:\.[^ >]*)?\s+([^>]+)>`)
+	cueSpeaker = regexp.MustCompile(`^([\p{L}][\p{L}\p{N} .'&-]{0,40}?)(\(\?\))?:\s+(.+)$`)
+	cueEvent   = regexp.MustCompile(`^\[[^\]]+\]$`)
+	cueLang    = regexp.MustCompile(`^\[([a-z]{2,3}(?:-[a-z0-9]{2,8})*)\] (.+)$`)
+)
+
+// parseCues reads SubRip or, with vtt set, WebVTT cues as segments. Speakers
+// come from WebVTT voice tags, or from "Name: " prefixes when every cue has
+// one, as in the srt output; the review, crosstalk and language markers of
+// the outputs are read back. Consecutive cues of one speaker are joined into a turn.
+func parseCues(data string, vtt bool) *Transcript {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	t := &Transcript{}
+	voiced := false
+	for _, block := range strings.Split(data, "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		timing := -1
+		for i, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			// The WEBVTT header, NOTE, STYLE and REGION blocks, and SubRip
+			// index lines without a cue
+			continue
+		}
+		times := strings.SplitN(lines[timing], "-->", 2)
+		start, err := parseClock(strings.ReplaceAll(strings.TrimSpace(times[0]), ",", "."))
+		if err != nil {
+			continue
+		}
+		endField := strings.Fields(times[1])
+		if len(endField) == 0 {
+			continue
+		}
+		end, err := parseClock(strings.ReplaceAll(endField[0], ",", "."))
+		if err != nil {
+			continue
+		}
+		text := strings.Join(lines[timing+1:], " ")
+		s := Segment{ID: len(t.Segments), Start: start, End: end}
+		if m := cueVoice.FindStringSubmatch(text); vtt && m != nil {
+			s.Speaker, voiced = strings.TrimSpace(m[1]), true
+		}
+		s.Text = strings.Join(strings.Fields(html.UnescapeString(cueTag.ReplaceAllString(text, ""))), " ")
+		if s.Text == "" {
+			continue
+		}
+		t.Segments = append(t.Segments, s)
+	}
+	if !voiced {
+		splitSpeakerPrefixes(t.Segments)
+	}
+	speakers := false
+	for i := range t.Segments {
+		s := &t.Segments[i]
+		if name, ok := strings.CutSuffix(s.Speaker, reviewMarker); ok {
+			s.Speaker, s.Review = strings.TrimSpace(name), true
+		}
+		if text, ok := strings.CutPrefix(s.Text, crosstalkMarker); ok {
+			s.Text, s.Crosstalk = text, true
+		}
+		if m := cueLang.FindStringSubmatch(s.Text); m != nil {
+			s.Language, s.Text = m[1], m[2]
+		}
+		if s.Speaker == "" && cueEvent.MatchString(s.Text) {
+			s.Kind = eventKind
+		}
+		speakers = speakers || s.Speaker != ""
+	}
+	if speakers {
+		t.Segments = mergeSpeakerTurns(t.Segments)
+	}
+	return t
+}
+
+// splitSpeakerPrefixes moves "Name: " prefixes into the speakers of the
+// segments, but only when every speech cue has one and there are few enough
+// names that they can't just be words followed by a colon.
+func splitSpeakerPrefixes(segments []Segment) {
+	names := map[string]bool{}
+	for _, s := range segments {
+		if cueEvent.MatchString(s.Text) {
+			continue
+		}
+		m := cueSpeaker.FindStringSubmatch(s.Text)
+		if m == nil {
+			return
+		}
+		names[m[1]] = true
+	}
+	if len(names) == 0 || len(names) > maxImportedSpeakers {
+		return
+	}
+	for i := range segments {
+		if m := cueSpeaker.FindStringSubmatch(segments[i].Text); m != nil {
+			segments[i].Speaker = strings.TrimSpace(m[1]) + m[2]
+			segments[i].Text = m[3]
+		}
+	}
+}
+
+// parseTranscriptJSON recognizes a canonical transcript or a provider reply
+// by its fields and converts it with the provider's own mapping.
+func parseTranscriptJSON(data []byte) (*Transcript, string, error) {
+	var probe struct {
+		Version  *int            `json:"version"`
+		Results  json.RawMessage `json:"results"`
+		Response json.RawMessage `json:"response"`
+		// AssemblyAI
+		Utterances    json.RawMessage `json:"utterances"`
+		AudioDuration *float64        `json:"audio_duration"`
+		Segments      []struct {
+			Speaker *string `json:"speaker"`
+		} `json:"segments"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, "", err
+	}
+	var results struct {
+		Channels json.RawMessage `json:"channels"`
+		Items    json.RawMessage `json:"items"`
+	}
+	if probe.Results != nil {
+		json.Unmarshal(probe.Results, &results)
+	}
+	switch {
+	case probe.Version != nil:
+		var t Transcript
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, "", err
+		}
+		if t.Version > transcriptVersion {
+			return nil, "", fmt.Errorf("transcript version %d is newer than supported %d", t.Version, transcriptVersion)
+		}
+		return &t, "canonical json", nil
+	case results.Channels != nil:
+		var res deepgramResponse
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, "", err
+		}
+		return res.transcript(""), "deepgram json", nil
+	case results.Items != nil:
+		var doc awsTranscript
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, "", err
+		}
+		return doc.transcript(""), "aws transcribe json", nil
+	case probe.Response != nil:
+		var op googleOperation
+		if err := json.Unmarshal(data, &op); err != nil {
+			return nil, "", err
+		}
+		if len(op.Response.Results) != 1 {
+			return nil, "", fmt.Errorf("expected the Speech-to-Text results of one file, got %d", len(op.Response.Results))
+		}
+		for uri := range op.Response.Results {
+			t, err := op.transcript(uri, "")
+			return t, "google speech-to-text json", err
+		}
+	case probe.Utterances != nil || probe.AudioDuration != nil:
+		var res assemblyTranscript
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, "", err
+		}
+		return res.transcript(""), "assemblyai json", nil
+	case len(probe.Segments) > 0 && probe.Segments[0].Speaker != nil:
+		var d diarizedResponse
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, "", err
+		}
+		return d.transcript(), "openai diarized json", nil
+	}
+	// Whisper's verbose_json has the canonical layout without a version
+	var t Transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, "", err
+	}
+	return &t, "whisper json", nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCues(t *testing.T) {
+	type cue struct {
+		Speaker, Text, Language, Kind string
+		Start, End                    float64
+		Review, Crosstalk             bool
+	}
+	tests := []struct {
+		name string
+		data string
+		vtt  bool
+		want []cue
+	}{
+		{"srt", "1\r\n00:00:01,000 --> 00:00:02,500\r\nHello there.\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nSecond <i>line</i>\r\nwraps.\r\n", false, []cue{
+			{Text: "Hello there.", Start: 1, End: 2.5},
+			{Text: "Second line wraps.", Start: 3, End: 4},
+		}},
+		{"srt speakers merged", "1\n00:00:00,000 --> 00:00:01,000\nAlice: Hi.\n\n2\n00:00:01,000 --> 00:00:02,000\nAlice: Welcome.\n\n3\n00:00:02,000 --> 00:00:03,000\nBob(?): Thanks.\n", false, []cue{
+			{Speaker: "Alice", Text: "Hi. Welcome.", Start: 0, End: 2},
+			{Speaker: "Bob", Text: "Thanks.", Start: 2, End: 3, Review: true},
+		}},
+		{"prefix on only some cues", "1\n00:00:00,000 --> 00:00:01,000\nNote: this is text.\n\n2\n00:00:01,000 --> 00:00:02,000\nplain words\n", false, []cue{
+			{Text: "Note: this is text.", Start: 0, End: 1},
+			{Text: "plain words", Start: 1, End: 2},
+		}},
+		{"vtt voices", "WEBVTT\n\nNOTE made elsewhere\n\n00:00.000 --> 00:01.000 align:start\n<v.loud Alice>[crosstalk] Hi &amp; welcome</v>\n\n00:01.000 --> 00:02.000\n<v Bob>[fr] Bonjour\n", true, []cue{
+			{Speaker: "Alice", Text: "Hi & welcome", Start: 0, End: 1, Crosstalk: true},
+			{Speaker: "Bob", Text: "Bonjour", Language: "fr", Start: 1, End: 2},
+		}},
+		{"events", "1\n00:00:00,000 --> 00:00:01,000\nHost: Hello.\n\n2\n00:00:01,000 --> 00:00:05,000\n[music]\n\n3\n00:00:05,000 --> 00:00:06,000\nHost: Back.\n", false, []cue{
+			{Speaker: "Host", Text: "Hello.", Start: 0, End: 1},
+			{Text: "[music]", Kind: eventKind, Start: 1, End: 5},
+			{Speaker: "Host", Text: "Back.", Start: 5, End: 6},
+		}},
+		{"bad timing and empty cues skipped", "1\n00:00:xx,000 --> 00:00:01,000\nlost\n\n2\n00:00:01,000 --> 00:00:02,000\n<b></b>\n\n3\n00:00:02,000 --> 00:00:03,000\nkept\n", false, []cue{
+			{Text: "kept", Start: 2, End: 3},
+		}},
+	}
+	for _, tt := range tests {
+		var got []cue
+		for _, s := range parseCues(tt.data, tt.vtt).Segments {
+			got = append(got, cue{s.Speaker, s.Text, s.Language, s.Kind, s.Start, s.End, s.Review, s.Crosstalk})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseCues = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadForeignTranscript(t *testing.T) {
+	tests := []struct {
+		file, data string
+		format     string
+		text       string
+		duration   float64
+		err        bool
+	}{
+		{"ep.srt", "1\n00:00:00,000 --> 00:00:02,000\nHello.\n", "srt", "Hello.", 2, false},
+		{"ep.txt", "\ufeff1\n00:00:00,000 --> 00:00:02,000\nNo extension.\n", "srt", "No extension.", 2, false},
+		{"ep.srt", "\ufeffWEBVTT\n\n00:00.000 --> 00:03.000\nMislabelled.\n", "vtt", "Mislabelled.", 3, false},
+		{"ep.json", `{"version": 1, "text": "Canonical.", "duration": 9, "segments": [{"start": 0, "end": 9, "text": "Canonical."}]}`, "canonical json", "Canonical.", 9, false},
+		{"ep.json", `{"version": 999, "text": "Future."}`, "", "", 0, true},
+		{"ep.srt", "1\n00:00:00,000 --> 00:00:02,000\n<i></i>\n", "", "", 0, true},
+		{"notes.txt", "just some notes", "", "", 0, true},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tr, format, err := loadForeignTranscript(nil, path)
+		if (err != nil) != tt.err {
+			t.Errorf("loadForeignTranscript(%q) error = %v, want error %v", tt.data, err, tt.err)
+			continue
+		}
+		if err == nil && (format != tt.format || tr.Text != tt.text || tr.Duration != tt.duration) {
+			t.Errorf("loadForeignTranscript(%q) = %s %q %gs, want %s %q %gs", tt.data, format, tr.Text, tr.Duration, tt.format, tt.text, tt.duration)
+		}
+	}
+}
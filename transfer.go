@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// transferAttempts is how many times an upload, download or cut that arrives
+// truncated or corrupted is tried before giving up. A transfer whose
+// connection dropped is tried up to droppedAttempts times, waiting
+// transferBackoff before the first retry and twice as long before each next.
+const (
+	transferAttempts = 3
+	droppedAttempts  = 5
+	transferBackoff  = 2 * time.Second
+)
+
+// Audio is taken for truncated when it is shorter than expected by more than
+// truncationSlack seconds plus truncationRatio of the expected length; cuts
+// without re-encoding end on a frame boundary, so a little is always lost.
+const (
+	truncationSlack = 1.0
+	truncationRatio = 0.02
+)
+
+// corruptTransfer reports a transfer whose size, checksum or duration didn't
+// match what was sent.
+type corruptTransfer struct {
+	what   string
+	detail string
+}
+
+func (e *corruptTransfer) Error() string {
+	return fmt.Sprintf("corrupted %s: %s", e.what, e.detail)
+}
+
+// droppedTransfer reports a transfer whose connection failed part way through.
+type droppedTransfer struct {
+	what string
+	err  error
+}
+
+func (e *droppedTransfer) Error() string {
+	return fmt.Sprintf("interrupted %s: %v", e.what, e.err)
+}
+
+// isDropped reports whether err is a connection that failed part way, such as
+// a reset, a broken pipe or a stalled link timing out, rather than a refusal
+// that trying again won't fix.
+func isDropped(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	for _, target := range []error{io.EOF, io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, syscall.ETIMEDOUT} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryCorrupt runs transfer, trying again while it fails with a corruptTransfer
+// error, up to transferAttempts times in all. A droppedTransfer is tried up to
+// droppedAttempts times, with a growing wait in between for the link to
+// recover.
+func (p *Pipeline) retryCorrupt(ctx context.Context, transfer func() error) error {
+	wait := transferBackoff
+	for attempt := 1; ; attempt++ {
+		err := transfer()
+		var (
+			corrupt *corruptTransfer
+			dropped *droppedTransfer
+		)
+		switch {
+		case ctx.Err() != nil:
+			return err
+		case errors.As(err, &corrupt) && attempt < transferAttempts:
+			p.console.warnf("%v; retrying\n", err)
+		case errors.As(err, &dropped) && attempt < droppedAttempts:
+			p.console.warnf("%v; retrying in %s\n", err, wait)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+			wait *= 2
+		default:
+			return err
+		}
+		p.stats.retries.Add(1)
+	}
+}
+
+// truncated reports whether audio of got seconds is missing part of the
+// expected want seconds.
+func truncated(got, want float64) bool {
+	return want > 0 && got < want-truncationSlack-truncationRatio*want
+}
+
+// fileMD5 returns the MD5 digest of the file at path, the checksum storage
+// services report for uploads.
+func fileMD5(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for hashing: %v", err)
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to hash file: %v", err)
+	}
+	return h.Sum(nil), nil
+}
+
+// isRemoteAudio reports whether -audio names an http or https URL to download
+// rather than a local file.
+func isRemoteAudio(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// downloadAudio fetches the audio at rawURL into the cache directory, keeping
+// its file name. Each download is checked against the Content-Length and any
+// MD5 the server reports, in Content-MD5 or Google's x-goog-hash, and tried
+// again when it doesn't match. The caller removes the file with the returned
+// cleanup function.
+func (p *Pipeline) downloadAudio(ctx context.Context, rawURL string) (string, func(), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid audio URL: %v", err)
+	}
+	name := path.Base(u.Path)
+	if name == "" || name == "/" || name == "." {
+		name = "audio"
+	}
+	dir, err := p.makeTempDir("download")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	dest := filepath.Join(dir, name)
+	err = p.retryCorrupt(ctx, func() error {
+		return p.downloadOnce(ctx, rawURL, dest)
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	p.console.progressf("Downloaded %s\n", name)
+	return dest, cleanup, nil
+}
+
+// downloadOnce makes one attempt at downloading rawURL to dest.
+func (p *Pipeline) downloadOnce(ctx context.Context, rawURL, dest string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if isDropped(err) {
+			return &droppedTransfer{"download", err}
+		}
+		return fmt.Errorf("failed to download audio: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("non-200 response downloading audio: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %v", err)
+	}
+	h := md5.New()
+	n, copyErr := io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	switch {
+	case resp.ContentLength >= 0 && n != resp.ContentLength:
+		return &corruptTransfer{"download", fmt.Sprintf("received %d of %d bytes", n, resp.ContentLength)}
+	case copyErr != nil:
+		// A connection dropped mid-body without a length to check against
+		return &droppedTransfer{"download", copyErr}
+	}
+	if want := reportedMD5(resp.Header); want != nil && !bytes.Equal(want, h.Sum(nil)) {
+		return &corruptTransfer{"download", "MD5 checksum mismatch"}
+	}
+	return nil
+}
+
+// reportedMD5 returns the MD5 of the body given in the response headers, if
+// any.
+func reportedMD5(h http.Header) []byte {
+	if sum, err := base64.StdEncoding.DecodeString(h.Get("Content-MD5")); err == nil && len(sum) == md5.Size {
+		return sum
+	}
+	for _, v := range h.Values("X-Goog-Hash") {
+		for _, part := range strings.Split(v, ",") {
+			if b64, ok := strings.CutPrefix(strings.TrimSpace(part), "md5="); ok {
+				if sum, err := base64.StdEncoding.DecodeString(b64); err == nil && len(sum) == md5.Size {
+					return sum
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkCut verifies that the cut of path from start to end seconds (0 meaning
+// the end of the file) at out isn't truncated, when ffprobe can measure both.
+func checkCut(path, out string, start, end float64) error {
+	got, err := probeDuration(out)
+	if err != nil {
+		return nil
+	}
+	total, err := probeDuration(path)
+	if err != nil {
+		return nil
+	}
+	if end <= 0 || end > total {
+		end = total
+	}
+	if truncated(got, end-start) {
+		return &corruptTransfer{"audio cut", fmt.Sprintf("%.1f of %.1f seconds", got, end-start)}
+	}
+	return nil
+}
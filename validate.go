@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// schemaFiles holds the JSON schema of every transcript version, named
+// transcript.vN.json. A change to the transcript layout that older readers
+// would reject bumps transcriptVersion and adds a schema for the new version.
+//
+//go:embed schema
+var schemaFiles embed.FS
+
+// maxValidationErrors is how many problems validate lists per file.
+const maxValidationErrors = 20
+
+// transcriptSchema returns the JSON schema of the given transcript version.
+func transcriptSchema(version int) ([]byte, error) {
+	data, err := schemaFiles.ReadFile(fmt.Sprintf("schema/transcript.v%d.json", version))
+	if err != nil {
+		return nil, fmt.Errorf("no schema for transcript version %d (supported: 1 to %d)", version, transcriptVersion)
+	}
+	return data, nil
+}
+
+// runValidate implements the validate command.
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	printSchema := flags.Int("print-schema", 0, "Print the JSON schema of this transcript version and exit")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: podcast-transcription validate file.json... | -print-schema version")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *printSchema != 0 {
+		data, err := transcriptSchema(*printSchema)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no files to validate")
+	}
+	key, err := loadEncryptionKey("")
+	if err != nil {
+		return err
+	}
+	invalid := 0
+	for _, path := range flags.Args() {
+		version, problems, err := validateTranscriptFile(key, path)
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: valid transcript version %d\n", path, version)
+			continue
+		}
+		invalid++
+		fmt.Printf("%s: invalid\n", path)
+		for i, problem := range problems {
+			if i == maxValidationErrors {
+				fmt.Printf("  ... and %d more\n", len(problems)-i)
+				break
+			}
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d file(s) are not valid transcripts", invalid, flags.NArg())
+	}
+	return nil
+}
+
+// validateTranscriptFile checks the file at path against the schema of the
+// transcript version it declares, then for timing the schema can't express.
+// It returns the version and the problems found; err is for files that can't
+// be read at all.
+func validateTranscriptFile(key []byte, path string) (int, []string, error) {
+	data, err := readStored(key, path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return 0, []string{fmt.Sprintf("not JSON: %v", err)}, nil
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return 0, []string{"$: expected an object"}, nil
+	}
+	n, ok := obj["version"].(json.Number)
+	version, err := n.Int64()
+	if !ok || err != nil {
+		return 0, []string{"$.version: missing or not an integer"}, nil
+	}
+	raw, err := transcriptSchema(int(version))
+	if err != nil {
+		return int(version), []string{"$.version: " + err.Error()}, nil
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return 0, nil, fmt.Errorf("invalid embedded schema: %v", err)
+	}
+	v := &schemaValidator{root: schema}
+	v.check(schema, doc, "$")
+	if len(v.problems) > 0 {
+		return int(version), v.problems, nil
+	}
+
+	var t Transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return int(version), []string{err.Error()}, nil
+	}
+	var problems []string
+	for i, s := range t.Segments {
+		if s.End < s.Start {
+			problems = append(problems, fmt.Sprintf("$.segments[%d]: ends at %.3f, before its start at %.3f", i, s.End, s.Start))
+		}
+		for j, w := range s.Words {
+			if w.End < w.Start {
+				problems = append(problems, fmt.Sprintf("$.segments[%d].words[%d]: ends at %.3f, before its start at %.3f", i, j, w.End, w.Start))
+			}
+		}
+	}
+	return int(version), problems, nil
+}
+
+// schemaValidator checks JSON decoded with UseNumber against the subset of
+// JSON Schema the transcript schemas use: $ref to $defs, type, const, enum,
+// minimum, maximum, required, properties, additionalProperties and items.
+type schemaValidator struct {
+	root     map[string]any
+	problems []string
+}
+
+func (v *schemaValidator) failf(path, format string, args ...any) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) check(schema map[string]any, value any, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		name, found := strings.CutPrefix(ref, "#/$defs/")
+		defs, _ := v.root["$defs"].(map[string]any)
+		def, ok := defs[name].(map[string]any)
+		if !found || !ok {
+			v.failf(path, "schema has an unresolvable $ref %q", ref)
+			return
+		}
+		schema = def
+	}
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		v.failf(path, "expected %s, got %s", describeTypes(types), jsonType(value))
+		return
+	}
+	if want, ok := schema["const"]; ok && !jsonEqual(want, value) {
+		v.failf(path, "must be %v", want)
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, want := range enum {
+			found = found || jsonEqual(want, value)
+		}
+		if !found {
+			v.failf(path, "must be one of %v", enum)
+		}
+	}
+	if n, ok := value.(json.Number); ok {
+		f, _ := n.Float64()
+		if lo, ok := schema["minimum"].(float64); ok && f < lo {
+			v.failf(path, "%v is below the minimum %v", n, lo)
+		}
+		if hi, ok := schema["maximum"].(float64); ok && f > hi {
+			v.failf(path, "%v is above the maximum %v", n, hi)
+		}
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				v.failf(path, "missing required property %q", name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := properties[name].(map[string]any); ok {
+				v.check(sub, value[name], path+"."+name)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					v.failf(path, "unknown property %q", name)
+				}
+			case map[string]any:
+				v.check(extra, value[name], path+"."+name)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				v.check(items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+// matchesType reports whether value is of the schema type, or one of the
+// types when given a list.
+func matchesType(types, value any) bool {
+	list, ok := types.([]any)
+	if !ok {
+		list = []any{types}
+	}
+	got := jsonType(value)
+	for _, t := range list {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func describeTypes(types any) string {
+	list, ok := types.([]any)
+	if !ok {
+		return fmt.Sprint(types)
+	}
+	names := make([]string, len(list))
+	for i, t := range list {
+		names[i] = fmt.Sprint(t)
+	}
+	return strings.Join(names, " or ")
+}
+
+// jsonType names the JSON Schema type of a value decoded with UseNumber.
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := value.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual compares a schema value with a document value, numbers by value.
+func jsonEqual(want, got any) bool {
+	if n, ok := got.(json.Number); ok {
+		f, err := n.Float64()
+		return err == nil && want == f
+	}
+	return reflect.DeepEqual(want, got)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// verbatimWhisperPrompt shows Whisper a transcript that keeps the fillers and
+// false starts it leaves out by default.
+const verbatimWhisperPrompt = "Umm, let me think like, hmm... Okay, here's what I'm, like, thinking."
+
+// verbatimStatement is the certification of a -verbatim run's manifest.
+const verbatimStatement = "The text of the transcript is the transcription's, word for word: no cleanup, normalization, glossary substitution or anonymization was applied, and every word was checked to be in the transcription, in order, with none dropped or added."
+
+// VerbatimCertificate records in the manifest of a -verbatim run that the
+// transcript's text wasn't transformed, and what was checked.
+type VerbatimCertificate struct {
+	Statement string `json:"statement"`
+	// Transformations lists the text transformations applied, which is none.
+	Transformations []string `json:"transformations"`
+	// Words is the number of words checked against the transcription.
+	Words int `json:"words"`
+	// TextSHA256 is the hash of the transcript's speech, turn by turn, for
+	// telling later whether it was altered.
+	TextSHA256 string `json:"text_sha256"`
+}
+
+// restoreSourceWords gives the turns the source's own words, with its
+// spelling, casing and punctuation. The turns must hold the source's words
+// and no others, in order, as checked by measureDrift with no tolerance; each
+// takes as many words of the source as it has, and the punctuation after them.
+func restoreSourceWords(source string, turns []Segment) {
+	fields := strings.Fields(source)
+	i := 0
+	for t := range turns {
+		n := len(normalizedWords(turns[t].Text))
+		var words []string
+		for i < len(fields) && (n > 0 || normalizeWord(fields[i]) == "") {
+			if normalizeWord(fields[i]) != "" {
+				n--
+			}
+			words = append(words, fields[i])
+			i++
+		}
+		if t == len(turns)-1 {
+			words = append(words, fields[i:]...)
+		}
+		turns[t].Text = strings.Join(words, " ")
+	}
+}
+
+// certifyVerbatim checks that the speech of the diarized transcript is the
+// transcription's, word for word, and returns the certificate of it.
+func certifyVerbatim(transcription, diarized *Transcript) (*VerbatimCertificate, error) {
+	text := paragraphText(diarized.Segments)
+	drift := measureDrift(paragraphText(transcription.Segments), []Segment{{Text: text}}, 0)
+	if !drift.withinTolerance(0) {
+		return nil, fmt.Errorf("the transcript's words aren't the transcription's (%s)", drift)
+	}
+	var turns []string
+	for _, s := range diarized.Segments {
+		if s.Kind != eventKind {
+			turns = append(turns, s.Speaker+": "+s.Text)
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(turns, "\n")))
+	return &VerbatimCertificate{
+		Statement:       verbatimStatement,
+		Transformations: []string{},
+		Words:           drift.SourceWords,
+		TextSHA256:      hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// numberLines numbers the lines of text, as court transcripts are, with the
+// numbers right-aligned.
+func numberLines(text string) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	width := len(fmt.Sprint(len(lines)))
+	var b strings.Builder
+	for i, line := range lines {
+		if line == "" {
+			fmt.Fprintf(&b, "%*d\n", width, i+1)
+		} else {
+			fmt.Fprintf(&b, "%*d  %s\n", width, i+1, line)
+		}
+	}
+	return b.String()
+}
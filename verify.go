@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// normalizedWords splits text into words normalised with normalizeWord, dropping
+// tokens that are pure punctuation.
+func normalizedWords(text string) []string {
+	var words []string
+	for _, f := range strings.Fields(text) {
+		if n := normalizeWord(f); n != "" {
+			words = append(words, n)
+		}
+	}
+	return words
+}
+
+// editDistance returns the number of word insertions and deletions needed to turn
+// a into b, using Myers' O((N+M)D) algorithm. It gives up once the distance exceeds
+// maxD and reports ok=false, so large divergences are detected cheaply.
+func editDistance(a, b []string, maxD int) (d int, ok bool) {
+	n, m := len(a), len(b)
+	maxD = min(maxD, n+m)
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return d, true
+			}
+		}
+	}
+	return maxD, false
+}
+
+// wordDrift describes how far diarized turns diverge from the source transcript.
+type wordDrift struct {
+	SourceWords int
+	OutputWords int
+	Edits       int
+	Exceeded    bool
+}
+
+// Ratio is the number of word edits relative to the source length.
+func (w wordDrift) Ratio() float64 {
+	if w.SourceWords == 0 {
+		return 0
+	}
+	return float64(w.Edits) / float64(w.SourceWords)
+}
+
+func (w wordDrift) String() string {
+	cmp := ""
+	if w.Exceeded {
+		cmp = ">"
+	}
+	return fmt.Sprintf("%s%.1f%% of %d source words changed (%d words returned)", cmp, 100*w.Ratio(), w.SourceWords, w.OutputWords)
+}
+
+// measureDrift compares the words of the diarized turns against source. Dropped,
+// reordered and invented words all count as edits; casing and punctuation don't.
+func measureDrift(source string, turns []Segment, tolerance float64) wordDrift {
+	src := normalizedWords(source)
+	var out []string
+	for _, t := range turns {
+		out = append(out, normalizedWords(t.Text)...)
+	}
+	limit := int(math.Ceil(tolerance*float64(len(src)))) + 1
+	d, ok := editDistance(src, out, limit)
+	return wordDrift{SourceWords: len(src), OutputWords: len(out), Edits: d, Exceeded: !ok}
+}
+
+// withinTolerance reports whether the drift is acceptable under tolerance.
+func (w wordDrift) withinTolerance(tolerance float64) bool {
+	return !w.Exceeded && w.Ratio() <= tolerance
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// The software version, commit and build date, set at build time with
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Binaries built without them fall back to what the Go toolchain recorded:
+// the module version under go install, and the commit of the checkout.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// currentBuild returns the build info, read once.
+var currentBuild = sync.OnceValue(func() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = strings.TrimPrefix(bi.Main.Version, "v")
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+})
+
+// shortCommit is the first 12 characters of the commit, marked when the
+// checkout had changes.
+func (b buildInfo) shortCommit() string {
+	c := b.Commit
+	if len(c) > 12 {
+		c = c[:12]
+	}
+	if c != "" && b.Modified {
+		c += "-dirty"
+	}
+	return c
+}
+
+// String writes the build info on one line, as the version command prints it.
+func (b buildInfo) String() string {
+	var details []string
+	if c := b.shortCommit(); c != "" {
+		details = append(details, "commit "+c)
+	}
+	if b.BuildDate != "" {
+		details = append(details, "built "+b.BuildDate)
+	}
+	details = append(details, b.GoVersion, b.Platform)
+	return fmt.Sprintf("podcast-transcription %s (%s)", b.Version, strings.Join(details, ", "))
+}
+
+// userAgent is the User-Agent of the tool's API requests, so providers can
+// tell which build sent one. -user-agent adds to it.
+func userAgent() string {
+	b := currentBuild()
+	ua := "podcast-transcription/" + b.Version
+	if c := b.shortCommit(); c != "" {
+		ua += " (" + c + ")"
+	}
+	return ua + " Go/" + strings.TrimPrefix(b.GoVersion, "go")
+}
+
+// runVersion implements the version command.
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the build info as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	b := currentBuild()
+	if !*asJSON {
+		fmt.Println(b)
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
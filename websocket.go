@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes (RFC 6455).
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsGUID is appended to the handshake key to compute the accept header.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsConn is a minimal WebSocket connection: enough of RFC 6455 to exchange
+// text messages with an API, answering pings and reassembling fragments.
+// Clients mask the frames they send, servers don't.
+type wsConn struct {
+	rw      io.ReadWriteCloser
+	br      *bufio.Reader
+	client  bool
+	maxSize int64
+
+	wmu sync.Mutex
+}
+
+// dialWebSocket opens a WebSocket with the handshake request req, whose
+// headers such as Authorization are kept, through client. ws and wss URLs are
+// accepted. Messages larger than maxSize bytes are refused.
+func dialWebSocket(ctx context.Context, client *http.Client, req *http.Request, maxSize int64) (*wsConn, error) {
+	switch req.URL.Scheme {
+	case "ws":
+		req.URL.Scheme = "http"
+	case "wss":
+		req.URL.Scheme = "https"
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req = req.WithContext(ctx)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("websocket handshake failed: %d, body: %s", resp.StatusCode, string(body))
+	}
+	rw, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("websocket handshake failed: connection can't be upgraded")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		rw.Close()
+		return nil, fmt.Errorf("websocket handshake failed: bad Sec-WebSocket-Accept")
+	}
+	return &wsConn{rw: rw, br: bufio.NewReader(rw), client: true, maxSize: maxSize}, nil
+}
+
+// wsAccept returns the Sec-WebSocket-Accept value for a handshake key.
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// writeText sends data as one text message.
+func (c *wsConn) writeText(data []byte) error {
+	return c.writeFrame(wsText, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if c.client {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		header = append(header, mask...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.rw.Write(append(header, payload...))
+	return err
+}
+
+// readMessage returns the next text or binary message. Pings are answered on
+// the way; a close frame from the peer ends the connection with io.EOF.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		}
+		message = append(message, payload...)
+		if int64(len(message)) > c.maxSize {
+			return nil, fmt.Errorf("websocket message exceeds %d bytes", c.maxSize)
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0F
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > uint64(c.maxSize) {
+		err = fmt.Errorf("websocket frame exceeds %d bytes", c.maxSize)
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	if opcode != wsContinuation && opcode != wsText && opcode != wsBinary && opcode < wsClose {
+		err = fmt.Errorf("unknown websocket opcode %d", opcode)
+	}
+	return
+}
+
+// close sends a normal closure and closes the connection.
+func (c *wsConn) close() error {
+	c.writeFrame(wsClose, []byte{0x03, 0xE8})
+	return c.rw.Close()
+}
+
+// isClosedConn reports whether err only says the connection went away.
+func isClosedConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || strings.Contains(err.Error(), "use of closed network connection")
+}
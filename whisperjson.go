@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"strings"
+)
+
+// whisperWindow is the length in seconds of the windows Whisper decodes, and
+// whisperFrames the mel frames per second its seek offsets count.
+const (
+	whisperWindow = 30
+	whisperFrames = 100
+)
+
+// whisperResult is the JSON the openai-whisper command line writes, which
+// most Whisper post-processing tools read, with a speaker on each segment
+// and word as WhisperX adds them.
+type whisperResult struct {
+	Text     string           `json:"text"`
+	Segments []whisperSegment `json:"segments"`
+	Language string           `json:"language,omitempty"`
+}
+
+type whisperSegment struct {
+	ID               int           `json:"id"`
+	Seek             int           `json:"seek"`
+	Start            float64       `json:"start"`
+	End              float64       `json:"end"`
+	Text             string        `json:"text"`
+	Tokens           []int         `json:"tokens"`
+	Temperature      float64       `json:"temperature"`
+	AvgLogprob       float64       `json:"avg_logprob"`
+	CompressionRatio float64       `json:"compression_ratio"`
+	NoSpeechProb     float64       `json:"no_speech_prob"`
+	Speaker          string        `json:"speaker,omitempty"`
+	Words            []whisperWord `json:"words,omitempty"`
+}
+
+type whisperWord struct {
+	Word        string  `json:"word"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float64 `json:"probability,omitempty"`
+	Speaker     string  `json:"speaker,omitempty"`
+}
+
+// renderWhisperJSON writes the transcript in the layout of Whisper's JSON
+// output. Token IDs aren't known after diarization, so tokens are empty;
+// seek is the start of the 30-second window a segment falls in, and text has
+// the leading space Whisper's does. Events are left out.
+func renderWhisperJSON(t *Transcript, _ renderOptions) ([]byte, error) {
+	out := whisperResult{Segments: []whisperSegment{}, Language: t.Language}
+	var text strings.Builder
+	for _, s := range t.Segments {
+		if s.Kind == eventKind {
+			continue
+		}
+		seg := whisperSegment{
+			ID:               len(out.Segments),
+			Seek:             int(s.Start/whisperWindow) * whisperWindow * whisperFrames,
+			Start:            s.Start,
+			End:              s.End,
+			Text:             " " + strings.TrimSpace(s.Text),
+			Tokens:           []int{},
+			AvgLogprob:       s.AvgLogprob,
+			CompressionRatio: compressionRatio(s.Text),
+			NoSpeechProb:     s.NoSpeechProb,
+			Speaker:          s.Speaker,
+		}
+		for _, w := range s.Words {
+			seg.Words = append(seg.Words, whisperWord{
+				Word:        " " + strings.TrimSpace(w.Text),
+				Start:       w.Start,
+				End:         w.End,
+				Probability: w.Confidence,
+				Speaker:     s.Speaker,
+			})
+		}
+		out.Segments = append(out.Segments, seg)
+		text.WriteString(seg.Text)
+	}
+	out.Text = text.String()
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// compressionRatio is the text's length over its zlib-compressed length, the
+// measure Whisper uses to spot repetitive hallucinations.
+func compressionRatio(text string) float64 {
+	if text == "" {
+		return 0
+	}
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(text))
+	w.Close()
+	return float64(len(text)) / float64(b.Len())
+}
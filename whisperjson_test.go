@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderWhisperJSON(t *testing.T) {
+	tr := &Transcript{Language: "en", Segments: []Segment{
+		{Speaker: "Host", Start: 0, End: 4, Text: "Welcome.", AvgLogprob: -0.2, Words: []Word{{Text: "Welcome.", Start: 0, End: 1, Confidence: 0.9}}},
+		{Kind: eventKind, Start: 4, End: 40, Text: "[music]"},
+		{Speaker: "Guest", Start: 65, End: 70, Text: " Thanks for having me. "},
+	}}
+	data, err := renderWhisperJSON(tr, renderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got whisperResult
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != " Welcome. Thanks for having me." || got.Language != "en" {
+		t.Errorf("text %q in %q, want the turns with Whisper's leading spaces in en", got.Text, got.Language)
+	}
+	tests := []struct {
+		id, seek   int
+		start, end float64
+		text       string
+		speaker    string
+		words      []whisperWord
+	}{
+		{0, 0, 0, 4, " Welcome.", "Host", []whisperWord{{Word: " Welcome.", Start: 0, End: 1, Probability: 0.9, Speaker: "Host"}}},
+		{1, 6000, 65, 70, " Thanks for having me.", "Guest", nil},
+	}
+	if len(got.Segments) != len(tests) {
+		t.Fatalf("got %d segments, want %d without the event", len(got.Segments), len(tests))
+	}
+	for i, tt := range tests {
+		s := got.Segments[i]
+		if s.ID != tt.id || s.Seek != tt.seek || s.Start != tt.start || s.End != tt.end || s.Text != tt.text || s.Speaker != tt.speaker || !reflect.DeepEqual(s.Words, tt.words) {
+			t.Errorf("segment %d = %+v, want %+v", i, s, tt)
+		}
+		if s.Tokens == nil || s.CompressionRatio <= 0 {
+			t.Errorf("segment %d has tokens %v and compression ratio %g, want [] and a ratio", i, s.Tokens, s.CompressionRatio)
+		}
+	}
+	if !strings.Contains(string(data), `"tokens": []`) {
+		t.Errorf("tokens should be written as an empty list:\n%s", data)
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	repeated := compressionRatio(strings.Repeat("thank you ", 50))
+	varied := compressionRatio("The quick brown fox jumps over the lazy dog while the band plays.")
+	if compressionRatio("") != 0 {
+		t.Errorf("compressionRatio of nothing = %g, want 0", compressionRatio(""))
+	}
+	if repeated <= 2.4 || varied >= 2.4 {
+		t.Errorf("repeated text ratio %.2f, varied %.2f; want them either side of Whisper's 2.4 threshold", repeated, varied)
+	}
+}
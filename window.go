@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily time range jobs may start in, such as 01:00-07:00.
+// A window whose end is before its start runs past midnight.
+type timeWindow struct {
+	start, end time.Duration
+	spec       string
+}
+
+// parseWindow parses a -window value of the form HH:MM-HH:MM, in local time.
+func parseWindow(s string) (timeWindow, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("invalid window %q (use HH:MM-HH:MM, e.g. 01:00-07:00)", s)
+	}
+	var w timeWindow
+	for _, part := range []struct {
+		text string
+		into *time.Duration
+	}{{from, &w.start}, {to, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.text))
+		if err != nil {
+			return timeWindow{}, fmt.Errorf("invalid window %q (use HH:MM-HH:MM, e.g. 01:00-07:00)", s)
+		}
+		*part.into = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if w.start == w.end {
+		return timeWindow{}, fmt.Errorf("invalid window %q: it starts and ends at the same time", s)
+	}
+	w.spec = strings.TrimSpace(s)
+	return w, nil
+}
+
+// sinceMidnight returns how far into its day t is.
+func sinceMidnight(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+}
+
+// contains reports whether t is inside the window.
+func (w timeWindow) contains(t time.Time) bool {
+	at := sinceMidnight(t)
+	if w.start < w.end {
+		return at >= w.start && at < w.end
+	}
+	return at >= w.start || at < w.end
+}
+
+// opens returns the next time the window opens at or after t.
+func (w timeWindow) opens(t time.Time) time.Time {
+	y, m, d := t.Date()
+	open := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.start)
+	if open.Before(t) {
+		open = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(w.start)
+	}
+	return open
+}
+
+// waitForWindow blocks until the window is open, so that a job started
+// outside it is queued rather than run.
+func (p *Pipeline) waitForWindow(ctx context.Context, w timeWindow) error {
+	now := time.Now()
+	if w.contains(now) {
+		return nil
+	}
+	open := w.opens(now)
+	p.console.progressf("Outside the %s window; waiting until %s (in %s)\n", w.spec, open.Format("15:04"), open.Sub(now).Round(time.Minute))
+	timer := time.NewTimer(time.Until(open))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
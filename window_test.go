@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end time.Duration
+		err        bool
+	}{
+		{"01:00-07:00", time.Hour, 7 * time.Hour, false},
+		{" 22:30 - 06:15 ", 22*time.Hour + 30*time.Minute, 6*time.Hour + 15*time.Minute, false},
+		{"9:05-17:00", 9*time.Hour + 5*time.Minute, 17 * time.Hour, false},
+		{"01:00", 0, 0, true},
+		{"01:00-01:00", 0, 0, true},
+		{"25:00-07:00", 0, 0, true},
+		{"night", 0, 0, true},
+	}
+	for _, tt := range tests {
+		w, err := parseWindow(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseWindow(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && (w.start != tt.start || w.end != tt.end) {
+			t.Errorf("parseWindow(%q) = %v-%v, want %v-%v", tt.in, w.start, w.end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestWindowContainsAndOpens(t *testing.T) {
+	at := func(day, hour, min int) time.Time { return time.Date(2026, 3, day, hour, min, 0, 0, time.UTC) }
+	tests := []struct {
+		window   string
+		now      time.Time
+		contains bool
+		opens    time.Time
+	}{
+		{"01:00-07:00", at(10, 3, 0), true, at(11, 1, 0)},
+		{"01:00-07:00", at(10, 0, 30), false, at(10, 1, 0)},
+		{"01:00-07:00", at(10, 7, 0), false, at(11, 1, 0)},
+		{"01:00-07:00", at(10, 1, 0), true, at(10, 1, 0)},
+		{"22:00-06:00", at(10, 23, 0), true, at(11, 22, 0)},
+		{"22:00-06:00", at(10, 5, 59), true, at(10, 22, 0)},
+		{"22:00-06:00", at(10, 12, 0), false, at(10, 22, 0)},
+	}
+	for _, tt := range tests {
+		w, err := parseWindow(tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.contains(tt.now); got != tt.contains {
+			t.Errorf("%s contains %s = %v, want %v", tt.window, tt.now.Format("15:04"), got, tt.contains)
+		}
+		if got := w.opens(tt.now); !got.Equal(tt.opens) {
+			t.Errorf("%s opens after %s at %s, want %s", tt.window, tt.now.Format("Jan 2 15:04"), got.Format("Jan 2 15:04"), tt.opens.Format("Jan 2 15:04"))
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Endpoints of the YouTube Data API and Google's OAuth token exchange.
+const (
+	youtubeCaptionsURL       = "https://www.googleapis.com/youtube/v3/captions"
+	youtubeCaptionsUploadURL = "https://www.googleapis.com/upload/youtube/v3/captions"
+	googleTokenURL           = "https://oauth2.googleapis.com/token"
+)
+
+// youtubeTrackName names the caption tracks -youtube-video-id uploads, so a
+// later run replaces its own tracks and leaves the others alone.
+const youtubeTrackName = "Transcript"
+
+// Credentials of the YouTube upload: an access token with the
+// youtube.force-ssl scope, or an OAuth client and refresh token to get one.
+const (
+	youtubeTokenEnv        = "YOUTUBE_ACCESS_TOKEN"
+	youtubeClientIDEnv     = "YOUTUBE_CLIENT_ID"
+	youtubeClientSecretEnv = "YOUTUBE_CLIENT_SECRET"
+	youtubeRefreshTokenEnv = "YOUTUBE_REFRESH_TOKEN"
+)
+
+// captionTrack is a caption file to upload and its language.
+type captionTrack struct {
+	path     string
+	language string
+}
+
+// captionFormat returns the format of formats uploaded to YouTube: srt, or
+// else vtt, or "" if there's neither.
+func captionFormat(formats []string) string {
+	format := ""
+	for _, f := range formats {
+		if f == "srt" || (f == "vtt" && format == "") {
+			format = f
+		}
+	}
+	return format
+}
+
+// youtubeCaptionTracks returns the caption files of the run to upload: those
+// of the transcript and its translations in the captionFormat.
+func (p *Pipeline) youtubeCaptionTracks(t *Transcript, formats []string, passes []translationPass) ([]captionTrack, error) {
+	format := captionFormat(formats)
+	lang := strings.ToLower(t.Language)
+	if !languageCode.MatchString(lang) {
+		// Whisper names the language rather than giving its code
+		lang = strings.ToLower(p.config.Language)
+	}
+	if lang == "" {
+		return nil, fmt.Errorf("the transcript's language code is unknown; give it with -language")
+	}
+	tracks := []captionTrack{{p.outputFile(format, ""), lang}}
+	seen := map[string]bool{}
+	for _, pass := range passes {
+		if !seen[pass.to] && t.hasTranslation(pass.to) {
+			seen[pass.to] = true
+			tracks = append(tracks, captionTrack{p.outputFile(format, pass.to), pass.to})
+		}
+	}
+	return tracks, nil
+}
+
+// youtubeAccessToken returns the access token from YOUTUBE_ACCESS_TOKEN or,
+// failing that, exchanges YOUTUBE_REFRESH_TOKEN for one.
+func (p *Pipeline) youtubeAccessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv(youtubeTokenEnv); token != "" {
+		return token, nil
+	}
+	id, secret, refresh := os.Getenv(youtubeClientIDEnv), os.Getenv(youtubeClientSecretEnv), os.Getenv(youtubeRefreshTokenEnv)
+	if id == "" || secret == "" || refresh == "" {
+		return "", fmt.Errorf("please set %s, or %s, %s and %s", youtubeTokenEnv, youtubeClientIDEnv, youtubeClientSecretEnv, youtubeRefreshTokenEnv)
+	}
+	form := url.Values{"client_id": {id}, "client_secret": {secret}, "refresh_token": {refresh}, "grant_type": {"refresh_token"}}
+	req, err := http.NewRequestWithContext(ctx, "POST", googleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var res struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := p.youtubeDo(req, &res); err != nil {
+		return "", fmt.Errorf("failed to refresh the YouTube access token: %v", err)
+	}
+	registerSecret(res.AccessToken)
+	return res.AccessToken, nil
+}
+
+// uploadYouTubeCaptions uploads the caption tracks to the video, replacing
+// the tracks of earlier runs in the same languages, and returns how many
+// were uploaded.
+func (p *Pipeline) uploadYouTubeCaptions(ctx context.Context, videoID string, tracks []captionTrack) (int, error) {
+	token, err := p.youtubeAccessToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", youtubeCaptionsURL+"?part=snippet&videoId="+url.QueryEscape(videoID), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var existing struct {
+		Items []struct {
+			ID      string `json:"id"`
+			Snippet struct {
+				Language  string `json:"language"`
+				Name      string `json:"name"`
+				TrackKind string `json:"trackKind"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := p.youtubeDo(req, &existing); err != nil {
+		return 0, fmt.Errorf("failed to list the video's captions: %v", err)
+	}
+
+	for i, track := range tracks {
+		data, err := readStored(p.config.EncryptionKey, track.path)
+		if err != nil {
+			return i, err
+		}
+		caption := map[string]any{"snippet": map[string]any{"videoId": videoID, "language": track.language, "name": youtubeTrackName}}
+		method := "POST"
+		for _, item := range existing.Items {
+			if item.Snippet.Name == youtubeTrackName && item.Snippet.TrackKind != "asr" && sameLanguage(item.Snippet.Language, track.language) {
+				method, caption["id"] = "PUT", item.ID
+				break
+			}
+		}
+		if err := p.putYouTubeCaption(ctx, token, method, caption, data); err != nil {
+			return i, fmt.Errorf("failed to upload %s: %v", filepath.Base(track.path), err)
+		}
+	}
+	return len(tracks), nil
+}
+
+// putYouTubeCaption creates (POST) or replaces (PUT) a caption track with a
+// multipart upload of its metadata and file.
+func (p *Pipeline) putYouTubeCaption(ctx context.Context, token, method string, caption map[string]any, data []byte) error {
+	metadata, err := json.Marshal(caption)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	part.Write(metadata)
+	part, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}})
+	part.Write(data)
+	mw.Close()
+
+	req, err := http.NewRequestWithContext(ctx, method, youtubeCaptionsUploadURL+"?uploadType=multipart&part=snippet", &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+	var res struct {
+		ID string `json:"id"`
+	}
+	return p.youtubeDo(req, &res)
+}
+
+func (p *Pipeline) youtubeDo(req *http.Request, out any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxResponseBodySize))
+		return fmt.Errorf("non-200 response from YouTube: %d, body: %s%s", resp.StatusCode, string(body), requestRef(resp))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, p.config.MaxResponseBodySize)).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode YouTube response: %v", err)
+	}
+	return nil
+}